@@ -114,6 +114,30 @@ type ExternalAccountKey struct {
 	CreatedAt     time.Time `json:"createdAt"`
 	BoundAt       time.Time `json:"boundAt,omitempty"`
 	Policy        *Policy   `json:"policy,omitempty"`
+	// NotAfter is the time after which the key cannot be used to bind new
+	// accounts, and the accounts bound to it cannot be used anymore. A zero
+	// value means that the key does not expire.
+	NotAfter time.Time `json:"notAfter,omitempty"`
+	// MultiUse allows the key to be bound to more than one account. By
+	// default a key can only be used once.
+	MultiUse bool `json:"multiUse,omitempty"`
+	// Disabled disables the key and the accounts bound to it. There's no
+	// per-account flag, disabling a multi-use key disables all the accounts
+	// bound to it.
+	Disabled bool `json:"disabled,omitempty"`
+	// AccountIDs contains the IDs of all the accounts bound to the key, the
+	// last one is AccountID. Single-use keys are bound to one account only.
+	AccountIDs []string `json:"-"`
+}
+
+// ExternalAccountKeyOptions are the lifecycle properties set on an External
+// Account Binding key when it's created.
+type ExternalAccountKeyOptions struct {
+	// NotAfter is the expiration of the key, a zero value means that the key
+	// does not expire.
+	NotAfter time.Time
+	// MultiUse allows the key to be bound to more than one account.
+	MultiUse bool
 }
 
 // AlreadyBound returns whether this EAK is already bound to
@@ -122,14 +146,22 @@ func (eak *ExternalAccountKey) AlreadyBound() bool {
 	return !eak.BoundAt.IsZero()
 }
 
+// IsExpired returns whether the EAK is expired at the given time.
+func (eak *ExternalAccountKey) IsExpired(now time.Time) bool {
+	return !eak.NotAfter.IsZero() && now.After(eak.NotAfter)
+}
+
 // BindTo binds the EAK to an Account.
-// It returns an error if it's already bound.
+// It returns an error if it's already bound and it's not a multi-use key.
 func (eak *ExternalAccountKey) BindTo(account *Account) error {
-	if eak.AlreadyBound() {
+	if eak.AlreadyBound() && !eak.MultiUse {
 		return NewError(ErrorUnauthorizedType, "external account binding key with id '%s' was already bound to account '%s' on %s", eak.ID, eak.AccountID, eak.BoundAt)
 	}
 	eak.AccountID = account.ID
+	eak.AccountIDs = append(eak.AccountIDs, account.ID)
 	eak.BoundAt = time.Now()
-	eak.HmacKey = []byte{} // clearing the key bytes; can only be used once
+	if !eak.MultiUse {
+		eak.HmacKey = []byte{} // clearing the key bytes; can only be used once
+	}
 	return nil
 }
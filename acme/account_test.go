@@ -135,6 +135,22 @@ func TestExternalAccountKey_BindTo(t *testing.T) {
 			},
 			err: NewError(ErrorUnauthorizedType, "external account binding key with id '%s' was already bound to account '%s' on %s", "eakID", "someAccountID", boundAt),
 		},
+		{
+			name: "ok/multi-use",
+			eak: &ExternalAccountKey{
+				ID:            "eakID",
+				ProvisionerID: "provID",
+				Reference:     "ref",
+				HmacKey:       []byte{1, 3, 3, 7},
+				AccountID:     "someAccountID",
+				BoundAt:       boundAt,
+				MultiUse:      true,
+			},
+			acct: &Account{
+				ID: "accountID",
+			},
+			err: nil,
+		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -156,9 +172,31 @@ func TestExternalAccountKey_BindTo(t *testing.T) {
 				}
 			} else {
 				assert.Equals(t, eak.AccountID, acct.ID)
-				assert.Equals(t, eak.HmacKey, []byte{})
+				if eak.MultiUse {
+					assert.Equals(t, eak.HmacKey, []byte{1, 3, 3, 7})
+				} else {
+					assert.Equals(t, eak.HmacKey, []byte{})
+				}
 				assert.NotNil(t, eak.BoundAt)
 			}
 		})
 	}
 }
+
+func TestExternalAccountKey_IsExpired(t *testing.T) {
+	now := time.Now()
+	tests := []struct {
+		name string
+		eak  *ExternalAccountKey
+		want bool
+	}{
+		{"ok/no-expiration", &ExternalAccountKey{}, false},
+		{"ok/not-expired", &ExternalAccountKey{NotAfter: now.Add(time.Minute)}, false},
+		{"ok/expired", &ExternalAccountKey{NotAfter: now.Add(-time.Minute)}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equals(t, tt.want, tt.eak.IsExpired(now))
+		})
+	}
+}
@@ -0,0 +1,89 @@
+// Package agent implements the remote validation agents used to validate ACME
+// challenges from multiple network perspectives.
+//
+// An agent receives the challenges validated by the CA, validates them from
+// its own network view and returns the result. The CA only accepts the
+// validation if the number of agents that validate a challenge reaches the
+// quorum configured in the ACME provisioner.
+package agent
+
+import (
+	"crypto/subtle"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/smallstep/certificates/acme"
+	"github.com/smallstep/certificates/api/read"
+	"github.com/smallstep/certificates/api/render"
+)
+
+// maxRequestSize is the maximum size of a validation request.
+const maxRequestSize = 1 << 20
+
+// Handler is the HTTP handler of a validation agent.
+type Handler struct {
+	token  string
+	client acme.Client
+	mux    *http.ServeMux
+}
+
+// Option is the type of the options passed to New.
+type Option func(*Handler)
+
+// WithClient sets the client used to validate the challenges. Defaults to the
+// client returned by acme.NewClient.
+func WithClient(c acme.Client) Option {
+	return func(h *Handler) {
+		h.client = c
+	}
+}
+
+// New returns the handler of a validation agent. The requests must be
+// authenticated with the given token as a bearer token.
+func New(token string, opts ...Option) *Handler {
+	h := &Handler{token: token}
+	for _, fn := range opts {
+		fn(h)
+	}
+	if h.client == nil {
+		h.client = acme.NewClient()
+	}
+	h.mux = http.NewServeMux()
+	h.mux.HandleFunc("POST /validate", h.Validate)
+	return h
+}
+
+// ServeHTTP implements http.Handler, it serves the validate endpoint in
+// /validate.
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	h.mux.ServeHTTP(w, r)
+}
+
+// Validate validates the challenge in the request and returns an
+// acme.ValidationResponse with the result.
+func (h *Handler) Validate(w http.ResponseWriter, r *http.Request) {
+	if !h.isAuthorized(r) {
+		render.Error(w, r, acme.NewError(acme.ErrorUnauthorizedType, "invalid validation agent token"))
+		return
+	}
+
+	var req acme.ValidationRequest
+	if err := read.JSON(io.LimitReader(r.Body, maxRequestSize), &req); err != nil {
+		render.Error(w, r, acme.WrapError(acme.ErrorMalformedType, err, "error reading validation request"))
+		return
+	}
+
+	ctx := acme.NewClientContext(r.Context(), h.client)
+	resp, err := acme.ValidateFromPerspective(ctx, &req)
+	if err != nil {
+		render.Error(w, r, err)
+		return
+	}
+	render.JSON(w, r, resp)
+}
+
+func (h *Handler) isAuthorized(r *http.Request) bool {
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	return ok && h.token != "" && subtle.ConstantTimeCompare([]byte(token), []byte(h.token)) == 1
+}
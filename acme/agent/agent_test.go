@@ -0,0 +1,102 @@
+package agent
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.step.sm/crypto/jose"
+
+	"github.com/smallstep/certificates/acme"
+)
+
+type mockClient struct {
+	lookupTxt func(name string) ([]string, error)
+}
+
+func (m *mockClient) Get(string) (*http.Response, error)                           { return nil, nil }
+func (m *mockClient) LookupTxt(name string) ([]string, error)                      { return m.lookupTxt(name) }
+func (m *mockClient) LookupCAA(context.Context, string) ([]*acme.CAARecord, error) { return nil, nil }
+func (m *mockClient) TLSDial(string, string, *tls.Config) (*tls.Conn, error)       { return nil, nil }
+
+func TestHandler_Validate(t *testing.T) {
+	jwk, err := jose.GenerateJWK("EC", "P-256", "ES256", "sig", "", 0)
+	require.NoError(t, err)
+	pub := jwk.Public()
+	keyAuth, err := acme.KeyAuthorization("token", &pub)
+	require.NoError(t, err)
+	sum := sha256.Sum256([]byte(keyAuth))
+	txt := base64.RawURLEncoding.EncodeToString(sum[:])
+
+	srv := httptest.NewServer(New("secret", WithClient(&mockClient{
+		lookupTxt: func(name string) ([]string, error) {
+			if name == "_acme-challenge.example.com" {
+				return []string{txt}, nil
+			}
+			return nil, nil
+		},
+	})))
+	defer srv.Close()
+
+	mustRequest := func(v any) []byte {
+		b, err := json.Marshal(v)
+		require.NoError(t, err)
+		return b
+	}
+
+	tests := []struct {
+		name       string
+		path       string
+		token      string
+		body       []byte
+		wantStatus int
+		want       *acme.ValidationResponse
+	}{
+		{"ok/valid", "/validate", "secret", mustRequest(acme.ValidationRequest{Type: acme.DNS01, Value: "example.com", Token: "token", Key: &pub}),
+			http.StatusOK, &acme.ValidationResponse{Status: acme.StatusValid}},
+		{"ok/invalid", "/validate", "secret", mustRequest(acme.ValidationRequest{Type: acme.DNS01, Value: "example.org", Token: "token", Key: &pub}),
+			http.StatusOK, nil},
+		{"fail/token", "/validate", "foo", mustRequest(acme.ValidationRequest{Type: acme.DNS01, Value: "example.com", Token: "token", Key: &pub}),
+			http.StatusUnauthorized, nil},
+		{"fail/no-token", "/validate", "", mustRequest(acme.ValidationRequest{Type: acme.DNS01, Value: "example.com", Token: "token", Key: &pub}),
+			http.StatusUnauthorized, nil},
+		{"fail/json", "/validate", "secret", []byte("{"), http.StatusBadRequest, nil},
+		{"fail/type", "/validate", "secret", mustRequest(acme.ValidationRequest{Type: acme.DEVICEATTEST01, Value: "example.com", Token: "token", Key: &pub}),
+			http.StatusBadRequest, nil},
+		{"fail/path", "/foo", "secret", nil, http.StatusNotFound, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, err := http.NewRequest(http.MethodPost, srv.URL+tt.path, bytes.NewReader(tt.body))
+			require.NoError(t, err)
+			if tt.token != "" {
+				req.Header.Set("Authorization", "Bearer "+tt.token)
+			}
+			resp, err := srv.Client().Do(req)
+			require.NoError(t, err)
+			defer resp.Body.Close()
+			assert.Equal(t, tt.wantStatus, resp.StatusCode)
+			if tt.wantStatus != http.StatusOK {
+				return
+			}
+
+			var vr acme.ValidationResponse
+			require.NoError(t, json.NewDecoder(resp.Body).Decode(&vr))
+			if tt.want != nil {
+				assert.Equal(t, tt.want, &vr)
+			} else {
+				assert.Equal(t, acme.StatusPending, vr.Status)
+				require.NotNil(t, vr.Error)
+				assert.Equal(t, "urn:ietf:params:acme:error:rejectedIdentifier", vr.Error.Type)
+			}
+		})
+	}
+}
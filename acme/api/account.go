@@ -131,6 +131,15 @@ func NewAccount(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 
+		if err := acme.ConsumeRateLimit(ctx, db, acme.RateLimitKey{
+			ProvisionerID: prov.GetID(),
+			Name:          newAccountsPerIPRateLimit,
+			Value:         clientIP(r),
+		}, rateLimitsOf(prov).NewAccountsPerIP); err != nil {
+			render.Error(w, r, err)
+			return
+		}
+
 		acc = &acme.Account{
 			Key:             jwk,
 			Contact:         nar.Contact,
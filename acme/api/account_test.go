@@ -50,12 +50,13 @@ func (*fakeProvisioner) IsChallengeEnabled(context.Context, provisioner.ACMEChal
 func (*fakeProvisioner) IsAttestationFormatEnabled(context.Context, provisioner.ACMEAttestationFormat) bool {
 	return true
 }
-func (*fakeProvisioner) GetAttestationRoots() (*x509.CertPool, bool)   { return nil, false }
-func (*fakeProvisioner) AuthorizeRevoke(context.Context, string) error { return nil }
-func (*fakeProvisioner) GetID() string                                 { return "" }
-func (*fakeProvisioner) GetName() string                               { return "" }
-func (*fakeProvisioner) DefaultTLSCertDuration() time.Duration         { return 0 }
-func (*fakeProvisioner) GetOptions() *provisioner.Options              { return nil }
+func (*fakeProvisioner) GetAttestationRoots() (*x509.CertPool, bool)        { return nil, false }
+func (*fakeProvisioner) AuthorizeRevoke(context.Context, string) error      { return nil }
+func (*fakeProvisioner) GetID() string                                      { return "" }
+func (*fakeProvisioner) GetName() string                                    { return "" }
+func (*fakeProvisioner) DefaultTLSCertDuration() time.Duration              { return 0 }
+func (*fakeProvisioner) GetOptions() *provisioner.Options                   { return nil }
+func (*fakeProvisioner) GetProfile(string) (*provisioner.ACMEProfile, bool) { return nil, false }
 
 func newProv() acme.Provisioner {
 	// Initialize provisioners
@@ -113,6 +114,26 @@ func newACMEProvWithOptions(t *testing.T, options *provisioner.Options) *provisi
 	return a
 }
 
+func newACMEProvWithProfiles(t *testing.T) *provisioner.ACME {
+	p := &provisioner.ACME{
+		Type: "ACME",
+		Name: "test@acme-<test>provisioner.com",
+		Profiles: []*provisioner.ACMEProfile{
+			{Name: "tlsserver", Description: "Server certificates"},
+			{
+				Name:        "mtls",
+				Description: "Client and server certificates",
+				Identifiers: []provisioner.ACMEIdentifierType{provisioner.DNS},
+				Claims:      &provisioner.Claims{DefaultTLSDur: &provisioner.Duration{Duration: time.Hour}},
+			},
+		},
+	}
+	if err := p.Init(provisioner.Config{Claims: globalProvisionerClaims}); err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
 func createEABJWS(jwk *jose.JSONWebKey, hmacKey []byte, keyID, u string) (*jose.JSONWebSignature, error) {
 	signer, err := jose.NewSigner(
 		jose.SigningKey{
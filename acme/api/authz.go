@@ -0,0 +1,98 @@
+package api
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+	"strings"
+
+	"go.step.sm/crypto/x509util"
+
+	"github.com/smallstep/certificates/acme"
+	"github.com/smallstep/certificates/api/render"
+)
+
+// NewAuthorizationRequest represents the body for a NewAuthorization request.
+type NewAuthorizationRequest struct {
+	Identifier acme.Identifier `json:"identifier"`
+}
+
+// Validate validates a new-authz request body. Pre-authorizations are only
+// supported for DNS and IP identifiers, and, as defined in RFC 8555, they
+// cannot be used for wildcard domains.
+func (n *NewAuthorizationRequest) Validate() error {
+	switch n.Identifier.Type {
+	case acme.IP:
+		if net.ParseIP(n.Identifier.Value) == nil {
+			return acme.NewError(acme.ErrorMalformedType, "invalid IP address: %s", n.Identifier.Value)
+		}
+	case acme.DNS:
+		if strings.HasPrefix(n.Identifier.Value, "*.") {
+			return acme.NewError(acme.ErrorMalformedType, "wildcard identifiers cannot be pre-authorized")
+		}
+		if _, err := x509util.SanitizeName(n.Identifier.Value); err != nil {
+			return acme.NewError(acme.ErrorMalformedType, "invalid DNS name: %s", n.Identifier.Value)
+		}
+	default:
+		return acme.NewError(acme.ErrorUnsupportedIdentifierType, "identifier type unsupported: %s", n.Identifier.Type)
+	}
+	return nil
+}
+
+// NewAuthorization ACME api for creating a pre-authorization as defined in
+// RFC 8555, section 7.4.1. Valid pre-authorizations are reused by new orders
+// of the same account.
+func NewAuthorization(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	linker := acme.MustLinkerFromContext(ctx)
+
+	acc, err := accountFromContext(ctx)
+	if err != nil {
+		render.Error(w, r, err)
+		return
+	}
+	payload, err := payloadFromContext(ctx)
+	if err != nil {
+		render.Error(w, r, err)
+		return
+	}
+
+	var nar NewAuthorizationRequest
+	if err := json.Unmarshal(payload.value, &nar); err != nil {
+		render.Error(w, r, acme.WrapError(acme.ErrorMalformedType, err,
+			"failed to unmarshal new-authz request payload"))
+		return
+	}
+	if err := nar.Validate(); err != nil {
+		render.Error(w, r, err)
+		return
+	}
+
+	acmeProv, err := acmeProvisionerFromContext(ctx)
+	if err != nil {
+		render.Error(w, r, err)
+		return
+	}
+	if err := authorizeIdentifiers(ctx, acmeProv, acc, []acme.Identifier{nar.Identifier}); err != nil {
+		render.Error(w, r, err)
+		return
+	}
+
+	az := &acme.Authorization{
+		AccountID:  acc.ID,
+		Identifier: nar.Identifier,
+		ExpiresAt:  clock.Now().Add(defaultOrderExpiry),
+		Status:     acme.StatusPending,
+	}
+	if err := newAuthorization(ctx, az); err != nil {
+		render.Error(w, r, err)
+		return
+	}
+
+	acme.SendEmailReply00Challenges(ctx, az)
+
+	linker.LinkAuthorization(ctx, az)
+
+	w.Header().Set("Location", linker.GetLink(ctx, acme.AuthzLinkType, az.ID))
+	render.JSONStatus(w, r, az, http.StatusCreated)
+}
@@ -0,0 +1,138 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/smallstep/certificates/acme"
+)
+
+func TestNewAuthorizationRequest_Validate(t *testing.T) {
+	tests := []struct {
+		name       string
+		identifier acme.Identifier
+		wantType   string
+	}{
+		{"ok/dns", acme.Identifier{Type: acme.DNS, Value: "example.com"}, ""},
+		{"ok/ip", acme.Identifier{Type: acme.IP, Value: "192.168.0.1"}, ""},
+		{"fail/wildcard", acme.Identifier{Type: acme.DNS, Value: "*.example.com"}, "urn:ietf:params:acme:error:malformed"},
+		{"fail/dns", acme.Identifier{Type: acme.DNS, Value: "xn--bücher.example.com"}, "urn:ietf:params:acme:error:malformed"},
+		{"fail/ip", acme.Identifier{Type: acme.IP, Value: "foo"}, "urn:ietf:params:acme:error:malformed"},
+		{"fail/type", acme.Identifier{Type: acme.PermanentIdentifier, Value: "foo"}, "urn:ietf:params:acme:error:unsupportedIdentifier"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := (&NewAuthorizationRequest{Identifier: tt.identifier}).Validate()
+			if tt.wantType == "" {
+				assert.NoError(t, err)
+				return
+			}
+			var ae *acme.Error
+			require.True(t, errors.As(err, &ae))
+			assert.Equal(t, tt.wantType, ae.Type)
+		})
+	}
+}
+
+func TestNewAuthorization(t *testing.T) {
+	prov := newProv()
+	provName := url.PathEscape(prov.GetName())
+	baseURL := fmt.Sprintf("https://test.ca.smallstep.com/acme/%s", provName)
+
+	mustPayload := func(id acme.Identifier) []byte {
+		b, err := json.Marshal(NewAuthorizationRequest{Identifier: id})
+		require.NoError(t, err)
+		return b
+	}
+	okDB := func() *acme.MockDB {
+		return &acme.MockDB{
+			MockGetExternalAccountKeyByAccountID: func(ctx context.Context, provisionerID, accountID string) (*acme.ExternalAccountKey, error) {
+				return nil, nil
+			},
+			MockCreateChallenge: func(ctx context.Context, ch *acme.Challenge) error {
+				assert.Equal(t, "accID", ch.AccountID)
+				assert.Equal(t, "example.com", ch.Value)
+				ch.ID = string(ch.Type)
+				return nil
+			},
+			MockCreateAuthorization: func(ctx context.Context, az *acme.Authorization) error {
+				assert.Equal(t, "accID", az.AccountID)
+				assert.Equal(t, acme.StatusPending, az.Status)
+				assert.False(t, az.Wildcard)
+				az.ID = "azID"
+				return nil
+			},
+		}
+	}
+
+	tests := []struct {
+		name       string
+		payload    []byte
+		db         acme.DB
+		ca         *mockCA
+		wantStatus int
+	}{
+		{"ok", mustPayload(acme.Identifier{Type: acme.DNS, Value: "example.com"}), okDB(), &mockCA{}, http.StatusCreated},
+		{"fail/payload", []byte("foo"), &acme.MockDB{}, &mockCA{}, http.StatusBadRequest},
+		{"fail/wildcard", mustPayload(acme.Identifier{Type: acme.DNS, Value: "*.example.com"}), &acme.MockDB{}, &mockCA{}, http.StatusBadRequest},
+		{"fail/authority-policy", mustPayload(acme.Identifier{Type: acme.DNS, Value: "example.com"}), okDB(), &mockCA{
+			MockAreSANsallowed: func(ctx context.Context, sans []string) error {
+				return errors.New("force")
+			},
+		}, http.StatusBadRequest},
+		{"fail/db.CreateAuthorization", mustPayload(acme.Identifier{Type: acme.DNS, Value: "example.com"}), &acme.MockDB{
+			MockGetExternalAccountKeyByAccountID: func(ctx context.Context, provisionerID, accountID string) (*acme.ExternalAccountKey, error) {
+				return nil, nil
+			},
+			MockCreateChallenge: func(ctx context.Context, ch *acme.Challenge) error {
+				return nil
+			},
+			MockCreateAuthorization: func(ctx context.Context, az *acme.Authorization) error {
+				return errors.New("force")
+			},
+		}, &mockCA{}, http.StatusInternalServerError},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockMustAuthority(t, tt.ca)
+			ctx := acme.NewProvisionerContext(context.Background(), prov)
+			ctx = context.WithValue(ctx, accContextKey, &acme.Account{ID: "accID"})
+			ctx = context.WithValue(ctx, payloadContextKey, &payloadInfo{value: tt.payload})
+			ctx = newBaseContext(ctx, tt.db, acme.NewLinker("test.ca.smallstep.com", "acme"))
+
+			req := httptest.NewRequest("POST", baseURL+"/new-authz", http.NoBody).WithContext(ctx)
+			w := httptest.NewRecorder()
+			NewAuthorization(w, req)
+			res := w.Result()
+
+			body, err := io.ReadAll(res.Body)
+			res.Body.Close()
+			require.NoError(t, err)
+
+			assert.Equal(t, tt.wantStatus, res.StatusCode, string(body))
+			if tt.wantStatus != http.StatusCreated {
+				return
+			}
+
+			assert.Equal(t, baseURL+"/authz/azID", res.Header.Get("Location"))
+			var az acme.Authorization
+			require.NoError(t, json.Unmarshal(body, &az))
+			assert.Equal(t, acme.StatusPending, az.Status)
+			assert.Equal(t, acme.Identifier{Type: acme.DNS, Value: "example.com"}, az.Identifier)
+			assert.NotEmpty(t, az.Challenges)
+			for _, ch := range az.Challenges {
+				assert.Equal(t, fmt.Sprintf("%s/challenge/azID/%s", baseURL, ch.Type), ch.URL)
+			}
+		})
+	}
+}
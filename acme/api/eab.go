@@ -4,6 +4,7 @@ import (
 	"context"
 	"encoding/json"
 	"errors"
+	"time"
 
 	"go.step.sm/crypto/jose"
 
@@ -68,7 +69,15 @@ func validateExternalAccountBinding(ctx context.Context, nar *NewAccountRequest)
 		return nil, acme.NewError(acme.ErrorServerInternalType, "external account binding key with id '%s' does not have secret bytes", keyID)
 	}
 
-	if externalAccountKey.AlreadyBound() {
+	if externalAccountKey.Disabled {
+		return nil, acme.NewError(acme.ErrorUnauthorizedType, "external account binding key with id '%s' is disabled", keyID)
+	}
+
+	if externalAccountKey.IsExpired(time.Now()) {
+		return nil, acme.NewError(acme.ErrorUnauthorizedType, "external account binding key with id '%s' expired on %s", keyID, externalAccountKey.NotAfter)
+	}
+
+	if externalAccountKey.AlreadyBound() && !externalAccountKey.MultiUse {
 		return nil, acme.NewError(acme.ErrorUnauthorizedType, "external account binding key with id '%s' was already bound to account '%s' on %s", keyID, externalAccountKey.AccountID, externalAccountKey.BoundAt)
 	}
 
@@ -94,6 +103,35 @@ func validateExternalAccountBinding(ctx context.Context, nar *NewAccountRequest)
 	return externalAccountKey, nil
 }
 
+// validateAccountExternalAccountKey validates that the External Account
+// Binding key the account was created with, if any, is still enabled and not
+// expired.
+func validateAccountExternalAccountKey(ctx context.Context, acc *acme.Account) error {
+	acmeProv, err := acmeProvisionerFromContext(ctx)
+	if err != nil {
+		return acme.WrapErrorISE(err, "could not load ACME provisioner from context")
+	}
+
+	if !acmeProv.RequireEAB {
+		return nil
+	}
+
+	db := acme.MustDatabaseFromContext(ctx)
+	eak, err := db.GetExternalAccountKeyByAccountID(ctx, acmeProv.GetID(), acc.ID)
+	switch {
+	case err != nil:
+		return acme.WrapErrorISE(err, "error retrieving external account binding key")
+	case eak == nil:
+		return nil
+	case eak.Disabled:
+		return acme.NewError(acme.ErrorUnauthorizedType, "account is disabled")
+	case eak.IsExpired(time.Now()):
+		return acme.NewError(acme.ErrorUnauthorizedType, "account external account binding key expired on %s", eak.NotAfter)
+	default:
+		return nil
+	}
+}
+
 // keysAreEqual performs an equality check on two JWKs by comparing
 // the (base64 encoding) of the Key IDs.
 func keysAreEqual(x, y *jose.JSONWebKey) bool {
@@ -886,6 +886,128 @@ func TestHandler_validateExternalAccountBinding(t *testing.T) {
 	}
 }
 
+func TestHandler_validateExternalAccountBinding_lifecycle(t *testing.T) {
+	acmeProv := newACMEProv(t)
+	escProvName := url.PathEscape(acmeProv.GetName())
+	baseURL := &url.URL{Scheme: "https", Host: "test.ca.smallstep.com"}
+	boundAt := time.Now().Add(-time.Hour)
+	notAfter := time.Now().Add(-time.Minute)
+
+	newContext := func(t *testing.T) (context.Context, *NewAccountRequest) {
+		jwk, err := jose.GenerateJWK("EC", "P-256", "ES256", "sig", "", 0)
+		assert.FatalError(t, err)
+		url := fmt.Sprintf("%s/acme/%s/account/new-account", baseURL.String(), escProvName)
+		rawEABJWS, err := createRawEABJWS(jwk, []byte{1, 3, 3, 7}, "eakID", url)
+		assert.FatalError(t, err)
+		eab := &ExternalAccountBinding{}
+		assert.FatalError(t, json.Unmarshal(rawEABJWS, &eab))
+		so := new(jose.SignerOptions)
+		so.WithHeader("alg", jose.SignatureAlgorithm(jwk.Algorithm))
+		so.WithHeader("url", url)
+		signer, err := jose.NewSigner(jose.SigningKey{
+			Algorithm: jose.SignatureAlgorithm(jwk.Algorithm),
+			Key:       jwk.Key,
+		}, so)
+		assert.FatalError(t, err)
+		jws, err := signer.Sign([]byte("{}"))
+		assert.FatalError(t, err)
+		raw, err := jws.CompactSerialize()
+		assert.FatalError(t, err)
+		parsedJWS, err := jose.ParseJWS(raw)
+		assert.FatalError(t, err)
+		prov := newACMEProv(t)
+		prov.RequireEAB = true
+		ctx := context.WithValue(context.Background(), jwkContextKey, jwk)
+		ctx = acme.NewProvisionerContext(ctx, prov)
+		ctx = context.WithValue(ctx, jwsContextKey, parsedJWS)
+		return ctx, &NewAccountRequest{ExternalAccountBinding: eab}
+	}
+
+	tests := []struct {
+		name string
+		eak  *acme.ExternalAccountKey
+		err  string
+	}{
+		{"ok/multi-use-bound", &acme.ExternalAccountKey{ID: "eakID", HmacKey: []byte{1, 3, 3, 7}, AccountID: "accountID", BoundAt: boundAt, MultiUse: true}, ""},
+		{"ok/not-expired", &acme.ExternalAccountKey{ID: "eakID", HmacKey: []byte{1, 3, 3, 7}, NotAfter: time.Now().Add(time.Hour)}, ""},
+		{"fail/single-use-bound", &acme.ExternalAccountKey{ID: "eakID", HmacKey: []byte{1, 3, 3, 7}, AccountID: "accountID", BoundAt: boundAt},
+			fmt.Sprintf("external account binding key with id 'eakID' was already bound to account 'accountID' on %s", boundAt)},
+		{"fail/disabled", &acme.ExternalAccountKey{ID: "eakID", HmacKey: []byte{1, 3, 3, 7}, Disabled: true},
+			"external account binding key with id 'eakID' is disabled"},
+		{"fail/expired", &acme.ExternalAccountKey{ID: "eakID", HmacKey: []byte{1, 3, 3, 7}, NotAfter: notAfter},
+			fmt.Sprintf("external account binding key with id 'eakID' expired on %s", notAfter)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, nar := newContext(t)
+			ctx = acme.NewDatabaseContext(ctx, &acme.MockDB{
+				MockGetExternalAccountKey: func(ctx context.Context, provisionerID, keyID string) (*acme.ExternalAccountKey, error) {
+					return tt.eak, nil
+				},
+			})
+			got, err := validateExternalAccountBinding(ctx, nar)
+			if tt.err != "" {
+				var ae *acme.Error
+				if assert.True(t, errors.As(err, &ae)) {
+					assert.Equals(t, "urn:ietf:params:acme:error:unauthorized", ae.Type)
+					assert.Equals(t, tt.err, ae.Err.Error())
+				}
+				return
+			}
+			assert.FatalError(t, err)
+			assert.Equals(t, tt.eak, got)
+		})
+	}
+}
+
+func Test_validateAccountExternalAccountKey(t *testing.T) {
+	acc := &acme.Account{ID: "accountID"}
+	notAfter := time.Now().Add(-time.Minute)
+	newContext := func(requireEAB bool, db acme.DB) context.Context {
+		prov := newACMEProv(t)
+		prov.RequireEAB = requireEAB
+		ctx := acme.NewProvisionerContext(context.Background(), prov)
+		return acme.NewDatabaseContext(ctx, db)
+	}
+	newDB := func(eak *acme.ExternalAccountKey, err error) acme.DB {
+		return &acme.MockDB{
+			MockGetExternalAccountKeyByAccountID: func(ctx context.Context, provisionerID, accountID string) (*acme.ExternalAccountKey, error) {
+				assert.Equals(t, "accountID", accountID)
+				return eak, err
+			},
+		}
+	}
+	tests := []struct {
+		name string
+		ctx  context.Context
+		err  *acme.Error
+	}{
+		{"ok/eab-not-required", newContext(false, &acme.MockDB{}), nil},
+		{"ok/no-eak", newContext(true, newDB(nil, nil)), nil},
+		{"ok/eak", newContext(true, newDB(&acme.ExternalAccountKey{ID: "eakID", NotAfter: time.Now().Add(time.Hour)}, nil)), nil},
+		{"fail/db", newContext(true, newDB(nil, errors.New("force"))),
+			acme.NewErrorISE("error retrieving external account binding key: force")},
+		{"fail/disabled", newContext(true, newDB(&acme.ExternalAccountKey{ID: "eakID", Disabled: true}, nil)),
+			acme.NewError(acme.ErrorUnauthorizedType, "account is disabled")},
+		{"fail/expired", newContext(true, newDB(&acme.ExternalAccountKey{ID: "eakID", NotAfter: notAfter}, nil)),
+			acme.NewError(acme.ErrorUnauthorizedType, "account external account binding key expired on %s", notAfter)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateAccountExternalAccountKey(tt.ctx, acc)
+			if tt.err == nil {
+				assert.FatalError(t, err)
+				return
+			}
+			var ae *acme.Error
+			if assert.True(t, errors.As(err, &ae)) {
+				assert.Equals(t, tt.err.Type, ae.Type)
+				assert.Equals(t, tt.err.Err.Error(), ae.Err.Error())
+			}
+		})
+	}
+}
+
 func Test_validateEABJWS(t *testing.T) {
 	acmeProv := newACMEProv(t)
 	escProvName := url.PathEscape(acmeProv.GetName())
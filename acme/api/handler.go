@@ -7,6 +7,7 @@ import (
 	"encoding/pem"
 	"fmt"
 	"net/http"
+	"strconv"
 	"time"
 
 	"github.com/go-chi/chi/v5"
@@ -162,11 +163,13 @@ func route(r api.Router, middleware func(next nextHTTP) nextHTTP) {
 	r.MethodFunc("POST", getPath(acme.AccountLinkType, "{provisionerID}", "{accID}"),
 		extractPayloadByKid(GetOrUpdateAccount))
 	r.MethodFunc("POST", getPath(acme.KeyChangeLinkType, "{provisionerID}", "{accID}"),
-		extractPayloadByKid(NotImplemented))
+		extractPayloadByKid(KeyChange))
 	r.MethodFunc("POST", getPath(acme.NewOrderLinkType, "{provisionerID}"),
 		extractPayloadByKid(NewOrder))
+	r.MethodFunc("POST", getPath(acme.NewAuthzLinkType, "{provisionerID}"),
+		extractPayloadByKid(NewAuthorization))
 	r.MethodFunc("POST", getPath(acme.OrderLinkType, "{provisionerID}", "{ordID}"),
-		extractPayloadByKid(isPostAsGet(GetOrder)))
+		extractPayloadByKid(GetOrUpdateOrder))
 	r.MethodFunc("POST", getPath(acme.OrdersByAccountLinkType, "{provisionerID}", "{accID}"),
 		extractPayloadByKid(isPostAsGet(GetOrdersByAccountID)))
 	r.MethodFunc("POST", getPath(acme.FinalizeLinkType, "{provisionerID}", "{ordID}"),
@@ -179,6 +182,16 @@ func route(r api.Router, middleware func(next nextHTTP) nextHTTP) {
 		extractPayloadByKid(isPostAsGet(GetCertificate)))
 	r.MethodFunc("POST", getPath(acme.RevokeCertLinkType, "{provisionerID}"),
 		extractPayloadByKidOrJWK(RevokeCert))
+
+	// ACME Renewal Information (ARI), RFC 9773
+	r.MethodFunc("GET", getPath(acme.RenewalInfoLinkType, "{provisionerID}", "{certID}"),
+		commonMiddleware(GetRenewalInfo))
+
+	// Short-Term, Automatically Renewed (STAR) certificates, RFC 8739
+	r.MethodFunc("POST", getPath(acme.StarCertificateLinkType, "{provisionerID}", "{ordID}"),
+		extractPayloadByKid(isPostAsGet(GetStarCertificate)))
+	r.MethodFunc("GET", getPath(acme.StarCertificateLinkType, "{provisionerID}", "{ordID}"),
+		commonMiddleware(GetStarCertificate))
 }
 
 // GetNonce just sets the right header since a Nonce is added to each response
@@ -192,20 +205,32 @@ func GetNonce(w http.ResponseWriter, r *http.Request) {
 }
 
 type Meta struct {
-	TermsOfService          string   `json:"termsOfService,omitempty"`
-	Website                 string   `json:"website,omitempty"`
-	CaaIdentities           []string `json:"caaIdentities,omitempty"`
-	ExternalAccountRequired bool     `json:"externalAccountRequired,omitempty"`
+	TermsOfService          string            `json:"termsOfService,omitempty"`
+	Website                 string            `json:"website,omitempty"`
+	CaaIdentities           []string          `json:"caaIdentities,omitempty"`
+	ExternalAccountRequired bool              `json:"externalAccountRequired,omitempty"`
+	Profiles                map[string]string `json:"profiles,omitempty"`
+	AutoRenewal             *AutoRenewalMeta  `json:"auto-renewal,omitempty"`
+}
+
+// AutoRenewalMeta advertises the support of Short-Term, Automatically
+// Renewed (STAR) certificates in the ACME directory, RFC 8739.
+type AutoRenewalMeta struct {
+	MinLifetime         int64 `json:"min-lifetime"`
+	MaxDuration         int64 `json:"max-duration"`
+	AllowCertificateGet bool  `json:"allow-certificate-get,omitempty"`
 }
 
 // Directory represents an ACME directory for configuring clients.
 type Directory struct {
-	NewNonce   string `json:"newNonce"`
-	NewAccount string `json:"newAccount"`
-	NewOrder   string `json:"newOrder"`
-	RevokeCert string `json:"revokeCert"`
-	KeyChange  string `json:"keyChange"`
-	Meta       *Meta  `json:"meta,omitempty"`
+	NewNonce    string `json:"newNonce"`
+	NewAccount  string `json:"newAccount"`
+	NewOrder    string `json:"newOrder"`
+	NewAuthz    string `json:"newAuthz,omitempty"`
+	RevokeCert  string `json:"revokeCert"`
+	KeyChange   string `json:"keyChange"`
+	RenewalInfo string `json:"renewalInfo,omitempty"`
+	Meta        *Meta  `json:"meta,omitempty"`
 }
 
 // ToLog enables response logging for the Directory type.
@@ -230,12 +255,14 @@ func GetDirectory(w http.ResponseWriter, r *http.Request) {
 	linker := acme.MustLinkerFromContext(ctx)
 
 	render.JSON(w, r, &Directory{
-		NewNonce:   linker.GetLink(ctx, acme.NewNonceLinkType),
-		NewAccount: linker.GetLink(ctx, acme.NewAccountLinkType),
-		NewOrder:   linker.GetLink(ctx, acme.NewOrderLinkType),
-		RevokeCert: linker.GetLink(ctx, acme.RevokeCertLinkType),
-		KeyChange:  linker.GetLink(ctx, acme.KeyChangeLinkType),
-		Meta:       createMetaObject(acmeProv),
+		NewNonce:    linker.GetLink(ctx, acme.NewNonceLinkType),
+		NewAccount:  linker.GetLink(ctx, acme.NewAccountLinkType),
+		NewOrder:    linker.GetLink(ctx, acme.NewOrderLinkType),
+		NewAuthz:    linker.GetLink(ctx, acme.NewAuthzLinkType),
+		RevokeCert:  linker.GetLink(ctx, acme.RevokeCertLinkType),
+		KeyChange:   linker.GetLink(ctx, acme.KeyChangeLinkType),
+		RenewalInfo: linker.GetLink(ctx, acme.RenewalInfoLinkType),
+		Meta:        createMetaObject(acmeProv),
 	})
 }
 
@@ -249,11 +276,39 @@ func createMetaObject(p *provisioner.ACME) *Meta {
 			Website:                 p.Website,
 			CaaIdentities:           p.CaaIdentities,
 			ExternalAccountRequired: p.RequireEAB,
+			Profiles:                createProfilesObject(p),
+			AutoRenewal:             createAutoRenewalObject(p),
 		}
 	}
 	return nil
 }
 
+// createAutoRenewalObject returns the auto-renewal capabilities advertised in
+// the ACME directory.
+func createAutoRenewalObject(p *provisioner.ACME) *AutoRenewalMeta {
+	if p.AutoRenewal == nil {
+		return nil
+	}
+	return &AutoRenewalMeta{
+		MinLifetime:         int64(p.AutoRenewal.GetMinLifetime() / time.Second),
+		MaxDuration:         int64(p.AutoRenewal.GetMaxDuration() / time.Second),
+		AllowCertificateGet: p.AutoRenewal.AllowCertificateGet,
+	}
+}
+
+// createProfilesObject returns the map of profile names and descriptions
+// advertised in the ACME directory.
+func createProfilesObject(p *provisioner.ACME) map[string]string {
+	if len(p.Profiles) == 0 {
+		return nil
+	}
+	profiles := make(map[string]string, len(p.Profiles))
+	for _, profile := range p.Profiles {
+		profiles[profile.Name] = profile.Description
+	}
+	return profiles
+}
+
 // shouldAddMetaObject returns whether or not the ACME provisioner
 // has properties configured that must be added to the ACME directory object.
 func shouldAddMetaObject(p *provisioner.ACME) bool {
@@ -266,6 +321,10 @@ func shouldAddMetaObject(p *provisioner.ACME) bool {
 		return true
 	case p.RequireEAB:
 		return true
+	case len(p.Profiles) > 0:
+		return true
+	case p.AutoRenewal != nil:
+		return true
 	default:
 		return false
 	}
@@ -350,9 +409,50 @@ func GetChallenge(w http.ResponseWriter, r *http.Request) {
 		render.Error(w, r, err)
 		return
 	}
-	if err = ch.Validate(ctx, db, jwk, payload.value); err != nil {
-		render.Error(w, r, acme.WrapErrorISE(err, "error validating challenge"))
-		return
+
+	// Limit the number of failed validations per identifier.
+	prov, _ := acme.ProvisionerFromContext(ctx)
+	failedLimit := rateLimitsOf(prov).FailedValidationsPerIdentifier
+	var failedKey acme.RateLimitKey
+	if failedLimit.IsEnabled() && ch.Status == acme.StatusPending {
+		failedKey = acme.RateLimitKey{
+			ProvisionerID: prov.GetID(),
+			Name:          failedValidationsPerIdentifierRateLimit,
+			Value:         ch.Value,
+		}
+		if err := acme.CheckRateLimit(ctx, db, failedKey, failedLimit); err != nil {
+			render.Error(w, r, err)
+			return
+		}
+	}
+
+	// If enabled, http-01, dns-01, dns-account-01 and tls-alpn-01 challenges
+	// are validated asynchronously, and the failed validations are counted
+	// by CountFailedValidation.
+	validator, ok := acme.AsyncValidatorFromContext(ctx)
+	switch {
+	case ok && validator.IsAsync(ch):
+		if ch.Status == acme.StatusPending {
+			if err := validator.Enqueue(ctx, db, ch); err != nil {
+				render.Error(w, r, acme.WrapErrorISE(err, "error enqueuing challenge validation"))
+				return
+			}
+		}
+		if ch.Status == acme.StatusProcessing {
+			w.Header().Set("Retry-After", strconv.Itoa(int(validator.RetryAfter().Seconds())))
+		}
+	default:
+		if err = ch.Validate(ctx, db, jwk, payload.value); err != nil {
+			render.Error(w, r, acme.WrapErrorISE(err, "error validating challenge"))
+			return
+		}
+
+		if failedKey.Name != "" && ch.Error != nil {
+			if err := acme.IncrementRateLimit(ctx, db, failedKey, failedLimit); err != nil {
+				render.Error(w, r, err)
+				return
+			}
+		}
 	}
 
 	linker.LinkChallenge(ctx, ch, azID)
@@ -385,6 +485,60 @@ func GetCertificate(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	writeCertificate(w, cert)
+}
+
+// GetStarCertificate ACME api for retrieving the current certificate of an
+// auto-renewal order, RFC 8739. The certificate is retrieved using POST-as-GET
+// requests, or unauthenticated GET requests if the order allows them.
+func GetStarCertificate(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	db := acme.MustDatabaseFromContext(ctx)
+
+	var (
+		o   *acme.Order
+		err error
+	)
+	if r.Method == http.MethodGet {
+		prov, err := provisionerFromContext(ctx)
+		if err != nil {
+			render.Error(w, r, err)
+			return
+		}
+		o, err = db.GetOrder(ctx, chi.URLParam(r, "ordID"))
+		if err != nil {
+			render.Error(w, r, acme.WrapErrorISE(err, "error retrieving order"))
+			return
+		}
+		if prov.GetID() != o.ProvisionerID {
+			render.Error(w, r, acme.NewError(acme.ErrorUnauthorizedType,
+				"provisioner '%s' does not own order '%s'", prov.GetID(), o.ID))
+			return
+		}
+		if o.AutoRenewal != nil && !o.AutoRenewal.AllowCertificateGet {
+			render.Error(w, r, acme.NewError(acme.ErrorUnauthorizedType,
+				"order '%s' does not allow unauthenticated certificate requests", o.ID))
+			return
+		}
+	} else if o, err = getAccountOrder(ctx, chi.URLParam(r, "ordID")); err != nil {
+		render.Error(w, r, err)
+		return
+	}
+
+	cert, err := o.GetAutoRenewalCertificate(ctx, db)
+	if err != nil {
+		render.Error(w, r, err)
+		return
+	}
+
+	w.Header().Set("Cert-Not-Before", cert.Leaf.NotBefore.UTC().Format(http.TimeFormat))
+	w.Header().Set("Cert-Not-After", cert.Leaf.NotAfter.UTC().Format(http.TimeFormat))
+	writeCertificate(w, cert)
+}
+
+// writeCertificate writes the PEM encoded certificate chain of the given
+// certificate.
+func writeCertificate(w http.ResponseWriter, cert *acme.Certificate) {
 	var certBytes []byte
 	for _, c := range append([]*x509.Certificate{cert.Leaf}, cert.Intermediates...) {
 		certBytes = append(certBytes, pem.EncodeToMemory(&pem.Block{
@@ -30,11 +30,15 @@ import (
 type mockClient struct {
 	get       func(url string) (*http.Response, error)
 	lookupTxt func(name string) ([]string, error)
+	lookupCAA func(name string) ([]*acme.CAARecord, error)
 	tlsDial   func(network, addr string, config *tls.Config) (*tls.Conn, error)
 }
 
 func (m *mockClient) Get(u string) (*http.Response, error)    { return m.get(u) }
 func (m *mockClient) LookupTxt(name string) ([]string, error) { return m.lookupTxt(name) }
+func (m *mockClient) LookupCAA(_ context.Context, name string) ([]*acme.CAARecord, error) {
+	return m.lookupCAA(name)
+}
 func (m *mockClient) TLSDial(network, addr string, config *tls.Config) (*tls.Conn, error) {
 	return m.tlsDial(network, addr, config)
 }
@@ -108,11 +112,13 @@ func TestHandler_GetDirectory(t *testing.T) {
 			baseURL := &url.URL{Scheme: "https", Host: "test.ca.smallstep.com"}
 			ctx := acme.NewProvisionerContext(context.Background(), prov)
 			expDir := Directory{
-				NewNonce:   fmt.Sprintf("%s/acme/%s/new-nonce", baseURL.String(), provName),
-				NewAccount: fmt.Sprintf("%s/acme/%s/new-account", baseURL.String(), provName),
-				NewOrder:   fmt.Sprintf("%s/acme/%s/new-order", baseURL.String(), provName),
-				RevokeCert: fmt.Sprintf("%s/acme/%s/revoke-cert", baseURL.String(), provName),
-				KeyChange:  fmt.Sprintf("%s/acme/%s/key-change", baseURL.String(), provName),
+				NewNonce:    fmt.Sprintf("%s/acme/%s/new-nonce", baseURL.String(), provName),
+				NewAccount:  fmt.Sprintf("%s/acme/%s/new-account", baseURL.String(), provName),
+				NewOrder:    fmt.Sprintf("%s/acme/%s/new-order", baseURL.String(), provName),
+				NewAuthz:    fmt.Sprintf("%s/acme/%s/new-authz", baseURL.String(), provName),
+				RevokeCert:  fmt.Sprintf("%s/acme/%s/revoke-cert", baseURL.String(), provName),
+				KeyChange:   fmt.Sprintf("%s/acme/%s/key-change", baseURL.String(), provName),
+				RenewalInfo: fmt.Sprintf("%s/acme/%s/renewal-info", baseURL.String(), provName),
 			}
 			return test{
 				ctx:        ctx,
@@ -127,11 +133,13 @@ func TestHandler_GetDirectory(t *testing.T) {
 			baseURL := &url.URL{Scheme: "https", Host: "test.ca.smallstep.com"}
 			ctx := acme.NewProvisionerContext(context.Background(), prov)
 			expDir := Directory{
-				NewNonce:   fmt.Sprintf("%s/acme/%s/new-nonce", baseURL.String(), provName),
-				NewAccount: fmt.Sprintf("%s/acme/%s/new-account", baseURL.String(), provName),
-				NewOrder:   fmt.Sprintf("%s/acme/%s/new-order", baseURL.String(), provName),
-				RevokeCert: fmt.Sprintf("%s/acme/%s/revoke-cert", baseURL.String(), provName),
-				KeyChange:  fmt.Sprintf("%s/acme/%s/key-change", baseURL.String(), provName),
+				NewNonce:    fmt.Sprintf("%s/acme/%s/new-nonce", baseURL.String(), provName),
+				NewAccount:  fmt.Sprintf("%s/acme/%s/new-account", baseURL.String(), provName),
+				NewOrder:    fmt.Sprintf("%s/acme/%s/new-order", baseURL.String(), provName),
+				NewAuthz:    fmt.Sprintf("%s/acme/%s/new-authz", baseURL.String(), provName),
+				RevokeCert:  fmt.Sprintf("%s/acme/%s/revoke-cert", baseURL.String(), provName),
+				KeyChange:   fmt.Sprintf("%s/acme/%s/key-change", baseURL.String(), provName),
+				RenewalInfo: fmt.Sprintf("%s/acme/%s/renewal-info", baseURL.String(), provName),
 				Meta: &Meta{
 					ExternalAccountRequired: true,
 				},
@@ -152,11 +160,13 @@ func TestHandler_GetDirectory(t *testing.T) {
 			baseURL := &url.URL{Scheme: "https", Host: "test.ca.smallstep.com"}
 			ctx := acme.NewProvisionerContext(context.Background(), prov)
 			expDir := Directory{
-				NewNonce:   fmt.Sprintf("%s/acme/%s/new-nonce", baseURL.String(), provName),
-				NewAccount: fmt.Sprintf("%s/acme/%s/new-account", baseURL.String(), provName),
-				NewOrder:   fmt.Sprintf("%s/acme/%s/new-order", baseURL.String(), provName),
-				RevokeCert: fmt.Sprintf("%s/acme/%s/revoke-cert", baseURL.String(), provName),
-				KeyChange:  fmt.Sprintf("%s/acme/%s/key-change", baseURL.String(), provName),
+				NewNonce:    fmt.Sprintf("%s/acme/%s/new-nonce", baseURL.String(), provName),
+				NewAccount:  fmt.Sprintf("%s/acme/%s/new-account", baseURL.String(), provName),
+				NewOrder:    fmt.Sprintf("%s/acme/%s/new-order", baseURL.String(), provName),
+				NewAuthz:    fmt.Sprintf("%s/acme/%s/new-authz", baseURL.String(), provName),
+				RevokeCert:  fmt.Sprintf("%s/acme/%s/revoke-cert", baseURL.String(), provName),
+				KeyChange:   fmt.Sprintf("%s/acme/%s/key-change", baseURL.String(), provName),
+				RenewalInfo: fmt.Sprintf("%s/acme/%s/renewal-info", baseURL.String(), provName),
 				Meta: &Meta{
 					TermsOfService:          "https://terms.ca.local/",
 					Website:                 "https://ca.local/",
@@ -170,6 +180,59 @@ func TestHandler_GetDirectory(t *testing.T) {
 				statusCode: 200,
 			}
 		},
+		"ok/profiles-meta": func(t *testing.T) test {
+			prov := newACMEProvWithProfiles(t)
+			provName := url.PathEscape(prov.GetName())
+			baseURL := &url.URL{Scheme: "https", Host: "test.ca.smallstep.com"}
+			ctx := acme.NewProvisionerContext(context.Background(), prov)
+			expDir := Directory{
+				NewNonce:    fmt.Sprintf("%s/acme/%s/new-nonce", baseURL.String(), provName),
+				NewAccount:  fmt.Sprintf("%s/acme/%s/new-account", baseURL.String(), provName),
+				NewOrder:    fmt.Sprintf("%s/acme/%s/new-order", baseURL.String(), provName),
+				NewAuthz:    fmt.Sprintf("%s/acme/%s/new-authz", baseURL.String(), provName),
+				RevokeCert:  fmt.Sprintf("%s/acme/%s/revoke-cert", baseURL.String(), provName),
+				KeyChange:   fmt.Sprintf("%s/acme/%s/key-change", baseURL.String(), provName),
+				RenewalInfo: fmt.Sprintf("%s/acme/%s/renewal-info", baseURL.String(), provName),
+				Meta: &Meta{
+					Profiles: map[string]string{
+						"tlsserver": "Server certificates",
+						"mtls":      "Client and server certificates",
+					},
+				},
+			}
+			return test{
+				ctx:        ctx,
+				dir:        expDir,
+				statusCode: 200,
+			}
+		},
+		"ok/auto-renewal-meta": func(t *testing.T) test {
+			prov := newAutoRenewalProv(t)
+			provName := url.PathEscape(prov.GetName())
+			baseURL := &url.URL{Scheme: "https", Host: "test.ca.smallstep.com"}
+			ctx := acme.NewProvisionerContext(context.Background(), prov)
+			expDir := Directory{
+				NewNonce:    fmt.Sprintf("%s/acme/%s/new-nonce", baseURL.String(), provName),
+				NewAccount:  fmt.Sprintf("%s/acme/%s/new-account", baseURL.String(), provName),
+				NewOrder:    fmt.Sprintf("%s/acme/%s/new-order", baseURL.String(), provName),
+				NewAuthz:    fmt.Sprintf("%s/acme/%s/new-authz", baseURL.String(), provName),
+				RevokeCert:  fmt.Sprintf("%s/acme/%s/revoke-cert", baseURL.String(), provName),
+				KeyChange:   fmt.Sprintf("%s/acme/%s/key-change", baseURL.String(), provName),
+				RenewalInfo: fmt.Sprintf("%s/acme/%s/renewal-info", baseURL.String(), provName),
+				Meta: &Meta{
+					AutoRenewal: &AutoRenewalMeta{
+						MinLifetime:         3600,
+						MaxDuration:         31536000,
+						AllowCertificateGet: true,
+					},
+				},
+			}
+			return test{
+				ctx:        ctx,
+				dir:        expDir,
+				statusCode: 200,
+			}
+		},
 	}
 	for name, run := range tests {
 		tc := run(t)
@@ -380,6 +443,77 @@ func TestHandler_GetAuthorization(t *testing.T) {
 	}
 }
 
+func TestHandler_GetChallenge_async(t *testing.T) {
+	chiCtx := chi.NewRouteContext()
+	chiCtx.URLParams.Add("chID", "chID")
+	chiCtx.URLParams.Add("authzID", "authzID")
+	prov := newProv()
+	u := fmt.Sprintf("https://test.ca.smallstep.com/acme/%s/challenge/authzID/chID", url.PathEscape(prov.GetName()))
+
+	validator, err := acme.NewAsyncValidator(acme.AsyncValidatorOptions{
+		Workers:    1,
+		MinBackoff: 5 * time.Second,
+		MaxBackoff: time.Minute,
+	})
+	assert.FatalError(t, err)
+
+	for _, status := range []acme.Status{acme.StatusPending, acme.StatusProcessing} {
+		t.Run(string(status), func(t *testing.T) {
+			var saved, updated bool
+			db := &acme.MockDB{
+				MockGetChallenge: func(ctx context.Context, chID, azID string) (*acme.Challenge, error) {
+					return &acme.Challenge{
+						ID:        "chID",
+						Status:    status,
+						Type:      acme.HTTP01,
+						AccountID: "accID",
+					}, nil
+				},
+				MockSaveValidationJob: func(ctx context.Context, job *acme.ValidationJob) error {
+					assert.Equals(t, job.ChallengeID, "chID")
+					assert.Equals(t, job.AuthorizationID, "authzID")
+					assert.Equals(t, job.ProvisionerName, prov.GetName())
+					saved = true
+					return nil
+				},
+				MockUpdateChallenge: func(ctx context.Context, ch *acme.Challenge) error {
+					assert.Equals(t, ch.Status, acme.StatusProcessing)
+					updated = true
+					return nil
+				},
+			}
+
+			acc := &acme.Account{ID: "accID"}
+			ctx := acme.NewProvisionerContext(context.Background(), prov)
+			ctx = context.WithValue(ctx, accContextKey, acc)
+			ctx = context.WithValue(ctx, payloadContextKey, &payloadInfo{isEmptyJSON: true})
+			_jwk, err := jose.GenerateJWK("EC", "P-256", "ES256", "sig", "", 0)
+			assert.FatalError(t, err)
+			_pub := _jwk.Public()
+			ctx = context.WithValue(ctx, jwkContextKey, &_pub)
+			ctx = context.WithValue(ctx, chi.RouteCtxKey, chiCtx)
+			ctx = acme.NewContext(ctx, db, nil, acme.NewLinker("test.ca.smallstep.com", "acme"), nil)
+			ctx = acme.NewAsyncValidatorContext(ctx, validator)
+
+			req := httptest.NewRequest("POST", u, http.NoBody).WithContext(ctx)
+			w := httptest.NewRecorder()
+			GetChallenge(w, req)
+			res := w.Result()
+
+			assert.Equals(t, res.StatusCode, 200)
+			assert.Equals(t, res.Header.Get("Retry-After"), "5")
+			assert.Equals(t, saved, status == acme.StatusPending)
+			assert.Equals(t, updated, status == acme.StatusPending)
+
+			var ch acme.Challenge
+			assert.FatalError(t, json.NewDecoder(res.Body).Decode(&ch))
+			res.Body.Close()
+			assert.Equals(t, ch.Status, acme.StatusProcessing)
+			assert.Equals(t, ch.URL, u)
+		})
+	}
+}
+
 func TestHandler_GetCertificate(t *testing.T) {
 	leaf, err := pemutil.ReadCertificate("../../authority/testdata/certs/foo.crt")
 	assert.FatalError(t, err)
@@ -517,6 +651,82 @@ func TestHandler_GetCertificate(t *testing.T) {
 	}
 }
 
+func TestHandler_GetStarCertificate(t *testing.T) {
+	prov := newProv()
+	leaf, _ := mustRenewalInfoCertificate(t, "cdn.example.com")
+	chiCtx := chi.NewRouteContext()
+	chiCtx.URLParams.Add("ordID", "ordID")
+	now := time.Now()
+
+	newOrder := func(status acme.Status, allowGet bool) *acme.Order {
+		return &acme.Order{
+			ID:            "ordID",
+			AccountID:     "accID",
+			ProvisionerID: prov.GetID(),
+			Status:        status,
+			CertificateID: "certID",
+			AutoRenewal: &acme.AutoRenewal{
+				StartDate:           now,
+				EndDate:             now.Add(24 * time.Hour),
+				Lifetime:            3600,
+				AllowCertificateGet: allowGet,
+			},
+		}
+	}
+
+	tests := []struct {
+		name       string
+		method     string
+		acc        *acme.Account
+		order      *acme.Order
+		wantStatus int
+		wantType   string
+	}{
+		{"ok/post-as-get", "POST", &acme.Account{ID: "accID"}, newOrder(acme.StatusValid, false), 200, ""},
+		{"ok/get", "GET", nil, newOrder(acme.StatusValid, true), 200, ""},
+		{"fail/get-not-allowed", "GET", nil, newOrder(acme.StatusValid, false), 401, "urn:ietf:params:acme:error:unauthorized"},
+		{"fail/account", "POST", &acme.Account{ID: "otherID"}, newOrder(acme.StatusValid, false), 401, "urn:ietf:params:acme:error:unauthorized"},
+		{"fail/canceled", "POST", &acme.Account{ID: "accID"}, newOrder(acme.StatusCanceled, false), 403, "urn:ietf:params:acme:error:autoRenewalCanceled"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := &acme.MockDB{
+				MockGetOrder: func(ctx context.Context, id string) (*acme.Order, error) {
+					return tt.order, nil
+				},
+				MockGetCertificate: func(ctx context.Context, id string) (*acme.Certificate, error) {
+					return &acme.Certificate{ID: id, AccountID: "accID", Leaf: leaf}, nil
+				},
+			}
+			ctx := newBaseContext(context.Background(), db)
+			ctx = acme.NewProvisionerContext(ctx, prov)
+			if tt.acc != nil {
+				ctx = context.WithValue(ctx, accContextKey, tt.acc)
+			}
+			ctx = context.WithValue(ctx, chi.RouteCtxKey, chiCtx)
+			req := httptest.NewRequest(tt.method, "/order/ordID/star-certificate", http.NoBody).WithContext(ctx)
+			w := httptest.NewRecorder()
+			GetStarCertificate(w, req)
+			res := w.Result()
+			body, err := io.ReadAll(res.Body)
+			res.Body.Close()
+			assert.FatalError(t, err)
+
+			assert.Equals(t, res.StatusCode, tt.wantStatus)
+			if tt.wantType != "" {
+				var ae acme.Error
+				assert.FatalError(t, json.Unmarshal(body, &ae))
+				assert.Equals(t, ae.Type, tt.wantType)
+				return
+			}
+			assert.Equals(t, body, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: leaf.Raw}))
+			assert.Equals(t, res.Header.Get("Content-Type"), "application/pem-certificate-chain")
+			assert.Equals(t, res.Header.Get("Cert-Not-Before"), leaf.NotBefore.UTC().Format(http.TimeFormat))
+			assert.Equals(t, res.Header.Get("Cert-Not-After"), leaf.NotAfter.UTC().Format(http.TimeFormat))
+		})
+	}
+}
+
 func TestHandler_GetChallenge(t *testing.T) {
 	chiCtx := chi.NewRouteContext()
 	chiCtx.URLParams.Add("chID", "chID")
@@ -0,0 +1,198 @@
+package api
+
+import (
+	"crypto/rsa"
+	"encoding/json"
+	"net/http"
+
+	"go.step.sm/crypto/jose"
+	"go.step.sm/crypto/keyutil"
+
+	"github.com/smallstep/certificates/acme"
+	"github.com/smallstep/certificates/api/render"
+)
+
+// KeyChangeRequest is the payload of the inner JWS of a key-change request as
+// defined in RFC 8555, section 7.3.5.
+type KeyChangeRequest struct {
+	Account string           `json:"account"`
+	OldKey  *jose.JSONWebKey `json:"oldKey"`
+}
+
+// Validate validates a key-change request body.
+func (k *KeyChangeRequest) Validate() error {
+	switch {
+	case k.Account == "":
+		return acme.NewError(acme.ErrorMalformedType, "account cannot be empty")
+	case k.OldKey == nil:
+		return acme.NewError(acme.ErrorMalformedType, "oldKey cannot be empty")
+	case !k.OldKey.Valid():
+		return acme.NewError(acme.ErrorMalformedType, "invalid oldKey")
+	default:
+		return nil
+	}
+}
+
+// KeyChange is the handler that implements the ACME account key rollover. The
+// payload of the request is a JWS signed by the new key, with a payload
+// containing the account URL and the old key.
+func KeyChange(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	db := acme.MustDatabaseFromContext(ctx)
+	linker := acme.MustLinkerFromContext(ctx)
+
+	acc, err := accountFromContext(ctx)
+	if err != nil {
+		render.Error(w, r, err)
+		return
+	}
+	outer, err := jwsFromContext(ctx)
+	if err != nil {
+		render.Error(w, r, err)
+		return
+	}
+	payload, err := payloadFromContext(ctx)
+	if err != nil {
+		render.Error(w, r, err)
+		return
+	}
+
+	inner, err := jose.ParseJWS(string(payload.value))
+	if err != nil {
+		render.Error(w, r, acme.WrapError(acme.ErrorMalformedType, err, "failed to parse inner JWS"))
+		return
+	}
+	newKey, err := validateKeyChangeJWS(outer, inner)
+	if err != nil {
+		render.Error(w, r, err)
+		return
+	}
+	innerPayload, err := inner.Verify(newKey)
+	if err != nil {
+		render.Error(w, r, acme.WrapError(acme.ErrorMalformedType, err, "error verifying inner jws"))
+		return
+	}
+
+	var kcr KeyChangeRequest
+	if err := json.Unmarshal(innerPayload, &kcr); err != nil {
+		render.Error(w, r, acme.WrapError(acme.ErrorMalformedType, err,
+			"failed to unmarshal key-change request payload"))
+		return
+	}
+	if err := kcr.Validate(); err != nil {
+		render.Error(w, r, err)
+		return
+	}
+
+	// The account in the inner payload must be the one that signed the outer
+	// JWS, and the old key must be its current key.
+	if kcr.Account != outer.Signatures[0].Protected.KeyID {
+		render.Error(w, r, acme.NewError(acme.ErrorUnauthorizedType,
+			"key-change account does not match the kid in the outer jws"))
+		return
+	}
+	oldKid, err := acme.KeyToID(kcr.OldKey)
+	if err != nil {
+		render.Error(w, r, acme.WrapErrorISE(err, "error getting KeyID from oldKey"))
+		return
+	}
+	accKid, err := acme.KeyToID(acc.Key)
+	if err != nil {
+		render.Error(w, r, acme.WrapErrorISE(err, "error getting KeyID from account key"))
+		return
+	}
+	if oldKid != accKid {
+		render.Error(w, r, acme.NewError(acme.ErrorUnauthorizedType,
+			"key-change oldKey does not match the account key"))
+		return
+	}
+
+	// Reject keys already bound to other accounts, the response must include
+	// the location of the account using the key.
+	if newKey.KeyID, err = acme.KeyToID(newKey); err != nil {
+		render.Error(w, r, acme.WrapErrorISE(err, "error getting KeyID from new key"))
+		return
+	}
+	existing, err := db.GetAccountByKeyID(ctx, newKey.KeyID)
+	switch {
+	case acme.IsErrNotFound(err):
+		break
+	case err != nil:
+		render.Error(w, r, acme.WrapErrorISE(err, "error retrieving account by key"))
+		return
+	default:
+		w.Header().Set("Location", linker.GetLink(ctx, acme.AccountLinkType, existing.ID))
+		acmeErr := acme.NewError(acme.ErrorMalformedType, "key is already in use by another account")
+		acmeErr.Status = http.StatusConflict
+		render.Error(w, r, acmeErr)
+		return
+	}
+
+	acc.Key = newKey
+	if err := db.UpdateAccountKey(ctx, acc); err != nil {
+		render.Error(w, r, acme.WrapErrorISE(err, "error updating account key"))
+		return
+	}
+
+	linker.LinkAccount(ctx, acc)
+
+	w.Header().Set("Location", linker.GetLink(ctx, acme.AccountLinkType, acc.ID))
+	render.JSON(w, r, acc)
+}
+
+// validateKeyChangeJWS validates the inner JWS of a key-change request and
+// returns the new key. The inner JWS must be signed with the new key included
+// in the jwk header, it must not have a nonce and its url must match the one
+// in the outer JWS.
+func validateKeyChangeJWS(outer, inner *jose.JSONWebSignature) (*jose.JSONWebKey, error) {
+	if len(inner.Signatures) != 1 {
+		return nil, acme.NewError(acme.ErrorMalformedType, "inner jws must contain exactly one signature")
+	}
+
+	sig := inner.Signatures[0]
+	uh := sig.Unprotected
+	if uh.KeyID != "" || uh.JSONWebKey != nil || uh.Algorithm != "" || uh.Nonce != "" || len(uh.ExtraHeaders) > 0 {
+		return nil, acme.NewError(acme.ErrorMalformedType, "unprotected header must not be used")
+	}
+
+	hdr := sig.Protected
+	if hdr.KeyID != "" {
+		return nil, acme.NewError(acme.ErrorMalformedType, "inner jws must not have a kid")
+	}
+	if hdr.Nonce != "" {
+		return nil, acme.NewError(acme.ErrorMalformedType, "inner jws must not have a nonce")
+	}
+	jwk := hdr.JSONWebKey
+	if jwk == nil || !jwk.Valid() || !jwk.IsPublic() {
+		return nil, acme.NewError(acme.ErrorMalformedType, "inner jws must have a valid jwk")
+	}
+
+	switch hdr.Algorithm {
+	case jose.RS256, jose.RS384, jose.RS512, jose.PS256, jose.PS384, jose.PS512:
+		k, ok := jwk.Key.(*rsa.PublicKey)
+		if !ok {
+			return nil, acme.NewError(acme.ErrorMalformedType, "jws key type and algorithm do not match")
+		}
+		if k.Size() < keyutil.MinRSAKeyBytes {
+			return nil, acme.NewError(acme.ErrorMalformedType,
+				"rsa keys must be at least %d bits (%d bytes) in size",
+				8*keyutil.MinRSAKeyBytes, keyutil.MinRSAKeyBytes)
+		}
+	case jose.ES256, jose.ES384, jose.ES512, jose.EdDSA:
+		// we good
+	default:
+		return nil, acme.NewError(acme.ErrorBadSignatureAlgorithmType, "unsuitable algorithm: %s", hdr.Algorithm)
+	}
+
+	outerURL, _ := outer.Signatures[0].Protected.ExtraHeaders["url"].(string)
+	innerURL, ok := hdr.ExtraHeaders["url"].(string)
+	switch {
+	case !ok:
+		return nil, acme.NewError(acme.ErrorMalformedType, "inner jws missing url protected header")
+	case innerURL != outerURL:
+		return nil, acme.NewError(acme.ErrorMalformedType,
+			"url header in inner jws (%s) does not match outer jws url (%s)", innerURL, outerURL)
+	}
+
+	return jwk, nil
+}
@@ -0,0 +1,139 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.step.sm/crypto/jose"
+
+	"github.com/smallstep/certificates/acme"
+)
+
+func mustKeyChangeJWS(t *testing.T, key *jose.JSONWebKey, headers map[jose.HeaderKey]interface{}, embedJWK bool, payload []byte) *jose.JSONWebSignature {
+	t.Helper()
+	signer, err := jose.NewSigner(jose.SigningKey{
+		Algorithm: jose.SignatureAlgorithm(key.Algorithm),
+		Key:       key.Key,
+	}, &jose.SignerOptions{
+		ExtraHeaders: headers,
+		EmbedJWK:     embedJWK,
+	})
+	require.NoError(t, err)
+	jws, err := signer.Sign(payload)
+	require.NoError(t, err)
+	raw, err := jws.CompactSerialize()
+	require.NoError(t, err)
+	jws, err = jose.ParseJWS(raw)
+	require.NoError(t, err)
+	return jws
+}
+
+func TestKeyChange(t *testing.T) {
+	prov := newProv()
+	provName := url.PathEscape(prov.GetName())
+	u := fmt.Sprintf("https://test.ca.smallstep.com/acme/%s/key-change", provName)
+	kid := fmt.Sprintf("https://test.ca.smallstep.com/acme/%s/account/accID", provName)
+
+	oldKey, err := jose.GenerateJWK("EC", "P-256", "ES256", "sig", "", 0)
+	require.NoError(t, err)
+	newKey, err := jose.GenerateJWK("EC", "P-256", "ES256", "sig", "", 0)
+	require.NoError(t, err)
+	oldPub := oldKey.Public()
+	newPub := newKey.Public()
+
+	outer := mustKeyChangeJWS(t, oldKey, map[jose.HeaderKey]interface{}{
+		"kid": kid, "url": u,
+	}, false, []byte("{}"))
+
+	innerPayload := func(account string, key *jose.JSONWebKey) []byte {
+		b, err := json.Marshal(KeyChangeRequest{Account: account, OldKey: key})
+		require.NoError(t, err)
+		return b
+	}
+	innerJWS := func(headers map[jose.HeaderKey]interface{}, payload []byte) []byte {
+		jws := mustKeyChangeJWS(t, newKey, headers, true, payload)
+		raw, err := jws.CompactSerialize()
+		require.NoError(t, err)
+		return []byte(raw)
+	}
+	validInner := innerJWS(map[jose.HeaderKey]interface{}{"url": u}, innerPayload(kid, &oldPub))
+
+	tests := []struct {
+		name         string
+		payload      []byte
+		db           acme.DB
+		wantStatus   int
+		wantLocation string
+	}{
+		{"ok", validInner, &acme.MockDB{
+			MockGetAccountByKeyID: func(ctx context.Context, kid string) (*acme.Account, error) {
+				return nil, acme.ErrNotFound
+			},
+			MockUpdateAccountKey: func(ctx context.Context, acc *acme.Account) error {
+				assert.Equal(t, "accID", acc.ID)
+				newKid, err := acme.KeyToID(&newPub)
+				require.NoError(t, err)
+				assert.Equal(t, newKid, acc.Key.KeyID)
+				return nil
+			},
+		}, http.StatusOK, kid},
+		{"fail/inner-jws", []byte("foo"), &acme.MockDB{}, http.StatusBadRequest, ""},
+		{"fail/inner-nonce", innerJWS(map[jose.HeaderKey]interface{}{"url": u, "nonce": "nonce"}, innerPayload(kid, &oldPub)), &acme.MockDB{}, http.StatusBadRequest, ""},
+		{"fail/inner-url", innerJWS(map[jose.HeaderKey]interface{}{"url": u + "/foo"}, innerPayload(kid, &oldPub)), &acme.MockDB{}, http.StatusBadRequest, ""},
+		{"fail/inner-payload", innerJWS(map[jose.HeaderKey]interface{}{"url": u}, []byte("foo")), &acme.MockDB{}, http.StatusBadRequest, ""},
+		{"fail/account", innerJWS(map[jose.HeaderKey]interface{}{"url": u}, innerPayload(kid+"foo", &oldPub)), &acme.MockDB{}, http.StatusUnauthorized, ""},
+		{"fail/oldKey", innerJWS(map[jose.HeaderKey]interface{}{"url": u}, innerPayload(kid, &newPub)), &acme.MockDB{}, http.StatusUnauthorized, ""},
+		{"fail/key-in-use", validInner, &acme.MockDB{
+			MockGetAccountByKeyID: func(ctx context.Context, kid string) (*acme.Account, error) {
+				return &acme.Account{ID: "otherID"}, nil
+			},
+		}, http.StatusConflict, fmt.Sprintf("https://test.ca.smallstep.com/acme/%s/account/otherID", provName)},
+		{"fail/db.GetAccountByKeyID", validInner, &acme.MockDB{
+			MockGetAccountByKeyID: func(ctx context.Context, kid string) (*acme.Account, error) {
+				return nil, errors.New("force")
+			},
+		}, http.StatusInternalServerError, ""},
+		{"fail/db.UpdateAccountKey", validInner, &acme.MockDB{
+			MockGetAccountByKeyID: func(ctx context.Context, kid string) (*acme.Account, error) {
+				return nil, acme.ErrNotFound
+			},
+			MockError: errors.New("force"),
+		}, http.StatusInternalServerError, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			acc := &acme.Account{ID: "accID", Key: &oldPub, Status: acme.StatusValid}
+			ctx := acme.NewProvisionerContext(context.Background(), prov)
+			ctx = context.WithValue(ctx, accContextKey, acc)
+			ctx = context.WithValue(ctx, jwsContextKey, outer)
+			ctx = context.WithValue(ctx, payloadContextKey, &payloadInfo{value: tt.payload})
+			ctx = newBaseContext(ctx, tt.db, acme.NewLinker("test.ca.smallstep.com", "acme"))
+
+			req := httptest.NewRequest("POST", u, http.NoBody).WithContext(ctx)
+			w := httptest.NewRecorder()
+			KeyChange(w, req)
+			res := w.Result()
+
+			body, err := io.ReadAll(res.Body)
+			res.Body.Close()
+			require.NoError(t, err)
+
+			assert.Equal(t, tt.wantStatus, res.StatusCode, string(body))
+			assert.Equal(t, tt.wantLocation, res.Header.Get("Location"))
+			if tt.wantStatus == http.StatusOK {
+				var got acme.Account
+				require.NoError(t, json.Unmarshal(body, &got))
+				assert.Equal(t, acme.StatusValid, got.Status)
+			}
+		})
+	}
+}
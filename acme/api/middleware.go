@@ -258,6 +258,10 @@ func extractJWK(next nextHTTP) nextHTTP {
 				render.Error(w, r, acme.NewError(acme.ErrorUnauthorizedType, "account is not active"))
 				return
 			}
+			if err := validateAccountExternalAccountKey(ctx, acc); err != nil {
+				render.Error(w, r, err)
+				return
+			}
 			ctx = context.WithValue(ctx, accContextKey, acc)
 		}
 		next(w, r.WithContext(ctx))
@@ -321,6 +325,11 @@ func lookupJWK(next nextHTTP) nextHTTP {
 				return
 			}
 
+			if err := validateAccountExternalAccountKey(ctx, acc); err != nil {
+				render.Error(w, r, err)
+				return
+			}
+
 			if storedLocation := acc.GetLocation(); storedLocation != "" {
 				if kid != storedLocation {
 					// ACME accounts should have a stored location equivalent to the
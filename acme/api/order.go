@@ -5,9 +5,11 @@ import (
 	"crypto/x509"
 	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net"
 	"net/http"
+	"net/mail"
 	"strings"
 	"time"
 
@@ -28,6 +30,9 @@ type NewOrderRequest struct {
 	Identifiers []acme.Identifier `json:"identifiers"`
 	NotBefore   time.Time         `json:"notBefore,omitempty"`
 	NotAfter    time.Time         `json:"notAfter,omitempty"`
+	Replaces    string            `json:"replaces,omitempty"`
+	Profile     string            `json:"profile,omitempty"`
+	AutoRenewal *acme.AutoRenewal `json:"auto-renewal,omitempty"`
 }
 
 // Validate validates a new-order request body.
@@ -54,11 +59,23 @@ func (n *NewOrderRequest) Validate() error {
 			// validation of Wire identifiers is performed in `validateWireIdentifiers`, but
 			// marked here as known and supported types.
 			continue
+		case acme.EMAIL:
+			// RFC 8823 requires a bare email address, without a display name.
+			addr, err := mail.ParseAddress(id.Value)
+			if err != nil || addr.Name != "" || addr.Address != id.Value {
+				return acme.NewError(acme.ErrorMalformedType, "invalid email address: %s", id.Value)
+			}
 		default:
 			return acme.NewError(acme.ErrorMalformedType, "identifier type unsupported: %s", id.Type)
 		}
 	}
 
+	// Certificates for email addresses are S/MIME certificates, they cannot
+	// include other identifiers.
+	if emails := identifiersOfType(acme.EMAIL, n.Identifiers); len(emails) > 0 && len(emails) != len(n.Identifiers) {
+		return acme.NewError(acme.ErrorMalformedType, "email identifiers cannot be combined with other identifier types")
+	}
+
 	if err := n.validateWireIdentifiers(); err != nil {
 		return acme.WrapError(acme.ErrorMalformedType, err, "failed validating Wire identifiers")
 	}
@@ -165,7 +182,6 @@ var defaultOrderBackdate = time.Minute
 // NewOrder ACME api for creating a new order.
 func NewOrder(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
-	ca := mustAuthority(ctx)
 	db := acme.MustDatabaseFromContext(ctx)
 	linker := acme.MustLinkerFromContext(ctx)
 
@@ -206,35 +222,46 @@ func NewOrder(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	var eak *acme.ExternalAccountKey
-	if acmeProv.RequireEAB {
-		if eak, err = db.GetExternalAccountKeyByAccountID(ctx, prov.GetID(), acc.ID); err != nil {
-			render.Error(w, r, acme.WrapErrorISE(err, "error retrieving external account binding key"))
+	// Validate the auto-renewal object of STAR orders, RFC 8739.
+	if nor.AutoRenewal != nil {
+		if err := validateAutoRenewal(acmeProv, &nor); err != nil {
+			render.Error(w, r, err)
 			return
 		}
 	}
 
-	acmePolicy, err := newACMEPolicyEngine(eak)
-	if err != nil {
-		render.Error(w, r, acme.WrapErrorISE(err, "error creating ACME policy engine"))
+	// Select the certificate profile, if any. The profile is added to the
+	// context so the provisioner policy of the profile is used.
+	defaultDuration := prov.DefaultTLSCertDuration()
+	if nor.Profile != "" {
+		profile, ok := prov.GetProfile(nor.Profile)
+		if !ok {
+			render.Error(w, r, acme.NewError(acme.ErrorInvalidProfileType, "profile %q is not supported", nor.Profile))
+			return
+		}
+		defaultDuration = profile.DefaultTLSCertDuration()
+		ctx = provisioner.NewContextWithACMEProfile(ctx, nor.Profile)
+	}
+
+	if err := authorizeIdentifiers(ctx, acmeProv, acc, nor.Identifiers); err != nil {
+		render.Error(w, r, err)
 		return
 	}
 
-	for _, identifier := range nor.Identifiers {
-		// evaluate the ACME account level policy
-		if err = isIdentifierAllowed(acmePolicy, identifier); err != nil {
-			render.Error(w, r, acme.WrapError(acme.ErrorRejectedIdentifierType, err, "not authorized"))
-			return
-		}
-		// evaluate the provisioner level policy
-		orderIdentifier := provisioner.ACMEIdentifier{Type: provisioner.ACMEIdentifierType(identifier.Type), Value: identifier.Value}
-		if err = prov.AuthorizeOrderIdentifier(ctx, orderIdentifier); err != nil {
-			render.Error(w, r, acme.WrapError(acme.ErrorRejectedIdentifierType, err, "not authorized"))
-			return
-		}
-		// evaluate the authority level policy
-		if err = ca.AreSANsAllowed(ctx, []string{identifier.Value}); err != nil {
-			render.Error(w, r, acme.WrapError(acme.ErrorRejectedIdentifierType, err, "not authorized"))
+	if err := acme.ConsumeRateLimit(ctx, db, acme.RateLimitKey{
+		ProvisionerID: prov.GetID(),
+		Name:          newOrdersPerAccountRateLimit,
+		Value:         acc.ID,
+	}, rateLimitsOf(prov).NewOrdersPerAccount); err != nil {
+		render.Error(w, r, err)
+		return
+	}
+
+	// Validate the certificate to replace, RFC 9773.
+	var replacedBy string
+	if nor.Replaces != "" {
+		if replacedBy, err = validateReplaces(ctx, db, acc, &nor); err != nil {
+			render.Error(w, r, err)
 			return
 		}
 	}
@@ -250,9 +277,30 @@ func NewOrder(w http.ResponseWriter, r *http.Request) {
 		AuthorizationIDs: make([]string, len(nor.Identifiers)),
 		NotBefore:        nor.NotBefore,
 		NotAfter:         nor.NotAfter,
+		Replaces:         nor.Replaces,
+		Profile:          nor.Profile,
+		AutoRenewal:      nor.AutoRenewal,
+	}
+
+	// Valid authorizations of the account, including pre-authorizations
+	// created using the new-authz resource, are reused, RFC 8555 section 7.4.1.
+	azs, err := db.GetAuthorizationsByAccountID(ctx, acc.ID)
+	if err != nil {
+		render.Error(w, r, acme.WrapErrorISE(err, "error retrieving authorizations"))
+		return
 	}
 
+	reused := 0
+	var newAzs []*acme.Authorization
 	for i, identifier := range o.Identifiers {
+		if az := findReusableAuthorization(azs, identifier, now); az != nil {
+			o.AuthorizationIDs[i] = az.ID
+			if az.ExpiresAt.Before(o.ExpiresAt) {
+				o.ExpiresAt = az.ExpiresAt
+			}
+			reused++
+			continue
+		}
 		az := &acme.Authorization{
 			AccountID:  acc.ID,
 			Identifier: identifier,
@@ -264,13 +312,23 @@ func NewOrder(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 		o.AuthorizationIDs[i] = az.ID
+		newAzs = append(newAzs, az)
+	}
+	if reused == len(o.Identifiers) {
+		o.Status = acme.StatusReady
 	}
 
+	// The certificates of auto-renewal orders are valid between the start
+	// and the end date of the order.
+	if o.AutoRenewal != nil {
+		o.NotBefore = o.AutoRenewal.StartDate
+		o.NotAfter = o.AutoRenewal.EndDate
+	}
 	if o.NotBefore.IsZero() {
 		o.NotBefore = now
 	}
 	if o.NotAfter.IsZero() {
-		o.NotAfter = o.NotBefore.Add(prov.DefaultTLSCertDuration())
+		o.NotAfter = o.NotBefore.Add(defaultDuration)
 	}
 	// If request NotBefore was empty then backdate the order.NotBefore (now)
 	// to avoid timing issues.
@@ -283,12 +341,182 @@ func NewOrder(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Reserve the certificate to replace, so concurrent orders cannot replace
+	// the same certificate. The order is invalidated if it cannot be reserved.
+	if o.Replaces != "" {
+		if err := o.ReserveReplaced(ctx, db, replacedBy); err != nil {
+			var ae *acme.Error
+			if errors.As(err, &ae) {
+				o.Error = ae
+			}
+			o.Status = acme.StatusInvalid
+			if err := db.UpdateOrder(ctx, o); err != nil {
+				render.Error(w, r, acme.WrapErrorISE(err, "error updating order"))
+				return
+			}
+			render.Error(w, r, err)
+			return
+		}
+	}
+
+	acme.SendEmailReply00Challenges(ctx, newAzs...)
+
 	linker.LinkOrder(ctx, o)
 
 	w.Header().Set("Location", linker.GetLink(ctx, acme.OrderLinkType, o.ID))
 	render.JSONStatus(w, r, o, http.StatusCreated)
 }
 
+// validateReplaces checks that the certificate in the replaces field of a
+// new-order request exists, is owned by the account, has not been replaced by
+// a different order, and shares at least one identifier with the request. It
+// returns the order that reserved the certificate, if it was released.
+func validateReplaces(ctx context.Context, db acme.DB, acc *acme.Account, nor *NewOrderRequest) (string, error) {
+	cert, err := acme.GetCertificateByID(ctx, db, nor.Replaces)
+	if err != nil {
+		return "", err
+	}
+	if cert.AccountID != acc.ID {
+		return "", acme.NewError(acme.ErrorUnauthorizedType,
+			"account '%s' does not own certificate '%s'", acc.ID, nor.Replaces)
+	}
+	if cert.ReplacedBy != "" {
+		// The certificate can be replaced again if the order that reserved it
+		// is invalid, has expired, or has been removed.
+		o, err := db.GetOrder(ctx, cert.ReplacedBy)
+		var ae *acme.Error
+		switch {
+		case err == nil && o.IsReplacing():
+			return "", acme.NewError(acme.ErrorAlreadyReplacedType,
+				"certificate '%s' has already been replaced by order '%s'", nor.Replaces, cert.ReplacedBy)
+		case err != nil && !acme.IsErrNotFound(err) && !errors.As(err, &ae):
+			return "", acme.WrapErrorISE(err, "error retrieving order %s", cert.ReplacedBy)
+		}
+	}
+
+	sans := make(map[string]struct{})
+	for _, name := range cert.Leaf.DNSNames {
+		sans[strings.ToLower(name)] = struct{}{}
+	}
+	for _, ip := range cert.Leaf.IPAddresses {
+		sans[ip.String()] = struct{}{}
+	}
+	if len(sans) == 0 {
+		return cert.ReplacedBy, nil
+	}
+	for _, id := range nor.Identifiers {
+		value := id.Value
+		if id.Type == acme.IP {
+			if ip := net.ParseIP(value); ip != nil {
+				value = ip.String()
+			}
+		}
+		if _, ok := sans[strings.ToLower(value)]; ok {
+			return cert.ReplacedBy, nil
+		}
+	}
+	return "", acme.NewError(acme.ErrorMalformedType,
+		"order identifiers do not match any identifier of certificate '%s'", nor.Replaces)
+}
+
+// validateAutoRenewal checks that the auto-renewal object of a new-order
+// request is supported by the provisioner, and sets the default start date.
+func validateAutoRenewal(p *provisioner.ACME, nor *NewOrderRequest) error {
+	ar := nor.AutoRenewal
+	if p.AutoRenewal == nil {
+		return acme.NewError(acme.ErrorMalformedType, "auto-renewal orders are not supported")
+	}
+	if !nor.NotBefore.IsZero() || !nor.NotAfter.IsZero() {
+		return acme.NewError(acme.ErrorMalformedType, "notBefore and notAfter cannot be used in auto-renewal orders")
+	}
+	if len(identifiersOfType(acme.PermanentIdentifier, nor.Identifiers)) > 0 {
+		return acme.NewError(acme.ErrorMalformedType, "auto-renewal orders cannot contain permanent identifiers")
+	}
+
+	now := clock.Now().Truncate(time.Second)
+	if ar.StartDate.IsZero() {
+		ar.StartDate = now
+	}
+	ar.StartDate = ar.StartDate.Truncate(time.Second)
+	ar.EndDate = ar.EndDate.Truncate(time.Second)
+
+	switch {
+	case !ar.EndDate.After(ar.StartDate) || !ar.EndDate.After(now):
+		return acme.NewError(acme.ErrorMalformedType, "auto-renewal end-date must be after the start-date and the current time")
+	case ar.EndDate.Sub(ar.StartDate) > p.AutoRenewal.GetMaxDuration():
+		return acme.NewError(acme.ErrorMalformedType, "auto-renewal duration cannot be greater than %s", p.AutoRenewal.GetMaxDuration())
+	case ar.GetLifetime() < p.AutoRenewal.GetMinLifetime():
+		return acme.NewError(acme.ErrorMalformedType, "auto-renewal lifetime cannot be less than %s", p.AutoRenewal.GetMinLifetime())
+	case ar.LifetimeAdjust < 0:
+		return acme.NewError(acme.ErrorMalformedType, "auto-renewal lifetime-adjust cannot be negative")
+	case ar.AllowCertificateGet && !p.AutoRenewal.AllowCertificateGet:
+		return acme.NewError(acme.ErrorMalformedType, "auto-renewal allow-certificate-get is not supported")
+	}
+	return nil
+}
+
+// authorizeIdentifiers evaluates the ACME account, provisioner and authority
+// level policies for the given identifiers.
+func authorizeIdentifiers(ctx context.Context, acmeProv *provisioner.ACME, acc *acme.Account, identifiers []acme.Identifier) error {
+	ca := mustAuthority(ctx)
+	db := acme.MustDatabaseFromContext(ctx)
+	prov := acme.MustProvisionerFromContext(ctx)
+
+	var (
+		eak *acme.ExternalAccountKey
+		err error
+	)
+	if acmeProv.RequireEAB {
+		if eak, err = db.GetExternalAccountKeyByAccountID(ctx, prov.GetID(), acc.ID); err != nil {
+			return acme.WrapErrorISE(err, "error retrieving external account binding key")
+		}
+	}
+
+	acmePolicy, err := newACMEPolicyEngine(eak)
+	if err != nil {
+		return acme.WrapErrorISE(err, "error creating ACME policy engine")
+	}
+
+	for _, identifier := range identifiers {
+		// evaluate the ACME account level policy
+		if err = isIdentifierAllowed(acmePolicy, identifier); err != nil {
+			return acme.WrapError(acme.ErrorRejectedIdentifierType, err, "not authorized")
+		}
+		// evaluate the provisioner level policy
+		orderIdentifier := provisioner.ACMEIdentifier{Type: provisioner.ACMEIdentifierType(identifier.Type), Value: identifier.Value}
+		if err = prov.AuthorizeOrderIdentifier(ctx, orderIdentifier); err != nil {
+			return acme.WrapError(acme.ErrorRejectedIdentifierType, err, "not authorized")
+		}
+		// evaluate the authority level policy
+		if err = ca.AreSANsAllowed(ctx, []string{identifier.Value}); err != nil {
+			return acme.WrapError(acme.ErrorRejectedIdentifierType, err, "not authorized")
+		}
+	}
+
+	return nil
+}
+
+// findReusableAuthorization returns a valid and not expired authorization for
+// the given order identifier, or nil if there is none. Authorizations bound to
+// a device attestation are never reused.
+func findReusableAuthorization(azs []*acme.Authorization, identifier acme.Identifier, now time.Time) *acme.Authorization {
+	value, isWildcard := trimIfWildcard(identifier.Value)
+	for _, az := range azs {
+		switch {
+		case az.Status != acme.StatusValid:
+		case az.Fingerprint != "":
+		case !now.Before(az.ExpiresAt):
+		case az.Wildcard != isWildcard:
+		case az.Identifier.Type != identifier.Type:
+		case identifier.Type == acme.DNS && strings.EqualFold(az.Identifier.Value, value):
+			return az
+		case identifier.Type == acme.IP && net.ParseIP(az.Identifier.Value).Equal(net.ParseIP(value)):
+			return az
+		}
+	}
+	return nil
+}
+
 func isIdentifierAllowed(acmePolicy policy.X509Policy, identifier acme.Identifier) error {
 	if acmePolicy == nil {
 		return nil
@@ -373,7 +601,20 @@ func newAuthorization(ctx context.Context, az *acme.Authorization) error {
 			Status:    acme.StatusPending,
 			Target:    target,
 		}
-		if err := db.CreateChallenge(ctx, ch); err != nil {
+		if typ == acme.EMAILREPLY00 {
+			// The challenge email is sent once the authorization is stored,
+			// the number of emails sent to the same address is limited.
+			if err := acme.ConsumeRateLimit(ctx, db, acme.RateLimitKey{
+				ProvisionerID: prov.GetID(),
+				Name:          challengeEmailsPerAddressRateLimit,
+				Value:         strings.ToLower(ch.Value),
+			}, rateLimitsOf(prov).ChallengeEmailsPerAddress); err != nil {
+				return err
+			}
+			if err := acme.CreateEmailReply00Challenge(ctx, db, ch); err != nil {
+				return err
+			}
+		} else if err := db.CreateChallenge(ctx, ch); err != nil {
 			return acme.WrapErrorISE(err, "error creating challenge")
 		}
 		az.Challenges = append(az.Challenges, ch)
@@ -390,34 +631,72 @@ func GetOrder(w http.ResponseWriter, r *http.Request) {
 	db := acme.MustDatabaseFromContext(ctx)
 	linker := acme.MustLinkerFromContext(ctx)
 
-	acc, err := accountFromContext(ctx)
+	o, err := getAccountOrder(ctx, chi.URLParam(r, "ordID"))
 	if err != nil {
 		render.Error(w, r, err)
 		return
 	}
-	prov, err := provisionerFromContext(ctx)
+	if err = o.UpdateStatus(ctx, db); err != nil {
+		render.Error(w, r, acme.WrapErrorISE(err, "error updating order status"))
+		return
+	}
+
+	linker.LinkOrder(ctx, o)
+
+	w.Header().Set("Location", linker.GetLink(ctx, acme.OrderLinkType, o.ID))
+	render.JSON(w, r, o)
+}
+
+// UpdateOrderRequest represents the body for an order update request. The
+// only supported update is the cancellation of auto-renewal orders.
+type UpdateOrderRequest struct {
+	Status acme.Status `json:"status"`
+}
+
+// Validate validates an update-order request body.
+func (u *UpdateOrderRequest) Validate() error {
+	if u.Status != acme.StatusCanceled {
+		return acme.NewError(acme.ErrorMalformedType, "cannot update order status to '%s', only '%s' is supported",
+			u.Status, acme.StatusCanceled)
+	}
+	return nil
+}
+
+// GetOrUpdateOrder ACME api for retrieving an order using POST-as-GET
+// requests, or for canceling an auto-renewal order, RFC 8739.
+func GetOrUpdateOrder(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	db := acme.MustDatabaseFromContext(ctx)
+	linker := acme.MustLinkerFromContext(ctx)
+
+	payload, err := payloadFromContext(ctx)
 	if err != nil {
 		render.Error(w, r, err)
 		return
 	}
+	if payload.isPostAsGet {
+		GetOrder(w, r)
+		return
+	}
 
-	o, err := db.GetOrder(ctx, chi.URLParam(r, "ordID"))
-	if err != nil {
-		render.Error(w, r, acme.WrapErrorISE(err, "error retrieving order"))
+	var uor UpdateOrderRequest
+	if err := json.Unmarshal(payload.value, &uor); err != nil {
+		render.Error(w, r, acme.WrapError(acme.ErrorMalformedType, err,
+			"failed to unmarshal update-order request payload"))
 		return
 	}
-	if acc.ID != o.AccountID {
-		render.Error(w, r, acme.NewError(acme.ErrorUnauthorizedType,
-			"account '%s' does not own order '%s'", acc.ID, o.ID))
+	if err := uor.Validate(); err != nil {
+		render.Error(w, r, err)
 		return
 	}
-	if prov.GetID() != o.ProvisionerID {
-		render.Error(w, r, acme.NewError(acme.ErrorUnauthorizedType,
-			"provisioner '%s' does not own order '%s'", prov.GetID(), o.ID))
+
+	o, err := getAccountOrder(ctx, chi.URLParam(r, "ordID"))
+	if err != nil {
+		render.Error(w, r, err)
 		return
 	}
-	if err = o.UpdateStatus(ctx, db); err != nil {
-		render.Error(w, r, acme.WrapErrorISE(err, "error updating order status"))
+	if err := o.CancelAutoRenewal(ctx, db); err != nil {
+		render.Error(w, r, err)
 		return
 	}
 
@@ -427,6 +706,35 @@ func GetOrder(w http.ResponseWriter, r *http.Request) {
 	render.JSON(w, r, o)
 }
 
+// getAccountOrder returns the order with the given id if it's owned by the
+// account and the provisioner in the context.
+func getAccountOrder(ctx context.Context, id string) (*acme.Order, error) {
+	db := acme.MustDatabaseFromContext(ctx)
+
+	acc, err := accountFromContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	prov, err := provisionerFromContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	o, err := db.GetOrder(ctx, id)
+	if err != nil {
+		return nil, acme.WrapErrorISE(err, "error retrieving order")
+	}
+	if acc.ID != o.AccountID {
+		return nil, acme.NewError(acme.ErrorUnauthorizedType,
+			"account '%s' does not own order '%s'", acc.ID, o.ID)
+	}
+	if prov.GetID() != o.ProvisionerID {
+		return nil, acme.NewError(acme.ErrorUnauthorizedType,
+			"provisioner '%s' does not own order '%s'", prov.GetID(), o.ID)
+	}
+	return o, nil
+}
+
 // FinalizeOrder attempts to finalize an order and create a certificate.
 func FinalizeOrder(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
@@ -475,12 +783,44 @@ func FinalizeOrder(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Limit the number of certificates per registered domain. The limits of
+	// all the domains are checked before the order is finalized, and the
+	// counters are only incremented once the certificate has been issued.
+	var certsKeys []acme.RateLimitKey
+	certsLimit := rateLimitsOf(prov).CertificatesPerRegisteredDomain
+	if certsLimit.IsEnabled() {
+		if err := o.UpdateStatus(ctx, db); err != nil {
+			render.Error(w, r, acme.WrapErrorISE(err, "error updating order status"))
+			return
+		}
+		if o.Status == acme.StatusReady {
+			for _, domain := range registeredDomains(o.Identifiers) {
+				key := acme.RateLimitKey{
+					ProvisionerID: prov.GetID(),
+					Name:          certificatesPerRegisteredDomainRateLimit,
+					Value:         domain,
+				}
+				if err := acme.CheckRateLimit(ctx, db, key, certsLimit); err != nil {
+					render.Error(w, r, err)
+					return
+				}
+				certsKeys = append(certsKeys, key)
+			}
+		}
+	}
+
 	ca := mustAuthority(ctx)
 	if err = o.Finalize(ctx, db, fr.csr, ca, prov); err != nil {
 		render.Error(w, r, acme.WrapErrorISE(err, "error finalizing order"))
 		return
 	}
 
+	// The certificate has already been issued, errors incrementing the
+	// counters are ignored.
+	for _, key := range certsKeys {
+		_ = acme.IncrementRateLimit(ctx, db, key, certsLimit)
+	}
+
 	linker.LinkOrder(ctx, o)
 
 	w.Header().Set("Location", linker.GetLink(ctx, acme.OrderLinkType, o.ID))
@@ -501,12 +841,15 @@ func challengeTypes(az *acme.Authorization) []acme.ChallengeType {
 		if !az.Wildcard {
 			chTypes = append(chTypes, []acme.ChallengeType{acme.HTTP01, acme.TLSALPN01}...)
 		}
+		chTypes = append(chTypes, acme.DNSACCOUNT01)
 	case acme.PermanentIdentifier:
 		chTypes = []acme.ChallengeType{acme.DEVICEATTEST01}
 	case acme.WireUser:
 		chTypes = []acme.ChallengeType{acme.WIREOIDC01}
 	case acme.WireDevice:
 		chTypes = []acme.ChallengeType{acme.WIREDPOP01}
+	case acme.EMAIL:
+		chTypes = []acme.ChallengeType{acme.EMAILREPLY00}
 	default:
 		chTypes = []acme.ChallengeType{}
 	}
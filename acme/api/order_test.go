@@ -227,6 +227,43 @@ func TestNewOrderRequest_Validate(t *testing.T) {
 				naf: naf,
 			}
 		},
+		"fail/bad-identifier/email": func(t *testing.T) test {
+			return test{
+				nor: &NewOrderRequest{
+					Identifiers: []acme.Identifier{
+						{Type: "email", Value: "Alice <alice@example.com>"},
+					},
+				},
+				err: acme.NewError(acme.ErrorMalformedType, "invalid email address: Alice <alice@example.com>"),
+			}
+		},
+		"fail/mixed-email-and-dns": func(t *testing.T) test {
+			return test{
+				nor: &NewOrderRequest{
+					Identifiers: []acme.Identifier{
+						{Type: "email", Value: "alice@example.com"},
+						{Type: "dns", Value: "example.com"},
+					},
+				},
+				err: acme.NewError(acme.ErrorMalformedType, "email identifiers cannot be combined with other identifier types"),
+			}
+		},
+		"ok/email": func(t *testing.T) test {
+			nbf := time.Now().UTC().Add(time.Minute)
+			naf := time.Now().UTC().Add(5 * time.Minute)
+			return test{
+				nor: &NewOrderRequest{
+					Identifiers: []acme.Identifier{
+						{Type: "email", Value: "alice@example.com"},
+						{Type: "email", Value: "alice.smith@example.com"},
+					},
+					NotAfter:  naf,
+					NotBefore: nbf,
+				},
+				nbf: nbf,
+				naf: naf,
+			}
+		},
 	}
 	for name, run := range tests {
 		tc := run(t)
@@ -1055,6 +1092,236 @@ MCowBQYDK2VwAyEA5c+4NKZSNQcR1T8qN6SjwgdPZQ0Ge12Ylx/YeGAJ35k=
 	}
 }
 
+type emailSender struct {
+	to   []string
+	msg  []byte
+	sent chan struct{}
+}
+
+func (s *emailSender) Send(_ string, to []string, msg []byte) error {
+	s.to, s.msg = to, msg
+	if s.sent != nil {
+		s.sent <- struct{}{}
+	}
+	return nil
+}
+
+func TestHandler_newAuthorization_email(t *testing.T) {
+	prov := &provisioner.ACME{
+		Type:       "ACME",
+		Name:       "acme",
+		Challenges: []provisioner.ACMEChallenge{provisioner.EMAIL_REPLY_00},
+		RateLimits: &provisioner.ACMERateLimits{
+			ChallengeEmailsPerAddress: &provisioner.ACMERateLimit{Limit: 1},
+		},
+	}
+	sassert.NoError(t, prov.Init(provisioner.Config{Claims: globalProvisionerClaims}))
+	var challenges []*acme.Challenge
+	var count int
+	db := &acme.MockDB{
+		MockIncrementRateLimitCounter: func(ctx context.Context, key string, window time.Duration) (*acme.RateLimitCounter, error) {
+			sassert.Equal(t, prov.GetID()+"|challengeEmailsPerAddress|alice@example.com", key)
+			sassert.Equal(t, 24*time.Hour, window)
+			count++
+			return &acme.RateLimitCounter{Key: key, Count: count, ResetAt: time.Now().Add(time.Hour)}, nil
+		},
+		MockCreateChallenge: func(ctx context.Context, ch *acme.Challenge) error {
+			challenges = append(challenges, ch)
+			return nil
+		},
+		MockCreateAuthorization: func(ctx context.Context, az *acme.Authorization) error {
+			return nil
+		},
+	}
+	az := &acme.Authorization{
+		AccountID:  "accID",
+		Identifier: acme.Identifier{Type: acme.EMAIL, Value: "Alice@example.com"},
+		Status:     acme.StatusPending,
+	}
+
+	ctx := newBaseContext(context.Background(), db)
+	ctx = acme.NewProvisionerContext(ctx, prov)
+
+	// The challenge email cannot be sent if it's not configured.
+	err := newAuthorization(ctx, az)
+	sassert.EqualError(t, err, "email-reply-00 challenge is not configured")
+
+	// The challenge email is sent after the authorization is created.
+	sender := &emailSender{sent: make(chan struct{}, 1)}
+	ctx = acme.NewEmailOptionsContext(ctx, &acme.EmailOptions{From: "acme@ca.example.com", Sender: sender})
+	challenges, count = nil, 0
+	sassert.NoError(t, newAuthorization(ctx, az))
+	sassert.Empty(t, sender.to)
+	if sassert.Len(t, challenges, 1) {
+		ch := challenges[0]
+		sassert.Equal(t, acme.EMAILREPLY00, ch.Type)
+		sassert.Equal(t, az.Token, ch.Token)
+		sassert.Equal(t, "acme@ca.example.com", ch.From)
+		sassert.NotEmpty(t, ch.TokenPart1)
+
+		acme.SendEmailReply00Challenges(ctx, az)
+		select {
+		case <-sender.sent:
+		case <-time.After(5 * time.Second):
+			t.Fatal("challenge email was not sent")
+		}
+		sassert.Equal(t, []string{"Alice@example.com"}, sender.to)
+		sassert.Contains(t, string(sender.msg), "Subject: ACME: "+ch.TokenPart1)
+	}
+	sassert.Equal(t, challenges, az.Challenges)
+
+	// The number of challenge emails sent to an address is limited.
+	challenges = nil
+	var acmeErr *acme.Error
+	err = newAuthorization(ctx, az)
+	if sassert.ErrorAs(t, err, &acmeErr) {
+		sassert.Equal(t, "urn:ietf:params:acme:error:rateLimited", acmeErr.Type)
+	}
+	sassert.Empty(t, challenges)
+}
+
+func TestHandler_NewOrder_email(t *testing.T) {
+	prov := &provisioner.ACME{
+		Type:       "ACME",
+		Name:       "acme",
+		Challenges: []provisioner.ACMEChallenge{provisioner.EMAIL_REPLY_00},
+	}
+	sassert.NoError(t, prov.Init(provisioner.Config{Claims: globalProvisionerClaims}))
+	b, err := json.Marshal(&NewOrderRequest{
+		Identifiers: []acme.Identifier{{Type: acme.EMAIL, Value: "alice@example.com"}},
+	})
+	sassert.NoError(t, err)
+
+	tests := []struct {
+		name       string
+		err        error
+		wantStatus int
+		wantSent   bool
+	}{
+		{"ok", nil, http.StatusCreated, true},
+		{"fail/create-order", errors.New("force"), http.StatusInternalServerError, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockMustAuthority(t, &mockCA{})
+			db := &acme.MockDB{
+				MockGetAuthorizationsByAccountID: func(ctx context.Context, accountID string) ([]*acme.Authorization, error) {
+					return nil, nil
+				},
+				MockCreateChallenge: func(ctx context.Context, ch *acme.Challenge) error {
+					ch.ID = "chID"
+					return nil
+				},
+				MockCreateAuthorization: func(ctx context.Context, az *acme.Authorization) error {
+					az.ID = "azID"
+					return nil
+				},
+				MockCreateOrder: func(ctx context.Context, o *acme.Order) error {
+					o.ID = "ordID"
+					return tt.err
+				},
+			}
+			sender := &emailSender{sent: make(chan struct{}, 1)}
+			ctx := context.WithValue(context.Background(), payloadContextKey, &payloadInfo{value: b})
+			ctx = context.WithValue(ctx, accContextKey, &acme.Account{ID: "accID"})
+			ctx = acme.NewProvisionerContext(ctx, prov)
+			ctx = acme.NewEmailOptionsContext(ctx, &acme.EmailOptions{From: "acme@ca.example.com", Sender: sender})
+			ctx = newBaseContext(ctx, db, acme.NewLinker("test.ca.smallstep.com", "acme"))
+
+			req := httptest.NewRequest("POST", "/new-order", http.NoBody).WithContext(ctx)
+			w := httptest.NewRecorder()
+			NewOrder(w, req)
+			sassert.Equal(t, tt.wantStatus, w.Code, w.Body.String())
+
+			select {
+			case <-sender.sent:
+				sassert.True(t, tt.wantSent, "unexpected challenge email")
+				sassert.Equal(t, []string{"alice@example.com"}, sender.to)
+			case <-time.After(100 * time.Millisecond):
+				sassert.False(t, tt.wantSent, "challenge email was not sent")
+			}
+		})
+	}
+}
+
+func TestHandler_NewOrder_replaces(t *testing.T) {
+	leaf, certID := mustRenewalInfoCertificate(t, "test.example.com")
+	b, err := json.Marshal(&NewOrderRequest{
+		Identifiers: []acme.Identifier{{Type: acme.DNS, Value: "test.example.com"}},
+		Replaces:    certID,
+	})
+	sassert.NoError(t, err)
+
+	tests := []struct {
+		name        string
+		replacedBy  string
+		wantStatus  int
+		wantInvalid bool
+	}{
+		{"ok", "", http.StatusCreated, false},
+		{"fail/concurrent-order", "otherOrdID", http.StatusConflict, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockMustAuthority(t, &mockCA{})
+			var replacedBy string
+			var updated *acme.Order
+			db := &acme.MockDB{
+				MockGetCertificateBySerial: func(ctx context.Context, serial string) (*acme.Certificate, error) {
+					return &acme.Certificate{ID: "certID", AccountID: "accID", Leaf: leaf, ReplacedBy: replacedBy}, nil
+				},
+				MockGetAuthorizationsByAccountID: func(ctx context.Context, accountID string) ([]*acme.Authorization, error) {
+					return nil, nil
+				},
+				MockCreateChallenge: func(ctx context.Context, ch *acme.Challenge) error {
+					ch.ID = "chID"
+					return nil
+				},
+				MockCreateAuthorization: func(ctx context.Context, az *acme.Authorization) error {
+					az.ID = "azID"
+					return nil
+				},
+				MockCreateOrder: func(ctx context.Context, o *acme.Order) error {
+					o.ID = "ordID"
+					// Another order reserves the certificate after the
+					// validation of the request.
+					replacedBy = tt.replacedBy
+					return nil
+				},
+				MockReplaceCertificate: func(ctx context.Context, id, oldOrderID, orderID string) error {
+					sassert.Equal(t, "certID", id)
+					if replacedBy != oldOrderID {
+						return acme.ErrCertificateReplaced
+					}
+					replacedBy = orderID
+					return nil
+				},
+				MockUpdateOrder: func(ctx context.Context, o *acme.Order) error {
+					updated = o
+					return nil
+				},
+			}
+			ctx := context.WithValue(context.Background(), payloadContextKey, &payloadInfo{value: b})
+			ctx = context.WithValue(ctx, accContextKey, &acme.Account{ID: "accID"})
+			ctx = acme.NewProvisionerContext(ctx, newProv())
+			ctx = newBaseContext(ctx, db, acme.NewLinker("test.ca.smallstep.com", "acme"))
+
+			req := httptest.NewRequest("POST", "/new-order", http.NoBody).WithContext(ctx)
+			w := httptest.NewRecorder()
+			NewOrder(w, req)
+			sassert.Equal(t, tt.wantStatus, w.Code, w.Body.String())
+			if tt.wantInvalid {
+				require.NotNil(t, updated)
+				sassert.Equal(t, acme.StatusInvalid, updated.Status)
+				sassert.Equal(t, "otherOrdID", replacedBy)
+			} else {
+				sassert.Nil(t, updated)
+				sassert.Equal(t, "ordID", replacedBy)
+			}
+		})
+	}
+}
+
 func TestHandler_NewOrder(t *testing.T) {
 	// Request with chi context
 	prov := newProv()
@@ -1192,6 +1459,52 @@ MCowBQYDK2VwAyEA5c+4NKZSNQcR1T8qN6SjwgdPZQ0Ge12Ylx/YeGAJ35k=
 				err: acme.NewErrorISE("error retrieving external account binding key: force"),
 			}
 		},
+		"fail/invalid-profile": func(t *testing.T) test {
+			acc := &acme.Account{ID: "accID"}
+			fr := &NewOrderRequest{
+				Identifiers: []acme.Identifier{
+					{Type: "dns", Value: "zap.internal"},
+				},
+				Profile: "foo",
+			}
+			b, err := json.Marshal(fr)
+			assert.FatalError(t, err)
+			ctx := acme.NewProvisionerContext(context.Background(), newACMEProvWithProfiles(t))
+			ctx = context.WithValue(ctx, accContextKey, acc)
+			ctx = context.WithValue(ctx, payloadContextKey, &payloadInfo{value: b})
+			return test{
+				ctx:        ctx,
+				statusCode: 400,
+				ca:         &mockCA{},
+				db:         &acme.MockDB{},
+				err:        acme.NewError(acme.ErrorInvalidProfileType, "profile \"foo\" is not supported"),
+			}
+		},
+		"fail/profile-identifier-type": func(t *testing.T) test {
+			acc := &acme.Account{ID: "accID"}
+			fr := &NewOrderRequest{
+				Identifiers: []acme.Identifier{
+					{Type: "ip", Value: "192.168.0.1"},
+				},
+				Profile: "mtls",
+			}
+			b, err := json.Marshal(fr)
+			assert.FatalError(t, err)
+			ctx := acme.NewProvisionerContext(context.Background(), newACMEProvWithProfiles(t))
+			ctx = context.WithValue(ctx, accContextKey, acc)
+			ctx = context.WithValue(ctx, payloadContextKey, &payloadInfo{value: b})
+			return test{
+				ctx:        ctx,
+				statusCode: 400,
+				ca:         &mockCA{},
+				db: &acme.MockDB{
+					MockGetExternalAccountKeyByAccountID: func(ctx context.Context, provisionerID, accountID string) (*acme.ExternalAccountKey, error) {
+						return nil, nil
+					},
+				},
+				err: acme.NewError(acme.ErrorRejectedIdentifierType, "not authorized"),
+			}
+		},
 		"fail/db.GetExternalAccountKeyByAccountID-error": func(t *testing.T) test {
 			acmeProv := newACMEProv(t)
 			acmeProv.RequireEAB = true
@@ -1706,6 +2019,182 @@ MCowBQYDK2VwAyEA5c+4NKZSNQcR1T8qN6SjwgdPZQ0Ge12Ylx/YeGAJ35k=
 				},
 			}
 		},
+		"ok/profile": func(t *testing.T) test {
+			acmeProv := newACMEProvWithProfiles(t)
+			acc := &acme.Account{ID: "accID"}
+			nor := &NewOrderRequest{
+				Identifiers: []acme.Identifier{
+					{Type: "dns", Value: "zap.internal"},
+				},
+				Profile: "mtls",
+			}
+			b, err := json.Marshal(nor)
+			assert.FatalError(t, err)
+			ctx := acme.NewProvisionerContext(context.Background(), acmeProv)
+			ctx = context.WithValue(ctx, accContextKey, acc)
+			ctx = context.WithValue(ctx, payloadContextKey, &payloadInfo{value: b})
+			return test{
+				ctx:        ctx,
+				statusCode: 201,
+				nor:        nor,
+				ca:         &mockCA{},
+				db: &acme.MockDB{
+					MockCreateChallenge: func(ctx context.Context, ch *acme.Challenge) error {
+						ch.ID = string(ch.Type)
+						return nil
+					},
+					MockCreateAuthorization: func(ctx context.Context, az *acme.Authorization) error {
+						az.ID = "az1ID"
+						return nil
+					},
+					MockCreateOrder: func(ctx context.Context, o *acme.Order) error {
+						o.ID = "ordID"
+						assert.Equals(t, o.Profile, "mtls")
+						return nil
+					},
+					MockGetExternalAccountKeyByAccountID: func(ctx context.Context, provisionerID, accountID string) (*acme.ExternalAccountKey, error) {
+						return nil, nil
+					},
+				},
+				vr: func(t *testing.T, o *acme.Order) {
+					testBufferDur := 5 * time.Second
+					expNaf := clock.Now().Add(time.Hour)
+
+					assert.Equals(t, o.ID, "ordID")
+					assert.Equals(t, o.Profile, "mtls")
+					assert.True(t, o.NotAfter.Add(-testBufferDur).Before(expNaf))
+					assert.True(t, o.NotAfter.Add(testBufferDur).After(expNaf))
+				},
+			}
+		},
+		"fail/db.GetAuthorizationsByAccountID-error": func(t *testing.T) test {
+			acc := &acme.Account{ID: "accID"}
+			nor := &NewOrderRequest{
+				Identifiers: []acme.Identifier{
+					{Type: "dns", Value: "zap.internal"},
+				},
+			}
+			b, err := json.Marshal(nor)
+			assert.FatalError(t, err)
+			ctx := acme.NewProvisionerContext(context.Background(), prov)
+			ctx = context.WithValue(ctx, accContextKey, acc)
+			ctx = context.WithValue(ctx, payloadContextKey, &payloadInfo{value: b})
+			return test{
+				ctx:        ctx,
+				statusCode: 500,
+				ca:         &mockCA{},
+				db: &acme.MockDB{
+					MockGetAuthorizationsByAccountID: func(ctx context.Context, accountID string) ([]*acme.Authorization, error) {
+						return nil, errors.New("force")
+					},
+					MockGetExternalAccountKeyByAccountID: func(ctx context.Context, provisionerID, accountID string) (*acme.ExternalAccountKey, error) {
+						return nil, nil
+					},
+				},
+				err: acme.NewErrorISE("error retrieving authorizations: force"),
+			}
+		},
+		"ok/reuse-authorizations": func(t *testing.T) test {
+			acc := &acme.Account{ID: "accID"}
+			nor := &NewOrderRequest{
+				Identifiers: []acme.Identifier{
+					{Type: "dns", Value: "ZAP.internal"},
+					{Type: "ip", Value: "192.168.0.1"},
+				},
+			}
+			b, err := json.Marshal(nor)
+			assert.FatalError(t, err)
+			ctx := acme.NewProvisionerContext(context.Background(), prov)
+			ctx = context.WithValue(ctx, accContextKey, acc)
+			ctx = context.WithValue(ctx, payloadContextKey, &payloadInfo{value: b})
+			azExpiry := clock.Now().Add(time.Hour)
+			return test{
+				ctx:        ctx,
+				statusCode: 201,
+				nor:        nor,
+				ca:         &mockCA{},
+				db: &acme.MockDB{
+					MockGetAuthorizationsByAccountID: func(ctx context.Context, accountID string) ([]*acme.Authorization, error) {
+						assert.Equals(t, "accID", accountID)
+						return []*acme.Authorization{
+							{ID: "wildcard", Identifier: acme.Identifier{Type: "dns", Value: "zap.internal"}, Wildcard: true, Status: acme.StatusValid, ExpiresAt: azExpiry},
+							{ID: "pending", Identifier: acme.Identifier{Type: "dns", Value: "zap.internal"}, Status: acme.StatusPending, ExpiresAt: azExpiry},
+							{ID: "expired", Identifier: acme.Identifier{Type: "dns", Value: "zap.internal"}, Status: acme.StatusValid, ExpiresAt: clock.Now().Add(-time.Minute)},
+							{ID: "dnsID", Identifier: acme.Identifier{Type: "dns", Value: "zap.internal"}, Status: acme.StatusValid, ExpiresAt: azExpiry},
+							{ID: "ipID", Identifier: acme.Identifier{Type: "ip", Value: "192.168.0.1"}, Status: acme.StatusValid, ExpiresAt: azExpiry.Add(time.Hour)},
+						}, nil
+					},
+					MockCreateOrder: func(ctx context.Context, o *acme.Order) error {
+						o.ID = "ordID"
+						assert.Equals(t, o.AuthorizationIDs, []string{"dnsID", "ipID"})
+						return nil
+					},
+					MockGetExternalAccountKeyByAccountID: func(ctx context.Context, provisionerID, accountID string) (*acme.ExternalAccountKey, error) {
+						return nil, nil
+					},
+				},
+				vr: func(t *testing.T, o *acme.Order) {
+					assert.Equals(t, o.ID, "ordID")
+					assert.Equals(t, o.Status, acme.StatusReady)
+					assert.Equals(t, o.AuthorizationURLs, []string{
+						fmt.Sprintf("%s/acme/%s/authz/dnsID", baseURL.String(), escProvName),
+						fmt.Sprintf("%s/acme/%s/authz/ipID", baseURL.String(), escProvName),
+					})
+					assert.True(t, o.ExpiresAt.Sub(azExpiry).Abs() < time.Second)
+				},
+			}
+		},
+		"ok/reuse-some-authorizations": func(t *testing.T) test {
+			acc := &acme.Account{ID: "accID"}
+			nor := &NewOrderRequest{
+				Identifiers: []acme.Identifier{
+					{Type: "dns", Value: "zap.internal"},
+					{Type: "dns", Value: "*.zap.internal"},
+				},
+			}
+			b, err := json.Marshal(nor)
+			assert.FatalError(t, err)
+			ctx := acme.NewProvisionerContext(context.Background(), prov)
+			ctx = context.WithValue(ctx, accContextKey, acc)
+			ctx = context.WithValue(ctx, payloadContextKey, &payloadInfo{value: b})
+			return test{
+				ctx:        ctx,
+				statusCode: 201,
+				nor:        nor,
+				ca:         &mockCA{},
+				db: &acme.MockDB{
+					MockGetAuthorizationsByAccountID: func(ctx context.Context, accountID string) ([]*acme.Authorization, error) {
+						return []*acme.Authorization{
+							{ID: "dnsID", Identifier: acme.Identifier{Type: "dns", Value: "zap.internal"}, Status: acme.StatusValid, ExpiresAt: clock.Now().Add(time.Hour)},
+						}, nil
+					},
+					MockCreateChallenge: func(ctx context.Context, ch *acme.Challenge) error {
+						ch.ID = string(ch.Type)
+						return nil
+					},
+					MockCreateAuthorization: func(ctx context.Context, az *acme.Authorization) error {
+						assert.Equals(t, az.Identifier, acme.Identifier{Type: "dns", Value: "zap.internal"})
+						assert.True(t, az.Wildcard)
+						az.ID = "wildcardID"
+						return nil
+					},
+					MockCreateOrder: func(ctx context.Context, o *acme.Order) error {
+						o.ID = "ordID"
+						return nil
+					},
+					MockGetExternalAccountKeyByAccountID: func(ctx context.Context, provisionerID, accountID string) (*acme.ExternalAccountKey, error) {
+						return nil, nil
+					},
+				},
+				vr: func(t *testing.T, o *acme.Order) {
+					assert.Equals(t, o.Status, acme.StatusPending)
+					assert.Equals(t, o.AuthorizationURLs, []string{
+						fmt.Sprintf("%s/acme/%s/authz/dnsID", baseURL.String(), escProvName),
+						fmt.Sprintf("%s/acme/%s/authz/wildcardID", baseURL.String(), escProvName),
+					})
+				},
+			}
+		},
 		"ok/nbf-no-naf": func(t *testing.T) test {
 			now := clock.Now()
 			expNbf := now.Add(10 * time.Minute)
@@ -2586,7 +3075,7 @@ func TestHandler_challengeTypes(t *testing.T) {
 					Wildcard:   false,
 				},
 			},
-			want: []acme.ChallengeType{acme.DNS01, acme.HTTP01, acme.TLSALPN01},
+			want: []acme.ChallengeType{acme.DNS01, acme.HTTP01, acme.TLSALPN01, acme.DNSACCOUNT01},
 		},
 		{
 			name: "ok/wildcard",
@@ -2596,7 +3085,7 @@ func TestHandler_challengeTypes(t *testing.T) {
 					Wildcard:   true,
 				},
 			},
-			want: []acme.ChallengeType{acme.DNS01},
+			want: []acme.ChallengeType{acme.DNS01, acme.DNSACCOUNT01},
 		},
 		{
 			name: "ok/ip",
@@ -2646,3 +3135,210 @@ func TestTrimIfWildcard(t *testing.T) {
 		})
 	}
 }
+
+func Test_validateReplaces(t *testing.T) {
+	leaf, certID := mustRenewalInfoCertificate(t, "test.example.com", "www.example.com")
+	acc := &acme.Account{ID: "accID"}
+	getCertificate := func(cert *acme.Certificate) *acme.MockDB {
+		return &acme.MockDB{
+			MockGetCertificateBySerial: func(ctx context.Context, serial string) (*acme.Certificate, error) {
+				return cert, nil
+			},
+		}
+	}
+	replacedBy := func(o *acme.Order, err error) *acme.MockDB {
+		db := getCertificate(&acme.Certificate{AccountID: "accID", Leaf: leaf, ReplacedBy: "ordID"})
+		db.MockGetOrder = func(ctx context.Context, id string) (*acme.Order, error) {
+			sassert.Equal(t, "ordID", id)
+			return o, err
+		}
+		return db
+	}
+	now := clock.Now()
+	dnsIdentifiers := func(names ...string) []acme.Identifier {
+		var ids []acme.Identifier
+		for _, name := range names {
+			ids = append(ids, acme.Identifier{Type: acme.DNS, Value: name})
+		}
+		return ids
+	}
+
+	tests := []struct {
+		name           string
+		db             acme.DB
+		identifiers    []acme.Identifier
+		wantReplacedBy string
+		wantType       acme.ProblemType
+		wantStatus     int
+	}{
+		{"ok", getCertificate(&acme.Certificate{AccountID: "accID", Leaf: leaf}), dnsIdentifiers("WWW.example.com", "new.example.com"), "", 0, 0},
+		{"ok/invalid-order", replacedBy(&acme.Order{ID: "ordID", Status: acme.StatusInvalid}, nil), dnsIdentifiers("test.example.com"), "ordID", 0, 0},
+		{"ok/expired-order", replacedBy(&acme.Order{ID: "ordID", Status: acme.StatusPending, ExpiresAt: now.Add(-time.Minute)}, nil), dnsIdentifiers("test.example.com"), "ordID", 0, 0},
+		{"ok/deleted-order", replacedBy(nil, acme.NewError(acme.ErrorMalformedType, "order ordID not found")), dnsIdentifiers("test.example.com"), "ordID", 0, 0},
+		{"fail/not-found", &acme.MockDB{
+			MockGetCertificateBySerial: func(ctx context.Context, serial string) (*acme.Certificate, error) {
+				return nil, acme.NewError(acme.ErrorMalformedType, "certificate with serial %s not found", serial)
+			},
+		}, dnsIdentifiers("test.example.com"), "", acme.ErrorMalformedType, http.StatusNotFound},
+		{"fail/account", getCertificate(&acme.Certificate{AccountID: "otherID", Leaf: leaf}), dnsIdentifiers("test.example.com"), "", acme.ErrorUnauthorizedType, http.StatusUnauthorized},
+		{"fail/already-replaced", replacedBy(&acme.Order{ID: "ordID", Status: acme.StatusValid}, nil), dnsIdentifiers("test.example.com"), "", acme.ErrorAlreadyReplacedType, http.StatusConflict},
+		{"fail/pending-order", replacedBy(&acme.Order{ID: "ordID", Status: acme.StatusPending, ExpiresAt: now.Add(time.Minute)}, nil), dnsIdentifiers("test.example.com"), "", acme.ErrorAlreadyReplacedType, http.StatusConflict},
+		{"fail/get-order", replacedBy(nil, errors.New("force")), dnsIdentifiers("test.example.com"), "", acme.ErrorServerInternalType, http.StatusInternalServerError},
+		{"fail/identifiers", getCertificate(&acme.Certificate{AccountID: "accID", Leaf: leaf}), dnsIdentifiers("new.example.com"), "", acme.ErrorMalformedType, http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := validateReplaces(context.Background(), tt.db, acc, &NewOrderRequest{
+				Identifiers: tt.identifiers,
+				Replaces:    certID,
+			})
+			if tt.wantStatus == 0 {
+				sassert.NoError(t, err)
+				sassert.Equal(t, tt.wantReplacedBy, got)
+				return
+			}
+			var ae *acme.Error
+			require.True(t, errors.As(err, &ae))
+			sassert.Equal(t, acme.NewError(tt.wantType, "").Type, ae.Type)
+			sassert.Equal(t, tt.wantStatus, ae.Status)
+		})
+	}
+}
+
+func newAutoRenewalProv(t *testing.T) *provisioner.ACME {
+	t.Helper()
+	p := &provisioner.ACME{
+		Type: "ACME",
+		Name: "test@acme-<test>provisioner.com",
+		AutoRenewal: &provisioner.ACMEAutoRenewal{
+			AllowCertificateGet: true,
+		},
+	}
+	require.NoError(t, p.Init(provisioner.Config{Claims: globalProvisionerClaims}))
+	return p
+}
+
+func Test_validateAutoRenewal(t *testing.T) {
+	prov := newAutoRenewalProv(t)
+	noGetProv := newAutoRenewalProv(t)
+	noGetProv.AutoRenewal.AllowCertificateGet = false
+	now := clock.Now()
+	dnsIdentifiers := []acme.Identifier{{Type: acme.DNS, Value: "cdn.example.com"}}
+	autoRenewal := func(start, end time.Time, lifetime int64) *acme.AutoRenewal {
+		return &acme.AutoRenewal{StartDate: start, EndDate: end, Lifetime: lifetime}
+	}
+
+	tests := []struct {
+		name    string
+		prov    *provisioner.ACME
+		nor     *NewOrderRequest
+		wantErr string
+	}{
+		{"ok", prov, &NewOrderRequest{Identifiers: dnsIdentifiers, AutoRenewal: autoRenewal(now, now.Add(24*time.Hour), 3600)}, ""},
+		{"ok/default-start", prov, &NewOrderRequest{Identifiers: dnsIdentifiers, AutoRenewal: autoRenewal(time.Time{}, now.Add(24*time.Hour), 3600)}, ""},
+		{"fail/not-supported", newProv().(*provisioner.ACME), &NewOrderRequest{Identifiers: dnsIdentifiers, AutoRenewal: autoRenewal(now, now.Add(24*time.Hour), 3600)}, "auto-renewal orders are not supported"},
+		{"fail/notAfter", prov, &NewOrderRequest{Identifiers: dnsIdentifiers, NotAfter: now.Add(time.Hour), AutoRenewal: autoRenewal(now, now.Add(24*time.Hour), 3600)}, "notBefore and notAfter cannot be used"},
+		{"fail/permanent-identifier", prov, &NewOrderRequest{Identifiers: []acme.Identifier{{Type: acme.PermanentIdentifier, Value: "123"}}, AutoRenewal: autoRenewal(now, now.Add(24*time.Hour), 3600)}, "cannot contain permanent identifiers"},
+		{"fail/end-date", prov, &NewOrderRequest{Identifiers: dnsIdentifiers, AutoRenewal: autoRenewal(now, now.Add(-time.Hour), 3600)}, "end-date must be after"},
+		{"fail/max-duration", prov, &NewOrderRequest{Identifiers: dnsIdentifiers, AutoRenewal: autoRenewal(now, now.Add(400*24*time.Hour), 3600)}, "duration cannot be greater than"},
+		{"fail/min-lifetime", prov, &NewOrderRequest{Identifiers: dnsIdentifiers, AutoRenewal: autoRenewal(now, now.Add(24*time.Hour), 60)}, "lifetime cannot be less than"},
+		{"fail/lifetime-adjust", prov, &NewOrderRequest{Identifiers: dnsIdentifiers, AutoRenewal: &acme.AutoRenewal{StartDate: now, EndDate: now.Add(24 * time.Hour), Lifetime: 3600, LifetimeAdjust: -1}}, "lifetime-adjust cannot be negative"},
+		{"fail/allow-certificate-get", noGetProv, &NewOrderRequest{Identifiers: dnsIdentifiers, AutoRenewal: &acme.AutoRenewal{StartDate: now, EndDate: now.Add(24 * time.Hour), Lifetime: 3600, AllowCertificateGet: true}}, "allow-certificate-get is not supported"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateAutoRenewal(tt.prov, tt.nor)
+			if tt.wantErr != "" {
+				var ae *acme.Error
+				require.True(t, errors.As(err, &ae))
+				sassert.Equal(t, "urn:ietf:params:acme:error:malformed", ae.Type)
+				sassert.ErrorContains(t, err, tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			sassert.False(t, tt.nor.AutoRenewal.StartDate.IsZero())
+			sassert.Equal(t, 0, tt.nor.AutoRenewal.StartDate.Nanosecond())
+		})
+	}
+}
+
+func TestHandler_GetOrUpdateOrder(t *testing.T) {
+	prov := newProv()
+	chiCtx := chi.NewRouteContext()
+	chiCtx.URLParams.Add("ordID", "ordID")
+	acc := &acme.Account{ID: "accID"}
+	now := clock.Now()
+
+	newOrder := func(status acme.Status, ar *acme.AutoRenewal) *acme.Order {
+		return &acme.Order{
+			ID:            "ordID",
+			AccountID:     "accID",
+			ProvisionerID: prov.GetID(),
+			Status:        status,
+			ExpiresAt:     now.Add(time.Hour),
+			CertificateID: "certID",
+			AutoRenewal:   ar,
+		}
+	}
+	ar := &acme.AutoRenewal{StartDate: now, EndDate: now.Add(24 * time.Hour), Lifetime: 3600}
+
+	tests := []struct {
+		name       string
+		order      *acme.Order
+		payload    *payloadInfo
+		wantStatus int
+		wantType   string
+	}{
+		{"ok/post-as-get", newOrder(acme.StatusValid, ar), &payloadInfo{isPostAsGet: true}, 200, ""},
+		{"ok/cancel", newOrder(acme.StatusValid, ar), &payloadInfo{value: []byte(`{"status":"canceled"}`)}, 200, ""},
+		{"fail/status", newOrder(acme.StatusValid, ar), &payloadInfo{value: []byte(`{"status":"invalid"}`)}, 400, "urn:ietf:params:acme:error:malformed"},
+		{"fail/not-auto-renewal", newOrder(acme.StatusValid, nil), &payloadInfo{value: []byte(`{"status":"canceled"}`)}, 403, "urn:ietf:params:acme:error:autoRenewalCancellationInvalid"},
+		{"fail/not-valid", newOrder(acme.StatusReady, ar), &payloadInfo{value: []byte(`{"status":"canceled"}`)}, 403, "urn:ietf:params:acme:error:autoRenewalCancellationInvalid"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var updated *acme.Order
+			db := &acme.MockDB{
+				MockGetOrder: func(ctx context.Context, id string) (*acme.Order, error) {
+					return tt.order, nil
+				},
+				MockUpdateOrder: func(ctx context.Context, o *acme.Order) error {
+					updated = o
+					return nil
+				},
+			}
+			ctx := newBaseContext(context.Background(), db, acme.NewLinker("test.ca.smallstep.com", "acme"))
+			ctx = acme.NewProvisionerContext(ctx, prov)
+			ctx = context.WithValue(ctx, accContextKey, acc)
+			ctx = context.WithValue(ctx, payloadContextKey, tt.payload)
+			ctx = context.WithValue(ctx, chi.RouteCtxKey, chiCtx)
+			req := httptest.NewRequest("POST", "/order/ordID", http.NoBody).WithContext(ctx)
+			w := httptest.NewRecorder()
+			GetOrUpdateOrder(w, req)
+			res := w.Result()
+			body, err := io.ReadAll(res.Body)
+			res.Body.Close()
+			require.NoError(t, err)
+
+			sassert.Equal(t, tt.wantStatus, res.StatusCode)
+			if tt.wantType != "" {
+				var ae acme.Error
+				require.NoError(t, json.Unmarshal(body, &ae))
+				sassert.Equal(t, tt.wantType, ae.Type)
+				sassert.Nil(t, updated)
+				return
+			}
+
+			var o acme.Order
+			require.NoError(t, json.Unmarshal(body, &o))
+			sassert.Contains(t, o.StarCertificateURL, "/order/ordID/star-certificate")
+			sassert.Empty(t, o.CertificateURL)
+			if tt.payload.isPostAsGet {
+				sassert.Equal(t, acme.StatusValid, o.Status)
+			} else {
+				sassert.Equal(t, acme.StatusCanceled, o.Status)
+				sassert.Equal(t, acme.StatusCanceled, updated.Status)
+			}
+		})
+	}
+}
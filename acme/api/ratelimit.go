@@ -0,0 +1,93 @@
+package api
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"strings"
+
+	"golang.org/x/net/publicsuffix"
+
+	"github.com/smallstep/certificates/acme"
+	"github.com/smallstep/certificates/authority/provisioner"
+)
+
+// Names of the ACME rate limits, used in the keys of the counters.
+const (
+	newAccountsPerIPRateLimit                = "newAccountsPerIP"
+	newOrdersPerAccountRateLimit             = "newOrdersPerAccount"
+	failedValidationsPerIdentifierRateLimit  = "failedValidationsPerIdentifier"
+	certificatesPerRegisteredDomainRateLimit = "certificatesPerRegisteredDomain"
+	challengeEmailsPerAddressRateLimit       = "challengeEmailsPerAddress"
+)
+
+// rateLimitsOf returns the rate limits of the given provisioner. It returns an
+// empty set of limits if the provisioner does not define them.
+func rateLimitsOf(prov acme.Provisioner) *provisioner.ACMERateLimits {
+	if p, ok := prov.(*provisioner.ACME); ok && p.RateLimits != nil {
+		return p.RateLimits
+	}
+	return &provisioner.ACMERateLimits{}
+}
+
+// CountFailedValidation increments the failedValidationsPerIdentifier rate
+// limit counter if the given challenge is invalid. It's used to count the
+// challenges validated asynchronously by an acme.AsyncValidator.
+func CountFailedValidation(ctx context.Context, ch *acme.Challenge) {
+	if ch.Status != acme.StatusInvalid {
+		return
+	}
+	prov, ok := acme.ProvisionerFromContext(ctx)
+	if !ok {
+		return
+	}
+	limit := rateLimitsOf(prov).FailedValidationsPerIdentifier
+	key := acme.RateLimitKey{
+		ProvisionerID: prov.GetID(),
+		Name:          failedValidationsPerIdentifierRateLimit,
+		Value:         ch.Value,
+	}
+	_ = acme.IncrementRateLimit(ctx, acme.MustDatabaseFromContext(ctx), key, limit)
+}
+
+// clientIP returns the IP address of the client. Forwarding headers are not
+// used because they can be set by the clients.
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// registeredDomains returns the unique registered domains of the DNS
+// identifiers, and the IP addresses of the IP identifiers. The registered
+// domain is the domain directly below a public suffix, if the identifier does
+// not have one, the identifier is used.
+func registeredDomains(identifiers []acme.Identifier) []string {
+	var domains []string
+	seen := make(map[string]struct{})
+	for _, id := range identifiers {
+		var domain string
+		switch id.Type {
+		case acme.DNS:
+			value, _ := trimIfWildcard(strings.ToLower(id.Value))
+			var err error
+			if domain, err = publicsuffix.EffectiveTLDPlusOne(value); err != nil {
+				domain = value
+			}
+		case acme.IP:
+			domain = id.Value
+			if ip := net.ParseIP(id.Value); ip != nil {
+				domain = ip.String()
+			}
+		default:
+			continue
+		}
+		if _, ok := seen[domain]; !ok {
+			seen[domain] = struct{}{}
+			domains = append(domains, domain)
+		}
+	}
+	return domains
+}
@@ -0,0 +1,253 @@
+package api
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/go-chi/chi/v5"
+	"go.step.sm/crypto/jose"
+	"go.step.sm/crypto/keyutil"
+
+	"github.com/smallstep/certificates/acme"
+	"github.com/smallstep/certificates/authority/provisioner"
+)
+
+func Test_registeredDomains(t *testing.T) {
+	assert.Equal(t, []string{"example.com", "example.co.uk", "localhost", "10.0.0.1", "2001:db8::1"}, registeredDomains([]acme.Identifier{
+		{Type: acme.DNS, Value: "www.example.com"},
+		{Type: acme.DNS, Value: "*.Example.com"},
+		{Type: acme.DNS, Value: "a.b.example.co.uk"},
+		{Type: acme.DNS, Value: "localhost"},
+		{Type: acme.IP, Value: "10.0.0.1"},
+		{Type: acme.IP, Value: "2001:0db8::0001"},
+		{Type: acme.PermanentIdentifier, Value: "12345"},
+	}))
+	assert.Empty(t, registeredDomains(nil))
+}
+
+func Test_rateLimitsOf(t *testing.T) {
+	limits := &provisioner.ACMERateLimits{NewOrdersPerAccount: &provisioner.ACMERateLimit{Limit: 1}}
+	assert.Equal(t, limits, rateLimitsOf(&provisioner.ACME{RateLimits: limits}))
+	assert.Equal(t, &provisioner.ACMERateLimits{}, rateLimitsOf(&provisioner.ACME{}))
+	assert.Equal(t, &provisioner.ACMERateLimits{}, rateLimitsOf(nil))
+}
+
+func Test_clientIP(t *testing.T) {
+	r := httptest.NewRequest("POST", "/", http.NoBody)
+	r.RemoteAddr = "10.0.0.1:1234"
+	r.Header.Set("X-Forwarded-For", "10.0.0.2")
+	assert.Equal(t, "10.0.0.1", clientIP(r))
+	r.RemoteAddr = "10.0.0.1"
+	assert.Equal(t, "10.0.0.1", clientIP(r))
+}
+
+func TestNewAccount_rateLimited(t *testing.T) {
+	prov := &provisioner.ACME{
+		Type: "ACME",
+		Name: "test@acme-<test>provisioner.com",
+		RateLimits: &provisioner.ACMERateLimits{
+			NewAccountsPerIP: &provisioner.ACMERateLimit{Limit: 1},
+		},
+	}
+	require.NoError(t, prov.Init(provisioner.Config{Claims: globalProvisionerClaims}))
+	assert.Equal(t, 3*time.Hour, prov.RateLimits.NewAccountsPerIP.GetWindow())
+
+	b, err := json.Marshal(&NewAccountRequest{Contact: []string{"foo"}})
+	require.NoError(t, err)
+	jwk, err := jose.GenerateJWK("EC", "P-256", "ES256", "sig", "", 0)
+	require.NoError(t, err)
+
+	tests := []struct {
+		name       string
+		count      int
+		err        error
+		wantStatus int
+	}{
+		{"ok", 0, nil, http.StatusCreated},
+		{"fail/limited", 1, nil, http.StatusTooManyRequests},
+		{"fail/db", 0, errors.New("force"), http.StatusInternalServerError},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := &acme.MockDB{
+				MockIncrementRateLimitCounter: func(ctx context.Context, key string, window time.Duration) (*acme.RateLimitCounter, error) {
+					assert.Equal(t, prov.GetID()+"|newAccountsPerIP|192.0.2.1", key)
+					assert.Equal(t, 3*time.Hour, window)
+					return &acme.RateLimitCounter{Key: key, Count: tt.count + 1, ResetAt: time.Now().Add(time.Hour)}, tt.err
+				},
+				MockCreateAccount: func(ctx context.Context, acc *acme.Account) error {
+					acc.ID = "accID"
+					return nil
+				},
+			}
+			ctx := context.WithValue(context.Background(), payloadContextKey, &payloadInfo{value: b})
+			ctx = context.WithValue(ctx, jwkContextKey, jwk)
+			ctx = acme.NewProvisionerContext(ctx, prov)
+			ctx = newBaseContext(ctx, db, acme.NewLinker("test.ca.smallstep.com", "acme"))
+
+			req := httptest.NewRequest("POST", "/new-account", http.NoBody).WithContext(ctx)
+			w := httptest.NewRecorder()
+			NewAccount(w, req)
+
+			assert.Equal(t, tt.wantStatus, w.Code, w.Body.String())
+			if tt.wantStatus == http.StatusTooManyRequests {
+				assert.NotEmpty(t, w.Header().Get("Retry-After"))
+				assert.Contains(t, w.Body.String(), "urn:ietf:params:acme:error:rateLimited")
+			}
+		})
+	}
+}
+
+func TestCountFailedValidation(t *testing.T) {
+	prov := &provisioner.ACME{
+		Type: "ACME",
+		Name: "test@acme-<test>provisioner.com",
+		RateLimits: &provisioner.ACMERateLimits{
+			FailedValidationsPerIdentifier: &provisioner.ACMERateLimit{Limit: 5},
+		},
+	}
+	require.NoError(t, prov.Init(provisioner.Config{Claims: globalProvisionerClaims}))
+
+	tests := []struct {
+		name      string
+		status    acme.Status
+		wantCount bool
+	}{
+		{"ok/invalid", acme.StatusInvalid, true},
+		{"ok/valid", acme.StatusValid, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var counted bool
+			db := &acme.MockDB{
+				MockIncrementRateLimitCounter: func(ctx context.Context, key string, window time.Duration) (*acme.RateLimitCounter, error) {
+					assert.Equal(t, prov.GetID()+"|failedValidationsPerIdentifier|example.com", key)
+					counted = true
+					return &acme.RateLimitCounter{Key: key, Count: 1}, nil
+				},
+			}
+			ctx := acme.NewProvisionerContext(context.Background(), prov)
+			ctx = newBaseContext(ctx, db)
+			CountFailedValidation(ctx, &acme.Challenge{Type: acme.HTTP01, Value: "example.com", Status: tt.status})
+			assert.Equal(t, tt.wantCount, counted)
+		})
+	}
+}
+
+func TestFinalizeOrder_rateLimited(t *testing.T) {
+	prov := &provisioner.ACME{
+		Type: "ACME",
+		Name: "test@acme-<test>provisioner.com",
+		RateLimits: &provisioner.ACMERateLimits{
+			CertificatesPerRegisteredDomain: &provisioner.ACMERateLimit{Limit: 2},
+		},
+	}
+	require.NoError(t, prov.Init(provisioner.Config{Claims: globalProvisionerClaims}))
+
+	leaf, _ := mustRenewalInfoCertificate(t, "www.example.com", "www.example.org")
+	pub, priv, err := keyutil.GenerateDefaultKeyPair()
+	require.NoError(t, err)
+	leaf.PublicKey = pub
+	csrDER, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{
+		DNSNames: []string{"www.example.com", "www.example.org"},
+	}, priv)
+	require.NoError(t, err)
+	b, err := json.Marshal(&FinalizeRequest{CSR: base64.RawURLEncoding.EncodeToString(csrDER)})
+	require.NoError(t, err)
+
+	now := clock.Now()
+	tests := []struct {
+		name       string
+		counts     map[string]int
+		signErr    error
+		wantStatus int
+		wantCounts []string
+	}{
+		{"ok", map[string]int{"example.com": 1, "example.org": 1}, nil, http.StatusOK, []string{"example.com", "example.org"}},
+		{"fail/limited", map[string]int{"example.com": 1, "example.org": 2}, nil, http.StatusTooManyRequests, nil},
+		{"fail/sign", map[string]int{}, errors.New("force"), http.StatusInternalServerError, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockMustAuthority(t, &mockCASigner{
+				signer: func(*x509.CertificateRequest, provisioner.SignOptions, ...provisioner.SignOption) ([]*x509.Certificate, error) {
+					if tt.signErr != nil {
+						return nil, tt.signErr
+					}
+					return []*x509.Certificate{leaf, leaf}, nil
+				},
+			})
+
+			var counted []string
+			key := func(domain string) string {
+				return prov.GetID() + "|certificatesPerRegisteredDomain|" + domain
+			}
+			db := &acme.MockDB{
+				MockGetOrder: func(ctx context.Context, id string) (*acme.Order, error) {
+					return &acme.Order{
+						ID:            "orderID",
+						AccountID:     "accID",
+						ProvisionerID: prov.GetID(),
+						Status:        acme.StatusReady,
+						ExpiresAt:     now.Add(time.Hour),
+						NotBefore:     now,
+						NotAfter:      now.Add(24 * time.Hour),
+						Identifiers: []acme.Identifier{
+							{Type: acme.DNS, Value: "www.example.com"},
+							{Type: acme.DNS, Value: "www.example.org"},
+						},
+					}, nil
+				},
+				MockGetRateLimitCounter: func(ctx context.Context, k string, window time.Duration) (*acme.RateLimitCounter, error) {
+					for domain, count := range tt.counts {
+						if k == key(domain) {
+							return &acme.RateLimitCounter{Key: k, Count: count, ResetAt: now.Add(time.Hour)}, nil
+						}
+					}
+					return &acme.RateLimitCounter{Key: k}, nil
+				},
+				MockIncrementRateLimitCounter: func(ctx context.Context, k string, window time.Duration) (*acme.RateLimitCounter, error) {
+					for _, domain := range []string{"example.com", "example.org"} {
+						if k == key(domain) {
+							counted = append(counted, domain)
+						}
+					}
+					return &acme.RateLimitCounter{Key: k, Count: 1}, nil
+				},
+				MockCreateCertificate: func(ctx context.Context, cert *acme.Certificate) error {
+					cert.ID = "certID"
+					return nil
+				},
+				MockUpdateOrder: func(ctx context.Context, o *acme.Order) error {
+					return nil
+				},
+			}
+
+			chiCtx := chi.NewRouteContext()
+			chiCtx.URLParams.Add("ordID", "orderID")
+			ctx := context.WithValue(context.Background(), payloadContextKey, &payloadInfo{value: b})
+			ctx = context.WithValue(ctx, accContextKey, &acme.Account{ID: "accID"})
+			ctx = context.WithValue(ctx, chi.RouteCtxKey, chiCtx)
+			ctx = acme.NewProvisionerContext(ctx, prov)
+			ctx = newBaseContext(ctx, db, acme.NewLinker("test.ca.smallstep.com", "acme"))
+
+			req := httptest.NewRequest("POST", "/order/orderID/finalize", http.NoBody).WithContext(ctx)
+			w := httptest.NewRecorder()
+			FinalizeOrder(w, req)
+
+			assert.Equal(t, tt.wantStatus, w.Code, w.Body.String())
+			assert.Equal(t, tt.wantCounts, counted)
+		})
+	}
+}
@@ -0,0 +1,38 @@
+package api
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+
+	"github.com/smallstep/certificates/acme"
+	"github.com/smallstep/certificates/api/render"
+)
+
+// renewalInfoRetryAfter is the time clients should wait before checking the
+// renewal information again.
+var renewalInfoRetryAfter = 6 * time.Hour
+
+// GetRenewalInfo is the ACME resource that returns the suggested renewal window
+// of a certificate, RFC 9773. The resource is not authenticated.
+func GetRenewalInfo(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	db := acme.MustDatabaseFromContext(ctx)
+
+	cert, err := acme.GetCertificateByID(ctx, db, chi.URLParam(r, "certID"))
+	if err != nil {
+		render.Error(w, r, err)
+		return
+	}
+
+	ri, err := cert.RenewalInfo(mustAuthority(ctx))
+	if err != nil {
+		render.Error(w, r, err)
+		return
+	}
+
+	w.Header().Set("Retry-After", strconv.Itoa(int(renewalInfoRetryAfter.Seconds())))
+	render.JSON(w, r, ri)
+}
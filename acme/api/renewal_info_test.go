@@ -0,0 +1,107 @@
+package api
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.step.sm/crypto/keyutil"
+	"go.step.sm/crypto/minica"
+
+	"github.com/smallstep/certificates/acme"
+)
+
+func mustRenewalInfoCertificate(t *testing.T, names ...string) (*x509.Certificate, string) {
+	t.Helper()
+	ca, err := minica.New()
+	require.NoError(t, err)
+	pub, _, err := keyutil.GenerateDefaultKeyPair()
+	require.NoError(t, err)
+
+	now := time.Now().Truncate(time.Second)
+	leaf, err := ca.Sign(&x509.Certificate{
+		SerialNumber: big.NewInt(1234),
+		DNSNames:     names,
+		NotBefore:    now,
+		NotAfter:     now.Add(24 * time.Hour),
+		PublicKey:    pub,
+	})
+	require.NoError(t, err)
+	id, err := acme.CertificateID(leaf)
+	require.NoError(t, err)
+	return leaf, id
+}
+
+func TestGetRenewalInfo(t *testing.T) {
+	leaf, certID := mustRenewalInfoCertificate(t, "test.example.com")
+
+	tests := []struct {
+		name       string
+		certID     string
+		db         acme.DB
+		ca         acme.CertificateAuthority
+		statusCode int
+		wantType   string
+	}{
+		{"ok", certID, &acme.MockDB{
+			MockGetCertificateBySerial: func(ctx context.Context, serial string) (*acme.Certificate, error) {
+				assert.Equal(t, "1234", serial)
+				return &acme.Certificate{ID: "certID", Leaf: leaf}, nil
+			},
+		}, &mockCA{}, http.StatusOK, ""},
+		{"fail/malformed", "foo", &acme.MockDB{}, &mockCA{}, http.StatusBadRequest, "urn:ietf:params:acme:error:malformed"},
+		{"fail/not-found", certID, &acme.MockDB{
+			MockGetCertificateBySerial: func(ctx context.Context, serial string) (*acme.Certificate, error) {
+				return nil, acme.NewError(acme.ErrorMalformedType, "certificate with serial %s not found", serial)
+			},
+		}, &mockCA{}, http.StatusNotFound, "urn:ietf:params:acme:error:malformed"},
+		{"fail/isRevoked", certID, &acme.MockDB{
+			MockGetCertificateBySerial: func(ctx context.Context, serial string) (*acme.Certificate, error) {
+				return &acme.Certificate{ID: "certID", Leaf: leaf}, nil
+			},
+		}, &mockCA{
+			MockIsRevoked: func(sn string) (bool, error) {
+				return false, errors.New("force")
+			},
+		}, http.StatusInternalServerError, "urn:ietf:params:acme:error:serverInternal"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockMustAuthority(t, tt.ca)
+
+			chiCtx := chi.NewRouteContext()
+			chiCtx.URLParams.Add("certID", tt.certID)
+			ctx := context.WithValue(context.Background(), chi.RouteCtxKey, chiCtx)
+			ctx = acme.NewDatabaseContext(ctx, tt.db)
+
+			req := httptest.NewRequest("GET", "https://ca.example.com/acme/prov/renewal-info/"+tt.certID, http.NoBody)
+			w := httptest.NewRecorder()
+			GetRenewalInfo(w, req.WithContext(ctx))
+			res := w.Result()
+			defer res.Body.Close()
+
+			assert.Equal(t, tt.statusCode, res.StatusCode)
+			if tt.statusCode != http.StatusOK {
+				var ae acme.Error
+				require.NoError(t, json.NewDecoder(res.Body).Decode(&ae))
+				assert.Equal(t, tt.wantType, ae.Type)
+				return
+			}
+
+			var ri acme.RenewalInfo
+			require.NoError(t, json.NewDecoder(res.Body).Decode(&ri))
+			assert.Equal(t, "21600", res.Header.Get("Retry-After"))
+			assert.Equal(t, leaf.NotBefore.Add(16*time.Hour).UTC(), ri.SuggestedWindow.Start)
+			assert.Equal(t, leaf.NotBefore.Add(20*time.Hour).UTC(), ri.SuggestedWindow.End)
+		})
+	}
+}
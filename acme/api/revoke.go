@@ -83,6 +83,14 @@ func RevokeCert(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Short-term certificates are not revoked, the auto-renewal order must
+	// be canceled instead, RFC 8739 section 2.3.
+	if dbCert.AutoRenewal {
+		render.Error(w, r, acme.NewError(acme.ErrorAutoRenewalRevocationNotSupportedType,
+			"certificate %s belongs to an auto-renewal order", serial))
+		return
+	}
+
 	if shouldCheckAccountFrom(jws) {
 		account, err := accountFromContext(ctx)
 		if err != nil {
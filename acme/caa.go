@@ -0,0 +1,195 @@
+package acme
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strings"
+
+	"github.com/smallstep/certificates/authority/provisioner"
+)
+
+// CAARecord is a DNS Certification Authority Authorization resource record as
+// defined in RFC 8659.
+type CAARecord struct {
+	Flag  uint8
+	Tag   string
+	Value string
+}
+
+// IsCritical returns true if the issuer critical flag of the record is set.
+func (r *CAARecord) IsCritical() bool {
+	return r.Flag&128 != 0
+}
+
+// caaIdentitiesOf returns the issuer domain names of the provisioner and true
+// if the provisioner requires the CAA records to be checked.
+func caaIdentitiesOf(prov Provisioner) ([]string, bool) {
+	p, ok := prov.(*provisioner.ACME)
+	if !ok || !p.CheckCAA {
+		return nil, false
+	}
+	return p.CaaIdentities, true
+}
+
+// isCAAChallenge returns true if the CAA records apply to the challenge. CAA
+// records are only defined for DNS names.
+func isCAAChallenge(ch *Challenge) bool {
+	switch ch.Type {
+	case HTTP01, DNS01, DNSACCOUNT01, TLSALPN01:
+		return net.ParseIP(ch.Value) == nil
+	default:
+		return false
+	}
+}
+
+// caaValidate checks that the CAA records of the challenge identifier allow
+// the provisioner to issue a certificate for it. It returns nil if the
+// provisioner does not require CAA checking or the records allow the
+// issuance. Otherwise, it returns the error and whether the challenge must be
+// marked as invalid; errors retrieving the records are considered transient.
+func caaValidate(ctx context.Context, ch *Challenge) (*Error, bool) {
+	prov, ok := ProvisionerFromContext(ctx)
+	if !ok {
+		return nil, false
+	}
+	identities, ok := caaIdentitiesOf(prov)
+	if !ok || !isCAAChallenge(ch) {
+		return nil, false
+	}
+
+	domain, wildcard := strings.CutPrefix(ch.Value, "*.")
+	records, err := relevantCAASet(ctx, MustClientFromContext(ctx), domain)
+	if err != nil {
+		return WrapError(ErrorDNSType, err, "error looking up CAA records for domain %s", domain), false
+	}
+
+	var accountURL string
+	if linker, ok := LinkerFromContext(ctx); ok {
+		accountURL = linker.GetLink(ctx, AccountLinkType, ch.AccountID)
+	}
+	if !caaAllows(records, identities, wildcard, accountURL, string(ch.Type)) {
+		return NewDetailedError(ErrorCaaType, "CAA records for %s do not allow the issuance of a certificate for %s using %s",
+			domain, ch.Value, ch.Type), true
+	}
+	return nil, false
+}
+
+// relevantCAASet returns the relevant CAA record set of a domain. As defined
+// in RFC 8659, section 3, the relevant set is the first non-empty set found
+// climbing the DNS tree from the domain to the top level domain.
+func relevantCAASet(ctx context.Context, vc Client, domain string) ([]*CAARecord, error) {
+	name := strings.TrimSuffix(domain, ".")
+	for name != "" {
+		records, err := vc.LookupCAA(ctx, name)
+		if err != nil {
+			return nil, err
+		}
+		if len(records) > 0 {
+			return records, nil
+		}
+		_, name, _ = strings.Cut(name, ".")
+	}
+	return nil, nil
+}
+
+// caaAllows returns true if the given relevant CAA record set allows the
+// issuance of a certificate to one of the given issuer domain names. The
+// issuewild records are used for wildcard names if present, and the RFC 8657
+// accounturi and validationmethods parameters are enforced.
+func caaAllows(records []*CAARecord, identities []string, wildcard bool, accountURL, method string) bool {
+	var issue, issueWild []*CAARecord
+	for _, r := range records {
+		switch strings.ToLower(r.Tag) {
+		case "issue":
+			issue = append(issue, r)
+		case "issuewild":
+			issueWild = append(issueWild, r)
+		case "iodef", "issuemail", "issuevmc", "contactemail", "contactphone":
+		default:
+			// Unknown critical properties must prevent the issuance.
+			if r.IsCritical() {
+				return false
+			}
+		}
+	}
+
+	candidates := issue
+	if wildcard && len(issueWild) > 0 {
+		candidates = issueWild
+	}
+	// The issuance is not restricted if there are no issue properties.
+	if len(candidates) == 0 {
+		return true
+	}
+	for _, r := range candidates {
+		if caaIssueAllows(r.Value, identities, accountURL, method) {
+			return true
+		}
+	}
+	return false
+}
+
+// caaIssueAllows returns true if the value of an issue or issuewild property
+// authorizes one of the given issuer domain names with the given account and
+// validation method.
+func caaIssueAllows(value string, identities []string, accountURL, method string) bool {
+	issuer, params, err := parseCAAIssueValue(value)
+	if err != nil || issuer == "" {
+		return false
+	}
+
+	var found bool
+	for _, id := range identities {
+		if strings.EqualFold(strings.TrimSuffix(id, "."), issuer) {
+			found = true
+			break
+		}
+	}
+	if !found {
+		return false
+	}
+
+	// RFC 8657, section 3.
+	if uri, ok := params["accounturi"]; ok && (accountURL == "" || uri != accountURL) {
+		return false
+	}
+	// RFC 8657, section 4.
+	if methods, ok := params["validationmethods"]; ok {
+		for _, m := range strings.Split(methods, ",") {
+			if strings.TrimSpace(m) == method {
+				return true
+			}
+		}
+		return false
+	}
+	return true
+}
+
+// parseCAAIssueValue parses the value of an issue or issuewild property,
+// returning the issuer domain name and the parameters. An empty issuer domain
+// name means that no issuer is authorized.
+func parseCAAIssueValue(value string) (string, map[string]string, error) {
+	issuer, rest, _ := strings.Cut(value, ";")
+	issuer = strings.TrimSuffix(strings.TrimSpace(issuer), ".")
+	if strings.ContainsAny(issuer, " \t") {
+		return "", nil, errors.New("invalid issuer domain name")
+	}
+
+	params := make(map[string]string)
+	for _, p := range strings.Split(rest, ";") {
+		if p = strings.TrimSpace(p); p == "" {
+			continue
+		}
+		tag, val, ok := strings.Cut(p, "=")
+		if !ok {
+			return "", nil, errors.New("invalid parameter")
+		}
+		tag, val = strings.TrimSpace(tag), strings.TrimSpace(val)
+		if tag == "" || strings.ContainsAny(val, " \t") {
+			return "", nil, errors.New("invalid parameter")
+		}
+		params[tag] = val
+	}
+	return issuer, params, nil
+}
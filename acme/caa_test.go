@@ -0,0 +1,170 @@
+package acme
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.step.sm/crypto/jose"
+
+	"github.com/smallstep/certificates/authority/config"
+	"github.com/smallstep/certificates/authority/provisioner"
+)
+
+func Test_caaAllows(t *testing.T) {
+	identities := []string{"ca.example.org"}
+	accountURL := "https://ca.example.org/acme/acme/account/accID"
+	issue := func(value string) *CAARecord {
+		return &CAARecord{Tag: "issue", Value: value}
+	}
+	issueWild := func(value string) *CAARecord {
+		return &CAARecord{Tag: "issuewild", Value: value}
+	}
+
+	tests := []struct {
+		name     string
+		records  []*CAARecord
+		wildcard bool
+		method   string
+		want     bool
+	}{
+		{"ok/empty", nil, false, "http-01", true},
+		{"ok/issue", []*CAARecord{issue("ca.example.org")}, false, "http-01", true},
+		{"ok/issue-case", []*CAARecord{{Tag: "ISSUE", Value: "CA.example.org."}}, false, "http-01", true},
+		{"ok/issue-any", []*CAARecord{issue("other.example.com"), issue("ca.example.org")}, false, "http-01", true},
+		{"ok/iodef-only", []*CAARecord{{Tag: "iodef", Value: "mailto:security@example.com"}}, false, "http-01", true},
+		{"ok/issuewild-not-wildcard", []*CAARecord{issueWild(";")}, false, "http-01", true},
+		{"ok/issuewild", []*CAARecord{issue(";"), issueWild("ca.example.org")}, true, "dns-01", true},
+		{"ok/issue-wildcard", []*CAARecord{issue("ca.example.org")}, true, "dns-01", true},
+		{"ok/unknown-not-critical", []*CAARecord{{Tag: "foo", Value: "bar"}, issue("ca.example.org")}, false, "http-01", true},
+		{"ok/accounturi", []*CAARecord{issue("ca.example.org; accounturi=" + accountURL)}, false, "http-01", true},
+		{"ok/validationmethods", []*CAARecord{issue("ca.example.org; validationmethods=dns-01,http-01")}, false, "http-01", true},
+		{"ok/all-params", []*CAARecord{issue("ca.example.org; accounturi=" + accountURL + "; validationmethods=tls-alpn-01")}, false, "tls-alpn-01", true},
+		{"ok/unknown-param", []*CAARecord{issue("ca.example.org; foo=bar")}, false, "http-01", true},
+		{"fail/other-issuer", []*CAARecord{issue("other.example.com")}, false, "http-01", false},
+		{"fail/no-issuer", []*CAARecord{issue(";")}, false, "http-01", false},
+		{"fail/issuewild", []*CAARecord{issue("ca.example.org"), issueWild("other.example.com")}, true, "dns-01", false},
+		{"fail/unknown-critical", []*CAARecord{{Flag: 128, Tag: "foo", Value: "bar"}, issue("ca.example.org")}, false, "http-01", false},
+		{"fail/accounturi", []*CAARecord{issue("ca.example.org; accounturi=https://ca.example.org/acme/acme/account/other")}, false, "http-01", false},
+		{"fail/validationmethods", []*CAARecord{issue("ca.example.org; validationmethods=dns-01")}, false, "http-01", false},
+		{"fail/malformed", []*CAARecord{issue("ca.example.org; accounturi")}, false, "http-01", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, caaAllows(tt.records, identities, tt.wildcard, accountURL, tt.method))
+		})
+	}
+}
+
+func Test_relevantCAASet(t *testing.T) {
+	var lookups []string
+	vc := &mockClient{
+		lookupCAA: func(name string) ([]*CAARecord, error) {
+			lookups = append(lookups, name)
+			switch name {
+			case "example.com":
+				return []*CAARecord{{Tag: "issue", Value: "ca.example.org"}}, nil
+			case "fail.example.net":
+				return nil, errors.New("force")
+			default:
+				return nil, nil
+			}
+		},
+	}
+
+	records, err := relevantCAASet(context.Background(), vc, "a.b.example.com")
+	require.NoError(t, err)
+	assert.Equal(t, []*CAARecord{{Tag: "issue", Value: "ca.example.org"}}, records)
+	assert.Equal(t, []string{"a.b.example.com", "b.example.com", "example.com"}, lookups)
+
+	lookups = nil
+	records, err = relevantCAASet(context.Background(), vc, "www.example.org.")
+	require.NoError(t, err)
+	assert.Empty(t, records)
+	assert.Equal(t, []string{"www.example.org", "example.org", "org"}, lookups)
+
+	_, err = relevantCAASet(context.Background(), vc, "www.fail.example.net")
+	assert.Error(t, err)
+}
+
+func TestChallenge_Validate_caa(t *testing.T) {
+	jwk, err := jose.GenerateJWK("EC", "P-256", "ES256", "sig", "", 0)
+	require.NoError(t, err)
+	keyAuth, err := KeyAuthorization("token", jwk)
+	require.NoError(t, err)
+	sum := sha256.Sum256([]byte(keyAuth))
+	txt := base64.RawURLEncoding.EncodeToString(sum[:])
+
+	prov := &provisioner.ACME{
+		Type:          "ACME",
+		Name:          "acme",
+		CaaIdentities: []string{"ca.example.org"},
+		CheckCAA:      true,
+	}
+	require.NoError(t, prov.Init(provisioner.Config{Claims: config.GlobalProvisionerClaims}))
+	accountURL := "https://ca.example.org/acme/acme/account/accID"
+
+	tests := []struct {
+		name       string
+		value      string
+		lookupCAA  func(name string) ([]*CAARecord, error)
+		wantStatus Status
+		wantErr    string
+	}{
+		{"ok/no-records", "www.example.com", func(name string) ([]*CAARecord, error) {
+			return nil, nil
+		}, StatusValid, ""},
+		{"ok/accounturi", "www.example.com", func(name string) ([]*CAARecord, error) {
+			if name == "example.com" {
+				return []*CAARecord{{Tag: "issue", Value: "ca.example.org; accounturi=" + accountURL + "; validationmethods=dns-01"}}, nil
+			}
+			return nil, nil
+		}, StatusValid, ""},
+		{"fail/forbidden", "*.example.com", func(name string) ([]*CAARecord, error) {
+			return []*CAARecord{{Tag: "issue", Value: "ca.example.org"}, {Tag: "issuewild", Value: ";"}}, nil
+		}, StatusInvalid, "urn:ietf:params:acme:error:caa"},
+		{"fail/validationmethods", "www.example.com", func(name string) ([]*CAARecord, error) {
+			return []*CAARecord{{Tag: "issue", Value: "ca.example.org; validationmethods=http-01"}}, nil
+		}, StatusInvalid, "urn:ietf:params:acme:error:caa"},
+		{"fail/lookup", "www.example.com", func(name string) ([]*CAARecord, error) {
+			return nil, errors.New("force")
+		}, StatusPending, "urn:ietf:params:acme:error:dns"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ch := &Challenge{
+				ID:        "chID",
+				AccountID: "accID",
+				Type:      DNS01,
+				Status:    StatusPending,
+				Token:     "token",
+				Value:     tt.value,
+			}
+			ctx := NewProvisionerContext(context.Background(), prov)
+			ctx = NewLinkerContext(ctx, NewLinker("ca.example.org", "acme"))
+			ctx = NewClientContext(ctx, &mockClient{
+				lookupTxt: func(name string) ([]string, error) {
+					return []string{txt}, nil
+				},
+				lookupCAA: tt.lookupCAA,
+			})
+			db := &MockDB{
+				MockUpdateChallenge: func(ctx context.Context, updch *Challenge) error {
+					return nil
+				},
+			}
+
+			require.NoError(t, ch.Validate(ctx, db, jwk, nil))
+			assert.Equal(t, tt.wantStatus, ch.Status)
+			if tt.wantErr == "" {
+				assert.Nil(t, ch.Error)
+			} else if assert.NotNil(t, ch.Error) {
+				assert.Equal(t, tt.wantErr, ch.Error.Type)
+			}
+		})
+	}
+}
@@ -11,4 +11,10 @@ type Certificate struct {
 	OrderID       string
 	Leaf          *x509.Certificate
 	Intermediates []*x509.Certificate
+	// ReplacedBy is the ID of the order that replaced this certificate using
+	// the ARI replaces field.
+	ReplacedBy string
+	// AutoRenewal is true if the certificate is one of the short-term
+	// certificates of an auto-renewal order, RFC 8739.
+	AutoRenewal bool
 }
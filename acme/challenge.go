@@ -13,9 +13,11 @@ import (
 	"crypto/tls"
 	"crypto/x509"
 	"encoding/asn1"
+	"encoding/base32"
 	"encoding/base64"
 	"encoding/hex"
 	"encoding/json"
+	"encoding/pem"
 	"errors"
 	"fmt"
 	"io"
@@ -48,6 +50,9 @@ const (
 	HTTP01 ChallengeType = "http-01"
 	// DNS01 is the dns-01 ACME challenge type
 	DNS01 ChallengeType = "dns-01"
+	// DNSACCOUNT01 is the dns-account-01 ACME challenge type defined in
+	// https://datatracker.ietf.org/doc/draft-ietf-acme-dns-account-label/
+	DNSACCOUNT01 ChallengeType = "dns-account-01"
 	// TLSALPN01 is the tls-alpn-01 ACME challenge type
 	TLSALPN01 ChallengeType = "tls-alpn-01"
 	// DEVICEATTEST01 is the device-attest-01 ACME challenge type
@@ -56,6 +61,8 @@ const (
 	WIREOIDC01 ChallengeType = "wire-oidc-01"
 	// WIREDPOP01 is the Wire DPoP challenge type
 	WIREDPOP01 ChallengeType = "wire-dpop-01"
+	// EMAILREPLY00 is the email-reply-00 ACME challenge type defined in RFC 8823
+	EMAILREPLY00 ChallengeType = "email-reply-00"
 )
 
 var (
@@ -87,9 +94,11 @@ type Challenge struct {
 	ValidatedAt     string        `json:"validated,omitempty"`
 	URL             string        `json:"url"`
 	Target          string        `json:"target,omitempty"`
+	From            string        `json:"from,omitempty"`
 	Error           *Error        `json:"error,omitempty"`
 	Payload         []byte        `json:"-"`
 	PayloadFormat   string        `json:"-"`
+	TokenPart1      string        `json:"-"`
 }
 
 // ToLog enables response logging.
@@ -109,11 +118,17 @@ func (ch *Challenge) Validate(ctx context.Context, db DB, jwk *jose.JSONWebKey,
 	if ch.Status != StatusPending {
 		return nil
 	}
+	// Check the CAA records if the provisioner requires it, RFC 8659.
+	if caaErr, markInvalid := caaValidate(ctx, ch); caaErr != nil {
+		return storeError(ctx, db, ch, markInvalid, caaErr)
+	}
 	switch ch.Type {
 	case HTTP01:
 		return http01Validate(ctx, ch, db, jwk)
 	case DNS01:
 		return dns01Validate(ctx, ch, db, jwk)
+	case DNSACCOUNT01:
+		return dnsAccount01Validate(ctx, ch, db, jwk)
 	case TLSALPN01:
 		return tlsalpn01Validate(ctx, ch, db, jwk)
 	case DEVICEATTEST01:
@@ -130,6 +145,12 @@ func (ch *Challenge) Validate(ctx context.Context, db DB, jwk *jose.JSONWebKey,
 			return NewErrorISE("db %T is not a WireDB", db)
 		}
 		return wireDPOP01Validate(ctx, ch, wireDB, jwk, payload)
+	case EMAILREPLY00:
+		emailDB, ok := db.(EmailDB)
+		if !ok {
+			return NewErrorISE("db %T is not an EmailDB", db)
+		}
+		return emailReply00Validate(ctx, ch, emailDB, jwk)
 	default:
 		return NewErrorISE("unexpected challenge type %q", ch.Type)
 	}
@@ -175,6 +196,11 @@ func http01Validate(ctx context.Context, ch *Challenge, db DB, jwk *jose.JSONWeb
 			"keyAuthorization does not match; expected %s, but got %s", expected, keyAuth))
 	}
 
+	// Validate the challenge from the remote perspectives, if configured.
+	if markInvalid, err := validateFromPerspectives(ctx, ch, jwk); err != nil {
+		return storeError(ctx, db, ch, markInvalid, err)
+	}
+
 	// Update and store the challenge.
 	ch.Status = StatusValid
 	ch.Error = nil
@@ -224,6 +250,15 @@ func dns01ChallengeHost(domain string) string {
 	return "_acme-challenge." + rootedName(domain)
 }
 
+// dnsAccount01ChallengeHost returns the account scoped name used in the
+// dns-account-01 challenge. The label is the lowercase base32 encoding of the
+// first 10 bytes of the SHA-256 digest of the account URL.
+func dnsAccount01ChallengeHost(accountURL, domain string) string {
+	h := sha256.Sum256([]byte(accountURL))
+	label := strings.ToLower(base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(h[:10]))
+	return "_" + label + "." + dns01ChallengeHost(domain)
+}
+
 func tlsAlert(err error) uint8 {
 	var opErr *net.OpError
 	if errors.As(err, &opErr) {
@@ -330,6 +365,11 @@ func tlsalpn01Validate(ctx context.Context, ch *Challenge, db DB, jwk *jose.JSON
 					hex.EncodeToString(hashedKeyAuth[:]), hex.EncodeToString(extValue)))
 			}
 
+			// Validate the challenge from the remote perspectives, if configured.
+			if markInvalid, err := validateFromPerspectives(ctx, ch, jwk); err != nil {
+				return storeError(ctx, db, ch, markInvalid, err)
+			}
+
 			ch.Status = StatusValid
 			ch.Error = nil
 			ch.ValidatedAt = clock.Now().Format(time.RFC3339)
@@ -361,8 +401,31 @@ func dns01Validate(ctx context.Context, ch *Challenge, db DB, jwk *jose.JSONWebK
 	// Instead perform txt lookup for _acme-challenge.example.com
 	domain := strings.TrimPrefix(ch.Value, "*.")
 
+	return dnsTXTValidate(ctx, ch, db, jwk, domain, dns01ChallengeHost(domain))
+}
+
+// dnsAccount01Validate validates a dns-account-01 challenge. It works like
+// dns-01 but the TXT record is looked up in a label derived from the account
+// URL, allowing multiple accounts to validate the same domain at the same
+// time.
+func dnsAccount01Validate(ctx context.Context, ch *Challenge, db DB, jwk *jose.JSONWebKey) error {
+	linker, ok := LinkerFromContext(ctx)
+	if !ok {
+		return NewErrorISE("missing linker")
+	}
+
+	// Normalize domain for wildcard DNS names, see dns01Validate.
+	domain := strings.TrimPrefix(ch.Value, "*.")
+	accountURL := linker.GetLink(ctx, AccountLinkType, ch.AccountID)
+
+	return dnsTXTValidate(ctx, ch, db, jwk, domain, dnsAccount01ChallengeHost(accountURL, domain))
+}
+
+// dnsTXTValidate validates that the TXT records of the given name contains
+// the digest of the key authorization of the challenge.
+func dnsTXTValidate(ctx context.Context, ch *Challenge, db DB, jwk *jose.JSONWebKey, domain, name string) error {
 	vc := MustClientFromContext(ctx)
-	txtRecords, err := vc.LookupTxt(dns01ChallengeHost(domain))
+	txtRecords, err := vc.LookupTxt(name)
 	if err != nil {
 		return storeError(ctx, db, ch, false, WrapError(ErrorDNSType, err,
 			"error looking up TXT records for domain %s", domain))
@@ -386,6 +449,11 @@ func dns01Validate(ctx context.Context, ch *Challenge, db DB, jwk *jose.JSONWebK
 			"keyAuthorization does not match; expected %s, but got %s", expectedKeyAuth, txtRecords))
 	}
 
+	// Validate the challenge from the remote perspectives, if configured.
+	if markInvalid, err := validateFromPerspectives(ctx, ch, jwk); err != nil {
+		return storeError(ctx, db, ch, markInvalid, err)
+	}
+
 	// Update and store the challenge.
 	ch.Status = StatusValid
 	ch.Error = nil
@@ -833,7 +901,7 @@ func deviceAttest01Validate(ctx context.Context, ch *Challenge, db DB, jwk *jose
 	format := att.Format
 	prov := MustProvisionerFromContext(ctx)
 	if !prov.IsAttestationFormatEnabled(ctx, provisioner.ACMEAttestationFormat(format)) {
-		if format != "apple" && format != "step" && format != "tpm" {
+		if format != "apple" && format != "step" && format != "tpm" && format != "android-key" {
 			return storeError(ctx, db, ch, true, NewDetailedError(ErrorBadAttestationStatementType, "unsupported attestation object format %q", format))
 		}
 
@@ -934,8 +1002,39 @@ func deviceAttest01Validate(ctx context.Context, ch *Challenge, db DB, jwk *jose
 			return storeError(ctx, db, ch, true, NewDetailedError(ErrorBadAttestationStatementType, "permanent identifier does not match").AddSubproblems(subproblem))
 		}
 
-		// Update attestation key fingerprint to compare against the CSR
+		// Update attestation key fingerprint to compare against the CSR, and
+		// the EK validation to send it to the webhooks.
+		az.Fingerprint = data.Fingerprint
+		az.TPMEKValidation = data.TPMEKValidation
+	case "android-key":
+		data, err := doAndroidKeyAttestationFormat(ctx, prov, ch, jwk, &att)
+		if err != nil {
+			var acmeError *Error
+			if errors.As(err, &acmeError) {
+				if acmeError.Status == 500 {
+					return acmeError
+				}
+				return storeError(ctx, db, ch, true, acmeError)
+			}
+			return WrapErrorISE(err, "error validating attestation")
+		}
+
+		// Validate the serial number, IMEI or MEID attested by the device
+		// with the challenged Order value. Device identifiers are only
+		// attested on managed devices.
+		if !slices.Contains(data.PermanentIdentifiers, ch.Value) {
+			subproblem := NewSubproblemWithIdentifier(
+				ErrorRejectedIdentifierType,
+				Identifier{Type: "permanent-identifier", Value: ch.Value},
+				"challenge identifier %q doesn't match any of the attested hardware identifiers %q", ch.Value, data.PermanentIdentifiers,
+			)
+			return storeError(ctx, db, ch, true, NewDetailedError(ErrorBadAttestationStatementType, "permanent identifier does not match").AddSubproblems(subproblem))
+		}
+
+		// Update attestation key fingerprint to compare against the CSR, and
+		// the device properties to send them to the webhooks.
 		az.Fingerprint = data.Fingerprint
+		az.AndroidDevice = data.Device
 	default:
 		return storeError(ctx, db, ch, true, NewDetailedError(ErrorBadAttestationStatementType, "unsupported attestation object format %q", format))
 	}
@@ -971,6 +1070,7 @@ type tpmAttestationData struct {
 	VerifiedChains       [][]*x509.Certificate
 	PermanentIdentifiers []string
 	Fingerprint          string
+	TPMEKValidation      *provisioner.TPMEKValidation
 }
 
 // coseAlgorithmIdentifier models a COSEAlgorithmIdentifier.
@@ -983,7 +1083,7 @@ const (
 	coseAlgRS1   coseAlgorithmIdentifier = -65535 // deprecated, but (still) often used in TPMs
 )
 
-func doTPMAttestationFormat(_ context.Context, prov Provisioner, ch *Challenge, jwk *jose.JSONWebKey, att *attestationObject) (*tpmAttestationData, error) {
+func doTPMAttestationFormat(ctx context.Context, prov Provisioner, ch *Challenge, jwk *jose.JSONWebKey, att *attestationObject) (*tpmAttestationData, error) {
 	ver, ok := att.AttStatement["ver"].(string)
 	if !ok {
 		return nil, NewDetailedError(ErrorBadAttestationStatementType, "ver not present")
@@ -1169,10 +1269,194 @@ func doTPMAttestationFormat(_ context.Context, prov Provisioner, ch *Challenge,
 		return nil, WrapErrorISE(err, "error calculating key fingerprint")
 	}
 
+	// validate the EK certificate chain if the provisioner requires it
+	if p, ok := prov.(*provisioner.ACME); ok && p.TPMAttestation != nil {
+		if data.TPMEKValidation, err = validateTPMEKCertificate(ctx, p.TPMAttestation, akCert, att); err != nil {
+			return nil, err
+		}
+		if data.TPMEKValidation != nil && data.TPMEKValidation.PermanentIdentifierBound {
+			data.PermanentIdentifiers = []string{data.TPMEKValidation.EKKeyID}
+		}
+	}
+
 	// TODO(hs): pass more attestation data, so that that can be used/recorded too?
 	return data, nil
 }
 
+// ekKeyIDPrefix is the prefix of the URI identifying an EK by the SHA-256
+// hash of its public key.
+const ekKeyIDPrefix = "urn:ek:sha256:"
+
+// validateTPMEKCertificate validates the EK certificate chain in the "ekx5c"
+// property of the attestation statement using the EK roots configured in the
+// provisioner. It returns nil if the chain is not present and the provisioner
+// does not require it.
+func validateTPMEKCertificate(ctx context.Context, opts *provisioner.ACMETPMAttestation, akCert *x509.Certificate, att *attestationObject) (*provisioner.TPMEKValidation, error) {
+	ekx5c, ok := att.AttStatement["ekx5c"].([]interface{})
+	if !ok || len(ekx5c) == 0 {
+		if opts.RequireEKCertificate {
+			return nil, NewDetailedError(ErrorBadAttestationStatementType, "ekx5c not present")
+		}
+		return nil, nil
+	}
+
+	certs := make([]*x509.Certificate, len(ekx5c))
+	for i, v := range ekx5c {
+		b, vok := v.([]byte)
+		if !vok {
+			return nil, NewDetailedError(ErrorBadAttestationStatementType, "ekx5c is malformed")
+		}
+		cert, err := x509.ParseCertificate(b)
+		if err != nil {
+			return nil, WrapDetailedError(ErrorBadAttestationStatementType, err, "ekx5c is malformed")
+		}
+		certs[i] = cert
+	}
+
+	// EK certificates usually contain a critical Subject Alternative Name
+	// extension with the TPM manufacturer, model and version encoded as a
+	// directory name, and an empty subject.
+	ekCert := certs[0]
+	if len(ekCert.UnhandledCriticalExtensions) > 0 {
+		unhandledCriticalExtensions := ekCert.UnhandledCriticalExtensions[:0]
+		for _, extOID := range ekCert.UnhandledCriticalExtensions {
+			if !extOID.Equal(oidSubjectAlternativeName) {
+				unhandledCriticalExtensions = append(unhandledCriticalExtensions, extOID)
+			}
+		}
+		ekCert.UnhandledCriticalExtensions = unhandledCriticalExtensions
+	}
+
+	roots, ok := opts.GetEKRoots()
+	if !ok {
+		return nil, NewErrorISE("no root CA bundle available to verify the EK certificate")
+	}
+	intermediates := x509.NewCertPool()
+	for _, cert := range certs[1:] {
+		intermediates.AddCert(cert)
+	}
+	verifiedChains, err := ekCert.Verify(x509.VerifyOptions{
+		Roots:         roots,
+		Intermediates: intermediates,
+		CurrentTime:   time.Now().Truncate(time.Second),
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageAny},
+	})
+	if err != nil {
+		return nil, WrapDetailedError(ErrorBadAttestationStatementType, err, "ekx5c is not valid")
+	}
+
+	chain := verifiedChains[0]
+	v := &provisioner.TPMEKValidation{
+		Chain: make([]string, len(chain)),
+	}
+	for i, cert := range chain {
+		v.Chain[i] = cert.Subject.String()
+	}
+
+	if opts.CheckEKRevocation {
+		if err := checkTPMEKRevocation(ctx, chain); err != nil {
+			return nil, err
+		}
+		v.RevocationChecked = true
+	}
+
+	pubBytes, err := x509.MarshalPKIXPublicKey(ekCert.PublicKey)
+	if err != nil {
+		return nil, WrapErrorISE(err, "error marshaling EK public key")
+	}
+	sum := sha256.Sum256(pubBytes)
+	v.EKKeyID = ekKeyIDPrefix + base64.StdEncoding.EncodeToString(sum[:])
+
+	// If the AK certificate identifies the EK it was created with, it must
+	// be the validated one. The EK chain alone does not prove that the AK
+	// lives in the same TPM, so binding the permanent identifier requires
+	// the AK certificate to identify the EK.
+	var akEKKeyIDs []string
+	for _, u := range akCert.URIs {
+		if s := u.String(); strings.HasPrefix(s, ekKeyIDPrefix) {
+			akEKKeyIDs = append(akEKKeyIDs, s)
+		}
+	}
+	switch {
+	case len(akEKKeyIDs) == 0 && opts.BindPermanentIdentifier:
+		return nil, NewDetailedError(ErrorBadAttestationStatementType, "AK certificate does not identify the EK certificate")
+	case len(akEKKeyIDs) > 0 && !slices.Contains(akEKKeyIDs, v.EKKeyID):
+		return nil, NewDetailedError(ErrorBadAttestationStatementType, "AK certificate does not match the EK certificate")
+	}
+
+	v.PermanentIdentifierBound = opts.BindPermanentIdentifier
+	return v, nil
+}
+
+// checkTPMEKRevocation checks the revocation status of the certificates in the
+// verified EK chain, except the root, using the CRLs in their CRL distribution
+// points. The EK certificate must have CRL distribution points, intermediates
+// without them are not checked.
+func checkTPMEKRevocation(ctx context.Context, chain []*x509.Certificate) error {
+	vc := MustClientFromContext(ctx)
+	now := time.Now()
+	for i := 0; i < len(chain)-1; i++ {
+		cert, issuer := chain[i], chain[i+1]
+		if len(cert.CRLDistributionPoints) == 0 {
+			if i > 0 {
+				continue
+			}
+			return NewDetailedError(ErrorBadAttestationStatementType, "EK certificate does not have CRL distribution points")
+		}
+		var crl *x509.RevocationList
+		var errs []string
+		for _, u := range cert.CRLDistributionPoints {
+			c, err := fetchTPMEKCRL(vc, u)
+			if err != nil {
+				errs = append(errs, err.Error())
+				continue
+			}
+			if err := c.CheckSignatureFrom(issuer); err != nil {
+				errs = append(errs, fmt.Sprintf("error validating CRL %s: %v", u, err))
+				continue
+			}
+			if !c.NextUpdate.IsZero() && now.After(c.NextUpdate) {
+				errs = append(errs, fmt.Sprintf("CRL %s is expired", u))
+				continue
+			}
+			crl = c
+			break
+		}
+		if crl == nil {
+			return NewErrorISE("error checking EK certificate %q revocation: %s", cert.Subject, strings.Join(errs, "; "))
+		}
+		for _, rc := range crl.RevokedCertificateEntries {
+			if rc.SerialNumber.Cmp(cert.SerialNumber) == 0 {
+				return NewDetailedError(ErrorBadAttestationStatementType, "EK certificate %q is revoked", cert.Subject)
+			}
+		}
+	}
+	return nil
+}
+
+func fetchTPMEKCRL(vc Client, u string) (*x509.RevocationList, error) {
+	resp, err := vc.Get(u)
+	if err != nil {
+		return nil, fmt.Errorf("error fetching CRL %s: %w", u, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 400 {
+		return nil, fmt.Errorf("error fetching CRL %s: status code %d", u, resp.StatusCode)
+	}
+	b, err := io.ReadAll(io.LimitReader(resp.Body, 10<<20))
+	if err != nil {
+		return nil, fmt.Errorf("error reading CRL %s: %w", u, err)
+	}
+	if block, _ := pem.Decode(b); block != nil {
+		b = block.Bytes
+	}
+	crl, err := x509.ParseRevocationList(b)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing CRL %s: %w", u, err)
+	}
+	return crl, nil
+}
+
 var (
 	oidExtensionExtendedKeyUsage = asn1.ObjectIdentifier{2, 5, 29, 37}
 	oidTCGKpAIKCertificate       = asn1.ObjectIdentifier{2, 23, 133, 8, 3}
@@ -1505,6 +1789,241 @@ func doStepAttestationFormat(_ context.Context, prov Provisioner, ch *Challenge,
 	return data, nil
 }
 
+// oidAndroidKeyDescription is the OID of the Android Key Attestation extension,
+// see https://source.android.com/docs/security/features/keystore/attestation.
+var oidAndroidKeyDescription = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 11129, 2, 1, 17}
+
+// Android security levels and verified boot states.
+const (
+	androidSecurityLevelTrustedEnvironment asn1.Enumerated = 1
+	androidSecurityLevelStrongBox          asn1.Enumerated = 2
+	androidVerifiedBootStateVerified       asn1.Enumerated = 0
+)
+
+// Tags of the AuthorizationList fields used by the android-key format.
+const (
+	androidTagRootOfTrust               = 704
+	androidTagAttestationIDBrand        = 710
+	androidTagAttestationIDDevice       = 711
+	androidTagAttestationIDProduct      = 712
+	androidTagAttestationIDSerial       = 713
+	androidTagAttestationIDIMEI         = 714
+	androidTagAttestationIDMEID         = 715
+	androidTagAttestationIDManufacturer = 716
+	androidTagAttestationIDModel        = 717
+	androidTagAttestationIDSecondIMEI   = 723
+)
+
+// androidKeyDescription is the KeyDescription sequence of the Android Key
+// Attestation extension.
+type androidKeyDescription struct {
+	AttestationVersion       int
+	AttestationSecurityLevel asn1.Enumerated
+	KeyMintVersion           int
+	KeyMintSecurityLevel     asn1.Enumerated
+	AttestationChallenge     []byte
+	UniqueID                 []byte
+	SoftwareEnforced         asn1.RawValue
+	HardwareEnforced         asn1.RawValue
+}
+
+// androidRootOfTrust is the RootOfTrust sequence of an AuthorizationList.
+type androidRootOfTrust struct {
+	VerifiedBootKey   []byte
+	DeviceLocked      bool
+	VerifiedBootState asn1.Enumerated
+	VerifiedBootHash  []byte `asn1:"optional"`
+}
+
+// androidAuthorizationList contains the fields of an AuthorizationList used by
+// the android-key format.
+type androidAuthorizationList struct {
+	RootOfTrust  *androidRootOfTrust
+	Brand        string
+	Device       string
+	Product      string
+	Serial       string
+	IMEI         string
+	SecondIMEI   string
+	MEID         string
+	Manufacturer string
+	Model        string
+}
+
+// parseAndroidAuthorizationList parses the fields of an AuthorizationList used
+// by the android-key format, the rest of fields are ignored.
+func parseAndroidAuthorizationList(list asn1.RawValue) (*androidAuthorizationList, error) {
+	if list.Class != asn1.ClassUniversal || list.Tag != asn1.TagSequence {
+		return nil, errors.New("authorization list is not a sequence")
+	}
+
+	ret := new(androidAuthorizationList)
+	ids := map[int]*string{
+		androidTagAttestationIDBrand:        &ret.Brand,
+		androidTagAttestationIDDevice:       &ret.Device,
+		androidTagAttestationIDProduct:      &ret.Product,
+		androidTagAttestationIDSerial:       &ret.Serial,
+		androidTagAttestationIDIMEI:         &ret.IMEI,
+		androidTagAttestationIDSecondIMEI:   &ret.SecondIMEI,
+		androidTagAttestationIDMEID:         &ret.MEID,
+		androidTagAttestationIDManufacturer: &ret.Manufacturer,
+		androidTagAttestationIDModel:        &ret.Model,
+	}
+	for rest := list.Bytes; len(rest) > 0; {
+		var field asn1.RawValue
+		var err error
+		if rest, err = asn1.Unmarshal(rest, &field); err != nil {
+			return nil, fmt.Errorf("error parsing authorization list: %w", err)
+		}
+		if field.Class != asn1.ClassContextSpecific {
+			continue
+		}
+		switch field.Tag {
+		case androidTagRootOfTrust:
+			ret.RootOfTrust = new(androidRootOfTrust)
+			if _, err := asn1.Unmarshal(field.Bytes, ret.RootOfTrust); err != nil {
+				return nil, fmt.Errorf("error parsing root of trust: %w", err)
+			}
+		default:
+			if v, ok := ids[field.Tag]; ok {
+				var b []byte
+				if _, err := asn1.Unmarshal(field.Bytes, &b); err != nil {
+					return nil, fmt.Errorf("error parsing authorization list tag %d: %w", field.Tag, err)
+				}
+				*v = string(b)
+			}
+		}
+	}
+	return ret, nil
+}
+
+type androidKeyAttestationData struct {
+	Certificate          *x509.Certificate
+	VerifiedChains       [][]*x509.Certificate
+	PermanentIdentifiers []string
+	Device               *provisioner.AndroidDevice
+	Fingerprint          string
+}
+
+func doAndroidKeyAttestationFormat(_ context.Context, prov Provisioner, ch *Challenge, jwk *jose.JSONWebKey, att *attestationObject) (*androidKeyAttestationData, error) {
+	// Google attestation roots must be configured.
+	roots, ok := prov.GetAttestationRoots()
+	if !ok {
+		return nil, NewErrorISE("no root CA bundle available to verify the attestation certificate")
+	}
+
+	// Extract x5c and verify certificate
+	x5c, ok := att.AttStatement["x5c"].([]interface{})
+	if !ok {
+		return nil, NewDetailedError(ErrorBadAttestationStatementType, "x5c not present")
+	}
+	if len(x5c) == 0 {
+		return nil, NewDetailedError(ErrorRejectedIdentifierType, "x5c is empty")
+	}
+	der, ok := x5c[0].([]byte)
+	if !ok {
+		return nil, NewDetailedError(ErrorBadAttestationStatementType, "x5c is malformed")
+	}
+	leaf, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, WrapDetailedError(ErrorBadAttestationStatementType, err, "x5c is malformed")
+	}
+	intermediates := x509.NewCertPool()
+	for _, v := range x5c[1:] {
+		der, ok = v.([]byte)
+		if !ok {
+			return nil, NewDetailedError(ErrorBadAttestationStatementType, "x5c is malformed")
+		}
+		cert, err := x509.ParseCertificate(der)
+		if err != nil {
+			return nil, WrapDetailedError(ErrorBadAttestationStatementType, err, "x5c is malformed")
+		}
+		intermediates.AddCert(cert)
+	}
+	verifiedChains, err := leaf.Verify(x509.VerifyOptions{
+		Intermediates: intermediates,
+		Roots:         roots,
+		CurrentTime:   time.Now().Truncate(time.Second),
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageAny},
+	})
+	if err != nil {
+		return nil, WrapDetailedError(ErrorBadAttestationStatementType, err, "x5c is not valid")
+	}
+
+	// Parse the key description extension.
+	var desc *androidKeyDescription
+	for _, ext := range leaf.Extensions {
+		if !ext.Id.Equal(oidAndroidKeyDescription) {
+			continue
+		}
+		desc = new(androidKeyDescription)
+		if rest, err := asn1.Unmarshal(ext.Value, desc); err != nil || len(rest) > 0 {
+			return nil, WrapDetailedError(ErrorBadAttestationStatementType, err, "error parsing key description")
+		}
+		break
+	}
+	if desc == nil {
+		return nil, NewDetailedError(ErrorBadAttestationStatementType, "key description not present")
+	}
+
+	// The attestation challenge must be the SHA-256 digest of the key
+	// authorization.
+	keyAuth, err := KeyAuthorization(ch.Token, jwk)
+	if err != nil {
+		return nil, WrapErrorISE(err, "failed creating key auth digest")
+	}
+	hashedKeyAuth := sha256.Sum256([]byte(keyAuth))
+	if subtle.ConstantTimeCompare(hashedKeyAuth[:], desc.AttestationChallenge) == 0 {
+		return nil, NewDetailedError(ErrorBadAttestationStatementType, "key authorization invalid")
+	}
+
+	// Only keys in a TEE or a StrongBox are accepted.
+	if !isAndroidHardwareSecurityLevel(desc.AttestationSecurityLevel) || !isAndroidHardwareSecurityLevel(desc.KeyMintSecurityLevel) {
+		return nil, NewDetailedError(ErrorBadAttestationStatementType, "attestation security level %d is not hardware-backed", desc.AttestationSecurityLevel)
+	}
+
+	// Only the values enforced by the hardware are trusted.
+	hw, err := parseAndroidAuthorizationList(desc.HardwareEnforced)
+	if err != nil {
+		return nil, WrapDetailedError(ErrorBadAttestationStatementType, err, "error parsing hardware enforced authorization list")
+	}
+	switch {
+	case hw.RootOfTrust == nil:
+		return nil, NewDetailedError(ErrorBadAttestationStatementType, "root of trust not present")
+	case hw.RootOfTrust.VerifiedBootState != androidVerifiedBootStateVerified:
+		return nil, NewDetailedError(ErrorBadAttestationStatementType, "verified boot state %d is not verified", hw.RootOfTrust.VerifiedBootState)
+	case !hw.RootOfTrust.DeviceLocked:
+		return nil, NewDetailedError(ErrorBadAttestationStatementType, "device bootloader is not locked")
+	}
+
+	data := &androidKeyAttestationData{
+		Certificate:    leaf,
+		VerifiedChains: verifiedChains,
+	}
+	for _, id := range []string{hw.Serial, hw.IMEI, hw.SecondIMEI, hw.MEID} {
+		if id != "" {
+			data.PermanentIdentifiers = append(data.PermanentIdentifiers, id)
+		}
+	}
+	data.Device = &provisioner.AndroidDevice{
+		Brand:             hw.Brand,
+		Manufacturer:      hw.Manufacturer,
+		Model:             hw.Model,
+		DeviceIdentifiers: data.PermanentIdentifiers,
+	}
+	if data.Fingerprint, err = keyutil.Fingerprint(leaf.PublicKey); err != nil {
+		return nil, WrapErrorISE(err, "error calculating key fingerprint")
+	}
+
+	return data, nil
+}
+
+// isAndroidHardwareSecurityLevel returns true if the given security level is
+// TrustedEnvironment or StrongBox.
+func isAndroidHardwareSecurityLevel(level asn1.Enumerated) bool {
+	return level == androidSecurityLevelTrustedEnvironment || level == androidSecurityLevelStrongBox
+}
+
 // serverName determines the SNI HostName to set based on an acme.Challenge
 // for TLS-ALPN-01 challenges RFC8738 states that, if HostName is an IP, it
 // should be the ARPA address https://datatracker.ietf.org/doc/html/rfc8738#section-6.
@@ -24,6 +24,7 @@ import (
 	"net"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
 	"reflect"
 	"strconv"
 	"strings"
@@ -46,11 +47,15 @@ import (
 type mockClient struct {
 	get       func(url string) (*http.Response, error)
 	lookupTxt func(name string) ([]string, error)
+	lookupCAA func(name string) ([]*CAARecord, error)
 	tlsDial   func(network, addr string, config *tls.Config) (*tls.Conn, error)
 }
 
 func (m *mockClient) Get(url string) (*http.Response, error)  { return m.get(url) }
 func (m *mockClient) LookupTxt(name string) ([]string, error) { return m.lookupTxt(name) }
+func (m *mockClient) LookupCAA(_ context.Context, name string) ([]*CAARecord, error) {
+	return m.lookupCAA(name)
+}
 func (m *mockClient) TLSDial(network, addr string, tlsConfig *tls.Config) (*tls.Conn, error) {
 	return m.tlsDial(network, addr, tlsConfig)
 }
@@ -2146,6 +2151,81 @@ func TestDNS01Validate(t *testing.T) {
 	}
 }
 
+func Test_dnsAccount01ChallengeHost(t *testing.T) {
+	// Example from draft-ietf-acme-dns-account-label.
+	assert.Equal(t, "_ujmmovf2vn55tgye._acme-challenge.example.org",
+		dnsAccount01ChallengeHost("https://example.com/acme/acct/ExampleAccount", "example.org"))
+}
+
+func TestDNSAccount01Validate(t *testing.T) {
+	jwk, err := jose.GenerateJWK("EC", "P-256", "ES256", "sig", "", 0)
+	require.NoError(t, err)
+	keyAuth, err := KeyAuthorization("token", jwk)
+	require.NoError(t, err)
+	h := sha256.Sum256([]byte(keyAuth))
+	expected := base64.RawURLEncoding.EncodeToString(h[:])
+
+	linker := NewLinker("ca.example.com", "acme")
+	ctx := NewProvisionerContext(context.Background(), &MockProvisioner{Mret1: "acme"})
+	accountURL := linker.GetLink(ctx, AccountLinkType, "accID")
+	wantHost := dnsAccount01ChallengeHost(accountURL, "zap.internal")
+	assert.NotEqual(t, dnsAccount01ChallengeHost(linker.GetLink(ctx, AccountLinkType, "otherID"), "zap.internal"), wantHost)
+
+	tests := []struct {
+		name       string
+		linker     Linker
+		txtRecords map[string][]string
+		wantStatus Status
+		wantErr    bool
+	}{
+		{"ok", linker, map[string][]string{wantHost: {"foo", expected}}, StatusValid, false},
+		{"ok/dns-01 record", linker, map[string][]string{dns01ChallengeHost("zap.internal"): {expected}}, StatusPending, false},
+		{"ok/mismatch", linker, map[string][]string{wantHost: {"foo"}}, StatusPending, false},
+		{"fail/missing-linker", nil, nil, StatusPending, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ch := &Challenge{
+				ID:        "chID",
+				AccountID: "accID",
+				Type:      DNSACCOUNT01,
+				Token:     "token",
+				Value:     "*.zap.internal",
+				Status:    StatusPending,
+			}
+			var updated *Challenge
+			db := &MockDB{
+				MockUpdateChallenge: func(ctx context.Context, updch *Challenge) error {
+					updated = updch
+					return nil
+				},
+			}
+			ctx := NewClientContext(ctx, &mockClient{
+				lookupTxt: func(name string) ([]string, error) {
+					return tt.txtRecords[name], nil
+				},
+			})
+			if tt.linker != nil {
+				ctx = NewLinkerContext(ctx, tt.linker)
+			}
+
+			err := ch.Validate(ctx, db, jwk, nil)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			require.NotNil(t, updated)
+			assert.Equal(t, tt.wantStatus, updated.Status)
+			if tt.wantStatus == StatusValid {
+				assert.Nil(t, updated.Error)
+			} else {
+				assert.NotNil(t, updated.Error)
+			}
+		})
+	}
+}
+
 type tlsDialer func(network, addr string, config *tls.Config) (conn *tls.Conn, err error)
 
 func newTestTLSALPNServer(validationCert *tls.Certificate, opts ...func(*httptest.Server)) (*httptest.Server, tlsDialer) {
@@ -5158,3 +5238,397 @@ func Test_dns01ChallengeHost(t *testing.T) {
 		})
 	}
 }
+
+// testAndroidAuthorizationList contains the fields of the AuthorizationList
+// of an Android Key Attestation extension used in the tests.
+type testAndroidAuthorizationList struct {
+	Purpose     []int
+	RootOfTrust *androidRootOfTrust
+	Serial      []byte
+	IMEI        []byte
+	Model       []byte
+}
+
+func mustAndroidKeyDescription(t *testing.T, desc *androidKeyDescription, hw *testAndroidAuthorizationList) []byte {
+	t.Helper()
+
+	type authorizationList struct {
+		Purpose     []int         `asn1:"explicit,tag:1,set,optional"`
+		RootOfTrust asn1.RawValue `asn1:"optional"`
+		Serial      []byte        `asn1:"explicit,tag:713,optional"`
+		IMEI        []byte        `asn1:"explicit,tag:714,optional"`
+		Model       []byte        `asn1:"explicit,tag:717,optional"`
+	}
+	list := authorizationList{
+		Purpose: hw.Purpose,
+		Serial:  hw.Serial,
+		IMEI:    hw.IMEI,
+		Model:   hw.Model,
+	}
+	if hw.RootOfTrust != nil {
+		b, err := asn1.Marshal(*hw.RootOfTrust)
+		require.NoError(t, err)
+		list.RootOfTrust = asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: androidTagRootOfTrust, IsCompound: true, Bytes: b}
+	}
+
+	sw, err := asn1.Marshal(authorizationList{Purpose: []int{2, 3}})
+	require.NoError(t, err)
+	hwBytes, err := asn1.Marshal(list)
+	require.NoError(t, err)
+	desc.SoftwareEnforced = asn1.RawValue{FullBytes: sw}
+	desc.HardwareEnforced = asn1.RawValue{FullBytes: hwBytes}
+	b, err := asn1.Marshal(*desc)
+	require.NoError(t, err)
+	return b
+}
+
+// mustAttestAndroidKey returns an android-key attestation object with a valid
+// key description. The description can be modified with the given function.
+func mustAttestAndroidKey(t *testing.T, ca *minica.CA, keyAuthorization string, modify func(*androidKeyDescription, *testAndroidAuthorizationList)) (*attestationObject, *x509.Certificate) {
+	t.Helper()
+
+	signer, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	keyAuthSum := sha256.Sum256([]byte(keyAuthorization))
+	desc := &androidKeyDescription{
+		AttestationVersion:       300,
+		AttestationSecurityLevel: androidSecurityLevelTrustedEnvironment,
+		KeyMintVersion:           300,
+		KeyMintSecurityLevel:     androidSecurityLevelTrustedEnvironment,
+		AttestationChallenge:     keyAuthSum[:],
+	}
+	hw := &testAndroidAuthorizationList{
+		Purpose: []int{2, 3},
+		RootOfTrust: &androidRootOfTrust{
+			VerifiedBootKey:   []byte("boot-key"),
+			DeviceLocked:      true,
+			VerifiedBootState: androidVerifiedBootStateVerified,
+			VerifiedBootHash:  []byte("boot-hash"),
+		},
+		Serial: []byte("serial-number"),
+		IMEI:   []byte("490154203237518"),
+		Model:  []byte("Pixel 8"),
+	}
+	if modify != nil {
+		modify(desc, hw)
+	}
+
+	leaf, err := ca.Sign(&x509.Certificate{
+		Subject:   pkix.Name{CommonName: "Android Keystore Key"},
+		PublicKey: signer.Public(),
+		ExtraExtensions: []pkix.Extension{
+			{Id: oidAndroidKeyDescription, Value: mustAndroidKeyDescription(t, desc, hw)},
+		},
+	})
+	require.NoError(t, err)
+
+	return &attestationObject{
+		Format: "android-key",
+		AttStatement: map[string]interface{}{
+			"x5c": []interface{}{leaf.Raw, ca.Intermediate.Raw},
+		},
+	}, leaf
+}
+
+func mustAndroidKeyProvisioner(t *testing.T, roots []byte) Provisioner {
+	t.Helper()
+
+	prov := &provisioner.ACME{
+		Type:               "ACME",
+		Name:               "acme",
+		Challenges:         []provisioner.ACMEChallenge{provisioner.DEVICE_ATTEST_01},
+		AttestationFormats: []provisioner.ACMEAttestationFormat{provisioner.ANDROID_KEY},
+		AttestationRoots:   roots,
+	}
+	require.NoError(t, prov.Init(provisioner.Config{
+		Claims: config.GlobalProvisionerClaims,
+	}))
+	return prov
+}
+
+func Test_doAndroidKeyAttestationFormat(t *testing.T) {
+	ca, err := minica.New()
+	require.NoError(t, err)
+	caRoot := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ca.Root.Raw})
+	otherCA, err := minica.New()
+	require.NoError(t, err)
+
+	jwk, keyAuth := mustAccountAndKeyAuthorization(t, "token")
+	ch := &Challenge{Token: "token", Value: "serial-number"}
+
+	tests := []struct {
+		name    string
+		prov    Provisioner
+		ca      *minica.CA
+		modify  func(*androidKeyDescription, *testAndroidAuthorizationList)
+		noExt   bool
+		wantErr string
+	}{
+		{"ok", mustAndroidKeyProvisioner(t, caRoot), ca, nil, false, ""},
+		{"ok/strongbox", mustAndroidKeyProvisioner(t, caRoot), ca, func(d *androidKeyDescription, _ *testAndroidAuthorizationList) {
+			d.AttestationSecurityLevel = androidSecurityLevelStrongBox
+			d.KeyMintSecurityLevel = androidSecurityLevelStrongBox
+		}, false, ""},
+		{"fail/no-roots", mustNonAttestationProvisioner(t), ca, nil, false, "no root CA bundle available"},
+		{"fail/untrusted", mustAndroidKeyProvisioner(t, caRoot), otherCA, nil, false, "x5c is not valid"},
+		{"fail/no-key-description", mustAndroidKeyProvisioner(t, caRoot), ca, nil, true, "key description not present"},
+		{"fail/challenge", mustAndroidKeyProvisioner(t, caRoot), ca, func(d *androidKeyDescription, _ *testAndroidAuthorizationList) {
+			d.AttestationChallenge = []byte("foo")
+		}, false, "key authorization invalid"},
+		{"fail/software", mustAndroidKeyProvisioner(t, caRoot), ca, func(d *androidKeyDescription, _ *testAndroidAuthorizationList) {
+			d.AttestationSecurityLevel = 0
+		}, false, "is not hardware-backed"},
+		{"fail/keymint-software", mustAndroidKeyProvisioner(t, caRoot), ca, func(d *androidKeyDescription, _ *testAndroidAuthorizationList) {
+			d.KeyMintSecurityLevel = 0
+		}, false, "is not hardware-backed"},
+		{"fail/no-root-of-trust", mustAndroidKeyProvisioner(t, caRoot), ca, func(_ *androidKeyDescription, hw *testAndroidAuthorizationList) {
+			hw.RootOfTrust = nil
+		}, false, "root of trust not present"},
+		{"fail/unverified-boot", mustAndroidKeyProvisioner(t, caRoot), ca, func(_ *androidKeyDescription, hw *testAndroidAuthorizationList) {
+			hw.RootOfTrust.VerifiedBootState = 2
+		}, false, "verified boot state 2 is not verified"},
+		{"fail/unlocked", mustAndroidKeyProvisioner(t, caRoot), ca, func(_ *androidKeyDescription, hw *testAndroidAuthorizationList) {
+			hw.RootOfTrust.DeviceLocked = false
+		}, false, "device bootloader is not locked"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			att, leaf := mustAttestAndroidKey(t, tt.ca, keyAuth, tt.modify)
+			if tt.noExt {
+				leaf, err = tt.ca.Sign(&x509.Certificate{
+					Subject:   pkix.Name{CommonName: "Android Keystore Key"},
+					PublicKey: leaf.PublicKey,
+				})
+				require.NoError(t, err)
+				att.AttStatement["x5c"] = []interface{}{leaf.Raw, tt.ca.Intermediate.Raw}
+			}
+
+			data, err := doAndroidKeyAttestationFormat(context.Background(), tt.prov, ch, jwk, att)
+			if tt.wantErr != "" {
+				assert.ErrorContains(t, err, tt.wantErr)
+				assert.Nil(t, data)
+				return
+			}
+			require.NoError(t, err)
+
+			fingerprint, err := keyutil.Fingerprint(leaf.PublicKey)
+			require.NoError(t, err)
+			assert.Equal(t, leaf, data.Certificate)
+			assert.Len(t, data.VerifiedChains, 1)
+			assert.Equal(t, []string{"serial-number", "490154203237518"}, data.PermanentIdentifiers)
+			assert.Equal(t, &provisioner.AndroidDevice{
+				Model:             "Pixel 8",
+				DeviceIdentifiers: []string{"serial-number", "490154203237518"},
+			}, data.Device)
+			assert.Equal(t, fingerprint, data.Fingerprint)
+		})
+	}
+}
+
+func Test_deviceAttest01Validate_androidKey(t *testing.T) {
+	ca, err := minica.New()
+	require.NoError(t, err)
+	caRoot := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ca.Root.Raw})
+	ctx := NewProvisionerContext(context.Background(), mustAndroidKeyProvisioner(t, caRoot))
+
+	jwk, keyAuth := mustAccountAndKeyAuthorization(t, "token")
+	att, leaf := mustAttestAndroidKey(t, ca, keyAuth, nil)
+	attObj, err := cbor.Marshal(att)
+	require.NoError(t, err)
+	payload, err := json.Marshal(struct {
+		AttObj string `json:"attObj"`
+	}{
+		AttObj: base64.RawURLEncoding.EncodeToString(attObj),
+	})
+	require.NoError(t, err)
+	fingerprint, err := keyutil.Fingerprint(leaf.PublicKey)
+	require.NoError(t, err)
+
+	tests := []struct {
+		name       string
+		value      string
+		wantStatus Status
+	}{
+		{"ok/serial", "serial-number", StatusValid},
+		{"ok/imei", "490154203237518", StatusValid},
+		{"fail/identifier", "12345678", StatusInvalid},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ch := &Challenge{
+				ID:              "chID",
+				AuthorizationID: "azID",
+				Token:           "token",
+				Type:            DEVICEATTEST01,
+				Status:          StatusPending,
+				Value:           tt.value,
+			}
+			var updated *Challenge
+			db := &MockDB{
+				MockGetAuthorization: func(ctx context.Context, id string) (*Authorization, error) {
+					return &Authorization{ID: "azID"}, nil
+				},
+				MockUpdateAuthorization: func(ctx context.Context, az *Authorization) error {
+					assert.Equal(t, fingerprint, az.Fingerprint)
+					assert.Equal(t, &provisioner.AndroidDevice{
+						Model:             "Pixel 8",
+						DeviceIdentifiers: []string{"serial-number", "490154203237518"},
+					}, az.AndroidDevice)
+					return nil
+				},
+				MockUpdateChallenge: func(ctx context.Context, updch *Challenge) error {
+					updated = updch
+					return nil
+				},
+			}
+
+			require.NoError(t, deviceAttest01Validate(ctx, ch, db, jwk, payload))
+			require.NotNil(t, updated)
+			assert.Equal(t, tt.wantStatus, updated.Status)
+			if tt.wantStatus == StatusValid {
+				assert.Nil(t, updated.Error)
+				assert.Equal(t, "android-key", updated.PayloadFormat)
+			} else {
+				assert.Contains(t, updated.Error.Detail, "permanent identifier does not match")
+				require.Len(t, updated.Error.Subproblems, 1)
+			}
+		})
+	}
+}
+
+func Test_validateTPMEKCertificate(t *testing.T) {
+	eca, err := minica.New(minica.WithName("TPM Manufacturer"))
+	require.NoError(t, err)
+	ekRoot := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: eca.Root.Raw})
+	otherCA, err := minica.New()
+	require.NoError(t, err)
+
+	ekKey, err := keyutil.GenerateDefaultSigner()
+	require.NoError(t, err)
+	ekCert, err := eca.Sign(&x509.Certificate{
+		SerialNumber:          big.NewInt(1234),
+		PublicKey:             ekKey.Public(),
+		CRLDistributionPoints: []string{"http://crl.example.com/ek.crl"},
+	})
+	require.NoError(t, err)
+	pubBytes, err := x509.MarshalPKIXPublicKey(ekKey.Public())
+	require.NoError(t, err)
+	sum := sha256.Sum256(pubBytes)
+	ekKeyID := "urn:ek:sha256:" + base64.StdEncoding.EncodeToString(sum[:])
+
+	mustURL := func(s string) *url.URL {
+		u, err := url.Parse(s)
+		require.NoError(t, err)
+		return u
+	}
+	mustCRL := func(signer crypto.Signer, issuer *x509.Certificate, nextUpdate time.Time, revoked ...*big.Int) []byte {
+		rl := &x509.RevocationList{
+			Number:     big.NewInt(1),
+			ThisUpdate: time.Now().Add(-time.Hour),
+			NextUpdate: nextUpdate,
+		}
+		for _, sn := range revoked {
+			rl.RevokedCertificateEntries = append(rl.RevokedCertificateEntries, x509.RevocationListEntry{
+				SerialNumber:   sn,
+				RevocationTime: time.Now().Add(-time.Minute),
+			})
+		}
+		b, err := x509.CreateRevocationList(rand.Reader, rl, issuer, signer)
+		require.NoError(t, err)
+		return b
+	}
+	crlClient := func(b []byte) *mockClient {
+		return &mockClient{
+			get: func(url string) (*http.Response, error) {
+				assert.Equal(t, "http://crl.example.com/ek.crl", url)
+				return &http.Response{
+					StatusCode: http.StatusOK,
+					Body:       io.NopCloser(bytes.NewReader(b)),
+				}, nil
+			},
+		}
+	}
+	newOpts := func(o *provisioner.ACMETPMAttestation) *provisioner.ACMETPMAttestation {
+		o.EKRoots = ekRoot
+		p := &provisioner.ACME{Type: "ACME", Name: "acme", TPMAttestation: o}
+		require.NoError(t, p.Init(provisioner.Config{Claims: config.GlobalProvisionerClaims}))
+		return p.TPMAttestation
+	}
+	newAtt := func(ekx5c ...[]byte) *attestationObject {
+		att := &attestationObject{Format: "tpm", AttStatement: map[string]interface{}{}}
+		if ekx5c != nil {
+			chain := make([]interface{}, len(ekx5c))
+			for i, b := range ekx5c {
+				chain[i] = b
+			}
+			att.AttStatement["ekx5c"] = chain
+		}
+		return att
+	}
+
+	chain := []string{"", "CN=TPM Manufacturer Intermediate CA", "CN=TPM Manufacturer Root CA"}
+	tests := []struct {
+		name    string
+		ctx     context.Context
+		opts    *provisioner.ACMETPMAttestation
+		akCert  *x509.Certificate
+		att     *attestationObject
+		want    *provisioner.TPMEKValidation
+		wantErr string
+	}{
+		{"ok", context.Background(), newOpts(&provisioner.ACMETPMAttestation{RequireEKCertificate: true}),
+			&x509.Certificate{}, newAtt(ekCert.Raw, eca.Intermediate.Raw),
+			&provisioner.TPMEKValidation{EKKeyID: ekKeyID, Chain: chain}, ""},
+		{"ok/ak ek uri", context.Background(), newOpts(&provisioner.ACMETPMAttestation{RequireEKCertificate: true, BindPermanentIdentifier: true}),
+			&x509.Certificate{URIs: []*url.URL{mustURL("spiffe://example.com/tpm"), mustURL(ekKeyID)}}, newAtt(ekCert.Raw, eca.Intermediate.Raw),
+			&provisioner.TPMEKValidation{EKKeyID: ekKeyID, Chain: chain, PermanentIdentifierBound: true}, ""},
+		{"ok/not present", context.Background(), newOpts(&provisioner.ACMETPMAttestation{}),
+			&x509.Certificate{}, newAtt(), nil, ""},
+		{"ok/revocation", NewClientContext(context.Background(), crlClient(mustCRL(eca.Signer, eca.Intermediate, time.Now().Add(time.Hour), big.NewInt(1)))),
+			newOpts(&provisioner.ACMETPMAttestation{CheckEKRevocation: true}),
+			&x509.Certificate{}, newAtt(ekCert.Raw, eca.Intermediate.Raw),
+			&provisioner.TPMEKValidation{EKKeyID: ekKeyID, Chain: chain, RevocationChecked: true}, ""},
+		{"fail/required", context.Background(), newOpts(&provisioner.ACMETPMAttestation{RequireEKCertificate: true}),
+			&x509.Certificate{}, newAtt(), nil, "ekx5c not present"},
+		{"fail/malformed", context.Background(), newOpts(&provisioner.ACMETPMAttestation{}),
+			&x509.Certificate{}, newAtt([]byte("foo")), nil, "ekx5c is malformed"},
+		{"fail/untrusted", context.Background(), newOpts(&provisioner.ACMETPMAttestation{}),
+			&x509.Certificate{}, newAtt(ekCert.Raw, otherCA.Intermediate.Raw), nil, "ekx5c is not valid"},
+		{"fail/ak ek uri", context.Background(), newOpts(&provisioner.ACMETPMAttestation{}),
+			&x509.Certificate{URIs: []*url.URL{mustURL("urn:ek:sha256:Zm9vYmFy")}}, newAtt(ekCert.Raw, eca.Intermediate.Raw),
+			nil, "AK certificate does not match the EK certificate"},
+		{"fail/bind without ak ek uri", context.Background(), newOpts(&provisioner.ACMETPMAttestation{RequireEKCertificate: true, BindPermanentIdentifier: true}),
+			&x509.Certificate{URIs: []*url.URL{mustURL("spiffe://example.com/tpm")}}, newAtt(ekCert.Raw, eca.Intermediate.Raw),
+			nil, "AK certificate does not identify the EK certificate"},
+		{"fail/bind ak ek uri", context.Background(), newOpts(&provisioner.ACMETPMAttestation{RequireEKCertificate: true, BindPermanentIdentifier: true}),
+			&x509.Certificate{URIs: []*url.URL{mustURL("urn:ek:sha256:Zm9vYmFy")}}, newAtt(ekCert.Raw, eca.Intermediate.Raw),
+			nil, "AK certificate does not match the EK certificate"},
+		{"fail/revoked", NewClientContext(context.Background(), crlClient(mustCRL(eca.Signer, eca.Intermediate, time.Now().Add(time.Hour), big.NewInt(1234)))),
+			newOpts(&provisioner.ACMETPMAttestation{CheckEKRevocation: true}),
+			&x509.Certificate{}, newAtt(ekCert.Raw, eca.Intermediate.Raw), nil, "EK certificate \"\" is revoked"},
+		{"fail/crl expired", NewClientContext(context.Background(), crlClient(mustCRL(eca.Signer, eca.Intermediate, time.Now().Add(-time.Minute)))),
+			newOpts(&provisioner.ACMETPMAttestation{CheckEKRevocation: true}),
+			&x509.Certificate{}, newAtt(ekCert.Raw, eca.Intermediate.Raw), nil, "CRL http://crl.example.com/ek.crl is expired"},
+		{"fail/crl signature", NewClientContext(context.Background(), crlClient(mustCRL(otherCA.Signer, otherCA.Intermediate, time.Now().Add(time.Hour)))),
+			newOpts(&provisioner.ACMETPMAttestation{CheckEKRevocation: true}),
+			&x509.Certificate{}, newAtt(ekCert.Raw, eca.Intermediate.Raw), nil, "error validating CRL http://crl.example.com/ek.crl"},
+		{"fail/crl fetch", NewClientContext(context.Background(), &mockClient{
+			get: func(string) (*http.Response, error) { return nil, errors.New("force") },
+		}), newOpts(&provisioner.ACMETPMAttestation{CheckEKRevocation: true}),
+			&x509.Certificate{}, newAtt(ekCert.Raw, eca.Intermediate.Raw), nil, "error fetching CRL http://crl.example.com/ek.crl: force"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := validateTPMEKCertificate(tt.ctx, tt.opts, tt.akCert, tt.att)
+			if tt.wantErr != "" {
+				var acmeErr *Error
+				require.ErrorAs(t, err, &acmeErr)
+				assert.Contains(t, acmeErr.Err.Error(), tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
@@ -29,6 +29,9 @@ import (
 	"go.step.sm/crypto/tpm/simulator"
 	tpmstorage "go.step.sm/crypto/tpm/storage"
 	"go.step.sm/crypto/x509util"
+
+	"github.com/smallstep/certificates/authority/config"
+	"github.com/smallstep/certificates/authority/provisioner"
 )
 
 func newSimulatedTPM(t *testing.T) *tpm.TPM {
@@ -65,6 +68,14 @@ func generateKeyID(t *testing.T, pub crypto.PublicKey) []byte {
 }
 
 func mustAttestTPM(t *testing.T, keyAuthorization string, permanentIdentifiers []string) ([]byte, crypto.Signer, *x509.Certificate) {
+	t.Helper()
+	return mustAttestTPMWithEKCertificate(t, keyAuthorization, permanentIdentifiers, nil)
+}
+
+// mustAttestTPMWithEKCertificate attests a key like mustAttestTPM, and if eca
+// is not nil, it adds an EK certificate signed by it to the attestation
+// statement.
+func mustAttestTPMWithEKCertificate(t *testing.T, keyAuthorization string, permanentIdentifiers []string, eca *minica.CA) ([]byte, crypto.Signer, *x509.Certificate) {
 	t.Helper()
 	aca, err := minica.New(
 		minica.WithName("TPM Testing"),
@@ -142,19 +153,27 @@ func mustAttestTPM(t *testing.T, keyAuthorization string, permanentIdentifiers [
 	// AK.
 	params, err := key.CertificationParameters(context.Background())
 	require.NoError(t, err)
+	attStmt := map[string]interface{}{
+		"ver":      "2.0",
+		"x5c":      []interface{}{akCert.Raw, aca.Intermediate.Raw},
+		"alg":      int64(-257), // RS256
+		"sig":      params.CreateSignature,
+		"certInfo": params.CreateAttestation,
+		"pubArea":  params.Public,
+	}
+	if eca != nil {
+		ekCert, err := eca.Sign(&x509.Certificate{
+			PublicKey: eks[0].Public(),
+		})
+		require.NoError(t, err)
+		attStmt["ekx5c"] = []interface{}{ekCert.Raw, eca.Intermediate.Raw}
+	}
 	attObj, err := cbor.Marshal(struct {
 		Format       string                 `json:"fmt"`
 		AttStatement map[string]interface{} `json:"attStmt,omitempty"`
 	}{
-		Format: "tpm",
-		AttStatement: map[string]interface{}{
-			"ver":      "2.0",
-			"x5c":      []interface{}{akCert.Raw, aca.Intermediate.Raw},
-			"alg":      int64(-257), // RS256
-			"sig":      params.CreateSignature,
-			"certInfo": params.CreateAttestation,
-			"pubArea":  params.Public,
-		},
+		Format:       "tpm",
+		AttStatement: attStmt,
 	})
 	require.NoError(t, err)
 
@@ -404,6 +423,125 @@ func Test_deviceAttest01ValidateWithTPMSimulator(t *testing.T) {
 	}
 }
 
+func Test_deviceAttest01ValidateWithTPMSimulator_ekCertificate(t *testing.T) {
+	eca, err := minica.New(minica.WithName("TPM Manufacturer"))
+	require.NoError(t, err)
+	ekRoot := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: eca.Root.Raw})
+
+	jwk, keyAuth := mustAccountAndKeyAuthorization(t, "token")
+	payload, signer, root := mustAttestTPMWithEKCertificate(t, keyAuth, nil, eca)
+	caRoot := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: root.Raw})
+
+	prov := &provisioner.ACME{
+		Type:             "ACME",
+		Name:             "acme",
+		Challenges:       []provisioner.ACMEChallenge{provisioner.DEVICE_ATTEST_01},
+		AttestationRoots: caRoot,
+		TPMAttestation: &provisioner.ACMETPMAttestation{
+			RequireEKCertificate:    true,
+			EKRoots:                 ekRoot,
+			BindPermanentIdentifier: true,
+		},
+	}
+	require.NoError(t, prov.Init(provisioner.Config{Claims: config.GlobalProvisionerClaims}))
+	ctx := NewProvisionerContext(context.Background(), prov)
+
+	// The permanent identifier is the EK key hash.
+	ekKeyID := ""
+	db := &MockDB{
+		MockGetAuthorization: func(ctx context.Context, id string) (*Authorization, error) {
+			return &Authorization{ID: "azID"}, nil
+		},
+		MockUpdateAuthorization: func(ctx context.Context, az *Authorization) error {
+			fingerprint, err := keyutil.Fingerprint(signer.Public())
+			require.NoError(t, err)
+			assert.Equal(t, fingerprint, az.Fingerprint)
+			require.NotNil(t, az.TPMEKValidation)
+			assert.True(t, az.TPMEKValidation.PermanentIdentifierBound)
+			assert.False(t, az.TPMEKValidation.RevocationChecked)
+			assert.Equal(t, []string{"CN=TPM Manufacturer Intermediate CA", "CN=TPM Manufacturer Root CA"}, az.TPMEKValidation.Chain[1:])
+			ekKeyID = az.TPMEKValidation.EKKeyID
+			return nil
+		},
+		MockUpdateChallenge: func(ctx context.Context, updch *Challenge) error {
+			assert.Equal(t, StatusValid, updch.Status)
+			assert.Equal(t, ekKeyID, updch.Value)
+			return nil
+		},
+	}
+
+	// Get the EK key hash from the AK certificate.
+	var p payloadType
+	require.NoError(t, json.Unmarshal(payload, &p))
+	attObj, err := base64.RawURLEncoding.DecodeString(p.AttObj)
+	require.NoError(t, err)
+	att := attestationObject{}
+	require.NoError(t, cbor.Unmarshal(attObj, &att))
+	akCert, err := x509.ParseCertificate(att.AttStatement["x5c"].([]interface{})[0].([]byte))
+	require.NoError(t, err)
+	require.Len(t, akCert.URIs, 1)
+
+	ch := &Challenge{
+		ID:              "chID",
+		AuthorizationID: "azID",
+		Token:           "token",
+		Type:            "device-attest-01",
+		Status:          StatusPending,
+		Value:           akCert.URIs[0].String(),
+	}
+	require.NoError(t, deviceAttest01Validate(ctx, ch, db, jwk, payload))
+	assert.Equal(t, StatusValid, ch.Status)
+
+	// The challenge fails with a different permanent identifier.
+	ch.Status = StatusPending
+	ch.Value = "device.id.12345678"
+	db.MockUpdateChallenge = func(ctx context.Context, updch *Challenge) error {
+		assert.Equal(t, StatusInvalid, updch.Status)
+		return nil
+	}
+	require.NoError(t, deviceAttest01Validate(ctx, ch, db, jwk, payload))
+	assert.Equal(t, StatusInvalid, ch.Status)
+
+	// The challenge fails with a valid EK chain of a different TPM.
+	foreignKey, err := keyutil.GenerateDefaultSigner()
+	require.NoError(t, err)
+	foreignCert, err := eca.Sign(&x509.Certificate{
+		PublicKey: foreignKey.Public(),
+	})
+	require.NoError(t, err)
+	att.AttStatement["ekx5c"] = []interface{}{foreignCert.Raw, eca.Intermediate.Raw}
+	attObj, err = cbor.Marshal(struct {
+		Format       string                 `json:"fmt"`
+		AttStatement map[string]interface{} `json:"attStmt,omitempty"`
+	}{
+		Format:       "tpm",
+		AttStatement: att.AttStatement,
+	})
+	require.NoError(t, err)
+	foreignPayload, err := json.Marshal(struct {
+		AttObj string `json:"attObj"`
+	}{
+		AttObj: base64.RawURLEncoding.EncodeToString(attObj),
+	})
+	require.NoError(t, err)
+
+	ch.Status = StatusPending
+	ch.Value = akCert.URIs[0].String()
+	db.MockUpdateAuthorization = func(ctx context.Context, az *Authorization) error {
+		t.Error("authorization should not be updated")
+		return nil
+	}
+	db.MockUpdateChallenge = func(ctx context.Context, updch *Challenge) error {
+		assert.Equal(t, StatusInvalid, updch.Status)
+		if assert.NotNil(t, updch.Error) {
+			assert.Contains(t, updch.Error.Err.Error(), "AK certificate does not match the EK certificate")
+		}
+		return nil
+	}
+	require.NoError(t, deviceAttest01Validate(ctx, ch, db, jwk, foreignPayload))
+	assert.Equal(t, StatusInvalid, ch.Status)
+}
+
 func newBadAttestationStatementError(msg string) *Error {
 	return &Error{
 		Type:   "urn:ietf:params:acme:error:badAttestationStatement",
@@ -16,6 +16,10 @@ type Client interface {
 	// LookupTXT returns the DNS TXT records for the given domain name.
 	LookupTxt(name string) ([]string, error)
 
+	// LookupCAA returns the DNS CAA records for the given domain name. It
+	// does not climb the DNS tree.
+	LookupCAA(ctx context.Context, name string) ([]*CAARecord, error)
+
 	// TLSDial connects to the given network address using net.Dialer and then
 	// initiates a TLS handshake, returning the resulting TLS connection.
 	TLSDial(network, addr string, config *tls.Config) (*tls.Conn, error)
@@ -47,6 +51,9 @@ func MustClientFromContext(ctx context.Context) Client {
 type client struct {
 	http   *http.Client
 	dialer *net.Dialer
+	// nameservers are the addresses of the DNS servers used to look up CAA
+	// records, if empty the ones in /etc/resolv.conf are used.
+	nameservers []string
 }
 
 // NewClient returns an implementation of Client for verifying ACME challenges.
@@ -76,6 +83,22 @@ func (c *client) LookupTxt(name string) ([]string, error) {
 	return net.LookupTXT(name)
 }
 
+func (c *client) LookupCAA(ctx context.Context, name string) ([]*CAARecord, error) {
+	servers := c.nameservers
+	if len(servers) == 0 {
+		servers = systemNameservers()
+	}
+
+	var err error
+	for _, server := range servers {
+		var records []*CAARecord
+		if records, err = lookupCAA(ctx, c.dialer, server, name); err == nil {
+			return records, nil
+		}
+	}
+	return nil, err
+}
+
 func (c *client) TLSDial(network, addr string, config *tls.Config) (*tls.Conn, error) {
 	return tls.DialWithDialer(c.dialer, network, addr, config)
 }
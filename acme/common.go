@@ -78,6 +78,7 @@ type Provisioner interface {
 	GetName() string
 	DefaultTLSCertDuration() time.Duration
 	GetOptions() *provisioner.Options
+	GetProfile(name string) (*provisioner.ACMEProfile, bool)
 }
 
 type provisionerKey struct{}
@@ -120,6 +121,7 @@ type MockProvisioner struct {
 	MgetAttestationRoots      func() (*x509.CertPool, bool)
 	MdefaultTLSCertDuration   func() time.Duration
 	MgetOptions               func() *provisioner.Options
+	MgetProfile               func(name string) (*provisioner.ACMEProfile, bool)
 }
 
 // GetName mock
@@ -193,6 +195,14 @@ func (m *MockProvisioner) GetOptions() *provisioner.Options {
 	return m.Mret1.(*provisioner.Options)
 }
 
+// GetProfile mock
+func (m *MockProvisioner) GetProfile(name string) (*provisioner.ACMEProfile, bool) {
+	if m.MgetProfile != nil {
+		return m.MgetProfile(name)
+	}
+	return nil, false
+}
+
 // GetID mock
 func (m *MockProvisioner) GetID() string {
 	if m.MgetID != nil {
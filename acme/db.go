@@ -3,6 +3,7 @@ package acme
 import (
 	"context"
 	"database/sql"
+	"time"
 
 	"github.com/pkg/errors"
 )
@@ -19,14 +20,28 @@ func IsErrNotFound(err error) bool {
 	return errors.Is(err, ErrNotFound) || errors.Is(err, sql.ErrNoRows)
 }
 
+// ErrValidationJobLocked is the error returned by the acme.DB interface when a
+// validation job is being processed by another worker.
+var ErrValidationJobLocked = errors.New("validation job is locked")
+
+// ErrOrderRenewalLocked is the error returned by the acme.DB interface when
+// the certificate of an auto-renewal order is being reissued by another
+// instance of the CA.
+var ErrOrderRenewalLocked = errors.New("order renewal is locked")
+
+// ErrCertificateReplaced is the error returned by the acme.DB interface when a
+// certificate has already been replaced by another order.
+var ErrCertificateReplaced = errors.New("certificate has already been replaced")
+
 // DB is the DB interface expected by the step-ca ACME API.
 type DB interface {
 	CreateAccount(ctx context.Context, acc *Account) error
 	GetAccount(ctx context.Context, id string) (*Account, error)
 	GetAccountByKeyID(ctx context.Context, kid string) (*Account, error)
 	UpdateAccount(ctx context.Context, acc *Account) error
+	UpdateAccountKey(ctx context.Context, acc *Account) error
 
-	CreateExternalAccountKey(ctx context.Context, provisionerID, reference string) (*ExternalAccountKey, error)
+	CreateExternalAccountKey(ctx context.Context, provisionerID, reference string, opts ExternalAccountKeyOptions) (*ExternalAccountKey, error)
 	GetExternalAccountKey(ctx context.Context, provisionerID, keyID string) (*ExternalAccountKey, error)
 	GetExternalAccountKeys(ctx context.Context, provisionerID, cursor string, limit int) ([]*ExternalAccountKey, string, error)
 	GetExternalAccountKeyByReference(ctx context.Context, provisionerID, reference string) (*ExternalAccountKey, error)
@@ -45,6 +60,7 @@ type DB interface {
 	CreateCertificate(ctx context.Context, cert *Certificate) error
 	GetCertificate(ctx context.Context, id string) (*Certificate, error)
 	GetCertificateBySerial(ctx context.Context, serial string) (*Certificate, error)
+	ReplaceCertificate(ctx context.Context, id, oldOrderID, orderID string) error
 
 	CreateChallenge(ctx context.Context, ch *Challenge) error
 	GetChallenge(ctx context.Context, id, authzID string) (*Challenge, error)
@@ -54,6 +70,15 @@ type DB interface {
 	GetOrder(ctx context.Context, id string) (*Order, error)
 	GetOrdersByAccountID(ctx context.Context, accountID string) ([]string, error)
 	UpdateOrder(ctx context.Context, o *Order) error
+	LockOrderRenewal(ctx context.Context, orderID, owner string, until time.Time) (*Order, error)
+
+	GetRateLimitCounter(ctx context.Context, key string, window time.Duration) (*RateLimitCounter, error)
+	IncrementRateLimitCounter(ctx context.Context, key string, window time.Duration) (*RateLimitCounter, error)
+
+	SaveValidationJob(ctx context.Context, job *ValidationJob) error
+	GetValidationJobs(ctx context.Context) ([]*ValidationJob, error)
+	LockValidationJob(ctx context.Context, challengeID, owner string, until time.Time) (*ValidationJob, error)
+	DeleteValidationJob(ctx context.Context, challengeID string) error
 }
 
 // WireDB is the interface used for operations on ACME Orders for Wire identifiers. This
@@ -102,8 +127,9 @@ type MockDB struct {
 	MockGetAccount        func(ctx context.Context, id string) (*Account, error)
 	MockGetAccountByKeyID func(ctx context.Context, kid string) (*Account, error)
 	MockUpdateAccount     func(ctx context.Context, acc *Account) error
+	MockUpdateAccountKey  func(ctx context.Context, acc *Account) error
 
-	MockCreateExternalAccountKey         func(ctx context.Context, provisionerID, reference string) (*ExternalAccountKey, error)
+	MockCreateExternalAccountKey         func(ctx context.Context, provisionerID, reference string, opts ExternalAccountKeyOptions) (*ExternalAccountKey, error)
 	MockGetExternalAccountKey            func(ctx context.Context, provisionerID, keyID string) (*ExternalAccountKey, error)
 	MockGetExternalAccountKeys           func(ctx context.Context, provisionerID, cursor string, limit int) ([]*ExternalAccountKey, string, error)
 	MockGetExternalAccountKeyByReference func(ctx context.Context, provisionerID, reference string) (*ExternalAccountKey, error)
@@ -122,6 +148,7 @@ type MockDB struct {
 	MockCreateCertificate      func(ctx context.Context, cert *Certificate) error
 	MockGetCertificate         func(ctx context.Context, id string) (*Certificate, error)
 	MockGetCertificateBySerial func(ctx context.Context, serial string) (*Certificate, error)
+	MockReplaceCertificate     func(ctx context.Context, id, oldOrderID, orderID string) error
 
 	MockCreateChallenge func(ctx context.Context, ch *Challenge) error
 	MockGetChallenge    func(ctx context.Context, id, authzID string) (*Challenge, error)
@@ -131,6 +158,15 @@ type MockDB struct {
 	MockGetOrder             func(ctx context.Context, id string) (*Order, error)
 	MockGetOrdersByAccountID func(ctx context.Context, accountID string) ([]string, error)
 	MockUpdateOrder          func(ctx context.Context, o *Order) error
+	MockLockOrderRenewal     func(ctx context.Context, orderID, owner string, until time.Time) (*Order, error)
+
+	MockGetRateLimitCounter       func(ctx context.Context, key string, window time.Duration) (*RateLimitCounter, error)
+	MockIncrementRateLimitCounter func(ctx context.Context, key string, window time.Duration) (*RateLimitCounter, error)
+
+	MockSaveValidationJob   func(ctx context.Context, job *ValidationJob) error
+	MockGetValidationJobs   func(ctx context.Context) ([]*ValidationJob, error)
+	MockLockValidationJob   func(ctx context.Context, challengeID, owner string, until time.Time) (*ValidationJob, error)
+	MockDeleteValidationJob func(ctx context.Context, challengeID string) error
 
 	MockRet1  interface{}
 	MockError error
@@ -148,6 +184,15 @@ type MockWireDB struct {
 	MockCreateOidcToken         func(ctx context.Context, orderID string, idToken map[string]interface{}) error
 }
 
+// MockEmailDB is an implementation of the EmailDB interface that should only be
+// used as a mock in tests. It embeds the MockDB, as it is an extension of the
+// existing database methods.
+type MockEmailDB struct {
+	MockDB
+	MockSaveEmailReply func(ctx context.Context, tokenPart1 string, reply *EmailReply) error
+	MockGetEmailReply  func(ctx context.Context, tokenPart1 string) (*EmailReply, error)
+}
+
 // CreateAccount mock.
 func (m *MockDB) CreateAccount(ctx context.Context, acc *Account) error {
 	if m.MockCreateAccount != nil {
@@ -188,10 +233,18 @@ func (m *MockDB) UpdateAccount(ctx context.Context, acc *Account) error {
 	return m.MockError
 }
 
+// UpdateAccountKey mock
+func (m *MockDB) UpdateAccountKey(ctx context.Context, acc *Account) error {
+	if m.MockUpdateAccountKey != nil {
+		return m.MockUpdateAccountKey(ctx, acc)
+	}
+	return m.MockError
+}
+
 // CreateExternalAccountKey mock
-func (m *MockDB) CreateExternalAccountKey(ctx context.Context, provisionerID, reference string) (*ExternalAccountKey, error) {
+func (m *MockDB) CreateExternalAccountKey(ctx context.Context, provisionerID, reference string, opts ExternalAccountKeyOptions) (*ExternalAccountKey, error) {
 	if m.MockCreateExternalAccountKey != nil {
-		return m.MockCreateExternalAccountKey(ctx, provisionerID, reference)
+		return m.MockCreateExternalAccountKey(ctx, provisionerID, reference, opts)
 	} else if m.MockError != nil {
 		return nil, m.MockError
 	}
@@ -348,6 +401,16 @@ func (m *MockDB) GetCertificateBySerial(ctx context.Context, serial string) (*Ce
 	return m.MockRet1.(*Certificate), m.MockError
 }
 
+// ReplaceCertificate mock
+func (m *MockDB) ReplaceCertificate(ctx context.Context, id, oldOrderID, orderID string) error {
+	if m.MockReplaceCertificate != nil {
+		return m.MockReplaceCertificate(ctx, id, oldOrderID, orderID)
+	} else if m.MockError != nil {
+		return m.MockError
+	}
+	return m.MockError
+}
+
 // CreateChallenge mock
 func (m *MockDB) CreateChallenge(ctx context.Context, ch *Challenge) error {
 	if m.MockCreateChallenge != nil {
@@ -408,6 +471,72 @@ func (m *MockDB) UpdateOrder(ctx context.Context, o *Order) error {
 	return m.MockError
 }
 
+// LockOrderRenewal mock
+func (m *MockDB) LockOrderRenewal(ctx context.Context, orderID, owner string, until time.Time) (*Order, error) {
+	if m.MockLockOrderRenewal != nil {
+		return m.MockLockOrderRenewal(ctx, orderID, owner, until)
+	} else if m.MockError != nil {
+		return nil, m.MockError
+	}
+	return m.MockRet1.(*Order), m.MockError
+}
+
+// GetRateLimitCounter mock
+func (m *MockDB) GetRateLimitCounter(ctx context.Context, key string, window time.Duration) (*RateLimitCounter, error) {
+	if m.MockGetRateLimitCounter != nil {
+		return m.MockGetRateLimitCounter(ctx, key, window)
+	} else if m.MockError != nil {
+		return nil, m.MockError
+	}
+	return m.MockRet1.(*RateLimitCounter), m.MockError
+}
+
+// IncrementRateLimitCounter mock
+func (m *MockDB) IncrementRateLimitCounter(ctx context.Context, key string, window time.Duration) (*RateLimitCounter, error) {
+	if m.MockIncrementRateLimitCounter != nil {
+		return m.MockIncrementRateLimitCounter(ctx, key, window)
+	} else if m.MockError != nil {
+		return nil, m.MockError
+	}
+	return m.MockRet1.(*RateLimitCounter), m.MockError
+}
+
+// SaveValidationJob mock
+func (m *MockDB) SaveValidationJob(ctx context.Context, job *ValidationJob) error {
+	if m.MockSaveValidationJob != nil {
+		return m.MockSaveValidationJob(ctx, job)
+	}
+	return m.MockError
+}
+
+// GetValidationJobs mock
+func (m *MockDB) GetValidationJobs(ctx context.Context) ([]*ValidationJob, error) {
+	if m.MockGetValidationJobs != nil {
+		return m.MockGetValidationJobs(ctx)
+	} else if m.MockError != nil {
+		return nil, m.MockError
+	}
+	return m.MockRet1.([]*ValidationJob), m.MockError
+}
+
+// LockValidationJob mock
+func (m *MockDB) LockValidationJob(ctx context.Context, challengeID, owner string, until time.Time) (*ValidationJob, error) {
+	if m.MockLockValidationJob != nil {
+		return m.MockLockValidationJob(ctx, challengeID, owner, until)
+	} else if m.MockError != nil {
+		return nil, m.MockError
+	}
+	return m.MockRet1.(*ValidationJob), m.MockError
+}
+
+// DeleteValidationJob mock
+func (m *MockDB) DeleteValidationJob(ctx context.Context, challengeID string) error {
+	if m.MockDeleteValidationJob != nil {
+		return m.MockDeleteValidationJob(ctx, challengeID)
+	}
+	return m.MockError
+}
+
 // GetOrdersByAccountID mock
 func (m *MockDB) GetOrdersByAccountID(ctx context.Context, accID string) ([]string, error) {
 	if m.MockGetOrdersByAccountID != nil {
@@ -463,3 +592,21 @@ func (m *MockWireDB) CreateOidcToken(ctx context.Context, orderID string, idToke
 	}
 	return m.MockError
 }
+
+// SaveEmailReply mock.
+func (m *MockEmailDB) SaveEmailReply(ctx context.Context, tokenPart1 string, reply *EmailReply) error {
+	if m.MockSaveEmailReply != nil {
+		return m.MockSaveEmailReply(ctx, tokenPart1, reply)
+	}
+	return m.MockError
+}
+
+// GetEmailReply mock.
+func (m *MockEmailDB) GetEmailReply(ctx context.Context, tokenPart1 string) (*EmailReply, error) {
+	if m.MockGetEmailReply != nil {
+		return m.MockGetEmailReply(ctx, tokenPart1)
+	} else if m.MockError != nil {
+		return nil, m.MockError
+	}
+	return m.MockRet1.(*EmailReply), m.MockError
+}
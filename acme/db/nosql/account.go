@@ -3,6 +3,7 @@ package nosql
 import (
 	"context"
 	"encoding/json"
+	"net/http"
 	"time"
 
 	"github.com/pkg/errors"
@@ -143,3 +144,80 @@ func (db *DB) UpdateAccount(ctx context.Context, acc *acme.Account) error {
 
 	return db.save(ctx, old.ID, nu, old, "account", accountTable)
 }
+
+// UpdateAccountKey implements the AcmeDB.UpdateAccountKey interface. It
+// replaces the key of the account with the one in the given account and
+// updates the key-id to account-id index. The new key cannot be bound to
+// another account.
+func (db *DB) UpdateAccountKey(ctx context.Context, acc *acme.Account) error {
+	old, err := db.getDBAccount(ctx, acc.ID)
+	if err != nil {
+		return err
+	}
+
+	oldKid, err := acme.KeyToID(old.Key)
+	if err != nil {
+		return err
+	}
+	newKid, err := acme.KeyToID(acc.Key)
+	if err != nil {
+		return err
+	}
+	if oldKid == newKid {
+		return acme.NewError(acme.ErrorMalformedType, "new key must be different from the current account key")
+	}
+
+	// Fail early if the new key is already bound to an account. The swap
+	// below guards against a concurrent binding of the same key.
+	newKidB := []byte(newKid)
+	if _, err := db.db.Get(accountByKeyIDTable, newKidB); err == nil {
+		return errKeyInUse()
+	} else if !nosqlDB.IsErrNotFound(err) {
+		return errors.Wrapf(err, "error loading keyID to accountID index for key %s", newKid)
+	}
+
+	nu := old.clone()
+	nu.Key = acc.Key
+	oldB, err := json.Marshal(old)
+	if err != nil {
+		return errors.Wrapf(err, "error marshaling acme type: account, value: %v", old)
+	}
+	newB, err := json.Marshal(nu)
+	if err != nil {
+		return errors.Wrapf(err, "error marshaling acme type: account, value: %v", nu)
+	}
+
+	// A failed swap does not abort a transaction, so the operations are done
+	// one by one. First bind the new key, then update the account, and only
+	// then remove the old key index. If the account cannot be updated the new
+	// key is released, so the account keeps the old key and its index.
+	_, swapped, err := db.db.CmpAndSwap(accountByKeyIDTable, newKidB, nil, []byte(acc.ID))
+	switch {
+	case err != nil:
+		return errors.Wrapf(err, "error saving keyID to accountID index for key %s", newKid)
+	case !swapped:
+		return errKeyInUse()
+	}
+
+	_, swapped, err = db.db.CmpAndSwap(accountTable, []byte(acc.ID), oldB, newB)
+	if err != nil || !swapped {
+		if delErr := db.db.Del(accountByKeyIDTable, newKidB); delErr != nil {
+			return errors.Wrapf(delErr, "error deleting keyID to accountID index for key %s", newKid)
+		}
+		if err != nil {
+			return errors.Wrap(err, "error saving acme account")
+		}
+		return errors.New("error saving acme account; changed since last read")
+	}
+
+	if err := db.db.Del(accountByKeyIDTable, []byte(oldKid)); err != nil {
+		return errors.Wrapf(err, "error deleting keyID to accountID index for key %s", oldKid)
+	}
+	return nil
+}
+
+func errKeyInUse() *acme.Error {
+	acmeErr := acme.NewError(acme.ErrorMalformedType, "key is already in use by another account")
+	acmeErr.Status = http.StatusConflict
+	return acmeErr
+}
@@ -715,3 +715,261 @@ func TestDB_UpdateAccount(t *testing.T) {
 		})
 	}
 }
+
+func TestDB_UpdateAccountKey(t *testing.T) {
+	accID := "accID"
+	oldKey, err := jose.GenerateJWK("EC", "P-256", "ES256", "sig", "", 0)
+	assert.FatalError(t, err)
+	newKey, err := jose.GenerateJWK("EC", "P-256", "ES256", "sig", "", 0)
+	assert.FatalError(t, err)
+	oldKid, err := acme.KeyToID(oldKey)
+	assert.FatalError(t, err)
+	newKid, err := acme.KeyToID(newKey)
+	assert.FatalError(t, err)
+
+	dbacc := &dbAccount{
+		ID:              accID,
+		Status:          acme.StatusValid,
+		CreatedAt:       clock.Now(),
+		ProvisionerName: "alpha",
+		Key:             oldKey,
+	}
+	b, err := json.Marshal(dbacc)
+	assert.FatalError(t, err)
+
+	notFound := func(bucket, key []byte) ([]byte, error) {
+		if string(bucket) == string(accountTable) {
+			return b, nil
+		}
+		assert.Equals(t, bucket, accountByKeyIDTable)
+		assert.Equals(t, string(key), newKid)
+		return nil, nosqldb.ErrNotFound
+	}
+
+	type test struct {
+		db     *db.MockNoSQLDB
+		key    *jose.JSONWebKey
+		err    error
+		status int
+	}
+	var tests = map[string]func(t *testing.T) test{
+		"ok": func(t *testing.T) test {
+			var ops []string
+			t.Cleanup(func() {
+				assert.Equals(t, ops, []string{"bind", "account", "unbind"})
+			})
+			return test{
+				key: newKey,
+				db: &db.MockNoSQLDB{
+					MGet: notFound,
+					MCmpAndSwap: func(bucket, key, old, nu []byte) ([]byte, bool, error) {
+						if string(bucket) == string(accountByKeyIDTable) {
+							assert.Equals(t, string(key), newKid)
+							assert.Equals(t, old, nil)
+							assert.Equals(t, string(nu), accID)
+							ops = append(ops, "bind")
+							return nu, true, nil
+						}
+						assert.Equals(t, bucket, accountTable)
+						assert.Equals(t, string(key), accID)
+						assert.Equals(t, old, b)
+						dbNew := new(dbAccount)
+						assert.FatalError(t, json.Unmarshal(nu, dbNew))
+						kid, err := acme.KeyToID(dbNew.Key)
+						assert.FatalError(t, err)
+						assert.Equals(t, kid, newKid)
+						assert.Equals(t, dbNew.Status, acme.StatusValid)
+						ops = append(ops, "account")
+						return nu, true, nil
+					},
+					MDel: func(bucket, key []byte) error {
+						assert.Equals(t, bucket, accountByKeyIDTable)
+						assert.Equals(t, string(key), oldKid)
+						ops = append(ops, "unbind")
+						return nil
+					},
+				},
+			}
+		},
+		"fail/same-key": func(t *testing.T) test {
+			return test{
+				key: oldKey,
+				db: &db.MockNoSQLDB{
+					MGet: func(bucket, key []byte) ([]byte, error) {
+						return b, nil
+					},
+				},
+				err:    errors.New("new key must be different from the current account key"),
+				status: 400,
+			}
+		},
+		"fail/key-in-use": func(t *testing.T) test {
+			return test{
+				key: newKey,
+				db: &db.MockNoSQLDB{
+					MGet: func(bucket, key []byte) ([]byte, error) {
+						if string(bucket) == string(accountTable) {
+							return b, nil
+						}
+						return []byte("otherID"), nil
+					},
+				},
+				err:    errors.New("key is already in use by another account"),
+				status: 409,
+			}
+		},
+		"fail/key-in-use-race": func(t *testing.T) test {
+			return test{
+				key: newKey,
+				db: &db.MockNoSQLDB{
+					MGet: notFound,
+					MCmpAndSwap: func(bucket, key, old, nu []byte) ([]byte, bool, error) {
+						assert.Equals(t, bucket, accountByKeyIDTable)
+						return []byte("otherID"), false, nil
+					},
+				},
+				err:    errors.New("key is already in use by another account"),
+				status: 409,
+			}
+		},
+		"fail/bind-error": func(t *testing.T) test {
+			return test{
+				key: newKey,
+				db: &db.MockNoSQLDB{
+					MGet: notFound,
+					MCmpAndSwap: func(bucket, key, old, nu []byte) ([]byte, bool, error) {
+						return nil, false, errors.New("force")
+					},
+				},
+				err: errors.New("error saving keyID to accountID index for key " + newKid + ": force"),
+			}
+		},
+		"fail/account-changed": func(t *testing.T) test {
+			var released bool
+			t.Cleanup(func() {
+				assert.True(t, released)
+			})
+			return test{
+				key: newKey,
+				db: &db.MockNoSQLDB{
+					MGet: notFound,
+					MCmpAndSwap: func(bucket, key, old, nu []byte) ([]byte, bool, error) {
+						if string(bucket) == string(accountByKeyIDTable) {
+							return nu, true, nil
+						}
+						return []byte("changed"), false, nil
+					},
+					MDel: func(bucket, key []byte) error {
+						assert.Equals(t, bucket, accountByKeyIDTable)
+						assert.Equals(t, string(key), newKid)
+						released = true
+						return nil
+					},
+				},
+				err: errors.New("error saving acme account; changed since last read"),
+			}
+		},
+		"fail/account-error": func(t *testing.T) test {
+			return test{
+				key: newKey,
+				db: &db.MockNoSQLDB{
+					MGet: notFound,
+					MCmpAndSwap: func(bucket, key, old, nu []byte) ([]byte, bool, error) {
+						if string(bucket) == string(accountByKeyIDTable) {
+							return nu, true, nil
+						}
+						return nil, false, errors.New("force")
+					},
+					MDel: func(bucket, key []byte) error {
+						assert.Equals(t, string(key), newKid)
+						return nil
+					},
+				},
+				err: errors.New("error saving acme account: force"),
+			}
+		},
+		"fail/unbind-error": func(t *testing.T) test {
+			return test{
+				key: newKey,
+				db: &db.MockNoSQLDB{
+					MGet: notFound,
+					MCmpAndSwap: func(bucket, key, old, nu []byte) ([]byte, bool, error) {
+						return nu, true, nil
+					},
+					MDel: func(bucket, key []byte) error {
+						return errors.New("force")
+					},
+				},
+				err: errors.New("error deleting keyID to accountID index for key " + oldKid + ": force"),
+			}
+		},
+	}
+	for name, run := range tests {
+		tc := run(t)
+		t.Run(name, func(t *testing.T) {
+			d := DB{db: tc.db}
+			err := d.UpdateAccountKey(context.Background(), &acme.Account{ID: accID, Key: tc.key})
+			if tc.err != nil {
+				if assert.NotNil(t, err) {
+					assert.HasPrefix(t, err.Error(), tc.err.Error())
+					var ae *acme.Error
+					if tc.status != 0 && assert.True(t, errors.As(err, &ae)) {
+						assert.Equals(t, ae.Status, tc.status)
+					}
+				}
+			} else {
+				assert.FatalError(t, err)
+			}
+		})
+	}
+}
+
+func TestDB_UpdateAccountKey_keyInUse(t *testing.T) {
+	ctx := context.Background()
+	ndb, err := nosql.New("badgerv2", t.TempDir())
+	assert.FatalError(t, err)
+	d, err := New(ndb)
+	assert.FatalError(t, err)
+
+	newKey := func() *jose.JSONWebKey {
+		jwk, err := jose.GenerateJWK("EC", "P-256", "ES256", "sig", "", 0)
+		assert.FatalError(t, err)
+		return jwk
+	}
+	acc := &acme.Account{Key: newKey(), Status: acme.StatusValid}
+	other := &acme.Account{Key: newKey(), Status: acme.StatusValid}
+	assert.FatalError(t, d.CreateAccount(ctx, acc))
+	assert.FatalError(t, d.CreateAccount(ctx, other))
+	oldKid, err := acme.KeyToID(acc.Key)
+	assert.FatalError(t, err)
+	otherKid, err := acme.KeyToID(other.Key)
+	assert.FatalError(t, err)
+
+	// The key of the other account cannot be used, and the account keeps its
+	// key and its index.
+	err = d.UpdateAccountKey(ctx, &acme.Account{ID: acc.ID, Key: other.Key})
+	var ae *acme.Error
+	if assert.True(t, errors.As(err, &ae)) {
+		assert.Equals(t, ae.Status, 409)
+	}
+	got, err := d.GetAccountByKeyID(ctx, oldKid)
+	assert.FatalError(t, err)
+	assert.Equals(t, got.ID, acc.ID)
+	kid, err := acme.KeyToID(got.Key)
+	assert.FatalError(t, err)
+	assert.Equals(t, kid, oldKid)
+	got, err = d.GetAccountByKeyID(ctx, otherKid)
+	assert.FatalError(t, err)
+	assert.Equals(t, got.ID, other.ID)
+
+	// A new key replaces the old one.
+	rotated := newKey()
+	rotatedKid, err := acme.KeyToID(rotated)
+	assert.FatalError(t, err)
+	assert.FatalError(t, d.UpdateAccountKey(ctx, &acme.Account{ID: acc.ID, Key: rotated}))
+	got, err = d.GetAccountByKeyID(ctx, rotatedKid)
+	assert.FatalError(t, err)
+	assert.Equals(t, got.ID, acc.ID)
+	_, err = d.GetAccountByKeyID(ctx, oldKid)
+	assert.True(t, acme.IsErrNotFound(err))
+}
@@ -3,26 +3,33 @@ package nosql
 import (
 	"context"
 	"encoding/json"
+	"sync"
 	"time"
 
 	"github.com/pkg/errors"
 	"github.com/smallstep/certificates/acme"
+	"github.com/smallstep/certificates/authority/provisioner"
 	"github.com/smallstep/nosql"
 )
 
+// Mutex for locking authzsByAccount index operations.
+var authzsByAccountMux sync.Mutex
+
 // dbAuthz is the base authz type that others build from.
 type dbAuthz struct {
-	ID           string          `json:"id"`
-	AccountID    string          `json:"accountID"`
-	Identifier   acme.Identifier `json:"identifier"`
-	Status       acme.Status     `json:"status"`
-	Token        string          `json:"token"`
-	Fingerprint  string          `json:"fingerprint,omitempty"`
-	ChallengeIDs []string        `json:"challengeIDs"`
-	Wildcard     bool            `json:"wildcard"`
-	CreatedAt    time.Time       `json:"createdAt"`
-	ExpiresAt    time.Time       `json:"expiresAt"`
-	Error        *acme.Error     `json:"error"`
+	ID              string                       `json:"id"`
+	AccountID       string                       `json:"accountID"`
+	Identifier      acme.Identifier              `json:"identifier"`
+	Status          acme.Status                  `json:"status"`
+	Token           string                       `json:"token"`
+	Fingerprint     string                       `json:"fingerprint,omitempty"`
+	TPMEKValidation *provisioner.TPMEKValidation `json:"tpmEKValidation,omitempty"`
+	AndroidDevice   *provisioner.AndroidDevice   `json:"androidDevice,omitempty"`
+	ChallengeIDs    []string                     `json:"challengeIDs"`
+	Wildcard        bool                         `json:"wildcard"`
+	CreatedAt       time.Time                    `json:"createdAt"`
+	ExpiresAt       time.Time                    `json:"expiresAt"`
+	Error           *acme.Error                  `json:"error"`
 }
 
 func (ba *dbAuthz) clone() *dbAuthz {
@@ -62,16 +69,18 @@ func (db *DB) GetAuthorization(ctx context.Context, id string) (*acme.Authorizat
 		}
 	}
 	return &acme.Authorization{
-		ID:          dbaz.ID,
-		AccountID:   dbaz.AccountID,
-		Identifier:  dbaz.Identifier,
-		Status:      dbaz.Status,
-		Challenges:  chs,
-		Wildcard:    dbaz.Wildcard,
-		ExpiresAt:   dbaz.ExpiresAt,
-		Token:       dbaz.Token,
-		Fingerprint: dbaz.Fingerprint,
-		Error:       dbaz.Error,
+		ID:              dbaz.ID,
+		AccountID:       dbaz.AccountID,
+		Identifier:      dbaz.Identifier,
+		Status:          dbaz.Status,
+		Challenges:      chs,
+		Wildcard:        dbaz.Wildcard,
+		ExpiresAt:       dbaz.ExpiresAt,
+		Token:           dbaz.Token,
+		Fingerprint:     dbaz.Fingerprint,
+		TPMEKValidation: dbaz.TPMEKValidation,
+		AndroidDevice:   dbaz.AndroidDevice,
+		Error:           dbaz.Error,
 	}, nil
 }
 
@@ -91,19 +100,27 @@ func (db *DB) CreateAuthorization(ctx context.Context, az *acme.Authorization) e
 
 	now := clock.Now()
 	dbaz := &dbAuthz{
-		ID:           az.ID,
-		AccountID:    az.AccountID,
-		Status:       az.Status,
-		CreatedAt:    now,
-		ExpiresAt:    az.ExpiresAt,
-		Identifier:   az.Identifier,
-		ChallengeIDs: chIDs,
-		Token:        az.Token,
-		Fingerprint:  az.Fingerprint,
-		Wildcard:     az.Wildcard,
-	}
-
-	return db.save(ctx, az.ID, dbaz, nil, "authz", authzTable)
+		ID:              az.ID,
+		AccountID:       az.AccountID,
+		Status:          az.Status,
+		CreatedAt:       now,
+		ExpiresAt:       az.ExpiresAt,
+		Identifier:      az.Identifier,
+		ChallengeIDs:    chIDs,
+		Token:           az.Token,
+		Fingerprint:     az.Fingerprint,
+		TPMEKValidation: az.TPMEKValidation,
+		AndroidDevice:   az.AndroidDevice,
+		Wildcard:        az.Wildcard,
+	}
+
+	if err := db.save(ctx, az.ID, dbaz, nil, "authz", authzTable); err != nil {
+		return err
+	}
+	if _, err := db.updateAddAuthzIDs(ctx, az.AccountID, az.ID); err != nil {
+		return err
+	}
+	return nil
 }
 
 // UpdateAuthorization saves an updated ACME Authorization to the database.
@@ -116,41 +133,103 @@ func (db *DB) UpdateAuthorization(ctx context.Context, az *acme.Authorization) e
 	nu := old.clone()
 	nu.Status = az.Status
 	nu.Fingerprint = az.Fingerprint
+	nu.TPMEKValidation = az.TPMEKValidation
+	nu.AndroidDevice = az.AndroidDevice
 	nu.Error = az.Error
 	return db.save(ctx, old.ID, nu, old, "authz", authzTable)
 }
 
-// GetAuthorizationsByAccountID retrieves and unmarshals ACME authz types from the database.
-func (db *DB) GetAuthorizationsByAccountID(_ context.Context, accountID string) ([]*acme.Authorization, error) {
-	entries, err := db.db.List(authzTable)
+// GetAuthorizationsByAccountID retrieves and unmarshals the ACME authz types
+// of an account that have not expired yet.
+func (db *DB) GetAuthorizationsByAccountID(ctx context.Context, accountID string) ([]*acme.Authorization, error) {
+	dbazs, err := db.updateAddAuthzIDs(ctx, accountID)
 	if err != nil {
-		return nil, errors.Wrapf(err, "error listing authz")
+		return nil, err
+	}
+	authzs := make([]*acme.Authorization, 0, len(dbazs))
+	for _, dbaz := range dbazs {
+		authzs = append(authzs, &acme.Authorization{
+			ID:              dbaz.ID,
+			AccountID:       dbaz.AccountID,
+			Identifier:      dbaz.Identifier,
+			Status:          dbaz.Status,
+			Challenges:      nil, // challenges not required for current use case
+			Wildcard:        dbaz.Wildcard,
+			ExpiresAt:       dbaz.ExpiresAt,
+			Token:           dbaz.Token,
+			Fingerprint:     dbaz.Fingerprint,
+			TPMEKValidation: dbaz.TPMEKValidation,
+			AndroidDevice:   dbaz.AndroidDevice,
+			Error:           dbaz.Error,
+		})
 	}
-	authzs := []*acme.Authorization{}
-	for _, entry := range entries {
+	return authzs, nil
+}
+
+// updateAddAuthzIDs adds the given authz IDs to the index of authorizations of
+// the account, and returns the authorizations that were already in the index.
+// Expired and deleted authorizations are removed from the index.
+func (db *DB) updateAddAuthzIDs(ctx context.Context, accID string, addIDs ...string) ([]*dbAuthz, error) {
+	authzsByAccountMux.Lock()
+	defer authzsByAccountMux.Unlock()
+
+	var oldIDs []string
+	b, err := db.db.Get(authzsByAccountIDTable, []byte(accID))
+	if err != nil {
+		if !nosql.IsErrNotFound(err) {
+			return nil, errors.Wrapf(err, "error loading authzIDs for account %s", accID)
+		}
+	} else {
+		if err := json.Unmarshal(b, &oldIDs); err != nil {
+			return nil, errors.Wrapf(err, "error unmarshaling authzIDs for account %s", accID)
+		}
+	}
+
+	now := clock.Now()
+	dbazs := []*dbAuthz{}
+	ids := []string{}
+	for _, id := range oldIDs {
+		data, err := db.db.Get(authzTable, []byte(id))
+		if nosql.IsErrNotFound(err) {
+			continue
+		} else if err != nil {
+			return nil, errors.Wrapf(err, "error loading authz %s", id)
+		}
 		dbaz := new(dbAuthz)
-		if err = json.Unmarshal(entry.Value, dbaz); err != nil {
-			return nil, errors.Wrapf(err, "error unmarshaling dbAuthz key '%s' into dbAuthz struct", string(entry.Key))
+		if err := json.Unmarshal(data, dbaz); err != nil {
+			return nil, errors.Wrapf(err, "error unmarshaling authz %s into dbAuthz", id)
 		}
-		// Filter out all dbAuthzs that don't belong to the accountID. This
-		// could be made more efficient with additional data structures mapping the
-		// Account ID to authorizations. Not trivial to do, though.
-		if dbaz.AccountID != accountID {
+		if !now.Before(dbaz.ExpiresAt) {
 			continue
 		}
-		authzs = append(authzs, &acme.Authorization{
-			ID:          dbaz.ID,
-			AccountID:   dbaz.AccountID,
-			Identifier:  dbaz.Identifier,
-			Status:      dbaz.Status,
-			Challenges:  nil, // challenges not required for current use case
-			Wildcard:    dbaz.Wildcard,
-			ExpiresAt:   dbaz.ExpiresAt,
-			Token:       dbaz.Token,
-			Fingerprint: dbaz.Fingerprint,
-			Error:       dbaz.Error,
-		})
+		dbazs = append(dbazs, dbaz)
+		ids = append(ids, id)
 	}
+	ids = append(ids, addIDs...)
 
-	return authzs, nil
+	// If the list has not changed, then no need to write the DB.
+	if len(addIDs) == 0 && len(dbazs) == len(oldIDs) {
+		return dbazs, nil
+	}
+
+	nu, err := json.Marshal(ids)
+	if err != nil {
+		return nil, errors.Wrapf(err, "error marshaling authzIDs for account %s", accID)
+	}
+	_, swapped, err := db.db.CmpAndSwap(authzsByAccountIDTable, []byte(accID), b, nu)
+	switch {
+	case err != nil:
+		err = errors.Wrapf(err, "error saving authzIDs index for account %s", accID)
+	case !swapped:
+		err = errors.Errorf("error saving authzIDs index for account %s; changed since last read", accID)
+	}
+	if err != nil {
+		// Delete the authorizations that were just created if the index
+		// update fails. Ignore errors from delete -- we tried our best.
+		for _, id := range addIDs {
+			db.db.Del(authzTable, []byte(id))
+		}
+		return nil, err
+	}
+	return dbazs, nil
 }
@@ -11,6 +11,7 @@ import (
 	"github.com/pkg/errors"
 	"github.com/smallstep/assert"
 	"github.com/smallstep/certificates/acme"
+	"github.com/smallstep/certificates/authority/provisioner"
 	"github.com/smallstep/certificates/db"
 	"github.com/smallstep/nosql"
 	nosqldb "github.com/smallstep/nosql/database"
@@ -410,7 +411,18 @@ func TestDB_CreateAuthorization(t *testing.T) {
 			)
 			return test{
 				db: &db.MockNoSQLDB{
+					MGet: func(bucket, key []byte) ([]byte, error) {
+						assert.Equals(t, bucket, authzsByAccountIDTable)
+						assert.Equals(t, string(key), az.AccountID)
+						return nil, nosqldb.ErrNotFound
+					},
 					MCmpAndSwap: func(bucket, key, old, nu []byte) ([]byte, bool, error) {
+						if string(bucket) == string(authzsByAccountIDTable) {
+							assert.Equals(t, string(key), az.AccountID)
+							assert.Equals(t, old, nil)
+							assert.Equals(t, string(nu), `["`+*idPtr+`"]`)
+							return nu, true, nil
+						}
 						*idPtr = string(key)
 						assert.Equals(t, bucket, authzTable)
 						assert.Equals(t, string(key), az.ID)
@@ -439,6 +451,89 @@ func TestDB_CreateAuthorization(t *testing.T) {
 				_id: idPtr,
 			}
 		},
+		"ok/add-to-index": func(t *testing.T) test {
+			var id string
+			now := clock.Now()
+			az := &acme.Authorization{
+				ID:         azID,
+				AccountID:  "accountID",
+				Identifier: acme.Identifier{Type: "dns", Value: "test.ca.smallstep.com"},
+				Status:     acme.StatusPending,
+				ExpiresAt:  now.Add(5 * time.Minute),
+			}
+			expired, err := json.Marshal(&dbAuthz{ID: "expired", AccountID: "accountID", ExpiresAt: now.Add(-time.Minute)})
+			assert.FatalError(t, err)
+			current, err := json.Marshal(&dbAuthz{ID: "current", AccountID: "accountID", ExpiresAt: now.Add(time.Hour)})
+			assert.FatalError(t, err)
+			return test{
+				db: &db.MockNoSQLDB{
+					MGet: func(bucket, key []byte) ([]byte, error) {
+						switch string(bucket) {
+						case string(authzsByAccountIDTable):
+							assert.Equals(t, string(key), "accountID")
+							return []byte(`["expired","deleted","current"]`), nil
+						case string(authzTable):
+							switch string(key) {
+							case "expired":
+								return expired, nil
+							case "current":
+								return current, nil
+							default:
+								return nil, nosqldb.ErrNotFound
+							}
+						default:
+							t.Errorf("unexpected bucket %s", bucket)
+							return nil, errors.New("force")
+						}
+					},
+					MCmpAndSwap: func(bucket, key, old, nu []byte) ([]byte, bool, error) {
+						switch string(bucket) {
+						case string(authzTable):
+							assert.Equals(t, old, nil)
+							id = string(key)
+						case string(authzsByAccountIDTable):
+							assert.Equals(t, string(key), "accountID")
+							assert.Equals(t, string(old), `["expired","deleted","current"]`)
+							assert.Equals(t, string(nu), `["current","`+id+`"]`)
+						default:
+							t.Errorf("unexpected bucket %s", bucket)
+						}
+						return nu, true, nil
+					},
+				},
+				az:  az,
+				_id: &id,
+			}
+		},
+		"fail/index-error": func(t *testing.T) test {
+			az := &acme.Authorization{
+				ID:         azID,
+				AccountID:  "accountID",
+				Identifier: acme.Identifier{Type: "dns", Value: "test.ca.smallstep.com"},
+				Status:     acme.StatusPending,
+				ExpiresAt:  clock.Now().Add(5 * time.Minute),
+			}
+			return test{
+				db: &db.MockNoSQLDB{
+					MGet: func(bucket, key []byte) ([]byte, error) {
+						assert.Equals(t, bucket, authzsByAccountIDTable)
+						return nil, nosqldb.ErrNotFound
+					},
+					MCmpAndSwap: func(bucket, key, old, nu []byte) ([]byte, bool, error) {
+						if string(bucket) == string(authzsByAccountIDTable) {
+							return nil, false, errors.New("force")
+						}
+						return nu, true, nil
+					},
+					MDel: func(bucket, key []byte) error {
+						assert.Equals(t, bucket, authzTable)
+						return nil
+					},
+				},
+				az:  az,
+				err: errors.New("error saving authzIDs index for account accountID: force"),
+			}
+		},
 	}
 	for name, run := range tests {
 		tc := run(t)
@@ -591,6 +686,83 @@ func TestDB_UpdateAuthorization(t *testing.T) {
 				},
 			}
 		},
+		"ok/tpm-ek-validation": func(t *testing.T) test {
+			ekValidation := &provisioner.TPMEKValidation{
+				EKKeyID:                  "urn:ek:sha256:Zm9vYmFy",
+				Chain:                    []string{"", "CN=Intermediate", "CN=Root"},
+				RevocationChecked:        true,
+				PermanentIdentifierBound: true,
+			}
+			updAz := &acme.Authorization{
+				ID:         azID,
+				AccountID:  dbaz.AccountID,
+				Status:     acme.StatusValid,
+				Identifier: dbaz.Identifier,
+				Challenges: []*acme.Challenge{
+					{ID: "foo"},
+					{ID: "bar"},
+				},
+				Token:           dbaz.Token,
+				Wildcard:        dbaz.Wildcard,
+				ExpiresAt:       dbaz.ExpiresAt,
+				Fingerprint:     "fingerprint",
+				TPMEKValidation: ekValidation,
+				Error:           acme.NewError(acme.ErrorMalformedType, "malformed"),
+			}
+			return test{
+				az: updAz,
+				db: &db.MockNoSQLDB{
+					MGet: func(bucket, key []byte) ([]byte, error) {
+						return b, nil
+					},
+					MCmpAndSwap: func(bucket, key, old, nu []byte) ([]byte, bool, error) {
+						dbNew := new(dbAuthz)
+						assert.FatalError(t, json.Unmarshal(nu, dbNew))
+						assert.Equals(t, dbNew.Fingerprint, dbaz.Fingerprint)
+						assert.Equals(t, dbNew.TPMEKValidation, ekValidation)
+						return nu, true, nil
+					},
+				},
+			}
+		},
+		"ok/android-device": func(t *testing.T) test {
+			device := &provisioner.AndroidDevice{
+				Brand:             "google",
+				Manufacturer:      "Google",
+				Model:             "Pixel 8",
+				DeviceIdentifiers: []string{"serial-number", "490154203237518"},
+			}
+			updAz := &acme.Authorization{
+				ID:         azID,
+				AccountID:  dbaz.AccountID,
+				Status:     acme.StatusValid,
+				Identifier: dbaz.Identifier,
+				Challenges: []*acme.Challenge{
+					{ID: "foo"},
+					{ID: "bar"},
+				},
+				Token:         dbaz.Token,
+				Wildcard:      dbaz.Wildcard,
+				ExpiresAt:     dbaz.ExpiresAt,
+				Fingerprint:   "fingerprint",
+				AndroidDevice: device,
+				Error:         acme.NewError(acme.ErrorMalformedType, "malformed"),
+			}
+			return test{
+				az: updAz,
+				db: &db.MockNoSQLDB{
+					MGet: func(bucket, key []byte) ([]byte, error) {
+						return b, nil
+					},
+					MCmpAndSwap: func(bucket, key, old, nu []byte) ([]byte, bool, error) {
+						dbNew := new(dbAuthz)
+						assert.FatalError(t, json.Unmarshal(nu, dbNew))
+						assert.Equals(t, dbNew.AndroidDevice, device)
+						return nu, true, nil
+					},
+				},
+			}
+		},
 	}
 	for name, run := range tests {
 		tc := run(t)
@@ -630,34 +802,56 @@ func TestDB_GetAuthorizationsByAccountID(t *testing.T) {
 		authzs  []*acme.Authorization
 	}
 	var tests = map[string]func(t *testing.T) test{
-		"fail/db.List-error": func(t *testing.T) test {
+		"fail/db.Get-index-error": func(t *testing.T) test {
+			return test{
+				db: &db.MockNoSQLDB{
+					MGet: func(bucket, key []byte) ([]byte, error) {
+						assert.Equals(t, bucket, authzsByAccountIDTable)
+						assert.Equals(t, string(key), accountID)
+						return nil, errors.New("force")
+					},
+				},
+				err: errors.New("error loading authzIDs for account accountID: force"),
+			}
+		},
+		"fail/db.Get-authz-error": func(t *testing.T) test {
 			return test{
 				db: &db.MockNoSQLDB{
-					MList: func(bucket []byte) ([]*nosqldb.Entry, error) {
+					MGet: func(bucket, key []byte) ([]byte, error) {
+						if string(bucket) == string(authzsByAccountIDTable) {
+							return []byte(`["azID"]`), nil
+						}
 						assert.Equals(t, bucket, authzTable)
 						return nil, errors.New("force")
 					},
 				},
-				err: errors.New("error listing authz: force"),
+				err: errors.New("error loading authz azID: force"),
 			}
 		},
 		"fail/unmarshal": func(t *testing.T) test {
-			b := []byte(`{malformed}`)
 			return test{
 				db: &db.MockNoSQLDB{
-					MList: func(bucket []byte) ([]*nosqldb.Entry, error) {
+					MGet: func(bucket, key []byte) ([]byte, error) {
+						if string(bucket) == string(authzsByAccountIDTable) {
+							return []byte(`["azID"]`), nil
+						}
 						assert.Equals(t, bucket, authzTable)
-						return []*nosqldb.Entry{
-							{
-								Bucket: bucket,
-								Key:    []byte(azID),
-								Value:  b,
-							},
-						}, nil
+						return []byte(`{malformed}`), nil
 					},
 				},
 				authzs: nil,
-				err:    fmt.Errorf("error unmarshaling dbAuthz key '%s' into dbAuthz struct", azID),
+				err:    fmt.Errorf("error unmarshaling authz %s into dbAuthz", azID),
+			}
+		},
+		"ok/empty": func(t *testing.T) test {
+			return test{
+				db: &db.MockNoSQLDB{
+					MGet: func(bucket, key []byte) ([]byte, error) {
+						assert.Equals(t, bucket, authzsByAccountIDTable)
+						return nil, nosqldb.ErrNotFound
+					},
+				},
+				authzs: []*acme.Authorization{},
 			}
 		},
 		"ok": func(t *testing.T) test {
@@ -681,15 +875,14 @@ func TestDB_GetAuthorizationsByAccountID(t *testing.T) {
 
 			return test{
 				db: &db.MockNoSQLDB{
-					MList: func(bucket []byte) ([]*nosqldb.Entry, error) {
+					MGet: func(bucket, key []byte) ([]byte, error) {
+						if string(bucket) == string(authzsByAccountIDTable) {
+							assert.Equals(t, string(key), accountID)
+							return []byte(`["azID"]`), nil
+						}
 						assert.Equals(t, bucket, authzTable)
-						return []*nosqldb.Entry{
-							{
-								Bucket: bucket,
-								Key:    []byte(azID),
-								Value:  b,
-							},
-						}, nil
+						assert.Equals(t, string(key), azID)
+						return b, nil
 					},
 				},
 				authzs: []*acme.Authorization{
@@ -707,36 +900,33 @@ func TestDB_GetAuthorizationsByAccountID(t *testing.T) {
 				},
 			}
 		},
-		"ok/skip-different-account": func(t *testing.T) test {
+		"ok/prune-expired": func(t *testing.T) test {
 			now := clock.Now()
 			dbaz := &dbAuthz{
-				ID:        azID,
-				AccountID: "differentAccountID",
-				Identifier: acme.Identifier{
-					Type:  "dns",
-					Value: "test.ca.smallstep.com",
-				},
-				Status:       acme.StatusValid,
-				Token:        "token",
-				CreatedAt:    now,
-				ExpiresAt:    now.Add(5 * time.Minute),
-				ChallengeIDs: []string{"foo", "bar"},
-				Wildcard:     true,
+				ID:         azID,
+				AccountID:  accountID,
+				Identifier: acme.Identifier{Type: "dns", Value: "test.ca.smallstep.com"},
+				Status:     acme.StatusValid,
+				CreatedAt:  now.Add(-time.Hour),
+				ExpiresAt:  now.Add(-time.Minute),
 			}
 			b, err := json.Marshal(dbaz)
 			assert.FatalError(t, err)
 
 			return test{
 				db: &db.MockNoSQLDB{
-					MList: func(bucket []byte) ([]*nosqldb.Entry, error) {
-						assert.Equals(t, bucket, authzTable)
-						return []*nosqldb.Entry{
-							{
-								Bucket: bucket,
-								Key:    []byte(azID),
-								Value:  b,
-							},
-						}, nil
+					MGet: func(bucket, key []byte) ([]byte, error) {
+						if string(bucket) == string(authzsByAccountIDTable) {
+							return []byte(`["azID"]`), nil
+						}
+						return b, nil
+					},
+					MCmpAndSwap: func(bucket, key, old, nu []byte) ([]byte, bool, error) {
+						assert.Equals(t, bucket, authzsByAccountIDTable)
+						assert.Equals(t, string(key), accountID)
+						assert.Equals(t, string(old), `["azID"]`)
+						assert.Equals(t, string(nu), `[]`)
+						return nu, true, nil
 					},
 				},
 				authzs: []*acme.Authorization{},
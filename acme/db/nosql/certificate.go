@@ -19,6 +19,8 @@ type dbCert struct {
 	OrderID       string    `json:"orderID"`
 	Leaf          []byte    `json:"leaf"`
 	Intermediates []byte    `json:"intermediates"`
+	ReplacedBy    string    `json:"replacedBy,omitempty"`
+	AutoRenewal   bool      `json:"autoRenewal,omitempty"`
 }
 
 type dbSerial struct {
@@ -52,6 +54,7 @@ func (db *DB) CreateCertificate(ctx context.Context, cert *acme.Certificate) err
 		OrderID:       cert.OrderID,
 		Leaf:          leaf,
 		Intermediates: intermediates,
+		AutoRenewal:   cert.AutoRenewal,
 		CreatedAt:     time.Now().UTC(),
 	}
 	err = db.save(ctx, cert.ID, dbch, nil, "certificate", certTable)
@@ -67,9 +70,9 @@ func (db *DB) CreateCertificate(ctx context.Context, cert *acme.Certificate) err
 	return db.save(ctx, serial, dbSerial, nil, "serial", certBySerialTable)
 }
 
-// GetCertificate retrieves and unmarshals an ACME certificate type from the
+// getDBCertificate retrieves and unmarshals an ACME certificate type from the
 // datastore.
-func (db *DB) GetCertificate(_ context.Context, id string) (*acme.Certificate, error) {
+func (db *DB) getDBCertificate(_ context.Context, id string) (*dbCert, error) {
 	b, err := db.db.Get(certTable, []byte(id))
 	if nosql.IsErrNotFound(err) {
 		return nil, acme.NewError(acme.ErrorMalformedType, "certificate %s not found", id)
@@ -80,6 +83,16 @@ func (db *DB) GetCertificate(_ context.Context, id string) (*acme.Certificate, e
 	if err := json.Unmarshal(b, dbC); err != nil {
 		return nil, errors.Wrapf(err, "error unmarshaling certificate %s", id)
 	}
+	return dbC, nil
+}
+
+// GetCertificate retrieves and unmarshals an ACME certificate type from the
+// datastore.
+func (db *DB) GetCertificate(ctx context.Context, id string) (*acme.Certificate, error) {
+	dbC, err := db.getDBCertificate(ctx, id)
+	if err != nil {
+		return nil, err
+	}
 
 	certs, err := parseBundle(append(dbC.Leaf, dbC.Intermediates...))
 	if err != nil {
@@ -92,9 +105,48 @@ func (db *DB) GetCertificate(_ context.Context, id string) (*acme.Certificate, e
 		OrderID:       dbC.OrderID,
 		Leaf:          certs[0],
 		Intermediates: certs[1:],
+		ReplacedBy:    dbC.ReplacedBy,
+		AutoRenewal:   dbC.AutoRenewal,
 	}, nil
 }
 
+// ReplaceCertificate sets the order that replaces the certificate with the
+// given id, RFC 9773. An empty orderID releases the certificate. The update is
+// done using compare-and-swap, so only one order can replace a certificate, and
+// it returns acme.ErrCertificateReplaced if the certificate is not replaced by
+// oldOrderID.
+func (db *DB) ReplaceCertificate(_ context.Context, id, oldOrderID, orderID string) error {
+	old, err := db.db.Get(certTable, []byte(id))
+	switch {
+	case nosql.IsErrNotFound(err):
+		return acme.NewError(acme.ErrorMalformedType, "certificate %s not found", id)
+	case err != nil:
+		return errors.Wrapf(err, "error loading certificate %s", id)
+	}
+
+	dbC := new(dbCert)
+	if err := json.Unmarshal(old, dbC); err != nil {
+		return errors.Wrapf(err, "error unmarshaling certificate %s", id)
+	}
+	if dbC.ReplacedBy != oldOrderID {
+		return acme.ErrCertificateReplaced
+	}
+
+	dbC.ReplacedBy = orderID
+	nu, err := json.Marshal(dbC)
+	if err != nil {
+		return errors.Wrapf(err, "error marshaling certificate %s", id)
+	}
+	_, swapped, err := db.db.CmpAndSwap(certTable, []byte(id), old, nu)
+	switch {
+	case err != nil:
+		return errors.Wrapf(err, "error saving acme certificate %s", id)
+	case !swapped:
+		return acme.ErrCertificateReplaced
+	}
+	return nil
+}
+
 // GetCertificateBySerial retrieves and unmarshals an ACME certificate type from the
 // datastore based on a certificate serial number.
 func (db *DB) GetCertificateBySerial(ctx context.Context, serial string) (*acme.Certificate, error) {
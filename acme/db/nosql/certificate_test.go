@@ -275,6 +275,124 @@ func TestDB_GetCertificate(t *testing.T) {
 	}
 }
 
+func TestDB_ReplaceCertificate(t *testing.T) {
+	leaf, err := pemutil.ReadCertificate("../../../authority/testdata/certs/foo.crt")
+	assert.FatalError(t, err)
+
+	certID := "certID"
+	dbc := &dbCert{
+		ID:        certID,
+		AccountID: "accountID",
+		OrderID:   "orderID",
+		Leaf: pem.EncodeToMemory(&pem.Block{
+			Type:  "CERTIFICATE",
+			Bytes: leaf.Raw,
+		}),
+		ReplacedBy: "oldOrderID",
+		CreatedAt:  clock.Now(),
+	}
+	b, err := json.Marshal(dbc)
+	assert.FatalError(t, err)
+
+	type test struct {
+		db         nosql.DB
+		oldOrderID string
+		err        error
+	}
+	var tests = map[string]func(t *testing.T) test{
+		"fail/db.Get-error": func(t *testing.T) test {
+			return test{
+				db: &db.MockNoSQLDB{
+					MGet: func(bucket, key []byte) ([]byte, error) {
+						assert.Equals(t, bucket, certTable)
+						assert.Equals(t, string(key), certID)
+						return nil, errors.New("force")
+					},
+				},
+				oldOrderID: "oldOrderID",
+				err:        errors.New("error loading certificate certID: force"),
+			}
+		},
+		"fail/replaced": func(t *testing.T) test {
+			return test{
+				db: &db.MockNoSQLDB{
+					MGet: func(bucket, key []byte) ([]byte, error) {
+						return b, nil
+					},
+				},
+				oldOrderID: "",
+				err:        acme.ErrCertificateReplaced,
+			}
+		},
+		"fail/db.CmpAndSwap-error": func(t *testing.T) test {
+			return test{
+				db: &db.MockNoSQLDB{
+					MGet: func(bucket, key []byte) ([]byte, error) {
+						return b, nil
+					},
+					MCmpAndSwap: func(bucket, key, old, nu []byte) ([]byte, bool, error) {
+						return nil, false, errors.New("force")
+					},
+				},
+				oldOrderID: "oldOrderID",
+				err:        errors.New("error saving acme certificate certID: force"),
+			}
+		},
+		"fail/db.CmpAndSwap-not-swapped": func(t *testing.T) test {
+			return test{
+				db: &db.MockNoSQLDB{
+					MGet: func(bucket, key []byte) ([]byte, error) {
+						return b, nil
+					},
+					MCmpAndSwap: func(bucket, key, old, nu []byte) ([]byte, bool, error) {
+						return old, false, nil
+					},
+				},
+				oldOrderID: "oldOrderID",
+				err:        acme.ErrCertificateReplaced,
+			}
+		},
+		"ok": func(t *testing.T) test {
+			return test{
+				db: &db.MockNoSQLDB{
+					MGet: func(bucket, key []byte) ([]byte, error) {
+						return b, nil
+					},
+					MCmpAndSwap: func(bucket, key, old, nu []byte) ([]byte, bool, error) {
+						assert.Equals(t, bucket, certTable)
+						assert.Equals(t, string(key), certID)
+						assert.Equals(t, old, b)
+
+						dbNew := new(dbCert)
+						assert.FatalError(t, json.Unmarshal(nu, dbNew))
+						assert.Equals(t, dbNew.ID, certID)
+						assert.Equals(t, dbNew.AccountID, "accountID")
+						assert.Equals(t, dbNew.OrderID, "orderID")
+						assert.Equals(t, dbNew.Leaf, dbc.Leaf)
+						assert.Equals(t, dbNew.ReplacedBy, "newOrderID")
+						return nu, true, nil
+					},
+				},
+				oldOrderID: "oldOrderID",
+			}
+		},
+	}
+	for name, run := range tests {
+		tc := run(t)
+		t.Run(name, func(t *testing.T) {
+			d := DB{db: tc.db}
+			err := d.ReplaceCertificate(context.Background(), certID, tc.oldOrderID, "newOrderID")
+			if tc.err != nil {
+				if assert.NotNil(t, err) {
+					assert.HasPrefix(t, err.Error(), tc.err.Error())
+				}
+			} else {
+				assert.Nil(t, err)
+			}
+		})
+	}
+}
+
 func Test_parseBundle(t *testing.T) {
 	leaf, err := pemutil.ReadCertificate("../../../authority/testdata/certs/foo.crt")
 	assert.FatalError(t, err)
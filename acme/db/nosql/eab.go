@@ -4,6 +4,7 @@ import (
 	"context"
 	"crypto/rand"
 	"encoding/json"
+	"slices"
 	"sync"
 	"time"
 
@@ -27,6 +28,19 @@ type dbExternalAccountKey struct {
 	HmacKey       []byte    `json:"key"`
 	CreatedAt     time.Time `json:"createdAt"`
 	BoundAt       time.Time `json:"boundAt"`
+	NotAfter      time.Time `json:"notAfter,omitempty"`
+	MultiUse      bool      `json:"multiUse,omitempty"`
+	Disabled      bool      `json:"disabled,omitempty"`
+	AccountIDs    []string  `json:"accountIDs,omitempty"`
+}
+
+// accountIDs returns the IDs of all the accounts bound to the key. Keys bound
+// before all the account IDs were stored only have the last one.
+func (dbeak *dbExternalAccountKey) accountIDs() []string {
+	if dbeak.AccountID == "" || slices.Contains(dbeak.AccountIDs, dbeak.AccountID) {
+		return dbeak.AccountIDs
+	}
+	return append(slices.Clip(dbeak.AccountIDs), dbeak.AccountID)
 }
 
 type dbExternalAccountKeyReference struct {
@@ -34,6 +48,11 @@ type dbExternalAccountKeyReference struct {
 	ExternalAccountKeyID string `json:"externalAccountKeyID"`
 }
 
+type dbExternalAccountKeyAccount struct {
+	AccountID            string `json:"accountID"`
+	ExternalAccountKeyID string `json:"externalAccountKeyID"`
+}
+
 // getDBExternalAccountKey retrieves and unmarshals dbExternalAccountKey.
 func (db *DB) getDBExternalAccountKey(_ context.Context, id string) (*dbExternalAccountKey, error) {
 	data, err := db.db.Get(externalAccountKeyTable, []byte(id))
@@ -52,8 +71,9 @@ func (db *DB) getDBExternalAccountKey(_ context.Context, id string) (*dbExternal
 	return dbeak, nil
 }
 
-// CreateExternalAccountKey creates a new External Account Binding key with a name
-func (db *DB) CreateExternalAccountKey(ctx context.Context, provisionerID, reference string) (*acme.ExternalAccountKey, error) {
+// CreateExternalAccountKey creates a new External Account Binding key with a
+// name and the given lifecycle options.
+func (db *DB) CreateExternalAccountKey(ctx context.Context, provisionerID, reference string, opts acme.ExternalAccountKeyOptions) (*acme.ExternalAccountKey, error) {
 	externalAccountKeyMutex.Lock()
 	defer externalAccountKeyMutex.Unlock()
 
@@ -74,6 +94,8 @@ func (db *DB) CreateExternalAccountKey(ctx context.Context, provisionerID, refer
 		Reference:     reference,
 		HmacKey:       random,
 		CreatedAt:     clock.Now(),
+		NotAfter:      opts.NotAfter,
+		MultiUse:      opts.MultiUse,
 	}
 
 	if err := db.save(ctx, keyID, dbeak, nil, "external_account_key", externalAccountKeyTable); err != nil {
@@ -102,6 +124,10 @@ func (db *DB) CreateExternalAccountKey(ctx context.Context, provisionerID, refer
 		HmacKey:       dbeak.HmacKey,
 		CreatedAt:     dbeak.CreatedAt,
 		BoundAt:       dbeak.BoundAt,
+		NotAfter:      dbeak.NotAfter,
+		MultiUse:      dbeak.MultiUse,
+		Disabled:      dbeak.Disabled,
+		AccountIDs:    dbeak.accountIDs(),
 	}, nil
 }
 
@@ -127,6 +153,10 @@ func (db *DB) GetExternalAccountKey(ctx context.Context, provisionerID, keyID st
 		HmacKey:       dbeak.HmacKey,
 		CreatedAt:     dbeak.CreatedAt,
 		BoundAt:       dbeak.BoundAt,
+		NotAfter:      dbeak.NotAfter,
+		MultiUse:      dbeak.MultiUse,
+		Disabled:      dbeak.Disabled,
+		AccountIDs:    dbeak.accountIDs(),
 	}, nil
 }
 
@@ -148,6 +178,11 @@ func (db *DB) DeleteExternalAccountKey(ctx context.Context, provisionerID, keyID
 			return errors.Wrapf(err, "error deleting ACME EAB Key reference with Key ID %s and reference %s", keyID, dbeak.Reference)
 		}
 	}
+	for _, accountID := range dbeak.accountIDs() {
+		if err := db.db.Del(externalAccountKeyIDsByAccountIDTable, []byte(referenceKey(provisionerID, accountID))); err != nil {
+			return errors.Wrapf(err, "error deleting ACME EAB Key account index with Key ID %s and account %s", keyID, accountID)
+		}
+	}
 	if err := db.db.Del(externalAccountKeyTable, []byte(keyID)); err != nil {
 		return errors.Wrapf(err, "error deleting ACME EAB Key with Key ID %s", keyID)
 	}
@@ -199,6 +234,10 @@ func (db *DB) GetExternalAccountKeys(ctx context.Context, provisionerID, cursor
 			AccountID:     eak.AccountID,
 			CreatedAt:     eak.CreatedAt,
 			BoundAt:       eak.BoundAt,
+			NotAfter:      eak.NotAfter,
+			MultiUse:      eak.MultiUse,
+			Disabled:      eak.Disabled,
+			AccountIDs:    eak.accountIDs(),
 		})
 	}
 
@@ -229,9 +268,33 @@ func (db *DB) GetExternalAccountKeyByReference(ctx context.Context, provisionerI
 	return db.GetExternalAccountKey(ctx, provisionerID, dbExternalAccountKeyReference.ExternalAccountKeyID)
 }
 
-func (db *DB) GetExternalAccountKeyByAccountID(context.Context, string, string) (*acme.ExternalAccountKey, error) {
-	//nolint:nilnil // legacy
-	return nil, nil
+// GetExternalAccountKeyByAccountID retrieves the External Account Binding key
+// bound to the given account. It returns nil if the account was not created
+// with an External Account Binding key.
+func (db *DB) GetExternalAccountKeyByAccountID(ctx context.Context, provisionerID, accountID string) (*acme.ExternalAccountKey, error) {
+	if accountID == "" {
+		//nolint:nilnil // legacy
+		return nil, nil
+	}
+
+	k, err := db.db.Get(externalAccountKeyIDsByAccountIDTable, []byte(referenceKey(provisionerID, accountID)))
+	if nosqlDB.IsErrNotFound(err) {
+		//nolint:nilnil // accounts created without EAB or before the index existed
+		return nil, nil
+	} else if err != nil {
+		return nil, errors.Wrapf(err, "error loading ACME EAB key for account %s", accountID)
+	}
+	dbExternalAccountKeyAccount := new(dbExternalAccountKeyAccount)
+	if err := json.Unmarshal(k, dbExternalAccountKeyAccount); err != nil {
+		return nil, errors.Wrapf(err, "error unmarshaling ACME EAB key for account %s", accountID)
+	}
+
+	eak, err := db.GetExternalAccountKey(ctx, provisionerID, dbExternalAccountKeyAccount.ExternalAccountKeyID)
+	if errors.Is(err, acme.ErrNotFound) {
+		//nolint:nilnil // the key was deleted
+		return nil, nil
+	}
+	return eak, err
 }
 
 func (db *DB) UpdateExternalAccountKey(ctx context.Context, provisionerID string, eak *acme.ExternalAccountKey) error {
@@ -263,9 +326,34 @@ func (db *DB) UpdateExternalAccountKey(ctx context.Context, provisionerID string
 		HmacKey:       eak.HmacKey,
 		CreatedAt:     eak.CreatedAt,
 		BoundAt:       eak.BoundAt,
+		NotAfter:      eak.NotAfter,
+		MultiUse:      eak.MultiUse,
+		Disabled:      eak.Disabled,
+		AccountIDs:    eak.AccountIDs,
+	}
+	nu.AccountIDs = nu.accountIDs()
+
+	if err := db.save(ctx, nu.ID, nu, old, "external_account_key", externalAccountKeyTable); err != nil {
+		return err
 	}
 
-	return db.save(ctx, nu.ID, nu, old, "external_account_key", externalAccountKeyTable)
+	// index the newly bound accounts, multi-use keys are indexed once per
+	// account.
+	oldAccountIDs := old.accountIDs()
+	for _, accountID := range nu.AccountIDs {
+		if slices.Contains(oldAccountIDs, accountID) {
+			continue
+		}
+		dbExternalAccountKeyAccount := &dbExternalAccountKeyAccount{
+			AccountID:            accountID,
+			ExternalAccountKeyID: nu.ID,
+		}
+		if err := db.save(ctx, referenceKey(provisionerID, accountID), dbExternalAccountKeyAccount, nil, "external_account_key_account", externalAccountKeyIDsByAccountIDTable); err != nil {
+			return err
+		}
+	}
+
+	return nil
 }
 
 func (db *DB) addEAKID(ctx context.Context, provisionerID, eakID string) error {
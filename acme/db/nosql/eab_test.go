@@ -5,6 +5,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"testing"
+	"time"
 
 	"github.com/google/go-cmp/cmp"
 	"github.com/pkg/errors"
@@ -1051,7 +1052,7 @@ func TestDB_CreateExternalAccountKey(t *testing.T) {
 		tc := run(t)
 		t.Run(name, func(t *testing.T) {
 			d := DB{db: tc.db}
-			eak, err := d.CreateExternalAccountKey(context.Background(), provID, ref)
+			eak, err := d.CreateExternalAccountKey(context.Background(), provID, ref, acme.ExternalAccountKeyOptions{})
 			if err != nil {
 				if assert.NotNil(t, tc.err) {
 					assert.Equals(t, err.Error(), tc.err.Error())
@@ -1711,3 +1712,102 @@ func Test_removeElement(t *testing.T) {
 		})
 	}
 }
+
+func TestDB_ExternalAccountKeyLifecycle(t *testing.T) {
+	ctx := context.Background()
+	ndb, err := nosql.New("badgerv2", t.TempDir())
+	assert.FatalError(t, err)
+	db, err := New(ndb)
+	assert.FatalError(t, err)
+
+	notAfter := clock.Now().Add(time.Hour)
+	eak, err := db.CreateExternalAccountKey(ctx, "provID", "ref", acme.ExternalAccountKeyOptions{
+		NotAfter: notAfter,
+		MultiUse: true,
+	})
+	assert.FatalError(t, err)
+	got, err := db.GetExternalAccountKey(ctx, "provID", eak.ID)
+	assert.FatalError(t, err)
+	assert.True(t, got.MultiUse)
+	assert.True(t, notAfter.Equal(got.NotAfter))
+
+	// accounts without a key
+	got, err = db.GetExternalAccountKeyByAccountID(ctx, "provID", "accID1")
+	assert.FatalError(t, err)
+	assert.Nil(t, got)
+
+	// multi-use key bound to two accounts
+	for _, accID := range []string{"accID1", "accID2"} {
+		assert.FatalError(t, eak.BindTo(&acme.Account{ID: accID}))
+		assert.FatalError(t, db.UpdateExternalAccountKey(ctx, "provID", eak))
+	}
+	for _, accID := range []string{"accID1", "accID2"} {
+		got, err := db.GetExternalAccountKeyByAccountID(ctx, "provID", accID)
+		assert.FatalError(t, err)
+		assert.Equals(t, eak.ID, got.ID)
+		assert.True(t, got.MultiUse)
+		assert.True(t, notAfter.Equal(got.NotAfter))
+		assert.Equals(t, "accID2", got.AccountID)
+		assert.Equals(t, []string{"accID1", "accID2"}, got.AccountIDs)
+		assert.Equals(t, eak.HmacKey, got.HmacKey)
+	}
+	got, err = db.GetExternalAccountKeyByAccountID(ctx, "otherProvID", "accID1")
+	assert.FatalError(t, err)
+	assert.Nil(t, got)
+
+	// disable the key
+	eak.Disabled = true
+	assert.FatalError(t, db.UpdateExternalAccountKey(ctx, "provID", eak))
+	got, err = db.GetExternalAccountKeyByAccountID(ctx, "provID", "accID1")
+	assert.FatalError(t, err)
+	assert.True(t, got.Disabled)
+
+	// deleted keys are not returned and all the account indexes are removed
+	assert.FatalError(t, db.DeleteExternalAccountKey(ctx, "provID", eak.ID))
+	for _, accID := range []string{"accID1", "accID2"} {
+		got, err := db.GetExternalAccountKeyByAccountID(ctx, "provID", accID)
+		assert.FatalError(t, err)
+		assert.Nil(t, got)
+		_, err = ndb.Get(externalAccountKeyIDsByAccountIDTable, []byte("provID."+accID))
+		assert.True(t, nosqldb.IsErrNotFound(err))
+	}
+}
+
+func TestDB_DeleteExternalAccountKey_legacyAccountIndex(t *testing.T) {
+	ctx := context.Background()
+	ndb, err := nosql.New("badgerv2", t.TempDir())
+	assert.FatalError(t, err)
+	db, err := New(ndb)
+	assert.FatalError(t, err)
+
+	eak, err := db.CreateExternalAccountKey(ctx, "provID", "", acme.ExternalAccountKeyOptions{MultiUse: true})
+	assert.FatalError(t, err)
+
+	// keys bound before all the account IDs were stored only have the last
+	// account ID.
+	old, err := db.getDBExternalAccountKey(ctx, eak.ID)
+	assert.FatalError(t, err)
+	nu := *old
+	nu.AccountID = "accID2"
+	nu.BoundAt = clock.Now()
+	assert.FatalError(t, db.save(ctx, eak.ID, nu, old, "external_account_key", externalAccountKeyTable))
+	dbeaka := &dbExternalAccountKeyAccount{AccountID: "accID2", ExternalAccountKeyID: eak.ID}
+	assert.FatalError(t, db.save(ctx, "provID.accID2", dbeaka, nil, "external_account_key_account", externalAccountKeyIDsByAccountIDTable))
+
+	got, err := db.GetExternalAccountKey(ctx, "provID", eak.ID)
+	assert.FatalError(t, err)
+	assert.Equals(t, []string{"accID2"}, got.AccountIDs)
+
+	// binding a new account keeps the previous one
+	assert.FatalError(t, got.BindTo(&acme.Account{ID: "accID3"}))
+	assert.FatalError(t, db.UpdateExternalAccountKey(ctx, "provID", got))
+	got, err = db.GetExternalAccountKey(ctx, "provID", eak.ID)
+	assert.FatalError(t, err)
+	assert.Equals(t, []string{"accID2", "accID3"}, got.AccountIDs)
+
+	assert.FatalError(t, db.DeleteExternalAccountKey(ctx, "provID", eak.ID))
+	for _, accID := range []string{"accID2", "accID3"} {
+		_, err = ndb.Get(externalAccountKeyIDsByAccountIDTable, []byte("provID."+accID))
+		assert.True(t, nosqldb.IsErrNotFound(err))
+	}
+}
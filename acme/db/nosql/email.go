@@ -0,0 +1,58 @@
+package nosql
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/smallstep/certificates/acme"
+	"github.com/smallstep/nosql"
+)
+
+type dbEmailReply struct {
+	TokenPart1 string    `json:"tokenPart1"`
+	From       string    `json:"from"`
+	Response   string    `json:"response"`
+	ReceivedAt time.Time `json:"receivedAt"`
+}
+
+// SaveEmailReply stores the reply to the email-reply-00 challenge email with
+// the given token-part1. A new reply overwrites the previous one.
+func (db *DB) SaveEmailReply(_ context.Context, tokenPart1 string, reply *acme.EmailReply) error {
+	b, err := json.Marshal(&dbEmailReply{
+		TokenPart1: tokenPart1,
+		From:       reply.From,
+		Response:   reply.Response,
+		ReceivedAt: reply.ReceivedAt,
+	})
+	if err != nil {
+		return errors.Wrapf(err, "error marshaling email reply %s", tokenPart1)
+	}
+	if err := db.db.Set(emailReplyTable, []byte(tokenPart1), b); err != nil {
+		return errors.Wrapf(err, "error saving email reply %s", tokenPart1)
+	}
+	return nil
+}
+
+// GetEmailReply returns the reply to the email-reply-00 challenge email with
+// the given token-part1. It returns nil if the reply has not been received.
+func (db *DB) GetEmailReply(_ context.Context, tokenPart1 string) (*acme.EmailReply, error) {
+	b, err := db.db.Get(emailReplyTable, []byte(tokenPart1))
+	switch {
+	case nosql.IsErrNotFound(err):
+		return nil, nil
+	case err != nil:
+		return nil, errors.Wrapf(err, "error loading email reply %s", tokenPart1)
+	}
+
+	r := new(dbEmailReply)
+	if err := json.Unmarshal(b, r); err != nil {
+		return nil, errors.Wrapf(err, "error unmarshaling email reply %s", tokenPart1)
+	}
+	return &acme.EmailReply{
+		From:       r.From,
+		Response:   r.Response,
+		ReceivedAt: r.ReceivedAt,
+	}, nil
+}
@@ -0,0 +1,62 @@
+package nosql
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/smallstep/certificates/acme"
+	"github.com/smallstep/nosql"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDB_EmailReplies(t *testing.T) {
+	ctx := context.Background()
+	ndb, err := nosql.New("badgerv2", t.TempDir())
+	require.NoError(t, err)
+	db, err := New(ndb)
+	require.NoError(t, err)
+
+	// The reply has not been received.
+	reply, err := db.GetEmailReply(ctx, "token1")
+	require.NoError(t, err)
+	assert.Nil(t, reply)
+
+	now := clock.Now().Truncate(time.Second)
+	require.NoError(t, db.SaveEmailReply(ctx, "token1", &acme.EmailReply{
+		From:       "alice@example.com",
+		Response:   "response1",
+		ReceivedAt: now,
+	}))
+	reply, err = db.GetEmailReply(ctx, "token1")
+	require.NoError(t, err)
+	assert.Equal(t, "alice@example.com", reply.From)
+	assert.Equal(t, "response1", reply.Response)
+	assert.True(t, now.Equal(reply.ReceivedAt))
+
+	// A new reply overwrites the previous one.
+	require.NoError(t, db.SaveEmailReply(ctx, "token1", &acme.EmailReply{
+		From:       "alice@example.com",
+		Response:   "response2",
+		ReceivedAt: now,
+	}))
+	reply, err = db.GetEmailReply(ctx, "token1")
+	require.NoError(t, err)
+	assert.Equal(t, "response2", reply.Response)
+
+	// The challenge keeps the token-part1 and the from address.
+	ch := &acme.Challenge{
+		AccountID:  "accID",
+		Type:       acme.EMAILREPLY00,
+		Value:      "alice@example.com",
+		Token:      "token2",
+		TokenPart1: "token1",
+		From:       "acme@ca.example.com",
+	}
+	require.NoError(t, db.CreateChallenge(ctx, ch))
+	got, err := db.GetChallenge(ctx, ch.ID, "azID")
+	require.NoError(t, err)
+	assert.Equal(t, "token1", got.TokenPart1)
+	assert.Equal(t, "acme@ca.example.com", got.From)
+}
@@ -0,0 +1,240 @@
+package nosql
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/smallstep/certificates/acme"
+	"github.com/smallstep/nosql"
+)
+
+// SweepOptions are the options used to remove stale ACME objects from the
+// database.
+type SweepOptions struct {
+	// Retention is the time that orders and authorizations are kept after
+	// their expiration.
+	Retention time.Duration
+	// NonceRetention is the time that unused nonces are kept after their
+	// creation.
+	NonceRetention time.Duration
+}
+
+// SweepResult contains the number of objects removed by a sweep.
+type SweepResult struct {
+	Orders         int
+	Authorizations int
+	Challenges     int
+	Nonces         int
+	OrderIndexes   int
+	RateLimits     int
+}
+
+// Counts returns the number of objects removed indexed by object type.
+func (r *SweepResult) Counts() map[string]int {
+	return map[string]int{
+		"order":         r.Orders,
+		"authorization": r.Authorizations,
+		"challenge":     r.Challenges,
+		"nonce":         r.Nonces,
+		"order_index":   r.OrderIndexes,
+		"rate_limit":    r.RateLimits,
+	}
+}
+
+// Total returns the total number of objects removed.
+func (r *SweepResult) Total() int {
+	return r.Orders + r.Authorizations + r.Challenges + r.Nonces + r.OrderIndexes + r.RateLimits
+}
+
+// Sweep removes from the database the orders and authorizations that expired
+// before the configured retention, the challenges of those authorizations,
+// and the unused nonces older than the nonce retention. The references to the
+// removed orders are also removed from the account-to-orders index, and the
+// rate limit counters with an expired window are removed too. The result
+// contains the objects removed, even if an error is returned.
+func (db *DB) Sweep(ctx context.Context, opts SweepOptions) (*SweepResult, error) {
+	res := new(SweepResult)
+	now := clock.Now()
+
+	if err := db.sweepOrders(ctx, now.Add(-opts.Retention), res); err != nil {
+		return res, err
+	}
+	if err := db.sweepAuthorizations(ctx, now.Add(-opts.Retention), res); err != nil {
+		return res, err
+	}
+	if err := db.sweepNonces(ctx, now.Add(-opts.NonceRetention), res); err != nil {
+		return res, err
+	}
+	if err := db.sweepRateLimits(ctx, now, res); err != nil {
+		return res, err
+	}
+	return res, nil
+}
+
+func (db *DB) sweepOrders(_ context.Context, before time.Time, res *SweepResult) error {
+	entries, err := db.db.List(orderTable)
+	if err != nil {
+		return errors.Wrap(err, "error listing orders")
+	}
+
+	deleted := make(map[string]struct{})
+	for _, entry := range entries {
+		dbo := new(dbOrder)
+		if err := json.Unmarshal(entry.Value, dbo); err != nil {
+			return errors.Wrapf(err, "error unmarshaling order %s", entry.Key)
+		}
+		if dbo.ExpiresAt.IsZero() || !dbo.ExpiresAt.Before(before) {
+			continue
+		}
+		// Auto-renewal orders are kept until their end date.
+		if dbo.AutoRenewal != nil && dbo.Status != acme.StatusInvalid && !dbo.AutoRenewal.EndDate.Before(before) {
+			continue
+		}
+		if err := db.db.Del(orderTable, entry.Key); err != nil {
+			return errors.Wrapf(err, "error deleting order %s", entry.Key)
+		}
+		if dbo.AutoRenewal != nil {
+			if err := db.db.Del(autoRenewalOrdersTable, entry.Key); err != nil && !nosql.IsErrNotFound(err) {
+				return errors.Wrapf(err, "error deleting auto-renewal order %s index", entry.Key)
+			}
+		}
+		deleted[string(entry.Key)] = struct{}{}
+		res.Orders++
+	}
+
+	if len(deleted) == 0 {
+		return nil
+	}
+	return db.sweepOrdersByAccountIndex(deleted, res)
+}
+
+// sweepOrdersByAccountIndex removes the given order ids from the
+// account-to-orders index.
+func (db *DB) sweepOrdersByAccountIndex(deleted map[string]struct{}, res *SweepResult) error {
+	ordersByAccountMux.Lock()
+	defer ordersByAccountMux.Unlock()
+
+	entries, err := db.db.List(ordersByAccountIDTable)
+	if err != nil {
+		return errors.Wrap(err, "error listing orderIDs index")
+	}
+
+	for _, entry := range entries {
+		var oids []string
+		if err := json.Unmarshal(entry.Value, &oids); err != nil {
+			return errors.Wrapf(err, "error unmarshaling orderIDs for account %s", entry.Key)
+		}
+		keep := make([]string, 0, len(oids))
+		for _, oid := range oids {
+			if _, ok := deleted[oid]; !ok {
+				keep = append(keep, oid)
+			}
+		}
+		switch {
+		case len(keep) == len(oids):
+			continue
+		case len(keep) == 0:
+			if err := db.db.Del(ordersByAccountIDTable, entry.Key); err != nil {
+				return errors.Wrapf(err, "error deleting orderIDs index for account %s", entry.Key)
+			}
+		default:
+			b, err := json.Marshal(keep)
+			if err != nil {
+				return errors.Wrapf(err, "error marshaling orderIDs for account %s", entry.Key)
+			}
+			_, swapped, err := db.db.CmpAndSwap(ordersByAccountIDTable, entry.Key, entry.Value, b)
+			if err != nil {
+				return errors.Wrapf(err, "error saving orderIDs index for account %s", entry.Key)
+			}
+			if !swapped {
+				// The index was modified after it was listed, the next
+				// sweep will remove the stale references.
+				continue
+			}
+		}
+		res.OrderIndexes++
+	}
+
+	return nil
+}
+
+func (db *DB) sweepAuthorizations(_ context.Context, before time.Time, res *SweepResult) error {
+	entries, err := db.db.List(authzTable)
+	if err != nil {
+		return errors.Wrap(err, "error listing authz")
+	}
+
+	for _, entry := range entries {
+		dbaz := new(dbAuthz)
+		if err := json.Unmarshal(entry.Value, dbaz); err != nil {
+			return errors.Wrapf(err, "error unmarshaling authz %s", entry.Key)
+		}
+		if dbaz.ExpiresAt.IsZero() || !dbaz.ExpiresAt.Before(before) {
+			continue
+		}
+		for _, chID := range dbaz.ChallengeIDs {
+			switch err := db.db.Del(challengeTable, []byte(chID)); {
+			case nosql.IsErrNotFound(err):
+			case err != nil:
+				return errors.Wrapf(err, "error deleting challenge %s", chID)
+			default:
+				res.Challenges++
+			}
+		}
+		if err := db.db.Del(authzTable, entry.Key); err != nil {
+			return errors.Wrapf(err, "error deleting authz %s", entry.Key)
+		}
+		res.Authorizations++
+	}
+
+	return nil
+}
+
+func (db *DB) sweepNonces(_ context.Context, before time.Time, res *SweepResult) error {
+	entries, err := db.db.List(nonceTable)
+	if err != nil {
+		return errors.Wrap(err, "error listing nonces")
+	}
+
+	for _, entry := range entries {
+		n := new(dbNonce)
+		if err := json.Unmarshal(entry.Value, n); err != nil {
+			return errors.Wrapf(err, "error unmarshaling nonce %s", entry.Key)
+		}
+		if !n.CreatedAt.Before(before) {
+			continue
+		}
+		// A nonce might have been consumed in the meantime.
+		if err := db.db.Del(nonceTable, entry.Key); err != nil && !nosql.IsErrNotFound(err) {
+			return errors.Wrapf(err, "error deleting nonce %s", entry.Key)
+		}
+		res.Nonces++
+	}
+
+	return nil
+}
+
+func (db *DB) sweepRateLimits(_ context.Context, now time.Time, res *SweepResult) error {
+	entries, err := db.db.List(rateLimitTable)
+	if err != nil {
+		return errors.Wrap(err, "error listing rate limit counters")
+	}
+
+	for _, entry := range entries {
+		c := new(dbRateLimitCounter)
+		if err := json.Unmarshal(entry.Value, c); err != nil {
+			return errors.Wrapf(err, "error unmarshaling rate limit counter %s", entry.Key)
+		}
+		if now.Before(c.ResetAt) {
+			continue
+		}
+		if err := db.db.Del(rateLimitTable, entry.Key); err != nil {
+			return errors.Wrapf(err, "error deleting rate limit counter %s", entry.Key)
+		}
+		res.RateLimits++
+	}
+
+	return nil
+}
@@ -0,0 +1,148 @@
+package nosql
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/smallstep/nosql"
+	"github.com/smallstep/nosql/database"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/smallstep/certificates/acme"
+	certdb "github.com/smallstep/certificates/db"
+)
+
+func TestDB_Sweep(t *testing.T) {
+	ctx := context.Background()
+	ndb, err := nosql.New("badgerv2", t.TempDir())
+	require.NoError(t, err)
+	db, err := New(ndb)
+	require.NoError(t, err)
+
+	now := clock.Now()
+	retention := 24 * time.Hour
+	stale := now.Add(-2 * retention)
+	fresh := now.Add(time.Hour)
+
+	newChallenge := func() *acme.Challenge {
+		ch := &acme.Challenge{AccountID: "accID", Type: acme.HTTP01, Status: acme.StatusPending, Token: "token", Value: "example.com"}
+		require.NoError(t, db.CreateChallenge(ctx, ch))
+		return ch
+	}
+	newAuthz := func(expiresAt time.Time) (*acme.Authorization, *acme.Challenge) {
+		ch := newChallenge()
+		az := &acme.Authorization{
+			AccountID:  "accID",
+			Identifier: acme.Identifier{Type: acme.DNS, Value: "example.com"},
+			Status:     acme.StatusPending,
+			ExpiresAt:  expiresAt,
+			Challenges: []*acme.Challenge{ch},
+		}
+		require.NoError(t, db.CreateAuthorization(ctx, az))
+		return az, ch
+	}
+	newOrder := func(expiresAt time.Time, azID string) *acme.Order {
+		o := &acme.Order{
+			AccountID:        "accID",
+			Status:           acme.StatusPending,
+			ExpiresAt:        expiresAt,
+			Identifiers:      []acme.Identifier{{Type: acme.DNS, Value: "example.com"}},
+			AuthorizationIDs: []string{azID},
+		}
+		require.NoError(t, db.CreateOrder(ctx, o))
+		return o
+	}
+
+	staleAz, staleCh := newAuthz(stale)
+	freshAz, freshCh := newAuthz(fresh)
+	staleOrder := newOrder(stale, staleAz.ID)
+	freshOrder := newOrder(fresh, freshAz.ID)
+
+	// The index only contains pending orders, the stale order is added
+	// manually to verify its removal.
+	b, err := json.Marshal([]string{staleOrder.ID, freshOrder.ID})
+	require.NoError(t, err)
+	require.NoError(t, ndb.Set(ordersByAccountIDTable, []byte("accID"), b))
+
+	require.NoError(t, db.save(ctx, "stale-nonce", &dbNonce{ID: "stale-nonce", CreatedAt: stale}, nil, "nonce", nonceTable))
+	freshNonce, err := db.CreateNonce(ctx)
+	require.NoError(t, err)
+
+	require.NoError(t, db.save(ctx, "stale-counter", &dbRateLimitCounter{Key: "stale-counter", Count: 1, WindowStart: stale, ResetAt: stale.Add(time.Hour)}, nil, "rateLimitCounter", rateLimitTable))
+	_, err = db.IncrementRateLimitCounter(ctx, "fresh-counter", time.Hour)
+	require.NoError(t, err)
+
+	res, err := db.Sweep(ctx, SweepOptions{Retention: retention, NonceRetention: time.Hour})
+	require.NoError(t, err)
+	assert.Equal(t, &SweepResult{
+		Orders:         1,
+		Authorizations: 1,
+		Challenges:     1,
+		Nonces:         1,
+		OrderIndexes:   1,
+		RateLimits:     1,
+	}, res)
+	assert.Equal(t, 6, res.Total())
+	assert.Equal(t, map[string]int{
+		"order": 1, "authorization": 1, "challenge": 1, "nonce": 1, "order_index": 1, "rate_limit": 1,
+	}, res.Counts())
+
+	_, err = db.GetOrder(ctx, staleOrder.ID)
+	assert.Error(t, err)
+	_, err = db.GetOrder(ctx, freshOrder.ID)
+	assert.NoError(t, err)
+	_, err = db.GetAuthorization(ctx, staleAz.ID)
+	assert.Error(t, err)
+	_, err = db.GetAuthorization(ctx, freshAz.ID)
+	assert.NoError(t, err)
+	_, err = db.GetChallenge(ctx, staleCh.ID, staleAz.ID)
+	assert.Error(t, err)
+	_, err = db.GetChallenge(ctx, freshCh.ID, freshAz.ID)
+	assert.NoError(t, err)
+	_, err = ndb.Get(nonceTable, []byte("stale-nonce"))
+	assert.True(t, nosql.IsErrNotFound(err))
+	_, err = ndb.Get(nonceTable, []byte(freshNonce))
+	assert.NoError(t, err)
+	_, err = ndb.Get(rateLimitTable, []byte("stale-counter"))
+	assert.True(t, nosql.IsErrNotFound(err))
+	_, err = ndb.Get(rateLimitTable, []byte("fresh-counter"))
+	assert.NoError(t, err)
+
+	b, err = ndb.Get(ordersByAccountIDTable, []byte("accID"))
+	require.NoError(t, err)
+	var oids []string
+	require.NoError(t, json.Unmarshal(b, &oids))
+	assert.Equal(t, []string{freshOrder.ID}, oids)
+
+	// A second run does not find anything else to remove.
+	res, err = db.Sweep(ctx, SweepOptions{Retention: retention, NonceRetention: time.Hour})
+	require.NoError(t, err)
+	assert.Equal(t, &SweepResult{}, res)
+}
+
+func TestDB_sweepOrdersByAccountIndex_notSwapped(t *testing.T) {
+	var swaps int
+	d := &DB{db: &certdb.MockNoSQLDB{
+		MList: func(bucket []byte) ([]*database.Entry, error) {
+			assert.Equal(t, ordersByAccountIDTable, bucket)
+			return []*database.Entry{
+				{Bucket: bucket, Key: []byte("accID"), Value: []byte(`["stale","fresh"]`)},
+			}, nil
+		},
+		MCmpAndSwap: func(bucket, key, old, nu []byte) ([]byte, bool, error) {
+			swaps++
+			assert.Equal(t, ordersByAccountIDTable, bucket)
+			assert.Equal(t, `["stale","fresh"]`, string(old))
+			assert.Equal(t, `["fresh"]`, string(nu))
+			return []byte(`["stale","fresh","new"]`), false, nil
+		},
+	}}
+
+	res := new(SweepResult)
+	require.NoError(t, d.sweepOrdersByAccountIndex(map[string]struct{}{"stale": {}}, res))
+	assert.Equal(t, 1, swaps)
+	assert.Equal(t, 0, res.OrderIndexes)
+}
@@ -18,13 +18,19 @@ var (
 	nonceTable                                = []byte("nonces")
 	orderTable                                = []byte("acme_orders")
 	ordersByAccountIDTable                    = []byte("acme_account_orders_index")
+	authzsByAccountIDTable                    = []byte("acme_account_authzs_index")
 	certTable                                 = []byte("acme_certs")
 	certBySerialTable                         = []byte("acme_serial_certs_index")
 	externalAccountKeyTable                   = []byte("acme_external_account_keys")
 	externalAccountKeyIDsByReferenceTable     = []byte("acme_external_account_keyID_reference_index")
 	externalAccountKeyIDsByProvisionerIDTable = []byte("acme_external_account_keyID_provisionerID_index")
+	externalAccountKeyIDsByAccountIDTable     = []byte("acme_external_account_keyID_accountID_index")
 	wireDpopTokenTable                        = []byte("wire_acme_dpop_token")
 	wireOidcTokenTable                        = []byte("wire_acme_oidc_token")
+	rateLimitTable                            = []byte("acme_rate_limits")
+	validationJobTable                        = []byte("acme_validation_jobs")
+	emailReplyTable                           = []byte("acme_email_replies")
+	autoRenewalOrdersTable                    = []byte("acme_auto_renewal_orders_index")
 )
 
 // DB is a struct that implements the AcmeDB interface.
@@ -38,7 +44,9 @@ func New(db nosqlDB.DB) (*DB, error) {
 		challengeTable, nonceTable, orderTable, ordersByAccountIDTable,
 		certTable, certBySerialTable, externalAccountKeyTable,
 		externalAccountKeyIDsByReferenceTable, externalAccountKeyIDsByProvisionerIDTable,
-		wireDpopTokenTable, wireOidcTokenTable,
+		externalAccountKeyIDsByAccountIDTable,
+		wireDpopTokenTable, wireOidcTokenTable, rateLimitTable, validationJobTable,
+		emailReplyTable, autoRenewalOrdersTable, authzsByAccountIDTable,
 	}
 	for _, b := range tables {
 		if err := db.CreateTable(b); err != nil {
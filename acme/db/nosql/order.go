@@ -3,6 +3,7 @@ package nosql
 import (
 	"context"
 	"encoding/json"
+	stderrors "errors"
 	"sync"
 	"time"
 
@@ -26,7 +27,15 @@ type dbOrder struct {
 	CreatedAt        time.Time         `json:"createdAt"`
 	ExpiresAt        time.Time         `json:"expiresAt,omitempty"`
 	CertificateID    string            `json:"certificate,omitempty"`
+	Replaces         string            `json:"replaces,omitempty"`
+	Profile          string            `json:"profile,omitempty"`
+	AutoRenewal      *acme.AutoRenewal `json:"autoRenewal,omitempty"`
+	CSR              []byte            `json:"csr,omitempty"`
 	Error            *acme.Error       `json:"error,omitempty"`
+	// RenewalLockedBy and RenewalLockedUntil lock the reissue of the current
+	// certificate of an auto-renewal order.
+	RenewalLockedBy    string    `json:"renewalLockedBy,omitempty"`
+	RenewalLockedUntil time.Time `json:"renewalLockedUntil,omitempty"`
 }
 
 func (a *dbOrder) clone() *dbOrder {
@@ -34,6 +43,26 @@ func (a *dbOrder) clone() *dbOrder {
 	return &b
 }
 
+func (a *dbOrder) toACME() *acme.Order {
+	return &acme.Order{
+		ID:               a.ID,
+		AccountID:        a.AccountID,
+		ProvisionerID:    a.ProvisionerID,
+		CertificateID:    a.CertificateID,
+		Status:           a.Status,
+		ExpiresAt:        a.ExpiresAt,
+		Identifiers:      a.Identifiers,
+		NotBefore:        a.NotBefore,
+		NotAfter:         a.NotAfter,
+		AuthorizationIDs: a.AuthorizationIDs,
+		Replaces:         a.Replaces,
+		Profile:          a.Profile,
+		AutoRenewal:      a.AutoRenewal,
+		CSR:              a.CSR,
+		Error:            a.Error,
+	}
+}
+
 // getDBOrder retrieves and unmarshals an ACME Order type from the database.
 func (db *DB) getDBOrder(_ context.Context, id string) (*dbOrder, error) {
 	b, err := db.db.Get(orderTable, []byte(id))
@@ -56,21 +85,7 @@ func (db *DB) GetOrder(ctx context.Context, id string) (*acme.Order, error) {
 		return nil, err
 	}
 
-	o := &acme.Order{
-		ID:               dbo.ID,
-		AccountID:        dbo.AccountID,
-		ProvisionerID:    dbo.ProvisionerID,
-		CertificateID:    dbo.CertificateID,
-		Status:           dbo.Status,
-		ExpiresAt:        dbo.ExpiresAt,
-		Identifiers:      dbo.Identifiers,
-		NotBefore:        dbo.NotBefore,
-		NotAfter:         dbo.NotAfter,
-		AuthorizationIDs: dbo.AuthorizationIDs,
-		Error:            dbo.Error,
-	}
-
-	return o, nil
+	return dbo.toACME(), nil
 }
 
 // CreateOrder creates ACME Order resources and saves them to the DB.
@@ -93,6 +108,9 @@ func (db *DB) CreateOrder(ctx context.Context, o *acme.Order) error {
 		NotBefore:        o.NotBefore,
 		NotAfter:         o.NotAfter,
 		AuthorizationIDs: o.AuthorizationIDs,
+		Replaces:         o.Replaces,
+		Profile:          o.Profile,
+		AutoRenewal:      o.AutoRenewal,
 	}
 	if err := db.save(ctx, o.ID, dbo, nil, "order", orderTable); err != nil {
 		return err
@@ -117,8 +135,101 @@ func (db *DB) UpdateOrder(ctx context.Context, o *acme.Order) error {
 	nu.Status = o.Status
 	nu.Error = o.Error
 	nu.CertificateID = o.CertificateID
+	nu.CSR = o.CSR
+
+	// A new certificate releases the renewal lock of the previous one.
+	if nu.CertificateID != old.CertificateID {
+		nu.RenewalLockedBy = ""
+		nu.RenewalLockedUntil = time.Time{}
+	}
+
+	if err := db.save(ctx, old.ID, nu, old, "order", orderTable); err != nil {
+		return err
+	}
+
+	// Keep the index of the auto-renewal orders being renewed.
+	if nu.AutoRenewal != nil {
+		return db.updateAutoRenewalOrdersIndex(nu)
+	}
+	return nil
+}
+
+// updateAutoRenewalOrdersIndex adds the given auto-renewal order to the index
+// of auto-renewal orders if it's valid, and removes it otherwise.
+func (db *DB) updateAutoRenewalOrdersIndex(dbo *dbOrder) error {
+	if dbo.Status == acme.StatusValid {
+		if err := db.db.Set(autoRenewalOrdersTable, []byte(dbo.ID), []byte(dbo.ID)); err != nil {
+			return errors.Wrapf(err, "error saving auto-renewal order %s index", dbo.ID)
+		}
+		return nil
+	}
+	if err := db.db.Del(autoRenewalOrdersTable, []byte(dbo.ID)); err != nil && !nosql.IsErrNotFound(err) {
+		return errors.Wrapf(err, "error deleting auto-renewal order %s index", dbo.ID)
+	}
+	return nil
+}
+
+// LockOrderRenewal locks the reissue of the current certificate of the given
+// auto-renewal order for the given owner until the given time. The lock is
+// acquired using compare-and-swap so only one instance of the CA reissues the
+// certificate, and it's released when the order is updated with a new
+// certificate. It returns acme.ErrOrderRenewalLocked if the renewal is locked
+// by another owner. The returned order is the stored one after acquiring the
+// lock.
+func (db *DB) LockOrderRenewal(_ context.Context, orderID, owner string, until time.Time) (*acme.Order, error) {
+	old, err := db.db.Get(orderTable, []byte(orderID))
+	switch {
+	case nosql.IsErrNotFound(err):
+		return nil, acme.NewError(acme.ErrorMalformedType, "order %s not found", orderID)
+	case err != nil:
+		return nil, errors.Wrapf(err, "error loading order %s", orderID)
+	}
+
+	dbo := new(dbOrder)
+	if err := json.Unmarshal(old, dbo); err != nil {
+		return nil, errors.Wrapf(err, "error unmarshaling order %s into dbOrder", orderID)
+	}
+	if dbo.RenewalLockedBy != "" && dbo.RenewalLockedBy != owner && dbo.RenewalLockedUntil.After(clock.Now()) {
+		return nil, acme.ErrOrderRenewalLocked
+	}
+
+	dbo.RenewalLockedBy = owner
+	dbo.RenewalLockedUntil = until
+	nu, err := json.Marshal(dbo)
+	if err != nil {
+		return nil, errors.Wrapf(err, "error marshaling order %s", orderID)
+	}
+	_, swapped, err := db.db.CmpAndSwap(orderTable, []byte(orderID), old, nu)
+	switch {
+	case err != nil:
+		return nil, errors.Wrapf(err, "error saving order %s", orderID)
+	case !swapped:
+		return nil, acme.ErrOrderRenewalLocked
+	}
+	return dbo.toACME(), nil
+}
+
+// GetAutoRenewalOrders returns the valid auto-renewal orders. Orders that
+// cannot be loaded are skipped, and the returned error contains the reasons.
+func (db *DB) GetAutoRenewalOrders(ctx context.Context) ([]*acme.Order, error) {
+	entries, err := db.db.List(autoRenewalOrdersTable)
+	if err != nil {
+		return nil, errors.Wrap(err, "error listing auto-renewal orders")
+	}
 
-	return db.save(ctx, old.ID, nu, old, "order", orderTable)
+	var errs []error
+	orders := make([]*acme.Order, 0, len(entries))
+	for _, entry := range entries {
+		o, err := db.GetOrder(ctx, string(entry.Key))
+		if err != nil {
+			errs = append(errs, errors.Wrapf(err, "error loading auto-renewal order %s", entry.Key))
+			continue
+		}
+		if o.Status == acme.StatusValid {
+			orders = append(orders, o)
+		}
+	}
+	return orders, stderrors.Join(errs...)
 }
 
 func (db *DB) updateAddOrderIDs(ctx context.Context, accID string, includeReadyOrders bool, addOids ...string) ([]string, error) {
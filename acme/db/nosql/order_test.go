@@ -1023,3 +1023,142 @@ func TestDB_updateAddOrderIDs(t *testing.T) {
 		})
 	}
 }
+
+func TestDB_GetAutoRenewalOrders(t *testing.T) {
+	ctx := context.Background()
+	ndb, err := nosql.New("badgerv2", t.TempDir())
+	assert.FatalError(t, err)
+	db, err := New(ndb)
+	assert.FatalError(t, err)
+
+	now := clock.Now().Truncate(time.Second)
+	newOrder := func(ar *acme.AutoRenewal) *acme.Order {
+		o := &acme.Order{
+			AccountID:        "accID",
+			Status:           acme.StatusReady,
+			ExpiresAt:        now.Add(time.Hour),
+			Identifiers:      []acme.Identifier{{Type: acme.DNS, Value: "example.com"}},
+			AuthorizationIDs: []string{"azID"},
+			AutoRenewal:      ar,
+		}
+		assert.FatalError(t, db.CreateOrder(ctx, o))
+		return o
+	}
+
+	ar := &acme.AutoRenewal{
+		StartDate: now,
+		EndDate:   now.Add(24 * time.Hour),
+		Lifetime:  3600,
+	}
+	o1 := newOrder(ar)
+	o2 := newOrder(nil)
+
+	// Orders are added to the index once they are valid.
+	orders, err := db.GetAutoRenewalOrders(ctx)
+	assert.FatalError(t, err)
+	assert.Len(t, 0, orders)
+
+	for _, o := range []*acme.Order{o1, o2} {
+		o.Status = acme.StatusValid
+		o.CertificateID = "certID"
+		o.CSR = []byte("csr")
+		assert.FatalError(t, db.UpdateOrder(ctx, o))
+	}
+	orders, err = db.GetAutoRenewalOrders(ctx)
+	assert.FatalError(t, err)
+	assert.Len(t, 1, orders)
+	assert.Equals(t, o1.ID, orders[0].ID)
+	assert.Equals(t, ar, orders[0].AutoRenewal)
+	assert.Equals(t, []byte("csr"), orders[0].CSR)
+
+	// Orders that cannot be loaded are reported without hiding the others.
+	assert.FatalError(t, db.db.Set(autoRenewalOrdersTable, []byte("missing"), []byte{}))
+	orders, err = db.GetAutoRenewalOrders(ctx)
+	if assert.NotNil(t, err) {
+		assert.HasPrefix(t, err.Error(), "error loading auto-renewal order missing")
+	}
+	assert.Len(t, 1, orders)
+	assert.Equals(t, o1.ID, orders[0].ID)
+	assert.FatalError(t, db.db.Del(autoRenewalOrdersTable, []byte("missing")))
+
+	// Canceled orders are removed from the index.
+	o1.Status = acme.StatusCanceled
+	assert.FatalError(t, db.UpdateOrder(ctx, o1))
+	orders, err = db.GetAutoRenewalOrders(ctx)
+	assert.FatalError(t, err)
+	assert.Len(t, 0, orders)
+
+	// Auto-renewal orders are not swept until their end date.
+	o1.Status = acme.StatusValid
+	assert.FatalError(t, db.UpdateOrder(ctx, o1))
+	res, err := db.Sweep(ctx, SweepOptions{Retention: -2 * time.Hour})
+	assert.FatalError(t, err)
+	assert.Equals(t, 1, res.Orders)
+	_, err = db.GetOrder(ctx, o1.ID)
+	assert.FatalError(t, err)
+
+	res, err = db.Sweep(ctx, SweepOptions{Retention: -48 * time.Hour})
+	assert.FatalError(t, err)
+	assert.Equals(t, 1, res.Orders)
+	orders, err = db.GetAutoRenewalOrders(ctx)
+	assert.FatalError(t, err)
+	assert.Len(t, 0, orders)
+}
+
+func TestDB_LockOrderRenewal(t *testing.T) {
+	ctx := context.Background()
+	ndb, err := nosql.New("badgerv2", t.TempDir())
+	assert.FatalError(t, err)
+	db, err := New(ndb)
+	assert.FatalError(t, err)
+
+	now := clock.Now().Truncate(time.Second)
+	o := &acme.Order{
+		AccountID:        "accID",
+		Status:           acme.StatusReady,
+		ExpiresAt:        now.Add(time.Hour),
+		Identifiers:      []acme.Identifier{{Type: acme.DNS, Value: "example.com"}},
+		AuthorizationIDs: []string{"azID"},
+		AutoRenewal: &acme.AutoRenewal{
+			StartDate: now,
+			EndDate:   now.Add(24 * time.Hour),
+			Lifetime:  3600,
+		},
+	}
+	assert.FatalError(t, db.CreateOrder(ctx, o))
+	o.Status = acme.StatusValid
+	o.CertificateID = "cert1"
+	assert.FatalError(t, db.UpdateOrder(ctx, o))
+
+	_, err = db.LockOrderRenewal(ctx, "missing", "owner1", now.Add(time.Minute))
+	assert.NotNil(t, err)
+
+	// Only one owner can reissue the current certificate.
+	locked, err := db.LockOrderRenewal(ctx, o.ID, "owner1", now.Add(time.Minute))
+	assert.FatalError(t, err)
+	assert.Equals(t, acme.StatusValid, locked.Status)
+	assert.Equals(t, "cert1", locked.CertificateID)
+	_, err = db.LockOrderRenewal(ctx, o.ID, "owner2", now.Add(time.Minute))
+	assert.Equals(t, acme.ErrOrderRenewalLocked, err)
+	_, err = db.LockOrderRenewal(ctx, o.ID, "owner1", now.Add(time.Minute))
+	assert.FatalError(t, err)
+
+	// A canceled order is returned after the lock.
+	o.Status = acme.StatusCanceled
+	assert.FatalError(t, db.UpdateOrder(ctx, o))
+	locked, err = db.LockOrderRenewal(ctx, o.ID, "owner1", now.Add(time.Minute))
+	assert.FatalError(t, err)
+	assert.Equals(t, acme.StatusCanceled, locked.Status)
+
+	// A new certificate releases the lock.
+	o.Status = acme.StatusValid
+	o.CertificateID = "cert2"
+	assert.FatalError(t, db.UpdateOrder(ctx, o))
+	locked, err = db.LockOrderRenewal(ctx, o.ID, "owner2", now.Add(-time.Minute))
+	assert.FatalError(t, err)
+	assert.Equals(t, "cert2", locked.CertificateID)
+
+	// An expired lock can be taken by another owner.
+	_, err = db.LockOrderRenewal(ctx, o.ID, "owner1", now.Add(time.Minute))
+	assert.FatalError(t, err)
+}
@@ -0,0 +1,89 @@
+package nosql
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/smallstep/certificates/acme"
+	"github.com/smallstep/nosql"
+)
+
+// maxRateLimitRetries is the number of times that the update of a rate limit
+// counter is retried if it was modified concurrently.
+const maxRateLimitRetries = 10
+
+type dbRateLimitCounter struct {
+	Key         string    `json:"key"`
+	Count       int       `json:"count"`
+	WindowStart time.Time `json:"windowStart"`
+	ResetAt     time.Time `json:"resetAt"`
+}
+
+func (c *dbRateLimitCounter) toACME() *acme.RateLimitCounter {
+	return &acme.RateLimitCounter{
+		Key:     c.Key,
+		Count:   c.Count,
+		ResetAt: c.ResetAt,
+	}
+}
+
+// getDBRateLimitCounter returns the current counter and its raw value. If the
+// counter does not exist or its window has expired, it returns a new counter
+// starting now.
+func (db *DB) getDBRateLimitCounter(key string, window time.Duration) (*dbRateLimitCounter, []byte, error) {
+	now := clock.Now()
+	b, err := db.db.Get(rateLimitTable, []byte(key))
+	switch {
+	case nosql.IsErrNotFound(err):
+		return &dbRateLimitCounter{Key: key, WindowStart: now, ResetAt: now.Add(window)}, nil, nil
+	case err != nil:
+		return nil, nil, errors.Wrapf(err, "error loading rate limit counter %s", key)
+	}
+
+	c := new(dbRateLimitCounter)
+	if err := json.Unmarshal(b, c); err != nil {
+		return nil, nil, errors.Wrapf(err, "error unmarshaling rate limit counter %s", key)
+	}
+	if !now.Before(c.ResetAt) {
+		c = &dbRateLimitCounter{Key: key, WindowStart: now, ResetAt: now.Add(window)}
+	}
+	return c, b, nil
+}
+
+// GetRateLimitCounter returns the rate limit counter with the given key. If the
+// counter does not exist, or its window has expired, the count is 0.
+func (db *DB) GetRateLimitCounter(_ context.Context, key string, window time.Duration) (*acme.RateLimitCounter, error) {
+	c, _, err := db.getDBRateLimitCounter(key, window)
+	if err != nil {
+		return nil, err
+	}
+	return c.toACME(), nil
+}
+
+// IncrementRateLimitCounter increments the rate limit counter with the given
+// key, starting a new window if the previous one has expired. The counter is
+// updated using compare-and-swap so it can be shared by multiple instances of
+// the CA.
+func (db *DB) IncrementRateLimitCounter(_ context.Context, key string, window time.Duration) (*acme.RateLimitCounter, error) {
+	for i := 0; i < maxRateLimitRetries; i++ {
+		c, old, err := db.getDBRateLimitCounter(key, window)
+		if err != nil {
+			return nil, err
+		}
+		c.Count++
+		nu, err := json.Marshal(c)
+		if err != nil {
+			return nil, errors.Wrapf(err, "error marshaling rate limit counter %s", key)
+		}
+		_, swapped, err := db.db.CmpAndSwap(rateLimitTable, []byte(key), old, nu)
+		switch {
+		case err != nil:
+			return nil, errors.Wrapf(err, "error saving rate limit counter %s", key)
+		case swapped:
+			return c.toACME(), nil
+		}
+	}
+	return nil, errors.Errorf("error saving rate limit counter %s: too many concurrent updates", key)
+}
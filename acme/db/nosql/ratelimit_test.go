@@ -0,0 +1,57 @@
+package nosql
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/smallstep/nosql"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDB_RateLimitCounter(t *testing.T) {
+	ctx := context.Background()
+	ndb, err := nosql.New("badgerv2", t.TempDir())
+	require.NoError(t, err)
+	db, err := New(ndb)
+	require.NoError(t, err)
+
+	c, err := db.GetRateLimitCounter(ctx, "key", time.Hour)
+	require.NoError(t, err)
+	assert.Equal(t, 0, c.Count)
+	assert.Equal(t, "key", c.Key)
+
+	for i := 1; i <= 3; i++ {
+		c, err = db.IncrementRateLimitCounter(ctx, "key", time.Hour)
+		require.NoError(t, err)
+		assert.Equal(t, i, c.Count)
+	}
+	resetAt := c.ResetAt
+	assert.WithinDuration(t, clock.Now().Add(time.Hour), resetAt, time.Minute)
+
+	c, err = db.GetRateLimitCounter(ctx, "key", time.Hour)
+	require.NoError(t, err)
+	assert.Equal(t, 3, c.Count)
+	assert.True(t, resetAt.Equal(c.ResetAt))
+
+	// Other keys are independent.
+	c, err = db.IncrementRateLimitCounter(ctx, "other", time.Hour)
+	require.NoError(t, err)
+	assert.Equal(t, 1, c.Count)
+
+	// Expired windows start again.
+	require.NoError(t, db.save(ctx, "expired", &dbRateLimitCounter{
+		Key:         "expired",
+		Count:       10,
+		WindowStart: clock.Now().Add(-2 * time.Hour),
+		ResetAt:     clock.Now().Add(-time.Hour),
+	}, nil, "rateLimitCounter", rateLimitTable))
+	c, err = db.GetRateLimitCounter(ctx, "expired", time.Hour)
+	require.NoError(t, err)
+	assert.Equal(t, 0, c.Count)
+	c, err = db.IncrementRateLimitCounter(ctx, "expired", time.Hour)
+	require.NoError(t, err)
+	assert.Equal(t, 1, c.Count)
+	assert.True(t, c.ResetAt.After(clock.Now()))
+}
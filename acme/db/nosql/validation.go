@@ -0,0 +1,133 @@
+package nosql
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/smallstep/certificates/acme"
+	"github.com/smallstep/nosql"
+)
+
+type dbValidationJob struct {
+	ChallengeID     string    `json:"challengeID"`
+	AuthorizationID string    `json:"authorizationID"`
+	AccountID       string    `json:"accountID"`
+	ProvisionerName string    `json:"provisionerName"`
+	BaseURL         string    `json:"baseURL,omitempty"`
+	Attempts        int       `json:"attempts"`
+	CreatedAt       time.Time `json:"createdAt"`
+	Deadline        time.Time `json:"deadline"`
+	NextAttemptAt   time.Time `json:"nextAttemptAt"`
+	LockedBy        string    `json:"lockedBy,omitempty"`
+	LockedUntil     time.Time `json:"lockedUntil"`
+}
+
+func newDBValidationJob(job *acme.ValidationJob) *dbValidationJob {
+	return &dbValidationJob{
+		ChallengeID:     job.ChallengeID,
+		AuthorizationID: job.AuthorizationID,
+		AccountID:       job.AccountID,
+		ProvisionerName: job.ProvisionerName,
+		BaseURL:         job.BaseURL,
+		Attempts:        job.Attempts,
+		CreatedAt:       job.CreatedAt,
+		Deadline:        job.Deadline,
+		NextAttemptAt:   job.NextAttemptAt,
+		LockedBy:        job.LockedBy,
+		LockedUntil:     job.LockedUntil,
+	}
+}
+
+func (j *dbValidationJob) toACME() *acme.ValidationJob {
+	return &acme.ValidationJob{
+		ChallengeID:     j.ChallengeID,
+		AuthorizationID: j.AuthorizationID,
+		AccountID:       j.AccountID,
+		ProvisionerName: j.ProvisionerName,
+		BaseURL:         j.BaseURL,
+		Attempts:        j.Attempts,
+		CreatedAt:       j.CreatedAt,
+		Deadline:        j.Deadline,
+		NextAttemptAt:   j.NextAttemptAt,
+		LockedBy:        j.LockedBy,
+		LockedUntil:     j.LockedUntil,
+	}
+}
+
+// SaveValidationJob creates or updates the given validation job.
+func (db *DB) SaveValidationJob(_ context.Context, job *acme.ValidationJob) error {
+	b, err := json.Marshal(newDBValidationJob(job))
+	if err != nil {
+		return errors.Wrapf(err, "error marshaling validation job %s", job.ChallengeID)
+	}
+	if err := db.db.Set(validationJobTable, []byte(job.ChallengeID), b); err != nil {
+		return errors.Wrapf(err, "error saving validation job %s", job.ChallengeID)
+	}
+	return nil
+}
+
+// GetValidationJobs returns all the stored validation jobs.
+func (db *DB) GetValidationJobs(context.Context) ([]*acme.ValidationJob, error) {
+	entries, err := db.db.List(validationJobTable)
+	if err != nil {
+		return nil, errors.Wrap(err, "error listing validation jobs")
+	}
+
+	jobs := make([]*acme.ValidationJob, 0, len(entries))
+	for _, entry := range entries {
+		j := new(dbValidationJob)
+		if err := json.Unmarshal(entry.Value, j); err != nil {
+			return nil, errors.Wrapf(err, "error unmarshaling validation job %s", entry.Key)
+		}
+		jobs = append(jobs, j.toACME())
+	}
+	return jobs, nil
+}
+
+// LockValidationJob locks the validation job of the given challenge for the
+// given owner until the given time. The lock is acquired using
+// compare-and-swap so only one instance of the CA processes a job at a time.
+// It returns acme.ErrValidationJobLocked if the job is locked by another
+// owner.
+func (db *DB) LockValidationJob(_ context.Context, challengeID, owner string, until time.Time) (*acme.ValidationJob, error) {
+	old, err := db.db.Get(validationJobTable, []byte(challengeID))
+	switch {
+	case nosql.IsErrNotFound(err):
+		return nil, acme.NewError(acme.ErrorMalformedType, "validation job %s not found", challengeID)
+	case err != nil:
+		return nil, errors.Wrapf(err, "error loading validation job %s", challengeID)
+	}
+
+	j := new(dbValidationJob)
+	if err := json.Unmarshal(old, j); err != nil {
+		return nil, errors.Wrapf(err, "error unmarshaling validation job %s", challengeID)
+	}
+	if j.LockedBy != "" && j.LockedBy != owner && j.LockedUntil.After(clock.Now()) {
+		return nil, acme.ErrValidationJobLocked
+	}
+
+	j.LockedBy = owner
+	j.LockedUntil = until
+	nu, err := json.Marshal(j)
+	if err != nil {
+		return nil, errors.Wrapf(err, "error marshaling validation job %s", challengeID)
+	}
+	_, swapped, err := db.db.CmpAndSwap(validationJobTable, []byte(challengeID), old, nu)
+	switch {
+	case err != nil:
+		return nil, errors.Wrapf(err, "error saving validation job %s", challengeID)
+	case !swapped:
+		return nil, acme.ErrValidationJobLocked
+	}
+	return j.toACME(), nil
+}
+
+// DeleteValidationJob deletes the validation job of the given challenge.
+func (db *DB) DeleteValidationJob(_ context.Context, challengeID string) error {
+	if err := db.db.Del(validationJobTable, []byte(challengeID)); err != nil && !nosql.IsErrNotFound(err) {
+		return errors.Wrapf(err, "error deleting validation job %s", challengeID)
+	}
+	return nil
+}
@@ -0,0 +1,77 @@
+package nosql
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/smallstep/certificates/acme"
+	"github.com/smallstep/nosql"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDB_ValidationJobs(t *testing.T) {
+	ctx := context.Background()
+	ndb, err := nosql.New("badgerv2", t.TempDir())
+	require.NoError(t, err)
+	db, err := New(ndb)
+	require.NoError(t, err)
+
+	now := clock.Now().Truncate(time.Second)
+	job := &acme.ValidationJob{
+		ChallengeID:     "chID",
+		AuthorizationID: "azID",
+		AccountID:       "accID",
+		ProvisionerName: "acme",
+		BaseURL:         "https://ca.example.com",
+		CreatedAt:       now,
+		Deadline:        now.Add(10 * time.Minute),
+		NextAttemptAt:   now,
+	}
+	require.NoError(t, db.SaveValidationJob(ctx, job))
+
+	jobs, err := db.GetValidationJobs(ctx)
+	require.NoError(t, err)
+	require.Len(t, jobs, 1)
+	assert.Equal(t, "chID", jobs[0].ChallengeID)
+	assert.Equal(t, "https://ca.example.com", jobs[0].BaseURL)
+	assert.True(t, now.Add(10*time.Minute).Equal(jobs[0].Deadline))
+
+	// Lock the job.
+	locked, err := db.LockValidationJob(ctx, "chID", "owner1", now.Add(time.Minute))
+	require.NoError(t, err)
+	assert.Equal(t, "owner1", locked.LockedBy)
+
+	// Other owners cannot lock it until the lock expires.
+	_, err = db.LockValidationJob(ctx, "chID", "owner2", now.Add(time.Minute))
+	assert.ErrorIs(t, err, acme.ErrValidationJobLocked)
+
+	// Unlock the job.
+	locked.LockedBy = ""
+	locked.LockedUntil = time.Time{}
+	locked.Attempts = 1
+	require.NoError(t, db.SaveValidationJob(ctx, locked))
+	locked, err = db.LockValidationJob(ctx, "chID", "owner2", now.Add(time.Minute))
+	require.NoError(t, err)
+	assert.Equal(t, "owner2", locked.LockedBy)
+	assert.Equal(t, 1, locked.Attempts)
+
+	// Expired locks can be taken.
+	locked.LockedUntil = now.Add(-time.Second)
+	require.NoError(t, db.SaveValidationJob(ctx, locked))
+	locked, err = db.LockValidationJob(ctx, "chID", "owner1", now.Add(time.Minute))
+	require.NoError(t, err)
+	assert.Equal(t, "owner1", locked.LockedBy)
+
+	// Delete the job.
+	require.NoError(t, db.DeleteValidationJob(ctx, "chID"))
+	require.NoError(t, db.DeleteValidationJob(ctx, "chID"))
+	jobs, err = db.GetValidationJobs(ctx)
+	require.NoError(t, err)
+	assert.Empty(t, jobs)
+
+	_, err = db.LockValidationJob(ctx, "chID", "owner1", now.Add(time.Minute))
+	var acmeErr *acme.Error
+	assert.ErrorAs(t, err, &acmeErr)
+}
@@ -0,0 +1,186 @@
+package acme
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"net"
+	"os"
+	"strings"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// typeCAA is the DNS resource record type of CAA records.
+const typeCAA = dnsmessage.Type(257)
+
+// dnsTimeout is the timeout of a DNS exchange.
+const dnsTimeout = 10 * time.Second
+
+// systemNameservers returns the addresses of the nameservers configured in
+// /etc/resolv.conf. It defaults to a local resolver if the file cannot be
+// read.
+func systemNameservers() []string {
+	var servers []string
+	if f, err := os.Open("/etc/resolv.conf"); err == nil {
+		defer f.Close()
+		scanner := bufio.NewScanner(f)
+		for scanner.Scan() {
+			fields := strings.Fields(scanner.Text())
+			if len(fields) >= 2 && fields[0] == "nameserver" {
+				servers = append(servers, net.JoinHostPort(fields[1], "53"))
+			}
+		}
+	}
+	if len(servers) == 0 {
+		servers = []string{"127.0.0.1:53"}
+	}
+	return servers
+}
+
+// lookupCAA sends a recursive CAA query for the given name to a DNS server
+// and returns the CAA records in the answer. An empty list is returned if the
+// name does not exist.
+func lookupCAA(ctx context.Context, dialer *net.Dialer, server, name string) ([]*CAARecord, error) {
+	qname, err := dnsmessage.NewName(strings.TrimSuffix(name, ".") + ".")
+	if err != nil {
+		return nil, fmt.Errorf("invalid domain name %q: %w", name, err)
+	}
+	id := uint16(rand.Uint32())
+	query, err := (&dnsmessage.Message{
+		Header: dnsmessage.Header{ID: id, RecursionDesired: true},
+		Questions: []dnsmessage.Question{{
+			Name:  qname,
+			Type:  typeCAA,
+			Class: dnsmessage.ClassINET,
+		}},
+	}).Pack()
+	if err != nil {
+		return nil, fmt.Errorf("error packing DNS query: %w", err)
+	}
+
+	msg, err := dnsExchange(ctx, dialer, "udp", server, query)
+	if err == nil && msg.Truncated {
+		msg, err = dnsExchange(ctx, dialer, "tcp", server, query)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if msg.ID != id {
+		return nil, fmt.Errorf("unexpected DNS response id %d from %s", msg.ID, server)
+	}
+	switch msg.RCode {
+	case dnsmessage.RCodeSuccess:
+	case dnsmessage.RCodeNameError:
+		return nil, nil
+	default:
+		return nil, fmt.Errorf("error looking up CAA records for %s: %s", name, msg.RCode)
+	}
+
+	var records []*CAARecord
+	for _, a := range msg.Answers {
+		if a.Header.Type != typeCAA {
+			continue
+		}
+		body, ok := a.Body.(*dnsmessage.UnknownResource)
+		if !ok {
+			continue
+		}
+		r, err := parseCAARecord(body.Data)
+		if err != nil {
+			return nil, err
+		}
+		records = append(records, r)
+	}
+	return records, nil
+}
+
+// dnsExchange sends a DNS query using the given network and returns the
+// response. The exchange is aborted when the context is done.
+func dnsExchange(ctx context.Context, dialer *net.Dialer, network, server string, query []byte) (*dnsmessage.Message, error) {
+	ctx, cancel := context.WithTimeout(ctx, dnsTimeout)
+	defer cancel()
+
+	var (
+		conn net.Conn
+		err  error
+	)
+	// Use the resolver configured with the --resolver flag, if any, like the
+	// TXT lookups do.
+	if dial := net.DefaultResolver.Dial; dial != nil {
+		conn, err = dial(ctx, network, server)
+	} else {
+		conn, err = dialer.DialContext(ctx, network, server)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error connecting to DNS server %s: %w", server, err)
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		if err := conn.SetDeadline(deadline); err != nil {
+			return nil, err
+		}
+	}
+	// Unblock reads and writes if the context is canceled before the
+	// deadline.
+	stop := context.AfterFunc(ctx, func() {
+		conn.SetDeadline(time.Now())
+	})
+	defer stop()
+
+	var b []byte
+	if network == "tcp" {
+		// TCP messages are prefixed with the two byte length.
+		if _, err := conn.Write(binary.BigEndian.AppendUint16(nil, uint16(len(query)))); err != nil {
+			return nil, fmt.Errorf("error sending DNS query to %s: %w", server, err)
+		}
+		if _, err := conn.Write(query); err != nil {
+			return nil, fmt.Errorf("error sending DNS query to %s: %w", server, err)
+		}
+		var size [2]byte
+		if _, err := io.ReadFull(conn, size[:]); err != nil {
+			return nil, fmt.Errorf("error reading DNS response from %s: %w", server, err)
+		}
+		b = make([]byte, binary.BigEndian.Uint16(size[:]))
+		if _, err := io.ReadFull(conn, b); err != nil {
+			return nil, fmt.Errorf("error reading DNS response from %s: %w", server, err)
+		}
+	} else {
+		if _, err := conn.Write(query); err != nil {
+			return nil, fmt.Errorf("error sending DNS query to %s: %w", server, err)
+		}
+		b = make([]byte, 65535)
+		n, err := conn.Read(b)
+		if err != nil {
+			return nil, fmt.Errorf("error reading DNS response from %s: %w", server, err)
+		}
+		b = b[:n]
+	}
+
+	msg := new(dnsmessage.Message)
+	if err := msg.Unpack(b); err != nil {
+		return nil, fmt.Errorf("error parsing DNS response from %s: %w", server, err)
+	}
+	return msg, nil
+}
+
+// parseCAARecord parses the RDATA of a CAA record, RFC 8659, section 4.1.
+func parseCAARecord(data []byte) (*CAARecord, error) {
+	if len(data) < 2 {
+		return nil, errors.New("invalid CAA record: too short")
+	}
+	tagLen := int(data[1])
+	if tagLen == 0 || len(data) < 2+tagLen {
+		return nil, errors.New("invalid CAA record: bad tag length")
+	}
+	return &CAARecord{
+		Flag:  data[0],
+		Tag:   string(data[2 : 2+tagLen]),
+		Value: string(data[2+tagLen:]),
+	}, nil
+}
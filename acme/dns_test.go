@@ -0,0 +1,117 @@
+package acme
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// startDNSStub starts a UDP DNS server that answers CAA queries with the
+// given records. Names not in the map return NXDOMAIN, and the name
+// "fail.example.com." returns SERVFAIL.
+func startDNSStub(t *testing.T, records map[string][]*CAARecord) string {
+	t.Helper()
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+
+	go func() {
+		b := make([]byte, 512)
+		for {
+			n, addr, err := conn.ReadFrom(b)
+			if err != nil {
+				return
+			}
+			var query dnsmessage.Message
+			if err := query.Unpack(b[:n]); err != nil || len(query.Questions) != 1 {
+				continue
+			}
+			q := query.Questions[0]
+			resp := dnsmessage.Message{
+				Header:    dnsmessage.Header{ID: query.ID, Response: true, RecursionAvailable: true},
+				Questions: query.Questions,
+			}
+			name := q.Name.String()
+			rrs, ok := records[name]
+			switch {
+			case name == "fail.example.com.":
+				resp.RCode = dnsmessage.RCodeServerFailure
+			case !ok:
+				resp.RCode = dnsmessage.RCodeNameError
+			}
+			for _, r := range rrs {
+				data := append([]byte{r.Flag, byte(len(r.Tag))}, r.Tag...)
+				resp.Answers = append(resp.Answers, dnsmessage.Resource{
+					Header: dnsmessage.ResourceHeader{Name: q.Name, Type: typeCAA, Class: dnsmessage.ClassINET, TTL: 60},
+					Body:   &dnsmessage.UnknownResource{Type: typeCAA, Data: append(data, r.Value...)},
+				})
+			}
+			out, err := resp.Pack()
+			if err != nil {
+				continue
+			}
+			_, _ = conn.WriteTo(out, addr)
+		}
+	}()
+
+	return conn.LocalAddr().String()
+}
+
+func TestClient_LookupCAA(t *testing.T) {
+	addr := startDNSStub(t, map[string][]*CAARecord{
+		"example.com.": {
+			{Flag: 0, Tag: "issue", Value: "ca.example.org; validationmethods=dns-01"},
+			{Flag: 128, Tag: "issuewild", Value: ";"},
+		},
+		"empty.example.com.": {},
+	})
+	c := &client{dialer: &net.Dialer{}, nameservers: []string{addr}}
+	ctx := context.Background()
+
+	records, err := c.LookupCAA(ctx, "example.com")
+	require.NoError(t, err)
+	assert.Equal(t, []*CAARecord{
+		{Flag: 0, Tag: "issue", Value: "ca.example.org; validationmethods=dns-01"},
+		{Flag: 128, Tag: "issuewild", Value: ";"},
+	}, records)
+
+	records, err = c.LookupCAA(ctx, "empty.example.com.")
+	require.NoError(t, err)
+	assert.Empty(t, records)
+
+	records, err = c.LookupCAA(ctx, "missing.example.com")
+	require.NoError(t, err)
+	assert.Empty(t, records)
+
+	_, err = c.LookupCAA(ctx, "fail.example.com")
+	assert.Error(t, err)
+
+	// The relevant set is found climbing the tree.
+	records, err = relevantCAASet(ctx, c, "www.missing.example.com")
+	require.NoError(t, err)
+	assert.Len(t, records, 2)
+
+	// The lookup is aborted with the validation context.
+	canceled, cancel := context.WithCancel(ctx)
+	cancel()
+	_, err = c.LookupCAA(canceled, "example.com")
+	assert.ErrorIs(t, err, context.Canceled)
+}
+
+func Test_parseCAARecord(t *testing.T) {
+	r, err := parseCAARecord([]byte("\x80\x05issueca.example.org"))
+	require.NoError(t, err)
+	assert.Equal(t, &CAARecord{Flag: 128, Tag: "issue", Value: "ca.example.org"}, r)
+	assert.True(t, r.IsCritical())
+
+	_, err = parseCAARecord([]byte{0})
+	assert.Error(t, err)
+	_, err = parseCAARecord([]byte("\x00\x00"))
+	assert.Error(t, err)
+	_, err = parseCAARecord([]byte("\x00\x09issue"))
+	assert.Error(t, err)
+}
@@ -0,0 +1,380 @@
+package acme
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/mail"
+	"strings"
+	"time"
+
+	"go.step.sm/crypto/jose"
+	"go.step.sm/crypto/randutil"
+
+	"github.com/smallstep/certificates/acme/email"
+)
+
+const (
+	emailReplySubjectPrefix = "ACME: "
+	emailReplyBeginMarker   = "-----BEGIN ACME RESPONSE-----"
+	emailReplyEndMarker     = "-----END ACME RESPONSE-----"
+)
+
+// EmailReply is a reply to an email-reply-00 challenge email received by the
+// CA. The DKIM signature of the reply has already been verified.
+type EmailReply struct {
+	From       string    `json:"from"`
+	Response   string    `json:"response"`
+	ReceivedAt time.Time `json:"receivedAt"`
+}
+
+// EmailDB is the interface used to store the replies to the email-reply-00
+// challenge emails. This is not a general purpose interface, and it should only
+// be used when the email-reply-00 challenge is configured in the CA. Currently
+// it provides a runtime assertion only; not at compile time.
+type EmailDB interface {
+	DB
+	// SaveEmailReply stores the reply to the challenge email with the given
+	// token-part1.
+	SaveEmailReply(ctx context.Context, tokenPart1 string, reply *EmailReply) error
+	// GetEmailReply returns the reply to the challenge email with the given
+	// token-part1, or nil if no reply has been received yet.
+	GetEmailReply(ctx context.Context, tokenPart1 string) (*EmailReply, error)
+}
+
+// EmailSender is the interface used to send the challenge emails.
+type EmailSender interface {
+	Send(from string, to []string, msg []byte) error
+}
+
+// EmailOptions are the options used to send the email-reply-00 challenge
+// emails.
+type EmailOptions struct {
+	// From is the address the challenge emails are sent from, the replies
+	// must be sent to this address.
+	From string
+	// Sender is the sender used to deliver the emails.
+	Sender EmailSender
+	// OnError, if set, is called when a challenge email cannot be sent.
+	OnError func(ch *Challenge, err error)
+}
+
+type emailOptionsKey struct{}
+
+// NewEmailOptionsContext adds the given email options to the context.
+func NewEmailOptionsContext(ctx context.Context, opts *EmailOptions) context.Context {
+	return context.WithValue(ctx, emailOptionsKey{}, opts)
+}
+
+// EmailOptionsFromContext returns the email options from the given context.
+func EmailOptionsFromContext(ctx context.Context) (opts *EmailOptions, ok bool) {
+	opts, ok = ctx.Value(emailOptionsKey{}).(*EmailOptions)
+	return
+}
+
+// CreateEmailReply00Challenge stores a new email-reply-00 challenge with the
+// token-part1 that is sent to the email address in the challenge value. The
+// token-part2 is the token of the challenge, and it's returned to the client
+// in the challenge object, RFC 8823 section 3. The challenge email is sent
+// with SendEmailReply00Challenges.
+func CreateEmailReply00Challenge(ctx context.Context, db DB, ch *Challenge) error {
+	opts, ok := EmailOptionsFromContext(ctx)
+	if !ok || opts.Sender == nil {
+		return NewErrorISE("email-reply-00 challenge is not configured")
+	}
+
+	var err error
+	if ch.TokenPart1, err = randutil.Alphanumeric(32); err != nil {
+		return WrapErrorISE(err, "error generating random alphanumeric ID")
+	}
+	ch.From = opts.From
+
+	if err := db.CreateChallenge(ctx, ch); err != nil {
+		return WrapErrorISE(err, "error creating challenge")
+	}
+	return nil
+}
+
+// SendEmailReply00Challenges sends in the background the challenge emails of
+// the email-reply-00 challenges in the given authorizations. It must be called
+// after the authorizations, and the order containing them, are stored. Errors
+// are reported to the OnError function of the email options.
+func SendEmailReply00Challenges(ctx context.Context, azs ...*Authorization) {
+	opts, ok := EmailOptionsFromContext(ctx)
+	if !ok || opts.Sender == nil {
+		return
+	}
+
+	var chs []*Challenge
+	for _, az := range azs {
+		for _, ch := range az.Challenges {
+			if ch.Type == EMAILREPLY00 {
+				chs = append(chs, ch)
+			}
+		}
+	}
+	if len(chs) == 0 {
+		return
+	}
+
+	go func() {
+		for _, ch := range chs {
+			if err := sendEmailReply00Challenge(opts, ch); err != nil && opts.OnError != nil {
+				opts.OnError(ch, err)
+			}
+		}
+	}()
+}
+
+// sendEmailReply00Challenge sends the challenge email of the given challenge.
+func sendEmailReply00Challenge(opts *EmailOptions, ch *Challenge) error {
+	msg, err := newEmailReply00Message(opts.From, ch.Value, ch.TokenPart1)
+	if err != nil {
+		return fmt.Errorf("error creating challenge email: %w", err)
+	}
+	if err := opts.Sender.Send(opts.From, []string{ch.Value}, msg); err != nil {
+		return fmt.Errorf("error sending challenge email to %s: %w", ch.Value, err)
+	}
+	return nil
+}
+
+// newEmailReply00Message returns the challenge email, RFC 8823 section 3.1.
+func newEmailReply00Message(from, to, tokenPart1 string) ([]byte, error) {
+	_, domain, ok := strings.Cut(from, "@")
+	if !ok {
+		return nil, fmt.Errorf("invalid email address %q", from)
+	}
+	id, err := randutil.Alphanumeric(32)
+	if err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "From: %s\r\n", from)
+	fmt.Fprintf(&buf, "To: %s\r\n", to)
+	fmt.Fprintf(&buf, "Subject: %s%s\r\n", emailReplySubjectPrefix, tokenPart1)
+	fmt.Fprintf(&buf, "Date: %s\r\n", clock.Now().Format(time.RFC1123Z))
+	fmt.Fprintf(&buf, "Message-ID: <%s@%s>\r\n", id, domain)
+	fmt.Fprintf(&buf, "MIME-Version: 1.0\r\n")
+	fmt.Fprintf(&buf, "Content-Type: text/plain; charset=us-ascii\r\n")
+	fmt.Fprintf(&buf, "Auto-Submitted: auto-generated; type=acme\r\n")
+	fmt.Fprintf(&buf, "\r\n")
+	fmt.Fprintf(&buf, "This is an automatically generated ACME challenge for the email address\r\n")
+	fmt.Fprintf(&buf, "%q. If you haven't requested an S/MIME certificate for this\r\n", to)
+	fmt.Fprintf(&buf, "email address, you can ignore this message. If you did request it, your\r\n")
+	fmt.Fprintf(&buf, "email client or your ACME client can process this request automatically.\r\n")
+	return buf.Bytes(), nil
+}
+
+// HandleEmailReply processes a message received by the CA with the reply to
+// an email-reply-00 challenge email, RFC 8823 section 3.2. The reply must
+// have a valid DKIM signature aligned with the domain of the From address,
+// its subject must contain the token-part1 and its body the ACME response.
+// The reply is stored and it's checked when the client asks the CA to
+// validate the challenge.
+func HandleEmailReply(ctx context.Context, db DB, data []byte) error {
+	emailDB, ok := db.(EmailDB)
+	if !ok {
+		return fmt.Errorf("db %T is not an EmailDB", db)
+	}
+
+	msg, err := mail.ReadMessage(bytes.NewReader(data))
+	if err != nil {
+		return fmt.Errorf("error parsing message: %w", err)
+	}
+	if len(msg.Header["From"]) != 1 {
+		return errors.New("message must have exactly one From header")
+	}
+	from, err := mail.ParseAddress(msg.Header.Get("From"))
+	if err != nil {
+		return fmt.Errorf("error parsing From header: %w", err)
+	}
+	tokenPart1, err := parseEmailReplySubject(msg.Header.Get("Subject"))
+	if err != nil {
+		return err
+	}
+	text, err := readEmailReplyText(msg.Header, msg.Body)
+	if err != nil {
+		return err
+	}
+	response, err := parseEmailReplyResponse(text)
+	if err != nil {
+		return err
+	}
+
+	// Verify that the domain of the sender has signed the reply.
+	domains, err := email.VerifyDKIM(data, MustClientFromContext(ctx).LookupTxt)
+	if err != nil {
+		return fmt.Errorf("error verifying DKIM signature: %w", err)
+	}
+	if !isDKIMAligned(from.Address, domains) {
+		return fmt.Errorf("message from %s does not have a valid DKIM signature", from.Address)
+	}
+
+	return emailDB.SaveEmailReply(ctx, tokenPart1, &EmailReply{
+		From:       from.Address,
+		Response:   response,
+		ReceivedAt: clock.Now(),
+	})
+}
+
+// isDKIMAligned returns true if one of the signing domains is the domain of
+// the given address or one of its parents.
+func isDKIMAligned(address string, domains []string) bool {
+	i := strings.LastIndex(address, "@")
+	if i < 0 {
+		return false
+	}
+	domain := strings.ToLower(strings.TrimSuffix(address[i+1:], "."))
+	for _, d := range domains {
+		d = strings.TrimSuffix(d, ".")
+		if domain == d || strings.HasSuffix(domain, "."+d) {
+			return true
+		}
+	}
+	return false
+}
+
+// parseEmailReplySubject returns the token-part1 in the subject of a reply,
+// the subject is the one in the challenge email with a reply prefix like
+// "Re: ".
+func parseEmailReplySubject(subject string) (string, error) {
+	if decoded, err := new(mime.WordDecoder).DecodeHeader(subject); err == nil {
+		subject = decoded
+	}
+	i := strings.LastIndex(subject, emailReplySubjectPrefix)
+	if i < 0 {
+		return "", errors.New("message subject does not contain an ACME challenge")
+	}
+	token := strings.TrimSpace(subject[i+len(emailReplySubjectPrefix):])
+	if token == "" || strings.ContainsAny(token, " \t") {
+		return "", errors.New("message subject does not contain a valid ACME challenge")
+	}
+	return token, nil
+}
+
+// parseEmailReplyResponse returns the ACME response between the begin and end
+// markers in the body of a reply.
+func parseEmailReplyResponse(text string) (string, error) {
+	_, rest, ok := strings.Cut(text, emailReplyBeginMarker)
+	if !ok {
+		return "", errors.New("message does not contain an ACME response")
+	}
+	response, _, ok := strings.Cut(rest, emailReplyEndMarker)
+	if !ok {
+		return "", errors.New("message does not contain an ACME response")
+	}
+	response = strings.Join(strings.Fields(response), "")
+	if _, err := base64.RawURLEncoding.DecodeString(response); err != nil || response == "" {
+		return "", errors.New("message does not contain a valid ACME response")
+	}
+	return response, nil
+}
+
+type mimeHeader interface {
+	Get(key string) string
+}
+
+// readEmailReplyText returns the decoded text of the reply. In multipart
+// messages the first text/plain part with an ACME response is used.
+func readEmailReplyText(h mimeHeader, body io.Reader) (string, error) {
+	mediaType, params, err := mime.ParseMediaType(h.Get("Content-Type"))
+	if err != nil {
+		mediaType = "text/plain"
+	}
+
+	switch {
+	case strings.HasPrefix(mediaType, "multipart/"):
+		mr := multipart.NewReader(body, params["boundary"])
+		for {
+			p, err := mr.NextRawPart()
+			if err != nil {
+				return "", errors.New("message does not contain an ACME response")
+			}
+			if text, err := readEmailReplyText(p.Header, p); err == nil && strings.Contains(text, emailReplyBeginMarker) {
+				return text, nil
+			}
+		}
+	case mediaType == "text/plain":
+		switch strings.ToLower(strings.TrimSpace(h.Get("Content-Transfer-Encoding"))) {
+		case "quoted-printable":
+			body = quotedprintable.NewReader(body)
+		case "base64":
+			body = base64.NewDecoder(base64.StdEncoding, &newlineStripper{r: body})
+		}
+		b, err := io.ReadAll(body)
+		if err != nil {
+			return "", fmt.Errorf("error reading message: %w", err)
+		}
+		return string(b), nil
+	default:
+		return "", fmt.Errorf("unsupported message content type %s", mediaType)
+	}
+}
+
+// newlineStripper removes the line breaks in base64 encoded bodies.
+type newlineStripper struct {
+	r io.Reader
+}
+
+func (s *newlineStripper) Read(p []byte) (int, error) {
+	n, err := s.r.Read(p)
+	j := 0
+	for _, b := range p[:n] {
+		if b != '\r' && b != '\n' {
+			p[j] = b
+			j++
+		}
+	}
+	return j, err
+}
+
+// emailReply00Validate validates an email-reply-00 challenge using the reply
+// received for the challenge email. If the reply has not been received yet
+// the challenge remains pending.
+func emailReply00Validate(ctx context.Context, ch *Challenge, db EmailDB, jwk *jose.JSONWebKey) error {
+	reply, err := db.GetEmailReply(ctx, ch.TokenPart1)
+	if err != nil {
+		return WrapErrorISE(err, "error retrieving email reply")
+	}
+	if reply == nil {
+		return storeError(ctx, db, ch, false, NewError(ErrorRejectedIdentifierType,
+			"the reply to the challenge email sent to %s has not been received", ch.Value))
+	}
+
+	if !strings.EqualFold(reply.From, ch.Value) {
+		return storeError(ctx, db, ch, true, NewError(ErrorRejectedIdentifierType,
+			"the reply to the challenge email was sent from %s, expected %s", reply.From, ch.Value))
+	}
+
+	// The key authorization is token-part1 || token-part2 || '.' ||
+	// base64url(JWK_Thumbprint(accountKey)).
+	keyAuth, err := KeyAuthorization(ch.TokenPart1+ch.Token, jwk)
+	if err != nil {
+		return err
+	}
+	sum := sha256.Sum256([]byte(keyAuth))
+	expected := base64.RawURLEncoding.EncodeToString(sum[:])
+	if subtle.ConstantTimeCompare([]byte(expected), []byte(reply.Response)) != 1 {
+		return storeError(ctx, db, ch, true, NewError(ErrorRejectedIdentifierType,
+			"email reply response does not match; expected %s, but got %s", expected, reply.Response))
+	}
+
+	// Update and store the challenge.
+	ch.Status = StatusValid
+	ch.Error = nil
+	ch.ValidatedAt = clock.Now().Format(time.RFC3339)
+
+	if err := db.UpdateChallenge(ctx, ch); err != nil {
+		return WrapErrorISE(err, "error updating challenge")
+	}
+	return nil
+}
@@ -0,0 +1,420 @@
+package email
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// maxDKIMSignatures is the maximum number of DKIM-Signature header fields
+// verified in a message.
+const maxDKIMSignatures = 5
+
+// DKIMLookupFunc is the function used to look up the TXT records with the
+// DKIM public keys.
+type DKIMLookupFunc func(name string) ([]string, error)
+
+// DKIMSigner signs messages using DKIM, RFC 6376. It uses the relaxed
+// canonicalization for both the header and the body.
+type DKIMSigner struct {
+	// Domain is the signing domain, the d= tag.
+	Domain string
+	// Selector is the selector of the public key, the s= tag.
+	Selector string
+	// Signer is the private key, it must be an RSA or an Ed25519 key.
+	Signer crypto.Signer
+	// Headers is the list of header fields to sign, it defaults to From, To,
+	// Subject, Date and Message-ID.
+	Headers []string
+}
+
+var defaultDKIMHeaders = []string{"From", "To", "Subject", "Date", "Message-ID"}
+
+// Sign returns the given message with a DKIM-Signature header field
+// prepended.
+func (s *DKIMSigner) Sign(msg []byte) ([]byte, error) {
+	var algorithm string
+	switch s.Signer.Public().(type) {
+	case *rsa.PublicKey:
+		algorithm = "rsa-sha256"
+	case ed25519.PublicKey:
+		algorithm = "ed25519-sha256"
+	default:
+		return nil, fmt.Errorf("unsupported DKIM key type %T", s.Signer.Public())
+	}
+
+	headers, body, err := splitMessage(msg)
+	if err != nil {
+		return nil, err
+	}
+
+	names := s.Headers
+	if len(names) == 0 {
+		names = defaultDKIMHeaders
+	}
+	bodyHash := sha256.Sum256(canonicalizeBody(body, true))
+	field := fmt.Sprintf("DKIM-Signature: v=1; a=%s; c=relaxed/relaxed; d=%s; s=%s;\r\n t=%d; h=%s;\r\n bh=%s;\r\n b=",
+		algorithm, s.Domain, s.Selector, time.Now().Unix(), strings.Join(names, ":"),
+		base64.StdEncoding.EncodeToString(bodyHash[:]))
+
+	h := sha256.New()
+	h.Write(canonicalizeSignedHeaders(headers, names, true))
+	h.Write([]byte(canonicalizeHeader(field, true)))
+	digest := h.Sum(nil)
+
+	var sig []byte
+	if algorithm == "ed25519-sha256" {
+		sig, err = s.Signer.Sign(nil, digest, crypto.Hash(0))
+	} else {
+		sig, err = s.Signer.Sign(nil, digest, crypto.SHA256)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error signing message: %w", err)
+	}
+
+	var buf bytes.Buffer
+	buf.WriteString(field)
+	buf.WriteString(base64.StdEncoding.EncodeToString(sig))
+	buf.WriteString("\r\n")
+	buf.Write(normalizeLineEndings(msg))
+	return buf.Bytes(), nil
+}
+
+// VerifyDKIM verifies the DKIM signatures of the given message and returns
+// the list of signing domains (the d= tags) with a valid signature. Invalid
+// signatures are ignored, and an empty list is returned if the message does
+// not contain a valid signature. Signatures with the body length limit, the
+// l= tag, are not considered valid, as they allow content to be appended to
+// the signed message.
+func VerifyDKIM(msg []byte, lookup DKIMLookupFunc) ([]string, error) {
+	headers, body, err := splitMessage(msg)
+	if err != nil {
+		return nil, err
+	}
+
+	var domains []string
+	var n int
+	for _, field := range headers {
+		if !strings.EqualFold(headerName(field), "DKIM-Signature") {
+			continue
+		}
+		if n++; n > maxDKIMSignatures {
+			break
+		}
+		if domain, err := verifyDKIMSignature(headers, body, field, lookup); err == nil {
+			domains = append(domains, domain)
+		}
+	}
+	return domains, nil
+}
+
+func verifyDKIMSignature(headers []string, body []byte, field string, lookup DKIMLookupFunc) (string, error) {
+	tags, err := parseTags(headerValue(field))
+	if err != nil {
+		return "", err
+	}
+
+	for _, t := range []string{"v", "a", "b", "bh", "d", "h", "s"} {
+		if _, ok := tags[t]; !ok {
+			return "", fmt.Errorf("DKIM signature is missing the %s= tag", t)
+		}
+	}
+	if tags["v"] != "1" {
+		return "", fmt.Errorf("unsupported DKIM version %q", tags["v"])
+	}
+	if _, ok := tags["l"]; ok {
+		return "", fmt.Errorf("DKIM signatures with body length limits are not supported")
+	}
+	if x, ok := tags["x"]; ok {
+		exp, err := strconv.ParseInt(x, 10, 64)
+		if err != nil {
+			return "", fmt.Errorf("invalid DKIM expiration %q", x)
+		}
+		if time.Now().Unix() > exp {
+			return "", fmt.Errorf("DKIM signature has expired")
+		}
+	}
+
+	var headerRelaxed, bodyRelaxed bool
+	switch c := tags["c"]; c {
+	case "", "simple", "simple/simple":
+	case "relaxed", "relaxed/simple":
+		headerRelaxed = true
+	case "simple/relaxed":
+		bodyRelaxed = true
+	case "relaxed/relaxed":
+		headerRelaxed, bodyRelaxed = true, true
+	default:
+		return "", fmt.Errorf("unsupported DKIM canonicalization %q", c)
+	}
+
+	names := strings.Split(tags["h"], ":")
+	var hasFrom bool
+	for i := range names {
+		names[i] = strings.TrimSpace(names[i])
+		hasFrom = hasFrom || strings.EqualFold(names[i], "From")
+	}
+	if !hasFrom {
+		return "", fmt.Errorf("DKIM signature does not sign the From header field")
+	}
+
+	bodyHash, err := base64.StdEncoding.DecodeString(removeWhitespace(tags["bh"]))
+	if err != nil {
+		return "", fmt.Errorf("invalid DKIM body hash: %w", err)
+	}
+	sum := sha256.Sum256(canonicalizeBody(body, bodyRelaxed))
+	if !bytes.Equal(sum[:], bodyHash) {
+		return "", fmt.Errorf("DKIM body hash does not match")
+	}
+
+	sig, err := base64.StdEncoding.DecodeString(removeWhitespace(tags["b"]))
+	if err != nil {
+		return "", fmt.Errorf("invalid DKIM signature: %w", err)
+	}
+
+	h := sha256.New()
+	h.Write(canonicalizeSignedHeaders(headers, names, headerRelaxed))
+	h.Write([]byte(canonicalizeHeader(strings.TrimSuffix(removeSignature(field), "\r\n"), headerRelaxed)))
+	digest := h.Sum(nil)
+
+	domain := strings.ToLower(tags["d"])
+	pub, err := lookupDKIMKey(lookup, tags["s"]+"._domainkey."+domain)
+	if err != nil {
+		return "", err
+	}
+
+	switch tags["a"] {
+	case "rsa-sha256":
+		key, ok := pub.(*rsa.PublicKey)
+		if !ok {
+			return "", fmt.Errorf("DKIM key is not an RSA key")
+		}
+		if key.N.BitLen() < 1024 {
+			return "", fmt.Errorf("DKIM RSA key is too short")
+		}
+		if err := rsa.VerifyPKCS1v15(key, crypto.SHA256, digest, sig); err != nil {
+			return "", fmt.Errorf("invalid DKIM signature: %w", err)
+		}
+	case "ed25519-sha256":
+		key, ok := pub.(ed25519.PublicKey)
+		if !ok {
+			return "", fmt.Errorf("DKIM key is not an Ed25519 key")
+		}
+		if !ed25519.Verify(key, digest, sig) {
+			return "", fmt.Errorf("invalid DKIM signature")
+		}
+	default:
+		return "", fmt.Errorf("unsupported DKIM algorithm %q", tags["a"])
+	}
+
+	return domain, nil
+}
+
+// lookupDKIMKey looks up and parses the DKIM public key record.
+func lookupDKIMKey(lookup DKIMLookupFunc, name string) (crypto.PublicKey, error) {
+	records, err := lookup(name)
+	if err != nil {
+		return nil, fmt.Errorf("error looking up DKIM key %s: %w", name, err)
+	}
+	for _, r := range records {
+		tags, err := parseTags(r)
+		if err != nil {
+			continue
+		}
+		if v, ok := tags["v"]; ok && v != "DKIM1" {
+			continue
+		}
+		p := removeWhitespace(tags["p"])
+		if p == "" {
+			return nil, fmt.Errorf("DKIM key %s has been revoked", name)
+		}
+		b, err := base64.StdEncoding.DecodeString(p)
+		if err != nil {
+			return nil, fmt.Errorf("invalid DKIM key %s: %w", name, err)
+		}
+		switch k := tags["k"]; k {
+		case "", "rsa":
+			if pub, err := x509.ParsePKIXPublicKey(b); err == nil {
+				return pub, nil
+			}
+			pub, err := x509.ParsePKCS1PublicKey(b)
+			if err != nil {
+				return nil, fmt.Errorf("invalid DKIM key %s: %w", name, err)
+			}
+			return pub, nil
+		case "ed25519":
+			if len(b) != ed25519.PublicKeySize {
+				return nil, fmt.Errorf("invalid DKIM key %s: bad Ed25519 key size", name)
+			}
+			return ed25519.PublicKey(b), nil
+		default:
+			return nil, fmt.Errorf("unsupported DKIM key type %q", k)
+		}
+	}
+	return nil, fmt.Errorf("DKIM key %s not found", name)
+}
+
+// splitMessage splits the message in the list of header fields, including
+// the continuation lines and the trailing CRLF, and the body.
+func splitMessage(msg []byte) ([]string, []byte, error) {
+	msg = normalizeLineEndings(msg)
+
+	var header, body []byte
+	if bytes.HasPrefix(msg, []byte("\r\n")) {
+		body = msg[2:]
+	} else if i := bytes.Index(msg, []byte("\r\n\r\n")); i >= 0 {
+		header, body = msg[:i+2], msg[i+4:]
+	} else {
+		header = msg
+	}
+
+	var fields []string
+	for _, line := range strings.SplitAfter(string(header), "\r\n") {
+		switch {
+		case line == "":
+		case line[0] == ' ' || line[0] == '\t':
+			if len(fields) == 0 {
+				return nil, nil, fmt.Errorf("malformed message header")
+			}
+			fields[len(fields)-1] += line
+		case strings.Contains(line, ":"):
+			fields = append(fields, line)
+		default:
+			return nil, nil, fmt.Errorf("malformed message header")
+		}
+	}
+	return fields, body, nil
+}
+
+// normalizeLineEndings converts bare LF line endings to CRLF.
+func normalizeLineEndings(msg []byte) []byte {
+	if !bytes.Contains(msg, []byte("\n")) {
+		return msg
+	}
+	msg = bytes.ReplaceAll(msg, []byte("\r\n"), []byte("\n"))
+	return bytes.ReplaceAll(msg, []byte("\n"), []byte("\r\n"))
+}
+
+func headerName(field string) string {
+	name, _, _ := strings.Cut(field, ":")
+	return strings.TrimSpace(name)
+}
+
+func headerValue(field string) string {
+	_, value, _ := strings.Cut(field, ":")
+	return value
+}
+
+// canonicalizeSignedHeaders returns the canonicalized header fields in the
+// given list of names. Header fields with the same name are used from the
+// bottom to the top of the header, and missing fields are ignored.
+func canonicalizeSignedHeaders(headers, names []string, relaxed bool) []byte {
+	var buf bytes.Buffer
+	used := make(map[string]int)
+	for _, name := range names {
+		key := strings.ToLower(name)
+		skip := used[key]
+		used[key]++
+		for i := len(headers) - 1; i >= 0; i-- {
+			if !strings.EqualFold(headerName(headers[i]), name) {
+				continue
+			}
+			if skip > 0 {
+				skip--
+				continue
+			}
+			buf.WriteString(canonicalizeHeader(headers[i], relaxed))
+			break
+		}
+	}
+	return buf.Bytes()
+}
+
+var wspRegexp = regexp.MustCompile(`[ \t]+`)
+
+// canonicalizeHeader canonicalizes a header field using the simple or the
+// relaxed algorithm defined in RFC 6376 section 3.4.
+func canonicalizeHeader(field string, relaxed bool) string {
+	if !relaxed {
+		return field
+	}
+	name, value, _ := strings.Cut(field, ":")
+	value = strings.ReplaceAll(value, "\r\n", "")
+	value = wspRegexp.ReplaceAllString(value, " ")
+	value = strings.TrimSpace(value)
+	if strings.HasSuffix(field, "\r\n") {
+		value += "\r\n"
+	}
+	return strings.ToLower(strings.TrimSpace(name)) + ":" + value
+}
+
+// canonicalizeBody canonicalizes the body using the simple or the relaxed
+// algorithm defined in RFC 6376 section 3.4.
+func canonicalizeBody(body []byte, relaxed bool) []byte {
+	lines := strings.Split(string(body), "\r\n")
+	if relaxed {
+		for i, line := range lines {
+			lines[i] = strings.TrimRight(wspRegexp.ReplaceAllString(line, " "), " ")
+		}
+	}
+	// Remove the empty lines at the end of the body.
+	for len(lines) > 0 && lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	if len(lines) == 0 {
+		if relaxed {
+			return nil
+		}
+		return []byte("\r\n")
+	}
+	return []byte(strings.Join(lines, "\r\n") + "\r\n")
+}
+
+var signatureRegexp = regexp.MustCompile(`((?:^|;)[ \t\r\n]*b[ \t\r\n]*=)[^;]*`)
+
+// removeSignature removes the value of the b= tag in the DKIM-Signature
+// header field.
+func removeSignature(field string) string {
+	name, value, _ := strings.Cut(field, ":")
+	return name + ":" + signatureRegexp.ReplaceAllString(value, "$1")
+}
+
+// parseTags parses a DKIM tag list, RFC 6376 section 3.2.
+func parseTags(s string) (map[string]string, error) {
+	tags := make(map[string]string)
+	for _, tag := range strings.Split(s, ";") {
+		if strings.TrimSpace(tag) == "" {
+			continue
+		}
+		name, value, ok := strings.Cut(tag, "=")
+		if !ok {
+			return nil, fmt.Errorf("malformed DKIM tag %q", strings.TrimSpace(tag))
+		}
+		name = strings.TrimSpace(name)
+		if _, ok := tags[name]; ok {
+			return nil, fmt.Errorf("duplicated DKIM tag %q", name)
+		}
+		tags[name] = strings.TrimSpace(value)
+	}
+	return tags, nil
+}
+
+func removeWhitespace(s string) string {
+	return strings.Map(func(r rune) rune {
+		switch r {
+		case ' ', '\t', '\r', '\n':
+			return -1
+		default:
+			return r
+		}
+	}, s)
+}
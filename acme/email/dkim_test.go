@@ -0,0 +1,174 @@
+package email
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testMessage = "From: Alice <alice@example.com>\r\n" +
+	"To: acme@ca.example.com\r\n" +
+	"Subject: Re: ACME: token\r\n" +
+	"Date: Fri, 16 Oct 2026 10:00:00 +0000\r\n" +
+	"Message-ID: <1234@example.com>\r\n" +
+	"\r\n" +
+	"-----BEGIN ACME RESPONSE-----\r\n" +
+	"response\r\n" +
+	"-----END ACME RESPONSE-----\r\n"
+
+func dkimRecord(t *testing.T, pub crypto.PublicKey) string {
+	t.Helper()
+	switch k := pub.(type) {
+	case ed25519.PublicKey:
+		return "v=DKIM1; k=ed25519; p=" + base64.StdEncoding.EncodeToString(k)
+	default:
+		b, err := x509.MarshalPKIXPublicKey(pub)
+		require.NoError(t, err)
+		return "v=DKIM1; k=rsa; p=" + base64.StdEncoding.EncodeToString(b)
+	}
+}
+
+func TestDKIM(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+
+	sign := func(t *testing.T, signer crypto.Signer, msg string) string {
+		t.Helper()
+		s := &DKIMSigner{Domain: "example.com", Selector: "sel", Signer: signer}
+		b, err := s.Sign([]byte(msg))
+		require.NoError(t, err)
+		return string(b)
+	}
+	lookup := func(pub crypto.PublicKey) DKIMLookupFunc {
+		return func(name string) ([]string, error) {
+			if name != "sel._domainkey.example.com" {
+				return nil, errors.New("not found")
+			}
+			return []string{dkimRecord(t, pub)}, nil
+		}
+	}
+
+	tests := []struct {
+		name   string
+		msg    string
+		lookup DKIMLookupFunc
+		want   []string
+	}{
+		{"ok/rsa", sign(t, rsaKey, testMessage), lookup(rsaKey.Public()), []string{"example.com"}},
+		{"ok/ed25519", sign(t, edKey, testMessage), lookup(edKey.Public()), []string{"example.com"}},
+		{"ok/lf", strings.ReplaceAll(sign(t, edKey, testMessage), "\r\n", "\n"), lookup(edKey.Public()), []string{"example.com"}},
+		{"ok/relaxed", strings.Replace(strings.Replace(sign(t, edKey, testMessage),
+			"Subject: Re:", "subject:   Re: ", 1), "response\r\n", "response \t \r\n", 1) + "\r\n\r\n", lookup(edKey.Public()), []string{"example.com"}},
+		{"ok/unsigned", testMessage, lookup(edKey.Public()), nil},
+		{"fail/body", strings.Replace(sign(t, edKey, testMessage), "response\r\n", "other\r\n", 1), lookup(edKey.Public()), nil},
+		{"fail/header", strings.Replace(sign(t, edKey, testMessage), "Alice <alice@", "Mallory <alice@", 1), lookup(edKey.Public()), nil},
+		{"fail/wrong-key", sign(t, edKey, testMessage), lookup(rsaKey.Public()), nil},
+		{"fail/lookup", sign(t, rsaKey, testMessage), func(string) ([]string, error) { return nil, errors.New("force") }, nil},
+		{"fail/revoked", sign(t, rsaKey, testMessage), func(string) ([]string, error) { return []string{"v=DKIM1; p="}, nil }, nil},
+		{"fail/length", strings.Replace(sign(t, edKey, testMessage), "v=1;", "v=1; l=10;", 1), lookup(edKey.Public()), nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := VerifyDKIM([]byte(tt.msg), tt.lookup)
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+
+	_, err = VerifyDKIM([]byte("not a header\r\n\r\nbody"), lookup(edKey.Public()))
+	assert.Error(t, err)
+}
+
+// TestVerifyDKIM_rfc8463 verifies the Ed25519 signed example in RFC 8463,
+// appendix A.
+func TestVerifyDKIM_rfc8463(t *testing.T) {
+	const msg = "DKIM-Signature: v=1; a=ed25519-sha256; c=relaxed/relaxed;\r\n" +
+		" d=football.example.com; i=@football.example.com;\r\n" +
+		" q=dns/txt; s=brisbane; t=1528637909; h=from : to :\r\n" +
+		" subject : date : message-id : from : subject : date;\r\n" +
+		" bh=2jUSOH9NhtVGCQWNr9BrIAPreKQjO6Sn7XIkfJVOzv8=;\r\n" +
+		" b=/gCrinpcQOoIfuHNQIbq4pgh9kyIK3AQUdt9OdqQehSwhEIug4D11Bus\r\n" +
+		" Fa3bT3FY5OsU7ZbnKELq+eXdp1Q1Dw==\r\n" +
+		"From: Joe SixPack <joe@football.example.com>\r\n" +
+		"To: Suzie Q <suzie@shopping.example.net>\r\n" +
+		"Subject: Is dinner ready?\r\n" +
+		"Date: Fri, 11 Jul 2003 21:00:37 -0700 (PDT)\r\n" +
+		"Message-ID: <20030712040037.46341.5F8J@football.example.com>\r\n" +
+		"\r\n" +
+		"Hi.\r\n" +
+		"\r\n" +
+		"We lost the game.  Are you hungry yet?\r\n" +
+		"\r\n" +
+		"Joe.\r\n"
+
+	lookup := func(name string) ([]string, error) {
+		if name != "brisbane._domainkey.football.example.com" {
+			return nil, errors.New("not found")
+		}
+		return []string{"v=DKIM1; k=ed25519; p=11qYAYKxCrfVS/7TyWQHOg7hcvPapiMlrwIaaPcHURo="}, nil
+	}
+
+	got, err := VerifyDKIM([]byte(msg), lookup)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"football.example.com"}, got)
+
+	// The oversigned Subject cannot be added.
+	got, err = VerifyDKIM([]byte(strings.Replace(msg, "From: Joe", "Subject: Dinner\r\nFrom: Joe", 1)), lookup)
+	require.NoError(t, err)
+	assert.Empty(t, got)
+
+	got, err = VerifyDKIM([]byte(strings.Replace(msg, "Joe.", "Mallory.", 1)), lookup)
+	require.NoError(t, err)
+	assert.Empty(t, got)
+}
+
+// Test_canonicalization_rfc6376 uses the canonicalization examples in RFC
+// 6376, section 3.4.5.
+func Test_canonicalization_rfc6376(t *testing.T) {
+	const msg = "A: X\r\n" +
+		"B : Y\t\r\n" +
+		"\tZ  \r\n" +
+		"\r\n" +
+		" C \r\n" +
+		"D \t E\r\n" +
+		"\r\n" +
+		"\r\n"
+
+	headers, body, err := splitMessage([]byte(msg))
+	require.NoError(t, err)
+	require.Len(t, headers, 2)
+
+	names := []string{"A", "B"}
+	assert.Equal(t, "a:X\r\nb:Y Z\r\n", string(canonicalizeSignedHeaders(headers, names, true)))
+	assert.Equal(t, " C\r\nD E\r\n", string(canonicalizeBody(body, true)))
+	assert.Equal(t, "A: X\r\nB : Y\t\r\n\tZ  \r\n", string(canonicalizeSignedHeaders(headers, names, false)))
+	assert.Equal(t, " C \r\nD \t E\r\n", string(canonicalizeBody(body, false)))
+}
+
+func Test_canonicalizeHeader(t *testing.T) {
+	assert.Equal(t, "subject:Re: ACME: token\r\n", canonicalizeHeader("Subject :  Re:\r\n\tACME: \t token  \r\n", true))
+	assert.Equal(t, "Subject : Re:\r\n", canonicalizeHeader("Subject : Re:\r\n", false))
+}
+
+func Test_canonicalizeBody(t *testing.T) {
+	assert.Equal(t, "\r\n", string(canonicalizeBody(nil, false)))
+	assert.Empty(t, canonicalizeBody(nil, true))
+	assert.Equal(t, " C \r\nD \t E\r\n", string(canonicalizeBody([]byte(" C \r\nD \t E\r\n\r\n\r\n"), false)))
+	assert.Equal(t, " C\r\nD E\r\n", string(canonicalizeBody([]byte(" C \r\nD \t E\r\n\r\n\r\n"), true)))
+}
+
+func Test_removeSignature(t *testing.T) {
+	field := "DKIM-Signature: v=1; bh=abc;\r\n b=c2ln\r\n bmF0dXJl; d=example.com\r\n"
+	assert.Equal(t, "DKIM-Signature: v=1; bh=abc;\r\n b=; d=example.com\r\n", removeSignature(field))
+}
@@ -0,0 +1,47 @@
+// Package email implements the mail transport used by the email-reply-00
+// ACME challenge, RFC 8823: an SMTP client to send the challenge emails
+// through a relay, a minimal SMTP server to receive the replies, and the DKIM
+// signing and verification of the messages.
+package email
+
+import (
+	"net"
+	"net/smtp"
+)
+
+// SMTPSender sends messages using an SMTP relay. The connection is upgraded
+// to TLS if the relay supports STARTTLS.
+type SMTPSender struct {
+	// Addr is the address of the relay in host:port format.
+	Addr string
+	// Auth is the optional authentication mechanism used with the relay.
+	Auth smtp.Auth
+	// DKIM is the optional signer used to sign the messages.
+	DKIM *DKIMSigner
+}
+
+// NewSMTPSender creates a new sender for the given relay. If a username is
+// given, the PLAIN authentication mechanism is used.
+func NewSMTPSender(addr, username, password string) (*SMTPSender, error) {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+	s := &SMTPSender{Addr: addr}
+	if username != "" {
+		s.Auth = smtp.PlainAuth("", username, password, host)
+	}
+	return s, nil
+}
+
+// Send sends the message to the given recipients.
+func (s *SMTPSender) Send(from string, to []string, msg []byte) error {
+	msg = normalizeLineEndings(msg)
+	if s.DKIM != nil {
+		var err error
+		if msg, err = s.DKIM.Sign(msg); err != nil {
+			return err
+		}
+	}
+	return smtp.SendMail(s.Addr, s.Auth, from, to, msg)
+}
@@ -0,0 +1,306 @@
+package email
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/textproto"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultMaxMessageBytes is the default maximum size of the messages
+	// accepted by the server.
+	DefaultMaxMessageBytes = 1 << 20
+	// DefaultTimeout is the default time to wait for the next command of a
+	// client.
+	DefaultTimeout = time.Minute
+
+	maxRecipients = 100
+)
+
+// HandlerFunc is the function called by the server with every message
+// received. If it returns an error the message is rejected.
+type HandlerFunc func(from string, to []string, data []byte) error
+
+// ErrServerClosed is returned by the Serve and ListenAndServe methods after a
+// call to Close.
+var ErrServerClosed = errors.New("email: server closed")
+
+// Server is a minimal SMTP server, RFC 5321, used to receive the replies to
+// the challenge emails. It does not support TLS nor authentication, and it
+// passes every message received to the handler.
+type Server struct {
+	// Addr is the TCP address the server listens on.
+	Addr string
+	// Hostname is the name used in the greeting of the server.
+	Hostname string
+	// Handler is the function called with every message received.
+	Handler HandlerFunc
+	// MaxMessageBytes is the maximum size of the messages. It defaults to
+	// 1MiB.
+	MaxMessageBytes int64
+	// Timeout is the time to wait for the next command of a client. It
+	// defaults to 1m.
+	Timeout time.Duration
+
+	mu       sync.Mutex
+	listener net.Listener
+	conns    map[net.Conn]struct{}
+	closed   bool
+	wg       sync.WaitGroup
+}
+
+// ListenAndServe listens on the server address and serves SMTP connections.
+func (s *Server) ListenAndServe() error {
+	l, err := net.Listen("tcp", s.Addr)
+	if err != nil {
+		return err
+	}
+	return s.Serve(l)
+}
+
+// Serve accepts and serves the SMTP connections in the given listener. It
+// always returns a non-nil error, after Close it returns ErrServerClosed.
+func (s *Server) Serve(l net.Listener) error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		l.Close()
+		return ErrServerClosed
+	}
+	s.listener = l
+	s.mu.Unlock()
+
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			s.mu.Lock()
+			closed := s.closed
+			s.mu.Unlock()
+			if closed {
+				return ErrServerClosed
+			}
+			var ne net.Error
+			if errors.As(err, &ne) && ne.Timeout() {
+				time.Sleep(100 * time.Millisecond)
+				continue
+			}
+			return err
+		}
+
+		if !s.trackConn(conn) {
+			conn.Close()
+			return ErrServerClosed
+		}
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			defer s.untrackConn(conn)
+			s.serveConn(conn)
+		}()
+	}
+}
+
+// Close stops the listener, closes all the active connections and waits for
+// their goroutines to finish.
+func (s *Server) Close() error {
+	s.mu.Lock()
+	s.closed = true
+	var err error
+	if s.listener != nil {
+		err = s.listener.Close()
+	}
+	for c := range s.conns {
+		c.Close()
+	}
+	s.mu.Unlock()
+	s.wg.Wait()
+	return err
+}
+
+func (s *Server) trackConn(c net.Conn) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return false
+	}
+	if s.conns == nil {
+		s.conns = make(map[net.Conn]struct{})
+	}
+	s.conns[c] = struct{}{}
+	return true
+}
+
+func (s *Server) untrackConn(c net.Conn) {
+	s.mu.Lock()
+	delete(s.conns, c)
+	s.mu.Unlock()
+	c.Close()
+}
+
+func (s *Server) hostname() string {
+	if s.Hostname != "" {
+		return s.Hostname
+	}
+	return "localhost"
+}
+
+func (s *Server) maxMessageBytes() int64 {
+	if s.MaxMessageBytes > 0 {
+		return s.MaxMessageBytes
+	}
+	return DefaultMaxMessageBytes
+}
+
+func (s *Server) timeout() time.Duration {
+	if s.Timeout > 0 {
+		return s.Timeout
+	}
+	return DefaultTimeout
+}
+
+// session is the state of an SMTP transaction.
+type session struct {
+	from string
+	to   []string
+	mail bool
+}
+
+func (s *session) reset() {
+	*s = session{}
+}
+
+func (s *Server) serveConn(conn net.Conn) {
+	tc := textproto.NewConn(conn)
+	reply := func(code int, msg string) bool {
+		return tc.PrintfLine("%d %s", code, msg) == nil
+	}
+
+	if !reply(220, s.hostname()+" ESMTP ready") {
+		return
+	}
+
+	var sess session
+	for {
+		conn.SetDeadline(time.Now().Add(s.timeout()))
+		line, err := tc.ReadLine()
+		if err != nil {
+			return
+		}
+		cmd, arg, _ := strings.Cut(line, " ")
+		arg = strings.TrimSpace(arg)
+
+		var ok bool
+		switch strings.ToUpper(cmd) {
+		case "HELO":
+			sess.reset()
+			ok = reply(250, s.hostname())
+		case "EHLO":
+			sess.reset()
+			ok = tc.PrintfLine("250-%s", s.hostname()) == nil &&
+				tc.PrintfLine("250-8BITMIME") == nil &&
+				tc.PrintfLine("250 SIZE %d", s.maxMessageBytes()) == nil
+		case "MAIL":
+			from, err := parsePath(arg, "FROM:")
+			switch {
+			case err != nil:
+				ok = reply(501, err.Error())
+			case sess.mail:
+				ok = reply(503, "Nested MAIL command")
+			default:
+				sess.reset()
+				sess.mail, sess.from = true, from
+				ok = reply(250, "OK")
+			}
+		case "RCPT":
+			to, err := parsePath(arg, "TO:")
+			switch {
+			case err != nil:
+				ok = reply(501, err.Error())
+			case !sess.mail:
+				ok = reply(503, "Need MAIL command")
+			case to == "":
+				ok = reply(501, "Empty recipient")
+			case len(sess.to) >= maxRecipients:
+				ok = reply(452, "Too many recipients")
+			default:
+				sess.to = append(sess.to, to)
+				ok = reply(250, "OK")
+			}
+		case "DATA":
+			if len(sess.to) == 0 {
+				ok = reply(503, "Need RCPT command")
+				break
+			}
+			if !reply(354, "End data with <CR><LF>.<CR><LF>") {
+				return
+			}
+			ok = s.readData(tc, &sess, reply)
+			sess.reset()
+		case "RSET":
+			sess.reset()
+			ok = reply(250, "OK")
+		case "NOOP":
+			ok = reply(250, "OK")
+		case "VRFY":
+			ok = reply(252, "Cannot VRFY user")
+		case "QUIT":
+			reply(221, "Bye")
+			return
+		default:
+			ok = reply(502, "Command not implemented")
+		}
+		if !ok {
+			return
+		}
+	}
+}
+
+func (s *Server) readData(tc *textproto.Conn, sess *session, reply func(int, string) bool) bool {
+	limit := s.maxMessageBytes()
+	r := tc.DotReader()
+	data, err := io.ReadAll(io.LimitReader(r, limit+1))
+	if err != nil {
+		return false
+	}
+	if int64(len(data)) > limit {
+		// Consume the rest of the message before replying.
+		if _, err := io.Copy(io.Discard, r); err != nil {
+			return false
+		}
+		return reply(552, "Message exceeds the maximum size")
+	}
+
+	if s.Handler == nil {
+		return reply(554, "Transaction failed")
+	}
+	// The dot reader converts the line endings to LF, but the handler
+	// expects the message as it was sent.
+	if err := s.Handler(sess.from, sess.to, normalizeLineEndings(data)); err != nil {
+		log.Printf("email: message from %s rejected: %v", sess.from, err)
+		return reply(550, "Message rejected")
+	}
+	return reply(250, "OK")
+}
+
+// parsePath parses the reverse-path or forward-path argument of the MAIL and
+// RCPT commands, ignoring the ESMTP parameters.
+func parsePath(arg, prefix string) (string, error) {
+	if len(arg) < len(prefix) || !strings.EqualFold(arg[:len(prefix)], prefix) {
+		return "", fmt.Errorf("syntax error, expected %s<address>", prefix)
+	}
+	arg = strings.TrimSpace(arg[len(prefix):])
+	if !strings.HasPrefix(arg, "<") {
+		return "", fmt.Errorf("syntax error, expected %s<address>", prefix)
+	}
+	i := strings.Index(arg, ">")
+	if i < 0 {
+		return "", fmt.Errorf("syntax error, expected %s<address>", prefix)
+	}
+	return arg[1:i], nil
+}
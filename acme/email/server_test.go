@@ -0,0 +1,107 @@
+package email
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type received struct {
+	from string
+	to   []string
+	data string
+}
+
+func startServer(t *testing.T, s *Server) string {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	done := make(chan error, 1)
+	go func() {
+		done <- s.Serve(l)
+	}()
+	t.Cleanup(func() {
+		s.Close()
+		assert.ErrorIs(t, <-done, ErrServerClosed)
+	})
+	return l.Addr().String()
+}
+
+func TestServer(t *testing.T) {
+	var mu sync.Mutex
+	var messages []received
+	s := &Server{
+		Hostname: "mx.example.com",
+		Handler: func(from string, to []string, data []byte) error {
+			if strings.Contains(string(data), "reject") {
+				return errors.New("rejected")
+			}
+			mu.Lock()
+			messages = append(messages, received{from, to, string(data)})
+			mu.Unlock()
+			return nil
+		},
+		MaxMessageBytes: 1024,
+	}
+	addr := startServer(t, s)
+
+	sender, err := NewSMTPSender(addr, "", "")
+	require.NoError(t, err)
+
+	msg := "From: alice@example.com\nTo: acme@ca.example.com\nSubject: test\n\n.leading dot\nbody\n"
+	require.NoError(t, sender.Send("alice@example.com", []string{"acme@ca.example.com"}, []byte(msg)))
+	assert.Error(t, sender.Send("alice@example.com", []string{"acme@ca.example.com"}, []byte("Subject: reject\r\n\r\nbody\r\n")))
+	assert.Error(t, sender.Send("alice@example.com", []string{"acme@ca.example.com"}, []byte("Subject: big\r\n\r\n"+strings.Repeat("a", 2048)+"\r\n")))
+
+	mu.Lock()
+	defer mu.Unlock()
+	require.Len(t, messages, 1)
+	assert.Equal(t, "alice@example.com", messages[0].from)
+	assert.Equal(t, []string{"acme@ca.example.com"}, messages[0].to)
+	assert.Equal(t, strings.ReplaceAll(msg, "\n", "\r\n"), messages[0].data)
+}
+
+func TestServer_commands(t *testing.T) {
+	addr := startServer(t, &Server{
+		Handler: func(string, []string, []byte) error { return nil },
+	})
+
+	conn, err := net.Dial("tcp", addr)
+	require.NoError(t, err)
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	expect := func(code string) {
+		t.Helper()
+		line, err := r.ReadString('\n')
+		require.NoError(t, err)
+		assert.True(t, strings.HasPrefix(line, code+" "), "expected %s, got %q", code, line)
+	}
+	send := func(cmd, code string) {
+		t.Helper()
+		_, err := fmt.Fprintf(conn, "%s\r\n", cmd)
+		require.NoError(t, err)
+		expect(code)
+	}
+
+	expect("220")
+	send("HELO client.example.com", "250")
+	send("RCPT TO:<acme@ca.example.com>", "503")
+	send("DATA", "503")
+	send("MAIL alice@example.com", "501")
+	send("MAIL FROM:<alice@example.com> SIZE=100", "250")
+	send("MAIL FROM:<alice@example.com>", "503")
+	send("RCPT TO:<>", "501")
+	send("RCPT TO:<acme@ca.example.com>", "250")
+	send("RSET", "250")
+	send("DATA", "503")
+	send("NOOP", "250")
+	send("STARTTLS", "502")
+	send("QUIT", "221")
+}
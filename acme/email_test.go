@@ -0,0 +1,288 @@
+package acme
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.step.sm/crypto/jose"
+
+	"github.com/smallstep/certificates/acme/email"
+)
+
+type recordingSender struct {
+	from string
+	to   []string
+	msg  string
+	err  error
+	sent chan struct{}
+}
+
+func (s *recordingSender) Send(from string, to []string, msg []byte) error {
+	s.from, s.to, s.msg = from, to, string(msg)
+	err := s.err
+	if s.sent != nil {
+		s.sent <- struct{}{}
+	}
+	return err
+}
+
+// newEmailReplyDB returns an EmailDB that keeps the replies and the
+// challenges in memory.
+func newEmailReplyDB() (*MockEmailDB, map[string]*EmailReply, map[string]*Challenge) {
+	replies := make(map[string]*EmailReply)
+	challenges := make(map[string]*Challenge)
+	db := &MockEmailDB{
+		MockDB: MockDB{
+			MockCreateChallenge: func(_ context.Context, ch *Challenge) error {
+				ch.ID = "chID"
+				challenges[ch.ID] = ch
+				return nil
+			},
+			MockUpdateChallenge: func(_ context.Context, ch *Challenge) error {
+				challenges[ch.ID] = ch
+				return nil
+			},
+		},
+		MockSaveEmailReply: func(_ context.Context, tokenPart1 string, reply *EmailReply) error {
+			replies[tokenPart1] = reply
+			return nil
+		},
+		MockGetEmailReply: func(_ context.Context, tokenPart1 string) (*EmailReply, error) {
+			return replies[tokenPart1], nil
+		},
+	}
+	return db, replies, challenges
+}
+
+func emailReplyResponse(t *testing.T, tokenPart1, tokenPart2 string, jwk *jose.JSONWebKey) string {
+	t.Helper()
+	keyAuth, err := KeyAuthorization(tokenPart1+tokenPart2, jwk)
+	require.NoError(t, err)
+	sum := sha256.Sum256([]byte(keyAuth))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+func TestCreateEmailReply00Challenge(t *testing.T) {
+	db, _, challenges := newEmailReplyDB()
+	sender := &recordingSender{}
+	ctx := NewEmailOptionsContext(context.Background(), &EmailOptions{
+		From:   "acme@ca.example.com",
+		Sender: sender,
+	})
+
+	ch := &Challenge{
+		Type:   EMAILREPLY00,
+		Value:  "alice@example.com",
+		Token:  "tokenPart2",
+		Status: StatusPending,
+	}
+	require.NoError(t, CreateEmailReply00Challenge(ctx, db, ch))
+	assert.Len(t, ch.TokenPart1, 32)
+	assert.Equal(t, "acme@ca.example.com", ch.From)
+	assert.Equal(t, ch, challenges["chID"])
+
+	// The email is not sent until the authorization is stored.
+	assert.Empty(t, sender.to)
+
+	// The token-part2 and the from address are in the challenge object.
+	b, err := ch.ToLog()
+	require.NoError(t, err)
+	assert.Contains(t, b, `"token":"tokenPart2"`)
+	assert.Contains(t, b, `"from":"acme@ca.example.com"`)
+	assert.NotContains(t, b, ch.TokenPart1)
+
+	// Errors.
+	db.MockCreateChallenge = func(context.Context, *Challenge) error {
+		return errors.New("force")
+	}
+	assert.Error(t, CreateEmailReply00Challenge(ctx, db, &Challenge{Type: EMAILREPLY00, Value: "alice@example.com"}))
+	assert.Error(t, CreateEmailReply00Challenge(context.Background(), db, &Challenge{Type: EMAILREPLY00, Value: "alice@example.com"}))
+}
+
+func TestSendEmailReply00Challenges(t *testing.T) {
+	sender := &recordingSender{sent: make(chan struct{}, 1)}
+	errs := make(chan error, 1)
+	ctx := NewEmailOptionsContext(context.Background(), &EmailOptions{
+		From:   "acme@ca.example.com",
+		Sender: sender,
+		OnError: func(ch *Challenge, err error) {
+			assert.Equal(t, "alice@example.com", ch.Value)
+			errs <- err
+		},
+	})
+
+	ch := &Challenge{
+		Type:       EMAILREPLY00,
+		Value:      "alice@example.com",
+		Token:      "tokenPart2",
+		TokenPart1: "tokenPart1",
+		Status:     StatusPending,
+	}
+	az := &Authorization{
+		Challenges: []*Challenge{
+			{Type: HTTP01, Value: "example.com"},
+			ch,
+		},
+	}
+
+	SendEmailReply00Challenges(ctx, az)
+	select {
+	case <-sender.sent:
+	case <-time.After(5 * time.Second):
+		t.Fatal("challenge email was not sent")
+	}
+	assert.Equal(t, "acme@ca.example.com", sender.from)
+	assert.Equal(t, []string{"alice@example.com"}, sender.to)
+	assert.Contains(t, sender.msg, "\r\nSubject: ACME: tokenPart1\r\n")
+	assert.Contains(t, sender.msg, "\r\nAuto-Submitted: auto-generated; type=acme\r\n")
+	assert.NotContains(t, sender.msg, ch.Token)
+
+	// Errors are reported to OnError.
+	sender.err = errors.New("force")
+	SendEmailReply00Challenges(ctx, az)
+	<-sender.sent
+	select {
+	case err := <-errs:
+		assert.EqualError(t, err, "error sending challenge email to alice@example.com: force")
+	case <-time.After(5 * time.Second):
+		t.Fatal("OnError was not called")
+	}
+}
+
+func TestHandleEmailReply(t *testing.T) {
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	ctx := NewClientContext(context.Background(), &mockClient{
+		lookupTxt: func(name string) ([]string, error) {
+			if name != "sel._domainkey.example.com" {
+				return nil, errors.New("not found")
+			}
+			pub := key.Public().(ed25519.PublicKey)
+			return []string{"v=DKIM1; k=ed25519; p=" + base64.StdEncoding.EncodeToString(pub)}, nil
+		},
+	})
+	sign := func(t *testing.T, domain, msg string) []byte {
+		t.Helper()
+		s := &email.DKIMSigner{Domain: domain, Selector: "sel", Signer: key}
+		b, err := s.Sign([]byte(msg))
+		require.NoError(t, err)
+		return b
+	}
+
+	reply := "From: Alice <alice@example.com>\r\n" +
+		"To: acme@ca.example.com\r\n" +
+		"Subject: Re: ACME: tokenPart1\r\n" +
+		"\r\n" +
+		"-----BEGIN ACME RESPONSE-----\r\n" +
+		"LoqXcYV8q5ONbJQxbmR7SCTNo3tiAXDfowyjxAjEuX0\r\n" +
+		"-----END ACME RESPONSE-----\r\n"
+	multipartReply := "From: alice@mail.example.com\r\n" +
+		"To: acme@ca.example.com\r\n" +
+		"Subject: =?UTF-8?Q?Re=3A_ACME=3A_tokenPart1?=\r\n" +
+		"MIME-Version: 1.0\r\n" +
+		"Content-Type: multipart/alternative; boundary=b1\r\n" +
+		"\r\n" +
+		"--b1\r\n" +
+		"Content-Type: text/plain; charset=utf-8\r\n" +
+		"Content-Transfer-Encoding: quoted-printable\r\n" +
+		"\r\n" +
+		"=2D----BEGIN ACME RESPONSE-----\r\n" +
+		"LoqXcYV8q5ONbJQxbmR7SCTNo3tiAXDfowyjxAjEuX0\r\n" +
+		"-----END ACME RESPONSE-----\r\n" +
+		"--b1\r\n" +
+		"Content-Type: text/html\r\n" +
+		"\r\n" +
+		"<p>html</p>\r\n" +
+		"--b1--\r\n"
+
+	tests := []struct {
+		name    string
+		db      DB
+		data    []byte
+		wantErr string
+	}{
+		{"ok", nil, sign(t, "example.com", reply), ""},
+		{"ok/multipart", nil, sign(t, "example.com", multipartReply), ""},
+		{"fail/db", &MockDB{}, sign(t, "example.com", reply), "is not an EmailDB"},
+		{"fail/unsigned", nil, []byte(reply), "does not have a valid DKIM signature"},
+		{"fail/unaligned", nil, sign(t, "example.com", strings.Replace(reply, "alice@example.com", "alice@other.com", 1)), "does not have a valid DKIM signature"},
+		{"fail/subject", nil, sign(t, "example.com", strings.Replace(reply, "ACME: tokenPart1", "Hello", 1)), "does not contain an ACME challenge"},
+		{"fail/response", nil, sign(t, "example.com", strings.Replace(reply, "-----END ACME RESPONSE-----", "", 1)), "does not contain an ACME response"},
+		{"fail/from", nil, sign(t, "example.com", strings.Replace(reply, "From: Alice <alice@example.com>", "From: alice", 1)), "error parsing From header"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, replies, _ := newEmailReplyDB()
+			var adb DB = db
+			if tt.db != nil {
+				adb = tt.db
+			}
+			err := HandleEmailReply(ctx, adb, tt.data)
+			if tt.wantErr != "" {
+				assert.ErrorContains(t, err, tt.wantErr)
+				assert.Empty(t, replies)
+				return
+			}
+			require.NoError(t, err)
+			require.Contains(t, replies, "tokenPart1")
+			assert.Equal(t, "LoqXcYV8q5ONbJQxbmR7SCTNo3tiAXDfowyjxAjEuX0", replies["tokenPart1"].Response)
+		})
+	}
+}
+
+func Test_emailReply00Validate(t *testing.T) {
+	jwk, err := jose.GenerateJWK("EC", "P-256", "ES256", "sig", "", 0)
+	require.NoError(t, err)
+	response := emailReplyResponse(t, "tokenPart1", "tokenPart2", jwk)
+
+	tests := []struct {
+		name       string
+		reply      *EmailReply
+		wantStatus Status
+		wantErr    bool
+	}{
+		{"ok", &EmailReply{From: "Alice@example.com", Response: response}, StatusValid, false},
+		{"pending", nil, StatusPending, true},
+		{"fail/from", &EmailReply{From: "bob@example.com", Response: response}, StatusInvalid, true},
+		{"fail/response", &EmailReply{From: "alice@example.com", Response: "foo"}, StatusInvalid, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, replies, challenges := newEmailReplyDB()
+			if tt.reply != nil {
+				replies["tokenPart1"] = tt.reply
+			}
+			ch := &Challenge{
+				ID:         "chID",
+				Type:       EMAILREPLY00,
+				Value:      "alice@example.com",
+				Status:     StatusPending,
+				Token:      "tokenPart2",
+				TokenPart1: "tokenPart1",
+			}
+			require.NoError(t, ch.Validate(context.Background(), db, jwk, nil))
+			require.Contains(t, challenges, "chID")
+			assert.Equal(t, tt.wantStatus, challenges["chID"].Status)
+			if tt.wantErr {
+				assert.NotNil(t, challenges["chID"].Error)
+				assert.Equal(t, "urn:ietf:params:acme:error:rejectedIdentifier", challenges["chID"].Error.Type)
+			} else {
+				assert.Nil(t, challenges["chID"].Error)
+				assert.NotEmpty(t, challenges["chID"].ValidatedAt)
+			}
+		})
+	}
+
+	// The DB must implement the EmailDB interface.
+	ch := &Challenge{Type: EMAILREPLY00, Status: StatusPending}
+	assert.Error(t, ch.Validate(context.Background(), &MockDB{}, jwk, nil))
+}
@@ -3,7 +3,10 @@ package acme
 import (
 	"encoding/json"
 	"fmt"
+	"math"
 	"net/http"
+	"strconv"
+	"time"
 
 	"github.com/pkg/errors"
 	"github.com/smallstep/certificates/api/render"
@@ -65,6 +68,18 @@ const (
 	ErrorUserActionRequiredType
 	// ErrorNotImplementedType operation is not implemented
 	ErrorNotImplementedType
+	// ErrorAlreadyReplacedType request specified a certificate to be replaced that has already been replaced
+	ErrorAlreadyReplacedType
+	// ErrorInvalidProfileType the request specified an unknown certificate profile
+	ErrorInvalidProfileType
+	// ErrorAutoRenewalCanceledType the short-term certificate is no longer available because the auto-renewal order has been canceled
+	ErrorAutoRenewalCanceledType
+	// ErrorAutoRenewalExpiredType the short-term certificate is no longer available because the auto-renewal order has expired
+	ErrorAutoRenewalExpiredType
+	// ErrorAutoRenewalCancellationInvalidType request attempted to cancel an auto-renewal order that is not in the valid state
+	ErrorAutoRenewalCancellationInvalidType
+	// ErrorAutoRenewalRevocationNotSupportedType request attempted to revoke a short-term certificate of an auto-renewal order
+	ErrorAutoRenewalRevocationNotSupportedType
 )
 
 // String returns the string representation of the acme problem type,
@@ -121,6 +136,18 @@ func (ap ProblemType) String() string {
 		return "userActionRequired"
 	case ErrorNotImplementedType:
 		return "notImplemented"
+	case ErrorAlreadyReplacedType:
+		return "alreadyReplaced"
+	case ErrorInvalidProfileType:
+		return "invalidProfile"
+	case ErrorAutoRenewalCanceledType:
+		return "autoRenewalCanceled"
+	case ErrorAutoRenewalExpiredType:
+		return "autoRenewalExpired"
+	case ErrorAutoRenewalCancellationInvalidType:
+		return "autoRenewalCancellationInvalid"
+	case ErrorAutoRenewalRevocationNotSupportedType:
+		return "autoRenewalRevocationNotSupported"
 	default:
 		return fmt.Sprintf("unsupported type ACME error type '%d'", int(ap))
 	}
@@ -151,6 +178,36 @@ var (
 			details: "Certificate already revoked",
 			status:  400,
 		},
+		ErrorAlreadyReplacedType: {
+			typ:     officialACMEPrefix + ErrorAlreadyReplacedType.String(),
+			details: "Certificate already replaced",
+			status:  409,
+		},
+		ErrorInvalidProfileType: {
+			typ:     officialACMEPrefix + ErrorInvalidProfileType.String(),
+			details: "The request specified an unsupported certificate profile",
+			status:  400,
+		},
+		ErrorAutoRenewalCanceledType: {
+			typ:     officialACMEPrefix + ErrorAutoRenewalCanceledType.String(),
+			details: "The auto-renewal order has been canceled",
+			status:  403,
+		},
+		ErrorAutoRenewalExpiredType: {
+			typ:     officialACMEPrefix + ErrorAutoRenewalExpiredType.String(),
+			details: "The auto-renewal order has expired",
+			status:  403,
+		},
+		ErrorAutoRenewalCancellationInvalidType: {
+			typ:     officialACMEPrefix + ErrorAutoRenewalCancellationInvalidType.String(),
+			details: "The auto-renewal order cannot be canceled",
+			status:  403,
+		},
+		ErrorAutoRenewalRevocationNotSupportedType: {
+			typ:     officialACMEPrefix + ErrorAutoRenewalRevocationNotSupportedType.String(),
+			details: "The certificates of auto-renewal orders cannot be revoked",
+			status:  403,
+		},
 		ErrorBadCSRType: {
 			typ:     officialACMEPrefix + ErrorBadCSRType.String(),
 			details: "The CSR is unacceptable",
@@ -229,7 +286,7 @@ var (
 		ErrorRateLimitedType: {
 			typ:     officialACMEPrefix + ErrorRateLimitedType.String(),
 			details: "The request exceeds a rate limit",
-			status:  400,
+			status:  429,
 		},
 		ErrorRejectedIdentifierType: {
 			typ:     officialACMEPrefix + ErrorRejectedIdentifierType.String(),
@@ -277,6 +334,8 @@ type Error struct {
 	Subproblems []Subproblem `json:"subproblems,omitempty"`
 	Err         error        `json:"-"`
 	Status      int          `json:"-"`
+	// RetryAfter, if set, is sent to the client in the Retry-After header.
+	RetryAfter time.Duration `json:"-"`
 }
 
 // Subproblem represents an ACME subproblem. It's fairly
@@ -425,6 +484,9 @@ func (e *Error) ToLog() (any, error) {
 
 // Render implements render.RenderableError for Error.
 func (e *Error) Render(w http.ResponseWriter, r *http.Request) {
+	if e.RetryAfter > 0 {
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(e.RetryAfter.Seconds()))))
+	}
 	w.Header().Set("Content-Type", "application/problem+json")
 	render.JSONStatus(w, r, e, e.StatusCode())
 }
@@ -46,6 +46,10 @@ const (
 	RevokeCertLinkType
 	// KeyChangeLinkType key rollover
 	KeyChangeLinkType
+	// RenewalInfoLinkType renewal information
+	RenewalInfoLinkType
+	// StarCertificateLinkType current certificate of an auto-renewal order
+	StarCertificateLinkType
 )
 
 func (l LinkType) String() string {
@@ -74,6 +78,10 @@ func (l LinkType) String() string {
 		return "revoke-cert"
 	case KeyChangeLinkType:
 		return "key-change"
+	case RenewalInfoLinkType:
+		return "renewal-info"
+	case StarCertificateLinkType:
+		return "star-certificate"
 	default:
 		return fmt.Sprintf("unexpected LinkType '%d'", int(l))
 	}
@@ -91,6 +99,14 @@ func GetUnescapedPathSuffix(typ LinkType, provisionerName string, inputs ...stri
 		return fmt.Sprintf("/%s/%s/%s/orders", provisionerName, AccountLinkType, inputs[0])
 	case FinalizeLinkType:
 		return fmt.Sprintf("/%s/%s/%s/finalize", provisionerName, OrderLinkType, inputs[0])
+	case StarCertificateLinkType:
+		return fmt.Sprintf("/%s/%s/%s/%s", provisionerName, OrderLinkType, inputs[0], typ)
+	case RenewalInfoLinkType:
+		// The directory contains the base URL of the resource.
+		if len(inputs) == 0 {
+			return fmt.Sprintf("/%s/%s", provisionerName, typ)
+		}
+		return fmt.Sprintf("/%s/%s/%s", provisionerName, typ, inputs[0])
 	default:
 		return ""
 	}
@@ -236,7 +252,12 @@ func (l *linker) LinkOrder(ctx context.Context, o *Order) {
 		o.AuthorizationURLs[i] = l.GetLink(ctx, AuthzLinkType, azID)
 	}
 	o.FinalizeURL = l.GetLink(ctx, FinalizeLinkType, o.ID)
-	if o.CertificateID != "" {
+	switch {
+	case o.AutoRenewal != nil && o.CertificateID != "":
+		// The certificates of auto-renewal orders change, so a stable URL
+		// is used, RFC 8739.
+		o.StarCertificateURL = l.GetLink(ctx, StarCertificateLinkType, o.ID)
+	case o.CertificateID != "":
 		o.CertificateURL = l.GetLink(ctx, CertificateLinkType, o.CertificateID)
 	}
 }
@@ -46,6 +46,9 @@ func TestGetUnescapedPathSuffix(t *testing.T) {
 	assert.Equals(t, getPath(AuthzLinkType, "{provisionerID}", "{authzID}"), "/{provisionerID}/authz/{authzID}")
 	assert.Equals(t, getPath(ChallengeLinkType, "{provisionerID}", "{authzID}", "{chID}"), "/{provisionerID}/challenge/{authzID}/{chID}")
 	assert.Equals(t, getPath(CertificateLinkType, "{provisionerID}", "{certID}"), "/{provisionerID}/certificate/{certID}")
+	assert.Equals(t, getPath(RenewalInfoLinkType, "{provisionerID}"), "/{provisionerID}/renewal-info")
+	assert.Equals(t, getPath(RenewalInfoLinkType, "{provisionerID}", "{certID}"), "/{provisionerID}/renewal-info/{certID}")
+	assert.Equals(t, getPath(StarCertificateLinkType, "{provisionerID}", "{ordID}"), "/{provisionerID}/order/{ordID}/star-certificate")
 }
 
 func TestLinker_DNS(t *testing.T) {
@@ -176,6 +179,10 @@ func TestLinker_GetLink(t *testing.T) {
 	assert.Equals(t, linker.GetLink(ctx, ChallengeLinkType, id, id), fmt.Sprintf("%s/acme/%s/challenge/%s/%s", baseURL, escProvName, id, id))
 
 	assert.Equals(t, linker.GetLink(ctx, CertificateLinkType, id), fmt.Sprintf("%s/acme/%s/certificate/1234", baseURL, escProvName))
+
+	assert.Equals(t, linker.GetLink(ctx, RenewalInfoLinkType), fmt.Sprintf("%s/acme/%s/renewal-info", baseURL, escProvName))
+
+	assert.Equals(t, linker.GetLink(ctx, RenewalInfoLinkType, id), fmt.Sprintf("%s/acme/%s/renewal-info/1234", baseURL, escProvName))
 }
 
 func TestLinker_LinkOrder(t *testing.T) {
@@ -234,6 +241,18 @@ func TestLinker_LinkOrder(t *testing.T) {
 				assert.Equals(t, o.CertificateURL, fmt.Sprintf("%s/%s/%s/certificate/%s", baseURL, linkerPrefix, provName, certID))
 			},
 		},
+		"auto-renewal": {
+			o: &Order{
+				ID:               oid,
+				CertificateID:    certID,
+				AuthorizationIDs: []string{"foo"},
+				AutoRenewal:      &AutoRenewal{Lifetime: 3600},
+			},
+			validate: func(o *Order) {
+				assert.Equals(t, o.CertificateURL, "")
+				assert.Equals(t, o.StarCertificateURL, fmt.Sprintf("%s/%s/%s/order/%s/star-certificate", baseURL, linkerPrefix, provName, oid))
+			},
+		},
 	}
 	for name, tc := range tests {
 		t.Run(name, func(t *testing.T) {
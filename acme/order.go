@@ -37,8 +37,25 @@ const (
 	WireUser IdentifierType = "wireapp-user"
 	// WireDevice is the Wire device identifier type
 	WireDevice IdentifierType = "wireapp-device"
+	// EMAIL is the ACME email identifier type defined in RFC 8823
+	EMAIL IdentifierType = "email"
 )
 
+// defaultEmailLeafTemplate is the template used by default for orders with
+// email identifiers. The certificates are meant to be used for S/MIME, so
+// they include the email protection extended key usage instead of the TLS
+// ones in x509util.DefaultLeafTemplate.
+const defaultEmailLeafTemplate = `{
+	"subject": {{ toJson .Subject }},
+	"sans": {{ toJson .SANs }},
+{{- if typeIs "*rsa.PublicKey" .Insecure.CR.PublicKey }}
+	"keyUsage": ["keyEncipherment", "digitalSignature"],
+{{- else }}
+	"keyUsage": ["digitalSignature"],
+{{- end }}
+	"extKeyUsage": ["emailProtection"]
+}`
+
 // Identifier encodes the type that an order pertains to.
 type Identifier struct {
 	Type  IdentifierType `json:"type"`
@@ -61,6 +78,15 @@ type Order struct {
 	FinalizeURL       string       `json:"finalize"`
 	CertificateID     string       `json:"-"`
 	CertificateURL    string       `json:"certificate,omitempty"`
+	Replaces          string       `json:"replaces,omitempty"`
+	Profile           string       `json:"profile,omitempty"`
+	// AutoRenewal is set on orders of Short-Term, Automatically Renewed
+	// (STAR) certificates, RFC 8739.
+	AutoRenewal        *AutoRenewal `json:"auto-renewal,omitempty"`
+	StarCertificateURL string       `json:"star-certificate,omitempty"`
+	// CSR is the certificate request of a finalized auto-renewal order, it's
+	// used to reissue the certificates.
+	CSR []byte `json:"-"`
 }
 
 // ToLog enables response logging.
@@ -80,7 +106,7 @@ func (o *Order) UpdateStatus(ctx context.Context, db DB) error {
 	switch o.Status {
 	case StatusInvalid:
 		return nil
-	case StatusValid:
+	case StatusValid, StatusCanceled:
 		return nil
 	case StatusReady:
 		// Check expiry
@@ -137,6 +163,11 @@ func (o *Order) UpdateStatus(ctx context.Context, db DB) error {
 		return WrapErrorISE(err, "error updating order")
 	}
 
+	// Invalid orders release the certificate they replace.
+	if o.Status == StatusInvalid && o.Replaces != "" {
+		return o.ReleaseReplaced(ctx, db)
+	}
+
 	return nil
 }
 
@@ -161,6 +192,26 @@ func (o *Order) getAuthorizationFingerprint(ctx context.Context, db DB) (string,
 	return "", nil
 }
 
+// setAuthorizationAttestationData sets in the attestation data the results of
+// the device-attest-01 challenge stored in the list of authorizations: the
+// validation of the EK certificate, only set by the tpm format if the
+// provisioner validates the EK certificates, and the device properties set by
+// the android-key format.
+func (o *Order) setAuthorizationAttestationData(ctx context.Context, db DB, attData *provisioner.AttestationData) error {
+	for _, azID := range o.AuthorizationIDs {
+		az, err := db.GetAuthorization(ctx, azID)
+		if err != nil {
+			return WrapErrorISE(err, "error getting authorization %q", azID)
+		}
+		if az.TPMEKValidation != nil || az.AndroidDevice != nil {
+			attData.TPMEKValidation = az.TPMEKValidation
+			attData.AndroidDevice = az.AndroidDevice
+			return nil
+		}
+	}
+	return nil
+}
+
 // Finalize signs a certificate if the necessary conditions for Order completion
 // have been met.
 //
@@ -204,6 +255,44 @@ func (o *Order) Finalize(ctx context.Context, db DB, csr *x509.CertificateReques
 		}
 	}
 
+	// The first certificate of an auto-renewal order is the one covering the
+	// current time, the following ones are issued by the AutoRenewer.
+	notBefore, notAfter := o.NotBefore, o.NotAfter
+	if o.AutoRenewal != nil {
+		now := clock.Now()
+		if !now.Before(o.AutoRenewal.EndDate) {
+			return NewError(ErrorAutoRenewalExpiredType, "order %s has expired", o.ID)
+		}
+		notBefore, notAfter = o.AutoRenewal.Validity(now)
+		o.CSR = csr.Raw
+	}
+
+	// The certificate in the replaces field is reserved for the order when
+	// the order is created, RFC 9773.
+	if o.Replaces != "" {
+		if err := o.checkReplaced(ctx, db); err != nil {
+			return err
+		}
+	}
+
+	cert, err := o.sign(ctx, db, csr, auth, p, fingerprint, notBefore, notAfter)
+	if err != nil {
+		return err
+	}
+
+	o.CertificateID = cert.ID
+	o.Status = StatusValid
+
+	if err = db.UpdateOrder(ctx, o); err != nil {
+		return WrapErrorISE(err, "error updating order %s", o.ID)
+	}
+
+	return nil
+}
+
+// sign signs and stores a certificate for the order with the given validity.
+// The fingerprint is the key fingerprint of an attested key, if any.
+func (o *Order) sign(ctx context.Context, db DB, csr *x509.CertificateRequest, auth CertificateAuthority, p Provisioner, fingerprint string, notBefore, notAfter time.Time) (*Certificate, error) {
 	// canonicalize the CSR to allow for comparison
 	csr = canonicalize(csr)
 
@@ -212,24 +301,24 @@ func (o *Order) Finalize(ctx context.Context, db DB, csr *x509.CertificateReques
 	if o.containsWireIdentifiers() {
 		wireDB, ok := db.(WireDB)
 		if !ok {
-			return fmt.Errorf("db %T is not a WireDB", db)
+			return nil, fmt.Errorf("db %T is not a WireDB", db)
 		}
 		subject, err := createWireSubject(o, csr)
 		if err != nil {
-			return fmt.Errorf("failed creating Wire subject: %w", err)
+			return nil, fmt.Errorf("failed creating Wire subject: %w", err)
 		}
 		data.SetSubject(subject)
 
 		// Inject Wire's custom challenges into the template once they have been validated
 		dpop, err := wireDB.GetDpopToken(ctx, o.ID)
 		if err != nil {
-			return fmt.Errorf("failed getting Wire DPoP token: %w", err)
+			return nil, fmt.Errorf("failed getting Wire DPoP token: %w", err)
 		}
 		data.Set("Dpop", dpop)
 
 		oidc, err := wireDB.GetOidcToken(ctx, o.ID)
 		if err != nil {
-			return fmt.Errorf("failed getting Wire OIDC token: %w", err)
+			return nil, fmt.Errorf("failed getting Wire OIDC token: %w", err)
 		}
 		data.Set("Oidc", oidc)
 	} else {
@@ -250,7 +339,7 @@ func (o *Order) Finalize(ctx context.Context, db DB, csr *x509.CertificateReques
 			// could result in unauthorized access if a relying system relies on the Common
 			// Name in its authorization logic.
 			if csr.Subject.CommonName != "" && csr.Subject.CommonName != permanentIdentifier {
-				return NewError(ErrorBadCSRType, "CSR Subject Common Name does not match identifiers exactly: "+
+				return nil, NewError(ErrorBadCSRType, "CSR Subject Common Name does not match identifiers exactly: "+
 					"CSR Subject Common Name = %s, Order Permanent Identifier = %s", csr.Subject.CommonName, permanentIdentifier)
 			}
 			break
@@ -264,23 +353,43 @@ func (o *Order) Finalize(ctx context.Context, db DB, csr *x509.CertificateReques
 			Type:  x509util.PermanentIdentifierType,
 			Value: permanentIdentifier,
 		})
-		extraOptions = append(extraOptions, provisioner.AttestationData{
+		attData := provisioner.AttestationData{
 			PermanentIdentifier: permanentIdentifier,
-		})
+		}
+		// The attestation results are only available on attested keys.
+		if fingerprint != "" {
+			if err := o.setAuthorizationAttestationData(ctx, db, &attData); err != nil {
+				return nil, err
+			}
+		}
+		extraOptions = append(extraOptions, attData)
 	} else {
 		defaultTemplate = x509util.DefaultLeafTemplate
+		if numberOfIdentifierType(EMAIL, o.Identifiers) > 0 {
+			defaultTemplate = defaultEmailLeafTemplate
+		}
 		sans, err := o.sans(csr)
 		if err != nil {
-			return err
+			return nil, err
 		}
 		data.SetSubjectAlternativeNames(sans...)
 	}
 
+	// Use the profile selected in the order, if any.
+	var profile *provisioner.ACMEProfile
+	if o.Profile != "" {
+		var ok bool
+		if profile, ok = p.GetProfile(o.Profile); !ok {
+			return nil, NewError(ErrorInvalidProfileType, "profile %q is not supported", o.Profile)
+		}
+		ctx = provisioner.NewContextWithACMEProfile(ctx, o.Profile)
+	}
+
 	// Get authorizations from the ACME provisioner.
 	ctx = provisioner.NewContextWithMethod(ctx, provisioner.SignMethod)
 	signOps, err := p.AuthorizeSign(ctx, "")
 	if err != nil {
-		return WrapErrorISE(err, "error retrieving authorization options from ACME provisioner")
+		return nil, WrapErrorISE(err, "error retrieving authorization options from ACME provisioner")
 	}
 	// Unlike most of the provisioners, ACME's AuthorizeSign method doesn't
 	// define the templates, and the template data used in WebHooks is not
@@ -291,9 +400,13 @@ func (o *Order) Finalize(ctx context.Context, db DB, csr *x509.CertificateReques
 		}
 	}
 
-	templateOptions, err := provisioner.CustomTemplateOptions(p.GetOptions(), data, defaultTemplate)
+	options := p.GetOptions()
+	if profile != nil {
+		options = profile.GetOptions()
+	}
+	templateOptions, err := provisioner.CustomTemplateOptions(options, data, defaultTemplate)
 	if err != nil {
-		return WrapErrorISE(err, "error creating template options from ACME provisioner")
+		return nil, WrapErrorISE(err, "error creating template options from ACME provisioner")
 	}
 
 	// Build extra signing options.
@@ -302,8 +415,8 @@ func (o *Order) Finalize(ctx context.Context, db DB, csr *x509.CertificateReques
 
 	// Sign a new certificate.
 	certChain, err := auth.SignWithContext(ctx, csr, provisioner.SignOptions{
-		NotBefore: provisioner.NewTimeDuration(o.NotBefore),
-		NotAfter:  provisioner.NewTimeDuration(o.NotAfter),
+		NotBefore: provisioner.NewTimeDuration(notBefore),
+		NotAfter:  provisioner.NewTimeDuration(notAfter),
 	}, signOps...)
 	if err != nil {
 		// Add subproblem for webhook errors, others can be added later.
@@ -315,10 +428,10 @@ func (o *Order) Finalize(ctx context.Context, db DB, csr *x509.CertificateReques
 				Type:   fmt.Sprintf("urn:smallstep:acme:error:%s", webhookErr.Code),
 				Detail: webhookErr.Message,
 			})
-			return acmeError
+			return nil, acmeError
 		}
 
-		return WrapErrorISE(err, "error signing certificate for order %s", o.ID)
+		return nil, WrapErrorISE(err, "error signing certificate for order %s", o.ID)
 	}
 
 	cert := &Certificate{
@@ -326,19 +439,13 @@ func (o *Order) Finalize(ctx context.Context, db DB, csr *x509.CertificateReques
 		OrderID:       o.ID,
 		Leaf:          certChain[0],
 		Intermediates: certChain[1:],
+		AutoRenewal:   o.AutoRenewal != nil,
 	}
 	if err := db.CreateCertificate(ctx, cert); err != nil {
-		return WrapErrorISE(err, "error creating certificate for order %s", o.ID)
+		return nil, WrapErrorISE(err, "error creating certificate for order %s", o.ID)
 	}
 
-	o.CertificateID = cert.ID
-	o.Status = StatusValid
-
-	if err = db.UpdateOrder(ctx, o); err != nil {
-		return WrapErrorISE(err, "error updating order %s", o.ID)
-	}
-
-	return nil
+	return cert, nil
 }
 
 // containsWireIdentifiers checks if [Order] contains ACME
@@ -402,7 +509,7 @@ func createWireSubject(o *Order, csr *x509.CertificateRequest) (subject x509util
 
 func (o *Order) sans(csr *x509.CertificateRequest) ([]x509util.SubjectAlternativeName, error) {
 	var sans []x509util.SubjectAlternativeName
-	if len(csr.EmailAddresses) > 0 {
+	if len(csr.EmailAddresses) > 0 && numberOfIdentifierType(EMAIL, o.Identifiers) == 0 {
 		return sans, NewError(ErrorBadCSRType, "Only DNS names and IP addresses are allowed")
 	}
 
@@ -411,7 +518,8 @@ func (o *Order) sans(csr *x509.CertificateRequest) ([]x509util.SubjectAlternativ
 	orderIPs := make([]net.IP, numberOfIdentifierType(IP, o.Identifiers))
 	orderPIDs := make([]string, numberOfIdentifierType(PermanentIdentifier, o.Identifiers))
 	tmpOrderURIs := make([]*url.URL, numberOfIdentifierType(WireUser, o.Identifiers)+numberOfIdentifierType(WireDevice, o.Identifiers))
-	indexDNS, indexIP, indexPID, indexURI := 0, 0, 0, 0
+	orderEmails := make([]string, numberOfIdentifierType(EMAIL, o.Identifiers))
+	indexDNS, indexIP, indexPID, indexURI, indexEmail := 0, 0, 0, 0, 0
 	for _, n := range o.Identifiers {
 		switch n.Type {
 		case DNS:
@@ -445,6 +553,9 @@ func (o *Order) sans(csr *x509.CertificateRequest) ([]x509util.SubjectAlternativ
 			}
 			tmpOrderURIs[indexURI] = clientID
 			indexURI++
+		case EMAIL:
+			orderEmails[indexEmail] = n.Value
+			indexEmail++
 		default:
 			return sans, NewErrorISE("unsupported identifier type in order: %s", n.Type)
 		}
@@ -452,8 +563,9 @@ func (o *Order) sans(csr *x509.CertificateRequest) ([]x509util.SubjectAlternativ
 	orderNames = uniqueSortedLowerNames(orderNames)
 	orderIPs = uniqueSortedIPs(orderIPs)
 	orderURIs := uniqueSortedURIStrings(tmpOrderURIs)
+	orderEmails = uniqueSortedLowerNames(orderEmails)
 
-	totalNumberOfSANs := len(csr.DNSNames) + len(csr.IPAddresses) + len(csr.URIs)
+	totalNumberOfSANs := len(csr.DNSNames) + len(csr.IPAddresses) + len(csr.URIs) + len(csr.EmailAddresses)
 	sans = make([]x509util.SubjectAlternativeName, totalNumberOfSANs)
 	index := 0
 
@@ -516,6 +628,23 @@ func (o *Order) sans(csr *x509.CertificateRequest) ([]x509util.SubjectAlternativ
 		index++
 	}
 
+	if len(csr.EmailAddresses) != len(orderEmails) {
+		return sans, NewError(ErrorBadCSRType, "CSR email addresses do not match identifiers exactly: "+
+			"CSR email addresses = %v, Order email addresses = %v", csr.EmailAddresses, orderEmails)
+	}
+
+	for i := range csr.EmailAddresses {
+		if csr.EmailAddresses[i] != orderEmails[i] {
+			return sans, NewError(ErrorBadCSRType, "CSR email addresses do not match identifiers exactly: "+
+				"CSR email addresses = %v, Order email addresses = %v", csr.EmailAddresses, orderEmails)
+		}
+		sans[index] = x509util.SubjectAlternativeName{
+			Type:  x509util.EmailType,
+			Value: csr.EmailAddresses[i],
+		}
+		index++
+	}
+
 	return sans, nil
 }
 
@@ -534,8 +663,9 @@ func numberOfIdentifierType(typ IdentifierType, ids []Identifier) int {
 // canonicalize canonicalizes a CSR so that it can be compared against an Order
 // NOTE: this effectively changes the order of SANs in the CSR, which may be OK,
 // but may not be expected. It also adds a Subject Common Name to either the IP
-// addresses or DNS names slice, depending on whether it can be parsed as an IP
-// or not. This might result in an additional SAN in the final certificate.
+// addresses, email addresses or DNS names slice, depending on whether it can be
+// parsed as an IP, it contains an @ or not. This might result in an additional
+// SAN in the final certificate.
 func canonicalize(csr *x509.CertificateRequest) (canonicalized *x509.CertificateRequest) {
 	// for clarity only; we're operating on the same object by pointer
 	canonicalized = csr
@@ -547,10 +677,13 @@ func canonicalize(csr *x509.CertificateRequest) (canonicalized *x509.Certificate
 	// subjectAltName extension, or both. Subject Common Names that can be
 	// parsed as an IP are included as an IP address for the equality check.
 	// If these were excluded, a certificate could contain an IP as the
-	// common name without having been challenged.
+	// common name without having been challenged. The same applies to email
+	// addresses, RFC 8823.
 	if csr.Subject.CommonName != "" {
 		if ip := net.ParseIP(csr.Subject.CommonName); ip != nil {
 			canonicalized.IPAddresses = append(canonicalized.IPAddresses, ip)
+		} else if strings.Contains(csr.Subject.CommonName, "@") {
+			canonicalized.EmailAddresses = append(canonicalized.EmailAddresses, csr.Subject.CommonName)
 		} else {
 			canonicalized.DNSNames = append(canonicalized.DNSNames, csr.Subject.CommonName)
 		}
@@ -558,6 +691,9 @@ func canonicalize(csr *x509.CertificateRequest) (canonicalized *x509.Certificate
 
 	canonicalized.DNSNames = uniqueSortedLowerNames(canonicalized.DNSNames)
 	canonicalized.IPAddresses = uniqueSortedIPs(canonicalized.IPAddresses)
+	if len(canonicalized.EmailAddresses) > 0 {
+		canonicalized.EmailAddresses = uniqueSortedLowerNames(canonicalized.EmailAddresses)
+	}
 
 	return canonicalized
 }
@@ -628,3 +764,68 @@ func uniqueSortedIPs(ips []net.IP) (unique []net.IP) {
 	})
 	return
 }
+
+// ReserveReplaced sets the order as the replacement of the certificate in the
+// replaces field, if the certificate is replaced by oldOrderID. It returns an
+// alreadyReplaced error if another order has reserved the certificate.
+func (o *Order) ReserveReplaced(ctx context.Context, db DB, oldOrderID string) error {
+	cert, err := GetCertificateByID(ctx, db, o.Replaces)
+	if err != nil {
+		return WrapErrorISE(err, "error retrieving certificate %s replaced by order %s", o.Replaces, o.ID)
+	}
+	switch err := db.ReplaceCertificate(ctx, cert.ID, oldOrderID, o.ID); {
+	case errors.Is(err, ErrCertificateReplaced):
+		return NewError(ErrorAlreadyReplacedType, "certificate '%s' has already been replaced", o.Replaces)
+	case err != nil:
+		return WrapErrorISE(err, "error updating certificate %s replaced by order %s", o.Replaces, o.ID)
+	default:
+		return nil
+	}
+}
+
+// ReleaseReplaced releases the certificate in the replaces field, so it can be
+// replaced by other orders. Certificates reserved by other orders are not
+// modified.
+func (o *Order) ReleaseReplaced(ctx context.Context, db DB) error {
+	cert, err := GetCertificateByID(ctx, db, o.Replaces)
+	if err != nil {
+		return WrapErrorISE(err, "error retrieving certificate %s replaced by order %s", o.Replaces, o.ID)
+	}
+	if err := db.ReplaceCertificate(ctx, cert.ID, o.ID, ""); err != nil && !errors.Is(err, ErrCertificateReplaced) {
+		return WrapErrorISE(err, "error releasing certificate %s replaced by order %s", o.Replaces, o.ID)
+	}
+	return nil
+}
+
+// checkReplaced checks that the certificate in the replaces field is reserved
+// for the order.
+func (o *Order) checkReplaced(ctx context.Context, db DB) error {
+	cert, err := GetCertificateByID(ctx, db, o.Replaces)
+	if err != nil {
+		return WrapErrorISE(err, "error retrieving certificate %s replaced by order %s", o.Replaces, o.ID)
+	}
+	switch cert.ReplacedBy {
+	case o.ID:
+		return nil
+	case "":
+		// The reservation was released, reserve it again.
+		return o.ReserveReplaced(ctx, db, "")
+	default:
+		return NewError(ErrorAlreadyReplacedType,
+			"certificate '%s' has already been replaced by order '%s'", o.Replaces, cert.ReplacedBy)
+	}
+}
+
+// IsReplacing returns true if the order is replacing the certificate in the
+// replaces field, or it might do it in the future. Invalid and expired orders
+// release the certificate they replace.
+func (o *Order) IsReplacing() bool {
+	switch o.Status {
+	case StatusInvalid:
+		return false
+	case StatusPending, StatusReady:
+		return clock.Now().Before(o.ExpiresAt)
+	default:
+		return true
+	}
+}
@@ -8,6 +8,7 @@ import (
 	"encoding/asn1"
 	"encoding/json"
 	"fmt"
+	"math/big"
 	"net"
 	"reflect"
 	"testing"
@@ -20,7 +21,9 @@ import (
 	"github.com/smallstep/certificates/authority/provisioner"
 	"github.com/smallstep/certificates/errs"
 	"github.com/smallstep/certificates/webhook"
+	"github.com/stretchr/testify/require"
 	"go.step.sm/crypto/keyutil"
+	"go.step.sm/crypto/minica"
 	"go.step.sm/crypto/x509util"
 )
 
@@ -77,6 +80,45 @@ func TestOrder_UpdateStatus(t *testing.T) {
 				},
 			}
 		},
+		"ok/ready-expired-releases-replaced": func(t *testing.T) test {
+			ca, err := minica.New()
+			require.NoError(t, err)
+			leaf := mustRenewalInfoLeaf(t, ca, big.NewInt(1234), time.Now(), time.Hour)
+			certID, err := CertificateID(leaf)
+			require.NoError(t, err)
+
+			o := &Order{
+				ID:        "oID",
+				AccountID: "accID",
+				Status:    StatusReady,
+				ExpiresAt: clock.Now().Add(-5 * time.Minute),
+				Replaces:  certID,
+			}
+			var released bool
+			t.Cleanup(func() {
+				assert.True(t, released)
+			})
+			return test{
+				o: o,
+				db: &MockDB{
+					MockUpdateOrder: func(ctx context.Context, updo *Order) error {
+						assert.Equals(t, updo.Status, StatusInvalid)
+						return nil
+					},
+					MockGetCertificateBySerial: func(ctx context.Context, serial string) (*Certificate, error) {
+						return &Certificate{ID: "certID", Leaf: leaf, ReplacedBy: "oID"}, nil
+					},
+					MockReplaceCertificate: func(ctx context.Context, id, oldOrderID, orderID string) error {
+						assert.False(t, released)
+						assert.Equals(t, id, "certID")
+						assert.Equals(t, oldOrderID, "oID")
+						assert.Equals(t, orderID, "")
+						released = true
+						return nil
+					},
+				},
+			}
+		},
 		"fail/ready-expired-db.UpdateOrder-error": func(t *testing.T) test {
 			now := clock.Now()
 			o := &Order{
@@ -502,6 +544,44 @@ func TestOrder_Finalize(t *testing.T) {
 				err: NewErrorISE("error retrieving authorization options from ACME provisioner: force"),
 			}
 		},
+		"fail/invalid-profile": func(t *testing.T) test {
+			now := clock.Now()
+			o := &Order{
+				ID:               "oID",
+				AccountID:        "accID",
+				Status:           StatusReady,
+				ExpiresAt:        now.Add(5 * time.Minute),
+				AuthorizationIDs: []string{"a", "b"},
+				Identifiers: []Identifier{
+					{Type: "dns", Value: "foo.internal"},
+					{Type: "dns", Value: "bar.internal"},
+				},
+				Profile: "mtls",
+			}
+			csr := &x509.CertificateRequest{
+				Subject: pkix.Name{
+					CommonName: "foo.internal",
+				},
+				DNSNames: []string{"bar.internal"},
+			}
+
+			return test{
+				o:   o,
+				csr: csr,
+				prov: &MockProvisioner{
+					MgetProfile: func(name string) (*provisioner.ACMEProfile, bool) {
+						assert.Equals(t, name, "mtls")
+						return nil, false
+					},
+				},
+				db: &MockDB{
+					MockGetAuthorization: func(ctx context.Context, id string) (*Authorization, error) {
+						return &Authorization{ID: id, Status: StatusValid}, nil
+					},
+				},
+				err: NewError(ErrorInvalidProfileType, "profile \"mtls\" is not supported"),
+			}
+		},
 		"fail/error-template-options": func(t *testing.T) test {
 			now := clock.Now()
 			o := &Order{
@@ -1879,6 +1959,43 @@ func TestOrder_sans(t *testing.T) {
 			},
 			err: nil,
 		},
+		{
+			name: "ok/email",
+			fields: fields{
+				Identifiers: []Identifier{
+					{Type: "email", Value: "alice@example.com"},
+					{Type: "email", Value: "Alice.Smith@example.com"},
+				},
+			},
+			csr: &x509.CertificateRequest{
+				Subject: pkix.Name{
+					CommonName: "alice@example.com",
+				},
+				EmailAddresses: []string{"alice.smith@example.com"},
+			},
+			want: []x509util.SubjectAlternativeName{
+				{Type: "email", Value: "alice.smith@example.com"},
+				{Type: "email", Value: "alice@example.com"},
+			},
+			err: nil,
+		},
+		{
+			name: "fail/error-emails-mismatch",
+			fields: fields{
+				Identifiers: []Identifier{
+					{Type: "email", Value: "alice@example.com"},
+				},
+			},
+			csr: &x509.CertificateRequest{
+				Subject: pkix.Name{
+					CommonName: "alice@example.com",
+				},
+				EmailAddresses: []string{"bob@example.com"},
+			},
+			want: []x509util.SubjectAlternativeName{},
+			err: NewError(ErrorBadCSRType, "CSR email addresses do not match identifiers exactly: "+
+				"CSR email addresses = %v, Order email addresses = %v", []string{"alice@example.com", "bob@example.com"}, []string{"alice@example.com"}),
+		},
 		{
 			name: "fail/unsupported-identifier-type",
 			fields: fields{
@@ -1975,3 +2092,79 @@ func TestOrder_getAuthorizationFingerprint(t *testing.T) {
 		})
 	}
 }
+
+func TestOrder_setAuthorizationAttestationData(t *testing.T) {
+	ctx := context.Background()
+	ekValidation := &provisioner.TPMEKValidation{EKKeyID: "urn:ek:sha256:Zm9vYmFy", Chain: []string{"", "CN=Root"}}
+	androidDevice := &provisioner.AndroidDevice{Brand: "google", Model: "Pixel 8", DeviceIdentifiers: []string{"serial-number"}}
+	tests := []struct {
+		name    string
+		db      DB
+		want    provisioner.AttestationData
+		wantErr bool
+	}{
+		{"ok", &MockDB{
+			MockGetAuthorization: func(ctx context.Context, id string) (*Authorization, error) {
+				return &Authorization{ID: id, Fingerprint: "fingerprint", Status: StatusValid}, nil
+			},
+		}, provisioner.AttestationData{PermanentIdentifier: "12345"}, false},
+		{"ok ek validation", &MockDB{
+			MockGetAuthorization: func(ctx context.Context, id string) (*Authorization, error) {
+				if id == "az1" {
+					return &Authorization{ID: id, Status: StatusValid}, nil
+				}
+				return &Authorization{ID: id, Fingerprint: "fingerprint", TPMEKValidation: ekValidation, Status: StatusValid}, nil
+			},
+		}, provisioner.AttestationData{PermanentIdentifier: "12345", TPMEKValidation: ekValidation}, false},
+		{"ok android device", &MockDB{
+			MockGetAuthorization: func(ctx context.Context, id string) (*Authorization, error) {
+				if id == "az1" {
+					return &Authorization{ID: id, Status: StatusValid}, nil
+				}
+				return &Authorization{ID: id, Fingerprint: "fingerprint", AndroidDevice: androidDevice, Status: StatusValid}, nil
+			},
+		}, provisioner.AttestationData{PermanentIdentifier: "12345", AndroidDevice: androidDevice}, false},
+		{"fail", &MockDB{
+			MockGetAuthorization: func(ctx context.Context, id string) (*Authorization, error) {
+				return nil, errors.New("force")
+			},
+		}, provisioner.AttestationData{PermanentIdentifier: "12345"}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			o := &Order{
+				AuthorizationIDs: []string{"az1", "az2"},
+			}
+			got := provisioner.AttestationData{PermanentIdentifier: "12345"}
+			err := o.setAuthorizationAttestationData(ctx, tt.db, &got)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equals(t, tt.want, got)
+		})
+	}
+}
+
+func Test_defaultEmailLeafTemplate(t *testing.T) {
+	signer, err := keyutil.GenerateDefaultSigner()
+	assert.FatalError(t, err)
+	csr, err := x509util.CreateCertificateRequest("alice@example.com", nil, signer)
+	assert.FatalError(t, err)
+
+	data := x509util.NewTemplateData()
+	data.SetCommonName("alice@example.com")
+	data.SetCertificateRequest(csr)
+	data.SetSubjectAlternativeNames(x509util.SubjectAlternativeName{
+		Type:  x509util.EmailType,
+		Value: "alice@example.com",
+	})
+	cert, err := x509util.NewCertificate(csr, x509util.WithTemplate(defaultEmailLeafTemplate, data))
+	assert.FatalError(t, err)
+
+	crt := cert.GetCertificate()
+	assert.Equals(t, []string{"alice@example.com"}, crt.EmailAddresses)
+	assert.Equals(t, []x509.ExtKeyUsage{x509.ExtKeyUsageEmailProtection}, crt.ExtKeyUsage)
+	assert.Equals(t, x509.KeyUsageDigitalSignature, crt.KeyUsage)
+}
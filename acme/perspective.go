@@ -0,0 +1,221 @@
+package acme
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+
+	"go.step.sm/crypto/jose"
+
+	"github.com/smallstep/certificates/authority/provisioner"
+)
+
+// ValidationRequest is the request sent to a remote validation agent to
+// validate a challenge from its network perspective.
+type ValidationRequest struct {
+	Type       ChallengeType    `json:"type"`
+	Value      string           `json:"value"`
+	Token      string           `json:"token"`
+	AccountURL string           `json:"accountURL,omitempty"`
+	Key        *jose.JSONWebKey `json:"key"`
+}
+
+// ValidationResponse is the response of a remote validation agent.
+type ValidationResponse struct {
+	Status Status `json:"status"`
+	Error  *Error `json:"error,omitempty"`
+}
+
+// perspectiveDB is the DB used to validate challenges from a remote
+// perspective. The validation agents do not store the challenges.
+type perspectiveDB struct {
+	DB
+}
+
+func (perspectiveDB) UpdateChallenge(context.Context, *Challenge) error {
+	return nil
+}
+
+// ValidateFromPerspective validates the challenge in the request from the
+// network perspective of the current host. It's used by the remote validation
+// agents, and the challenge is not stored.
+func ValidateFromPerspective(ctx context.Context, req *ValidationRequest) (*ValidationResponse, error) {
+	switch {
+	case req.Key == nil:
+		return nil, NewError(ErrorMalformedType, "validation request key cannot be empty")
+	case req.Value == "":
+		return nil, NewError(ErrorMalformedType, "validation request value cannot be empty")
+	case req.Token == "":
+		return nil, NewError(ErrorMalformedType, "validation request token cannot be empty")
+	}
+
+	ch := &Challenge{
+		Type:   req.Type,
+		Status: StatusPending,
+		Token:  req.Token,
+		Value:  req.Value,
+	}
+	db := perspectiveDB{}
+
+	var err error
+	switch req.Type {
+	case HTTP01:
+		err = http01Validate(ctx, ch, db, req.Key)
+	case DNS01:
+		err = dns01Validate(ctx, ch, db, req.Key)
+	case DNSACCOUNT01:
+		if req.AccountURL == "" {
+			return nil, NewError(ErrorMalformedType, "validation request accountURL cannot be empty")
+		}
+		domain := strings.TrimPrefix(ch.Value, "*.")
+		err = dnsTXTValidate(ctx, ch, db, req.Key, domain, dnsAccount01ChallengeHost(req.AccountURL, domain))
+	case TLSALPN01:
+		err = tlsalpn01Validate(ctx, ch, db, req.Key)
+	default:
+		return nil, NewError(ErrorMalformedType, "unsupported challenge type %q", req.Type)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &ValidationResponse{
+		Status: ch.Status,
+		Error:  ch.Error.withDetail(),
+	}, nil
+}
+
+// validateFromPerspectives validates the challenge from the remote validation
+// agents configured in the provisioner. It returns nil if the provisioner
+// does not define remote perspectives or if the number of agents that
+// validate the challenge reaches the quorum. The returned boolean reports
+// whether the challenge must be marked as invalid, that's only the case if
+// enough agents rejected the challenge to make the quorum unreachable; errors
+// reaching the agents can be retried.
+func validateFromPerspectives(ctx context.Context, ch *Challenge, jwk *jose.JSONWebKey) (bool, *Error) {
+	prov, ok := ProvisionerFromContext(ctx)
+	if !ok {
+		return false, nil
+	}
+	p, ok := prov.(*provisioner.ACME)
+	if !ok || p.ValidationPerspectives == nil {
+		return false, nil
+	}
+	vp := p.ValidationPerspectives
+
+	pub := jwk.Public()
+	req := &ValidationRequest{
+		Type:  ch.Type,
+		Value: ch.Value,
+		Token: ch.Token,
+		Key:   &pub,
+	}
+	if ch.Type == DNSACCOUNT01 {
+		linker, ok := LinkerFromContext(ctx)
+		if !ok {
+			return false, NewErrorISE("missing linker")
+		}
+		req.AccountURL = linker.GetLink(ctx, AccountLinkType, ch.AccountID)
+	}
+	body, err := json.Marshal(req)
+	if err != nil {
+		return false, WrapErrorISE(err, "error marshaling validation request")
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, vp.GetTimeout())
+	defer cancel()
+
+	type result struct {
+		agent string
+		err   error
+	}
+	client := vp.GetHTTPClient()
+	results := make(chan result, len(vp.Agents))
+	for _, a := range vp.Agents {
+		go func(a *provisioner.ACMEValidationAgent) {
+			results <- result{agent: a.Name, err: callValidationAgent(ctx, client, a, body)}
+		}(a)
+	}
+
+	// Wait until the quorum is reached or it cannot be reached anymore.
+	quorum := vp.GetQuorum()
+	var valid, rejected int
+	var failures []string
+	for range vp.Agents {
+		r := <-results
+		if r.err == nil {
+			valid++
+		} else {
+			failures = append(failures, r.agent+": "+r.err.Error())
+			var re *agentRejection
+			if errors.As(r.err, &re) {
+				rejected++
+			}
+		}
+		if valid >= quorum {
+			return false, nil
+		}
+		if len(vp.Agents)-len(failures) < quorum {
+			break
+		}
+	}
+
+	sort.Strings(failures)
+	return len(vp.Agents)-rejected < quorum, NewDetailedError(ErrorUnauthorizedType, "%s challenge for %s failed the validation from remote perspectives, %d of %d required agents succeeded; %s",
+		ch.Type, ch.Value, valid, quorum, strings.Join(failures, "; "))
+}
+
+// agentRejection is the error returned when a remote agent marks the challenge
+// as invalid, as opposed to errors that can be retried, like the errors
+// reaching the agent or a pending challenge.
+type agentRejection struct {
+	detail string
+}
+
+func (e *agentRejection) Error() string {
+	return e.detail
+}
+
+// callValidationAgent sends the validation request to a remote agent. It
+// returns an error if the agent did not validate the challenge.
+func callValidationAgent(ctx context.Context, client *http.Client, a *provisioner.ACMEValidationAgent, body []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, a.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+a.Token)
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status code %d", resp.StatusCode)
+	}
+
+	var vr ValidationResponse
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&vr); err != nil {
+		return fmt.Errorf("error decoding response: %w", err)
+	}
+	var detail string
+	if vr.Error != nil {
+		detail = vr.Error.Detail
+	} else {
+		detail = fmt.Sprintf("challenge status is %s", vr.Status)
+	}
+	switch vr.Status {
+	case StatusValid:
+		return nil
+	case StatusInvalid:
+		return &agentRejection{detail: detail}
+	default:
+		return errors.New(detail)
+	}
+}
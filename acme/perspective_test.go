@@ -0,0 +1,187 @@
+package acme
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.step.sm/crypto/jose"
+
+	"github.com/smallstep/certificates/authority/config"
+	"github.com/smallstep/certificates/authority/provisioner"
+)
+
+// newPerspectiveServer starts a validation agent that sees the given TXT
+// records.
+func newPerspectiveServer(t *testing.T, txt string) *httptest.Server {
+	t.Helper()
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		var req ValidationRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		ctx := NewClientContext(r.Context(), &mockClient{
+			lookupTxt: func(name string) ([]string, error) {
+				assert.Equal(t, "_acme-challenge.example.com", name)
+				return []string{txt}, nil
+			},
+		})
+		resp, err := ValidateFromPerspective(ctx, &req)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		_ = json.NewEncoder(w).Encode(resp)
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestChallenge_Validate_perspectives(t *testing.T) {
+	jwk, err := jose.GenerateJWK("EC", "P-256", "ES256", "sig", "", 0)
+	require.NoError(t, err)
+	keyAuth, err := KeyAuthorization("token", jwk)
+	require.NoError(t, err)
+	sum := sha256.Sum256([]byte(keyAuth))
+	txt := base64.RawURLEncoding.EncodeToString(sum[:])
+
+	good1 := newPerspectiveServer(t, txt)
+	good2 := newPerspectiveServer(t, txt)
+	bad := newPerspectiveServer(t, "hijacked")
+	invalid := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewEncoder(w).Encode(&ValidationResponse{
+			Status: StatusInvalid,
+			Error:  NewError(ErrorRejectedIdentifierType, "keyAuthorization does not match"),
+		})
+	}))
+	t.Cleanup(invalid.Close)
+	closed := httptest.NewTLSServer(http.NotFoundHandler())
+	closed.Close()
+
+	// All the test servers use the same certificate.
+	roots := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: good1.Certificate().Raw})
+
+	agent := func(name string, srv *httptest.Server, token string) *provisioner.ACMEValidationAgent {
+		return &provisioner.ACMEValidationAgent{Name: name, URL: srv.URL + "/validate", Token: token}
+	}
+
+	tests := []struct {
+		name       string
+		agents     []*provisioner.ACMEValidationAgent
+		quorum     int
+		roots      []byte
+		wantStatus Status
+	}{
+		{"ok/all", []*provisioner.ACMEValidationAgent{agent("a", good1, "secret"), agent("b", good2, "secret")}, 0, roots, StatusValid},
+		{"ok/quorum", []*provisioner.ACMEValidationAgent{agent("a", good1, "secret"), agent("b", bad, "secret"), agent("c", good2, "secret")}, 2, roots, StatusValid},
+		{"fail/all", []*provisioner.ACMEValidationAgent{agent("a", good1, "secret"), agent("b", invalid, "secret")}, 0, roots, StatusInvalid},
+		{"fail/quorum", []*provisioner.ACMEValidationAgent{agent("a", good1, "secret"), agent("b", invalid, "secret"), agent("c", invalid, "secret")}, 2, roots, StatusInvalid},
+		{"retry/pending", []*provisioner.ACMEValidationAgent{agent("a", good1, "secret"), agent("b", bad, "secret")}, 0, roots, StatusPending},
+		{"retry/quorum", []*provisioner.ACMEValidationAgent{agent("a", good1, "secret"), agent("b", invalid, "secret"), agent("c", closed, "secret")}, 2, roots, StatusPending},
+		{"retry/token", []*provisioner.ACMEValidationAgent{agent("a", good1, "foo")}, 0, roots, StatusPending},
+		{"retry/unreachable", []*provisioner.ACMEValidationAgent{agent("a", closed, "secret")}, 0, roots, StatusPending},
+		{"retry/untrusted", []*provisioner.ACMEValidationAgent{agent("a", good1, "secret")}, 0, nil, StatusPending},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			prov := &provisioner.ACME{
+				Type: "ACME",
+				Name: "acme",
+				ValidationPerspectives: &provisioner.ACMEValidationPerspectives{
+					Agents: tt.agents,
+					Quorum: tt.quorum,
+					Roots:  tt.roots,
+				},
+			}
+			require.NoError(t, prov.Init(provisioner.Config{Claims: config.GlobalProvisionerClaims}))
+
+			ch := &Challenge{
+				ID:        "chID",
+				AccountID: "accID",
+				Type:      DNS01,
+				Status:    StatusPending,
+				Token:     "token",
+				Value:     "example.com",
+			}
+			ctx := NewProvisionerContext(context.Background(), prov)
+			ctx = NewClientContext(ctx, &mockClient{
+				lookupTxt: func(name string) ([]string, error) {
+					return []string{txt}, nil
+				},
+			})
+			db := &MockDB{
+				MockUpdateChallenge: func(ctx context.Context, updch *Challenge) error {
+					return nil
+				},
+			}
+
+			require.NoError(t, ch.Validate(ctx, db, jwk, nil))
+			assert.Equal(t, tt.wantStatus, ch.Status)
+			if tt.wantStatus == StatusValid {
+				assert.Nil(t, ch.Error)
+				return
+			}
+			if assert.NotNil(t, ch.Error) {
+				assert.Equal(t, "urn:ietf:params:acme:error:unauthorized", ch.Error.Type)
+				assert.Contains(t, ch.Error.Detail, "failed the validation from remote perspectives")
+			}
+		})
+	}
+}
+
+func TestValidateFromPerspective(t *testing.T) {
+	jwk, err := jose.GenerateJWK("EC", "P-256", "ES256", "sig", "", 0)
+	require.NoError(t, err)
+	pub := jwk.Public()
+	keyAuth, err := KeyAuthorization("token", &pub)
+	require.NoError(t, err)
+	sum := sha256.Sum256([]byte(keyAuth))
+	txt := base64.RawURLEncoding.EncodeToString(sum[:])
+	accountURL := "https://ca.example.org/acme/acme/account/accID"
+
+	ctx := NewClientContext(context.Background(), &mockClient{
+		lookupTxt: func(name string) ([]string, error) {
+			if name == "_acme-challenge.example.com" || name == dnsAccount01ChallengeHost(accountURL, "example.com") {
+				return []string{txt}, nil
+			}
+			return []string{"foo"}, nil
+		},
+	})
+
+	resp, err := ValidateFromPerspective(ctx, &ValidationRequest{Type: DNS01, Value: "*.example.com", Token: "token", Key: &pub})
+	require.NoError(t, err)
+	assert.Equal(t, &ValidationResponse{Status: StatusValid}, resp)
+
+	resp, err = ValidateFromPerspective(ctx, &ValidationRequest{Type: DNSACCOUNT01, Value: "example.com", Token: "token", AccountURL: accountURL, Key: &pub})
+	require.NoError(t, err)
+	assert.Equal(t, StatusValid, resp.Status)
+
+	resp, err = ValidateFromPerspective(ctx, &ValidationRequest{Type: DNS01, Value: "example.org", Token: "token", Key: &pub})
+	require.NoError(t, err)
+	assert.Equal(t, StatusPending, resp.Status)
+	require.NotNil(t, resp.Error)
+	assert.Contains(t, resp.Error.Detail, "keyAuthorization does not match")
+
+	for _, req := range []*ValidationRequest{
+		{Type: DNS01, Value: "example.com", Token: "token"},
+		{Type: DNS01, Token: "token", Key: &pub},
+		{Type: DNS01, Value: "example.com", Key: &pub},
+		{Type: DNSACCOUNT01, Value: "example.com", Token: "token", Key: &pub},
+		{Type: DEVICEATTEST01, Value: "example.com", Token: "token", Key: &pub},
+	} {
+		_, err := ValidateFromPerspective(ctx, req)
+		assert.Error(t, err)
+	}
+}
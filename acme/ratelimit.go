@@ -0,0 +1,86 @@
+package acme
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/smallstep/certificates/authority/provisioner"
+)
+
+// RateLimitCounter is a fixed window counter used to enforce the ACME rate
+// limits.
+type RateLimitCounter struct {
+	Key     string
+	Count   int
+	ResetAt time.Time
+}
+
+// RateLimitKey identifies a rate limit counter. Counters are scoped to the
+// provisioner and the name of the rate limit.
+type RateLimitKey struct {
+	ProvisionerID string
+	Name          string
+	Value         string
+}
+
+// String returns the key used to store the counter.
+func (k RateLimitKey) String() string {
+	return strings.Join([]string{k.ProvisionerID, k.Name, k.Value}, "|")
+}
+
+// CheckRateLimit returns a rateLimited error if the counter with the given key
+// has reached the limit. The counter is not incremented.
+func CheckRateLimit(ctx context.Context, db DB, key RateLimitKey, limit *provisioner.ACMERateLimit) error {
+	if !limit.IsEnabled() {
+		return nil
+	}
+	c, err := db.GetRateLimitCounter(ctx, key.String(), limit.GetWindow())
+	if err != nil {
+		return WrapErrorISE(err, "error retrieving rate limit counter")
+	}
+	if c.Count >= limit.Limit {
+		return newRateLimitedError(key, c)
+	}
+	return nil
+}
+
+// ConsumeRateLimit increments the counter with the given key and returns a
+// rateLimited error if the new value exceeds the limit.
+func ConsumeRateLimit(ctx context.Context, db DB, key RateLimitKey, limit *provisioner.ACMERateLimit) error {
+	if !limit.IsEnabled() {
+		return nil
+	}
+	c, err := db.IncrementRateLimitCounter(ctx, key.String(), limit.GetWindow())
+	if err != nil {
+		return WrapErrorISE(err, "error incrementing rate limit counter")
+	}
+	if c.Count > limit.Limit {
+		return newRateLimitedError(key, c)
+	}
+	return nil
+}
+
+// IncrementRateLimit increments the counter with the given key without
+// enforcing the limit. It is used to record events, like failed validations or
+// issued certificates, that are checked before the next request.
+func IncrementRateLimit(ctx context.Context, db DB, key RateLimitKey, limit *provisioner.ACMERateLimit) error {
+	if !limit.IsEnabled() {
+		return nil
+	}
+	if _, err := db.IncrementRateLimitCounter(ctx, key.String(), limit.GetWindow()); err != nil {
+		return WrapErrorISE(err, "error incrementing rate limit counter")
+	}
+	return nil
+}
+
+func newRateLimitedError(key RateLimitKey, c *RateLimitCounter) *Error {
+	err := NewDetailedError(ErrorRateLimitedType, "%s rate limit exceeded for %s, retry after %s",
+		key.Name, key.Value, c.ResetAt.UTC().Format(time.RFC3339))
+	if d := c.ResetAt.Sub(clock.Now()); d > 0 {
+		err.RetryAfter = d
+	} else {
+		err.RetryAfter = time.Second
+	}
+	return err
+}
@@ -0,0 +1,78 @@
+package acme
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/smallstep/certificates/authority/provisioner"
+)
+
+func TestRateLimits(t *testing.T) {
+	ctx := context.Background()
+	key := RateLimitKey{ProvisionerID: "provID", Name: "newOrdersPerAccount", Value: "accID"}
+	limit := &provisioner.ACMERateLimit{Limit: 2, Window: &provisioner.Duration{Duration: time.Hour}}
+	resetAt := clock.Now().Add(30 * time.Minute)
+
+	counter := func(n int, err error) DB {
+		return &MockDB{
+			MockGetRateLimitCounter: func(ctx context.Context, k string, window time.Duration) (*RateLimitCounter, error) {
+				assert.Equal(t, "provID|newOrdersPerAccount|accID", k)
+				assert.Equal(t, time.Hour, window)
+				return &RateLimitCounter{Key: k, Count: n, ResetAt: resetAt}, err
+			},
+			MockIncrementRateLimitCounter: func(ctx context.Context, k string, window time.Duration) (*RateLimitCounter, error) {
+				assert.Equal(t, "provID|newOrdersPerAccount|accID", k)
+				assert.Equal(t, time.Hour, window)
+				return &RateLimitCounter{Key: k, Count: n + 1, ResetAt: resetAt}, err
+			},
+		}
+	}
+
+	tests := []struct {
+		name       string
+		fn         func(context.Context, DB, RateLimitKey, *provisioner.ACMERateLimit) error
+		db         DB
+		limit      *provisioner.ACMERateLimit
+		wantStatus int
+	}{
+		{"check/ok", CheckRateLimit, counter(1, nil), limit, 0},
+		{"check/disabled", CheckRateLimit, &MockDB{}, nil, 0},
+		{"check/limited", CheckRateLimit, counter(2, nil), limit, http.StatusTooManyRequests},
+		{"check/fail", CheckRateLimit, counter(0, errors.New("force")), limit, http.StatusInternalServerError},
+		{"consume/ok", ConsumeRateLimit, counter(1, nil), limit, 0},
+		{"consume/disabled", ConsumeRateLimit, &MockDB{}, &provisioner.ACMERateLimit{}, 0},
+		{"consume/limited", ConsumeRateLimit, counter(2, nil), limit, http.StatusTooManyRequests},
+		{"consume/fail", ConsumeRateLimit, counter(0, errors.New("force")), limit, http.StatusInternalServerError},
+		{"increment/ok", IncrementRateLimit, counter(5, nil), limit, 0},
+		{"increment/fail", IncrementRateLimit, counter(0, errors.New("force")), limit, http.StatusInternalServerError},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.fn(ctx, tt.db, key, tt.limit)
+			if tt.wantStatus == 0 {
+				assert.NoError(t, err)
+				return
+			}
+			var ae *Error
+			require.True(t, errors.As(err, &ae))
+			assert.Equal(t, tt.wantStatus, ae.Status)
+			if tt.wantStatus == http.StatusTooManyRequests {
+				assert.Equal(t, "urn:ietf:params:acme:error:rateLimited", ae.Type)
+				assert.Contains(t, ae.Detail, "newOrdersPerAccount rate limit exceeded for accID")
+				assert.InDelta(t, 30*time.Minute, ae.RetryAfter, float64(time.Minute))
+
+				w := httptest.NewRecorder()
+				ae.Render(w, httptest.NewRequest("POST", "/", http.NoBody))
+				assert.Equal(t, http.StatusTooManyRequests, w.Code)
+				assert.NotEmpty(t, w.Header().Get("Retry-After"))
+			}
+		})
+	}
+}
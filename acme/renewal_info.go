@@ -0,0 +1,162 @@
+package acme
+
+import (
+	"bytes"
+	"context"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// RenewalInfo is the ACME Renewal Information (ARI) of a certificate as defined
+// in RFC 9773.
+type RenewalInfo struct {
+	SuggestedWindow SuggestedWindow `json:"suggestedWindow"`
+	ExplanationURL  string          `json:"explanationURL,omitempty"`
+}
+
+// SuggestedWindow is the window in which a certificate should be renewed.
+type SuggestedWindow struct {
+	Start time.Time `json:"start"`
+	End   time.Time `json:"end"`
+}
+
+// ToLog enables response logging.
+func (ri *RenewalInfo) ToLog() (interface{}, error) {
+	b, err := json.Marshal(ri)
+	if err != nil {
+		return nil, WrapErrorISE(err, "error marshaling renewal info for logging")
+	}
+	return string(b), nil
+}
+
+// CertificateID returns the ARI unique identifier of a certificate. The
+// identifier is composed by the base64url encoded key identifier of the
+// authority key identifier extension and the base64url encoded DER bytes of the
+// serial number, separated by a period.
+func CertificateID(crt *x509.Certificate) (string, error) {
+	if len(crt.AuthorityKeyId) == 0 {
+		return "", errors.New("certificate does not have an authority key identifier")
+	}
+	b, err := asn1.Marshal(crt.SerialNumber)
+	if err != nil {
+		return "", errors.Wrap(err, "error marshaling serial number")
+	}
+	var v asn1.RawValue
+	if _, err := asn1.Unmarshal(b, &v); err != nil {
+		return "", errors.Wrap(err, "error unmarshaling serial number")
+	}
+	return base64.RawURLEncoding.EncodeToString(crt.AuthorityKeyId) + "." +
+		base64.RawURLEncoding.EncodeToString(v.Bytes), nil
+}
+
+// ParseCertificateID parses an ARI unique identifier and returns the key
+// identifier of the authority and the serial number of the certificate.
+func ParseCertificateID(id string) ([]byte, *big.Int, error) {
+	akiPart, serialPart, ok := strings.Cut(id, ".")
+	if !ok {
+		return nil, nil, NewError(ErrorMalformedType, "invalid certificate identifier %q", id)
+	}
+	aki, err := base64.RawURLEncoding.DecodeString(akiPart)
+	if err != nil || len(aki) == 0 {
+		return nil, nil, NewError(ErrorMalformedType, "invalid certificate identifier %q: invalid key identifier", id)
+	}
+	b, err := base64.RawURLEncoding.DecodeString(serialPart)
+	if err != nil || len(b) == 0 {
+		return nil, nil, NewError(ErrorMalformedType, "invalid certificate identifier %q: invalid serial number", id)
+	}
+
+	// The serial is the content of a DER INTEGER, it must be positive and
+	// minimally encoded.
+	if b[0]&0x80 != 0 || (len(b) > 1 && b[0] == 0 && b[1]&0x80 == 0) {
+		return nil, nil, NewError(ErrorMalformedType, "invalid certificate identifier %q: invalid serial number", id)
+	}
+
+	return aki, new(big.Int).SetBytes(b), nil
+}
+
+// GetCertificateByID returns the ACME certificate with the given ARI unique
+// identifier. It returns an error with a 404 status code if the certificate
+// does not exist or it was not issued by the ACME server.
+func GetCertificateByID(ctx context.Context, db DB, id string) (*Certificate, error) {
+	aki, serial, err := ParseCertificateID(id)
+	if err != nil {
+		return nil, err
+	}
+
+	cert, err := db.GetCertificateBySerial(ctx, serial.String())
+	if err != nil {
+		var ae *Error
+		if IsErrNotFound(err) || errors.As(err, &ae) {
+			return nil, newCertificateNotFoundError(id)
+		}
+		return nil, WrapErrorISE(err, "error retrieving certificate %s", id)
+	}
+	if !bytes.Equal(cert.Leaf.AuthorityKeyId, aki) {
+		return nil, newCertificateNotFoundError(id)
+	}
+
+	return cert, nil
+}
+
+func newCertificateNotFoundError(id string) *Error {
+	err := NewError(ErrorMalformedType, "certificate %s not found", id)
+	err.Status = http.StatusNotFound
+	return err
+}
+
+// RenewalInfo returns the suggested renewal window of the certificate. By
+// default, the window starts after two thirds of the lifetime of the certificate
+// and ends after five sixths of it. If the certificate has been revoked, the
+// window is moved to the past, so clients renew it immediately, and if the
+// certificate was issued by an intermediate that is no longer in use, the
+// window starts now.
+func (c *Certificate) RenewalInfo(auth CertificateAuthority) (*RenewalInfo, error) {
+	leaf := c.Leaf
+	now := clock.Now()
+	lifetime := leaf.NotAfter.Sub(leaf.NotBefore)
+	start := leaf.NotBefore.Add(lifetime * 2 / 3)
+	end := leaf.NotBefore.Add(lifetime * 5 / 6)
+
+	revoked, err := auth.IsRevoked(leaf.SerialNumber.String())
+	if err != nil {
+		return nil, WrapErrorISE(err, "error retrieving revocation status of certificate")
+	}
+
+	switch {
+	case revoked:
+		start, end = leaf.NotBefore, now
+	case isIssuerRotated(auth, leaf) && now.Before(start):
+		start = now
+	}
+
+	return &RenewalInfo{
+		SuggestedWindow: SuggestedWindow{
+			Start: start.UTC().Truncate(time.Second),
+			End:   end.UTC().Truncate(time.Second),
+		},
+	}, nil
+}
+
+// isIssuerRotated returns true if the certificate authority is able to report
+// its current intermediate and it is not the one that issued the certificate.
+func isIssuerRotated(auth CertificateAuthority, leaf *x509.Certificate) bool {
+	ia, ok := auth.(interface {
+		GetIntermediateCertificate() *x509.Certificate
+	})
+	if !ok {
+		return false
+	}
+	issuer := ia.GetIntermediateCertificate()
+	if issuer == nil || len(issuer.SubjectKeyId) == 0 || len(leaf.AuthorityKeyId) == 0 {
+		return false
+	}
+	return !bytes.Equal(issuer.SubjectKeyId, leaf.AuthorityKeyId)
+}
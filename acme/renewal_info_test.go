@@ -0,0 +1,272 @@
+package acme
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
+	"math/big"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.step.sm/crypto/keyutil"
+	"go.step.sm/crypto/minica"
+)
+
+type mockRenewalInfoAuth struct {
+	mockSignAuth
+	revoked      bool
+	isRevokedErr error
+	intermediate *x509.Certificate
+}
+
+func (m *mockRenewalInfoAuth) IsRevoked(string) (bool, error) {
+	return m.revoked, m.isRevokedErr
+}
+
+func (m *mockRenewalInfoAuth) GetIntermediateCertificate() *x509.Certificate {
+	return m.intermediate
+}
+
+func mustRenewalInfoLeaf(t *testing.T, ca *minica.CA, serial *big.Int, notBefore time.Time, lifetime time.Duration) *x509.Certificate {
+	t.Helper()
+	pub, _, err := keyutil.GenerateDefaultKeyPair()
+	require.NoError(t, err)
+	leaf, err := ca.Sign(&x509.Certificate{
+		SerialNumber: serial,
+		DNSNames:     []string{"test.example.com"},
+		NotBefore:    notBefore,
+		NotAfter:     notBefore.Add(lifetime),
+		PublicKey:    pub,
+	})
+	require.NoError(t, err)
+	return leaf
+}
+
+func TestCertificateID(t *testing.T) {
+	ca, err := minica.New()
+	require.NoError(t, err)
+
+	now := time.Now()
+	tests := []struct {
+		name   string
+		serial *big.Int
+	}{
+		{"small", big.NewInt(1)},
+		{"high bit", big.NewInt(0x87)},
+		{"large", new(big.Int).Lsh(big.NewInt(0xff), 150)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			leaf := mustRenewalInfoLeaf(t, ca, tt.serial, now, time.Hour)
+			id, err := CertificateID(leaf)
+			require.NoError(t, err)
+
+			aki, serial, err := ParseCertificateID(id)
+			require.NoError(t, err)
+			assert.Equal(t, ca.Intermediate.SubjectKeyId, aki)
+			assert.Equal(t, 0, tt.serial.Cmp(serial))
+		})
+	}
+
+	// Example from RFC 9773, section 4.1.
+	aki, serial, err := ParseCertificateID("aYhba4dGQEHhs3uEe6CuLN4ByNQ.AIdlQyE")
+	require.NoError(t, err)
+	assert.Equal(t, []byte{0x69, 0x88, 0x5b, 0x6b, 0x87, 0x46, 0x40, 0x41, 0xe1, 0xb3, 0x7b, 0x84, 0x7b, 0xa0, 0xae, 0x2c, 0xde, 0x01, 0xc8, 0xd4}, aki)
+	assert.Equal(t, "87654321", serial.Text(16))
+
+	_, err = CertificateID(&x509.Certificate{SerialNumber: big.NewInt(1)})
+	assert.Error(t, err)
+}
+
+func TestParseCertificateID_fail(t *testing.T) {
+	enc := base64.RawURLEncoding.EncodeToString
+	tests := []string{
+		"",
+		"foo",
+		"." + enc([]byte{1}),
+		enc([]byte{1}) + ".",
+		enc([]byte{1}) + ".!!",
+		"!!." + enc([]byte{1}),
+		enc([]byte{1}) + "." + enc([]byte{0x80}),
+		enc([]byte{1}) + "." + enc([]byte{0x00, 0x01}),
+	}
+	for _, id := range tests {
+		t.Run(id, func(t *testing.T) {
+			_, _, err := ParseCertificateID(id)
+			var ae *Error
+			require.True(t, errors.As(err, &ae))
+			assert.Equal(t, http.StatusBadRequest, ae.Status)
+		})
+	}
+}
+
+func TestGetCertificateByID(t *testing.T) {
+	ca, err := minica.New()
+	require.NoError(t, err)
+	leaf := mustRenewalInfoLeaf(t, ca, big.NewInt(1234), time.Now(), time.Hour)
+	id, err := CertificateID(leaf)
+	require.NoError(t, err)
+	otherID := base64.RawURLEncoding.EncodeToString([]byte("other")) + ".BNI"
+
+	tests := []struct {
+		name       string
+		id         string
+		db         DB
+		wantStatus int
+	}{
+		{"ok", id, &MockDB{
+			MockGetCertificateBySerial: func(ctx context.Context, serial string) (*Certificate, error) {
+				assert.Equal(t, "1234", serial)
+				return &Certificate{ID: "certID", Leaf: leaf}, nil
+			},
+		}, 0},
+		{"fail/malformed", "foo", &MockDB{}, http.StatusBadRequest},
+		{"fail/not-found", id, &MockDB{
+			MockGetCertificateBySerial: func(ctx context.Context, serial string) (*Certificate, error) {
+				return nil, NewError(ErrorMalformedType, "certificate with serial %s not found", serial)
+			},
+		}, http.StatusNotFound},
+		{"fail/other-issuer", otherID, &MockDB{
+			MockGetCertificateBySerial: func(ctx context.Context, serial string) (*Certificate, error) {
+				return &Certificate{ID: "certID", Leaf: leaf}, nil
+			},
+		}, http.StatusNotFound},
+		{"fail/db", id, &MockDB{
+			MockError: errors.New("force"),
+		}, http.StatusInternalServerError},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cert, err := GetCertificateByID(context.Background(), tt.db, tt.id)
+			if tt.wantStatus != 0 {
+				var ae *Error
+				require.True(t, errors.As(err, &ae))
+				assert.Equal(t, tt.wantStatus, ae.Status)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, "certID", cert.ID)
+		})
+	}
+}
+
+func TestCertificate_RenewalInfo(t *testing.T) {
+	ca, err := minica.New()
+	require.NoError(t, err)
+	rotated, err := minica.New()
+	require.NoError(t, err)
+
+	now := clock.Now()
+	notBefore := now.Add(-time.Hour)
+	lifetime := 24 * time.Hour
+	leaf := mustRenewalInfoLeaf(t, ca, big.NewInt(1), notBefore, lifetime)
+	start := notBefore.Add(16 * time.Hour).UTC()
+	end := notBefore.Add(20 * time.Hour).UTC()
+
+	tests := []struct {
+		name      string
+		auth      CertificateAuthority
+		wantStart time.Time
+		wantEnd   time.Time
+		wantErr   bool
+	}{
+		{"ok", &mockSignAuth{}, start, end, false},
+		{"ok/same intermediate", &mockRenewalInfoAuth{intermediate: ca.Intermediate}, start, end, false},
+		{"ok/revoked", &mockRenewalInfoAuth{revoked: true}, notBefore.UTC(), now, false},
+		{"ok/rotated", &mockRenewalInfoAuth{intermediate: rotated.Intermediate}, now, end, false},
+		{"fail/isRevoked", &mockRenewalInfoAuth{isRevokedErr: errors.New("force")}, time.Time{}, time.Time{}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ri, err := (&Certificate{Leaf: leaf}).RenewalInfo(tt.auth)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.WithinDuration(t, tt.wantStart, ri.SuggestedWindow.Start, time.Second)
+			assert.WithinDuration(t, tt.wantEnd, ri.SuggestedWindow.End, time.Second)
+			assert.True(t, ri.SuggestedWindow.Start.Before(ri.SuggestedWindow.End) || ri.SuggestedWindow.Start.Equal(ri.SuggestedWindow.End))
+		})
+	}
+}
+
+func TestOrder_ReserveReplaced(t *testing.T) {
+	ca, err := minica.New()
+	require.NoError(t, err)
+	leaf := mustRenewalInfoLeaf(t, ca, big.NewInt(1234), time.Now(), time.Hour)
+	id, err := CertificateID(leaf)
+	require.NoError(t, err)
+
+	// The mock replaces the certificate using compare-and-swap.
+	var replacedBy string
+	db := &MockDB{
+		MockGetCertificateBySerial: func(ctx context.Context, serial string) (*Certificate, error) {
+			return &Certificate{ID: "certID", Leaf: leaf, ReplacedBy: replacedBy}, nil
+		},
+		MockReplaceCertificate: func(ctx context.Context, certID, oldOrderID, orderID string) error {
+			assert.Equal(t, "certID", certID)
+			if replacedBy != oldOrderID {
+				return ErrCertificateReplaced
+			}
+			replacedBy = orderID
+			return nil
+		},
+	}
+
+	o1 := &Order{ID: "ord1", Replaces: id}
+	o2 := &Order{ID: "ord2", Replaces: id}
+	ctx := context.Background()
+	require.NoError(t, o1.ReserveReplaced(ctx, db, ""))
+	assert.Equal(t, "ord1", replacedBy)
+	require.NoError(t, o1.checkReplaced(ctx, db))
+
+	// Only one order can reserve the certificate.
+	err = o2.ReserveReplaced(ctx, db, "")
+	var ae *Error
+	require.ErrorAs(t, err, &ae)
+	assert.Equal(t, NewError(ErrorAlreadyReplacedType, "").Type, ae.Type)
+	require.ErrorAs(t, o2.checkReplaced(ctx, db), &ae)
+	assert.Equal(t, NewError(ErrorAlreadyReplacedType, "").Type, ae.Type)
+
+	// Other orders cannot release the certificate.
+	require.NoError(t, o2.ReleaseReplaced(ctx, db))
+	assert.Equal(t, "ord1", replacedBy)
+	require.NoError(t, o1.ReleaseReplaced(ctx, db))
+	assert.Empty(t, replacedBy)
+
+	// Released certificates can be reserved again.
+	require.NoError(t, o2.checkReplaced(ctx, db))
+	assert.Equal(t, "ord2", replacedBy)
+
+	db.MockReplaceCertificate = func(ctx context.Context, certID, oldOrderID, orderID string) error {
+		return errors.New("force")
+	}
+	assert.Error(t, o1.ReserveReplaced(ctx, db, "ord2"))
+	assert.Error(t, o2.ReleaseReplaced(ctx, db))
+}
+
+func TestOrder_IsReplacing(t *testing.T) {
+	now := clock.Now()
+	tests := []struct {
+		name  string
+		order *Order
+		want  bool
+	}{
+		{"pending", &Order{Status: StatusPending, ExpiresAt: now.Add(time.Hour)}, true},
+		{"ready", &Order{Status: StatusReady, ExpiresAt: now.Add(time.Hour)}, true},
+		{"processing", &Order{Status: StatusProcessing, ExpiresAt: now.Add(-time.Hour)}, true},
+		{"valid", &Order{Status: StatusValid, ExpiresAt: now.Add(-time.Hour)}, true},
+		{"expired", &Order{Status: StatusPending, ExpiresAt: now.Add(-time.Hour)}, false},
+		{"invalid", &Order{Status: StatusInvalid, ExpiresAt: now.Add(time.Hour)}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, tt.order.IsReplacing())
+		})
+	}
+}
@@ -0,0 +1,374 @@
+package acme
+
+import (
+	"context"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"go.step.sm/crypto/randutil"
+
+	"github.com/smallstep/certificates/authority"
+	"github.com/smallstep/certificates/authority/provisioner"
+)
+
+const (
+	// autoRenewalScanInterval is the time between two scans of the
+	// auto-renewal orders in the database.
+	autoRenewalScanInterval = time.Minute
+	// autoRenewalLease is the time an instance of the CA owns the reissue of
+	// the certificate of an auto-renewal order. It must be larger than the
+	// time required to sign the certificate.
+	autoRenewalLease = 2 * time.Minute
+)
+
+// AutoRenewal is the auto-renewal object of an order requesting Short-Term,
+// Automatically Renewed (STAR) certificates as defined in RFC 8739. Once the
+// order is finalized, the CA reissues the certificates of the order until the
+// end date or until the order is canceled.
+type AutoRenewal struct {
+	// StartDate is the earliest date of validity of the first certificate.
+	StartDate time.Time `json:"start-date"`
+	// EndDate is the latest date of validity of the last certificate.
+	EndDate time.Time `json:"end-date"`
+	// Lifetime is the validity of each certificate in seconds.
+	Lifetime int64 `json:"lifetime"`
+	// LifetimeAdjust is the number of seconds each certificate is pre-dated,
+	// so consecutive certificates overlap.
+	LifetimeAdjust int64 `json:"lifetime-adjust,omitempty"`
+	// AllowCertificateGet allows the certificates to be fetched using
+	// unauthenticated GET requests.
+	AllowCertificateGet bool `json:"allow-certificate-get,omitempty"`
+}
+
+// GetLifetime returns the validity of each certificate.
+func (ar *AutoRenewal) GetLifetime() time.Duration {
+	return time.Duration(ar.Lifetime) * time.Second
+}
+
+// GetLifetimeAdjust returns the time each certificate is pre-dated.
+func (ar *AutoRenewal) GetLifetimeAdjust() time.Duration {
+	return time.Duration(ar.LifetimeAdjust) * time.Second
+}
+
+// Validity returns the validity of the certificate in the schedule of the
+// order that covers the given time. The certificates are issued
+// back-to-back, each one valid for the lifetime of the order starting at the
+// start date, pre-dated by the lifetime-adjust, and the last one ends at the
+// end date.
+func (ar *AutoRenewal) Validity(t time.Time) (notBefore, notAfter time.Time) {
+	lifetime := ar.GetLifetime()
+	start := ar.StartDate
+	if t.After(start) {
+		start = start.Add(t.Sub(start) / lifetime * lifetime)
+	}
+	notBefore = start.Add(-ar.GetLifetimeAdjust())
+	notAfter = start.Add(lifetime)
+	if notAfter.After(ar.EndDate) {
+		notAfter = ar.EndDate
+	}
+	return
+}
+
+// AutoRenewalDB is the interface used by the AutoRenewer to find the
+// auto-renewal orders that are being renewed. Currently it provides a runtime
+// assertion only; not at compile time.
+type AutoRenewalDB interface {
+	DB
+	// GetAutoRenewalOrders returns the valid auto-renewal orders. If some
+	// orders cannot be loaded, it returns the rest of them and an error.
+	GetAutoRenewalOrders(ctx context.Context) ([]*Order, error)
+}
+
+// CancelAutoRenewal cancels a valid auto-renewal order, no more certificates
+// will be issued for it, RFC 8739 section 3.1.2.
+func (o *Order) CancelAutoRenewal(ctx context.Context, db DB) error {
+	switch {
+	case o.AutoRenewal == nil:
+		return NewError(ErrorAutoRenewalCancellationInvalidType, "order %s is not an auto-renewal order", o.ID)
+	case o.Status != StatusValid:
+		return NewError(ErrorAutoRenewalCancellationInvalidType, "order %s cannot be canceled in the %s state", o.ID, o.Status)
+	}
+
+	o.Status = StatusCanceled
+	if err := db.UpdateOrder(ctx, o); err != nil {
+		return WrapErrorISE(err, "error updating order %s", o.ID)
+	}
+	return nil
+}
+
+// Reissue issues the next certificate of a valid auto-renewal order if it
+// must be valid before the given time. The certificate is stored
+// and becomes the current certificate of the order. It returns nil if the
+// order does not need a new certificate, or if another instance of the CA is
+// reissuing it. The account, its external account binding key and the
+// authorizations of the order are checked before issuing each certificate.
+func (o *Order) Reissue(ctx context.Context, db DB, auth CertificateAuthority, p Provisioner, before time.Time) (*Certificate, error) {
+	if o.AutoRenewal == nil || o.Status != StatusValid {
+		return nil, nil
+	}
+
+	current, err := db.GetCertificate(ctx, o.CertificateID)
+	if err != nil {
+		return nil, WrapErrorISE(err, "error retrieving certificate %s", o.CertificateID)
+	}
+	// The last certificate has been issued.
+	endDate := o.autoRenewalEndDate(p)
+	if !current.Leaf.NotAfter.Before(endDate) {
+		return nil, nil
+	}
+	// The next certificate must be valid before the current one expires,
+	// taking into account the lifetime-adjust.
+	if before.Before(current.Leaf.NotAfter.Add(-o.AutoRenewal.GetLifetimeAdjust())) {
+		return nil, nil
+	}
+
+	// Claim the reissue of the current certificate, so only one instance of
+	// the CA signs the next one. The order might have been canceled or
+	// renewed since it was loaded.
+	owner, err := randutil.UUIDv4()
+	if err != nil {
+		return nil, WrapErrorISE(err, "error generating lock owner")
+	}
+	now := clock.Now()
+	locked, err := db.LockOrderRenewal(ctx, o.ID, owner, now.Add(autoRenewalLease))
+	switch {
+	case errors.Is(err, ErrOrderRenewalLocked):
+		return nil, nil
+	case err != nil:
+		return nil, WrapErrorISE(err, "error locking renewal of order %s", o.ID)
+	case locked.Status != StatusValid || locked.CertificateID != o.CertificateID:
+		o.Status, o.CertificateID = locked.Status, locked.CertificateID
+		return nil, nil
+	}
+
+	if err := o.checkReissue(ctx, db, p); err != nil {
+		return nil, err
+	}
+
+	// If the current certificate has already expired, the next one covers
+	// the current time.
+	start := current.Leaf.NotAfter
+	if now.After(start) {
+		start = now
+	}
+	notBefore, notAfter := o.AutoRenewal.Validity(start)
+	if notAfter.After(endDate) {
+		notAfter = endDate
+	}
+
+	csr, err := x509.ParseCertificateRequest(o.CSR)
+	if err != nil {
+		return nil, WrapErrorISE(err, "error parsing certificate request of order %s", o.ID)
+	}
+	cert, err := o.sign(ctx, db, csr, auth, p, "", notBefore, notAfter)
+	if err != nil {
+		return nil, err
+	}
+
+	o.CertificateID = cert.ID
+	if err := db.UpdateOrder(ctx, o); err != nil {
+		return nil, WrapErrorISE(err, "error updating order %s", o.ID)
+	}
+	return cert, nil
+}
+
+// autoRenewalEndDate returns the end date of the auto-renewal order limited by
+// the maximum duration of auto-renewal orders in the provisioner. The maximum
+// duration is checked when the order is created, but it might have been
+// lowered afterwards. If auto-renewal orders are no longer enabled in the
+// provisioner, no more certificates are issued.
+func (o *Order) autoRenewalEndDate(p Provisioner) time.Time {
+	endDate := o.AutoRenewal.EndDate
+	if acmeProv, ok := p.(*provisioner.ACME); ok {
+		if maxEndDate := o.AutoRenewal.StartDate.Add(acmeProv.AutoRenewal.GetMaxDuration()); maxEndDate.Before(endDate) {
+			endDate = maxEndDate
+		}
+	}
+	return endDate
+}
+
+// checkReissue returns an error if the certificate of the order cannot be
+// reissued. The account must be valid, the external account binding key it
+// was created with, if any, must be enabled and not expired, and the
+// authorizations of the order must be valid. Accounts and authorizations
+// cannot become valid again, so the order is canceled if they are not.
+func (o *Order) checkReissue(ctx context.Context, db DB, p Provisioner) error {
+	acc, err := db.GetAccount(ctx, o.AccountID)
+	if err != nil {
+		return WrapErrorISE(err, "error retrieving account %s", o.AccountID)
+	}
+	if acc.Status != StatusValid {
+		return o.cancelReissue(ctx, db, "account %s is %s", acc.ID, acc.Status)
+	}
+
+	if acmeProv, ok := p.(*provisioner.ACME); ok && acmeProv.RequireEAB {
+		eak, err := db.GetExternalAccountKeyByAccountID(ctx, p.GetID(), acc.ID)
+		switch {
+		case err != nil:
+			return WrapErrorISE(err, "error retrieving external account binding key of account %s", acc.ID)
+		case eak == nil:
+		case eak.Disabled:
+			return NewError(ErrorUnauthorizedType, "external account binding key of account %s is disabled", acc.ID)
+		case eak.IsExpired(clock.Now()):
+			return NewError(ErrorUnauthorizedType, "external account binding key of account %s expired on %s", acc.ID, eak.NotAfter)
+		}
+	}
+
+	for _, id := range o.AuthorizationIDs {
+		az, err := db.GetAuthorization(ctx, id)
+		if err != nil {
+			return WrapErrorISE(err, "error retrieving authorization %s", id)
+		}
+		if az.Status != StatusValid {
+			return o.cancelReissue(ctx, db, "authorization %s is %s", az.ID, az.Status)
+		}
+	}
+	return nil
+}
+
+// cancelReissue cancels the auto-renewal order and returns an unauthorized
+// error with the given reason.
+func (o *Order) cancelReissue(ctx context.Context, db DB, format string, args ...any) error {
+	o.Status = StatusCanceled
+	if err := db.UpdateOrder(ctx, o); err != nil {
+		return WrapErrorISE(err, "error updating order %s", o.ID)
+	}
+	return NewError(ErrorUnauthorizedType, format+", order %s has been canceled", append(args, o.ID)...)
+}
+
+// AutoRenewer periodically reissues the certificates of the valid
+// auto-renewal orders. Certificates are issued before the current ones
+// expire, so the clients can always fetch a valid one.
+type AutoRenewer struct {
+	// LoadProvisioner returns the ACME provisioner with the given id. If not
+	// set, the provisioner is loaded from the authority in the context.
+	LoadProvisioner func(ctx context.Context, id string) (Provisioner, error)
+	// OnError, if set, is called when the certificate of an order cannot be
+	// reissued.
+	OnError  func(o *Order, err error)
+	auth     CertificateAuthority
+	stop     chan struct{}
+	stopOnce sync.Once
+	wg       sync.WaitGroup
+}
+
+// NewAutoRenewer creates a new AutoRenewer that signs the certificates using
+// the given authority.
+func NewAutoRenewer(auth CertificateAuthority) *AutoRenewer {
+	return &AutoRenewer{
+		LoadProvisioner: loadProvisionerByID,
+		auth:            auth,
+		stop:            make(chan struct{}),
+	}
+}
+
+// Start starts reissuing the certificates of the auto-renewal orders. The
+// given context must contain the ACME database, and the authority if the
+// default LoadProvisioner is used.
+func (r *AutoRenewer) Start(ctx context.Context) {
+	r.wg.Add(1)
+	go func() {
+		defer r.wg.Done()
+		ticker := time.NewTicker(autoRenewalScanInterval)
+		defer ticker.Stop()
+		for {
+			r.Renew(ctx)
+			select {
+			case <-r.stop:
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+// Stop stops the AutoRenewer.
+func (r *AutoRenewer) Stop() {
+	r.stopOnce.Do(func() {
+		close(r.stop)
+	})
+	r.wg.Wait()
+}
+
+// Renew reissues the certificates of the auto-renewal orders starting before
+// the next scan, and returns the number of certificates issued.
+func (r *AutoRenewer) Renew(ctx context.Context) int {
+	db, ok := MustDatabaseFromContext(ctx).(AutoRenewalDB)
+	if !ok {
+		return 0
+	}
+	// The orders that cannot be loaded are reported, the rest are renewed.
+	orders, err := db.GetAutoRenewalOrders(ctx)
+	if err != nil {
+		r.onError(nil, err)
+	}
+
+	var n int
+	before := clock.Now().Add(autoRenewalScanInterval)
+	for _, o := range orders {
+		p, err := r.LoadProvisioner(ctx, o.ProvisionerID)
+		if err != nil {
+			r.onError(o, err)
+			continue
+		}
+		cert, err := o.Reissue(NewProvisionerContext(ctx, p), db, r.auth, p, before)
+		switch {
+		case err != nil:
+			r.onError(o, err)
+		case cert != nil:
+			n++
+		}
+	}
+	return n
+}
+
+func (r *AutoRenewer) onError(o *Order, err error) {
+	if r.OnError != nil {
+		r.OnError(o, err)
+	}
+}
+
+func loadProvisionerByID(ctx context.Context, id string) (Provisioner, error) {
+	p, err := authority.MustFromContext(ctx).LoadProvisionerByID(id)
+	if err != nil {
+		return nil, err
+	}
+	acmeProv, ok := p.(*provisioner.ACME)
+	if !ok {
+		return nil, fmt.Errorf("provisioner %s is not an ACME provisioner", id)
+	}
+	return acmeProv, nil
+}
+
+// checkAutoRenewalCertificate returns an error if the current certificate of
+// the order cannot be served at the star-certificate URL.
+func (o *Order) checkAutoRenewalCertificate() error {
+	switch {
+	case o.AutoRenewal == nil:
+		return NewError(ErrorMalformedType, "order %s is not an auto-renewal order", o.ID)
+	case o.Status == StatusCanceled:
+		return NewError(ErrorAutoRenewalCanceledType, "order %s has been canceled", o.ID)
+	case !clock.Now().Before(o.AutoRenewal.EndDate):
+		return NewError(ErrorAutoRenewalExpiredType, "order %s has expired", o.ID)
+	case o.Status != StatusValid:
+		return NewError(ErrorOrderNotReadyType, "order %s is not valid", o.ID)
+	default:
+		return nil
+	}
+}
+
+// GetAutoRenewalCertificate returns the current certificate of a valid
+// auto-renewal order.
+func (o *Order) GetAutoRenewalCertificate(ctx context.Context, db DB) (*Certificate, error) {
+	if err := o.checkAutoRenewalCertificate(); err != nil {
+		return nil, err
+	}
+	cert, err := db.GetCertificate(ctx, o.CertificateID)
+	if err != nil {
+		return nil, WrapErrorISE(err, "error retrieving certificate %s", o.CertificateID)
+	}
+	return cert, nil
+}
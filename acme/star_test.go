@@ -0,0 +1,674 @@
+package acme
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/smallstep/certificates/authority/config"
+	"github.com/smallstep/certificates/authority/provisioner"
+)
+
+func TestAutoRenewal_Validity(t *testing.T) {
+	start := time.Date(2026, 10, 16, 0, 0, 0, 0, time.UTC)
+	ar := &AutoRenewal{
+		StartDate:      start,
+		EndDate:        start.Add(10 * time.Hour),
+		Lifetime:       4 * 3600,
+		LifetimeAdjust: 600,
+	}
+
+	tests := []struct {
+		name          string
+		t             time.Time
+		wantNotBefore time.Time
+		wantNotAfter  time.Time
+	}{
+		{"before start", start.Add(-time.Hour), start.Add(-10 * time.Minute), start.Add(4 * time.Hour)},
+		{"first", start.Add(time.Hour), start.Add(-10 * time.Minute), start.Add(4 * time.Hour)},
+		{"second", start.Add(4 * time.Hour), start.Add(4*time.Hour - 10*time.Minute), start.Add(8 * time.Hour)},
+		{"last", start.Add(9 * time.Hour), start.Add(8*time.Hour - 10*time.Minute), start.Add(10 * time.Hour)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			notBefore, notAfter := ar.Validity(tt.t)
+			assert.Equal(t, tt.wantNotBefore, notBefore)
+			assert.Equal(t, tt.wantNotAfter, notAfter)
+		})
+	}
+}
+
+func TestOrder_CancelAutoRenewal(t *testing.T) {
+	var updated *Order
+	db := &MockDB{
+		MockUpdateOrder: func(_ context.Context, o *Order) error {
+			updated = o
+			return nil
+		},
+	}
+
+	o := &Order{ID: "oID", Status: StatusValid, AutoRenewal: &AutoRenewal{Lifetime: 3600}}
+	require.NoError(t, o.CancelAutoRenewal(context.Background(), db))
+	assert.Equal(t, StatusCanceled, o.Status)
+	assert.Equal(t, o, updated)
+
+	// Only valid auto-renewal orders can be canceled.
+	for _, o := range []*Order{
+		{ID: "oID", Status: StatusValid},
+		{ID: "oID", Status: StatusReady, AutoRenewal: &AutoRenewal{Lifetime: 3600}},
+		{ID: "oID", Status: StatusCanceled, AutoRenewal: &AutoRenewal{Lifetime: 3600}},
+	} {
+		err := o.CancelAutoRenewal(context.Background(), db)
+		var acmeErr *Error
+		require.ErrorAs(t, err, &acmeErr)
+		assert.Equal(t, "urn:ietf:params:acme:error:autoRenewalCancellationInvalid", acmeErr.Type)
+	}
+
+	o = &Order{ID: "oID", Status: StatusValid, AutoRenewal: &AutoRenewal{Lifetime: 3600}}
+	assert.Error(t, o.CancelAutoRenewal(context.Background(), &MockDB{MockError: errors.New("force")}))
+}
+
+func newAutoRenewalOrder(t *testing.T, now time.Time) (*Order, *x509.CertificateRequest) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	b, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{
+		Subject:  pkix.Name{CommonName: "cdn.example.com"},
+		DNSNames: []string{"cdn.example.com"},
+	}, key)
+	require.NoError(t, err)
+	csr, err := x509.ParseCertificateRequest(b)
+	require.NoError(t, err)
+
+	return &Order{
+		ID:               "oID",
+		AccountID:        "accID",
+		ProvisionerID:    "provID",
+		Status:           StatusReady,
+		ExpiresAt:        now.Add(time.Hour),
+		AuthorizationIDs: []string{"azID"},
+		Identifiers:      []Identifier{{Type: DNS, Value: "cdn.example.com"}},
+		AutoRenewal: &AutoRenewal{
+			StartDate:      now.Add(-time.Hour),
+			EndDate:        now.Add(23 * time.Hour),
+			Lifetime:       8 * 3600,
+			LifetimeAdjust: 300,
+		},
+	}, csr
+}
+
+func TestOrder_Finalize_autoRenewal(t *testing.T) {
+	now := clock.Now().Truncate(time.Second)
+	o, csr := newAutoRenewalOrder(t, now)
+
+	var signOpts provisioner.SignOptions
+	ca := &mockSignAuth{
+		signWithContext: func(_ context.Context, _ *x509.CertificateRequest, opts provisioner.SignOptions, _ ...provisioner.SignOption) ([]*x509.Certificate, error) {
+			signOpts = opts
+			return []*x509.Certificate{{NotBefore: opts.NotBefore.Time(), NotAfter: opts.NotAfter.Time()}, {}}, nil
+		},
+	}
+	prov := &MockProvisioner{
+		MauthorizeSign: func(context.Context, string) ([]provisioner.SignOption, error) {
+			return nil, nil
+		},
+		MgetOptions: func() *provisioner.Options {
+			return nil
+		},
+	}
+	var created *Certificate
+	db := &MockDB{
+		MockGetAuthorization: func(_ context.Context, id string) (*Authorization, error) {
+			return &Authorization{ID: id, Status: StatusValid}, nil
+		},
+		MockCreateCertificate: func(_ context.Context, cert *Certificate) error {
+			cert.ID = "certID"
+			created = cert
+			return nil
+		},
+		MockUpdateOrder: func(context.Context, *Order) error {
+			return nil
+		},
+	}
+
+	require.NoError(t, o.Finalize(context.Background(), db, csr, ca, prov))
+	assert.Equal(t, StatusValid, o.Status)
+	assert.Equal(t, "certID", o.CertificateID)
+	assert.Equal(t, csr.Raw, o.CSR)
+	assert.True(t, created.AutoRenewal)
+	// The first certificate covers the current time.
+	assert.Equal(t, now.Add(-time.Hour-5*time.Minute), signOpts.NotBefore.Time())
+	assert.Equal(t, now.Add(7*time.Hour), signOpts.NotAfter.Time())
+
+	// Orders cannot be finalized after the end date.
+	o, csr = newAutoRenewalOrder(t, now)
+	o.AutoRenewal.EndDate = now.Add(-time.Minute)
+	err := o.Finalize(context.Background(), db, csr, ca, prov)
+	var acmeErr *Error
+	require.ErrorAs(t, err, &acmeErr)
+	assert.Equal(t, "urn:ietf:params:acme:error:autoRenewalExpired", acmeErr.Type)
+}
+
+func TestOrder_Reissue(t *testing.T) {
+	now := clock.Now().Truncate(time.Second)
+	o, csr := newAutoRenewalOrder(t, now)
+	o.Status = StatusValid
+	o.CertificateID = "cert1"
+	o.CSR = csr.Raw
+
+	certs := map[string]*Certificate{
+		"cert1": {ID: "cert1", Leaf: &x509.Certificate{
+			NotBefore: now.Add(-time.Hour - 5*time.Minute),
+			NotAfter:  now.Add(7 * time.Hour),
+		}},
+	}
+	var signed int
+	ca := &mockSignAuth{
+		signWithContext: func(_ context.Context, _ *x509.CertificateRequest, opts provisioner.SignOptions, _ ...provisioner.SignOption) ([]*x509.Certificate, error) {
+			signed++
+			return []*x509.Certificate{{NotBefore: opts.NotBefore.Time(), NotAfter: opts.NotAfter.Time()}, {}}, nil
+		},
+	}
+	prov := &MockProvisioner{
+		MauthorizeSign: func(context.Context, string) ([]provisioner.SignOption, error) {
+			return nil, nil
+		},
+		MgetOptions: func() *provisioner.Options {
+			return nil
+		},
+	}
+	db := &MockDB{
+		MockGetAccount: func(_ context.Context, id string) (*Account, error) {
+			return &Account{ID: id, Status: StatusValid}, nil
+		},
+		MockGetAuthorization: func(_ context.Context, id string) (*Authorization, error) {
+			return &Authorization{ID: id, Status: StatusValid}, nil
+		},
+		MockGetCertificate: func(_ context.Context, id string) (*Certificate, error) {
+			return certs[id], nil
+		},
+		MockCreateCertificate: func(_ context.Context, cert *Certificate) error {
+			cert.ID = "cert2"
+			certs[cert.ID] = cert
+			return nil
+		},
+		MockLockOrderRenewal: lockOrderRenewal(o),
+		MockUpdateOrder: func(context.Context, *Order) error {
+			return nil
+		},
+	}
+	ctx := context.Background()
+
+	// The next certificate is not due yet.
+	cert, err := o.Reissue(ctx, db, ca, prov, now.Add(time.Hour))
+	require.NoError(t, err)
+	assert.Nil(t, cert)
+	assert.Equal(t, 0, signed)
+
+	// The next certificate starts at the end of the current one.
+	cert, err = o.Reissue(ctx, db, ca, prov, now.Add(7*time.Hour))
+	require.NoError(t, err)
+	require.NotNil(t, cert)
+	assert.Equal(t, "cert2", o.CertificateID)
+	assert.True(t, cert.AutoRenewal)
+	assert.Equal(t, now.Add(7*time.Hour-5*time.Minute), cert.Leaf.NotBefore)
+	assert.Equal(t, now.Add(15*time.Hour), cert.Leaf.NotAfter)
+
+	// The last certificate ends at the end date.
+	cert, err = o.Reissue(ctx, db, ca, prov, now.Add(15*time.Hour))
+	require.NoError(t, err)
+	require.NotNil(t, cert)
+	assert.Equal(t, o.AutoRenewal.EndDate, cert.Leaf.NotAfter)
+
+	// No more certificates are issued.
+	cert, err = o.Reissue(ctx, db, ca, prov, now.Add(24*time.Hour))
+	require.NoError(t, err)
+	assert.Nil(t, cert)
+	assert.Equal(t, 2, signed)
+
+	// Canceled orders are not renewed.
+	o.Status = StatusCanceled
+	cert, err = o.Reissue(ctx, db, ca, prov, now.Add(24*time.Hour))
+	require.NoError(t, err)
+	assert.Nil(t, cert)
+}
+
+func TestOrder_Reissue_expired(t *testing.T) {
+	now := clock.Now().Truncate(time.Second)
+	o, csr := newAutoRenewalOrder(t, now)
+	o.Status, o.CertificateID, o.CSR = StatusValid, "cert1", csr.Raw
+	o.AutoRenewal.StartDate = now.Add(-20 * time.Hour)
+
+	ca := &mockSignAuth{
+		signWithContext: func(_ context.Context, _ *x509.CertificateRequest, opts provisioner.SignOptions, _ ...provisioner.SignOption) ([]*x509.Certificate, error) {
+			return []*x509.Certificate{{NotBefore: opts.NotBefore.Time(), NotAfter: opts.NotAfter.Time()}, {}}, nil
+		},
+	}
+	prov := &MockProvisioner{
+		MauthorizeSign: func(context.Context, string) ([]provisioner.SignOption, error) {
+			return nil, nil
+		},
+		MgetOptions: func() *provisioner.Options {
+			return nil
+		},
+	}
+	db := &MockDB{
+		MockGetAccount: func(_ context.Context, id string) (*Account, error) {
+			return &Account{ID: id, Status: StatusValid}, nil
+		},
+		MockGetAuthorization: func(_ context.Context, id string) (*Authorization, error) {
+			return &Authorization{ID: id, Status: StatusValid}, nil
+		},
+		MockGetCertificate: func(_ context.Context, id string) (*Certificate, error) {
+			// The first certificate expired while the CA was down.
+			return &Certificate{ID: id, Leaf: &x509.Certificate{
+				NotBefore: now.Add(-20*time.Hour - 5*time.Minute),
+				NotAfter:  now.Add(-12 * time.Hour),
+			}}, nil
+		},
+		MockCreateCertificate: func(_ context.Context, cert *Certificate) error {
+			cert.ID = "cert2"
+			return nil
+		},
+		MockLockOrderRenewal: lockOrderRenewal(o),
+		MockUpdateOrder: func(context.Context, *Order) error {
+			return nil
+		},
+	}
+
+	// The next certificate covers the current time.
+	cert, err := o.Reissue(context.Background(), db, ca, prov, now)
+	require.NoError(t, err)
+	require.NotNil(t, cert)
+	assert.Equal(t, now.Add(-4*time.Hour-5*time.Minute), cert.Leaf.NotBefore)
+	assert.Equal(t, now.Add(4*time.Hour), cert.Leaf.NotAfter)
+}
+
+func TestOrder_Reissue_checks(t *testing.T) {
+	now := clock.Now().Truncate(time.Second)
+	acmeProv := &provisioner.ACME{Type: "ACME", Name: "acme", RequireEAB: true, AutoRenewal: &provisioner.ACMEAutoRenewal{}}
+	require.NoError(t, acmeProv.Init(provisioner.Config{Claims: config.GlobalProvisionerClaims}))
+
+	tests := []struct {
+		name         string
+		account      *Account
+		accountErr   error
+		eak          *ExternalAccountKey
+		authzStatus  Status
+		wantErr      string
+		wantCanceled bool
+	}{
+		{"ok", &Account{ID: "accID", Status: StatusValid}, nil, &ExternalAccountKey{ID: "eakID"}, StatusValid, "", false},
+		{"ok/no-eak", &Account{ID: "accID", Status: StatusValid}, nil, nil, StatusValid, "", false},
+		{"fail/account", nil, errors.New("force"), nil, StatusValid, "error retrieving account accID", false},
+		{"fail/account-deactivated", &Account{ID: "accID", Status: StatusDeactivated}, nil, nil, StatusValid, "account accID is deactivated, order oID has been canceled", true},
+		{"fail/eak-disabled", &Account{ID: "accID", Status: StatusValid}, nil, &ExternalAccountKey{ID: "eakID", Disabled: true}, StatusValid, "external account binding key of account accID is disabled", false},
+		{"fail/eak-expired", &Account{ID: "accID", Status: StatusValid}, nil, &ExternalAccountKey{ID: "eakID", NotAfter: now.Add(-time.Minute)}, StatusValid, "external account binding key of account accID expired", false},
+		{"fail/authz-deactivated", &Account{ID: "accID", Status: StatusValid}, nil, nil, StatusDeactivated, "authorization azID is deactivated, order oID has been canceled", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			o, csr := newAutoRenewalOrder(t, now)
+			o.Status, o.CertificateID, o.CSR = StatusValid, "cert1", csr.Raw
+
+			var signed bool
+			ca := &mockSignAuth{
+				signWithContext: func(_ context.Context, _ *x509.CertificateRequest, opts provisioner.SignOptions, _ ...provisioner.SignOption) ([]*x509.Certificate, error) {
+					signed = true
+					return []*x509.Certificate{{NotBefore: opts.NotBefore.Time(), NotAfter: opts.NotAfter.Time()}, {}}, nil
+				},
+			}
+			var updated *Order
+			db := &MockDB{
+				MockGetAccount: func(_ context.Context, id string) (*Account, error) {
+					assert.Equal(t, "accID", id)
+					return tt.account, tt.accountErr
+				},
+				MockGetExternalAccountKeyByAccountID: func(_ context.Context, provisionerID, accountID string) (*ExternalAccountKey, error) {
+					assert.Equal(t, acmeProv.GetID(), provisionerID)
+					assert.Equal(t, "accID", accountID)
+					return tt.eak, nil
+				},
+				MockGetAuthorization: func(_ context.Context, id string) (*Authorization, error) {
+					return &Authorization{ID: id, Status: tt.authzStatus}, nil
+				},
+				MockGetCertificate: func(_ context.Context, id string) (*Certificate, error) {
+					return &Certificate{ID: id, Leaf: &x509.Certificate{NotAfter: now.Add(7 * time.Hour)}}, nil
+				},
+				MockCreateCertificate: func(_ context.Context, cert *Certificate) error {
+					cert.ID = "cert2"
+					return nil
+				},
+				MockLockOrderRenewal: lockOrderRenewal(o),
+				MockUpdateOrder: func(_ context.Context, o *Order) error {
+					updated = o
+					return nil
+				},
+			}
+
+			cert, err := o.Reissue(context.Background(), db, ca, acmeProv, now.Add(7*time.Hour))
+			if tt.wantErr != "" {
+				require.Error(t, err)
+				assert.Contains(t, err.Error(), tt.wantErr)
+				assert.Nil(t, cert)
+				assert.False(t, signed)
+				if tt.wantCanceled {
+					require.NotNil(t, updated)
+					assert.Equal(t, StatusCanceled, updated.Status)
+				} else {
+					assert.Nil(t, updated)
+					assert.Equal(t, StatusValid, o.Status)
+				}
+				return
+			}
+			require.NoError(t, err)
+			require.NotNil(t, cert)
+			assert.True(t, signed)
+			assert.Equal(t, StatusValid, o.Status)
+		})
+	}
+}
+
+func TestOrder_Reissue_lock(t *testing.T) {
+	now := clock.Now().Truncate(time.Second)
+
+	tests := []struct {
+		name       string
+		lock       func(o *Order) (*Order, error)
+		wantSigned bool
+		wantStatus Status
+		wantErr    string
+	}{
+		{"ok", func(o *Order) (*Order, error) {
+			locked := *o
+			return &locked, nil
+		}, true, StatusValid, ""},
+		{"ok/locked", func(o *Order) (*Order, error) {
+			return nil, ErrOrderRenewalLocked
+		}, false, StatusValid, ""},
+		{"ok/canceled", func(o *Order) (*Order, error) {
+			locked := *o
+			locked.Status = StatusCanceled
+			return &locked, nil
+		}, false, StatusCanceled, ""},
+		{"ok/renewed", func(o *Order) (*Order, error) {
+			locked := *o
+			locked.CertificateID = "cert2"
+			return &locked, nil
+		}, false, StatusValid, ""},
+		{"fail/lock", func(o *Order) (*Order, error) {
+			return nil, errors.New("force")
+		}, false, StatusValid, "error locking renewal of order oID"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			o, csr := newAutoRenewalOrder(t, now)
+			o.Status, o.CertificateID, o.CSR = StatusValid, "cert1", csr.Raw
+
+			var signed bool
+			ca := &mockSignAuth{
+				signWithContext: func(_ context.Context, _ *x509.CertificateRequest, opts provisioner.SignOptions, _ ...provisioner.SignOption) ([]*x509.Certificate, error) {
+					signed = true
+					return []*x509.Certificate{{NotBefore: opts.NotBefore.Time(), NotAfter: opts.NotAfter.Time()}, {}}, nil
+				},
+			}
+			prov := &MockProvisioner{
+				MauthorizeSign: func(context.Context, string) ([]provisioner.SignOption, error) {
+					return nil, nil
+				},
+				MgetOptions: func() *provisioner.Options {
+					return nil
+				},
+			}
+			db := &MockDB{
+				MockGetAccount: func(_ context.Context, id string) (*Account, error) {
+					return &Account{ID: id, Status: StatusValid}, nil
+				},
+				MockGetAuthorization: func(_ context.Context, id string) (*Authorization, error) {
+					return &Authorization{ID: id, Status: StatusValid}, nil
+				},
+				MockGetCertificate: func(_ context.Context, id string) (*Certificate, error) {
+					return &Certificate{ID: id, Leaf: &x509.Certificate{NotAfter: now.Add(7 * time.Hour)}}, nil
+				},
+				MockCreateCertificate: func(_ context.Context, cert *Certificate) error {
+					cert.ID = "cert2"
+					return nil
+				},
+				MockLockOrderRenewal: func(_ context.Context, orderID, owner string, until time.Time) (*Order, error) {
+					assert.Equal(t, "oID", orderID)
+					assert.NotEmpty(t, owner)
+					assert.WithinDuration(t, now.Add(autoRenewalLease), until, time.Minute)
+					return tt.lock(o)
+				},
+				MockUpdateOrder: func(context.Context, *Order) error {
+					return nil
+				},
+			}
+
+			cert, err := o.Reissue(context.Background(), db, ca, prov, now.Add(7*time.Hour))
+			if tt.wantErr != "" {
+				assert.ErrorContains(t, err, tt.wantErr)
+			} else {
+				assert.NoError(t, err)
+			}
+			assert.Equal(t, tt.wantSigned, signed)
+			assert.Equal(t, tt.wantSigned, cert != nil)
+			assert.Equal(t, tt.wantStatus, o.Status)
+		})
+	}
+}
+
+func TestOrder_Reissue_maxDuration(t *testing.T) {
+	now := clock.Now().Truncate(time.Second)
+
+	tests := []struct {
+		name         string
+		autoRenewal  *provisioner.ACMEAutoRenewal
+		wantNotAfter time.Time
+	}{
+		{"ok", &provisioner.ACMEAutoRenewal{}, now.Add(15 * time.Hour)},
+		{"ok/max-duration-lowered", &provisioner.ACMEAutoRenewal{
+			MaxDuration: &provisioner.Duration{Duration: 10 * time.Hour},
+		}, now.Add(9 * time.Hour)},
+		{"ok/max-duration-reached", &provisioner.ACMEAutoRenewal{
+			MaxDuration: &provisioner.Duration{Duration: 8 * time.Hour},
+		}, time.Time{}},
+		{"ok/disabled", nil, time.Time{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			acmeProv := &provisioner.ACME{Type: "ACME", Name: "acme", AutoRenewal: tt.autoRenewal}
+			require.NoError(t, acmeProv.Init(provisioner.Config{Claims: config.GlobalProvisionerClaims}))
+
+			o, csr := newAutoRenewalOrder(t, now)
+			o.Status, o.CertificateID, o.CSR = StatusValid, "cert1", csr.Raw
+
+			ca := &mockSignAuth{
+				signWithContext: func(_ context.Context, _ *x509.CertificateRequest, opts provisioner.SignOptions, _ ...provisioner.SignOption) ([]*x509.Certificate, error) {
+					return []*x509.Certificate{{NotBefore: opts.NotBefore.Time(), NotAfter: opts.NotAfter.Time()}, {}}, nil
+				},
+			}
+			db := &MockDB{
+				MockGetAccount: func(_ context.Context, id string) (*Account, error) {
+					return &Account{ID: id, Status: StatusValid}, nil
+				},
+				MockGetAuthorization: func(_ context.Context, id string) (*Authorization, error) {
+					return &Authorization{ID: id, Status: StatusValid}, nil
+				},
+				MockGetCertificate: func(_ context.Context, id string) (*Certificate, error) {
+					return &Certificate{ID: id, Leaf: &x509.Certificate{NotAfter: now.Add(7 * time.Hour)}}, nil
+				},
+				MockCreateCertificate: func(_ context.Context, cert *Certificate) error {
+					cert.ID = "cert2"
+					return nil
+				},
+				MockLockOrderRenewal: lockOrderRenewal(o),
+				MockUpdateOrder: func(context.Context, *Order) error {
+					return nil
+				},
+			}
+
+			cert, err := o.Reissue(context.Background(), db, ca, acmeProv, now.Add(7*time.Hour))
+			require.NoError(t, err)
+			if tt.wantNotAfter.IsZero() {
+				assert.Nil(t, cert)
+				return
+			}
+			require.NotNil(t, cert)
+			assert.Equal(t, tt.wantNotAfter, cert.Leaf.NotAfter)
+		})
+	}
+}
+
+// lockOrderRenewal returns a MockLockOrderRenewal function that locks the
+// given orders.
+func lockOrderRenewal(orders ...*Order) func(context.Context, string, string, time.Time) (*Order, error) {
+	return func(_ context.Context, id, _ string, _ time.Time) (*Order, error) {
+		for _, o := range orders {
+			if o.ID == id {
+				locked := *o
+				return &locked, nil
+			}
+		}
+		return nil, errors.New("order not found")
+	}
+}
+
+type mockAutoRenewalDB struct {
+	MockDB
+	orders []*Order
+	err    error
+}
+
+func (m *mockAutoRenewalDB) GetAutoRenewalOrders(context.Context) ([]*Order, error) {
+	return m.orders, m.err
+}
+
+func TestAutoRenewer_Renew(t *testing.T) {
+	now := clock.Now().Truncate(time.Second)
+	o1, csr := newAutoRenewalOrder(t, now)
+	o1.Status, o1.CertificateID, o1.CSR = StatusValid, "cert1", csr.Raw
+	o2, _ := newAutoRenewalOrder(t, now)
+	o2.ID, o2.Status, o2.CertificateID, o2.CSR = "oID2", StatusValid, "cert2", csr.Raw
+	o3, _ := newAutoRenewalOrder(t, now)
+	o3.ID, o3.ProvisionerID = "oID3", "missing"
+
+	db := &mockAutoRenewalDB{
+		MockDB: MockDB{
+			MockGetAccount: func(_ context.Context, id string) (*Account, error) {
+				return &Account{ID: id, Status: StatusValid}, nil
+			},
+			MockGetAuthorization: func(_ context.Context, id string) (*Authorization, error) {
+				return &Authorization{ID: id, Status: StatusValid}, nil
+			},
+			MockGetCertificate: func(_ context.Context, id string) (*Certificate, error) {
+				// The first certificate expires before the next scan.
+				notAfter := now.Add(30 * time.Second)
+				if id == "cert2" {
+					notAfter = now.Add(time.Hour)
+				}
+				return &Certificate{ID: id, Leaf: &x509.Certificate{NotAfter: notAfter}}, nil
+			},
+			MockCreateCertificate: func(_ context.Context, cert *Certificate) error {
+				cert.ID = "cert3"
+				return nil
+			},
+			MockLockOrderRenewal: lockOrderRenewal(o1, o2, o3),
+			MockUpdateOrder: func(context.Context, *Order) error {
+				return nil
+			},
+		},
+		orders: []*Order{o1, o2, o3},
+		// Orders that cannot be loaded are reported.
+		err: errors.New("error loading auto-renewal order oID4"),
+	}
+	ca := &mockSignAuth{
+		signWithContext: func(_ context.Context, _ *x509.CertificateRequest, opts provisioner.SignOptions, _ ...provisioner.SignOption) ([]*x509.Certificate, error) {
+			return []*x509.Certificate{{NotBefore: opts.NotBefore.Time(), NotAfter: opts.NotAfter.Time()}, {}}, nil
+		},
+	}
+	prov := &MockProvisioner{
+		MauthorizeSign: func(context.Context, string) ([]provisioner.SignOption, error) {
+			return nil, nil
+		},
+		MgetOptions: func() *provisioner.Options {
+			return nil
+		},
+	}
+
+	var failed []string
+	var loadErr error
+	r := NewAutoRenewer(ca)
+	r.LoadProvisioner = func(_ context.Context, id string) (Provisioner, error) {
+		if id != "provID" {
+			return nil, errors.New("not found")
+		}
+		return prov, nil
+	}
+	r.OnError = func(o *Order, err error) {
+		if o == nil {
+			loadErr = err
+			return
+		}
+		failed = append(failed, o.ID)
+	}
+
+	ctx := NewDatabaseContext(context.Background(), db)
+	assert.Equal(t, 1, r.Renew(ctx))
+	assert.Equal(t, "cert3", o1.CertificateID)
+	assert.Equal(t, "cert2", o2.CertificateID)
+	assert.Equal(t, []string{"oID3"}, failed)
+	assert.EqualError(t, loadErr, "error loading auto-renewal order oID4")
+
+	// The database must implement AutoRenewalDB.
+	assert.Equal(t, 0, r.Renew(NewDatabaseContext(context.Background(), &MockDB{})))
+}
+
+func TestOrder_GetAutoRenewalCertificate(t *testing.T) {
+	now := clock.Now()
+	cert := &Certificate{ID: "certID"}
+	db := &MockDB{
+		MockGetCertificate: func(_ context.Context, id string) (*Certificate, error) {
+			if id != "certID" {
+				return nil, errors.New("force")
+			}
+			return cert, nil
+		},
+	}
+	ar := &AutoRenewal{EndDate: now.Add(time.Hour), Lifetime: 3600}
+
+	tests := []struct {
+		name     string
+		o        *Order
+		wantType string
+	}{
+		{"ok", &Order{Status: StatusValid, CertificateID: "certID", AutoRenewal: ar}, ""},
+		{"fail/not-auto-renewal", &Order{Status: StatusValid, CertificateID: "certID"}, "urn:ietf:params:acme:error:malformed"},
+		{"fail/canceled", &Order{Status: StatusCanceled, CertificateID: "certID", AutoRenewal: ar}, "urn:ietf:params:acme:error:autoRenewalCanceled"},
+		{"fail/expired", &Order{Status: StatusValid, CertificateID: "certID", AutoRenewal: &AutoRenewal{EndDate: now.Add(-time.Minute)}}, "urn:ietf:params:acme:error:autoRenewalExpired"},
+		{"fail/pending", &Order{Status: StatusPending, AutoRenewal: ar}, "urn:ietf:params:acme:error:orderNotReady"},
+		{"fail/db", &Order{Status: StatusValid, CertificateID: "missing", AutoRenewal: ar}, "urn:ietf:params:acme:error:serverInternal"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.o.GetAutoRenewalCertificate(context.Background(), db)
+			if tt.wantType != "" {
+				var acmeErr *Error
+				require.ErrorAs(t, err, &acmeErr)
+				assert.Equal(t, tt.wantType, acmeErr.Type)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, cert, got)
+		})
+	}
+}
@@ -14,7 +14,12 @@ var (
 	StatusDeactivated = Status("deactivated")
 	// StatusReady -- ready; e.g. for an Order that is ready to be finalized.
 	StatusReady = Status("ready")
+	// StatusProcessing -- processing; e.g. for a Challenge that is being
+	// validated asynchronously.
+	StatusProcessing = Status("processing")
+	// StatusCanceled -- canceled; e.g. for an auto-renewal Order canceled by
+	// the client, RFC 8739.
+	StatusCanceled = Status("canceled")
 	//statusExpired     = "expired"
 	//statusActive      = "active"
-	//statusProcessing  = "processing"
 )
@@ -0,0 +1,401 @@
+package acme
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"sync"
+	"time"
+
+	"go.step.sm/crypto/jose"
+	"go.step.sm/crypto/randutil"
+
+	"github.com/smallstep/certificates/authority"
+	"github.com/smallstep/certificates/authority/provisioner"
+)
+
+const (
+	// validationLease is the time a worker owns a validation job. It must be
+	// larger than the time required to validate a challenge.
+	validationLease = 2 * time.Minute
+	// validationScanInterval is the time between two scans of the pending
+	// validation jobs in the database.
+	validationScanInterval = time.Minute
+)
+
+// ValidationJob is the state of the asynchronous validation of a challenge.
+// Jobs are stored in the database, so any instance of the CA can resume them.
+type ValidationJob struct {
+	ChallengeID     string
+	AuthorizationID string
+	AccountID       string
+	ProvisionerName string
+	// BaseURL is the base URL of the request that started the validation,
+	// it's used to generate the ACME links.
+	BaseURL       string
+	Attempts      int
+	CreatedAt     time.Time
+	Deadline      time.Time
+	NextAttemptAt time.Time
+	LockedBy      string
+	LockedUntil   time.Time
+}
+
+// AsyncValidatorOptions are the options used to create an AsyncValidator.
+type AsyncValidatorOptions struct {
+	// Workers is the number of challenges validated concurrently.
+	Workers int
+	// RetryWindow is the time during which a challenge validation is retried
+	// if it fails with a transient error, like a connection or DNS error.
+	RetryWindow time.Duration
+	// MinBackoff is the time to wait before the first retry. The time is
+	// doubled on each retry.
+	MinBackoff time.Duration
+	// MaxBackoff is the maximum time to wait between two retries.
+	MaxBackoff time.Duration
+	// LoadProvisioner returns the ACME provisioner with the given name. If
+	// not set, the provisioner is loaded from the authority in the context.
+	LoadProvisioner func(ctx context.Context, name string) (Provisioner, error)
+	// OnComplete, if set, is called when a challenge becomes valid or
+	// invalid.
+	OnComplete func(ctx context.Context, ch *Challenge)
+}
+
+// AsyncValidator validates ACME challenges asynchronously using a bounded
+// pool of workers. Challenges are moved to the processing status, and
+// validations failing with transient errors are retried with an exponential
+// backoff until the retry window ends.
+type AsyncValidator struct {
+	opts     AsyncValidatorOptions
+	id       string
+	ctx      context.Context
+	queue    chan string
+	stop     chan struct{}
+	stopOnce sync.Once
+	wg       sync.WaitGroup
+}
+
+// NewAsyncValidator creates a new AsyncValidator with the given options.
+func NewAsyncValidator(opts AsyncValidatorOptions) (*AsyncValidator, error) {
+	switch {
+	case opts.Workers <= 0:
+		return nil, errors.New("number of workers must be greater than 0")
+	case opts.MinBackoff <= 0 || opts.MaxBackoff < opts.MinBackoff:
+		return nil, errors.New("invalid backoff configuration")
+	}
+	if opts.LoadProvisioner == nil {
+		opts.LoadProvisioner = loadProvisioner
+	}
+	id, err := randutil.UUIDv4()
+	if err != nil {
+		return nil, err
+	}
+	return &AsyncValidator{
+		opts:  opts,
+		id:    id,
+		queue: make(chan string, 100*opts.Workers),
+		stop:  make(chan struct{}),
+	}, nil
+}
+
+// Start starts the workers and resumes the pending validations stored in the
+// database. The given context must contain the authority and the ACME
+// database, linker and client.
+func (v *AsyncValidator) Start(ctx context.Context) {
+	v.ctx = ctx
+	for i := 0; i < v.opts.Workers; i++ {
+		v.wg.Add(1)
+		go v.work()
+	}
+	v.wg.Add(1)
+	go v.scan()
+}
+
+// Stop stops the workers. Pending validations are resumed by the next
+// validator using the same database.
+func (v *AsyncValidator) Stop() {
+	v.stopOnce.Do(func() {
+		close(v.stop)
+	})
+	v.wg.Wait()
+}
+
+// RetryAfter returns the time a client should wait before polling a
+// challenge being processed.
+func (v *AsyncValidator) RetryAfter() time.Duration {
+	return v.opts.MinBackoff
+}
+
+// IsAsync returns true if the given challenge is validated asynchronously.
+// Challenges that depend on the request payload are always validated
+// synchronously.
+func (v *AsyncValidator) IsAsync(ch *Challenge) bool {
+	switch ch.Type {
+	case HTTP01, DNS01, DNSACCOUNT01, TLSALPN01:
+		return true
+	default:
+		return false
+	}
+}
+
+// Enqueue stores a validation job for the given pending challenge and moves
+// the challenge to the processing status. The context must contain the
+// provisioner of the request.
+func (v *AsyncValidator) Enqueue(ctx context.Context, db DB, ch *Challenge) error {
+	prov, ok := ProvisionerFromContext(ctx)
+	if !ok {
+		return NewErrorISE("provisioner does not exist")
+	}
+	var baseURL string
+	if u := baseURLFromContext(ctx); u != nil {
+		baseURL = u.String()
+	}
+
+	now := time.Now().UTC()
+	job := &ValidationJob{
+		ChallengeID:     ch.ID,
+		AuthorizationID: ch.AuthorizationID,
+		AccountID:       ch.AccountID,
+		ProvisionerName: prov.GetName(),
+		BaseURL:         baseURL,
+		CreatedAt:       now,
+		Deadline:        now.Add(v.opts.RetryWindow),
+		NextAttemptAt:   now,
+	}
+	// The job is stored before the challenge so a challenge is never in the
+	// processing status without a job.
+	if err := db.SaveValidationJob(ctx, job); err != nil {
+		return WrapErrorISE(err, "error saving validation job")
+	}
+
+	ch.Status = StatusProcessing
+	ch.Error = nil
+	if err := db.UpdateChallenge(ctx, ch); err != nil {
+		return WrapErrorISE(err, "error updating challenge")
+	}
+
+	v.enqueue(ch.ID)
+	return nil
+}
+
+// enqueue adds the challenge id to the queue. If the queue is full the job
+// will be picked up in the next scan.
+func (v *AsyncValidator) enqueue(id string) {
+	select {
+	case <-v.stop:
+	case v.queue <- id:
+	default:
+	}
+}
+
+// schedule enqueues the job when the next attempt is due.
+func (v *AsyncValidator) schedule(job *ValidationJob) {
+	time.AfterFunc(time.Until(job.NextAttemptAt), func() {
+		v.enqueue(job.ChallengeID)
+	})
+}
+
+func (v *AsyncValidator) work() {
+	defer v.wg.Done()
+	for {
+		select {
+		case <-v.stop:
+			return
+		case id := <-v.queue:
+			v.process(id)
+		}
+	}
+}
+
+// scan periodically enqueues the due jobs that are not locked. It allows to
+// resume the validations of other instances after a restart or a crash.
+func (v *AsyncValidator) scan() {
+	defer v.wg.Done()
+	ticker := time.NewTicker(validationScanInterval)
+	defer ticker.Stop()
+
+	for {
+		v.enqueueDueJobs()
+		select {
+		case <-v.stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (v *AsyncValidator) enqueueDueJobs() {
+	db := MustDatabaseFromContext(v.ctx)
+	jobs, err := db.GetValidationJobs(v.ctx)
+	if err != nil {
+		return
+	}
+	now := time.Now().UTC()
+	for _, job := range jobs {
+		if job.NextAttemptAt.After(now) || (job.LockedBy != "" && job.LockedUntil.After(now)) {
+			continue
+		}
+		v.enqueue(job.ChallengeID)
+	}
+}
+
+// process runs one attempt of the validation of a challenge.
+func (v *AsyncValidator) process(id string) {
+	ctx := v.ctx
+	db := MustDatabaseFromContext(ctx)
+
+	now := time.Now().UTC()
+	job, err := db.LockValidationJob(ctx, id, v.id, now.Add(validationLease))
+	if err != nil {
+		// The job does not exist or another worker is processing it.
+		return
+	}
+	if job.NextAttemptAt.After(now) {
+		if v.unlock(ctx, db, job) {
+			v.schedule(job)
+		}
+		return
+	}
+
+	ch, err := db.GetChallenge(ctx, job.ChallengeID, job.AuthorizationID)
+	switch {
+	case err != nil && !now.Before(job.Deadline):
+		_ = db.DeleteValidationJob(ctx, job.ChallengeID)
+		return
+	case err != nil:
+		v.retry(ctx, db, job)
+		return
+	case ch.Status != StatusProcessing:
+		_ = db.DeleteValidationJob(ctx, job.ChallengeID)
+		return
+	}
+	ch.AuthorizationID = job.AuthorizationID
+
+	// The challenge is stored once the attempt is done, so clients never see
+	// the pending status used by the validation.
+	vctx, jwk, err := v.validationContext(ctx, db, job)
+	if err == nil {
+		ch.Status = StatusPending
+		err = ch.Validate(vctx, asyncValidationDB{db}, jwk, nil)
+	}
+	if err != nil {
+		// Unexpected errors are also retried.
+		ch.Status = StatusPending
+		var acmeErr *Error
+		if !errors.As(err, &acmeErr) {
+			acmeErr = WrapErrorISE(err, "error validating challenge")
+		}
+		ch.Error = acmeErr
+	}
+	job.Attempts++
+
+	switch {
+	case ch.Status == StatusValid || ch.Status == StatusInvalid:
+	case !time.Now().UTC().Before(job.Deadline):
+		// The retry window is over, the last error is final.
+		ch.Status = StatusInvalid
+	default:
+		ch.Status = StatusProcessing
+		_ = db.UpdateChallenge(ctx, ch)
+		v.retry(ctx, db, job)
+		return
+	}
+
+	if err := db.UpdateChallenge(ctx, ch); err != nil {
+		v.retry(ctx, db, job)
+		return
+	}
+	_ = db.DeleteValidationJob(ctx, job.ChallengeID)
+	if v.opts.OnComplete != nil && vctx != nil {
+		v.opts.OnComplete(vctx, ch)
+	}
+}
+
+// asyncValidationDB is the DB used during a validation attempt. Challenges
+// are stored by the AsyncValidator after the attempt.
+type asyncValidationDB struct {
+	DB
+}
+
+func (asyncValidationDB) UpdateChallenge(context.Context, *Challenge) error {
+	return nil
+}
+
+// validationContext returns the context and the account key used to validate
+// the challenge of the job.
+func (v *AsyncValidator) validationContext(ctx context.Context, db DB, job *ValidationJob) (context.Context, *jose.JSONWebKey, error) {
+	acc, err := db.GetAccount(ctx, job.AccountID)
+	if err != nil {
+		return nil, nil, WrapErrorISE(err, "error retrieving account")
+	}
+	prov, err := v.opts.LoadProvisioner(ctx, job.ProvisionerName)
+	if err != nil {
+		return nil, nil, WrapErrorISE(err, "error loading provisioner %s", job.ProvisionerName)
+	}
+	ctx = NewProvisionerContext(ctx, prov)
+	if job.BaseURL != "" {
+		u, err := url.Parse(job.BaseURL)
+		if err != nil {
+			return nil, nil, WrapErrorISE(err, "error parsing base url")
+		}
+		ctx = context.WithValue(ctx, baseURLKey{}, u)
+	}
+	return ctx, acc.Key, nil
+}
+
+// retry releases the job and schedules the next attempt. The validator does
+// not use the acme clock because backoffs can be shorter than its precision.
+func (v *AsyncValidator) retry(ctx context.Context, db DB, job *ValidationJob) {
+	job.NextAttemptAt = time.Now().UTC().Add(v.backoff(job.Attempts))
+	if v.unlock(ctx, db, job) {
+		v.schedule(job)
+	}
+}
+
+// unlock releases the lock of the job.
+func (v *AsyncValidator) unlock(ctx context.Context, db DB, job *ValidationJob) bool {
+	job.LockedBy = ""
+	job.LockedUntil = time.Time{}
+	return db.SaveValidationJob(ctx, job) == nil
+}
+
+// backoff returns the time to wait after the given number of attempts.
+func (v *AsyncValidator) backoff(attempts int) time.Duration {
+	d := v.opts.MinBackoff
+	for i := 1; i < attempts && d < v.opts.MaxBackoff; i++ {
+		d *= 2
+	}
+	if d > v.opts.MaxBackoff {
+		d = v.opts.MaxBackoff
+	}
+	return d
+}
+
+// loadProvisioner loads the ACME provisioner with the given name from the
+// authority in the context.
+func loadProvisioner(ctx context.Context, name string) (Provisioner, error) {
+	p, err := authority.MustFromContext(ctx).LoadProvisionerByName(name)
+	if err != nil {
+		return nil, err
+	}
+	acmeProv, ok := p.(*provisioner.ACME)
+	if !ok {
+		return nil, fmt.Errorf("provisioner %s is not an ACME provisioner", name)
+	}
+	return acmeProv, nil
+}
+
+type asyncValidatorKey struct{}
+
+// NewAsyncValidatorContext adds the given validator to the context.
+func NewAsyncValidatorContext(ctx context.Context, v *AsyncValidator) context.Context {
+	return context.WithValue(ctx, asyncValidatorKey{}, v)
+}
+
+// AsyncValidatorFromContext returns the current validator from the given
+// context.
+func AsyncValidatorFromContext(ctx context.Context) (v *AsyncValidator, ok bool) {
+	v, ok = ctx.Value(asyncValidatorKey{}).(*AsyncValidator)
+	return
+}
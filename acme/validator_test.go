@@ -0,0 +1,326 @@
+package acme
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.step.sm/crypto/jose"
+)
+
+// validatorTestDB is a MockDB that stores a single challenge and its
+// validation job.
+type validatorTestDB struct {
+	MockDB
+	mu      sync.Mutex
+	ch      *Challenge
+	job     *ValidationJob
+	deleted bool
+}
+
+func newValidatorTestDB(jwk *jose.JSONWebKey, ch *Challenge, job *ValidationJob) *validatorTestDB {
+	db := &validatorTestDB{ch: ch, job: job}
+	db.MockDB = MockDB{
+		MockGetAccount: func(ctx context.Context, id string) (*Account, error) {
+			return &Account{ID: id, Key: jwk}, nil
+		},
+		MockGetChallenge: func(ctx context.Context, id, authzID string) (*Challenge, error) {
+			db.mu.Lock()
+			defer db.mu.Unlock()
+			c := *db.ch
+			return &c, nil
+		},
+		MockUpdateChallenge: func(ctx context.Context, ch *Challenge) error {
+			db.mu.Lock()
+			defer db.mu.Unlock()
+			c := *ch
+			db.ch = &c
+			return nil
+		},
+		MockSaveValidationJob: func(ctx context.Context, job *ValidationJob) error {
+			db.mu.Lock()
+			defer db.mu.Unlock()
+			j := *job
+			db.job = &j
+			db.deleted = false
+			return nil
+		},
+		MockGetValidationJobs: func(ctx context.Context) ([]*ValidationJob, error) {
+			db.mu.Lock()
+			defer db.mu.Unlock()
+			if db.job == nil || db.deleted {
+				return nil, nil
+			}
+			j := *db.job
+			return []*ValidationJob{&j}, nil
+		},
+		MockLockValidationJob: func(ctx context.Context, challengeID, owner string, until time.Time) (*ValidationJob, error) {
+			db.mu.Lock()
+			defer db.mu.Unlock()
+			if db.job == nil || db.deleted {
+				return nil, ErrNotFound
+			}
+			db.job.LockedBy = owner
+			db.job.LockedUntil = until
+			j := *db.job
+			return &j, nil
+		},
+		MockDeleteValidationJob: func(ctx context.Context, challengeID string) error {
+			db.mu.Lock()
+			defer db.mu.Unlock()
+			db.deleted = true
+			return nil
+		},
+	}
+	return db
+}
+
+func mustDNS01Record(t *testing.T, jwk *jose.JSONWebKey, token string) string {
+	t.Helper()
+	keyAuth, err := KeyAuthorization(token, jwk)
+	require.NoError(t, err)
+	h := sha256.Sum256([]byte(keyAuth))
+	return base64.RawURLEncoding.EncodeToString(h[:])
+}
+
+func TestNewAsyncValidator(t *testing.T) {
+	tests := []struct {
+		name    string
+		opts    AsyncValidatorOptions
+		wantErr bool
+	}{
+		{"ok", AsyncValidatorOptions{Workers: 1, RetryWindow: time.Minute, MinBackoff: time.Second, MaxBackoff: time.Minute}, false},
+		{"fail/workers", AsyncValidatorOptions{Workers: 0, RetryWindow: time.Minute, MinBackoff: time.Second, MaxBackoff: time.Minute}, true},
+		{"fail/minBackoff", AsyncValidatorOptions{Workers: 1, RetryWindow: time.Minute, MinBackoff: 0, MaxBackoff: time.Minute}, true},
+		{"fail/maxBackoff", AsyncValidatorOptions{Workers: 1, RetryWindow: time.Minute, MinBackoff: time.Minute, MaxBackoff: time.Second}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			v, err := NewAsyncValidator(tt.opts)
+			if tt.wantErr {
+				assert.Error(t, err)
+				assert.Nil(t, v)
+				return
+			}
+			require.NoError(t, err)
+			assert.NotEmpty(t, v.id)
+			assert.NotNil(t, v.opts.LoadProvisioner)
+			assert.Equal(t, time.Second, v.RetryAfter())
+		})
+	}
+}
+
+func TestAsyncValidator_IsAsync(t *testing.T) {
+	v, err := NewAsyncValidator(AsyncValidatorOptions{Workers: 1, MinBackoff: time.Second, MaxBackoff: time.Second})
+	require.NoError(t, err)
+	for _, typ := range []ChallengeType{HTTP01, DNS01, DNSACCOUNT01, TLSALPN01} {
+		assert.True(t, v.IsAsync(&Challenge{Type: typ}), typ)
+	}
+	for _, typ := range []ChallengeType{DEVICEATTEST01, WIREOIDC01, WIREDPOP01} {
+		assert.False(t, v.IsAsync(&Challenge{Type: typ}), typ)
+	}
+}
+
+func TestAsyncValidator_Enqueue(t *testing.T) {
+	v, err := NewAsyncValidator(AsyncValidatorOptions{Workers: 1, RetryWindow: time.Minute, MinBackoff: time.Second, MaxBackoff: time.Second})
+	require.NoError(t, err)
+
+	ch := &Challenge{ID: "chID", AuthorizationID: "azID", AccountID: "accID", Type: DNS01, Status: StatusPending, Error: NewError(ErrorConnectionType, "an error")}
+	db := newValidatorTestDB(nil, ch, nil)
+
+	r := httptest.NewRequest("POST", "https://ca.example.com/acme/acme/challenge/azID/chID", nil)
+	ctx := newBaseURLContext(context.Background(), r)
+
+	// The provisioner is required.
+	assert.Error(t, v.Enqueue(ctx, db, ch))
+
+	ctx = NewProvisionerContext(ctx, &MockProvisioner{MgetName: func() string { return "acme" }})
+	require.NoError(t, v.Enqueue(ctx, db, ch))
+	assert.Equal(t, StatusProcessing, ch.Status)
+	assert.Nil(t, ch.Error)
+	assert.Equal(t, StatusProcessing, db.ch.Status)
+
+	require.NotNil(t, db.job)
+	assert.Equal(t, "chID", db.job.ChallengeID)
+	assert.Equal(t, "azID", db.job.AuthorizationID)
+	assert.Equal(t, "accID", db.job.AccountID)
+	assert.Equal(t, "acme", db.job.ProvisionerName)
+	assert.Equal(t, "https://ca.example.com", db.job.BaseURL)
+	assert.WithinDuration(t, time.Now().Add(time.Minute), db.job.Deadline, 5*time.Second)
+	assert.Equal(t, "chID", <-v.queue)
+}
+
+func TestAsyncValidator_process(t *testing.T) {
+	jwk, err := jose.GenerateJWK("EC", "P-256", "ES256", "sig", "", 0)
+	require.NoError(t, err)
+	record := mustDNS01Record(t, jwk, "token")
+
+	type test struct {
+		records     []string
+		status      Status
+		deadline    time.Time
+		lockErr     error
+		wantStatus  Status
+		wantError   bool
+		wantDeleted bool
+		wantAttempt int
+		wantDone    bool
+	}
+	now := time.Now()
+	tests := map[string]test{
+		"ok/valid": {
+			records: []string{record}, status: StatusProcessing, deadline: now.Add(time.Minute),
+			wantStatus: StatusValid, wantDeleted: true, wantDone: true,
+		},
+		"ok/retry": {
+			records: []string{"foo"}, status: StatusProcessing, deadline: now.Add(time.Minute),
+			wantStatus: StatusProcessing, wantError: true, wantAttempt: 1,
+		},
+		"ok/deadline": {
+			records: []string{"foo"}, status: StatusProcessing, deadline: now.Add(-time.Second),
+			wantStatus: StatusInvalid, wantError: true, wantDeleted: true, wantDone: true,
+		},
+		"ok/not-processing": {
+			records: []string{record}, status: StatusValid, deadline: now.Add(time.Minute),
+			wantStatus: StatusValid, wantDeleted: true,
+		},
+		"ok/locked": {
+			records: []string{record}, status: StatusProcessing, deadline: now.Add(time.Minute), lockErr: ErrValidationJobLocked,
+			wantStatus: StatusProcessing,
+		},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			var completed *Challenge
+			v, err := NewAsyncValidator(AsyncValidatorOptions{
+				Workers:     1,
+				RetryWindow: time.Minute,
+				MinBackoff:  time.Hour,
+				MaxBackoff:  time.Hour,
+				LoadProvisioner: func(ctx context.Context, name string) (Provisioner, error) {
+					assert.Equal(t, "acme", name)
+					return &MockProvisioner{MgetName: func() string { return name }}, nil
+				},
+				OnComplete: func(ctx context.Context, ch *Challenge) {
+					completed = ch
+				},
+			})
+			require.NoError(t, err)
+
+			ch := &Challenge{ID: "chID", AccountID: "accID", Type: DNS01, Token: "token", Value: "zap.internal", Status: tc.status}
+			db := newValidatorTestDB(jwk, ch, &ValidationJob{
+				ChallengeID:     "chID",
+				AuthorizationID: "azID",
+				AccountID:       "accID",
+				ProvisionerName: "acme",
+				BaseURL:         "https://ca.example.com",
+				Deadline:        tc.deadline,
+			})
+			if tc.lockErr != nil {
+				db.MockLockValidationJob = func(ctx context.Context, challengeID, owner string, until time.Time) (*ValidationJob, error) {
+					return nil, tc.lockErr
+				}
+			}
+
+			ctx := NewContext(context.Background(), db, &mockClient{
+				lookupTxt: func(name string) ([]string, error) {
+					assert.Equal(t, "_acme-challenge.zap.internal", name)
+					return tc.records, nil
+				},
+			}, NewLinker("ca.example.com", "acme"), nil)
+			v.ctx = ctx
+			v.process("chID")
+
+			assert.Equal(t, tc.wantStatus, db.ch.Status)
+			assert.Equal(t, tc.wantError, db.ch.Error != nil)
+			assert.Equal(t, tc.wantDeleted, db.deleted)
+			assert.Equal(t, tc.wantAttempt, db.job.Attempts)
+			if tc.wantDone {
+				require.NotNil(t, completed)
+				assert.Equal(t, tc.wantStatus, completed.Status)
+			} else {
+				assert.Nil(t, completed)
+			}
+			if name == "ok/retry" {
+				assert.Empty(t, db.job.LockedBy)
+				assert.WithinDuration(t, time.Now().Add(time.Hour), db.job.NextAttemptAt, 5*time.Second)
+			}
+		})
+	}
+}
+
+func TestAsyncValidator_Start(t *testing.T) {
+	jwk, err := jose.GenerateJWK("EC", "P-256", "ES256", "sig", "", 0)
+	require.NoError(t, err)
+	record := mustDNS01Record(t, jwk, "token")
+
+	done := make(chan *Challenge, 1)
+	v, err := NewAsyncValidator(AsyncValidatorOptions{
+		Workers:     2,
+		RetryWindow: time.Minute,
+		MinBackoff:  10 * time.Millisecond,
+		MaxBackoff:  10 * time.Millisecond,
+		LoadProvisioner: func(ctx context.Context, name string) (Provisioner, error) {
+			return &MockProvisioner{MgetName: func() string { return name }}, nil
+		},
+		OnComplete: func(ctx context.Context, ch *Challenge) {
+			u, ok := ctx.Value(baseURLKey{}).(*url.URL)
+			if assert.True(t, ok) {
+				assert.Equal(t, "https://ca.example.com", u.String())
+			}
+			done <- ch
+		},
+	})
+	require.NoError(t, err)
+
+	// The job stored by another instance is resumed, and the first attempt
+	// fails with a transient error.
+	ch := &Challenge{ID: "chID", AccountID: "accID", Type: DNS01, Token: "token", Value: "zap.internal", Status: StatusProcessing}
+	db := newValidatorTestDB(jwk, ch, &ValidationJob{
+		ChallengeID:     "chID",
+		AuthorizationID: "azID",
+		AccountID:       "accID",
+		ProvisionerName: "acme",
+		BaseURL:         "https://ca.example.com",
+		Deadline:        time.Now().Add(time.Minute),
+		LockedBy:        "other",
+		LockedUntil:     time.Now().Add(-time.Second),
+	})
+	var mu sync.Mutex
+	var lookups int
+	ctx := NewContext(context.Background(), db, &mockClient{
+		lookupTxt: func(name string) ([]string, error) {
+			mu.Lock()
+			defer mu.Unlock()
+			if lookups++; lookups == 1 {
+				return nil, errors.New("temporary failure")
+			}
+			return []string{record}, nil
+		},
+	}, NewLinker("ca.example.com", "acme"), nil)
+
+	v.Start(ctx)
+	defer v.Stop()
+
+	select {
+	case got := <-done:
+		assert.Equal(t, StatusValid, got.Status)
+		assert.Nil(t, got.Error)
+	case <-time.After(10 * time.Second):
+		t.Fatal("timeout waiting for the validation")
+	}
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	assert.Equal(t, StatusValid, db.ch.Status)
+	assert.True(t, db.deleted)
+	assert.Equal(t, 1, db.job.Attempts)
+}
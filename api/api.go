@@ -22,6 +22,7 @@ import (
 	"github.com/go-chi/chi/v5"
 	"github.com/pkg/errors"
 	"go.step.sm/crypto/sshutil"
+	"golang.org/x/crypto/ocsp"
 	"golang.org/x/crypto/ssh"
 
 	"github.com/smallstep/certificates/api/log"
@@ -56,6 +57,8 @@ type Authority interface {
 	GetFederation() ([]*x509.Certificate, error)
 	Version() authority.Version
 	GetCertificateRevocationList() (*authority.CertificateRevocationListInfo, error)
+	GetCertificateRevocationListByName(name string) (*authority.CertificateRevocationListInfo, error)
+	GetOCSPResponse(req *ocsp.Request) (*authority.OCSPResponseInfo, error)
 }
 
 // mustAuthority will be replaced on unit tests.
@@ -256,22 +259,73 @@ func scepFromProvisioner(p *provisioner.SCEP) *models.SCEP {
 	}
 }
 
+func estFromProvisioner(p *provisioner.EST) *models.EST {
+	var password string
+	if p.Password != "" {
+		password = redacted
+	}
+	return &models.EST{
+		ID:            p.ID,
+		Type:          p.Type,
+		Name:          p.Name,
+		ForceCN:       p.ForceCN,
+		Username:      p.Username,
+		Password:      password,
+		TLSClientAuth: p.TLSClientAuth,
+		CSRAttributes: p.CSRAttributes,
+		ServerKeyGen:  p.ServerKeyGen,
+		Options:       p.Options,
+		Claims:        p.Claims,
+	}
+}
+
+func cmpFromProvisioner(p *provisioner.CMP) *models.CMP {
+	redact := func(v string) string {
+		if v != "" {
+			return redacted
+		}
+		return ""
+	}
+	var signerKeyPEM []byte
+	if len(p.SignerKeyPEM) > 0 {
+		signerKeyPEM = []byte(redacted)
+	}
+	return &models.CMP{
+		ID:                p.ID,
+		Type:              p.Type,
+		Name:              p.Name,
+		ForceCN:           p.ForceCN,
+		SharedSecret:      redact(p.SharedSecret),
+		Roots:             p.Roots,
+		SignerCertificate: p.SignerCertificate,
+		SignerKeyPEM:      signerKeyPEM,
+		SignerKey:         redact(p.SignerKey),
+		SignerKeyPassword: redact(p.SignerKeyPassword),
+		Options:           p.Options,
+		Claims:            p.Claims,
+	}
+}
+
 // MarshalJSON implements json.Marshaler. It marshals the ProvisionersResponse
 // into a byte slice.
 //
-// Special treatment is given to the SCEP provisioner, as it contains a
-// challenge secret that MUST NOT be leaked in (public) HTTP responses. The
-// challenge value is thus redacted in HTTP responses.
+// Special treatment is given to the SCEP, EST and CMP provisioners, as they
+// contain a challenge, a password or a shared secret that MUST NOT be leaked
+// in (public) HTTP responses. These values are thus redacted in HTTP
+// responses.
 func (p ProvisionersResponse) MarshalJSON() ([]byte, error) {
 	var responseProvisioners provisioner.List
 	for _, item := range p.Provisioners {
-		scepProv, ok := item.(*provisioner.SCEP)
-		if !ok {
+		switch prov := item.(type) {
+		case *provisioner.SCEP:
+			responseProvisioners = append(responseProvisioners, scepFromProvisioner(prov))
+		case *provisioner.EST:
+			responseProvisioners = append(responseProvisioners, estFromProvisioner(prov))
+		case *provisioner.CMP:
+			responseProvisioners = append(responseProvisioners, cmpFromProvisioner(prov))
+		default:
 			responseProvisioners = append(responseProvisioners, item)
-			continue
 		}
-
-		responseProvisioners = append(responseProvisioners, scepFromProvisioner(scepProv))
 	}
 
 	var list = struct {
@@ -332,6 +386,9 @@ func Route(r Router) {
 	r.MethodFunc("POST", "/rekey", Rekey)
 	r.MethodFunc("POST", "/revoke", Revoke)
 	r.MethodFunc("GET", "/crl", CRL)
+	r.MethodFunc("GET", "/crl/{name}", CRL)
+	r.MethodFunc("POST", "/ocsp", OCSP)
+	r.MethodFunc("GET", "/ocsp/*", OCSP)
 	r.MethodFunc("GET", "/provisioners", Provisioners)
 	r.MethodFunc("GET", "/provisioners/{kid}/encrypted-key", ProvisionerKey)
 	r.MethodFunc("GET", "/roots", Roots)
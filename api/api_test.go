@@ -33,6 +33,7 @@ import (
 	"go.step.sm/crypto/jose"
 	"go.step.sm/crypto/minica"
 	"go.step.sm/crypto/x509util"
+	"golang.org/x/crypto/ocsp"
 	"golang.org/x/crypto/ssh"
 
 	"github.com/smallstep/certificates/authority"
@@ -210,6 +211,8 @@ type mockAuthority struct {
 	getIntermediateCertificates  func() []*x509.Certificate
 	getFederation                func() ([]*x509.Certificate, error)
 	getCRL                       func() (*authority.CertificateRevocationListInfo, error)
+	getCRLByName                 func(name string) (*authority.CertificateRevocationListInfo, error)
+	getOCSPResponse              func(req *ocsp.Request) (*authority.OCSPResponseInfo, error)
 	signSSH                      func(ctx context.Context, key ssh.PublicKey, opts provisioner.SignSSHOptions, signOpts ...provisioner.SignOption) (*ssh.Certificate, error)
 	signSSHAddUser               func(ctx context.Context, key ssh.PublicKey, cert *ssh.Certificate) (*ssh.Certificate, error)
 	renewSSH                     func(ctx context.Context, cert *ssh.Certificate) (*ssh.Certificate, error)
@@ -231,6 +234,22 @@ func (m *mockAuthority) GetCertificateRevocationList() (*authority.CertificateRe
 	return m.ret1.(*authority.CertificateRevocationListInfo), m.err
 }
 
+func (m *mockAuthority) GetCertificateRevocationListByName(name string) (*authority.CertificateRevocationListInfo, error) {
+	if m.getCRLByName != nil {
+		return m.getCRLByName(name)
+	}
+
+	return m.ret1.(*authority.CertificateRevocationListInfo), m.err
+}
+
+func (m *mockAuthority) GetOCSPResponse(req *ocsp.Request) (*authority.OCSPResponseInfo, error) {
+	if m.getOCSPResponse != nil {
+		return m.getOCSPResponse(req)
+	}
+
+	return m.ret1.(*authority.OCSPResponseInfo), m.err
+}
+
 // TODO: remove once Authorize is deprecated.
 func (m *mockAuthority) Authorize(ctx context.Context, ott string) ([]provisioner.SignOption, error) {
 	if m.authorize != nil {
@@ -1569,6 +1588,20 @@ func TestProvisionersResponse_MarshalJSON(t *testing.T) {
 				DecrypterKeyURI:               "softkms:path=/path/to/private.key",
 				DecrypterKeyPassword:          "super-secret-password",
 			},
+			&provisioner.EST{
+				Name:          "est",
+				Type:          "est",
+				Username:      "device",
+				Password:      "not-so-secret",
+				TLSClientAuth: true,
+			},
+			&provisioner.CMP{
+				Name:              "cmp",
+				Type:              "cmp",
+				SharedSecret:      "not-so-secret",
+				SignerKey:         "softkms:path=/path/to/signer.key",
+				SignerKeyPassword: "super-secret-password",
+			},
 			&provisioner.JWK{
 				EncryptedKey: "eyJhbGciOiJQQkVTMi1IUzI1NitBMTI4S1ciLCJlbmMiOiJBMTI4R0NNIiwicDJjIjoxMDAwMDAsInAycyI6IlhOdmYxQjgxSUlLMFA2NUkwcmtGTGcifQ.XaN9zcPQeWt49zchUDm34FECUTHfQTn_.tmNHPQDqR3ebsWfd.9WZr3YVdeOyJh36vvx0VlRtluhvYp4K7jJ1KGDr1qypwZ3ziBVSNbYYQ71du7fTtrnfG1wgGTVR39tWSzBU-zwQ5hdV3rpMAaEbod5zeW6SHd95H3Bvcb43YiiqJFNL5sGZzFb7FqzVmpsZ1efiv6sZaGDHtnCAL6r12UG5EZuqGfM0jGCZitUz2m9TUKXJL5DJ7MOYbFfkCEsUBPDm_TInliSVn2kMJhFa0VOe5wZk5YOuYM3lNYW64HGtbf-llN2Xk-4O9TfeSPizBx9ZqGpeu8pz13efUDT2WL9tWo6-0UE-CrG0bScm8lFTncTkHcu49_a5NaUBkYlBjEiw.thPcx3t1AUcWuEygXIY3Fg",
 				Key:          &key,
@@ -1595,6 +1628,22 @@ func TestProvisionersResponse_MarshalJSON(t *testing.T) {
 				"minimumPublicKeyLength":        2048,
 				"encryptionAlgorithmIdentifier": 2,
 			},
+			{
+				"type":          "est",
+				"name":          "est",
+				"forceCN":       false,
+				"username":      "device",
+				"password":      "*** REDACTED ***",
+				"tlsClientAuth": true,
+			},
+			{
+				"type":              "cmp",
+				"name":              "cmp",
+				"forceCN":           false,
+				"sharedSecret":      "*** REDACTED ***",
+				"signerKey":         "*** REDACTED ***",
+				"signerKeyPassword": "*** REDACTED ***",
+			},
 			{
 				"type": "JWK",
 				"name": "step-cli",
@@ -1635,6 +1684,20 @@ func TestProvisionersResponse_MarshalJSON(t *testing.T) {
 			DecrypterKeyURI:               "softkms:path=/path/to/private.key",
 			DecrypterKeyPassword:          "super-secret-password",
 		},
+		&provisioner.EST{
+			Name:          "est",
+			Type:          "est",
+			Username:      "device",
+			Password:      "not-so-secret",
+			TLSClientAuth: true,
+		},
+		&provisioner.CMP{
+			Name:              "cmp",
+			Type:              "cmp",
+			SharedSecret:      "not-so-secret",
+			SignerKey:         "softkms:path=/path/to/signer.key",
+			SignerKeyPassword: "super-secret-password",
+		},
 		&provisioner.JWK{
 			EncryptedKey: "eyJhbGciOiJQQkVTMi1IUzI1NitBMTI4S1ciLCJlbmMiOiJBMTI4R0NNIiwicDJjIjoxMDAwMDAsInAycyI6IlhOdmYxQjgxSUlLMFA2NUkwcmtGTGcifQ.XaN9zcPQeWt49zchUDm34FECUTHfQTn_.tmNHPQDqR3ebsWfd.9WZr3YVdeOyJh36vvx0VlRtluhvYp4K7jJ1KGDr1qypwZ3ziBVSNbYYQ71du7fTtrnfG1wgGTVR39tWSzBU-zwQ5hdV3rpMAaEbod5zeW6SHd95H3Bvcb43YiiqJFNL5sGZzFb7FqzVmpsZ1efiv6sZaGDHtnCAL6r12UG5EZuqGfM0jGCZitUz2m9TUKXJL5DJ7MOYbFfkCEsUBPDm_TInliSVn2kMJhFa0VOe5wZk5YOuYM3lNYW64HGtbf-llN2Xk-4O9TfeSPizBx9ZqGpeu8pz13efUDT2WL9tWo6-0UE-CrG0bScm8lFTncTkHcu49_a5NaUBkYlBjEiw.thPcx3t1AUcWuEygXIY3Fg",
 			Key:          &keyCopy,
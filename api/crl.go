@@ -5,13 +5,29 @@ import (
 	"net/http"
 	"time"
 
+	"github.com/go-chi/chi/v5"
+
 	"github.com/smallstep/certificates/api/render"
+	"github.com/smallstep/certificates/authority"
 	"github.com/smallstep/certificates/errs"
 )
 
-// CRL is an HTTP handler that returns the current CRL in DER or PEM format
+// CRL is an HTTP handler that returns the current CRL in DER or PEM format.
+// If the name parameter is present, it returns the partitioned or delta CRL
+// with that name.
 func CRL(w http.ResponseWriter, r *http.Request) {
-	crlInfo, err := mustAuthority(r.Context()).GetCertificateRevocationList()
+	var (
+		crlInfo *authority.CertificateRevocationListInfo
+		err     error
+	)
+
+	filename := "crl"
+	if name := chi.URLParam(r, "name"); name != "" {
+		filename += "-" + name
+		crlInfo, err = mustAuthority(r.Context()).GetCertificateRevocationListByName(name)
+	} else {
+		crlInfo, err = mustAuthority(r.Context()).GetCertificateRevocationList()
+	}
 	if err != nil {
 		render.Error(w, r, err)
 		return
@@ -32,7 +48,7 @@ func CRL(w http.ResponseWriter, r *http.Request) {
 	_, formatAsPEM := r.URL.Query()["pem"]
 	if formatAsPEM {
 		w.Header().Add("Content-Type", "application/x-pem-file")
-		w.Header().Add("Content-Disposition", "attachment; filename=\"crl.pem\"")
+		w.Header().Add("Content-Disposition", "attachment; filename=\""+filename+".pem\"")
 
 		_ = pem.Encode(w, &pem.Block{
 			Type:  "X509 CRL",
@@ -40,7 +56,7 @@ func CRL(w http.ResponseWriter, r *http.Request) {
 		})
 	} else {
 		w.Header().Add("Content-Type", "application/pkix-crl")
-		w.Header().Add("Content-Disposition", "attachment; filename=\"crl.der\"")
+		w.Header().Add("Content-Disposition", "attachment; filename=\""+filename+".der\"")
 		w.Write(crlInfo.Data)
 	}
 }
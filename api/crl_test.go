@@ -2,7 +2,6 @@ package api
 
 import (
 	"bytes"
-	"context"
 	"encoding/pem"
 	"io"
 	"net/http"
@@ -46,17 +45,22 @@ func Test_CRL(t *testing.T) {
 		{"ok/empty-pem", "http://example.com/crl?pem=true", nil, http.StatusOK, &authority.CertificateRevocationListInfo{Data: nil}, emptyPEMData, http.Header{"Content-Type": []string{"application/x-pem-file"}, "Content-Disposition": []string{`attachment; filename="crl.pem"`}}, ""},
 		{"fail/internal", "http://example.com/crl", errs.Wrap(http.StatusInternalServerError, errors.New("failure"), "authority.GetCertificateRevocationList"), http.StatusInternalServerError, nil, nil, http.Header{}, `{"status":500,"message":"The certificate authority encountered an Internal Server Error. Please see the certificate authority logs for more info."}`},
 		{"fail/nil", "http://example.com/crl", nil, http.StatusNotFound, nil, nil, http.Header{}, `{"status":404,"message":"no CRL available"}`},
+		{"ok/name", "http://example.com/crl/1-delta", nil, http.StatusOK, &authority.CertificateRevocationListInfo{Data: data}, data, http.Header{"Content-Type": []string{"application/pkix-crl"}, "Content-Disposition": []string{`attachment; filename="crl-1-delta.der"`}}, ""},
+		{"ok/name-pem", "http://example.com/crl/1-delta?pem=true", nil, http.StatusOK, &authority.CertificateRevocationListInfo{Data: data}, pemData, http.Header{"Content-Type": []string{"application/x-pem-file"}, "Content-Disposition": []string{`attachment; filename="crl-1-delta.pem"`}}, ""},
+		{"fail/name-not-found", "http://example.com/crl/foo", errs.Wrap(http.StatusNotFound, errors.New("not found"), "authority.GetCertificateRevocationListByName"), http.StatusNotFound, nil, nil, http.Header{}, `{"status":404,"message":"The certificate authority received an unexpected HTTP status code - '404'. Please see the certificate authority logs for more info."}`},
 	}
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			mockMustAuthority(t, &mockAuthority{ret1: tt.crlInfo, err: tt.err})
 
-			chiCtx := chi.NewRouteContext()
+			r := chi.NewRouter()
+			r.Get("/crl", CRL)
+			r.Get("/crl/{name}", CRL)
+
 			req := httptest.NewRequest("GET", tt.url, http.NoBody)
-			req = req.WithContext(context.WithValue(context.Background(), chi.RouteCtxKey, chiCtx))
 			w := httptest.NewRecorder()
-			CRL(w, req)
+			r.ServeHTTP(w, req)
 			res := w.Result()
 
 			assert.Equal(t, tt.statusCode, res.StatusCode)
@@ -0,0 +1,111 @@
+package models
+
+import (
+	"context"
+	"crypto/x509"
+
+	"golang.org/x/crypto/ssh"
+
+	"github.com/smallstep/certificates/authority/provisioner"
+)
+
+// CMP is the CMP provisioner model used solely in CA API responses. All
+// methods for the [provisioner.Interface] interface are implemented, but
+// return a dummy error.
+type CMP struct {
+	ID                string               `json:"-"`
+	Type              string               `json:"type"`
+	Name              string               `json:"name"`
+	ForceCN           bool                 `json:"forceCN"`
+	SharedSecret      string               `json:"sharedSecret,omitempty"`
+	Roots             []byte               `json:"roots,omitempty"`
+	SignerCertificate []byte               `json:"signerCertificate,omitempty"`
+	SignerKeyPEM      []byte               `json:"signerKeyPEM,omitempty"`
+	SignerKey         string               `json:"signerKey,omitempty"`
+	SignerKeyPassword string               `json:"signerKeyPassword,omitempty"`
+	Options           *provisioner.Options `json:"options,omitempty"`
+	Claims            *provisioner.Claims  `json:"claims,omitempty"`
+}
+
+// GetID returns the provisioner unique identifier.
+func (s *CMP) GetID() string {
+	if s.ID != "" {
+		return s.ID
+	}
+	return s.GetIDForToken()
+}
+
+// GetIDForToken returns an identifier that will be used to load the provisioner
+// from a token.
+func (s *CMP) GetIDForToken() string {
+	return "cmp/" + s.Name
+}
+
+// GetName returns the name of the provisioner.
+func (s *CMP) GetName() string {
+	return s.Name
+}
+
+// GetType returns the type of provisioner.
+func (s *CMP) GetType() provisioner.Type {
+	return provisioner.TypeCMP
+}
+
+// GetEncryptedKey returns the base provisioner encrypted key if it's defined.
+func (s *CMP) GetEncryptedKey() (string, string, bool) {
+	return "", "", false
+}
+
+// GetTokenID returns the identifier of the token.
+func (s *CMP) GetTokenID(string) (string, error) {
+	return "", errDummyImplementation
+}
+
+// Init initializes and validates the fields of a CMP type.
+func (s *CMP) Init(_ provisioner.Config) (err error) {
+	return errDummyImplementation
+}
+
+// AuthorizeSign returns an unimplemented error. Provisioners should overwrite
+// this method if they will support authorizing tokens for signing x509 Certificates.
+func (s *CMP) AuthorizeSign(context.Context, string) ([]provisioner.SignOption, error) {
+	return nil, errDummyImplementation
+}
+
+// AuthorizeRevoke returns an unimplemented error. Provisioners should overwrite
+// this method if they will support authorizing tokens for revoking x509 Certificates.
+func (s *CMP) AuthorizeRevoke(context.Context, string) error {
+	return errDummyImplementation
+}
+
+// AuthorizeRenew returns an unimplemented error. Provisioners should overwrite
+// this method if they will support authorizing tokens for renewing x509 Certificates.
+func (s *CMP) AuthorizeRenew(context.Context, *x509.Certificate) error {
+	return errDummyImplementation
+}
+
+// AuthorizeSSHSign returns an unimplemented error. Provisioners should overwrite
+// this method if they will support authorizing tokens for signing SSH Certificates.
+func (s *CMP) AuthorizeSSHSign(context.Context, string) ([]provisioner.SignOption, error) {
+	return nil, errDummyImplementation
+}
+
+// AuthorizeSSHRevoke returns an unimplemented error. Provisioners should overwrite
+// this method if they will support authorizing tokens for revoking SSH Certificates.
+func (s *CMP) AuthorizeSSHRevoke(context.Context, string) error {
+	return errDummyImplementation
+}
+
+// AuthorizeSSHRenew returns an unimplemented error. Provisioners should overwrite
+// this method if they will support authorizing tokens for renewing SSH Certificates.
+func (s *CMP) AuthorizeSSHRenew(context.Context, string) (*ssh.Certificate, error) {
+	return nil, errDummyImplementation
+}
+
+// AuthorizeSSHRekey returns an unimplemented error. Provisioners should overwrite
+// this method if they will support authorizing tokens for rekeying SSH Certificates.
+func (s *CMP) AuthorizeSSHRekey(context.Context, string) (*ssh.Certificate, []provisioner.SignOption, error) {
+	return nil, nil, errDummyImplementation
+}
+
+var _ provisioner.Interface = (*CMP)(nil)
@@ -0,0 +1,111 @@
+package models
+
+import (
+	"context"
+	"crypto/x509"
+
+	"go.step.sm/crypto/x509util"
+	"golang.org/x/crypto/ssh"
+
+	"github.com/smallstep/certificates/authority/provisioner"
+)
+
+// EST is the EST provisioner model used solely in CA API responses. All
+// methods for the [provisioner.Interface] interface are implemented, but
+// return a dummy error.
+type EST struct {
+	ID            string                       `json:"-"`
+	Type          string                       `json:"type"`
+	Name          string                       `json:"name"`
+	ForceCN       bool                         `json:"forceCN"`
+	Username      string                       `json:"username,omitempty"`
+	Password      string                       `json:"password,omitempty"`
+	TLSClientAuth bool                         `json:"tlsClientAuth"`
+	CSRAttributes []x509util.ObjectIdentifier  `json:"csrAttributes,omitempty"`
+	ServerKeyGen  *provisioner.ESTServerKeyGen `json:"serverKeyGen,omitempty"`
+	Options       *provisioner.Options         `json:"options,omitempty"`
+	Claims        *provisioner.Claims          `json:"claims,omitempty"`
+}
+
+// GetID returns the provisioner unique identifier.
+func (s *EST) GetID() string {
+	if s.ID != "" {
+		return s.ID
+	}
+	return s.GetIDForToken()
+}
+
+// GetIDForToken returns an identifier that will be used to load the provisioner
+// from a token.
+func (s *EST) GetIDForToken() string {
+	return "est/" + s.Name
+}
+
+// GetName returns the name of the provisioner.
+func (s *EST) GetName() string {
+	return s.Name
+}
+
+// GetType returns the type of provisioner.
+func (s *EST) GetType() provisioner.Type {
+	return provisioner.TypeEST
+}
+
+// GetEncryptedKey returns the base provisioner encrypted key if it's defined.
+func (s *EST) GetEncryptedKey() (string, string, bool) {
+	return "", "", false
+}
+
+// GetTokenID returns the identifier of the token.
+func (s *EST) GetTokenID(string) (string, error) {
+	return "", errDummyImplementation
+}
+
+// Init initializes and validates the fields of an EST type.
+func (s *EST) Init(_ provisioner.Config) (err error) {
+	return errDummyImplementation
+}
+
+// AuthorizeSign returns an unimplemented error. Provisioners should overwrite
+// this method if they will support authorizing tokens for signing x509 Certificates.
+func (s *EST) AuthorizeSign(context.Context, string) ([]provisioner.SignOption, error) {
+	return nil, errDummyImplementation
+}
+
+// AuthorizeRevoke returns an unimplemented error. Provisioners should overwrite
+// this method if they will support authorizing tokens for revoking x509 Certificates.
+func (s *EST) AuthorizeRevoke(context.Context, string) error {
+	return errDummyImplementation
+}
+
+// AuthorizeRenew returns an unimplemented error. Provisioners should overwrite
+// this method if they will support authorizing tokens for renewing x509 Certificates.
+func (s *EST) AuthorizeRenew(context.Context, *x509.Certificate) error {
+	return errDummyImplementation
+}
+
+// AuthorizeSSHSign returns an unimplemented error. Provisioners should overwrite
+// this method if they will support authorizing tokens for signing SSH Certificates.
+func (s *EST) AuthorizeSSHSign(context.Context, string) ([]provisioner.SignOption, error) {
+	return nil, errDummyImplementation
+}
+
+// AuthorizeSSHRevoke returns an unimplemented error. Provisioners should overwrite
+// this method if they will support authorizing tokens for revoking SSH Certificates.
+func (s *EST) AuthorizeSSHRevoke(context.Context, string) error {
+	return errDummyImplementation
+}
+
+// AuthorizeSSHRenew returns an unimplemented error. Provisioners should overwrite
+// this method if they will support authorizing tokens for renewing SSH Certificates.
+func (s *EST) AuthorizeSSHRenew(context.Context, string) (*ssh.Certificate, error) {
+	return nil, errDummyImplementation
+}
+
+// AuthorizeSSHRekey returns an unimplemented error. Provisioners should overwrite
+// this method if they will support authorizing tokens for rekeying SSH Certificates.
+func (s *EST) AuthorizeSSHRekey(context.Context, string) (*ssh.Certificate, []provisioner.SignOption, error) {
+	return nil, nil, errDummyImplementation
+}
+
+var _ provisioner.Interface = (*EST)(nil)
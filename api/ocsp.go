@@ -0,0 +1,107 @@
+package api
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"golang.org/x/crypto/ocsp"
+
+	"github.com/smallstep/certificates/api/render"
+	"github.com/smallstep/certificates/errs"
+	"github.com/smallstep/certificates/logging"
+)
+
+// maxOCSPRequestSize is the maximum size of an OCSP request body.
+const maxOCSPRequestSize = 64 * 1024
+
+// OCSP is an HTTP handler that implements the RFC 6960 OCSP responder. It
+// supports POST requests with the DER request in the body, and GET requests
+// with the base64 encoded request in the path.
+func OCSP(w http.ResponseWriter, r *http.Request) {
+	var (
+		der []byte
+		err error
+	)
+	switch r.Method {
+	case http.MethodPost:
+		der, err = io.ReadAll(io.LimitReader(r.Body, maxOCSPRequestSize))
+	default:
+		der, err = decodeOCSPRequestPath(chi.URLParam(r, "*"))
+	}
+	if err != nil {
+		writeOCSPResponse(w, ocsp.MalformedRequestErrorResponse)
+		return
+	}
+
+	req, err := ocsp.ParseRequest(der)
+	if err != nil {
+		writeOCSPResponse(w, ocsp.MalformedRequestErrorResponse)
+		return
+	}
+
+	info, err := mustAuthority(r.Context()).GetOCSPResponse(req)
+	if err != nil {
+		var ee *errs.Error
+		if errors.As(err, &ee) {
+			switch ee.StatusCode() {
+			case http.StatusNotFound, http.StatusNotImplemented:
+				render.Error(w, r, err)
+				return
+			case http.StatusUnauthorized:
+				writeOCSPResponse(w, ocsp.UnauthorizedErrorResponse)
+				return
+			}
+		}
+		if rl, ok := w.(logging.ResponseLogger); ok {
+			rl.WithFields(map[string]interface{}{
+				"error": err.Error(),
+			})
+		}
+		writeOCSPResponse(w, ocsp.InternalErrorErrorResponse)
+		return
+	}
+
+	// Responses to GET requests can be cached by HTTP proxies, RFC 5019,
+	// section 6.
+	if r.Method == http.MethodGet && !info.NextUpdate.IsZero() {
+		sum := sha256.Sum256(info.Data)
+		maxAge := int(time.Until(info.NextUpdate).Seconds())
+		if maxAge < 0 {
+			maxAge = 0
+		}
+		w.Header().Set("Cache-Control", fmt.Sprintf("max-age=%d, public, no-transform, must-revalidate", maxAge))
+		w.Header().Set("Last-Modified", info.ThisUpdate.UTC().Format(http.TimeFormat))
+		w.Header().Set("Expires", info.NextUpdate.UTC().Format(http.TimeFormat))
+		w.Header().Set("ETag", `"`+hex.EncodeToString(sum[:])+`"`)
+	}
+
+	writeOCSPResponse(w, info.Data)
+}
+
+// decodeOCSPRequestPath decodes the base64 encoded OCSP request in the path of
+// a GET request. Clients might URL-encode the request, and some of them use
+// the raw base64 encoding.
+func decodeOCSPRequestPath(s string) ([]byte, error) {
+	s, err := url.PathUnescape(s)
+	if err != nil {
+		return nil, err
+	}
+	if b, err := base64.StdEncoding.DecodeString(s); err == nil {
+		return b, nil
+	}
+	return base64.RawStdEncoding.DecodeString(s)
+}
+
+func writeOCSPResponse(w http.ResponseWriter, b []byte) {
+	w.Header().Set("Content-Type", "application/ocsp-response")
+	w.WriteHeader(http.StatusOK)
+	w.Write(b)
+}
@@ -0,0 +1,100 @@
+package api
+
+import (
+	"bytes"
+	"encoding/base64"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.step.sm/crypto/minica"
+	"golang.org/x/crypto/ocsp"
+
+	"github.com/smallstep/certificates/authority"
+	"github.com/smallstep/certificates/errs"
+)
+
+func Test_OCSP(t *testing.T) {
+	ca, err := minica.New()
+	require.NoError(t, err)
+
+	der, err := ocsp.CreateRequest(ca.Intermediate, ca.Root, nil)
+	require.NoError(t, err)
+
+	now := time.Now().Truncate(time.Second)
+	data := []byte{1, 2, 3, 4}
+	info := &authority.OCSPResponseInfo{
+		ThisUpdate: now,
+		NextUpdate: now.Add(time.Hour),
+		Data:       data,
+	}
+
+	tests := []struct {
+		name           string
+		method         string
+		path           string
+		body           []byte
+		info           *authority.OCSPResponseInfo
+		err            error
+		statusCode     int
+		expectedBody   []byte
+		expectsCaching bool
+	}{
+		{"ok/post", "POST", "http://example.com/ocsp", der, info, nil, http.StatusOK, data, false},
+		{"ok/get", "GET", "http://example.com/ocsp/" + base64.StdEncoding.EncodeToString(der), nil, info, nil, http.StatusOK, data, true},
+		{"ok/get-escaped", "GET", "http://example.com/ocsp/" + url.PathEscape(base64.StdEncoding.EncodeToString(der)), nil, info, nil, http.StatusOK, data, true},
+		{"ok/get-raw", "GET", "http://example.com/ocsp/" + base64.RawStdEncoding.EncodeToString(der), nil, info, nil, http.StatusOK, data, true},
+		{"fail/malformed-post", "POST", "http://example.com/ocsp", []byte("foo"), nil, nil, http.StatusOK, ocsp.MalformedRequestErrorResponse, false},
+		{"fail/malformed-get", "GET", "http://example.com/ocsp/not-base64!", nil, nil, nil, http.StatusOK, ocsp.MalformedRequestErrorResponse, false},
+		{"fail/unauthorized", "POST", "http://example.com/ocsp", der, nil, errs.Wrap(http.StatusUnauthorized, errors.New("force"), "authority.GetOCSPResponse"), http.StatusOK, ocsp.UnauthorizedErrorResponse, false},
+		{"fail/internal", "POST", "http://example.com/ocsp", der, nil, errs.Wrap(http.StatusInternalServerError, errors.New("force"), "authority.GetOCSPResponse"), http.StatusOK, ocsp.InternalErrorErrorResponse, false},
+		{"fail/disabled", "POST", "http://example.com/ocsp", der, nil, errs.Wrap(http.StatusNotFound, errors.New("force"), "authority.GetOCSPResponse"), http.StatusNotFound, nil, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockMustAuthority(t, &mockAuthority{
+				getOCSPResponse: func(req *ocsp.Request) (*authority.OCSPResponseInfo, error) {
+					assert.Equal(t, ca.Intermediate.SerialNumber, req.SerialNumber)
+					return tt.info, tt.err
+				},
+			})
+
+			r := chi.NewRouter()
+			r.Post("/ocsp", OCSP)
+			r.Get("/ocsp/*", OCSP)
+
+			req := httptest.NewRequest(tt.method, tt.path, bytes.NewReader(tt.body))
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, req)
+			res := w.Result()
+
+			assert.Equal(t, tt.statusCode, res.StatusCode)
+
+			body, err := io.ReadAll(res.Body)
+			res.Body.Close()
+			require.NoError(t, err)
+
+			if tt.statusCode != http.StatusOK {
+				return
+			}
+
+			assert.Equal(t, "application/ocsp-response", res.Header.Get("Content-Type"))
+			assert.Equal(t, tt.expectedBody, body)
+			if tt.expectsCaching {
+				assert.Equal(t, info.ThisUpdate.UTC().Format(http.TimeFormat), res.Header.Get("Last-Modified"))
+				assert.Equal(t, info.NextUpdate.UTC().Format(http.TimeFormat), res.Header.Get("Expires"))
+				assert.Contains(t, res.Header.Get("Cache-Control"), "public")
+				assert.NotEmpty(t, res.Header.Get("ETag"))
+			} else {
+				assert.Empty(t, res.Header.Get("Cache-Control"))
+			}
+		})
+	}
+}
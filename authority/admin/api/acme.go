@@ -3,11 +3,15 @@ package api
 import (
 	"fmt"
 	"net/http"
+	"time"
 
+	"github.com/go-chi/chi/v5"
 	"github.com/smallstep/linkedca"
 	"google.golang.org/protobuf/types/known/timestamppb"
 
 	"github.com/smallstep/certificates/acme"
+	"github.com/smallstep/certificates/api"
+	"github.com/smallstep/certificates/api/read"
 	"github.com/smallstep/certificates/api/render"
 	"github.com/smallstep/certificates/authority/admin"
 )
@@ -15,6 +19,10 @@ import (
 // CreateExternalAccountKeyRequest is the type for POST /admin/acme/eab requests
 type CreateExternalAccountKeyRequest struct {
 	Reference string `json:"reference"`
+	// NotAfter sets the expiration of the key, if not set the key does not
+	// expire.
+	NotAfter *time.Time `json:"notAfter,omitempty"`
+	MultiUse bool       `json:"multiUse,omitempty"`
 }
 
 // Validate validates a new ACME EAB Key request body.
@@ -22,6 +30,30 @@ func (r *CreateExternalAccountKeyRequest) Validate() error {
 	if len(r.Reference) > 256 { // an arbitrary, but sensible (IMO), limit
 		return fmt.Errorf("reference length %d exceeds the maximum (256)", len(r.Reference))
 	}
+	if r.NotAfter != nil && !r.NotAfter.After(time.Now()) {
+		return fmt.Errorf("notAfter %s is in the past", r.NotAfter.Format(time.RFC3339))
+	}
+	return nil
+}
+
+// UpdateExternalAccountKeyRequest is the type for PATCH /admin/acme/eab
+// requests. Only the properties present in the request are updated.
+type UpdateExternalAccountKeyRequest struct {
+	// NotAfter sets the expiration of the key, the zero time removes it.
+	NotAfter *time.Time `json:"notAfter,omitempty"`
+	MultiUse *bool      `json:"multiUse,omitempty"`
+	// Disabled disables or enables the key and the accounts bound to it.
+	// Accounts are disabled through the key they were bound with, disabling
+	// a multi-use key disables all its accounts; use a single-use key per
+	// account to be able to disable them individually.
+	Disabled *bool `json:"disabled,omitempty"`
+}
+
+// Validate validates an update ACME EAB Key request body.
+func (r *UpdateExternalAccountKeyRequest) Validate() error {
+	if r.NotAfter == nil && r.MultiUse == nil && r.Disabled == nil {
+		return admin.NewError(admin.ErrorBadRequestType, "update ACME EAB Key request must set notAfter, multiUse or disabled")
+	}
 	return nil
 }
 
@@ -58,6 +90,7 @@ type ACMEAdminResponder interface {
 	GetExternalAccountKeys(w http.ResponseWriter, r *http.Request)
 	CreateExternalAccountKey(w http.ResponseWriter, r *http.Request)
 	DeleteExternalAccountKey(w http.ResponseWriter, r *http.Request)
+	UpdateExternalAccountKey(w http.ResponseWriter, r *http.Request)
 }
 
 // acmeAdminResponder implements ACMEAdminResponder.
@@ -68,19 +101,181 @@ func NewACMEAdminResponder() ACMEAdminResponder {
 	return &acmeAdminResponder{}
 }
 
-// GetExternalAccountKeys writes the response for the EAB keys GET endpoint
+// GetExternalAccountKeys writes the response for the EAB keys GET endpoint.
+// If a reference is present in the URL only the key with that reference is
+// returned. The secrets of the keys are never returned.
 func (h *acmeAdminResponder) GetExternalAccountKeys(w http.ResponseWriter, r *http.Request) {
-	render.Error(w, r, admin.NewError(admin.ErrorNotImplementedType, "this functionality is currently only available in Certificate Manager: https://u.step.sm/cm"))
+	ctx := r.Context()
+	prov := linkedca.MustProvisionerFromContext(ctx)
+	acmeDB := acme.MustDatabaseFromContext(ctx)
+
+	var (
+		keys       []*acme.ExternalAccountKey
+		nextCursor string
+	)
+	if reference := chi.URLParam(r, "reference"); reference != "" {
+		k, err := acmeDB.GetExternalAccountKeyByReference(ctx, prov.GetId(), reference)
+		if err != nil && !acme.IsErrNotFound(err) {
+			render.Error(w, r, admin.WrapErrorISE(err, "error retrieving ACME External Account Key by reference"))
+			return
+		}
+		if k != nil {
+			keys = append(keys, k)
+		}
+	} else {
+		cursor, limit, err := api.ParseCursor(r)
+		if err != nil {
+			render.Error(w, r, admin.WrapError(admin.ErrorBadRequestType, err,
+				"error parsing cursor and limit from query params"))
+			return
+		}
+		if keys, nextCursor, err = acmeDB.GetExternalAccountKeys(ctx, prov.GetId(), cursor, limit); err != nil {
+			render.Error(w, r, admin.WrapErrorISE(err, "error retrieving ACME External Account Keys"))
+			return
+		}
+	}
+
+	eaks := make([]*linkedca.EABKey, len(keys))
+	for i, k := range keys {
+		eaks[i] = eakToLinked(k)
+		eaks[i].HmacKey = nil
+	}
+
+	render.JSON(w, r, &GetExternalAccountKeysResponse{
+		EAKs:       eaks,
+		NextCursor: nextCursor,
+	})
 }
 
-// CreateExternalAccountKey writes the response for the EAB key POST endpoint
+// CreateExternalAccountKey writes the response for the EAB key POST endpoint.
+// The response contains the secret of the key, it cannot be retrieved again.
 func (h *acmeAdminResponder) CreateExternalAccountKey(w http.ResponseWriter, r *http.Request) {
-	render.Error(w, r, admin.NewError(admin.ErrorNotImplementedType, "this functionality is currently only available in Certificate Manager: https://u.step.sm/cm"))
+	var body CreateExternalAccountKeyRequest
+	if err := read.JSON(r.Body, &body); err != nil {
+		render.Error(w, r, admin.WrapError(admin.ErrorBadRequestType, err, "error reading request body"))
+		return
+	}
+	if err := body.Validate(); err != nil {
+		render.Error(w, r, admin.WrapError(admin.ErrorBadRequestType, err, "error validating request body"))
+		return
+	}
+
+	ctx := r.Context()
+	prov := linkedca.MustProvisionerFromContext(ctx)
+	acmeDB := acme.MustDatabaseFromContext(ctx)
+
+	if reference := body.Reference; reference != "" {
+		k, err := acmeDB.GetExternalAccountKeyByReference(ctx, prov.GetId(), reference)
+		if err != nil && !acme.IsErrNotFound(err) {
+			render.Error(w, r, admin.WrapErrorISE(err, "error retrieving ACME External Account Key by reference"))
+			return
+		}
+		if k != nil && k.Reference == reference {
+			err := admin.NewError(admin.ErrorBadRequestType, "an ACME EAB key for provisioner '%s' with reference '%s' already exists", prov.GetName(), reference)
+			err.Status = http.StatusConflict
+			render.Error(w, r, err)
+			return
+		}
+	}
+
+	opts := acme.ExternalAccountKeyOptions{
+		MultiUse: body.MultiUse,
+	}
+	if body.NotAfter != nil {
+		opts.NotAfter = *body.NotAfter
+	}
+
+	eak, err := acmeDB.CreateExternalAccountKey(ctx, prov.GetId(), body.Reference, opts)
+	if err != nil {
+		render.Error(w, r, admin.WrapErrorISE(err, "error creating ACME External Account Key"))
+		return
+	}
+
+	render.ProtoJSONStatus(w, eakToLinked(eak), http.StatusCreated)
 }
 
-// DeleteExternalAccountKey writes the response for the EAB key DELETE endpoint
+// DeleteExternalAccountKey writes the response for the EAB key DELETE
+// endpoint. The accounts bound to the key are no longer bound to it.
 func (h *acmeAdminResponder) DeleteExternalAccountKey(w http.ResponseWriter, r *http.Request) {
-	render.Error(w, r, admin.NewError(admin.ErrorNotImplementedType, "this functionality is currently only available in Certificate Manager: https://u.step.sm/cm"))
+	ctx := r.Context()
+	prov := linkedca.MustProvisionerFromContext(ctx)
+	acmeDB := acme.MustDatabaseFromContext(ctx)
+	keyID := chi.URLParam(r, "id")
+
+	if _, err := acmeDB.GetExternalAccountKey(ctx, prov.GetId(), keyID); err != nil {
+		if acme.IsErrNotFound(err) {
+			render.Error(w, r, admin.NewError(admin.ErrorNotFoundType, "ACME External Account Key not found"))
+			return
+		}
+		render.Error(w, r, admin.WrapErrorISE(err, "error retrieving ACME External Account Key"))
+		return
+	}
+
+	if err := acmeDB.DeleteExternalAccountKey(ctx, prov.GetId(), keyID); err != nil {
+		render.Error(w, r, admin.WrapErrorISE(err, "error deleting ACME External Account Key %s", keyID))
+		return
+	}
+
+	render.JSON(w, r, &DeleteResponse{Status: "ok"})
+}
+
+// UpdateExternalAccountKey writes the response for the EAB key PATCH endpoint.
+// It updates the lifecycle properties of the key: its expiration, if it can be
+// bound to multiple accounts, and if the key and the accounts bound to it are
+// disabled.
+func (h *acmeAdminResponder) UpdateExternalAccountKey(w http.ResponseWriter, r *http.Request) {
+	var body UpdateExternalAccountKeyRequest
+	if err := read.JSON(r.Body, &body); err != nil {
+		render.Error(w, r, admin.WrapError(admin.ErrorBadRequestType, err, "error reading request body"))
+		return
+	}
+	if err := body.Validate(); err != nil {
+		render.Error(w, r, err)
+		return
+	}
+
+	ctx := r.Context()
+	prov := linkedca.MustProvisionerFromContext(ctx)
+	acmeDB := acme.MustDatabaseFromContext(ctx)
+	keyID := chi.URLParam(r, "id")
+
+	eak, err := acmeDB.GetExternalAccountKey(ctx, prov.GetId(), keyID)
+	if err != nil {
+		if acme.IsErrNotFound(err) {
+			render.Error(w, r, admin.NewError(admin.ErrorNotFoundType, "ACME External Account Key not found"))
+			return
+		}
+		render.Error(w, r, admin.WrapErrorISE(err, "error retrieving ACME External Account Key"))
+		return
+	}
+	if eak == nil {
+		render.Error(w, r, admin.NewError(admin.ErrorNotFoundType, "ACME External Account Key not found"))
+		return
+	}
+
+	if body.NotAfter != nil {
+		eak.NotAfter = *body.NotAfter
+	}
+	if body.MultiUse != nil {
+		if !*body.MultiUse && eak.MultiUse && eak.AlreadyBound() {
+			// single-use keys do not keep the secret once bound
+			eak.HmacKey = []byte{}
+		}
+		eak.MultiUse = *body.MultiUse
+	}
+	if body.Disabled != nil {
+		eak.Disabled = *body.Disabled
+	}
+
+	if err := acmeDB.UpdateExternalAccountKey(ctx, prov.GetId(), eak); err != nil {
+		render.Error(w, r, admin.WrapErrorISE(err, "error updating ACME External Account Key"))
+		return
+	}
+
+	// the secret of multi-use keys is only returned on creation
+	linkedEAK := eakToLinked(eak)
+	linkedEAK.HmacKey = nil
+	render.ProtoJSON(w, linkedEAK)
 }
 
 func eakToLinked(k *acme.ExternalAccountKey) *linkedca.EABKey {
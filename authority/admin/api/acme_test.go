@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"io"
 	"net/http"
 	"net/http/httptest"
@@ -155,8 +156,11 @@ func TestHandler_requireEABEnabled(t *testing.T) {
 }
 
 func TestCreateExternalAccountKeyRequest_Validate(t *testing.T) {
+	past := time.Now().Add(-time.Minute)
+	future := time.Now().Add(time.Hour)
 	type fields struct {
 		Reference string
+		NotAfter  *time.Time
 	}
 	tests := []struct {
 		name    string
@@ -170,6 +174,22 @@ func TestCreateExternalAccountKeyRequest_Validate(t *testing.T) {
 			},
 			wantErr: true,
 		},
+		{
+			name: "fail/not-after-in-the-past",
+			fields: fields{
+				Reference: "my-eab-reference",
+				NotAfter:  &past,
+			},
+			wantErr: true,
+		},
+		{
+			name: "ok/not-after",
+			fields: fields{
+				Reference: "my-eab-reference",
+				NotAfter:  &future,
+			},
+			wantErr: false,
+		},
 		{
 			name: "ok/empty-reference",
 			fields: fields{
@@ -189,6 +209,7 @@ func TestCreateExternalAccountKeyRequest_Validate(t *testing.T) {
 		t.Run(tt.name, func(t *testing.T) {
 			r := &CreateExternalAccountKeyRequest{
 				Reference: tt.fields.Reference,
+				NotAfter:  tt.fields.NotAfter,
 			}
 			if err := r.Validate(); (err != nil) != tt.wantErr {
 				t.Errorf("CreateExternalAccountKeyRequest.Validate() error = %v, wantErr %v", err, tt.wantErr)
@@ -198,23 +219,148 @@ func TestCreateExternalAccountKeyRequest_Validate(t *testing.T) {
 }
 
 func TestHandler_CreateExternalAccountKey(t *testing.T) {
+	prov := &linkedca.Provisioner{
+		Id:   "provID",
+		Name: "provName",
+	}
+	notAfter := time.Now().Add(time.Hour).Truncate(time.Second).UTC()
+	newEAK := func() *acme.ExternalAccountKey {
+		return &acme.ExternalAccountKey{
+			ID:            "eakID",
+			ProvisionerID: "provID",
+			Reference:     "ref",
+			HmacKey:       []byte{1, 3, 3, 7},
+			CreatedAt:     time.Now(),
+		}
+	}
 	type test struct {
-		ctx        context.Context
+		db         acme.DB
+		body       string
 		statusCode int
 		err        *admin.Error
+		want       *linkedca.EABKey
 	}
 	var tests = map[string]func(t *testing.T) test{
-		"ok": func(t *testing.T) test {
-			chiCtx := chi.NewRouteContext()
-			ctx := context.WithValue(context.Background(), chi.RouteCtxKey, chiCtx)
+		"fail/read.JSON": func(t *testing.T) test {
 			return test{
-				ctx:        ctx,
-				statusCode: 501,
+				db:         &acme.MockDB{},
+				body:       "{",
+				statusCode: 400,
+				err: &admin.Error{
+					Type:    admin.ErrorBadRequestType.String(),
+					Status:  400,
+					Detail:  "bad request",
+					Message: "error reading request body: error decoding json: unexpected EOF",
+				},
+			}
+		},
+		"fail/validate": func(t *testing.T) test {
+			return test{
+				db:         &acme.MockDB{},
+				body:       `{"notAfter":"2020-01-01T00:00:00Z"}`,
+				statusCode: 400,
+				err: &admin.Error{
+					Type:    admin.ErrorBadRequestType.String(),
+					Status:  400,
+					Detail:  "bad request",
+					Message: "error validating request body: notAfter 2020-01-01T00:00:00Z is in the past",
+				},
+			}
+		},
+		"fail/reference-lookup": func(t *testing.T) test {
+			return test{
+				db: &acme.MockDB{
+					MockGetExternalAccountKeyByReference: func(ctx context.Context, provisionerID, reference string) (*acme.ExternalAccountKey, error) {
+						return nil, errors.New("force")
+					},
+				},
+				body:       `{"reference":"ref"}`,
+				statusCode: 500,
+				err: &admin.Error{
+					Type:    admin.ErrorServerInternalType.String(),
+					Status:  500,
+					Detail:  "the server experienced an internal error",
+					Message: "error retrieving ACME External Account Key by reference: force",
+				},
+			}
+		},
+		"fail/reference-exists": func(t *testing.T) test {
+			return test{
+				db: &acme.MockDB{
+					MockGetExternalAccountKeyByReference: func(ctx context.Context, provisionerID, reference string) (*acme.ExternalAccountKey, error) {
+						return newEAK(), nil
+					},
+				},
+				body:       `{"reference":"ref"}`,
+				statusCode: 409,
 				err: &admin.Error{
-					Type:    admin.ErrorNotImplementedType.String(),
-					Status:  http.StatusNotImplemented,
-					Message: "this functionality is currently only available in Certificate Manager: https://u.step.sm/cm",
-					Detail:  "not implemented",
+					Type:    admin.ErrorBadRequestType.String(),
+					Status:  409,
+					Detail:  "bad request",
+					Message: "an ACME EAB key for provisioner 'provName' with reference 'ref' already exists",
+				},
+			}
+		},
+		"fail/create": func(t *testing.T) test {
+			return test{
+				db: &acme.MockDB{
+					MockGetExternalAccountKeyByReference: func(ctx context.Context, provisionerID, reference string) (*acme.ExternalAccountKey, error) {
+						return nil, acme.ErrNotFound
+					},
+					MockCreateExternalAccountKey: func(ctx context.Context, provisionerID, reference string, opts acme.ExternalAccountKeyOptions) (*acme.ExternalAccountKey, error) {
+						return nil, errors.New("force")
+					},
+				},
+				body:       `{"reference":"ref"}`,
+				statusCode: 500,
+				err: &admin.Error{
+					Type:    admin.ErrorServerInternalType.String(),
+					Status:  500,
+					Detail:  "the server experienced an internal error",
+					Message: "error creating ACME External Account Key: force",
+				},
+			}
+		},
+		"ok": func(t *testing.T) test {
+			return test{
+				db: &acme.MockDB{
+					MockCreateExternalAccountKey: func(ctx context.Context, provisionerID, reference string, opts acme.ExternalAccountKeyOptions) (*acme.ExternalAccountKey, error) {
+						assert.Equals(t, "provID", provisionerID)
+						assert.Equals(t, "", reference)
+						assert.Equals(t, acme.ExternalAccountKeyOptions{}, opts)
+						eak := newEAK()
+						eak.Reference = ""
+						return eak, nil
+					},
+				},
+				body:       `{}`,
+				statusCode: 201,
+				want: &linkedca.EABKey{
+					Id:          "eakID",
+					HmacKey:     []byte{1, 3, 3, 7},
+					Provisioner: "provID",
+				},
+			}
+		},
+		"ok/lifecycle": func(t *testing.T) test {
+			return test{
+				db: &acme.MockDB{
+					MockGetExternalAccountKeyByReference: func(ctx context.Context, provisionerID, reference string) (*acme.ExternalAccountKey, error) {
+						return nil, acme.ErrNotFound
+					},
+					MockCreateExternalAccountKey: func(ctx context.Context, provisionerID, reference string, opts acme.ExternalAccountKeyOptions) (*acme.ExternalAccountKey, error) {
+						assert.Equals(t, "ref", reference)
+						assert.Equals(t, acme.ExternalAccountKeyOptions{NotAfter: notAfter, MultiUse: true}, opts)
+						return newEAK(), nil
+					},
+				},
+				body:       `{"reference":"ref","multiUse":true,"notAfter":"` + notAfter.Format(time.RFC3339) + `"}`,
+				statusCode: 201,
+				want: &linkedca.EABKey{
+					Id:          "eakID",
+					HmacKey:     []byte{1, 3, 3, 7},
+					Provisioner: "provID",
+					Reference:   "ref",
 				},
 			}
 		},
@@ -222,65 +368,310 @@ func TestHandler_CreateExternalAccountKey(t *testing.T) {
 	for name, prep := range tests {
 		tc := prep(t)
 		t.Run(name, func(t *testing.T) {
-
-			req := httptest.NewRequest("POST", "/foo", http.NoBody) // chi routing is prepared in test setup
-			req = req.WithContext(tc.ctx)
+			chiCtx := chi.NewRouteContext()
+			chiCtx.URLParams.Add("provisionerName", "provName")
+			ctx := context.WithValue(context.Background(), chi.RouteCtxKey, chiCtx)
+			ctx = linkedca.NewContextWithProvisioner(ctx, prov)
+			ctx = acme.NewDatabaseContext(ctx, tc.db)
+			req := httptest.NewRequest("POST", "/foo", strings.NewReader(tc.body)).WithContext(ctx)
 			w := httptest.NewRecorder()
 			acmeResponder := NewACMEAdminResponder()
 			acmeResponder.CreateExternalAccountKey(w, req)
 			res := w.Result()
 			assert.Equals(t, tc.statusCode, res.StatusCode)
 
-			body, err := io.ReadAll(res.Body)
-			res.Body.Close()
-			assert.FatalError(t, err)
-
-			adminErr := admin.Error{}
-			assert.FatalError(t, json.Unmarshal(bytes.TrimSpace(body), &adminErr))
+			if res.StatusCode >= 400 {
+				body, err := io.ReadAll(res.Body)
+				res.Body.Close()
+				assert.FatalError(t, err)
 
-			assert.Equals(t, tc.err.Type, adminErr.Type)
-			assert.Equals(t, tc.err.Message, adminErr.Message)
-			assert.Equals(t, tc.err.StatusCode(), res.StatusCode)
-			assert.Equals(t, tc.err.Detail, adminErr.Detail)
-			assert.Equals(t, []string{"application/json"}, res.Header["Content-Type"])
+				adminErr := admin.Error{}
+				assert.FatalError(t, json.Unmarshal(bytes.TrimSpace(body), &adminErr))
+				assert.Equals(t, tc.err.Type, adminErr.Type)
+				assert.Equals(t, tc.err.Message, adminErr.Message)
+				assert.Equals(t, tc.err.Detail, adminErr.Detail)
+				assert.Equals(t, []string{"application/json"}, res.Header["Content-Type"])
+				return
+			}
 
+			got := &linkedca.EABKey{}
+			assert.FatalError(t, readProtoJSON(res.Body, got))
+			assert.Equals(t, tc.want.Id, got.Id)
+			assert.Equals(t, tc.want.HmacKey, got.HmacKey)
+			assert.Equals(t, tc.want.Provisioner, got.Provisioner)
+			assert.Equals(t, tc.want.Reference, got.Reference)
 		})
 	}
 }
 
 func TestHandler_DeleteExternalAccountKey(t *testing.T) {
+	prov := &linkedca.Provisioner{
+		Id:   "provID",
+		Name: "provName",
+	}
 	type test struct {
-		ctx        context.Context
+		db         acme.DB
 		statusCode int
 		err        *admin.Error
 	}
 	var tests = map[string]func(t *testing.T) test{
+		"fail/not-found": func(t *testing.T) test {
+			return test{
+				db: &acme.MockDB{
+					MockGetExternalAccountKey: func(ctx context.Context, provisionerID, keyID string) (*acme.ExternalAccountKey, error) {
+						return nil, acme.ErrNotFound
+					},
+				},
+				statusCode: 404,
+				err: &admin.Error{
+					Type:    admin.ErrorNotFoundType.String(),
+					Status:  404,
+					Detail:  "resource not found",
+					Message: "ACME External Account Key not found",
+				},
+			}
+		},
+		"fail/get": func(t *testing.T) test {
+			return test{
+				db: &acme.MockDB{
+					MockGetExternalAccountKey: func(ctx context.Context, provisionerID, keyID string) (*acme.ExternalAccountKey, error) {
+						return nil, errors.New("force")
+					},
+				},
+				statusCode: 500,
+				err: &admin.Error{
+					Type:    admin.ErrorServerInternalType.String(),
+					Status:  500,
+					Detail:  "the server experienced an internal error",
+					Message: "error retrieving ACME External Account Key: force",
+				},
+			}
+		},
+		"fail/delete": func(t *testing.T) test {
+			return test{
+				db: &acme.MockDB{
+					MockGetExternalAccountKey: func(ctx context.Context, provisionerID, keyID string) (*acme.ExternalAccountKey, error) {
+						return &acme.ExternalAccountKey{ID: "keyID", ProvisionerID: "provID"}, nil
+					},
+					MockDeleteExternalAccountKey: func(ctx context.Context, provisionerID, keyID string) error {
+						return errors.New("force")
+					},
+				},
+				statusCode: 500,
+				err: &admin.Error{
+					Type:    admin.ErrorServerInternalType.String(),
+					Status:  500,
+					Detail:  "the server experienced an internal error",
+					Message: "error deleting ACME External Account Key keyID: force",
+				},
+			}
+		},
 		"ok": func(t *testing.T) test {
+			deleted := false
+			t.Cleanup(func() {
+				assert.True(t, deleted)
+			})
+			return test{
+				db: &acme.MockDB{
+					MockGetExternalAccountKey: func(ctx context.Context, provisionerID, keyID string) (*acme.ExternalAccountKey, error) {
+						assert.Equals(t, "provID", provisionerID)
+						assert.Equals(t, "keyID", keyID)
+						return &acme.ExternalAccountKey{ID: "keyID", ProvisionerID: "provID"}, nil
+					},
+					MockDeleteExternalAccountKey: func(ctx context.Context, provisionerID, keyID string) error {
+						assert.Equals(t, "provID", provisionerID)
+						assert.Equals(t, "keyID", keyID)
+						deleted = true
+						return nil
+					},
+				},
+				statusCode: 200,
+			}
+		},
+	}
+	for name, prep := range tests {
+		tc := prep(t)
+		t.Run(name, func(t *testing.T) {
 			chiCtx := chi.NewRouteContext()
 			chiCtx.URLParams.Add("provisionerName", "provName")
 			chiCtx.URLParams.Add("id", "keyID")
 			ctx := context.WithValue(context.Background(), chi.RouteCtxKey, chiCtx)
+			ctx = linkedca.NewContextWithProvisioner(ctx, prov)
+			ctx = acme.NewDatabaseContext(ctx, tc.db)
+			req := httptest.NewRequest("DELETE", "/foo", http.NoBody).WithContext(ctx)
+			w := httptest.NewRecorder()
+			acmeResponder := NewACMEAdminResponder()
+			acmeResponder.DeleteExternalAccountKey(w, req)
+			res := w.Result()
+			assert.Equals(t, tc.statusCode, res.StatusCode)
+
+			body, err := io.ReadAll(res.Body)
+			res.Body.Close()
+			assert.FatalError(t, err)
+			assert.Equals(t, []string{"application/json"}, res.Header["Content-Type"])
+
+			if res.StatusCode >= 400 {
+				adminErr := admin.Error{}
+				assert.FatalError(t, json.Unmarshal(bytes.TrimSpace(body), &adminErr))
+				assert.Equals(t, tc.err.Type, adminErr.Type)
+				assert.Equals(t, tc.err.Message, adminErr.Message)
+				assert.Equals(t, tc.err.Detail, adminErr.Detail)
+				return
+			}
+
+			response := DeleteResponse{}
+			assert.FatalError(t, json.Unmarshal(bytes.TrimSpace(body), &response))
+			assert.Equals(t, "ok", response.Status)
+		})
+	}
+}
+
+func TestHandler_UpdateExternalAccountKey(t *testing.T) {
+	prov := &linkedca.Provisioner{
+		Id:   "provID",
+		Name: "provName",
+	}
+	notAfter := time.Now().Add(time.Hour).Truncate(time.Second).UTC()
+	newEAK := func() *acme.ExternalAccountKey {
+		return &acme.ExternalAccountKey{
+			ID:            "eakID",
+			ProvisionerID: "provID",
+			Reference:     "ref",
+			AccountID:     "accountID",
+			HmacKey:       []byte{1, 3, 3, 7},
+			BoundAt:       time.Now(),
+			MultiUse:      true,
+		}
+	}
+	type test struct {
+		db         acme.DB
+		body       string
+		statusCode int
+		err        *admin.Error
+		want       *acme.ExternalAccountKey
+	}
+	var tests = map[string]func(t *testing.T) test{
+		"fail/read.JSON": func(t *testing.T) test {
 			return test{
-				ctx:        ctx,
-				statusCode: 501,
+				db:         &acme.MockDB{},
+				body:       "{",
+				statusCode: 400,
+				err: &admin.Error{
+					Type:    admin.ErrorBadRequestType.String(),
+					Status:  400,
+					Detail:  "bad request",
+					Message: "error reading request body: error decoding json: unexpected EOF",
+				},
+			}
+		},
+		"fail/validate": func(t *testing.T) test {
+			return test{
+				db:         &acme.MockDB{},
+				body:       "{}",
+				statusCode: 400,
+				err: &admin.Error{
+					Type:    admin.ErrorBadRequestType.String(),
+					Status:  400,
+					Detail:  "bad request",
+					Message: "update ACME EAB Key request must set notAfter, multiUse or disabled",
+				},
+			}
+		},
+		"fail/not-found": func(t *testing.T) test {
+			return test{
+				db: &acme.MockDB{
+					MockGetExternalAccountKey: func(ctx context.Context, provisionerID, keyID string) (*acme.ExternalAccountKey, error) {
+						return nil, acme.ErrNotFound
+					},
+				},
+				body:       `{"disabled":true}`,
+				statusCode: 404,
 				err: &admin.Error{
-					Type:    admin.ErrorNotImplementedType.String(),
-					Status:  http.StatusNotImplemented,
-					Message: "this functionality is currently only available in Certificate Manager: https://u.step.sm/cm",
-					Detail:  "not implemented",
+					Type:    admin.ErrorNotFoundType.String(),
+					Status:  404,
+					Detail:  "resource not found",
+					Message: "ACME External Account Key not found",
 				},
 			}
 		},
+		"fail/update": func(t *testing.T) test {
+			return test{
+				db: &acme.MockDB{
+					MockGetExternalAccountKey: func(ctx context.Context, provisionerID, keyID string) (*acme.ExternalAccountKey, error) {
+						return newEAK(), nil
+					},
+					MockUpdateExternalAccountKey: func(ctx context.Context, provisionerID string, eak *acme.ExternalAccountKey) error {
+						return errors.New("force")
+					},
+				},
+				body:       `{"disabled":true}`,
+				statusCode: 500,
+				err: &admin.Error{
+					Type:    admin.ErrorServerInternalType.String(),
+					Status:  500,
+					Detail:  "the server experienced an internal error",
+					Message: "error updating ACME External Account Key: force",
+				},
+			}
+		},
+		"ok/disable": func(t *testing.T) test {
+			want := newEAK()
+			want.Disabled = true
+			return test{
+				db: &acme.MockDB{
+					MockGetExternalAccountKey: func(ctx context.Context, provisionerID, keyID string) (*acme.ExternalAccountKey, error) {
+						assert.Equals(t, "provID", provisionerID)
+						assert.Equals(t, "eakID", keyID)
+						return newEAK(), nil
+					},
+					MockUpdateExternalAccountKey: func(ctx context.Context, provisionerID string, eak *acme.ExternalAccountKey) error {
+						assert.Equals(t, "provID", provisionerID)
+						assert.True(t, eak.Disabled)
+						assert.True(t, eak.MultiUse)
+						assert.Equals(t, []byte{1, 3, 3, 7}, eak.HmacKey)
+						return nil
+					},
+				},
+				body:       `{"disabled":true}`,
+				statusCode: 200,
+				want:       want,
+			}
+		},
+		"ok/single-use": func(t *testing.T) test {
+			want := newEAK()
+			want.MultiUse = false
+			want.NotAfter = notAfter
+			return test{
+				db: &acme.MockDB{
+					MockGetExternalAccountKey: func(ctx context.Context, provisionerID, keyID string) (*acme.ExternalAccountKey, error) {
+						return newEAK(), nil
+					},
+					MockUpdateExternalAccountKey: func(ctx context.Context, provisionerID string, eak *acme.ExternalAccountKey) error {
+						assert.False(t, eak.MultiUse)
+						assert.Equals(t, notAfter, eak.NotAfter)
+						assert.Equals(t, []byte{}, eak.HmacKey)
+						return nil
+					},
+				},
+				body:       `{"multiUse":false,"notAfter":"` + notAfter.Format(time.RFC3339) + `"}`,
+				statusCode: 200,
+				want:       want,
+			}
+		},
 	}
 	for name, prep := range tests {
 		tc := prep(t)
 		t.Run(name, func(t *testing.T) {
-
-			req := httptest.NewRequest("DELETE", "/foo", http.NoBody) // chi routing is prepared in test setup
-			req = req.WithContext(tc.ctx)
+			chiCtx := chi.NewRouteContext()
+			chiCtx.URLParams.Add("provisionerName", "provName")
+			chiCtx.URLParams.Add("id", "eakID")
+			ctx := context.WithValue(context.Background(), chi.RouteCtxKey, chiCtx)
+			ctx = linkedca.NewContextWithProvisioner(ctx, prov)
+			ctx = acme.NewDatabaseContext(ctx, tc.db)
+			req := httptest.NewRequest("PATCH", "/foo", strings.NewReader(tc.body)).WithContext(ctx)
 			w := httptest.NewRecorder()
 			acmeResponder := NewACMEAdminResponder()
-			acmeResponder.DeleteExternalAccountKey(w, req)
+			acmeResponder.UpdateExternalAccountKey(w, req)
 			res := w.Result()
 			assert.Equals(t, tc.statusCode, res.StatusCode)
 
@@ -288,40 +679,145 @@ func TestHandler_DeleteExternalAccountKey(t *testing.T) {
 			res.Body.Close()
 			assert.FatalError(t, err)
 
-			adminErr := admin.Error{}
-			assert.FatalError(t, json.Unmarshal(bytes.TrimSpace(body), &adminErr))
+			if res.StatusCode >= 400 {
+				adminErr := admin.Error{}
+				assert.FatalError(t, json.Unmarshal(bytes.TrimSpace(body), &adminErr))
+				assert.Equals(t, tc.err.Type, adminErr.Type)
+				assert.Equals(t, tc.err.Message, adminErr.Message)
+				assert.Equals(t, tc.err.Detail, adminErr.Detail)
+				return
+			}
 
-			assert.Equals(t, tc.err.Type, adminErr.Type)
-			assert.Equals(t, tc.err.Message, adminErr.Message)
-			assert.Equals(t, tc.err.StatusCode(), res.StatusCode)
-			assert.Equals(t, tc.err.Detail, adminErr.Detail)
-			assert.Equals(t, []string{"application/json"}, res.Header["Content-Type"])
+			got := &linkedca.EABKey{}
+			assert.FatalError(t, protojson.Unmarshal(body, got))
+			assert.Equals(t, tc.want.ID, got.Id)
+			assert.Equals(t, tc.want.Reference, got.Reference)
+			assert.Equals(t, tc.want.AccountID, got.Account)
+			assert.Len(t, 0, got.HmacKey)
 		})
 	}
 }
 
 func TestHandler_GetExternalAccountKeys(t *testing.T) {
+	prov := &linkedca.Provisioner{
+		Id:   "provID",
+		Name: "provName",
+	}
+	newEAK := func(id, reference string) *acme.ExternalAccountKey {
+		return &acme.ExternalAccountKey{
+			ID:            id,
+			ProvisionerID: "provID",
+			Reference:     reference,
+			HmacKey:       []byte{1, 3, 3, 7},
+			CreatedAt:     time.Now(),
+			MultiUse:      true,
+		}
+	}
 	type test struct {
-		ctx        context.Context
+		db         acme.DB
+		reference  string
+		query      string
 		statusCode int
-		req        *http.Request
 		err        *admin.Error
+		want       GetExternalAccountKeysResponse
 	}
 	var tests = map[string]func(t *testing.T) test{
-		"ok": func(t *testing.T) test {
-			chiCtx := chi.NewRouteContext()
-			chiCtx.URLParams.Add("provisionerName", "provName")
-			req := httptest.NewRequest("GET", "/foo", http.NoBody)
-			ctx := context.WithValue(context.Background(), chi.RouteCtxKey, chiCtx)
+		"fail/parse-cursor": func(t *testing.T) test {
 			return test{
-				ctx:        ctx,
-				statusCode: 501,
-				req:        req,
+				db:         &acme.MockDB{},
+				query:      "?limit=foo",
+				statusCode: 400,
 				err: &admin.Error{
-					Type:    admin.ErrorNotImplementedType.String(),
-					Status:  http.StatusNotImplemented,
-					Message: "this functionality is currently only available in Certificate Manager: https://u.step.sm/cm",
-					Detail:  "not implemented",
+					Type:    admin.ErrorBadRequestType.String(),
+					Status:  400,
+					Detail:  "bad request",
+					Message: "error parsing cursor and limit from query params: limit 'foo' is not an integer: strconv.Atoi: parsing \"foo\": invalid syntax",
+				},
+			}
+		},
+		"fail/get-keys": func(t *testing.T) test {
+			return test{
+				db: &acme.MockDB{
+					MockGetExternalAccountKeys: func(ctx context.Context, provisionerID, cursor string, limit int) ([]*acme.ExternalAccountKey, string, error) {
+						return nil, "", errors.New("force")
+					},
+				},
+				statusCode: 500,
+				err: &admin.Error{
+					Type:    admin.ErrorServerInternalType.String(),
+					Status:  500,
+					Detail:  "the server experienced an internal error",
+					Message: "error retrieving ACME External Account Keys: force",
+				},
+			}
+		},
+		"fail/get-by-reference": func(t *testing.T) test {
+			return test{
+				db: &acme.MockDB{
+					MockGetExternalAccountKeyByReference: func(ctx context.Context, provisionerID, reference string) (*acme.ExternalAccountKey, error) {
+						return nil, errors.New("force")
+					},
+				},
+				reference:  "ref",
+				statusCode: 500,
+				err: &admin.Error{
+					Type:    admin.ErrorServerInternalType.String(),
+					Status:  500,
+					Detail:  "the server experienced an internal error",
+					Message: "error retrieving ACME External Account Key by reference: force",
+				},
+			}
+		},
+		"ok": func(t *testing.T) test {
+			return test{
+				db: &acme.MockDB{
+					MockGetExternalAccountKeys: func(ctx context.Context, provisionerID, cursor string, limit int) ([]*acme.ExternalAccountKey, string, error) {
+						assert.Equals(t, "provID", provisionerID)
+						assert.Equals(t, "cursor", cursor)
+						assert.Equals(t, 10, limit)
+						return []*acme.ExternalAccountKey{newEAK("eakID1", "ref1"), newEAK("eakID2", "")}, "next", nil
+					},
+				},
+				query:      "?cursor=cursor&limit=10",
+				statusCode: 200,
+				want: GetExternalAccountKeysResponse{
+					EAKs: []*linkedca.EABKey{
+						{Id: "eakID1", Provisioner: "provID", Reference: "ref1"},
+						{Id: "eakID2", Provisioner: "provID"},
+					},
+					NextCursor: "next",
+				},
+			}
+		},
+		"ok/reference": func(t *testing.T) test {
+			return test{
+				db: &acme.MockDB{
+					MockGetExternalAccountKeyByReference: func(ctx context.Context, provisionerID, reference string) (*acme.ExternalAccountKey, error) {
+						assert.Equals(t, "provID", provisionerID)
+						assert.Equals(t, "ref", reference)
+						return newEAK("eakID", "ref"), nil
+					},
+				},
+				reference:  "ref",
+				statusCode: 200,
+				want: GetExternalAccountKeysResponse{
+					EAKs: []*linkedca.EABKey{
+						{Id: "eakID", Provisioner: "provID", Reference: "ref"},
+					},
+				},
+			}
+		},
+		"ok/reference-not-found": func(t *testing.T) test {
+			return test{
+				db: &acme.MockDB{
+					MockGetExternalAccountKeyByReference: func(ctx context.Context, provisionerID, reference string) (*acme.ExternalAccountKey, error) {
+						return nil, acme.ErrNotFound
+					},
+				},
+				reference:  "ref",
+				statusCode: 200,
+				want: GetExternalAccountKeysResponse{
+					EAKs: []*linkedca.EABKey{},
 				},
 			}
 		},
@@ -329,8 +825,15 @@ func TestHandler_GetExternalAccountKeys(t *testing.T) {
 	for name, prep := range tests {
 		tc := prep(t)
 		t.Run(name, func(t *testing.T) {
-
-			req := tc.req.WithContext(tc.ctx)
+			chiCtx := chi.NewRouteContext()
+			chiCtx.URLParams.Add("provisionerName", "provName")
+			if tc.reference != "" {
+				chiCtx.URLParams.Add("reference", tc.reference)
+			}
+			ctx := context.WithValue(context.Background(), chi.RouteCtxKey, chiCtx)
+			ctx = linkedca.NewContextWithProvisioner(ctx, prov)
+			ctx = acme.NewDatabaseContext(ctx, tc.db)
+			req := httptest.NewRequest("GET", "/foo"+tc.query, http.NoBody).WithContext(ctx)
 			w := httptest.NewRecorder()
 			acmeResponder := NewACMEAdminResponder()
 			acmeResponder.GetExternalAccountKeys(w, req)
@@ -341,15 +844,28 @@ func TestHandler_GetExternalAccountKeys(t *testing.T) {
 			body, err := io.ReadAll(res.Body)
 			res.Body.Close()
 			assert.FatalError(t, err)
+			assert.Equals(t, []string{"application/json"}, res.Header["Content-Type"])
 
-			adminErr := admin.Error{}
-			assert.FatalError(t, json.Unmarshal(bytes.TrimSpace(body), &adminErr))
+			if res.StatusCode >= 400 {
+				adminErr := admin.Error{}
+				assert.FatalError(t, json.Unmarshal(bytes.TrimSpace(body), &adminErr))
+				assert.Equals(t, tc.err.Type, adminErr.Type)
+				assert.Equals(t, tc.err.Message, adminErr.Message)
+				assert.Equals(t, tc.err.Detail, adminErr.Detail)
+				return
+			}
 
-			assert.Equals(t, tc.err.Type, adminErr.Type)
-			assert.Equals(t, tc.err.Message, adminErr.Message)
-			assert.Equals(t, tc.err.StatusCode(), res.StatusCode)
-			assert.Equals(t, tc.err.Detail, adminErr.Detail)
-			assert.Equals(t, []string{"application/json"}, res.Header["Content-Type"])
+			got := GetExternalAccountKeysResponse{}
+			assert.FatalError(t, json.Unmarshal(bytes.TrimSpace(body), &got))
+			assert.Equals(t, tc.want.NextCursor, got.NextCursor)
+			if assert.Len(t, len(tc.want.EAKs), got.EAKs) {
+				for i, want := range tc.want.EAKs {
+					assert.Equals(t, want.Id, got.EAKs[i].Id)
+					assert.Equals(t, want.Provisioner, got.EAKs[i].Provisioner)
+					assert.Equals(t, want.Reference, got.EAKs[i].Reference)
+					assert.Len(t, 0, got.EAKs[i].HmacKey)
+				}
+			}
 		})
 	}
 }
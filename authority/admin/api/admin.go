@@ -3,6 +3,7 @@ package api
 import (
 	"context"
 	"net/http"
+	"time"
 
 	"github.com/go-chi/chi/v5"
 
@@ -13,6 +14,7 @@ import (
 	"github.com/smallstep/certificates/api/render"
 	"github.com/smallstep/certificates/authority/admin"
 	"github.com/smallstep/certificates/authority/provisioner"
+	"github.com/smallstep/certificates/db"
 )
 
 type adminAuthority interface {
@@ -33,6 +35,14 @@ type adminAuthority interface {
 	CreateAuthorityPolicy(ctx context.Context, admin *linkedca.Admin, policy *linkedca.Policy) (*linkedca.Policy, error)
 	UpdateAuthorityPolicy(ctx context.Context, admin *linkedca.Admin, policy *linkedca.Policy) (*linkedca.Policy, error)
 	RemoveAuthorityPolicy(ctx context.Context) error
+	SearchCertificates(filter *db.CertificateFilter, cursor string, limit int) ([]*db.CertificateEntry, string, error)
+	GetSCEPRequests(provisionerID string) ([]*db.SCEPRequest, error)
+	GetSCEPRequest(provisionerID, transactionID string) (*db.SCEPRequest, error)
+	UpdateSCEPRequestStatus(provisionerID, transactionID, status, reason string) (*db.SCEPRequest, error)
+	CreateSCEPChallenge(provisionerID, subject string, sans []string, maxUses int, duration time.Duration) (string, *db.SCEPChallenge, error)
+	GetSCEPChallenges(provisionerID string) ([]*db.SCEPChallenge, error)
+	GetSCEPChallenge(provisionerID, id string) (*db.SCEPChallenge, error)
+	DeleteSCEPChallenge(provisionerID, id string) error
 }
 
 // CreateAdminRequest represents the body for a CreateAdmin request.
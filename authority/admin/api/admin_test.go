@@ -21,6 +21,7 @@ import (
 	"github.com/smallstep/assert"
 	"github.com/smallstep/certificates/authority/admin"
 	"github.com/smallstep/certificates/authority/provisioner"
+	"github.com/smallstep/certificates/db"
 )
 
 type mockAdminAuthority struct {
@@ -44,6 +45,17 @@ type mockAdminAuthority struct {
 	MockCreateAuthorityPolicy func(ctx context.Context, adm *linkedca.Admin, policy *linkedca.Policy) (*linkedca.Policy, error)
 	MockUpdateAuthorityPolicy func(ctx context.Context, adm *linkedca.Admin, policy *linkedca.Policy) (*linkedca.Policy, error)
 	MockRemoveAuthorityPolicy func(ctx context.Context) error
+
+	MockSearchCertificates func(filter *db.CertificateFilter, cursor string, limit int) ([]*db.CertificateEntry, string, error)
+
+	MockGetSCEPRequests         func(provisionerID string) ([]*db.SCEPRequest, error)
+	MockGetSCEPRequest          func(provisionerID, transactionID string) (*db.SCEPRequest, error)
+	MockUpdateSCEPRequestStatus func(provisionerID, transactionID, status, reason string) (*db.SCEPRequest, error)
+
+	MockCreateSCEPChallenge func(provisionerID, subject string, sans []string, maxUses int, duration time.Duration) (string, *db.SCEPChallenge, error)
+	MockGetSCEPChallenges   func(provisionerID string) ([]*db.SCEPChallenge, error)
+	MockGetSCEPChallenge    func(provisionerID, id string) (*db.SCEPChallenge, error)
+	MockDeleteSCEPChallenge func(provisionerID, id string) error
 }
 
 func (m *mockAdminAuthority) IsAdminAPIEnabled() bool {
@@ -165,6 +177,62 @@ func (m *mockAdminAuthority) RemoveAuthorityPolicy(ctx context.Context) error {
 	return m.MockErr
 }
 
+func (m *mockAdminAuthority) SearchCertificates(filter *db.CertificateFilter, cursor string, limit int) ([]*db.CertificateEntry, string, error) {
+	if m.MockSearchCertificates != nil {
+		return m.MockSearchCertificates(filter, cursor, limit)
+	}
+	return m.MockRet1.([]*db.CertificateEntry), m.MockRet2.(string), m.MockErr
+}
+
+func (m *mockAdminAuthority) GetSCEPRequests(provisionerID string) ([]*db.SCEPRequest, error) {
+	if m.MockGetSCEPRequests != nil {
+		return m.MockGetSCEPRequests(provisionerID)
+	}
+	return m.MockRet1.([]*db.SCEPRequest), m.MockErr
+}
+
+func (m *mockAdminAuthority) GetSCEPRequest(provisionerID, transactionID string) (*db.SCEPRequest, error) {
+	if m.MockGetSCEPRequest != nil {
+		return m.MockGetSCEPRequest(provisionerID, transactionID)
+	}
+	return m.MockRet1.(*db.SCEPRequest), m.MockErr
+}
+
+func (m *mockAdminAuthority) UpdateSCEPRequestStatus(provisionerID, transactionID, status, reason string) (*db.SCEPRequest, error) {
+	if m.MockUpdateSCEPRequestStatus != nil {
+		return m.MockUpdateSCEPRequestStatus(provisionerID, transactionID, status, reason)
+	}
+	return m.MockRet1.(*db.SCEPRequest), m.MockErr
+}
+
+func (m *mockAdminAuthority) CreateSCEPChallenge(provisionerID, subject string, sans []string, maxUses int, duration time.Duration) (string, *db.SCEPChallenge, error) {
+	if m.MockCreateSCEPChallenge != nil {
+		return m.MockCreateSCEPChallenge(provisionerID, subject, sans, maxUses, duration)
+	}
+	return m.MockRet1.(string), m.MockRet2.(*db.SCEPChallenge), m.MockErr
+}
+
+func (m *mockAdminAuthority) GetSCEPChallenges(provisionerID string) ([]*db.SCEPChallenge, error) {
+	if m.MockGetSCEPChallenges != nil {
+		return m.MockGetSCEPChallenges(provisionerID)
+	}
+	return m.MockRet1.([]*db.SCEPChallenge), m.MockErr
+}
+
+func (m *mockAdminAuthority) GetSCEPChallenge(provisionerID, id string) (*db.SCEPChallenge, error) {
+	if m.MockGetSCEPChallenge != nil {
+		return m.MockGetSCEPChallenge(provisionerID, id)
+	}
+	return m.MockRet1.(*db.SCEPChallenge), m.MockErr
+}
+
+func (m *mockAdminAuthority) DeleteSCEPChallenge(provisionerID, id string) error {
+	if m.MockDeleteSCEPChallenge != nil {
+		return m.MockDeleteSCEPChallenge(provisionerID, id)
+	}
+	return m.MockErr
+}
+
 func TestCreateAdminRequest_Validate(t *testing.T) {
 	type fields struct {
 		Subject     string
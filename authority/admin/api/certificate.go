@@ -0,0 +1,101 @@
+package api
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/smallstep/certificates/api"
+	"github.com/smallstep/certificates/api/render"
+	"github.com/smallstep/certificates/authority/admin"
+	"github.com/smallstep/certificates/db"
+)
+
+// GetCertificatesResponse is the type for GET /admin/certificates responses.
+type GetCertificatesResponse struct {
+	Certificates []*db.CertificateEntry `json:"certificates"`
+	NextCursor   string                 `json:"nextCursor"`
+}
+
+// GetCertificates returns a page of the certificates issued by the authority
+// that satisfy the filters in the query params. The supported filters are
+// type (x509 or ssh), provisioner, san, subject, expiresAfter and
+// expiresBefore in RFC 3339 format, and revoked.
+func GetCertificates(w http.ResponseWriter, r *http.Request) {
+	cursor, limit, err := api.ParseCursor(r)
+	if err != nil {
+		render.Error(w, r, admin.WrapError(admin.ErrorBadRequestType, err,
+			"error parsing cursor and limit from query params"))
+		return
+	}
+
+	filter, err := parseCertificateFilter(r)
+	if err != nil {
+		render.Error(w, r, err)
+		return
+	}
+
+	auth := mustAuthority(r.Context())
+	if name := r.URL.Query().Get("provisioner"); name != "" {
+		p, err := auth.LoadProvisionerByName(name)
+		if err != nil {
+			render.Error(w, r, admin.WrapError(admin.ErrorBadRequestType, err,
+				"error loading provisioner %s", name))
+			return
+		}
+		filter.ProvisionerID = p.GetID()
+	}
+
+	certs, nextCursor, err := auth.SearchCertificates(filter, cursor, limit)
+	if err != nil {
+		render.Error(w, r, admin.WrapErrorISE(err, "error searching certificates"))
+		return
+	}
+
+	render.JSON(w, r, &GetCertificatesResponse{
+		Certificates: certs,
+		NextCursor:   nextCursor,
+	})
+}
+
+// parseCertificateFilter parses the certificate filters from the query params,
+// except the provisioner, that needs to be converted to an id.
+func parseCertificateFilter(r *http.Request) (*db.CertificateFilter, error) {
+	q := r.URL.Query()
+	filter := &db.CertificateFilter{
+		Type:    q.Get("type"),
+		SAN:     q.Get("san"),
+		Subject: q.Get("subject"),
+	}
+
+	switch filter.Type {
+	case "", db.X509CertificateType, db.SSHCertificateType:
+	default:
+		return nil, admin.NewError(admin.ErrorBadRequestType, "type '%s' is not valid", filter.Type)
+	}
+
+	var err error
+	if v := q.Get("expiresAfter"); v != "" {
+		if filter.ExpiresAfter, err = time.Parse(time.RFC3339, v); err != nil {
+			return nil, admin.WrapError(admin.ErrorBadRequestType, err, "expiresAfter '%s' is not a valid time", v)
+		}
+	}
+	if v := q.Get("expiresBefore"); v != "" {
+		if filter.ExpiresBefore, err = time.Parse(time.RFC3339, v); err != nil {
+			return nil, admin.WrapError(admin.ErrorBadRequestType, err, "expiresBefore '%s' is not a valid time", v)
+		}
+	}
+	if !filter.ExpiresAfter.IsZero() && !filter.ExpiresBefore.IsZero() &&
+		filter.ExpiresBefore.Before(filter.ExpiresAfter) {
+		return nil, admin.NewError(admin.ErrorBadRequestType, "expiresBefore cannot be before expiresAfter")
+	}
+	if v := q.Get("revoked"); v != "" {
+		revoked, err := strconv.ParseBool(v)
+		if err != nil {
+			return nil, admin.WrapError(admin.ErrorBadRequestType, err, "revoked '%s' is not a boolean", v)
+		}
+		filter.Revoked = &revoked
+	}
+
+	return filter, nil
+}
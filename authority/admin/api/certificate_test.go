@@ -0,0 +1,184 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/smallstep/assert"
+	"github.com/smallstep/certificates/authority/admin"
+	"github.com/smallstep/certificates/authority/provisioner"
+	"github.com/smallstep/certificates/db"
+)
+
+func TestGetCertificates(t *testing.T) {
+	notAfter := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	certs := []*db.CertificateEntry{
+		{ID: "x509/1", Type: "x509", SerialNumber: "1", Subject: "foo.example.com", SANs: []string{"foo.example.com"}, NotAfter: notAfter},
+		{ID: "ssh/2", Type: "ssh", SerialNumber: "2", Subject: "foo", SANs: []string{"foo"}, NotAfter: notAfter, Revoked: true},
+	}
+	type test struct {
+		auth       adminAuthority
+		req        *http.Request
+		statusCode int
+		err        *admin.Error
+		resp       GetCertificatesResponse
+	}
+	var tests = map[string]func(t *testing.T) test{
+		"fail/parse-cursor": func(t *testing.T) test {
+			return test{
+				req:        httptest.NewRequest("GET", "/foo?limit=A", http.NoBody),
+				statusCode: 400,
+				err: &admin.Error{
+					Type:    admin.ErrorBadRequestType.String(),
+					Detail:  "bad request",
+					Message: "error parsing cursor and limit from query params: limit 'A' is not an integer: strconv.Atoi: parsing \"A\": invalid syntax",
+				},
+			}
+		},
+		"fail/type": func(t *testing.T) test {
+			return test{
+				req:        httptest.NewRequest("GET", "/foo?type=pgp", http.NoBody),
+				statusCode: 400,
+				err: &admin.Error{
+					Type:    admin.ErrorBadRequestType.String(),
+					Detail:  "bad request",
+					Message: "type 'pgp' is not valid",
+				},
+			}
+		},
+		"fail/expiresAfter": func(t *testing.T) test {
+			return test{
+				req:        httptest.NewRequest("GET", "/foo?expiresAfter=yesterday", http.NoBody),
+				statusCode: 400,
+				err: &admin.Error{
+					Type:    admin.ErrorBadRequestType.String(),
+					Detail:  "bad request",
+					Message: "expiresAfter 'yesterday' is not a valid time: parsing time \"yesterday\" as \"2006-01-02T15:04:05Z07:00\": cannot parse \"yesterday\" as \"2006\"",
+				},
+			}
+		},
+		"fail/expiry-window": func(t *testing.T) test {
+			return test{
+				req:        httptest.NewRequest("GET", "/foo?expiresAfter=2024-01-02T00:00:00Z&expiresBefore=2024-01-01T00:00:00Z", http.NoBody),
+				statusCode: 400,
+				err: &admin.Error{
+					Type:    admin.ErrorBadRequestType.String(),
+					Detail:  "bad request",
+					Message: "expiresBefore cannot be before expiresAfter",
+				},
+			}
+		},
+		"fail/revoked": func(t *testing.T) test {
+			return test{
+				req:        httptest.NewRequest("GET", "/foo?revoked=maybe", http.NoBody),
+				statusCode: 400,
+				err: &admin.Error{
+					Type:    admin.ErrorBadRequestType.String(),
+					Detail:  "bad request",
+					Message: "revoked 'maybe' is not a boolean: strconv.ParseBool: parsing \"maybe\": invalid syntax",
+				},
+			}
+		},
+		"fail/provisioner": func(t *testing.T) test {
+			return test{
+				req: httptest.NewRequest("GET", "/foo?provisioner=unknown", http.NoBody),
+				auth: &mockAdminAuthority{
+					MockLoadProvisionerByName: func(name string) (provisioner.Interface, error) {
+						assert.Equals(t, "unknown", name)
+						return nil, errors.New("force")
+					},
+				},
+				statusCode: 400,
+				err: &admin.Error{
+					Type:    admin.ErrorBadRequestType.String(),
+					Detail:  "bad request",
+					Message: "error loading provisioner unknown: force",
+				},
+			}
+		},
+		"fail/auth.SearchCertificates": func(t *testing.T) test {
+			return test{
+				req: httptest.NewRequest("GET", "/foo", http.NoBody),
+				auth: &mockAdminAuthority{
+					MockSearchCertificates: func(filter *db.CertificateFilter, cursor string, limit int) ([]*db.CertificateEntry, string, error) {
+						return nil, "", admin.NewError(admin.ErrorNotImplementedType, "certificate inventory is not supported by the database")
+					},
+				},
+				statusCode: 501,
+				err: &admin.Error{
+					Type:    admin.ErrorNotImplementedType.String(),
+					Detail:  "not implemented",
+					Message: "error searching certificates: certificate inventory is not supported by the database",
+				},
+			}
+		},
+		"ok": func(t *testing.T) test {
+			revoked := true
+			return test{
+				req: httptest.NewRequest("GET", "/foo?cursor=x509/0&limit=2&type=x509&provisioner=jwk&san=*.example.com&subject=foo&expiresAfter=2024-01-01T00:00:00Z&expiresBefore=2024-02-01T00:00:00Z&revoked=true", http.NoBody),
+				auth: &mockAdminAuthority{
+					MockLoadProvisionerByName: func(name string) (provisioner.Interface, error) {
+						assert.Equals(t, "jwk", name)
+						return &provisioner.JWK{ID: "jwk-id", Name: "jwk"}, nil
+					},
+					MockSearchCertificates: func(filter *db.CertificateFilter, cursor string, limit int) ([]*db.CertificateEntry, string, error) {
+						assert.Equals(t, &db.CertificateFilter{
+							Type:          "x509",
+							ProvisionerID: "jwk-id",
+							SAN:           "*.example.com",
+							Subject:       "foo",
+							ExpiresAfter:  time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC),
+							ExpiresBefore: time.Date(2024, 2, 1, 0, 0, 0, 0, time.UTC),
+							Revoked:       &revoked,
+						}, filter)
+						assert.Equals(t, "x509/0", cursor)
+						assert.Equals(t, 2, limit)
+						return certs, "ssh/2", nil
+					},
+				},
+				statusCode: 200,
+				resp: GetCertificatesResponse{
+					Certificates: certs,
+					NextCursor:   "ssh/2",
+				},
+			}
+		},
+	}
+	for name, prep := range tests {
+		tc := prep(t)
+		t.Run(name, func(t *testing.T) {
+			mockMustAuthority(t, tc.auth)
+			req := tc.req.WithContext(context.Background())
+			w := httptest.NewRecorder()
+			GetCertificates(w, req)
+			res := w.Result()
+
+			assert.Equals(t, tc.statusCode, res.StatusCode)
+
+			body, err := io.ReadAll(res.Body)
+			res.Body.Close()
+			assert.FatalError(t, err)
+
+			if res.StatusCode >= 400 {
+				adminErr := admin.Error{}
+				assert.FatalError(t, json.Unmarshal(bytes.TrimSpace(body), &adminErr))
+				assert.Equals(t, tc.err.Type, adminErr.Type)
+				assert.Equals(t, tc.err.Message, adminErr.Message)
+				assert.Equals(t, tc.err.Detail, adminErr.Detail)
+				return
+			}
+
+			response := GetCertificatesResponse{}
+			assert.FatalError(t, json.Unmarshal(bytes.TrimSpace(body), &response))
+			assert.Equals(t, []string{"application/json"}, res.Header["Content-Type"])
+			assert.Equals(t, tc.resp, response)
+		})
+	}
+}
@@ -91,12 +91,27 @@ func Route(r api.Router, options ...RouterOption) {
 	r.MethodFunc("PATCH", "/admins/{id}", authnz(UpdateAdmin))
 	r.MethodFunc("DELETE", "/admins/{id}", authnz(DeleteAdmin))
 
+	// Certificates
+	r.MethodFunc("GET", "/certificates", authnz(GetCertificates))
+
+	// SCEP pending requests
+	r.MethodFunc("GET", "/scep/{provisionerName}/requests", authnz(GetSCEPRequests))
+	r.MethodFunc("GET", "/scep/{provisionerName}/requests/{transactionID}", authnz(GetSCEPRequest))
+	r.MethodFunc("PATCH", "/scep/{provisionerName}/requests/{transactionID}", authnz(UpdateSCEPRequest))
+
+	// SCEP dynamic challenges
+	r.MethodFunc("GET", "/scep/{provisionerName}/challenges", authnz(GetSCEPChallenges))
+	r.MethodFunc("GET", "/scep/{provisionerName}/challenges/{id}", authnz(GetSCEPChallenge))
+	r.MethodFunc("POST", "/scep/{provisionerName}/challenges", authnz(CreateSCEPChallenge))
+	r.MethodFunc("DELETE", "/scep/{provisionerName}/challenges/{id}", authnz(DeleteSCEPChallenge))
+
 	// ACME responder
 	if router.acmeResponder != nil {
 		// ACME External Account Binding Keys
 		r.MethodFunc("GET", "/acme/eab/{provisionerName}/{reference}", acmeEABMiddleware(router.acmeResponder.GetExternalAccountKeys))
 		r.MethodFunc("GET", "/acme/eab/{provisionerName}", acmeEABMiddleware(router.acmeResponder.GetExternalAccountKeys))
 		r.MethodFunc("POST", "/acme/eab/{provisionerName}", acmeEABMiddleware(router.acmeResponder.CreateExternalAccountKey))
+		r.MethodFunc("PATCH", "/acme/eab/{provisionerName}/{id}", acmeEABMiddleware(router.acmeResponder.UpdateExternalAccountKey))
 		r.MethodFunc("DELETE", "/acme/eab/{provisionerName}/{id}", acmeEABMiddleware(router.acmeResponder.DeleteExternalAccountKey))
 	}
 
@@ -0,0 +1,270 @@
+package api
+
+import (
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+
+	"github.com/smallstep/certificates/api/read"
+	"github.com/smallstep/certificates/api/render"
+	"github.com/smallstep/certificates/authority/admin"
+	"github.com/smallstep/certificates/authority/provisioner"
+	"github.com/smallstep/certificates/db"
+)
+
+// GetSCEPRequestsResponse is the type for GET /admin/scep/{provisionerName}/requests
+// responses.
+type GetSCEPRequestsResponse struct {
+	Requests []*db.SCEPRequest `json:"requests"`
+}
+
+// UpdateSCEPRequestRequest is the type for PATCH
+// /admin/scep/{provisionerName}/requests/{transactionID} requests.
+type UpdateSCEPRequestRequest struct {
+	Status string `json:"status"`
+	Reason string `json:"reason,omitempty"`
+}
+
+// Validate validates an update SCEP request body.
+func (r *UpdateSCEPRequestRequest) Validate() error {
+	switch r.Status {
+	case db.SCEPRequestApproved, db.SCEPRequestRejected:
+		return nil
+	default:
+		return admin.NewError(admin.ErrorBadRequestType, "status must be '%s' or '%s'",
+			db.SCEPRequestApproved, db.SCEPRequestRejected)
+	}
+}
+
+// GetSCEPRequests returns the requests in the pending queue of a SCEP
+// provisioner.
+func GetSCEPRequests(w http.ResponseWriter, r *http.Request) {
+	provisionerID, err := scepProvisionerID(r)
+	if err != nil {
+		render.Error(w, r, err)
+		return
+	}
+
+	reqs, err := mustAuthority(r.Context()).GetSCEPRequests(provisionerID)
+	if err != nil {
+		render.Error(w, r, err)
+		return
+	}
+	if reqs == nil {
+		reqs = []*db.SCEPRequest{}
+	}
+
+	render.JSON(w, r, &GetSCEPRequestsResponse{
+		Requests: reqs,
+	})
+}
+
+// GetSCEPRequest returns a request in the pending queue of a SCEP
+// provisioner.
+func GetSCEPRequest(w http.ResponseWriter, r *http.Request) {
+	provisionerID, err := scepProvisionerID(r)
+	if err != nil {
+		render.Error(w, r, err)
+		return
+	}
+	transactionID, err := url.PathUnescape(chi.URLParam(r, "transactionID"))
+	if err != nil {
+		render.Error(w, r, admin.WrapError(admin.ErrorBadRequestType, err, "error unescaping transaction id"))
+		return
+	}
+
+	req, err := mustAuthority(r.Context()).GetSCEPRequest(provisionerID, transactionID)
+	if err != nil {
+		render.Error(w, r, err)
+		return
+	}
+
+	render.JSON(w, r, req)
+}
+
+// UpdateSCEPRequest approves or rejects a request in the pending queue of a
+// SCEP provisioner.
+func UpdateSCEPRequest(w http.ResponseWriter, r *http.Request) {
+	var body UpdateSCEPRequestRequest
+	if err := read.JSON(r.Body, &body); err != nil {
+		render.Error(w, r, admin.WrapError(admin.ErrorBadRequestType, err, "error reading request body"))
+		return
+	}
+	if err := body.Validate(); err != nil {
+		render.Error(w, r, err)
+		return
+	}
+
+	provisionerID, err := scepProvisionerID(r)
+	if err != nil {
+		render.Error(w, r, err)
+		return
+	}
+	transactionID, err := url.PathUnescape(chi.URLParam(r, "transactionID"))
+	if err != nil {
+		render.Error(w, r, admin.WrapError(admin.ErrorBadRequestType, err, "error unescaping transaction id"))
+		return
+	}
+
+	req, err := mustAuthority(r.Context()).UpdateSCEPRequestStatus(provisionerID, transactionID, body.Status, body.Reason)
+	if err != nil {
+		render.Error(w, r, err)
+		return
+	}
+
+	render.JSON(w, r, req)
+}
+
+// CreateSCEPChallengeRequest is the type for POST
+// /admin/scep/{provisionerName}/challenges requests.
+type CreateSCEPChallengeRequest struct {
+	Subject  string                `json:"subject,omitempty"`
+	SANs     []string              `json:"sans,omitempty"`
+	MaxUses  int                   `json:"maxUses,omitempty"`
+	Duration *provisioner.Duration `json:"duration,omitempty"`
+}
+
+// Validate validates a create SCEP challenge request body.
+func (r *CreateSCEPChallengeRequest) Validate() error {
+	switch {
+	case r.MaxUses < 0:
+		return admin.NewError(admin.ErrorBadRequestType, "maxUses cannot be negative")
+	case r.Duration != nil && r.Duration.Duration < 0:
+		return admin.NewError(admin.ErrorBadRequestType, "duration cannot be negative")
+	default:
+		return nil
+	}
+}
+
+// CreateSCEPChallengeResponse is the type for POST
+// /admin/scep/{provisionerName}/challenges responses. The challenge value is
+// only returned when the challenge is created.
+type CreateSCEPChallengeResponse struct {
+	*db.SCEPChallenge
+	Challenge string `json:"challenge"`
+}
+
+// GetSCEPChallengesResponse is the type for GET
+// /admin/scep/{provisionerName}/challenges responses.
+type GetSCEPChallengesResponse struct {
+	Challenges []*db.SCEPChallenge `json:"challenges"`
+}
+
+// CreateSCEPChallenge creates a dynamic challenge for a SCEP provisioner.
+func CreateSCEPChallenge(w http.ResponseWriter, r *http.Request) {
+	var body CreateSCEPChallengeRequest
+	if err := read.JSON(r.Body, &body); err != nil {
+		render.Error(w, r, admin.WrapError(admin.ErrorBadRequestType, err, "error reading request body"))
+		return
+	}
+	if err := body.Validate(); err != nil {
+		render.Error(w, r, err)
+		return
+	}
+
+	p, err := scepProvisioner(r)
+	if err != nil {
+		render.Error(w, r, err)
+		return
+	}
+	if !p.DynamicChallenges {
+		render.Error(w, r, admin.NewError(admin.ErrorBadRequestType, "provisioner %s does not have dynamic challenges enabled", p.GetName()))
+		return
+	}
+
+	var duration time.Duration
+	if body.Duration != nil {
+		duration = body.Duration.Duration
+	}
+	challenge, ch, err := mustAuthority(r.Context()).CreateSCEPChallenge(p.GetID(), body.Subject, body.SANs, body.MaxUses, duration)
+	if err != nil {
+		render.Error(w, r, err)
+		return
+	}
+
+	render.JSONStatus(w, r, &CreateSCEPChallengeResponse{
+		SCEPChallenge: ch,
+		Challenge:     challenge,
+	}, http.StatusCreated)
+}
+
+// GetSCEPChallenges returns the dynamic challenges of a SCEP provisioner.
+func GetSCEPChallenges(w http.ResponseWriter, r *http.Request) {
+	provisionerID, err := scepProvisionerID(r)
+	if err != nil {
+		render.Error(w, r, err)
+		return
+	}
+
+	chs, err := mustAuthority(r.Context()).GetSCEPChallenges(provisionerID)
+	if err != nil {
+		render.Error(w, r, err)
+		return
+	}
+	if chs == nil {
+		chs = []*db.SCEPChallenge{}
+	}
+
+	render.JSON(w, r, &GetSCEPChallengesResponse{
+		Challenges: chs,
+	})
+}
+
+// GetSCEPChallenge returns a dynamic challenge of a SCEP provisioner.
+func GetSCEPChallenge(w http.ResponseWriter, r *http.Request) {
+	provisionerID, err := scepProvisionerID(r)
+	if err != nil {
+		render.Error(w, r, err)
+		return
+	}
+
+	ch, err := mustAuthority(r.Context()).GetSCEPChallenge(provisionerID, chi.URLParam(r, "id"))
+	if err != nil {
+		render.Error(w, r, err)
+		return
+	}
+
+	render.JSON(w, r, ch)
+}
+
+// DeleteSCEPChallenge deletes a dynamic challenge of a SCEP provisioner.
+func DeleteSCEPChallenge(w http.ResponseWriter, r *http.Request) {
+	provisionerID, err := scepProvisionerID(r)
+	if err != nil {
+		render.Error(w, r, err)
+		return
+	}
+
+	if err := mustAuthority(r.Context()).DeleteSCEPChallenge(provisionerID, chi.URLParam(r, "id")); err != nil {
+		render.Error(w, r, err)
+		return
+	}
+
+	render.JSON(w, r, &DeleteResponse{Status: "ok"})
+}
+
+// scepProvisioner returns the SCEP provisioner in the request path.
+func scepProvisioner(r *http.Request) (*provisioner.SCEP, error) {
+	name := chi.URLParam(r, "provisionerName")
+	p, err := mustAuthority(r.Context()).LoadProvisionerByName(name)
+	if err != nil {
+		return nil, admin.WrapError(admin.ErrorNotFoundType, err, "error loading provisioner %s", name)
+	}
+	prov, ok := p.(*provisioner.SCEP)
+	if !ok {
+		return nil, admin.NewError(admin.ErrorBadRequestType, "provisioner %s is not a SCEP provisioner", name)
+	}
+	return prov, nil
+}
+
+// scepProvisionerID returns the id of the SCEP provisioner in the request
+// path.
+func scepProvisionerID(r *http.Request) (string, error) {
+	p, err := scepProvisioner(r)
+	if err != nil {
+		return "", err
+	}
+	return p.GetID(), nil
+}
@@ -0,0 +1,596 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+
+	"github.com/smallstep/assert"
+	"github.com/smallstep/certificates/authority/admin"
+	"github.com/smallstep/certificates/authority/provisioner"
+	"github.com/smallstep/certificates/db"
+)
+
+func TestUpdateSCEPRequestRequest_Validate(t *testing.T) {
+	tests := []struct {
+		name    string
+		status  string
+		wantErr bool
+	}{
+		{"ok/approved", "approved", false},
+		{"ok/rejected", "rejected", false},
+		{"fail/pending", "pending", true},
+		{"fail/issued", "issued", true},
+		{"fail/empty", "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := &UpdateSCEPRequestRequest{Status: tt.status}
+			if err := r.Validate(); (err != nil) != tt.wantErr {
+				t.Errorf("UpdateSCEPRequestRequest.Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestGetSCEPRequests(t *testing.T) {
+	now := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	reqs := []*db.SCEPRequest{
+		{ProvisionerID: "scep-id", TransactionID: "tx1", Subject: "router1", Status: "pending", CreatedAt: now, UpdatedAt: now},
+		{ProvisionerID: "scep-id", TransactionID: "tx2", Subject: "router2", Status: "issued", SerialNumber: "1234", CreatedAt: now, UpdatedAt: now},
+	}
+	type test struct {
+		auth       adminAuthority
+		statusCode int
+		err        *admin.Error
+		resp       GetSCEPRequestsResponse
+	}
+	var tests = map[string]func(t *testing.T) test{
+		"fail/provisioner": func(t *testing.T) test {
+			return test{
+				auth: &mockAdminAuthority{
+					MockLoadProvisionerByName: func(name string) (provisioner.Interface, error) {
+						return nil, errors.New("force")
+					},
+				},
+				statusCode: 404,
+				err: &admin.Error{
+					Type:    admin.ErrorNotFoundType.String(),
+					Detail:  "resource not found",
+					Message: "error loading provisioner scep: force",
+				},
+			}
+		},
+		"fail/not-scep": func(t *testing.T) test {
+			return test{
+				auth: &mockAdminAuthority{
+					MockLoadProvisionerByName: func(name string) (provisioner.Interface, error) {
+						return &provisioner.JWK{ID: "jwk-id", Name: "scep"}, nil
+					},
+				},
+				statusCode: 400,
+				err: &admin.Error{
+					Type:    admin.ErrorBadRequestType.String(),
+					Detail:  "bad request",
+					Message: "provisioner scep is not a SCEP provisioner",
+				},
+			}
+		},
+		"fail/auth.GetSCEPRequests": func(t *testing.T) test {
+			return test{
+				auth: &mockAdminAuthority{
+					MockLoadProvisionerByName: func(name string) (provisioner.Interface, error) {
+						return &provisioner.SCEP{ID: "scep-id", Name: "scep"}, nil
+					},
+					MockGetSCEPRequests: func(provisionerID string) ([]*db.SCEPRequest, error) {
+						return nil, admin.NewError(admin.ErrorNotImplementedType, "pending SCEP requests are not supported by the database")
+					},
+				},
+				statusCode: 501,
+				err: &admin.Error{
+					Type:    admin.ErrorNotImplementedType.String(),
+					Detail:  "not implemented",
+					Message: "pending SCEP requests are not supported by the database",
+				},
+			}
+		},
+		"ok/empty": func(t *testing.T) test {
+			return test{
+				auth: &mockAdminAuthority{
+					MockLoadProvisionerByName: func(name string) (provisioner.Interface, error) {
+						return &provisioner.SCEP{ID: "scep-id", Name: "scep"}, nil
+					},
+					MockGetSCEPRequests: func(provisionerID string) ([]*db.SCEPRequest, error) {
+						return nil, nil
+					},
+				},
+				statusCode: 200,
+				resp:       GetSCEPRequestsResponse{Requests: []*db.SCEPRequest{}},
+			}
+		},
+		"ok": func(t *testing.T) test {
+			return test{
+				auth: &mockAdminAuthority{
+					MockLoadProvisionerByName: func(name string) (provisioner.Interface, error) {
+						assert.Equals(t, "scep", name)
+						return &provisioner.SCEP{ID: "scep-id", Name: "scep"}, nil
+					},
+					MockGetSCEPRequests: func(provisionerID string) ([]*db.SCEPRequest, error) {
+						assert.Equals(t, "scep-id", provisionerID)
+						return reqs, nil
+					},
+				},
+				statusCode: 200,
+				resp:       GetSCEPRequestsResponse{Requests: reqs},
+			}
+		},
+	}
+	for name, prep := range tests {
+		tc := prep(t)
+		t.Run(name, func(t *testing.T) {
+			mockMustAuthority(t, tc.auth)
+			chiCtx := chi.NewRouteContext()
+			chiCtx.URLParams.Add("provisionerName", "scep")
+			ctx := context.WithValue(context.Background(), chi.RouteCtxKey, chiCtx)
+			req := httptest.NewRequest("GET", "/foo", http.NoBody).WithContext(ctx)
+			w := httptest.NewRecorder()
+			GetSCEPRequests(w, req)
+			res := w.Result()
+
+			assert.Equals(t, tc.statusCode, res.StatusCode)
+
+			body, err := io.ReadAll(res.Body)
+			res.Body.Close()
+			assert.FatalError(t, err)
+
+			if res.StatusCode >= 400 {
+				adminErr := admin.Error{}
+				assert.FatalError(t, json.Unmarshal(bytes.TrimSpace(body), &adminErr))
+				assert.Equals(t, tc.err.Type, adminErr.Type)
+				assert.Equals(t, tc.err.Message, adminErr.Message)
+				assert.Equals(t, tc.err.Detail, adminErr.Detail)
+				return
+			}
+
+			response := GetSCEPRequestsResponse{}
+			assert.FatalError(t, json.Unmarshal(bytes.TrimSpace(body), &response))
+			assert.Equals(t, []string{"application/json"}, res.Header["Content-Type"])
+			assert.Equals(t, tc.resp, response)
+		})
+	}
+}
+
+func TestUpdateSCEPRequest(t *testing.T) {
+	now := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	type test struct {
+		auth       adminAuthority
+		body       string
+		statusCode int
+		err        *admin.Error
+		resp       *db.SCEPRequest
+	}
+	var tests = map[string]func(t *testing.T) test{
+		"fail/read.JSON": func(t *testing.T) test {
+			return test{
+				body:       "{",
+				statusCode: 400,
+				err: &admin.Error{
+					Type:    admin.ErrorBadRequestType.String(),
+					Detail:  "bad request",
+					Message: "error reading request body: error decoding json: unexpected EOF",
+				},
+			}
+		},
+		"fail/validate": func(t *testing.T) test {
+			return test{
+				body:       `{"status":"issued"}`,
+				statusCode: 400,
+				err: &admin.Error{
+					Type:    admin.ErrorBadRequestType.String(),
+					Detail:  "bad request",
+					Message: "status must be 'approved' or 'rejected'",
+				},
+			}
+		},
+		"fail/auth.UpdateSCEPRequestStatus": func(t *testing.T) test {
+			return test{
+				body: `{"status":"approved"}`,
+				auth: &mockAdminAuthority{
+					MockLoadProvisionerByName: func(name string) (provisioner.Interface, error) {
+						return &provisioner.SCEP{ID: "scep-id", Name: "scep"}, nil
+					},
+					MockUpdateSCEPRequestStatus: func(provisionerID, transactionID, status, reason string) (*db.SCEPRequest, error) {
+						return nil, admin.NewError(admin.ErrorBadRequestType, "SCEP request %s is not pending", transactionID)
+					},
+				},
+				statusCode: 400,
+				err: &admin.Error{
+					Type:    admin.ErrorBadRequestType.String(),
+					Detail:  "bad request",
+					Message: "SCEP request a/b+c is not pending",
+				},
+			}
+		},
+		"ok": func(t *testing.T) test {
+			resp := &db.SCEPRequest{ProvisionerID: "scep-id", TransactionID: "a/b+c", Status: "rejected", Reason: "unknown device", CreatedAt: now, UpdatedAt: now}
+			return test{
+				body: `{"status":"rejected","reason":"unknown device"}`,
+				auth: &mockAdminAuthority{
+					MockLoadProvisionerByName: func(name string) (provisioner.Interface, error) {
+						return &provisioner.SCEP{ID: "scep-id", Name: "scep"}, nil
+					},
+					MockUpdateSCEPRequestStatus: func(provisionerID, transactionID, status, reason string) (*db.SCEPRequest, error) {
+						assert.Equals(t, "scep-id", provisionerID)
+						assert.Equals(t, "a/b+c", transactionID)
+						assert.Equals(t, "rejected", status)
+						assert.Equals(t, "unknown device", reason)
+						return resp, nil
+					},
+				},
+				statusCode: 200,
+				resp:       resp,
+			}
+		},
+	}
+	for name, prep := range tests {
+		tc := prep(t)
+		t.Run(name, func(t *testing.T) {
+			mockMustAuthority(t, tc.auth)
+			chiCtx := chi.NewRouteContext()
+			chiCtx.URLParams.Add("provisionerName", "scep")
+			chiCtx.URLParams.Add("transactionID", "a%2Fb+c")
+			ctx := context.WithValue(context.Background(), chi.RouteCtxKey, chiCtx)
+			req := httptest.NewRequest("PATCH", "/foo", strings.NewReader(tc.body)).WithContext(ctx)
+			w := httptest.NewRecorder()
+			UpdateSCEPRequest(w, req)
+			res := w.Result()
+
+			assert.Equals(t, tc.statusCode, res.StatusCode)
+
+			body, err := io.ReadAll(res.Body)
+			res.Body.Close()
+			assert.FatalError(t, err)
+
+			if res.StatusCode >= 400 {
+				adminErr := admin.Error{}
+				assert.FatalError(t, json.Unmarshal(bytes.TrimSpace(body), &adminErr))
+				assert.Equals(t, tc.err.Type, adminErr.Type)
+				assert.Equals(t, tc.err.Message, adminErr.Message)
+				assert.Equals(t, tc.err.Detail, adminErr.Detail)
+				return
+			}
+
+			response := &db.SCEPRequest{}
+			assert.FatalError(t, json.Unmarshal(bytes.TrimSpace(body), response))
+			assert.Equals(t, tc.resp, response)
+		})
+	}
+}
+
+func TestCreateSCEPChallengeRequest_Validate(t *testing.T) {
+	tests := []struct {
+		name    string
+		req     *CreateSCEPChallengeRequest
+		wantErr bool
+	}{
+		{"ok/empty", &CreateSCEPChallengeRequest{}, false},
+		{"ok", &CreateSCEPChallengeRequest{Subject: "router1", SANs: []string{"router1.example.com"}, MaxUses: 3, Duration: &provisioner.Duration{Duration: time.Hour}}, false},
+		{"fail/maxUses", &CreateSCEPChallengeRequest{MaxUses: -1}, true},
+		{"fail/duration", &CreateSCEPChallengeRequest{Duration: &provisioner.Duration{Duration: -time.Hour}}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.req.Validate(); (err != nil) != tt.wantErr {
+				t.Errorf("CreateSCEPChallengeRequest.Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestCreateSCEPChallenge(t *testing.T) {
+	now := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	ch := &db.SCEPChallenge{
+		ID:            db.SCEPChallengeID("the-challenge"),
+		ProvisionerID: "scep-id",
+		Subject:       "router1",
+		SANs:          []string{"router1.example.com"},
+		MaxUses:       3,
+		CreatedAt:     now,
+		ExpiresAt:     now.Add(time.Hour),
+	}
+	type test struct {
+		auth       adminAuthority
+		body       string
+		statusCode int
+		err        *admin.Error
+		resp       *CreateSCEPChallengeResponse
+	}
+	var tests = map[string]func(t *testing.T) test{
+		"fail/read.JSON": func(t *testing.T) test {
+			return test{
+				body:       "{",
+				statusCode: 400,
+				err: &admin.Error{
+					Type:    admin.ErrorBadRequestType.String(),
+					Detail:  "bad request",
+					Message: "error reading request body: error decoding json: unexpected EOF",
+				},
+			}
+		},
+		"fail/validate": func(t *testing.T) test {
+			return test{
+				body:       `{"maxUses":-1}`,
+				statusCode: 400,
+				err: &admin.Error{
+					Type:    admin.ErrorBadRequestType.String(),
+					Detail:  "bad request",
+					Message: "maxUses cannot be negative",
+				},
+			}
+		},
+		"fail/not-enabled": func(t *testing.T) test {
+			return test{
+				body: `{}`,
+				auth: &mockAdminAuthority{
+					MockLoadProvisionerByName: func(name string) (provisioner.Interface, error) {
+						return &provisioner.SCEP{ID: "scep-id", Name: "scep"}, nil
+					},
+				},
+				statusCode: 400,
+				err: &admin.Error{
+					Type:    admin.ErrorBadRequestType.String(),
+					Detail:  "bad request",
+					Message: "provisioner scep does not have dynamic challenges enabled",
+				},
+			}
+		},
+		"fail/auth.CreateSCEPChallenge": func(t *testing.T) test {
+			return test{
+				body: `{}`,
+				auth: &mockAdminAuthority{
+					MockLoadProvisionerByName: func(name string) (provisioner.Interface, error) {
+						return &provisioner.SCEP{ID: "scep-id", Name: "scep", DynamicChallenges: true}, nil
+					},
+					MockCreateSCEPChallenge: func(provisionerID, subject string, sans []string, maxUses int, duration time.Duration) (string, *db.SCEPChallenge, error) {
+						return "", nil, admin.NewError(admin.ErrorNotImplementedType, "dynamic SCEP challenges are not supported by the database")
+					},
+				},
+				statusCode: 501,
+				err: &admin.Error{
+					Type:    admin.ErrorNotImplementedType.String(),
+					Detail:  "not implemented",
+					Message: "dynamic SCEP challenges are not supported by the database",
+				},
+			}
+		},
+		"ok": func(t *testing.T) test {
+			return test{
+				body: `{"subject":"router1","sans":["router1.example.com"],"maxUses":3,"duration":"1h"}`,
+				auth: &mockAdminAuthority{
+					MockLoadProvisionerByName: func(name string) (provisioner.Interface, error) {
+						return &provisioner.SCEP{ID: "scep-id", Name: "scep", DynamicChallenges: true}, nil
+					},
+					MockCreateSCEPChallenge: func(provisionerID, subject string, sans []string, maxUses int, duration time.Duration) (string, *db.SCEPChallenge, error) {
+						assert.Equals(t, "scep-id", provisionerID)
+						assert.Equals(t, "router1", subject)
+						assert.Equals(t, []string{"router1.example.com"}, sans)
+						assert.Equals(t, 3, maxUses)
+						assert.Equals(t, time.Hour, duration)
+						return "the-challenge", ch, nil
+					},
+				},
+				statusCode: 201,
+				resp:       &CreateSCEPChallengeResponse{SCEPChallenge: ch, Challenge: "the-challenge"},
+			}
+		},
+	}
+	for name, prep := range tests {
+		tc := prep(t)
+		t.Run(name, func(t *testing.T) {
+			mockMustAuthority(t, tc.auth)
+			chiCtx := chi.NewRouteContext()
+			chiCtx.URLParams.Add("provisionerName", "scep")
+			ctx := context.WithValue(context.Background(), chi.RouteCtxKey, chiCtx)
+			req := httptest.NewRequest("POST", "/foo", strings.NewReader(tc.body)).WithContext(ctx)
+			w := httptest.NewRecorder()
+			CreateSCEPChallenge(w, req)
+			res := w.Result()
+
+			assert.Equals(t, tc.statusCode, res.StatusCode)
+
+			body, err := io.ReadAll(res.Body)
+			res.Body.Close()
+			assert.FatalError(t, err)
+
+			if res.StatusCode >= 400 {
+				adminErr := admin.Error{}
+				assert.FatalError(t, json.Unmarshal(bytes.TrimSpace(body), &adminErr))
+				assert.Equals(t, tc.err.Type, adminErr.Type)
+				assert.Equals(t, tc.err.Message, adminErr.Message)
+				assert.Equals(t, tc.err.Detail, adminErr.Detail)
+				return
+			}
+
+			response := &CreateSCEPChallengeResponse{}
+			assert.FatalError(t, json.Unmarshal(bytes.TrimSpace(body), response))
+			assert.Equals(t, tc.resp, response)
+		})
+	}
+}
+
+func TestGetSCEPChallenges(t *testing.T) {
+	now := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	chs := []*db.SCEPChallenge{
+		{ID: "id1", ProvisionerID: "scep-id", MaxUses: 1, CreatedAt: now, ExpiresAt: now.Add(time.Hour)},
+		{ID: "id2", ProvisionerID: "scep-id", Subject: "router2", MaxUses: 2, Uses: 1, CreatedAt: now, ExpiresAt: now.Add(time.Hour)},
+	}
+	type test struct {
+		auth       adminAuthority
+		statusCode int
+		err        *admin.Error
+		resp       GetSCEPChallengesResponse
+	}
+	var tests = map[string]func(t *testing.T) test{
+		"fail/not-scep": func(t *testing.T) test {
+			return test{
+				auth: &mockAdminAuthority{
+					MockLoadProvisionerByName: func(name string) (provisioner.Interface, error) {
+						return &provisioner.JWK{ID: "jwk-id", Name: "scep"}, nil
+					},
+				},
+				statusCode: 400,
+				err: &admin.Error{
+					Type:    admin.ErrorBadRequestType.String(),
+					Detail:  "bad request",
+					Message: "provisioner scep is not a SCEP provisioner",
+				},
+			}
+		},
+		"ok/empty": func(t *testing.T) test {
+			return test{
+				auth: &mockAdminAuthority{
+					MockLoadProvisionerByName: func(name string) (provisioner.Interface, error) {
+						return &provisioner.SCEP{ID: "scep-id", Name: "scep"}, nil
+					},
+					MockGetSCEPChallenges: func(provisionerID string) ([]*db.SCEPChallenge, error) {
+						return nil, nil
+					},
+				},
+				statusCode: 200,
+				resp:       GetSCEPChallengesResponse{Challenges: []*db.SCEPChallenge{}},
+			}
+		},
+		"ok": func(t *testing.T) test {
+			return test{
+				auth: &mockAdminAuthority{
+					MockLoadProvisionerByName: func(name string) (provisioner.Interface, error) {
+						return &provisioner.SCEP{ID: "scep-id", Name: "scep"}, nil
+					},
+					MockGetSCEPChallenges: func(provisionerID string) ([]*db.SCEPChallenge, error) {
+						assert.Equals(t, "scep-id", provisionerID)
+						return chs, nil
+					},
+				},
+				statusCode: 200,
+				resp:       GetSCEPChallengesResponse{Challenges: chs},
+			}
+		},
+	}
+	for name, prep := range tests {
+		tc := prep(t)
+		t.Run(name, func(t *testing.T) {
+			mockMustAuthority(t, tc.auth)
+			chiCtx := chi.NewRouteContext()
+			chiCtx.URLParams.Add("provisionerName", "scep")
+			ctx := context.WithValue(context.Background(), chi.RouteCtxKey, chiCtx)
+			req := httptest.NewRequest("GET", "/foo", http.NoBody).WithContext(ctx)
+			w := httptest.NewRecorder()
+			GetSCEPChallenges(w, req)
+			res := w.Result()
+
+			assert.Equals(t, tc.statusCode, res.StatusCode)
+
+			body, err := io.ReadAll(res.Body)
+			res.Body.Close()
+			assert.FatalError(t, err)
+
+			if res.StatusCode >= 400 {
+				adminErr := admin.Error{}
+				assert.FatalError(t, json.Unmarshal(bytes.TrimSpace(body), &adminErr))
+				assert.Equals(t, tc.err.Type, adminErr.Type)
+				assert.Equals(t, tc.err.Message, adminErr.Message)
+				assert.Equals(t, tc.err.Detail, adminErr.Detail)
+				return
+			}
+
+			response := GetSCEPChallengesResponse{}
+			assert.FatalError(t, json.Unmarshal(bytes.TrimSpace(body), &response))
+			assert.Equals(t, tc.resp, response)
+		})
+	}
+}
+
+func TestDeleteSCEPChallenge(t *testing.T) {
+	type test struct {
+		auth       adminAuthority
+		statusCode int
+		err        *admin.Error
+	}
+	var tests = map[string]func(t *testing.T) test{
+		"fail/auth.DeleteSCEPChallenge": func(t *testing.T) test {
+			return test{
+				auth: &mockAdminAuthority{
+					MockLoadProvisionerByName: func(name string) (provisioner.Interface, error) {
+						return &provisioner.SCEP{ID: "scep-id", Name: "scep"}, nil
+					},
+					MockDeleteSCEPChallenge: func(provisionerID, id string) error {
+						return admin.NewError(admin.ErrorNotFoundType, "SCEP challenge %s not found", id)
+					},
+				},
+				statusCode: 404,
+				err: &admin.Error{
+					Type:    admin.ErrorNotFoundType.String(),
+					Detail:  "resource not found",
+					Message: "SCEP challenge id1 not found",
+				},
+			}
+		},
+		"ok": func(t *testing.T) test {
+			return test{
+				auth: &mockAdminAuthority{
+					MockLoadProvisionerByName: func(name string) (provisioner.Interface, error) {
+						return &provisioner.SCEP{ID: "scep-id", Name: "scep"}, nil
+					},
+					MockDeleteSCEPChallenge: func(provisionerID, id string) error {
+						assert.Equals(t, "scep-id", provisionerID)
+						assert.Equals(t, "id1", id)
+						return nil
+					},
+				},
+				statusCode: 200,
+			}
+		},
+	}
+	for name, prep := range tests {
+		tc := prep(t)
+		t.Run(name, func(t *testing.T) {
+			mockMustAuthority(t, tc.auth)
+			chiCtx := chi.NewRouteContext()
+			chiCtx.URLParams.Add("provisionerName", "scep")
+			chiCtx.URLParams.Add("id", "id1")
+			ctx := context.WithValue(context.Background(), chi.RouteCtxKey, chiCtx)
+			req := httptest.NewRequest("DELETE", "/foo", http.NoBody).WithContext(ctx)
+			w := httptest.NewRecorder()
+			DeleteSCEPChallenge(w, req)
+			res := w.Result()
+
+			assert.Equals(t, tc.statusCode, res.StatusCode)
+
+			body, err := io.ReadAll(res.Body)
+			res.Body.Close()
+			assert.FatalError(t, err)
+
+			if res.StatusCode >= 400 {
+				adminErr := admin.Error{}
+				assert.FatalError(t, json.Unmarshal(bytes.TrimSpace(body), &adminErr))
+				assert.Equals(t, tc.err.Type, adminErr.Type)
+				assert.Equals(t, tc.err.Message, adminErr.Message)
+				assert.Equals(t, tc.err.Detail, adminErr.Detail)
+				return
+			}
+
+			response := DeleteResponse{}
+			assert.FatalError(t, json.Unmarshal(bytes.TrimSpace(body), &response))
+			assert.Equals(t, "ok", response.Status)
+		})
+	}
+}
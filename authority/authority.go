@@ -81,9 +81,15 @@ type Authority struct {
 	sshCAHostFederatedCerts []ssh.PublicKey
 
 	// CRL vars
-	crlTicker  *time.Ticker
-	crlStopper chan struct{}
-	crlMutex   sync.Mutex
+	crlTicker      *time.Ticker
+	crlDeltaTicker *time.Ticker
+	crlStopper     chan struct{}
+	crlMutex       sync.Mutex
+
+	// OCSP vars
+	ocspCache     sync.Map
+	ocspMutex     sync.Mutex
+	ocspResponder *ocspResponder
 
 	// If true, do not re-initialize
 	initOnce  bool
@@ -759,6 +765,17 @@ func (a *Authority) init() error {
 		// can be validated when the CA is started.
 		a.scepOptions.SCEPProvisionerNames = a.getSCEPProvisionerNames()
 
+		// provide the database and the CRL, used to answer the GetCert,
+		// GetCRL and CertPoll messages.
+		a.scepOptions.DB = a.db
+		a.scepOptions.GetCRL = func() ([]byte, error) {
+			crlInfo, err := a.GetCertificateRevocationList()
+			if err != nil {
+				return nil, err
+			}
+			return crlInfo.Data, nil
+		}
+
 		// create a new SCEP authority
 		scepAuthority, err := scep.New(a, *a.scepOptions)
 		if err != nil {
@@ -824,6 +841,11 @@ func (a *Authority) init() error {
 		if v := a.config.CRL.CacheDuration; v == nil || v.Duration <= 0 {
 			a.config.CRL.CacheDuration = config.DefaultCRLCacheDuration
 		}
+		if a.config.CRL.IsDeltaEnabled() {
+			if v := a.config.CRL.Delta.CacheDuration; v == nil || v.Duration <= 0 {
+				a.config.CRL.Delta.CacheDuration = config.DefaultDeltaCRLCacheDuration
+			}
+		}
 		// Start CRL generator
 		if err := a.startCRLGenerator(); err != nil {
 			return err
@@ -898,6 +920,9 @@ func (a *Authority) IsAdminAPIEnabled() bool {
 func (a *Authority) Shutdown() error {
 	if a.crlTicker != nil {
 		a.crlTicker.Stop()
+		if a.crlDeltaTicker != nil {
+			a.crlDeltaTicker.Stop()
+		}
 		close(a.crlStopper)
 	}
 
@@ -911,6 +936,9 @@ func (a *Authority) Shutdown() error {
 func (a *Authority) CloseForReload() {
 	if a.crlTicker != nil {
 		a.crlTicker.Stop()
+		if a.crlDeltaTicker != nil {
+			a.crlDeltaTicker.Stop()
+		}
 		close(a.crlStopper)
 	}
 
@@ -974,6 +1002,11 @@ func (a *Authority) startCRLGenerator() error {
 	if !ok {
 		return errors.Errorf("CRL Generation requested, but database does not support CRL generation")
 	}
+	if a.config.CRL.IsPartitioned() || a.config.CRL.IsDeltaEnabled() {
+		if _, ok := a.db.(db.NamedCertificateRevocationListDB); !ok {
+			return errors.Errorf("Partitioned or delta CRL generation requested, but database does not support it")
+		}
+	}
 
 	// Always create a new CRL on startup in case the CA has been down and the
 	// time to next expected CRL update is less than the cache duration.
@@ -984,6 +1017,14 @@ func (a *Authority) startCRLGenerator() error {
 	a.crlStopper = make(chan struct{}, 1)
 	a.crlTicker = time.NewTicker(a.config.CRL.TickerDuration())
 
+	// Delta CRLs are regenerated with their own ticker, a nil channel will
+	// never be selected.
+	var deltaC <-chan time.Time
+	if a.config.CRL.IsDeltaEnabled() {
+		a.crlDeltaTicker = time.NewTicker(a.config.CRL.Delta.TickerDuration())
+		deltaC = a.crlDeltaTicker.C
+	}
+
 	go func() {
 		for {
 			select {
@@ -992,6 +1033,11 @@ func (a *Authority) startCRLGenerator() error {
 				if err := a.GenerateCertificateRevocationList(); err != nil {
 					log.Printf("error regenerating the CRL: %v", err)
 				}
+			case <-deltaC:
+				log.Println("Regenerating delta CRLs")
+				if err := a.GenerateDeltaCertificateRevocationLists(); err != nil {
+					log.Printf("error regenerating the delta CRLs: %v", err)
+				}
 			case <-a.crlStopper:
 				return
 			}
@@ -543,7 +543,7 @@ func TestAuthority_authorizeSign(t *testing.T) {
 				}
 			} else {
 				if assert.Nil(t, tc.err) {
-					assert.Equals(t, 11, len(got)) // number of provisioner.SignOptions returned
+					assert.Equals(t, 12, len(got)) // number of provisioner.SignOptions returned
 				}
 			}
 		})
@@ -2,9 +2,13 @@ package config
 
 import (
 	"bytes"
+	"crypto/x509"
+	"encoding/base64"
 	"encoding/json"
 	"fmt"
 	"net"
+	"net/mail"
+	"net/url"
 	"os"
 	"time"
 
@@ -39,52 +43,97 @@ var (
 	// DefaultDisableSmallstepExtensions is the default value for the
 	// DisableSmallstepExtensions provisioner claim.
 	DefaultDisableSmallstepExtensions = false
+	// DefaultEnableCertificateTransparency is the default value for the
+	// EnableCertificateTransparency provisioner claim.
+	DefaultEnableCertificateTransparency = false
 	// DefaultCRLCacheDuration is the default cache duration for the CRL.
 	DefaultCRLCacheDuration = &provisioner.Duration{Duration: 24 * time.Hour}
+	// DefaultOCSPCacheDuration is the default validity and cache duration for
+	// OCSP responses.
+	DefaultOCSPCacheDuration = &provisioner.Duration{Duration: time.Hour}
+	// DefaultOCSPSignerDuration is the default lifetime of the delegated OCSP
+	// signing certificate.
+	DefaultOCSPSignerDuration = &provisioner.Duration{Duration: 7 * 24 * time.Hour}
+	// DefaultDeltaCRLCacheDuration is the default cache duration for the delta
+	// CRLs.
+	DefaultDeltaCRLCacheDuration = &provisioner.Duration{Duration: time.Hour}
 	// DefaultCRLExpiredDuration is the default duration in which expired
 	// certificates will remain in the CRL after expiration.
 	DefaultCRLExpiredDuration = time.Hour
+	// DefaultCTQuorum is the default number of SCTs required to issue a
+	// certificate when Certificate Transparency is enabled.
+	DefaultCTQuorum = 1
+	// DefaultCTTimeout is the default time to wait for the SCTs of the
+	// Certificate Transparency logs.
+	DefaultCTTimeout = &provisioner.Duration{Duration: 10 * time.Second}
+	// DefaultACMEGCInterval is the default time between two runs of the ACME
+	// garbage collector.
+	DefaultACMEGCInterval = &provisioner.Duration{Duration: time.Hour}
+	// DefaultACMEGCRetention is the default time that expired ACME orders and
+	// authorizations are kept in the database.
+	DefaultACMEGCRetention = &provisioner.Duration{Duration: 30 * 24 * time.Hour}
+	// DefaultACMEGCNonceRetention is the default time that unused ACME nonces
+	// are kept in the database.
+	DefaultACMEGCNonceRetention = &provisioner.Duration{Duration: 24 * time.Hour}
+	// DefaultACMEValidationWorkers is the default number of ACME challenges
+	// validated concurrently.
+	DefaultACMEValidationWorkers = 10
+	// DefaultACMEValidationRetryWindow is the default time during which the
+	// validation of an ACME challenge is retried.
+	DefaultACMEValidationRetryWindow = &provisioner.Duration{Duration: 10 * time.Minute}
+	// DefaultACMEValidationMinBackoff is the default time to wait before the
+	// first retry of an ACME challenge validation.
+	DefaultACMEValidationMinBackoff = &provisioner.Duration{Duration: 5 * time.Second}
+	// DefaultACMEValidationMaxBackoff is the default maximum time to wait
+	// between two retries of an ACME challenge validation.
+	DefaultACMEValidationMaxBackoff = &provisioner.Duration{Duration: time.Minute}
 	// GlobalProvisionerClaims is the default duration that expired certificates
 	// remain in the CRL after expiration.
 	GlobalProvisionerClaims = provisioner.Claims{
-		MinTLSDur:                  &provisioner.Duration{Duration: 5 * time.Minute}, // TLS certs
-		MaxTLSDur:                  &provisioner.Duration{Duration: 24 * time.Hour},
-		DefaultTLSDur:              &provisioner.Duration{Duration: 24 * time.Hour},
-		MinUserSSHDur:              &provisioner.Duration{Duration: 5 * time.Minute}, // User SSH certs
-		MaxUserSSHDur:              &provisioner.Duration{Duration: 24 * time.Hour},
-		DefaultUserSSHDur:          &provisioner.Duration{Duration: 16 * time.Hour},
-		MinHostSSHDur:              &provisioner.Duration{Duration: 5 * time.Minute}, // Host SSH certs
-		MaxHostSSHDur:              &provisioner.Duration{Duration: 30 * 24 * time.Hour},
-		DefaultHostSSHDur:          &provisioner.Duration{Duration: 30 * 24 * time.Hour},
-		EnableSSHCA:                &DefaultEnableSSHCA,
-		DisableRenewal:             &DefaultDisableRenewal,
-		AllowRenewalAfterExpiry:    &DefaultAllowRenewalAfterExpiry,
-		DisableSmallstepExtensions: &DefaultDisableSmallstepExtensions,
+		MinTLSDur:                     &provisioner.Duration{Duration: 5 * time.Minute}, // TLS certs
+		MaxTLSDur:                     &provisioner.Duration{Duration: 24 * time.Hour},
+		DefaultTLSDur:                 &provisioner.Duration{Duration: 24 * time.Hour},
+		MinUserSSHDur:                 &provisioner.Duration{Duration: 5 * time.Minute}, // User SSH certs
+		MaxUserSSHDur:                 &provisioner.Duration{Duration: 24 * time.Hour},
+		DefaultUserSSHDur:             &provisioner.Duration{Duration: 16 * time.Hour},
+		MinHostSSHDur:                 &provisioner.Duration{Duration: 5 * time.Minute}, // Host SSH certs
+		MaxHostSSHDur:                 &provisioner.Duration{Duration: 30 * 24 * time.Hour},
+		DefaultHostSSHDur:             &provisioner.Duration{Duration: 30 * 24 * time.Hour},
+		EnableSSHCA:                   &DefaultEnableSSHCA,
+		DisableRenewal:                &DefaultDisableRenewal,
+		AllowRenewalAfterExpiry:       &DefaultAllowRenewalAfterExpiry,
+		DisableSmallstepExtensions:    &DefaultDisableSmallstepExtensions,
+		EnableCertificateTransparency: &DefaultEnableCertificateTransparency,
 	}
 )
 
 // Config represents the CA configuration and it's mapped to a JSON object.
 type Config struct {
-	Root             multiString          `json:"root"`
-	FederatedRoots   []string             `json:"federatedRoots"`
-	IntermediateCert string               `json:"crt"`
-	IntermediateKey  string               `json:"key"`
-	Address          string               `json:"address"`
-	InsecureAddress  string               `json:"insecureAddress"`
-	DNSNames         []string             `json:"dnsNames"`
-	KMS              *kms.Options         `json:"kms,omitempty"`
-	SSH              *SSHConfig           `json:"ssh,omitempty"`
-	Logger           json.RawMessage      `json:"logger,omitempty"`
-	DB               *db.Config           `json:"db,omitempty"`
-	Monitoring       json.RawMessage      `json:"monitoring,omitempty"`
-	AuthorityConfig  *AuthConfig          `json:"authority,omitempty"`
-	TLS              *TLSOptions          `json:"tls,omitempty"`
-	Password         string               `json:"password,omitempty"`
-	Templates        *templates.Templates `json:"templates,omitempty"`
-	CommonName       string               `json:"commonName,omitempty"`
-	CRL              *CRLConfig           `json:"crl,omitempty"`
-	MetricsAddress   string               `json:"metricsAddress,omitempty"`
-	SkipValidation   bool                 `json:"-"`
+	Root             multiString           `json:"root"`
+	FederatedRoots   []string              `json:"federatedRoots"`
+	IntermediateCert string                `json:"crt"`
+	IntermediateKey  string                `json:"key"`
+	Address          string                `json:"address"`
+	InsecureAddress  string                `json:"insecureAddress"`
+	DNSNames         []string              `json:"dnsNames"`
+	KMS              *kms.Options          `json:"kms,omitempty"`
+	SSH              *SSHConfig            `json:"ssh,omitempty"`
+	Logger           json.RawMessage       `json:"logger,omitempty"`
+	DB               *db.Config            `json:"db,omitempty"`
+	Monitoring       json.RawMessage       `json:"monitoring,omitempty"`
+	AuthorityConfig  *AuthConfig           `json:"authority,omitempty"`
+	TLS              *TLSOptions           `json:"tls,omitempty"`
+	Password         string                `json:"password,omitempty"`
+	Templates        *templates.Templates  `json:"templates,omitempty"`
+	CommonName       string                `json:"commonName,omitempty"`
+	CRL              *CRLConfig            `json:"crl,omitempty"`
+	OCSP             *OCSPConfig           `json:"ocsp,omitempty"`
+	CT               *CTConfig             `json:"ct,omitempty"`
+	ACMEGC           *ACMEGCConfig         `json:"acmeGC,omitempty"`
+	ACMEValidation   *ACMEValidationConfig `json:"acmeValidation,omitempty"`
+	ACMEEmail        *ACMEEmailConfig      `json:"acmeEmail,omitempty"`
+	MetricsAddress   string                `json:"metricsAddress,omitempty"`
+	SkipValidation   bool                  `json:"-"`
 
 	// Keeps record of the filename the Config is read from
 	loadedFromFilepath string
@@ -97,6 +146,49 @@ type CRLConfig struct {
 	CacheDuration    *provisioner.Duration `json:"cacheDuration,omitempty"`
 	RenewPeriod      *provisioner.Duration `json:"renewPeriod,omitempty"`
 	IDPurl           string                `json:"idpURL,omitempty"`
+	// Partitions is the number of partitioned CRLs to generate besides the
+	// complete CRL. Certificates are assigned to a partition using their
+	// serial number, and their CRL distribution point will point to it.
+	Partitions int `json:"partitions,omitempty"`
+	// Delta configures the generation of delta CRLs.
+	Delta *DeltaCRLConfig `json:"delta,omitempty"`
+}
+
+// DeltaCRLConfig represents config options for delta CRL generation. A delta
+// CRL is generated for the complete CRL and for each partitioned CRL.
+type DeltaCRLConfig struct {
+	Enabled       bool                  `json:"enabled"`
+	CacheDuration *provisioner.Duration `json:"cacheDuration,omitempty"`
+	RenewPeriod   *provisioner.Duration `json:"renewPeriod,omitempty"`
+}
+
+// IsEnabled returns if the delta CRL is enabled.
+func (c *DeltaCRLConfig) IsEnabled() bool {
+	return c != nil && c.Enabled
+}
+
+// TickerDuration the renewal ticker duration of the delta CRLs. This is set
+// by renewPeriod, of it is not set is ~2/3 of cacheDuration.
+func (c *DeltaCRLConfig) TickerDuration() time.Duration {
+	if !c.IsEnabled() {
+		return 0
+	}
+
+	if c.RenewPeriod != nil && c.RenewPeriod.Duration > 0 {
+		return c.RenewPeriod.Duration
+	}
+
+	return (c.CacheDuration.Duration / 3) * 2
+}
+
+// IsPartitioned returns if partitioned CRLs are enabled.
+func (c *CRLConfig) IsPartitioned() bool {
+	return c.IsEnabled() && c.Partitions > 1
+}
+
+// IsDeltaEnabled returns if delta CRLs are enabled.
+func (c *CRLConfig) IsDeltaEnabled() bool {
+	return c.IsEnabled() && c.Delta.IsEnabled()
 }
 
 // IsEnabled returns if the CRL is enabled.
@@ -123,6 +215,25 @@ func (c *CRLConfig) Validate() error {
 		return errors.New("crl.cacheDuration must be greater than or equal to crl.renewPeriod")
 	}
 
+	if c.Partitions < 0 {
+		return errors.New("crl.partitions must be greater than or equal to 0")
+	}
+
+	if d := c.Delta; d != nil {
+		if d.CacheDuration != nil && d.CacheDuration.Duration < 0 {
+			return errors.New("crl.delta.cacheDuration must be greater than or equal to 0")
+		}
+
+		if d.RenewPeriod != nil && d.RenewPeriod.Duration < 0 {
+			return errors.New("crl.delta.renewPeriod must be greater than or equal to 0")
+		}
+
+		if d.RenewPeriod != nil && d.CacheDuration != nil &&
+			d.RenewPeriod.Duration > d.CacheDuration.Duration {
+			return errors.New("crl.delta.cacheDuration must be greater than or equal to crl.delta.renewPeriod")
+		}
+	}
+
 	return nil
 }
 
@@ -140,6 +251,281 @@ func (c *CRLConfig) TickerDuration() time.Duration {
 	return (c.CacheDuration.Duration / 3) * 2
 }
 
+// OCSPConfig represents config options for the built-in OCSP responder.
+type OCSPConfig struct {
+	Enabled bool `json:"enabled"`
+	// CacheDuration is the validity of the OCSP responses, it sets the
+	// nextUpdate of the response and the time a response is kept in memory.
+	CacheDuration *provisioner.Duration `json:"cacheDuration,omitempty"`
+	// DelegatedSigner indicates whether the responses are signed with an OCSP
+	// signing certificate issued by the authority instead of the intermediate
+	// key.
+	DelegatedSigner bool `json:"delegatedSigner,omitempty"`
+	// DelegatedSignerDuration is the lifetime of the delegated OCSP signing
+	// certificate.
+	DelegatedSignerDuration *provisioner.Duration `json:"delegatedSignerDuration,omitempty"`
+	// URL is the OCSP responder URL added to the authority information access
+	// extension of the issued certificates.
+	URL string `json:"url,omitempty"`
+}
+
+// IsEnabled returns if the OCSP responder is enabled.
+func (c *OCSPConfig) IsEnabled() bool {
+	return c != nil && c.Enabled
+}
+
+// Validate validates the OCSP configuration.
+func (c *OCSPConfig) Validate() error {
+	if c == nil {
+		return nil
+	}
+
+	if c.CacheDuration != nil && c.CacheDuration.Duration < 0 {
+		return errors.New("ocsp.cacheDuration must be greater than or equal to 0")
+	}
+
+	if c.DelegatedSignerDuration != nil && c.DelegatedSignerDuration.Duration < 0 {
+		return errors.New("ocsp.delegatedSignerDuration must be greater than or equal to 0")
+	}
+
+	if c.URL != "" {
+		if u, err := url.Parse(c.URL); err != nil || u.Scheme == "" || u.Host == "" {
+			return errors.Errorf("ocsp.url %q is not a valid url", c.URL)
+		}
+	}
+
+	return nil
+}
+
+// ACMEGCConfig represents the config options of the garbage collector that
+// removes stale ACME orders, authorizations, challenges and nonces from the
+// database.
+type ACMEGCConfig struct {
+	Enabled bool `json:"enabled"`
+	// Interval is the time between two runs of the garbage collector. It
+	// defaults to 1h.
+	Interval *provisioner.Duration `json:"interval,omitempty"`
+	// Retention is the time that orders and authorizations are kept after
+	// their expiration. It defaults to 720h (30 days).
+	Retention *provisioner.Duration `json:"retention,omitempty"`
+	// NonceRetention is the time that unused nonces are kept after their
+	// creation. It defaults to 24h.
+	NonceRetention *provisioner.Duration `json:"nonceRetention,omitempty"`
+}
+
+// IsEnabled returns if the ACME garbage collector is enabled.
+func (c *ACMEGCConfig) IsEnabled() bool {
+	return c != nil && c.Enabled
+}
+
+// Validate validates the ACME garbage collector configuration.
+func (c *ACMEGCConfig) Validate() error {
+	if c == nil {
+		return nil
+	}
+
+	if c.Interval != nil && c.Interval.Duration <= 0 {
+		return errors.New("acmeGC.interval must be greater than 0")
+	}
+
+	if c.Retention != nil && c.Retention.Duration < 0 {
+		return errors.New("acmeGC.retention must be greater than or equal to 0")
+	}
+
+	if c.NonceRetention != nil && c.NonceRetention.Duration < 0 {
+		return errors.New("acmeGC.nonceRetention must be greater than or equal to 0")
+	}
+
+	return nil
+}
+
+// ACMEValidationConfig represents the config options of the asynchronous
+// validation of ACME challenges. If enabled, http-01, dns-01, dns-account-01
+// and tls-alpn-01 challenges are validated by a pool of workers, and
+// validations failing with transient errors are retried.
+type ACMEValidationConfig struct {
+	Enabled bool `json:"enabled"`
+	// Workers is the number of challenges validated concurrently. It
+	// defaults to 10.
+	Workers int `json:"workers,omitempty"`
+	// RetryWindow is the time during which a failed validation is retried.
+	// It defaults to 10m.
+	RetryWindow *provisioner.Duration `json:"retryWindow,omitempty"`
+	// MinBackoff is the time to wait before the first retry, it's doubled on
+	// each retry. It defaults to 5s.
+	MinBackoff *provisioner.Duration `json:"minBackoff,omitempty"`
+	// MaxBackoff is the maximum time to wait between two retries. It
+	// defaults to 1m.
+	MaxBackoff *provisioner.Duration `json:"maxBackoff,omitempty"`
+}
+
+// IsEnabled returns if the asynchronous validation of ACME challenges is
+// enabled.
+func (c *ACMEValidationConfig) IsEnabled() bool {
+	return c != nil && c.Enabled
+}
+
+// Validate validates the ACME validation configuration.
+func (c *ACMEValidationConfig) Validate() error {
+	if c == nil {
+		return nil
+	}
+
+	if c.Workers < 0 {
+		return errors.New("acmeValidation.workers must be greater than or equal to 0")
+	}
+
+	if c.RetryWindow != nil && c.RetryWindow.Duration < 0 {
+		return errors.New("acmeValidation.retryWindow must be greater than or equal to 0")
+	}
+
+	if c.MinBackoff != nil && c.MinBackoff.Duration <= 0 {
+		return errors.New("acmeValidation.minBackoff must be greater than 0")
+	}
+
+	if c.MaxBackoff != nil && c.MaxBackoff.Duration <= 0 {
+		return errors.New("acmeValidation.maxBackoff must be greater than 0")
+	}
+
+	if c.MinBackoff != nil && c.MaxBackoff != nil && c.MaxBackoff.Duration < c.MinBackoff.Duration {
+		return errors.New("acmeValidation.maxBackoff must be greater than or equal to acmeValidation.minBackoff")
+	}
+
+	return nil
+}
+
+// ACMEEmailConfig represents the config options of the email-reply-00 ACME
+// challenge, RFC 8823. The challenge emails are sent through an SMTP relay,
+// and the replies are received by an SMTP server run by the CA.
+type ACMEEmailConfig struct {
+	// From is the address used to send the challenge emails. The replies are
+	// sent to this address, so its mail must be delivered to ListenAddress.
+	From string `json:"from"`
+	// Relay is the address of the SMTP relay used to send the challenge
+	// emails, in host:port format.
+	Relay string `json:"relay"`
+	// Username and Password are the optional credentials used to
+	// authenticate with the relay.
+	Username string `json:"username,omitempty"`
+	Password string `json:"password,omitempty"`
+	// ListenAddress is the address of the SMTP server receiving the replies.
+	ListenAddress string `json:"listenAddress"`
+	// DKIM contains the optional options used to sign the challenge emails.
+	DKIM *ACMEEmailDKIMConfig `json:"dkim,omitempty"`
+}
+
+// ACMEEmailDKIMConfig represents the options used to sign the challenge
+// emails using DKIM.
+type ACMEEmailDKIMConfig struct {
+	Domain   string `json:"domain"`
+	Selector string `json:"selector"`
+	// Key is the path to the PEM encoded RSA or Ed25519 private key.
+	Key string `json:"key"`
+}
+
+// Validate validates the ACME email configuration.
+func (c *ACMEEmailConfig) Validate() error {
+	if c == nil {
+		return nil
+	}
+
+	if addr, err := mail.ParseAddress(c.From); err != nil || addr.Address != c.From {
+		return errors.Errorf("acmeEmail.from %q is not a valid email address", c.From)
+	}
+
+	if _, _, err := net.SplitHostPort(c.Relay); err != nil {
+		return errors.Errorf("acmeEmail.relay %q is not a valid address", c.Relay)
+	}
+
+	if c.Password != "" && c.Username == "" {
+		return errors.New("acmeEmail.username cannot be empty if acmeEmail.password is set")
+	}
+
+	if _, _, err := net.SplitHostPort(c.ListenAddress); err != nil {
+		return errors.Errorf("acmeEmail.listenAddress %q is not a valid address", c.ListenAddress)
+	}
+
+	if d := c.DKIM; d != nil {
+		switch {
+		case d.Domain == "":
+			return errors.New("acmeEmail.dkim.domain cannot be empty")
+		case d.Selector == "":
+			return errors.New("acmeEmail.dkim.selector cannot be empty")
+		case d.Key == "":
+			return errors.New("acmeEmail.dkim.key cannot be empty")
+		}
+	}
+
+	return nil
+}
+
+// CTConfig represents the config options for the submission of certificates
+// to Certificate Transparency logs. Certificates are only submitted if the
+// provisioner enables the enableCertificateTransparency claim.
+type CTConfig struct {
+	Logs []CTLog `json:"logs"`
+	// Quorum is the number of SCTs that must be collected before issuing the
+	// certificate. It defaults to 1 if logs are configured.
+	Quorum int `json:"quorum,omitempty"`
+	// Timeout is the maximum time to wait for the logs to return the SCTs.
+	Timeout *provisioner.Duration `json:"timeout,omitempty"`
+}
+
+// CTLog represents an RFC 6962 Certificate Transparency log.
+type CTLog struct {
+	Name string `json:"name,omitempty"`
+	URL  string `json:"url"`
+	// Key is the base64 encoded DER public key of the log.
+	Key string `json:"key"`
+}
+
+// PublicKey returns the DER encoded public key of the log.
+func (l *CTLog) PublicKey() ([]byte, error) {
+	der, err := base64.StdEncoding.DecodeString(l.Key)
+	if err != nil {
+		return nil, errors.Wrapf(err, "error decoding ct log %q key", l.URL)
+	}
+	if _, err := x509.ParsePKIXPublicKey(der); err != nil {
+		return nil, errors.Wrapf(err, "error parsing ct log %q key", l.URL)
+	}
+	return der, nil
+}
+
+// IsEnabled returns if Certificate Transparency logs are configured.
+func (c *CTConfig) IsEnabled() bool {
+	return c != nil && len(c.Logs) > 0
+}
+
+// Validate validates the Certificate Transparency configuration.
+func (c *CTConfig) Validate() error {
+	if c == nil {
+		return nil
+	}
+
+	for i := range c.Logs {
+		l := &c.Logs[i]
+		if u, err := url.Parse(l.URL); err != nil || u.Scheme == "" || u.Host == "" {
+			return errors.Errorf("ct.logs[%d].url %q is not a valid url", i, l.URL)
+		}
+		if _, err := l.PublicKey(); err != nil {
+			return errors.Wrapf(err, "ct.logs[%d].key is not valid", i)
+		}
+	}
+
+	if len(c.Logs) == 0 && c.Quorum != 0 {
+		return errors.New("ct.quorum cannot be set without ct.logs")
+	}
+	if c.Quorum < 0 || c.Quorum > len(c.Logs) {
+		return errors.Errorf("ct.quorum must be between 0 and the number of logs (%d)", len(c.Logs))
+	}
+
+	if c.Timeout != nil && c.Timeout.Duration < 0 {
+		return errors.New("ct.timeout must be greater than or equal to 0")
+	}
+
+	return nil
+}
+
 // ASN1DN contains ASN1.DN attributes that are used in Subject and Issuer
 // x509 Certificate blocks.
 type ASN1DN struct {
@@ -255,6 +641,50 @@ func (c *Config) Init() {
 	if c.CRL != nil && c.CRL.Enabled && c.CRL.CacheDuration == nil {
 		c.CRL.CacheDuration = DefaultCRLCacheDuration
 	}
+	if c.CRL.IsDeltaEnabled() && c.CRL.Delta.CacheDuration == nil {
+		c.CRL.Delta.CacheDuration = DefaultDeltaCRLCacheDuration
+	}
+	if c.OCSP != nil && c.OCSP.Enabled {
+		if c.OCSP.CacheDuration == nil {
+			c.OCSP.CacheDuration = DefaultOCSPCacheDuration
+		}
+		if c.OCSP.DelegatedSignerDuration == nil {
+			c.OCSP.DelegatedSignerDuration = DefaultOCSPSignerDuration
+		}
+	}
+	if c.CT != nil {
+		if c.CT.IsEnabled() && c.CT.Quorum == 0 {
+			c.CT.Quorum = DefaultCTQuorum
+		}
+		if c.CT.Timeout == nil {
+			c.CT.Timeout = DefaultCTTimeout
+		}
+	}
+	if c.ACMEGC != nil {
+		if c.ACMEGC.Interval == nil {
+			c.ACMEGC.Interval = DefaultACMEGCInterval
+		}
+		if c.ACMEGC.Retention == nil {
+			c.ACMEGC.Retention = DefaultACMEGCRetention
+		}
+		if c.ACMEGC.NonceRetention == nil {
+			c.ACMEGC.NonceRetention = DefaultACMEGCNonceRetention
+		}
+	}
+	if c.ACMEValidation != nil {
+		if c.ACMEValidation.Workers == 0 {
+			c.ACMEValidation.Workers = DefaultACMEValidationWorkers
+		}
+		if c.ACMEValidation.RetryWindow == nil {
+			c.ACMEValidation.RetryWindow = DefaultACMEValidationRetryWindow
+		}
+		if c.ACMEValidation.MinBackoff == nil {
+			c.ACMEValidation.MinBackoff = DefaultACMEValidationMinBackoff
+		}
+		if c.ACMEValidation.MaxBackoff == nil {
+			c.ACMEValidation.MaxBackoff = DefaultACMEValidationMaxBackoff
+		}
+	}
 	c.AuthorityConfig.init()
 }
 
@@ -377,6 +807,31 @@ func (c *Config) Validate() error {
 		return err
 	}
 
+	// Validate ocsp config: nil is ok
+	if err := c.OCSP.Validate(); err != nil {
+		return err
+	}
+
+	// Validate ct config: nil is ok
+	if err := c.CT.Validate(); err != nil {
+		return err
+	}
+
+	// Validate acme gc config: nil is ok
+	if err := c.ACMEGC.Validate(); err != nil {
+		return err
+	}
+
+	// Validate acme validation config: nil is ok
+	if err := c.ACMEValidation.Validate(); err != nil {
+		return err
+	}
+
+	// Validate acme email config: nil is ok
+	if err := c.ACMEEmail.Validate(); err != nil {
+		return err
+	}
+
 	return c.AuthorityConfig.Validate(c.GetAudiences())
 }
 
@@ -1,15 +1,19 @@
 package config
 
 import (
+	"crypto/x509"
+	"encoding/base64"
 	"fmt"
 	"reflect"
 	"testing"
+	"time"
 
 	"github.com/pkg/errors"
 	"github.com/smallstep/assert"
 	"github.com/smallstep/certificates/authority/provisioner"
 	_ "github.com/smallstep/certificates/cas"
 	"go.step.sm/crypto/jose"
+	"go.step.sm/crypto/keyutil"
 )
 
 func TestConfigValidate(t *testing.T) {
@@ -360,3 +364,287 @@ func TestConfig_Audience(t *testing.T) {
 		})
 	}
 }
+
+func TestOCSPConfig_Validate(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  *OCSPConfig
+		wantErr bool
+	}{
+		{"ok/nil", nil, false},
+		{"ok/empty", &OCSPConfig{}, false},
+		{"ok", &OCSPConfig{
+			Enabled:                 true,
+			CacheDuration:           &provisioner.Duration{Duration: time.Hour},
+			DelegatedSigner:         true,
+			DelegatedSignerDuration: &provisioner.Duration{Duration: 24 * time.Hour},
+			URL:                     "http://ca.example.com/ocsp",
+		}, false},
+		{"fail/cacheDuration", &OCSPConfig{
+			Enabled:       true,
+			CacheDuration: &provisioner.Duration{Duration: -time.Hour},
+		}, true},
+		{"fail/delegatedSignerDuration", &OCSPConfig{
+			Enabled:                 true,
+			DelegatedSignerDuration: &provisioner.Duration{Duration: -time.Hour},
+		}, true},
+		{"fail/url", &OCSPConfig{
+			Enabled: true,
+			URL:     "ocsp.example.com",
+		}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.config.Validate(); (err != nil) != tt.wantErr {
+				t.Errorf("OCSPConfig.Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestACMEGCConfig_Validate(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  *ACMEGCConfig
+		wantErr bool
+	}{
+		{"ok/nil", nil, false},
+		{"ok/empty", &ACMEGCConfig{}, false},
+		{"ok", &ACMEGCConfig{
+			Enabled:        true,
+			Interval:       &provisioner.Duration{Duration: time.Hour},
+			Retention:      &provisioner.Duration{Duration: 24 * time.Hour},
+			NonceRetention: &provisioner.Duration{Duration: time.Hour},
+		}, false},
+		{"fail/interval", &ACMEGCConfig{
+			Enabled:  true,
+			Interval: &provisioner.Duration{Duration: -time.Hour},
+		}, true},
+		{"fail/retention", &ACMEGCConfig{
+			Enabled:   true,
+			Retention: &provisioner.Duration{Duration: -time.Hour},
+		}, true},
+		{"fail/nonceRetention", &ACMEGCConfig{
+			Enabled:        true,
+			NonceRetention: &provisioner.Duration{Duration: -time.Hour},
+		}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.config.Validate(); (err != nil) != tt.wantErr {
+				t.Errorf("ACMEGCConfig.Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestCTConfig_Validate(t *testing.T) {
+	pub, _, err := keyutil.GenerateDefaultKeyPair()
+	assert.FatalError(t, err)
+	der, err := x509.MarshalPKIXPublicKey(pub)
+	assert.FatalError(t, err)
+	key := base64.StdEncoding.EncodeToString(der)
+
+	tests := []struct {
+		name    string
+		config  *CTConfig
+		wantErr bool
+	}{
+		{"ok/nil", nil, false},
+		{"ok/empty", &CTConfig{}, false},
+		{"ok", &CTConfig{
+			Logs: []CTLog{
+				{Name: "log1", URL: "https://ct1.example.com/log", Key: key},
+				{Name: "log2", URL: "https://ct2.example.com", Key: key},
+			},
+			Quorum:  2,
+			Timeout: &provisioner.Duration{Duration: 5 * time.Second},
+		}, false},
+		{"fail/url", &CTConfig{
+			Logs: []CTLog{{URL: "ct.example.com", Key: key}},
+		}, true},
+		{"fail/key", &CTConfig{
+			Logs: []CTLog{{URL: "https://ct.example.com", Key: "foo"}},
+		}, true},
+		{"fail/quorum", &CTConfig{
+			Logs:   []CTLog{{URL: "https://ct.example.com", Key: key}},
+			Quorum: 2,
+		}, true},
+		{"fail/quorum without logs", &CTConfig{
+			Quorum: 1,
+		}, true},
+		{"fail/negative quorum", &CTConfig{
+			Logs:   []CTLog{{URL: "https://ct.example.com", Key: key}},
+			Quorum: -1,
+		}, true},
+		{"fail/timeout", &CTConfig{
+			Logs:    []CTLog{{URL: "https://ct.example.com", Key: key}},
+			Timeout: &provisioner.Duration{Duration: -time.Second},
+		}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.config.Validate(); (err != nil) != tt.wantErr {
+				t.Errorf("CTConfig.Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestCRLConfig_Validate(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  *CRLConfig
+		wantErr bool
+	}{
+		{"ok/nil", nil, false},
+		{"ok/empty", &CRLConfig{}, false},
+		{"ok", &CRLConfig{
+			Enabled:       true,
+			CacheDuration: &provisioner.Duration{Duration: 24 * time.Hour},
+			RenewPeriod:   &provisioner.Duration{Duration: 16 * time.Hour},
+			Partitions:    16,
+			Delta: &DeltaCRLConfig{
+				Enabled:       true,
+				CacheDuration: &provisioner.Duration{Duration: time.Hour},
+				RenewPeriod:   &provisioner.Duration{Duration: 40 * time.Minute},
+			},
+		}, false},
+		{"fail/cacheDuration", &CRLConfig{
+			Enabled:       true,
+			CacheDuration: &provisioner.Duration{Duration: -time.Hour},
+		}, true},
+		{"fail/renewPeriod", &CRLConfig{
+			Enabled:       true,
+			CacheDuration: &provisioner.Duration{Duration: time.Hour},
+			RenewPeriod:   &provisioner.Duration{Duration: 2 * time.Hour},
+		}, true},
+		{"fail/partitions", &CRLConfig{
+			Enabled:    true,
+			Partitions: -1,
+		}, true},
+		{"fail/delta-cacheDuration", &CRLConfig{
+			Enabled: true,
+			Delta: &DeltaCRLConfig{
+				Enabled:       true,
+				CacheDuration: &provisioner.Duration{Duration: -time.Hour},
+			},
+		}, true},
+		{"fail/delta-renewPeriod", &CRLConfig{
+			Enabled: true,
+			Delta: &DeltaCRLConfig{
+				Enabled:       true,
+				CacheDuration: &provisioner.Duration{Duration: time.Hour},
+				RenewPeriod:   &provisioner.Duration{Duration: 2 * time.Hour},
+			},
+		}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.config.Validate(); (err != nil) != tt.wantErr {
+				t.Errorf("CRLConfig.Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestACMEValidationConfig_Validate(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  *ACMEValidationConfig
+		wantErr bool
+	}{
+		{"ok/nil", nil, false},
+		{"ok/empty", &ACMEValidationConfig{}, false},
+		{"ok", &ACMEValidationConfig{
+			Enabled:     true,
+			Workers:     5,
+			RetryWindow: &provisioner.Duration{Duration: 5 * time.Minute},
+			MinBackoff:  &provisioner.Duration{Duration: time.Second},
+			MaxBackoff:  &provisioner.Duration{Duration: 30 * time.Second},
+		}, false},
+		{"fail/workers", &ACMEValidationConfig{
+			Enabled: true,
+			Workers: -1,
+		}, true},
+		{"fail/retryWindow", &ACMEValidationConfig{
+			Enabled:     true,
+			RetryWindow: &provisioner.Duration{Duration: -time.Minute},
+		}, true},
+		{"fail/minBackoff", &ACMEValidationConfig{
+			Enabled:    true,
+			MinBackoff: &provisioner.Duration{Duration: 0},
+		}, true},
+		{"fail/maxBackoff", &ACMEValidationConfig{
+			Enabled:    true,
+			MaxBackoff: &provisioner.Duration{Duration: -time.Second},
+		}, true},
+		{"fail/backoff", &ACMEValidationConfig{
+			Enabled:    true,
+			MinBackoff: &provisioner.Duration{Duration: time.Minute},
+			MaxBackoff: &provisioner.Duration{Duration: time.Second},
+		}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.config.Validate(); (err != nil) != tt.wantErr {
+				t.Errorf("ACMEValidationConfig.Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestACMEEmailConfig_Validate(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  *ACMEEmailConfig
+		wantErr bool
+	}{
+		{"ok/nil", nil, false},
+		{"ok", &ACMEEmailConfig{
+			From:          "acme@ca.example.com",
+			Relay:         "smtp.example.com:587",
+			Username:      "acme",
+			Password:      "password",
+			ListenAddress: ":25",
+			DKIM: &ACMEEmailDKIMConfig{
+				Domain:   "ca.example.com",
+				Selector: "acme",
+				Key:      "dkim.key",
+			},
+		}, false},
+		{"fail/from", &ACMEEmailConfig{
+			From:          "ACME <acme@ca.example.com>",
+			Relay:         "smtp.example.com:587",
+			ListenAddress: ":25",
+		}, true},
+		{"fail/relay", &ACMEEmailConfig{
+			From:          "acme@ca.example.com",
+			Relay:         "smtp.example.com",
+			ListenAddress: ":25",
+		}, true},
+		{"fail/password", &ACMEEmailConfig{
+			From:          "acme@ca.example.com",
+			Relay:         "smtp.example.com:587",
+			Password:      "password",
+			ListenAddress: ":25",
+		}, true},
+		{"fail/listenAddress", &ACMEEmailConfig{
+			From:  "acme@ca.example.com",
+			Relay: "smtp.example.com:587",
+		}, true},
+		{"fail/dkim", &ACMEEmailConfig{
+			From:          "acme@ca.example.com",
+			Relay:         "smtp.example.com:587",
+			ListenAddress: ":25",
+			DKIM:          &ACMEEmailDKIMConfig{Domain: "ca.example.com", Selector: "acme"},
+		}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.config.Validate(); (err != nil) != tt.wantErr {
+				t.Errorf("ACMEEmailConfig.Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
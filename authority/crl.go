@@ -0,0 +1,504 @@
+package authority
+
+import (
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"math/big"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/smallstep/certificates/authority/config"
+	"github.com/smallstep/certificates/authority/provisioner"
+	casapi "github.com/smallstep/certificates/cas/apiv1"
+	"github.com/smallstep/certificates/db"
+	"github.com/smallstep/certificates/errs"
+	"github.com/smallstep/nosql/database"
+)
+
+var (
+	oidExtensionDeltaCRLIndicator = asn1.ObjectIdentifier{2, 5, 29, 27}
+	oidExtensionFreshestCRL       = asn1.ObjectIdentifier{2, 5, 29, 46}
+)
+
+// deltaCRLSuffix is the suffix used in the name of the delta CRLs.
+const deltaCRLSuffix = "delta"
+
+// crlScope defines the set of certificates covered by a CRL. The complete CRL
+// covers all the certificates, and a partitioned CRL covers the certificates
+// with a serial number that modulo the number of partitions is equal to the
+// index of the partition.
+type crlScope struct {
+	index      int
+	partitions int
+}
+
+// completeCRLScope is the scope of the complete CRL.
+var completeCRLScope = crlScope{index: -1}
+
+// isComplete returns true if the scope is the one of the complete CRL.
+func (s crlScope) isComplete() bool {
+	return s.index < 0
+}
+
+// name returns the name of the CRL, the complete CRL does not have a name.
+func (s crlScope) name() string {
+	if s.isComplete() {
+		return ""
+	}
+	return strconv.Itoa(s.index)
+}
+
+// deltaName returns the name of the delta CRL of the scope.
+func (s crlScope) deltaName() string {
+	if s.isComplete() {
+		return deltaCRLSuffix
+	}
+	return s.name() + "-" + deltaCRLSuffix
+}
+
+// contains returns true if the given serial number is covered by the scope.
+func (s crlScope) contains(sn *big.Int) bool {
+	if s.isComplete() {
+		return true
+	}
+	return crlPartitionIndex(sn, s.partitions) == s.index
+}
+
+// crlPartitionIndex returns the index of the partitioned CRL for the given
+// serial number.
+func crlPartitionIndex(sn *big.Int, partitions int) int {
+	var m big.Int
+	return int(m.Mod(sn, big.NewInt(int64(partitions))).Int64())
+}
+
+// newSerialNumber returns a random 128-bit serial number.
+func newSerialNumber() (*big.Int, error) {
+	limit := new(big.Int).Lsh(big.NewInt(1), 128)
+	sn, err := rand.Int(rand.Reader, limit)
+	if err != nil {
+		return nil, errors.Wrap(err, "error generating serial number")
+	}
+	return sn, nil
+}
+
+// newPartitionedSerialNumber returns a random serial number that belongs to
+// the partitioned CRL with the given index.
+func newPartitionedSerialNumber(partitions, index int) (*big.Int, error) {
+	sn, err := newSerialNumber()
+	if err != nil {
+		return nil, err
+	}
+	n := big.NewInt(int64(partitions))
+	sn.Sub(sn, new(big.Int).Mod(sn, n))
+	return sn.Add(sn, big.NewInt(int64(index))), nil
+}
+
+// crlScopes returns the scopes of all the CRLs generated by the authority.
+func (a *Authority) crlScopes() []crlScope {
+	scopes := []crlScope{completeCRLScope}
+	if a.config.CRL.IsPartitioned() {
+		for i := 0; i < a.config.CRL.Partitions; i++ {
+			scopes = append(scopes, crlScope{index: i, partitions: a.config.CRL.Partitions})
+		}
+	}
+	return scopes
+}
+
+// crlScopeByName returns the scope of the CRL with the given name, and if the
+// name refers to a delta CRL.
+func (a *Authority) crlScopeByName(name string) (crlScope, bool, bool) {
+	isDelta := false
+	switch {
+	case name == deltaCRLSuffix:
+		name, isDelta = "", true
+	case strings.HasSuffix(name, "-"+deltaCRLSuffix):
+		name, isDelta = strings.TrimSuffix(name, "-"+deltaCRLSuffix), true
+	}
+	if isDelta && !a.config.CRL.IsDeltaEnabled() {
+		return crlScope{}, false, false
+	}
+	if name == "" {
+		return completeCRLScope, isDelta, true
+	}
+	if !a.config.CRL.IsPartitioned() {
+		return crlScope{}, false, false
+	}
+	i, err := strconv.Atoi(name)
+	if err != nil || i < 0 || i >= a.config.CRL.Partitions || strconv.Itoa(i) != name {
+		return crlScope{}, false, false
+	}
+	return crlScope{index: i, partitions: a.config.CRL.Partitions}, isDelta, true
+}
+
+// crlURL returns the URL of the CRL with the given name. The URL of the
+// complete CRL is the configured IDP url or the default one.
+func (a *Authority) crlURL(name string) string {
+	var u string
+	if a.config.CRL.IDPurl != "" {
+		u = a.config.CRL.IDPurl
+	} else {
+		u = a.config.Audience("/1.0/crl")[0]
+	}
+	if name == "" {
+		return u
+	}
+	return strings.TrimSuffix(u, "/") + "/" + name
+}
+
+// GetCertificateRevocationListByName returns the partitioned or delta CRL with
+// the given name. An empty name returns the complete CRL.
+func (a *Authority) GetCertificateRevocationListByName(name string) (*CertificateRevocationListInfo, error) {
+	if name == "" {
+		return a.GetCertificateRevocationList()
+	}
+
+	if !a.config.CRL.IsEnabled() {
+		return nil, errs.Wrap(http.StatusNotFound, errors.Errorf("Certificate Revocation Lists are not enabled"), "authority.GetCertificateRevocationListByName")
+	}
+
+	if _, _, ok := a.crlScopeByName(name); !ok {
+		return nil, errs.Wrap(http.StatusNotFound, errors.Errorf("Certificate Revocation List %q does not exist", name), "authority.GetCertificateRevocationListByName")
+	}
+
+	crlDB, ok := a.db.(db.NamedCertificateRevocationListDB)
+	if !ok {
+		return nil, errs.Wrap(http.StatusNotImplemented, errors.Errorf("Database does not support partitioned or delta Certificate Revocation Lists"), "authority.GetCertificateRevocationListByName")
+	}
+
+	crlInfo, err := crlDB.GetCRLByName(name)
+	if err != nil {
+		return nil, errs.Wrap(http.StatusInternalServerError, err, "authority.GetCertificateRevocationListByName")
+	}
+
+	return &CertificateRevocationListInfo{
+		Number:    crlInfo.Number,
+		ExpiresAt: crlInfo.ExpiresAt,
+		Duration:  crlInfo.Duration,
+		Data:      crlInfo.DER,
+	}, nil
+}
+
+// GenerateDeltaCertificateRevocationLists generates the delta CRLs of the
+// complete CRL and of the partitioned CRLs. Returns nil if delta CRLs are not
+// enabled.
+func (a *Authority) GenerateDeltaCertificateRevocationLists() error {
+	if !a.config.CRL.IsDeltaEnabled() {
+		return nil
+	}
+
+	g, err := a.newCRLGenerator()
+	if err != nil {
+		return err
+	}
+
+	a.crlMutex.Lock()
+	defer a.crlMutex.Unlock()
+
+	if err := g.load(); err != nil {
+		return err
+	}
+
+	for _, scope := range a.crlScopes() {
+		if err := g.generate(scope, true); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// crlGenerator generates and stores the CRLs of the authority.
+type crlGenerator struct {
+	config      *config.Config
+	db          db.CertificateRevocationListDB
+	cas         casapi.CertificateAuthorityCRLGenerator
+	url         func(string) string
+	now         time.Time
+	revokedList []db.RevokedCertificateInfo
+}
+
+func (a *Authority) newCRLGenerator() (*crlGenerator, error) {
+	crlDB, ok := a.db.(db.CertificateRevocationListDB)
+	if !ok {
+		return nil, errors.Errorf("Database does not support CRL generation")
+	}
+
+	if a.config.CRL.IsPartitioned() || a.config.CRL.IsDeltaEnabled() {
+		if _, ok := a.db.(db.NamedCertificateRevocationListDB); !ok {
+			return nil, errors.Errorf("Database does not support partitioned or delta CRL generation")
+		}
+	}
+
+	// some CAS may not implement the CRLGenerator interface, so check before we proceed
+	caCRLGenerator, ok := a.x509CAService.(casapi.CertificateAuthorityCRLGenerator)
+	if !ok {
+		return nil, errors.Errorf("CA does not support CRL Generation")
+	}
+
+	return &crlGenerator{
+		config: a.config,
+		db:     crlDB,
+		cas:    caCRLGenerator,
+		url:    a.crlURL,
+	}, nil
+}
+
+// load loads the list of revoked certificates and sets the time used in the
+// generated CRLs.
+func (g *crlGenerator) load() error {
+	g.now = time.Now().Truncate(time.Second).UTC()
+	revokedList, err := g.db.GetRevokedCertificates()
+	if err != nil {
+		return errors.Wrap(err, "could not retrieve revoked certificates list from database")
+	}
+	if revokedList != nil {
+		g.revokedList = *revokedList
+	}
+	return nil
+}
+
+// get returns the stored CRL with the given name or nil if it does not exist.
+func (g *crlGenerator) get(name string) (*db.CertificateRevocationListInfo, error) {
+	var (
+		crlInfo *db.CertificateRevocationListInfo
+		err     error
+	)
+	if name == "" {
+		crlInfo, err = g.db.GetCRL()
+	} else {
+		crlInfo, err = g.db.(db.NamedCertificateRevocationListDB).GetCRLByName(name)
+	}
+	if err != nil {
+		if database.IsErrNotFound(err) {
+			return nil, nil
+		}
+		return nil, errors.Wrap(err, "could not retrieve CRL from database")
+	}
+	return crlInfo, nil
+}
+
+// store stores the CRL with the given name.
+func (g *crlGenerator) store(name string, crlInfo *db.CertificateRevocationListInfo) error {
+	var err error
+	if name == "" {
+		err = g.db.StoreCRL(crlInfo)
+	} else {
+		err = g.db.(db.NamedCertificateRevocationListDB).StoreCRLByName(name, crlInfo)
+	}
+	if err != nil {
+		return errors.Wrap(err, "could not store CRL in database")
+	}
+	return nil
+}
+
+// generate generates the complete or delta CRL of the given scope and stores
+// it in the database.
+func (g *crlGenerator) generate(scope crlScope, isDelta bool) error {
+	crlInfo, err := g.get(scope.name())
+	if err != nil {
+		return err
+	}
+
+	var deltaInfo *db.CertificateRevocationListInfo
+	if g.config.CRL.IsDeltaEnabled() {
+		if deltaInfo, err = g.get(scope.deltaName()); err != nil {
+			return err
+		}
+	}
+
+	// A delta CRL requires the base CRL, RFC 5280, section 5.2.4.
+	var baseNumber *big.Int
+	var baseThisUpdate time.Time
+	if isDelta {
+		if crlInfo == nil {
+			return errors.Errorf("could not generate delta CRL %q: base CRL not found", scope.deltaName())
+		}
+		base, err := x509.ParseRevocationList(crlInfo.DER)
+		if err != nil {
+			return errors.Wrapf(err, "could not parse base CRL of delta CRL %q", scope.deltaName())
+		}
+		baseNumber = big.NewInt(crlInfo.Number)
+		baseThisUpdate = base.ThisUpdate
+	}
+
+	// Number is a monotonically increasing integer (essentially the CRL version
+	// number) that we need to keep track of and increase every time we generate
+	// a new CRL. Complete and delta CRLs of the same scope share the sequence.
+	var bn big.Int
+	if crlInfo != nil {
+		bn.SetInt64(crlInfo.Number + 1)
+	}
+	if deltaInfo != nil && deltaInfo.Number+1 > bn.Int64() {
+		bn.SetInt64(deltaInfo.Number + 1)
+	}
+
+	// Convert our database db.RevokedCertificateInfo types into the pkix
+	// representation ready for the CAS to sign it
+	var revokedCertificates []pkix.RevokedCertificate
+	skipExpiredTime := g.now.Add(-config.DefaultCRLExpiredDuration)
+	for _, revokedCert := range g.revokedList {
+		// skip expired certificates
+		if !revokedCert.ExpiresAt.IsZero() && revokedCert.ExpiresAt.Before(skipExpiredTime) {
+			continue
+		}
+
+		// delta CRLs only contain the certificates revoked after the base
+		if isDelta && revokedCert.RevokedAt.Before(baseThisUpdate) {
+			continue
+		}
+
+		var sn big.Int
+		if _, ok := sn.SetString(revokedCert.Serial, 10); !ok {
+			continue
+		}
+		if !scope.contains(&sn) {
+			continue
+		}
+
+		revokedCertificates = append(revokedCertificates, pkix.RevokedCertificate{
+			SerialNumber:   &sn,
+			RevocationTime: revokedCert.RevokedAt,
+			Extensions:     nil,
+		})
+	}
+
+	var updateDuration time.Duration
+	switch {
+	case isDelta:
+		updateDuration = g.config.CRL.Delta.CacheDuration.Duration
+	case g.config.CRL.CacheDuration != nil:
+		updateDuration = g.config.CRL.CacheDuration.Duration
+	case crlInfo != nil:
+		updateDuration = crlInfo.Duration
+	}
+
+	// Create a RevocationList representation ready for the CAS to sign
+	// TODO: allow SignatureAlgorithm to be specified?
+	revocationList := x509.RevocationList{
+		SignatureAlgorithm:  0,
+		RevokedCertificates: revokedCertificates,
+		Number:              &bn,
+		ThisUpdate:          g.now,
+		NextUpdate:          g.now.Add(updateDuration),
+	}
+
+	// Add distribution point. A delta CRL has the same scope than its base
+	// CRL, so both use the same issuing distribution point.
+	//
+	// Note that this is currently using the port 443 by default.
+	if b, err := marshalDistributionPoint(g.url(scope.name()), false); err == nil {
+		revocationList.ExtraExtensions = []pkix.Extension{
+			{Id: oidExtensionIssuingDistributionPoint, Critical: true, Value: b},
+		}
+	}
+
+	switch {
+	case isDelta:
+		b, err := asn1.Marshal(baseNumber)
+		if err != nil {
+			return errors.Wrap(err, "error marshaling delta CRL indicator")
+		}
+		revocationList.ExtraExtensions = append(revocationList.ExtraExtensions, pkix.Extension{
+			Id: oidExtensionDeltaCRLIndicator, Critical: true, Value: b,
+		})
+	case g.config.CRL.IsDeltaEnabled():
+		b, err := marshalCRLDistributionPoints(g.url(scope.deltaName()))
+		if err != nil {
+			return errors.Wrap(err, "error marshaling freshest CRL")
+		}
+		revocationList.ExtraExtensions = append(revocationList.ExtraExtensions, pkix.Extension{
+			Id: oidExtensionFreshestCRL, Value: b,
+		})
+	}
+
+	certificateRevocationList, err := g.cas.CreateCRL(&casapi.CreateCRLRequest{RevocationList: &revocationList})
+	if err != nil {
+		return errors.Wrap(err, "could not create CRL")
+	}
+
+	// Create a new db.CertificateRevocationListInfo, which stores the new Number we just generated, the
+	// expiry time, duration, and the DER-encoded CRL
+	newCRLInfo := db.CertificateRevocationListInfo{
+		Number:    bn.Int64(),
+		ExpiresAt: revocationList.NextUpdate,
+		DER:       certificateRevocationList.CRL,
+		Duration:  updateDuration,
+	}
+
+	// Store the CRL in the database ready for retrieval by api endpoints
+	name := scope.name()
+	if isDelta {
+		name = scope.deltaName()
+	}
+	return g.store(name, &newCRLInfo)
+}
+
+// crlDistributionPoint is the DistributionPoint defined in RFC 5280, section
+// 4.2.1.13.
+type crlDistributionPoint struct {
+	DistributionPoint distributionPointName `asn1:"optional,tag:0"`
+	Reason            asn1.BitString        `asn1:"optional,tag:1"`
+	CRLIssuer         asn1.RawValue         `asn1:"optional,tag:2"`
+}
+
+// marshalCRLDistributionPoints marshals the CRLDistributionPoints syntax used
+// in the freshest CRL extension.
+func marshalCRLDistributionPoints(fullName string) ([]byte, error) {
+	return asn1.Marshal([]crlDistributionPoint{{
+		DistributionPoint: distributionPointName{
+			FullName: []asn1.RawValue{
+				{Class: 2, Tag: 6, Bytes: []byte(fullName)},
+			},
+		},
+	}})
+}
+
+// withCRLDistributionPoints returns a certificate enforcer that points the
+// certificate to its partitioned CRL and adds the freshest CRL extension
+// pointing to the delta CRL. If the certificate does not have a serial
+// number, a new one is generated for the partition.
+func (a *Authority) withCRLDistributionPoints() provisioner.CertificateEnforcerFunc {
+	return func(cert *x509.Certificate) error {
+		scope := completeCRLScope
+		if a.config.CRL.IsPartitioned() {
+			if cert.SerialNumber == nil {
+				sn, err := newSerialNumber()
+				if err != nil {
+					return err
+				}
+				cert.SerialNumber = sn
+			}
+			scope = crlScope{
+				index:      crlPartitionIndex(cert.SerialNumber, a.config.CRL.Partitions),
+				partitions: a.config.CRL.Partitions,
+			}
+		}
+
+		if len(cert.CRLDistributionPoints) == 0 {
+			cert.CRLDistributionPoints = []string{a.crlURL(scope.name())}
+		}
+
+		if a.config.CRL.IsDeltaEnabled() {
+			for _, ext := range cert.ExtraExtensions {
+				if ext.Id.Equal(oidExtensionFreshestCRL) {
+					return nil
+				}
+			}
+			b, err := marshalCRLDistributionPoints(a.crlURL(scope.deltaName()))
+			if err != nil {
+				return errors.Wrap(err, "error marshaling freshest CRL")
+			}
+			cert.ExtraExtensions = append(cert.ExtraExtensions, pkix.Extension{
+				Id: oidExtensionFreshestCRL, Value: b,
+			})
+		}
+
+		return nil
+	}
+}
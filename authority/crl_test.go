@@ -0,0 +1,228 @@
+package authority
+
+import (
+	"crypto/x509"
+	"encoding/asn1"
+	"math/big"
+	"net/http"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/smallstep/certificates/authority/config"
+	"github.com/smallstep/certificates/authority/provisioner"
+	"github.com/smallstep/certificates/db"
+	"github.com/smallstep/certificates/errs"
+	"github.com/smallstep/nosql/database"
+)
+
+func Test_newPartitionedSerialNumber(t *testing.T) {
+	for i := 0; i < 16; i++ {
+		sn, err := newPartitionedSerialNumber(16, i)
+		require.NoError(t, err)
+		assert.Equal(t, i, crlPartitionIndex(sn, 16))
+		assert.Equal(t, 1, sn.Sign())
+	}
+}
+
+func TestAuthority_crlScopeByName(t *testing.T) {
+	a := testAuthority(t)
+	a.config.CRL = &config.CRLConfig{
+		Enabled:    true,
+		Partitions: 4,
+		Delta:      &config.DeltaCRLConfig{Enabled: true},
+	}
+
+	tests := []struct {
+		name      string
+		wantScope crlScope
+		wantDelta bool
+		wantOK    bool
+	}{
+		{"", completeCRLScope, false, true},
+		{"delta", completeCRLScope, true, true},
+		{"0", crlScope{index: 0, partitions: 4}, false, true},
+		{"3-delta", crlScope{index: 3, partitions: 4}, true, true},
+		{"4", crlScope{}, false, false},
+		{"-1", crlScope{}, false, false},
+		{"01", crlScope{}, false, false},
+		{"foo", crlScope{}, false, false},
+		{"foo-delta", crlScope{}, false, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			scope, isDelta, ok := a.crlScopeByName(tt.name)
+			assert.Equal(t, tt.wantScope, scope)
+			assert.Equal(t, tt.wantDelta, isDelta)
+			assert.Equal(t, tt.wantOK, ok)
+		})
+	}
+}
+
+func TestAuthority_GenerateCertificateRevocationList_partitionedAndDelta(t *testing.T) {
+	var mu sync.Mutex
+	var complete *db.CertificateRevocationListInfo
+	named := map[string]*db.CertificateRevocationListInfo{}
+
+	revokedAt := time.Now().Add(-time.Hour).UTC()
+	var revokedList []db.RevokedCertificateInfo
+	for i := 0; i < 8; i++ {
+		revokedList = append(revokedList, db.RevokedCertificateInfo{
+			Serial:    strconv.Itoa(i),
+			RevokedAt: revokedAt,
+		})
+	}
+
+	a := testAuthority(t, WithDatabase(&db.MockAuthDB{
+		MGetRevokedCertificates: func() (*[]db.RevokedCertificateInfo, error) {
+			return &revokedList, nil
+		},
+		MGetCRL: func() (*db.CertificateRevocationListInfo, error) {
+			mu.Lock()
+			defer mu.Unlock()
+			if complete == nil {
+				return nil, database.ErrNotFound
+			}
+			return complete, nil
+		},
+		MStoreCRL: func(info *db.CertificateRevocationListInfo) error {
+			mu.Lock()
+			defer mu.Unlock()
+			complete = info
+			return nil
+		},
+		MGetCRLByName: func(name string) (*db.CertificateRevocationListInfo, error) {
+			mu.Lock()
+			defer mu.Unlock()
+			if info, ok := named[name]; ok {
+				return info, nil
+			}
+			return nil, database.ErrNotFound
+		},
+		MStoreCRLByName: func(name string, info *db.CertificateRevocationListInfo) error {
+			mu.Lock()
+			defer mu.Unlock()
+			named[name] = info
+			return nil
+		},
+	}))
+	a.config.CRL = &config.CRLConfig{
+		Enabled:       true,
+		CacheDuration: &provisioner.Duration{Duration: 24 * time.Hour},
+		IDPurl:        "https://ca.example.com/1.0/crl",
+		Partitions:    4,
+		Delta: &config.DeltaCRLConfig{
+			Enabled:       true,
+			CacheDuration: &provisioner.Duration{Duration: time.Hour},
+		},
+	}
+
+	parse := func(t *testing.T, name string) *x509.RevocationList {
+		t.Helper()
+		info, err := a.GetCertificateRevocationListByName(name)
+		require.NoError(t, err)
+		crl, err := x509.ParseRevocationList(info.Data)
+		require.NoError(t, err)
+		return crl
+	}
+	serials := func(crl *x509.RevocationList) []string {
+		var ret []string
+		for _, rc := range crl.RevokedCertificateEntries {
+			ret = append(ret, rc.SerialNumber.String())
+		}
+		return ret
+	}
+	hasExtension := func(crl *x509.RevocationList, oid asn1.ObjectIdentifier) bool {
+		for _, ext := range crl.Extensions {
+			if ext.Id.Equal(oid) {
+				return true
+			}
+		}
+		return false
+	}
+
+	// The delta CRLs require the base CRLs.
+	require.Error(t, a.GenerateDeltaCertificateRevocationLists())
+
+	require.NoError(t, a.GenerateCertificateRevocationList())
+
+	crl := parse(t, "")
+	assert.Equal(t, []string{"0", "1", "2", "3", "4", "5", "6", "7"}, serials(crl))
+	assert.True(t, hasExtension(crl, oidExtensionFreshestCRL))
+
+	crl = parse(t, "1")
+	assert.Equal(t, []string{"1", "5"}, serials(crl))
+	assert.True(t, hasExtension(crl, oidExtensionFreshestCRL))
+	assert.False(t, hasExtension(crl, oidExtensionDeltaCRLIndicator))
+	baseNumber := crl.Number
+
+	// A delta CRL is generated with the base CRL, and it is empty.
+	crl = parse(t, "1-delta")
+	assert.Empty(t, serials(crl))
+	assert.True(t, hasExtension(crl, oidExtensionDeltaCRLIndicator))
+	assert.Equal(t, 1, crl.Number.Cmp(baseNumber))
+
+	// New revocations are added to the delta CRLs.
+	revokedList = append(revokedList, db.RevokedCertificateInfo{
+		Serial:    "9",
+		RevokedAt: time.Now().UTC(),
+	})
+	require.NoError(t, a.GenerateDeltaCertificateRevocationLists())
+
+	crl = parse(t, "1-delta")
+	assert.Equal(t, []string{"9"}, serials(crl))
+	for _, ext := range crl.Extensions {
+		if ext.Id.Equal(oidExtensionDeltaCRLIndicator) {
+			var n *big.Int
+			_, err := asn1.Unmarshal(ext.Value, &n)
+			require.NoError(t, err)
+			assert.Equal(t, baseNumber, n)
+			assert.True(t, ext.Critical)
+		}
+	}
+	assert.Empty(t, serials(parse(t, "0-delta")))
+	assert.Equal(t, []string{"9"}, serials(parse(t, "delta")))
+	assert.Equal(t, []string{"1", "5"}, serials(parse(t, "1")))
+
+	// Unknown CRLs.
+	for _, name := range []string{"4", "foo", "foo-delta"} {
+		_, err := a.GetCertificateRevocationListByName(name)
+		var ee *errs.Error
+		require.ErrorAs(t, err, &ee)
+		assert.Equal(t, http.StatusNotFound, ee.StatusCode())
+	}
+}
+
+func TestAuthority_withCRLDistributionPoints(t *testing.T) {
+	a := testAuthority(t)
+	a.config.CRL = &config.CRLConfig{
+		Enabled:    true,
+		IDPurl:     "https://ca.example.com/1.0/crl",
+		Partitions: 4,
+		Delta:      &config.DeltaCRLConfig{Enabled: true},
+	}
+
+	cert := &x509.Certificate{SerialNumber: big.NewInt(6)}
+	require.NoError(t, a.withCRLDistributionPoints().Enforce(cert))
+	assert.Equal(t, []string{"https://ca.example.com/1.0/crl/2"}, cert.CRLDistributionPoints)
+	require.Len(t, cert.ExtraExtensions, 1)
+	assert.Equal(t, oidExtensionFreshestCRL, cert.ExtraExtensions[0].Id)
+	b, err := marshalCRLDistributionPoints("https://ca.example.com/1.0/crl/2-delta")
+	require.NoError(t, err)
+	assert.Equal(t, b, cert.ExtraExtensions[0].Value)
+
+	// A serial number is generated if necessary.
+	cert = &x509.Certificate{}
+	require.NoError(t, a.withCRLDistributionPoints().Enforce(cert))
+	require.NotNil(t, cert.SerialNumber)
+	assert.Equal(t, []string{"https://ca.example.com/1.0/crl/" + strconv.Itoa(crlPartitionIndex(cert.SerialNumber, 4))}, cert.CRLDistributionPoints)
+
+	// Distribution points in the template are kept.
+	cert = &x509.Certificate{SerialNumber: big.NewInt(6), CRLDistributionPoints: []string{"https://crl.example.com"}}
+	require.NoError(t, a.withCRLDistributionPoints().Enforce(cert))
+	assert.Equal(t, []string{"https://crl.example.com"}, cert.CRLDistributionPoints)
+}
@@ -0,0 +1,416 @@
+package authority
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"strings"
+
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/cryptobyte"
+
+	"github.com/smallstep/certificates/authority/config"
+	casapi "github.com/smallstep/certificates/cas/apiv1"
+)
+
+var (
+	// oidExtensionCTPoison is the precertificate poison extension defined in
+	// RFC 6962, section 3.1.
+	oidExtensionCTPoison = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 11129, 2, 4, 3}
+	// oidExtensionCTSCTList is the embedded SCT list extension defined in RFC
+	// 6962, section 3.3.
+	oidExtensionCTSCTList = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 11129, 2, 4, 2}
+)
+
+// maxCTResponseSize is the maximum size of the response of a CT log.
+const maxCTResponseSize = 64 * 1024
+
+// signedCertificateTimestamp is the JSON representation of an SCT returned by
+// the add-pre-chain endpoint of an RFC 6962 log.
+type signedCertificateTimestamp struct {
+	SCTVersion uint8  `json:"sct_version"`
+	ID         []byte `json:"id"`
+	Timestamp  uint64 `json:"timestamp"`
+	Extensions string `json:"extensions"`
+	Signature  []byte `json:"signature"`
+}
+
+// ctLog contains the configuration and the parsed key of a CT log.
+type ctLog struct {
+	config.CTLog
+	id  [sha256.Size]byte
+	key crypto.PublicKey
+}
+
+// ctResult is the result of the submission of a precertificate to a log.
+type ctResult struct {
+	log *ctLog
+	sct []byte
+	err error
+}
+
+// createCertificateWithSCTs issues a precertificate with the given request,
+// submits it to the configured Certificate Transparency logs and, once the
+// quorum of SCTs has been reached, issues the final certificate with the SCTs
+// embedded.
+func (a *Authority) createCertificateWithSCTs(ctx context.Context, req *casapi.CreateCertificateRequest) (*casapi.CreateCertificateResponse, error) {
+	leaf := req.Template
+
+	// The precertificate and the certificate must have the same serial.
+	if leaf.SerialNumber == nil {
+		sn, err := newSerialNumber()
+		if err != nil {
+			return nil, err
+		}
+		leaf.SerialNumber = sn
+	}
+
+	precert := *leaf
+	precert.ExtraExtensions = append(append([]pkix.Extension{}, leaf.ExtraExtensions...), pkix.Extension{
+		Id:       oidExtensionCTPoison,
+		Critical: true,
+		Value:    asn1.NullBytes,
+	})
+	precertReq := *req
+	precertReq.Template = &precert
+	resp, err := a.x509CAService.CreateCertificate(&precertReq)
+	if err != nil {
+		return nil, errors.Wrap(err, "error creating precertificate")
+	}
+	if len(resp.CertificateChain) == 0 {
+		return nil, errors.New("error creating precertificate: certificate chain is empty")
+	}
+	if resp.Certificate.SerialNumber.Cmp(leaf.SerialNumber) != 0 {
+		return nil, errors.New("error creating precertificate: certificate authority does not support custom serial numbers")
+	}
+
+	// Use the same values in the final certificate.
+	pre := resp.Certificate
+	leaf.NotBefore = pre.NotBefore
+	leaf.NotAfter = pre.NotAfter
+	leaf.SubjectKeyId = pre.SubjectKeyId
+
+	scts, err := a.submitPrecertificate(ctx, pre, resp.CertificateChain)
+	if err != nil {
+		return nil, err
+	}
+
+	ext, err := marshalSCTList(scts)
+	if err != nil {
+		return nil, err
+	}
+	leaf.ExtraExtensions = append(leaf.ExtraExtensions, ext)
+
+	resp, err = a.x509CAService.CreateCertificate(req)
+	if err != nil {
+		return nil, err
+	}
+
+	// Make sure that the SCTs are valid for the final certificate.
+	precertTBS, err := removeExtension(pre.RawTBSCertificate, oidExtensionCTPoison)
+	if err != nil {
+		return nil, err
+	}
+	tbs, err := removeExtension(resp.Certificate.RawTBSCertificate, oidExtensionCTSCTList)
+	if err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(tbs, precertTBS) {
+		return nil, errors.New("error creating certificate: certificate does not match the precertificate")
+	}
+
+	return resp, nil
+}
+
+// submitPrecertificate submits the precertificate to all the configured logs,
+// and returns the SCTs once the quorum is reached.
+func (a *Authority) submitPrecertificate(ctx context.Context, precert *x509.Certificate, chain []*x509.Certificate) ([][]byte, error) {
+	cfg := a.config.CT
+	logs, err := parseCTLogs(cfg.Logs)
+	if err != nil {
+		return nil, err
+	}
+
+	quorum := cfg.Quorum
+	if quorum <= 0 {
+		quorum = config.DefaultCTQuorum
+	}
+	timeout := config.DefaultCTTimeout.Duration
+	if cfg.Timeout != nil && cfg.Timeout.Duration > 0 {
+		timeout = cfg.Timeout.Duration
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	// The issuer key hash is the hash of the key of the certificate that
+	// signed the precertificate.
+	issuerKeyHash := sha256.Sum256(chain[0].RawSubjectPublicKeyInfo)
+	tbs, err := removeExtension(precert.RawTBSCertificate, oidExtensionCTPoison)
+	if err != nil {
+		return nil, err
+	}
+
+	body, err := json.Marshal(struct {
+		Chain [][]byte `json:"chain"`
+	}{Chain: a.ctChain(precert, chain)})
+	if err != nil {
+		return nil, errors.Wrap(err, "error marshaling add-pre-chain request")
+	}
+
+	results := make(chan ctResult, len(logs))
+	for _, l := range logs {
+		go func(l *ctLog) {
+			sct, err := a.addPreChain(ctx, l, body, issuerKeyHash, tbs)
+			results <- ctResult{log: l, sct: sct, err: err}
+		}(l)
+	}
+
+	var (
+		scts     [][]byte
+		failures []string
+	)
+	for range logs {
+		r := <-results
+		if r.err != nil {
+			failures = append(failures, fmt.Sprintf("%s: %v", r.log.URL, r.err))
+			continue
+		}
+		if scts = append(scts, r.sct); len(scts) == quorum {
+			return scts, nil
+		}
+	}
+
+	return nil, errors.Errorf("error submitting precertificate: got %d of %d required SCTs: %s",
+		len(scts), quorum, strings.Join(failures, "; "))
+}
+
+// ctChain returns the chain sent to the logs, the precertificate followed by
+// its issuers, ending with the root if it is known.
+func (a *Authority) ctChain(precert *x509.Certificate, chain []*x509.Certificate) [][]byte {
+	certs := [][]byte{precert.Raw}
+	for _, crt := range chain {
+		certs = append(certs, crt.Raw)
+	}
+	last := chain[len(chain)-1]
+	if !bytes.Equal(last.RawIssuer, last.RawSubject) {
+		for _, root := range a.rootX509Certs {
+			if bytes.Equal(last.RawIssuer, root.RawSubject) {
+				certs = append(certs, root.Raw)
+				break
+			}
+		}
+	}
+	return certs
+}
+
+// addPreChain submits a precertificate to a log and returns the verified SCT
+// in its TLS encoding.
+func (a *Authority) addPreChain(ctx context.Context, l *ctLog, body []byte, issuerKeyHash [sha256.Size]byte, tbs []byte) ([]byte, error) {
+	u := strings.TrimSuffix(l.URL, "/") + "/ct/v1/add-pre-chain"
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, u, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	client := a.httpClient
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	b, err := io.ReadAll(io.LimitReader(resp.Body, maxCTResponseSize))
+	if err != nil {
+		return nil, errors.Wrap(err, "error reading response")
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, errors.Errorf("log responded with status code %d", resp.StatusCode)
+	}
+
+	var sct signedCertificateTimestamp
+	if err := json.Unmarshal(b, &sct); err != nil {
+		return nil, errors.Wrap(err, "error unmarshaling response")
+	}
+	ext, err := base64.StdEncoding.DecodeString(sct.Extensions)
+	if err != nil {
+		return nil, errors.Wrap(err, "error decoding SCT extensions")
+	}
+	if err := verifySCT(l, &sct, ext, issuerKeyHash, tbs); err != nil {
+		return nil, err
+	}
+
+	// Serialize the SCT, RFC 6962, section 3.2.
+	var bb cryptobyte.Builder
+	bb.AddUint8(sct.SCTVersion)
+	bb.AddBytes(sct.ID)
+	bb.AddUint64(sct.Timestamp)
+	bb.AddUint16LengthPrefixed(func(b *cryptobyte.Builder) {
+		b.AddBytes(ext)
+	})
+	bb.AddBytes(sct.Signature)
+	return bb.Bytes()
+}
+
+// verifySCT verifies that the SCT has been signed by the given log for the
+// precertificate with the given TBS certificate.
+func verifySCT(l *ctLog, sct *signedCertificateTimestamp, ext []byte, issuerKeyHash [sha256.Size]byte, tbs []byte) error {
+	if sct.SCTVersion != 0 {
+		return errors.Errorf("unsupported SCT version %d", sct.SCTVersion)
+	}
+	if !bytes.Equal(sct.ID, l.id[:]) {
+		return errors.New("SCT log id does not match the log key")
+	}
+
+	// Parse the DigitallySigned struct, RFC 5246, section 4.7.
+	var (
+		hashAlg, sigAlg uint8
+		sig             cryptobyte.String
+	)
+	s := cryptobyte.String(sct.Signature)
+	if !s.ReadUint8(&hashAlg) || !s.ReadUint8(&sigAlg) ||
+		!s.ReadUint16LengthPrefixed(&sig) || !s.Empty() {
+		return errors.New("error parsing SCT signature")
+	}
+	if hashAlg != 4 { // sha256
+		return errors.Errorf("unsupported SCT hash algorithm %d", hashAlg)
+	}
+
+	// Build the signed data, RFC 6962, section 3.2.
+	var b cryptobyte.Builder
+	b.AddUint8(sct.SCTVersion)
+	b.AddUint8(0) // certificate_timestamp
+	b.AddUint64(sct.Timestamp)
+	b.AddUint16(1) // precert_entry
+	b.AddBytes(issuerKeyHash[:])
+	b.AddUint24LengthPrefixed(func(b *cryptobyte.Builder) {
+		b.AddBytes(tbs)
+	})
+	b.AddUint16LengthPrefixed(func(b *cryptobyte.Builder) {
+		b.AddBytes(ext)
+	})
+	data, err := b.Bytes()
+	if err != nil {
+		return errors.Wrap(err, "error marshaling SCT signed data")
+	}
+	digest := sha256.Sum256(data)
+
+	switch key := l.key.(type) {
+	case *ecdsa.PublicKey:
+		if sigAlg != 3 || !ecdsa.VerifyASN1(key, digest[:], sig) {
+			return errors.New("error verifying SCT signature")
+		}
+	case *rsa.PublicKey:
+		if sigAlg != 1 || rsa.VerifyPKCS1v15(key, crypto.SHA256, digest[:], sig) != nil {
+			return errors.New("error verifying SCT signature")
+		}
+	default:
+		return errors.Errorf("unsupported log key type %T", key)
+	}
+
+	return nil
+}
+
+// parseCTLogs parses the configured logs.
+func parseCTLogs(logs []config.CTLog) ([]*ctLog, error) {
+	ret := make([]*ctLog, len(logs))
+	for i, l := range logs {
+		der, err := l.PublicKey()
+		if err != nil {
+			return nil, err
+		}
+		key, err := x509.ParsePKIXPublicKey(der)
+		if err != nil {
+			return nil, errors.Wrapf(err, "error parsing ct log %q key", l.URL)
+		}
+		ret[i] = &ctLog{
+			CTLog: l,
+			id:    sha256.Sum256(der),
+			key:   key,
+		}
+	}
+	return ret, nil
+}
+
+// marshalSCTList returns the SCT list extension with the given serialized
+// SCTs.
+func marshalSCTList(scts [][]byte) (pkix.Extension, error) {
+	var b cryptobyte.Builder
+	b.AddUint16LengthPrefixed(func(b *cryptobyte.Builder) {
+		for _, sct := range scts {
+			b.AddUint16LengthPrefixed(func(b *cryptobyte.Builder) {
+				b.AddBytes(sct)
+			})
+		}
+	})
+	list, err := b.Bytes()
+	if err != nil {
+		return pkix.Extension{}, errors.Wrap(err, "error marshaling SCT list")
+	}
+	value, err := asn1.Marshal(list)
+	if err != nil {
+		return pkix.Extension{}, errors.Wrap(err, "error marshaling SCT list")
+	}
+	return pkix.Extension{
+		Id:    oidExtensionCTSCTList,
+		Value: value,
+	}, nil
+}
+
+// tbsCertificate is the ASN.1 structure of a TBSCertificate used to remove an
+// extension from a certificate.
+type tbsCertificate struct {
+	Raw                asn1.RawContent
+	Version            int `asn1:"optional,explicit,default:0,tag:0"`
+	SerialNumber       *big.Int
+	SignatureAlgorithm pkix.AlgorithmIdentifier
+	Issuer             asn1.RawValue
+	Validity           asn1.RawValue
+	Subject            asn1.RawValue
+	PublicKey          asn1.RawValue
+	UniqueID           asn1.BitString   `asn1:"optional,tag:1"`
+	SubjectUniqueID    asn1.BitString   `asn1:"optional,tag:2"`
+	Extensions         []pkix.Extension `asn1:"omitempty,optional,explicit,tag:3"`
+}
+
+// removeExtension returns the given DER TBSCertificate without the extension
+// with the given id.
+func removeExtension(der []byte, id asn1.ObjectIdentifier) ([]byte, error) {
+	var tbs tbsCertificate
+	if rest, err := asn1.Unmarshal(der, &tbs); err != nil {
+		return nil, errors.Wrap(err, "error parsing certificate")
+	} else if len(rest) > 0 {
+		return nil, errors.New("error parsing certificate: trailing data")
+	}
+
+	exts := tbs.Extensions[:0]
+	for _, ext := range tbs.Extensions {
+		if !ext.Id.Equal(id) {
+			exts = append(exts, ext)
+		}
+	}
+	tbs.Raw = nil
+	tbs.Extensions = exts
+
+	b, err := asn1.Marshal(tbs)
+	if err != nil {
+		return nil, errors.Wrap(err, "error marshaling certificate")
+	}
+	return b, nil
+}
@@ -0,0 +1,355 @@
+package authority
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.step.sm/crypto/jose"
+	"go.step.sm/crypto/keyutil"
+	"golang.org/x/crypto/cryptobyte"
+
+	"github.com/smallstep/certificates/authority/config"
+	"github.com/smallstep/certificates/authority/provisioner"
+)
+
+// fakeCTLog is an RFC 6962 log that only implements the add-pre-chain
+// endpoint.
+type fakeCTLog struct {
+	*httptest.Server
+	signer   crypto.Signer
+	status   int
+	delay    time.Duration
+	badSig   bool
+	requests atomic.Int32
+}
+
+func newFakeCTLog(t *testing.T) *fakeCTLog {
+	t.Helper()
+	signer, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	l := &fakeCTLog{signer: signer, status: http.StatusOK}
+	l.Server = httptest.NewServer(http.HandlerFunc(l.addPreChain))
+	t.Cleanup(l.Close)
+	return l
+}
+
+func (l *fakeCTLog) config(t *testing.T) config.CTLog {
+	t.Helper()
+	der, err := x509.MarshalPKIXPublicKey(l.signer.Public())
+	require.NoError(t, err)
+	return config.CTLog{
+		URL: l.URL,
+		Key: base64.StdEncoding.EncodeToString(der),
+	}
+}
+
+func (l *fakeCTLog) addPreChain(w http.ResponseWriter, r *http.Request) {
+	l.requests.Add(1)
+	if r.Method != http.MethodPost || r.URL.Path != "/ct/v1/add-pre-chain" {
+		http.NotFound(w, r)
+		return
+	}
+	if l.delay > 0 {
+		select {
+		case <-time.After(l.delay):
+		case <-r.Context().Done():
+			return
+		}
+	}
+	if l.status != http.StatusOK {
+		w.WriteHeader(l.status)
+		return
+	}
+
+	var req struct {
+		Chain [][]byte `json:"chain"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || len(req.Chain) < 2 {
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+	precert, err := x509.ParseCertificate(req.Chain[0])
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	issuer, err := x509.ParseCertificate(req.Chain[1])
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if len(precert.UnhandledCriticalExtensions) != 1 || !precert.UnhandledCriticalExtensions[0].Equal(oidExtensionCTPoison) {
+		http.Error(w, "certificate is not a precertificate", http.StatusBadRequest)
+		return
+	}
+	tbs, err := removeExtension(precert.RawTBSCertificate, oidExtensionCTPoison)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	der, _ := x509.MarshalPKIXPublicKey(l.signer.Public())
+	id := sha256.Sum256(der)
+	timestamp := uint64(time.Now().UnixMilli())
+	issuerKeyHash := sha256.Sum256(issuer.RawSubjectPublicKeyInfo)
+
+	var b cryptobyte.Builder
+	b.AddUint8(0)
+	b.AddUint8(0)
+	b.AddUint64(timestamp)
+	b.AddUint16(1)
+	b.AddBytes(issuerKeyHash[:])
+	b.AddUint24LengthPrefixed(func(b *cryptobyte.Builder) {
+		b.AddBytes(tbs)
+	})
+	b.AddUint16(0)
+	data := b.BytesOrPanic()
+	if l.badSig {
+		data = append(data, 0)
+	}
+	digest := sha256.Sum256(data)
+	sig, err := l.signer.Sign(rand.Reader, digest[:], crypto.SHA256)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	var ds cryptobyte.Builder
+	ds.AddUint8(4)
+	ds.AddUint8(3)
+	ds.AddUint16LengthPrefixed(func(b *cryptobyte.Builder) {
+		b.AddBytes(sig)
+	})
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(signedCertificateTimestamp{
+		SCTVersion: 0,
+		ID:         id[:],
+		Timestamp:  timestamp,
+		Signature:  ds.BytesOrPanic(),
+	})
+}
+
+// parseSCTList returns the SCTs embedded in the given certificate.
+func parseSCTList(t *testing.T, crt *x509.Certificate) [][]byte {
+	t.Helper()
+	for _, ext := range crt.Extensions {
+		if !ext.Id.Equal(oidExtensionCTSCTList) {
+			continue
+		}
+		var list []byte
+		_, err := asn1.Unmarshal(ext.Value, &list)
+		require.NoError(t, err)
+
+		var scts [][]byte
+		s := cryptobyte.String(list)
+		var entries cryptobyte.String
+		require.True(t, s.ReadUint16LengthPrefixed(&entries))
+		require.True(t, s.Empty())
+		for !entries.Empty() {
+			var sct cryptobyte.String
+			require.True(t, entries.ReadUint16LengthPrefixed(&sct))
+			scts = append(scts, sct)
+		}
+		return scts
+	}
+	return nil
+}
+
+// verifySCTList verifies the given SCTs like a client would do, using the
+// final certificate.
+func verifySCTList(t *testing.T, a *Authority, crt, issuer *x509.Certificate, scts [][]byte) {
+	t.Helper()
+	tbs, err := removeExtension(crt.RawTBSCertificate, oidExtensionCTSCTList)
+	require.NoError(t, err)
+	issuerKeyHash := sha256.Sum256(issuer.RawSubjectPublicKeyInfo)
+	logs, err := parseCTLogs(a.config.CT.Logs)
+	require.NoError(t, err)
+	for _, b := range scts {
+		var (
+			version   uint8
+			id        []byte
+			timestamp uint64
+			ext, sig  cryptobyte.String
+		)
+		s := cryptobyte.String(b)
+		require.True(t, s.ReadUint8(&version))
+		require.True(t, s.ReadBytes(&id, sha256.Size))
+		require.True(t, s.ReadUint64(&timestamp))
+		require.True(t, s.ReadUint16LengthPrefixed(&ext))
+		sig = s
+
+		var verified bool
+		for _, l := range logs {
+			if string(l.id[:]) == string(id) {
+				require.NoError(t, verifySCT(l, &signedCertificateTimestamp{
+					SCTVersion: version,
+					ID:         id,
+					Timestamp:  timestamp,
+					Signature:  sig,
+				}, ext, issuerKeyHash, tbs))
+				verified = true
+			}
+		}
+		assert.True(t, verified)
+	}
+}
+
+func TestAuthority_SignWithContext_certificateTransparency(t *testing.T) {
+	_, priv, err := keyutil.GenerateDefaultKeyPair()
+	require.NoError(t, err)
+
+	key, err := jose.ReadKey("testdata/secrets/step_cli_key_priv.jwk", jose.WithPassword([]byte("pass")))
+	require.NoError(t, err)
+
+	newLogs := func(n int) []*fakeCTLog {
+		logs := make([]*fakeCTLog, n)
+		for i := range logs {
+			logs[i] = newFakeCTLog(t)
+		}
+		return logs
+	}
+
+	tests := []struct {
+		name     string
+		logs     []*fakeCTLog
+		quorum   int
+		timeout  time.Duration
+		enabled  bool
+		setup    func(logs []*fakeCTLog)
+		wantSCTs int
+		wantErr  bool
+	}{
+		{"ok", newLogs(2), 2, 0, true, nil, 2, false},
+		{"ok/default quorum", newLogs(1), 0, 0, true, nil, 1, false},
+		{"ok/quorum with failures", newLogs(3), 2, 0, true, func(logs []*fakeCTLog) {
+			logs[0].status = http.StatusServiceUnavailable
+		}, 2, false},
+		{"ok/not enabled", newLogs(1), 1, 0, false, nil, 0, false},
+		{"fail/quorum", newLogs(2), 2, 0, true, func(logs []*fakeCTLog) {
+			logs[1].status = http.StatusBadRequest
+		}, 0, true},
+		{"fail/bad signature", newLogs(1), 1, 0, true, func(logs []*fakeCTLog) {
+			logs[0].badSig = true
+		}, 0, true},
+		{"fail/timeout", newLogs(1), 1, 100 * time.Millisecond, true, func(logs []*fakeCTLog) {
+			logs[0].delay = time.Second
+		}, 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.setup != nil {
+				tt.setup(tt.logs)
+			}
+
+			a := testAuthority(t)
+			a.config.CT = &config.CTConfig{Quorum: tt.quorum}
+			if tt.timeout > 0 {
+				a.config.CT.Timeout = &provisioner.Duration{Duration: tt.timeout}
+			}
+			for _, l := range tt.logs {
+				a.config.CT.Logs = append(a.config.CT.Logs, l.config(t))
+			}
+
+			token, err := generateToken("smallstep test", "step-cli", testAudiences.Sign[0], []string{"test.smallstep.com"}, time.Now(), key)
+			require.NoError(t, err)
+			ctx := provisioner.NewContextWithMethod(context.Background(), provisioner.SignMethod)
+			extraOpts, err := a.Authorize(ctx, token)
+			require.NoError(t, err)
+			extraOpts = append(extraOpts, provisioner.CertificateTransparency(tt.enabled))
+
+			chain, err := a.SignWithContext(context.Background(), getCSR(t, priv), provisioner.SignOptions{}, extraOpts...)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			require.Len(t, chain, 2)
+
+			crt, issuer := chain[0], chain[1]
+			assert.Empty(t, crt.UnhandledCriticalExtensions)
+
+			scts := parseSCTList(t, crt)
+			require.Len(t, scts, tt.wantSCTs)
+			if !tt.enabled {
+				assert.Zero(t, tt.logs[0].requests.Load())
+				return
+			}
+
+			// Verify the SCTs like a client would do, using the final
+			// certificate.
+			verifySCTList(t, a, crt, issuer, scts)
+		})
+	}
+}
+
+func TestAuthority_RenewContext_certificateTransparency(t *testing.T) {
+	_, priv, err := keyutil.GenerateDefaultKeyPair()
+	require.NoError(t, err)
+	key, err := jose.ReadKey("testdata/secrets/step_cli_key_priv.jwk", jose.WithPassword([]byte("pass")))
+	require.NoError(t, err)
+
+	log := newFakeCTLog(t)
+	a := testAuthority(t)
+	a.config.CT = &config.CTConfig{Logs: []config.CTLog{log.config(t)}, Quorum: 1}
+
+	sign := func(submitCT bool) *x509.Certificate {
+		token, err := generateToken("smallstep test", "step-cli", testAudiences.Sign[0], []string{"test.smallstep.com"}, time.Now(), key)
+		require.NoError(t, err)
+		ctx := provisioner.NewContextWithMethod(context.Background(), provisioner.SignMethod)
+		extraOpts, err := a.Authorize(ctx, token)
+		require.NoError(t, err)
+		extraOpts = append(extraOpts, provisioner.CertificateTransparency(submitCT))
+		chain, err := a.SignWithContext(context.Background(), getCSR(t, priv), provisioner.SignOptions{}, extraOpts...)
+		require.NoError(t, err)
+		return chain[0]
+	}
+
+	// The renewed certificate is submitted to the logs, and it only contains
+	// the new SCTs.
+	oldCrt := sign(true)
+	require.Len(t, parseSCTList(t, oldCrt), 1)
+	require.Equal(t, int32(1), log.requests.Load())
+
+	ctx := provisioner.NewContextWithMethod(context.Background(), provisioner.RenewMethod)
+	chain, err := a.RenewContext(ctx, oldCrt, nil)
+	require.NoError(t, err)
+	require.Len(t, chain, 2)
+	crt := chain[0]
+	assert.Equal(t, int32(2), log.requests.Load())
+	assert.Empty(t, crt.UnhandledCriticalExtensions)
+	var count int
+	for _, ext := range crt.Extensions {
+		if ext.Id.Equal(oidExtensionCTSCTList) {
+			count++
+		}
+	}
+	assert.Equal(t, 1, count)
+	scts := parseSCTList(t, crt)
+	require.Len(t, scts, 1)
+	assert.NotEqual(t, parseSCTList(t, oldCrt), scts)
+	verifySCTList(t, a, crt, chain[1], scts)
+
+	// Certificates without SCTs are not submitted.
+	oldCrt = sign(false)
+	chain, err = a.RenewContext(ctx, oldCrt, nil)
+	require.NoError(t, err)
+	assert.Empty(t, parseSCTList(t, chain[0]))
+	assert.Equal(t, int32(2), log.requests.Load())
+}
@@ -0,0 +1,22 @@
+package authority
+
+import (
+	"github.com/smallstep/certificates/authority/admin"
+	"github.com/smallstep/certificates/db"
+)
+
+// SearchCertificates returns a page of the X.509 and SSH certificates issued
+// by the authority that satisfy the given filter. It requires a database that
+// implements db.CertificateInventoryDB.
+func (a *Authority) SearchCertificates(filter *db.CertificateFilter, cursor string, limit int) ([]*db.CertificateEntry, string, error) {
+	idb, ok := a.db.(db.CertificateInventoryDB)
+	if !ok {
+		return nil, "", admin.NewError(admin.ErrorNotImplementedType, "certificate inventory is not supported by the database")
+	}
+
+	certs, nextCursor, err := idb.SearchCertificates(filter, cursor, limit)
+	if err != nil {
+		return nil, "", admin.WrapErrorISE(err, "error searching certificates")
+	}
+	return certs, nextCursor, nil
+}
@@ -0,0 +1,47 @@
+package authority
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/smallstep/certificates/authority/admin"
+	"github.com/smallstep/certificates/db"
+)
+
+func TestAuthority_SearchCertificates(t *testing.T) {
+	certs := []*db.CertificateEntry{{ID: "x509/1"}}
+	filter := &db.CertificateFilter{SAN: "foo.example.com"}
+
+	a := testAuthority(t, WithDatabase(&db.MockAuthDB{
+		MSearchCertificates: func(f *db.CertificateFilter, cursor string, limit int) ([]*db.CertificateEntry, string, error) {
+			assert.Equal(t, filter, f)
+			assert.Equal(t, "x509/0", cursor)
+			assert.Equal(t, 10, limit)
+			return certs, "x509/1", nil
+		},
+	}))
+	got, next, err := a.SearchCertificates(filter, "x509/0", 10)
+	require.NoError(t, err)
+	assert.Equal(t, certs, got)
+	assert.Equal(t, "x509/1", next)
+
+	a = testAuthority(t, WithDatabase(&db.MockAuthDB{
+		MSearchCertificates: func(f *db.CertificateFilter, cursor string, limit int) ([]*db.CertificateEntry, string, error) {
+			return nil, "", errors.New("force")
+		},
+	}))
+	_, _, err = a.SearchCertificates(filter, "", 0)
+	var ae *admin.Error
+	require.ErrorAs(t, err, &ae)
+	assert.Equal(t, http.StatusInternalServerError, ae.StatusCode())
+
+	// The simple database does not support the inventory.
+	a = testAuthority(t, WithDatabase(&db.SimpleDB{}))
+	_, _, err = a.SearchCertificates(filter, "", 0)
+	require.ErrorAs(t, err, &ae)
+	assert.Equal(t, http.StatusNotImplemented, ae.StatusCode())
+}
@@ -0,0 +1,278 @@
+package authority
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/ocsp"
+
+	"go.step.sm/crypto/keyutil"
+	"go.step.sm/crypto/x509util"
+
+	"github.com/smallstep/certificates/authority/provisioner"
+	casapi "github.com/smallstep/certificates/cas/apiv1"
+	"github.com/smallstep/certificates/db"
+	"github.com/smallstep/certificates/errs"
+)
+
+// oidExtensionOCSPNoCheck is the id-pkix-ocsp-nocheck extension defined in RFC
+// 6960, section 4.2.2.2.1.
+var oidExtensionOCSPNoCheck = asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 7, 48, 1, 5}
+
+// OCSPResponseInfo contains a signed OCSP response in DER format and the
+// validity of the response.
+type OCSPResponseInfo struct {
+	ThisUpdate time.Time
+	NextUpdate time.Time
+	Data       []byte
+}
+
+// ocspResponder contains the certificate and signer used to sign OCSP
+// responses.
+type ocspResponder struct {
+	certificate *x509.Certificate
+	signer      crypto.Signer
+	renewAt     time.Time
+}
+
+// GetOCSPResponse returns a signed OCSP response for the given request. The
+// status of the certificate is read from the revocation table and the
+// certificates table in the database. Responses are kept in memory for the
+// configured cache duration, or until the certificate is revoked.
+func (a *Authority) GetOCSPResponse(req *ocsp.Request) (*OCSPResponseInfo, error) {
+	if !a.config.OCSP.IsEnabled() {
+		return nil, errs.Wrap(http.StatusNotFound, errors.New("OCSP responder is not enabled"), "authority.GetOCSPResponse")
+	}
+
+	if len(a.intermediateX509Certs) == 0 {
+		return nil, errs.Wrap(http.StatusNotImplemented, errors.New("OCSP responder requires an intermediate certificate"), "authority.GetOCSPResponse")
+	}
+
+	issuer := a.intermediateX509Certs[0]
+	if !isOCSPRequestForIssuer(req, issuer) {
+		return nil, errs.Wrap(http.StatusUnauthorized, errors.New("OCSP request is not for this authority"), "authority.GetOCSPResponse")
+	}
+
+	now := time.Now().Truncate(time.Second).UTC()
+	serial := req.SerialNumber.String()
+	cacheKey := ocspCacheKey(serial, req.HashAlgorithm)
+	if v, ok := a.ocspCache.Load(cacheKey); ok {
+		if info := v.(*OCSPResponseInfo); now.Before(info.NextUpdate) {
+			return info, nil
+		}
+		a.ocspCache.Delete(cacheKey)
+	}
+
+	var cacheDuration time.Duration
+	if d := a.config.OCSP.CacheDuration; d != nil {
+		cacheDuration = d.Duration
+	}
+
+	template := ocsp.Response{
+		SerialNumber: req.SerialNumber,
+		ThisUpdate:   now,
+		IssuerHash:   req.HashAlgorithm,
+	}
+	if cacheDuration > 0 {
+		template.NextUpdate = now.Add(cacheDuration)
+	}
+
+	if err := a.setOCSPStatus(&template, serial, issuer); err != nil {
+		return nil, errs.Wrap(http.StatusInternalServerError, err, "authority.GetOCSPResponse")
+	}
+
+	responder, err := a.getOCSPResponder(issuer)
+	if err != nil {
+		return nil, errs.Wrap(http.StatusInternalServerError, err, "authority.GetOCSPResponse")
+	}
+	if responder.certificate != issuer {
+		template.Certificate = responder.certificate
+	}
+
+	der, err := ocsp.CreateResponse(issuer, responder.certificate, template, responder.signer)
+	if err != nil {
+		return nil, errs.Wrap(http.StatusInternalServerError, err, "authority.GetOCSPResponse; error creating response")
+	}
+
+	info := &OCSPResponseInfo{
+		ThisUpdate: template.ThisUpdate,
+		NextUpdate: template.NextUpdate,
+		Data:       der,
+	}
+	if cacheDuration > 0 {
+		a.ocspCache.Store(cacheKey, info)
+	}
+
+	return info, nil
+}
+
+// ocspCacheKey returns the key of a cached OCSP response. The CertID in the
+// response uses the hash algorithm of the request, so responses are cached per
+// serial number and hash algorithm.
+func ocspCacheKey(serial string, hash crypto.Hash) string {
+	return serial + "/" + hash.String()
+}
+
+// deleteOCSPResponses removes the cached OCSP responses for the given serial
+// number.
+func (a *Authority) deleteOCSPResponses(serial string) {
+	prefix := serial + "/"
+	a.ocspCache.Range(func(key, _ any) bool {
+		if strings.HasPrefix(key.(string), prefix) {
+			a.ocspCache.Delete(key)
+		}
+		return true
+	})
+}
+
+// setOCSPStatus sets the status of the certificate with the given serial
+// number in the OCSP response template.
+func (a *Authority) setOCSPStatus(template *ocsp.Response, serial string, issuer *x509.Certificate) error {
+	isRevoked, err := a.IsRevoked(serial)
+	if err != nil {
+		return errors.Wrap(err, "error checking revocation status")
+	}
+
+	if isRevoked {
+		template.Status = ocsp.Revoked
+		template.RevokedAt = template.ThisUpdate
+		template.RevocationReason = ocsp.Unspecified
+		if rdb, ok := a.db.(interface {
+			GetRevokedCertificate(string) (*db.RevokedCertificateInfo, error)
+		}); ok {
+			if rci, err := rdb.GetRevokedCertificate(serial); err == nil && rci != nil {
+				template.RevokedAt = rci.RevokedAt
+				template.RevocationReason = rci.ReasonCode
+			}
+		}
+		return nil
+	}
+
+	// Only certificates issued by this authority are reported as good, any
+	// other serial number is unknown to the responder.
+	cert, err := a.db.GetCertificate(serial)
+	if err != nil || !bytes.Equal(cert.RawIssuer, issuer.RawSubject) {
+		template.Status = ocsp.Unknown
+		return nil
+	}
+
+	template.Status = ocsp.Good
+	return nil
+}
+
+// getOCSPResponder returns the certificate and signer used to sign the OCSP
+// responses. By default the intermediate key is used, but if a delegated
+// signer is configured, the authority will issue itself an OCSP signing
+// certificate and renew it after 2/3 of its lifetime.
+func (a *Authority) getOCSPResponder(issuer *x509.Certificate) (*ocspResponder, error) {
+	if !a.config.OCSP.DelegatedSigner {
+		signer, err := a.GetX509Signer()
+		if err != nil {
+			return nil, errors.Wrap(err, "error getting intermediate signer")
+		}
+		return &ocspResponder{
+			certificate: issuer,
+			signer:      signer,
+		}, nil
+	}
+
+	a.ocspMutex.Lock()
+	defer a.ocspMutex.Unlock()
+
+	if r := a.ocspResponder; r != nil && time.Now().Before(r.renewAt) &&
+		bytes.Equal(r.certificate.RawIssuer, issuer.RawSubject) {
+		return r, nil
+	}
+
+	signer, err := keyutil.GenerateDefaultSigner()
+	if err != nil {
+		return nil, errors.Wrap(err, "error generating OCSP signing key")
+	}
+
+	cr, err := x509util.CreateCertificateRequest(a.config.CommonName+" OCSP Responder", nil, signer)
+	if err != nil {
+		return nil, errors.Wrap(err, "error creating OCSP signing certificate request")
+	}
+
+	template, err := x509util.NewCertificate(cr)
+	if err != nil {
+		return nil, errors.Wrap(err, "error creating OCSP signing certificate template")
+	}
+
+	certTpl := template.GetCertificate()
+	certTpl.KeyUsage = x509.KeyUsageDigitalSignature
+	certTpl.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageOCSPSigning}
+	certTpl.ExtraExtensions = append(certTpl.ExtraExtensions, pkix.Extension{
+		Id:    oidExtensionOCSPNoCheck,
+		Value: asn1.NullBytes,
+	})
+
+	lifetime := provisioner.DefaultCertValidity
+	if d := a.config.OCSP.DelegatedSignerDuration; d != nil && d.Duration > 0 {
+		lifetime = d.Duration
+	}
+
+	resp, err := a.x509CAService.CreateCertificate(&casapi.CreateCertificateRequest{
+		Template: certTpl,
+		CSR:      cr,
+		Lifetime: lifetime,
+		Backdate: time.Minute,
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "error creating OCSP signing certificate")
+	}
+
+	crt := resp.Certificate
+	a.ocspResponder = &ocspResponder{
+		certificate: crt,
+		signer:      signer,
+		renewAt:     crt.NotBefore.Add(crt.NotAfter.Sub(crt.NotBefore) * 2 / 3),
+	}
+
+	return a.ocspResponder, nil
+}
+
+// isOCSPRequestForIssuer returns true if the issuer name and key hashes in the
+// OCSP request match the given issuer.
+func isOCSPRequestForIssuer(req *ocsp.Request, issuer *x509.Certificate) bool {
+	if !req.HashAlgorithm.Available() {
+		return false
+	}
+
+	var spki struct {
+		Algorithm pkix.AlgorithmIdentifier
+		PublicKey asn1.BitString
+	}
+	if _, err := asn1.Unmarshal(issuer.RawSubjectPublicKeyInfo, &spki); err != nil {
+		return false
+	}
+
+	h := req.HashAlgorithm.New()
+	h.Write(spki.PublicKey.RightAlign())
+	if !bytes.Equal(h.Sum(nil), req.IssuerKeyHash) {
+		return false
+	}
+
+	h.Reset()
+	h.Write(issuer.RawSubject)
+	return bytes.Equal(h.Sum(nil), req.IssuerNameHash)
+}
+
+// withOCSPServer returns a certificate enforcer that adds the OCSP responder
+// URL to the authority information access extension of a certificate, if the
+// certificate template did not set one.
+func withOCSPServer(u string) provisioner.CertificateEnforcerFunc {
+	return func(cert *x509.Certificate) error {
+		if len(cert.OCSPServer) == 0 {
+			cert.OCSPServer = []string{u}
+		}
+		return nil
+	}
+}
@@ -0,0 +1,208 @@
+package authority
+
+import (
+	"context"
+	"crypto"
+	"crypto/x509"
+	"errors"
+	"math/big"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/ocsp"
+
+	"go.step.sm/crypto/minica"
+
+	"github.com/smallstep/certificates/authority/config"
+	"github.com/smallstep/certificates/authority/provisioner"
+	"github.com/smallstep/certificates/db"
+	"github.com/smallstep/certificates/errs"
+)
+
+func TestAuthority_GetOCSPResponse(t *testing.T) {
+	revokedAt := time.Now().Add(-time.Hour).Truncate(time.Second).UTC()
+	mockDB := func() *db.MockAuthDB {
+		return &db.MockAuthDB{
+			MIsRevoked: func(sn string) (bool, error) {
+				switch sn {
+				case "2":
+					return true, nil
+				case "4":
+					return false, errors.New("force")
+				default:
+					return false, nil
+				}
+			},
+			MGetRevokedCertificate: func(sn string) (*db.RevokedCertificateInfo, error) {
+				return &db.RevokedCertificateInfo{
+					Serial:     sn,
+					ReasonCode: ocsp.KeyCompromise,
+					RevokedAt:  revokedAt,
+				}, nil
+			},
+			MGetCertificate: func(sn string) (*x509.Certificate, error) {
+				return nil, errors.New("not found")
+			},
+		}
+	}
+
+	a := testAuthority(t, WithDatabase(mockDB()))
+	issuer := a.intermediateX509Certs[0]
+	a.db.(*db.MockAuthDB).MGetCertificate = func(sn string) (*x509.Certificate, error) {
+		if sn == "1" {
+			return &x509.Certificate{SerialNumber: big.NewInt(1), RawIssuer: issuer.RawSubject}, nil
+		}
+		return nil, errors.New("not found")
+	}
+	a.config.OCSP = &config.OCSPConfig{
+		Enabled:       true,
+		CacheDuration: &provisioner.Duration{Duration: time.Hour},
+	}
+
+	delegated := testAuthority(t, WithDatabase(mockDB()))
+	delegated.config.OCSP = &config.OCSPConfig{
+		Enabled:                 true,
+		DelegatedSigner:         true,
+		DelegatedSignerDuration: &provisioner.Duration{Duration: 24 * time.Hour},
+	}
+
+	disabled := testAuthority(t, WithDatabase(mockDB()))
+
+	ca, err := minica.New()
+	require.NoError(t, err)
+
+	newRequest := func(t *testing.T, sn int64, issuer *x509.Certificate) *ocsp.Request {
+		t.Helper()
+		b, err := ocsp.CreateRequest(&x509.Certificate{SerialNumber: big.NewInt(sn)}, issuer, &ocsp.RequestOptions{
+			Hash: crypto.SHA256,
+		})
+		require.NoError(t, err)
+		req, err := ocsp.ParseRequest(b)
+		require.NoError(t, err)
+		return req
+	}
+
+	tests := []struct {
+		name       string
+		auth       *Authority
+		req        *ocsp.Request
+		wantStatus int
+		wantReason int
+		delegated  bool
+		statusCode int
+	}{
+		{"ok/good", a, newRequest(t, 1, issuer), ocsp.Good, 0, false, 0},
+		{"ok/revoked", a, newRequest(t, 2, issuer), ocsp.Revoked, ocsp.KeyCompromise, false, 0},
+		{"ok/unknown", a, newRequest(t, 3, issuer), ocsp.Unknown, 0, false, 0},
+		{"ok/delegated", delegated, newRequest(t, 2, issuer), ocsp.Revoked, ocsp.KeyCompromise, true, 0},
+		{"fail/disabled", disabled, newRequest(t, 1, issuer), 0, 0, false, http.StatusNotFound},
+		{"fail/issuer", a, newRequest(t, 1, ca.Intermediate), 0, 0, false, http.StatusUnauthorized},
+		{"fail/db", a, newRequest(t, 4, issuer), 0, 0, false, http.StatusInternalServerError},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.auth.GetOCSPResponse(tt.req)
+			if tt.statusCode != 0 {
+				var ee *errs.Error
+				require.ErrorAs(t, err, &ee)
+				assert.Equal(t, tt.statusCode, ee.StatusCode())
+				assert.Nil(t, got)
+				return
+			}
+			require.NoError(t, err)
+
+			resp, err := ocsp.ParseResponse(got.Data, issuer)
+			require.NoError(t, err)
+			assert.Equal(t, tt.wantStatus, resp.Status)
+			assert.Equal(t, tt.req.SerialNumber, resp.SerialNumber)
+			assert.Equal(t, got.ThisUpdate, resp.ThisUpdate)
+			assert.Equal(t, got.NextUpdate, resp.NextUpdate)
+			if tt.wantStatus == ocsp.Revoked {
+				assert.Equal(t, tt.wantReason, resp.RevocationReason)
+				assert.Equal(t, revokedAt, resp.RevokedAt)
+			}
+			if tt.delegated {
+				require.NotNil(t, resp.Certificate)
+				assert.Equal(t, []x509.ExtKeyUsage{x509.ExtKeyUsageOCSPSigning}, resp.Certificate.ExtKeyUsage)
+				assert.Equal(t, issuer.RawSubject, resp.Certificate.RawIssuer)
+			} else {
+				assert.Nil(t, resp.Certificate)
+			}
+		})
+	}
+}
+
+func TestAuthority_GetOCSPResponse_cache(t *testing.T) {
+	var revoked bool
+	a := testAuthority(t, WithDatabase(&db.MockAuthDB{
+		MIsRevoked: func(sn string) (bool, error) {
+			return revoked, nil
+		},
+		MGetCertificate: func(sn string) (*x509.Certificate, error) {
+			return nil, errors.New("not found")
+		},
+		MRevoke: func(rci *db.RevokedCertificateInfo) error {
+			revoked = true
+			return nil
+		},
+	}))
+	a.config.OCSP = &config.OCSPConfig{
+		Enabled:       true,
+		CacheDuration: &provisioner.Duration{Duration: time.Hour},
+	}
+	issuer := a.intermediateX509Certs[0]
+
+	b, err := ocsp.CreateRequest(&x509.Certificate{SerialNumber: big.NewInt(1)}, issuer, nil)
+	require.NoError(t, err)
+	req, err := ocsp.ParseRequest(b)
+	require.NoError(t, err)
+
+	first, err := a.GetOCSPResponse(req)
+	require.NoError(t, err)
+	second, err := a.GetOCSPResponse(req)
+	require.NoError(t, err)
+	assert.Same(t, first, second)
+
+	// Responses are cached per hash algorithm, as the CertID depends on it.
+	b, err = ocsp.CreateRequest(&x509.Certificate{SerialNumber: big.NewInt(1)}, issuer, &ocsp.RequestOptions{Hash: crypto.SHA256})
+	require.NoError(t, err)
+	sha256Req, err := ocsp.ParseRequest(b)
+	require.NoError(t, err)
+	sha256Resp, err := a.GetOCSPResponse(sha256Req)
+	require.NoError(t, err)
+	assert.NotSame(t, first, sha256Resp)
+	resp, err := ocsp.ParseResponseForCert(sha256Resp.Data, &x509.Certificate{SerialNumber: big.NewInt(1)}, issuer)
+	require.NoError(t, err)
+	assert.Equal(t, crypto.SHA256, resp.IssuerHash)
+
+	// Revocation removes the cached response.
+	ctx := provisioner.NewContextWithMethod(context.Background(), provisioner.RevokeMethod)
+	require.NoError(t, a.Revoke(ctx, &RevokeOptions{
+		Serial: "1",
+		MTLS:   true,
+		Crt:    &x509.Certificate{SerialNumber: big.NewInt(1), Raw: []byte("raw")},
+	}))
+	third, err := a.GetOCSPResponse(req)
+	require.NoError(t, err)
+	resp, err = ocsp.ParseResponse(third.Data, issuer)
+	require.NoError(t, err)
+	assert.Equal(t, ocsp.Revoked, resp.Status)
+	fourth, err := a.GetOCSPResponse(sha256Req)
+	require.NoError(t, err)
+	resp, err = ocsp.ParseResponse(fourth.Data, issuer)
+	require.NoError(t, err)
+	assert.Equal(t, ocsp.Revoked, resp.Status)
+}
+
+func Test_withOCSPServer(t *testing.T) {
+	cert := &x509.Certificate{}
+	require.NoError(t, withOCSPServer("http://ocsp.example.com").Enforce(cert))
+	assert.Equal(t, []string{"http://ocsp.example.com"}, cert.OCSPServer)
+
+	cert = &x509.Certificate{OCSPServer: []string{"http://other.example.com"}}
+	require.NoError(t, withOCSPServer("http://ocsp.example.com").Enforce(cert))
+	assert.Equal(t, []string{"http://other.example.com"}, cert.OCSPServer)
+}
@@ -6,11 +6,14 @@ import (
 	"encoding/pem"
 	"fmt"
 	"net"
+	"net/http"
 	"strings"
 	"time"
 
 	"github.com/pkg/errors"
 	"github.com/smallstep/certificates/acme/wire"
+	"github.com/smallstep/certificates/authority/policy"
+	"github.com/smallstep/certificates/errs"
 	"github.com/smallstep/linkedca"
 )
 
@@ -23,6 +26,8 @@ const (
 	HTTP_01 ACMEChallenge = "http-01"
 	// DNS_01 is the dns-01 ACME challenge.
 	DNS_01 ACMEChallenge = "dns-01"
+	// DNS_ACCOUNT_01 is the dns-account-01 ACME challenge.
+	DNS_ACCOUNT_01 ACMEChallenge = "dns-account-01"
 	// TLS_ALPN_01 is the tls-alpn-01 ACME challenge.
 	TLS_ALPN_01 ACMEChallenge = "tls-alpn-01"
 	// DEVICE_ATTEST_01 is the device-attest-01 ACME challenge.
@@ -31,6 +36,8 @@ const (
 	WIREOIDC_01 ACMEChallenge = "wire-oidc-01"
 	// WIREDPOP_01 is the Wire DPoP challenge.
 	WIREDPOP_01 ACMEChallenge = "wire-dpop-01"
+	// EMAIL_REPLY_00 is the email-reply-00 ACME challenge.
+	EMAIL_REPLY_00 ACMEChallenge = "email-reply-00"
 )
 
 // String returns a normalized version of the challenge.
@@ -41,7 +48,7 @@ func (c ACMEChallenge) String() string {
 // Validate returns an error if the acme challenge is not a valid one.
 func (c ACMEChallenge) Validate() error {
 	switch ACMEChallenge(c.String()) {
-	case HTTP_01, DNS_01, TLS_ALPN_01, DEVICE_ATTEST_01, WIREOIDC_01, WIREDPOP_01:
+	case HTTP_01, DNS_01, DNS_ACCOUNT_01, TLS_ALPN_01, DEVICE_ATTEST_01, WIREOIDC_01, WIREDPOP_01, EMAIL_REPLY_00:
 		return nil
 	default:
 		return fmt.Errorf("acme challenge %q is not supported", c)
@@ -64,6 +71,11 @@ const (
 
 	// TPM is the format used to enable device-attest-01 with TPMs.
 	TPM ACMEAttestationFormat = "tpm"
+
+	// ANDROID_KEY is the format used to enable device-attest-01 on Android
+	// devices using Android Key Attestation. It is not enabled by default
+	// and it requires the Google attestation roots in AttestationRoots.
+	ANDROID_KEY ACMEAttestationFormat = "android-key" //nolint:stylecheck,revive // better names
 )
 
 // String returns a normalized version of the attestation format.
@@ -74,13 +86,107 @@ func (f ACMEAttestationFormat) String() string {
 // Validate returns an error if the attestation format is not a valid one.
 func (f ACMEAttestationFormat) Validate() error {
 	switch ACMEAttestationFormat(f.String()) {
-	case APPLE, STEP, TPM:
+	case APPLE, STEP, TPM, ANDROID_KEY:
 		return nil
 	default:
 		return fmt.Errorf("acme attestation format %q is not supported", f)
 	}
 }
 
+// ACMEProfile is a named certificate profile that ACME clients can select when
+// creating a new order. Each profile can define its own template, claims and
+// the identifiers it's allowed to issue certificates for. Claims and options
+// not set in the profile are inherited from the provisioner.
+type ACMEProfile struct {
+	// Name is the name of the profile used by clients in the newOrder
+	// request.
+	Name string `json:"name"`
+	// Description is a human readable description of the profile, it's
+	// advertised in the ACME directory.
+	Description string `json:"description,omitempty"`
+	// Identifiers contains the identifier types allowed in orders using
+	// this profile. If this value is not set all the types supported by the
+	// provisioner will be allowed.
+	Identifiers []ACMEIdentifierType `json:"identifiers,omitempty"`
+	// Policy contains the names allowed and denied in certificates issued
+	// using this profile.
+	Policy  *policy.X509PolicyOptions `json:"policy,omitempty"`
+	Claims  *Claims                   `json:"claims,omitempty"`
+	Options *Options                  `json:"options,omitempty"`
+	options *Options
+	ctl     *Controller
+}
+
+// GetOptions returns the options of the profile, including the ones inherited
+// from the provisioner.
+func (p *ACMEProfile) GetOptions() *Options {
+	return p.options
+}
+
+// DefaultTLSCertDuration returns the default TLS cert duration enforced by the
+// profile.
+func (p *ACMEProfile) DefaultTLSCertDuration() time.Duration {
+	return p.ctl.Claimer.DefaultTLSCertDuration()
+}
+
+// isIdentifierAllowed returns true if the profile allows the given identifier
+// type.
+func (p *ACMEProfile) isIdentifierAllowed(typ ACMEIdentifierType) bool {
+	if len(p.Identifiers) == 0 {
+		return true
+	}
+	for _, t := range p.Identifiers {
+		if strings.EqualFold(string(t), string(typ)) {
+			return true
+		}
+	}
+	return false
+}
+
+// init initializes the profile options and controller.
+func (p *ACMEProfile) init(prov *ACME, config Config) (err error) {
+	claims := p.Claims
+	if claims == nil {
+		claims = prov.Claims
+	}
+
+	options := p.Options
+	if options == nil {
+		options = prov.Options
+	}
+	p.options = &Options{}
+	if options != nil {
+		*p.options = *options
+	}
+	if p.Policy != nil {
+		x509Options := &X509Options{}
+		if p.options.X509 != nil {
+			*x509Options = *p.options.X509
+		}
+		x509Options.AllowedNames = p.Policy.AllowedNames
+		x509Options.DeniedNames = p.Policy.DeniedNames
+		x509Options.AllowWildcardNames = p.Policy.AllowWildcardNames
+		p.options.X509 = x509Options
+	}
+
+	p.ctl, err = NewController(prov, claims, config, p.options)
+	return
+}
+
+type acmeProfileKey struct{}
+
+// NewContextWithACMEProfile creates a new context with the name of the ACME
+// profile used in an order.
+func NewContextWithACMEProfile(ctx context.Context, profile string) context.Context {
+	return context.WithValue(ctx, acmeProfileKey{}, profile)
+}
+
+// ACMEProfileFromContext returns the name of the ACME profile in the context.
+func ACMEProfileFromContext(ctx context.Context) string {
+	profile, _ := ctx.Value(acmeProfileKey{}).(string)
+	return profile
+}
+
 // ACME is the acme provisioner type, an entity that can authorize the ACME
 // provisioning flow.
 type ACME struct {
@@ -100,6 +206,12 @@ type ACME struct {
 	// clients to determine the correct issuer domain name to use
 	// when configuring CAA records. Defaults to empty array.
 	CaaIdentities []string `json:"caaIdentities,omitempty"`
+	// CheckCAA makes the provisioner verify the CAA records of the DNS
+	// identifiers during the challenge validation, as defined in RFC 8659.
+	// The issuer domain names in the records are matched against the
+	// CaaIdentities, and the RFC 8657 accounturi and validationmethods
+	// parameters are enforced. Defaults to false.
+	CheckCAA bool `json:"checkCAA,omitempty"`
 	// RequireEAB makes the provisioner require ACME EAB to be provided
 	// by clients when creating a new Account. If set to true, the provided
 	// EAB will be verified. If set to false and an EAB is provided, it is
@@ -107,8 +219,8 @@ type ACME struct {
 	RequireEAB bool `json:"requireEAB,omitempty"`
 	// Challenges contains the enabled challenges for this provisioner. If this
 	// value is not set the default http-01, dns-01 and tls-alpn-01 challenges
-	// will be enabled, device-attest-01, wire-oidc-01 and wire-dpop-01 will be
-	// disabled.
+	// will be enabled, dns-account-01, device-attest-01, wire-oidc-01 and
+	// wire-dpop-01 will be disabled.
 	Challenges []ACMEChallenge `json:"challenges,omitempty"`
 	// AttestationFormats contains the enabled attestation formats for this
 	// provisioner. If this value is not set the default apple, step and tpm
@@ -117,9 +229,29 @@ type ACME struct {
 	// AttestationRoots contains a bundle of root certificates in PEM format
 	// that will be used to verify the attestation certificates. If provided,
 	// this bundle will be used even for well-known CAs like Apple and Yubico.
-	AttestationRoots    []byte   `json:"attestationRoots,omitempty"`
-	Claims              *Claims  `json:"claims,omitempty"`
-	Options             *Options `json:"options,omitempty"`
+	AttestationRoots []byte `json:"attestationRoots,omitempty"`
+	// Profiles contains the certificate profiles that clients can select
+	// in the newOrder request. If a client does not select a profile, the
+	// claims and options of the provisioner will be used.
+	Profiles []*ACMEProfile `json:"profiles,omitempty"`
+	// RateLimits contains the limits on the number of accounts, orders,
+	// failed validations and certificates created using this provisioner.
+	// Rate limits are disabled by default.
+	RateLimits *ACMERateLimits `json:"rateLimits,omitempty"`
+	// ValidationPerspectives configures the remote agents used to validate
+	// the http-01, dns-01, dns-account-01 and tls-alpn-01 challenges from
+	// multiple network perspectives. Defaults to validate the challenges
+	// only from the CA.
+	ValidationPerspectives *ACMEValidationPerspectives `json:"validationPerspectives,omitempty"`
+	// TPMAttestation configures the validation of the EK certificates in the
+	// tpm attestation format. Defaults to only validate the AK certificate.
+	TPMAttestation *ACMETPMAttestation `json:"tpmAttestation,omitempty"`
+	// AutoRenewal enables the orders of Short-Term, Automatically Renewed
+	// (STAR) certificates, RFC 8739. The CA reissues the certificates of
+	// these orders until they end or they are canceled.
+	AutoRenewal         *ACMEAutoRenewal `json:"autoRenewal,omitempty"`
+	Claims              *Claims          `json:"claims,omitempty"`
+	Options             *Options         `json:"options,omitempty"`
 	attestationRootPool *x509.CertPool
 	ctl                 *Controller
 }
@@ -169,6 +301,30 @@ func (p *ACME) DefaultTLSCertDuration() time.Duration {
 	return p.ctl.Claimer.DefaultTLSCertDuration()
 }
 
+// GetProfile returns the profile with the given name.
+func (p *ACME) GetProfile(name string) (*ACMEProfile, bool) {
+	for _, profile := range p.Profiles {
+		if profile.Name == name {
+			return profile, true
+		}
+	}
+	return nil, false
+}
+
+// controller returns the controller of the profile in the context, or the one
+// of the provisioner if the context does not have a profile.
+func (p *ACME) controller(ctx context.Context) (*Controller, error) {
+	name := ACMEProfileFromContext(ctx)
+	if name == "" {
+		return p.ctl, nil
+	}
+	profile, ok := p.GetProfile(name)
+	if !ok {
+		return nil, fmt.Errorf("acme profile %q is not supported", name)
+	}
+	return profile.ctl, nil
+}
+
 // Init initializes and validates the fields of an ACME type.
 func (p *ACME) Init(config Config) (err error) {
 	switch {
@@ -216,6 +372,42 @@ func (p *ACME) Init(config Config) (err error) {
 		return fmt.Errorf("failed initializing Wire options: %w", err)
 	}
 
+	names := make(map[string]bool, len(p.Profiles))
+	for _, profile := range p.Profiles {
+		switch {
+		case profile == nil:
+			return errors.New("acme profile cannot be empty")
+		case profile.Name == "":
+			return errors.New("acme profile name cannot be empty")
+		case names[profile.Name]:
+			return fmt.Errorf("acme profile %q is duplicated", profile.Name)
+		}
+		names[profile.Name] = true
+		if err := profile.init(p, config); err != nil {
+			return fmt.Errorf("failed initializing acme profile %q: %w", profile.Name, err)
+		}
+	}
+
+	if p.CheckCAA && len(p.CaaIdentities) == 0 {
+		return errors.New("acme provisioner with checkCAA enabled requires caaIdentities")
+	}
+
+	if err := p.RateLimits.init(); err != nil {
+		return err
+	}
+
+	if err := p.ValidationPerspectives.init(); err != nil {
+		return err
+	}
+
+	if err := p.TPMAttestation.init(); err != nil {
+		return err
+	}
+
+	if err := p.AutoRenewal.init(); err != nil {
+		return err
+	}
+
 	p.ctl, err = NewController(p, p.Claims, config, p.Options)
 	return
 }
@@ -268,6 +460,8 @@ const (
 	WireUser ACMEIdentifierType = "wireapp-user"
 	// WireDevice is the Wire device identifier type
 	WireDevice ACMEIdentifierType = "wireapp-device"
+	// Email is the ACME email identifier type
+	Email ACMEIdentifierType = "email"
 )
 
 // ACMEIdentifier encodes ACME Order Identifiers
@@ -278,8 +472,22 @@ type ACMEIdentifier struct {
 
 // AuthorizeOrderIdentifier verifies the provisioner is allowed to issue a
 // certificate for an ACME Order Identifier.
-func (p *ACME) AuthorizeOrderIdentifier(_ context.Context, identifier ACMEIdentifier) error {
-	x509Policy := p.ctl.getPolicy().getX509()
+func (p *ACME) AuthorizeOrderIdentifier(ctx context.Context, identifier ACMEIdentifier) error {
+	if name := ACMEProfileFromContext(ctx); name != "" {
+		profile, ok := p.GetProfile(name)
+		if !ok {
+			return fmt.Errorf("acme profile %q is not supported", name)
+		}
+		if !profile.isIdentifierAllowed(identifier.Type) {
+			return fmt.Errorf("acme profile %q does not allow identifiers of type '%s'", name, identifier.Type)
+		}
+	}
+
+	ctl, err := p.controller(ctx)
+	if err != nil {
+		return err
+	}
+	x509Policy := ctl.getPolicy().getX509()
 
 	// identifier is allowed if no policy is configured
 	if x509Policy == nil {
@@ -287,7 +495,6 @@ func (p *ACME) AuthorizeOrderIdentifier(_ context.Context, identifier ACMEIdenti
 	}
 
 	// assuming only valid identifiers (IP or DNS) are provided
-	var err error
 	switch identifier.Type {
 	case IP:
 		err = x509Policy.IsIPAllowed(net.ParseIP(identifier.Value))
@@ -305,6 +512,8 @@ func (p *ACME) AuthorizeOrderIdentifier(_ context.Context, identifier ACMEIdenti
 			return fmt.Errorf("failed parsing Wire SANs: %w", err)
 		}
 		err = x509Policy.AreSANsAllowed([]string{wireID.ClientID})
+	case Email:
+		err = x509Policy.AreSANsAllowed([]string{identifier.Value})
 	default:
 		err = fmt.Errorf("invalid ACME identifier type '%s' provided", identifier.Type)
 	}
@@ -314,19 +523,26 @@ func (p *ACME) AuthorizeOrderIdentifier(_ context.Context, identifier ACMEIdenti
 
 // AuthorizeSign does not do any validation, because all validation is handled
 // in the ACME protocol. This method returns a list of modifiers / constraints
-// on the resulting certificate.
-func (p *ACME) AuthorizeSign(context.Context, string) ([]SignOption, error) {
+// on the resulting certificate. If the context contains an ACME profile, the
+// claims and options of the profile will be used.
+func (p *ACME) AuthorizeSign(ctx context.Context, _ string) ([]SignOption, error) {
+	ctl, err := p.controller(ctx)
+	if err != nil {
+		return nil, errs.Wrap(http.StatusInternalServerError, err, "acme.AuthorizeSign")
+	}
+
 	opts := []SignOption{
 		p,
 		// modifiers / withOptions
-		newProvisionerExtensionOption(TypeACME, p.Name, "").WithControllerOptions(p.ctl),
+		newProvisionerExtensionOption(TypeACME, p.Name, "").WithControllerOptions(ctl),
+		newCertificateTransparencyOption(ctl),
 		newForceCNOption(p.ForceCN),
-		profileDefaultDuration(p.ctl.Claimer.DefaultTLSCertDuration()),
+		profileDefaultDuration(ctl.Claimer.DefaultTLSCertDuration()),
 		// validators
 		defaultPublicKeyValidator{},
-		newValidityValidator(p.ctl.Claimer.MinTLSCertDuration(), p.ctl.Claimer.MaxTLSCertDuration()),
-		newX509NamePolicyValidator(p.ctl.getPolicy().getX509()),
-		p.ctl.newWebhookController(nil, linkedca.Webhook_X509),
+		newValidityValidator(ctl.Claimer.MinTLSCertDuration(), ctl.Claimer.MaxTLSCertDuration()),
+		newX509NamePolicyValidator(ctl.getPolicy().getX509()),
+		ctl.newWebhookController(nil, linkedca.Webhook_X509),
 	}
 
 	return opts, nil
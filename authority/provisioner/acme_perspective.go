@@ -0,0 +1,150 @@
+package provisioner
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// DefaultACMEValidationTimeout is the default time to wait for the responses
+// of the remote validation agents.
+const DefaultACMEValidationTimeout = 30 * time.Second
+
+// ACMEValidationAgent is a remote validation agent used to validate ACME
+// challenges from a different network perspective.
+type ACMEValidationAgent struct {
+	// Name is the name of the agent, used in the error messages.
+	Name string `json:"name"`
+	// URL is the URL of the validate endpoint of the agent, e.g.
+	// https://agent.example.com:9443/validate. It must use https.
+	URL string `json:"url"`
+	// Token is the secret shared with the agent, it's sent as a bearer
+	// token to authenticate the requests.
+	Token string `json:"token"`
+}
+
+// ACMEValidationPerspectives configures the multi-perspective validation of
+// ACME challenges. After a successful validation from the CA, the challenge is
+// validated by the remote agents, and the validation only succeeds if the
+// number of agents that validate it reaches the quorum. This protects the
+// validation against DNS or BGP hijacks in a part of the network.
+type ACMEValidationPerspectives struct {
+	// Agents are the remote validation agents.
+	Agents []*ACMEValidationAgent `json:"agents"`
+	// Quorum is the number of remote agents that must validate a challenge.
+	// Defaults to the number of agents.
+	Quorum int `json:"quorum,omitempty"`
+	// Timeout is the time to wait for the responses of the agents. Defaults
+	// to 30s.
+	Timeout *Duration `json:"timeout,omitempty"`
+	// Roots contains a bundle of root certificates in PEM format used to
+	// verify the TLS certificates of the agents. Defaults to the system
+	// roots.
+	Roots  []byte `json:"roots,omitempty"`
+	client *http.Client
+}
+
+// GetQuorum returns the number of remote agents that must validate a
+// challenge.
+func (p *ACMEValidationPerspectives) GetQuorum() int {
+	if p.Quorum == 0 {
+		return len(p.Agents)
+	}
+	return p.Quorum
+}
+
+// GetTimeout returns the time to wait for the responses of the agents.
+func (p *ACMEValidationPerspectives) GetTimeout() time.Duration {
+	if p.Timeout == nil {
+		return DefaultACMEValidationTimeout
+	}
+	return p.Timeout.Duration
+}
+
+// GetHTTPClient returns the HTTP client used to send the validation requests
+// to the agents.
+func (p *ACMEValidationPerspectives) GetHTTPClient() *http.Client {
+	if p.client == nil {
+		return newACMEValidationClient(p.GetTimeout(), nil)
+	}
+	return p.client
+}
+
+func newACMEValidationClient(timeout time.Duration, roots *x509.CertPool) *http.Client {
+	return &http.Client{
+		Timeout: timeout,
+		Transport: &http.Transport{
+			Proxy: http.ProxyFromEnvironment,
+			TLSClientConfig: &tls.Config{
+				RootCAs:    roots,
+				MinVersion: tls.VersionTLS12,
+			},
+		},
+	}
+}
+
+func (p *ACMEValidationPerspectives) init() error {
+	if p == nil {
+		return nil
+	}
+	if len(p.Agents) == 0 {
+		return errors.New("acme validation perspectives require at least one agent")
+	}
+
+	names := make(map[string]bool, len(p.Agents))
+	for _, a := range p.Agents {
+		switch {
+		case a == nil:
+			return errors.New("acme validation agent cannot be empty")
+		case a.Name == "":
+			return errors.New("acme validation agent name cannot be empty")
+		case names[a.Name]:
+			return fmt.Errorf("acme validation agent %q is duplicated", a.Name)
+		case a.Token == "":
+			return fmt.Errorf("acme validation agent %q token cannot be empty", a.Name)
+		}
+		names[a.Name] = true
+		u, err := url.Parse(a.URL)
+		if err != nil || u.Scheme != "https" || u.Host == "" {
+			return fmt.Errorf("acme validation agent %q url %q is not valid", a.Name, a.URL)
+		}
+	}
+
+	switch {
+	case p.Quorum < 0 || p.Quorum > len(p.Agents):
+		return fmt.Errorf("acme validation perspectives quorum must be between 1 and %d", len(p.Agents))
+	case p.Timeout != nil && p.Timeout.Duration <= 0:
+		return errors.New("acme validation perspectives timeout must be greater than 0")
+	}
+
+	// The pool will be nil if there are no roots, and the system roots will
+	// be used.
+	var pool *x509.CertPool
+	if rest := p.Roots; len(rest) > 0 {
+		var block *pem.Block
+		var hasCert bool
+		pool = x509.NewCertPool()
+		for rest != nil {
+			block, rest = pem.Decode(rest)
+			if block == nil {
+				break
+			}
+			cert, err := x509.ParseCertificate(block.Bytes)
+			if err != nil {
+				return errors.New("error parsing acme validation perspectives roots: malformed certificate")
+			}
+			pool.AddCert(cert)
+			hasCert = true
+		}
+		if !hasCert {
+			return errors.New("error parsing acme validation perspectives roots: no certificates found")
+		}
+	}
+	p.client = newACMEValidationClient(p.GetTimeout(), pool)
+	return nil
+}
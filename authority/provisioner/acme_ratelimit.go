@@ -0,0 +1,82 @@
+package provisioner
+
+import (
+	"fmt"
+	"time"
+)
+
+// ACMERateLimit is the maximum number of events allowed in a fixed time
+// window.
+type ACMERateLimit struct {
+	Limit  int       `json:"limit"`
+	Window *Duration `json:"window,omitempty"`
+}
+
+// IsEnabled returns if the rate limit is enabled.
+func (l *ACMERateLimit) IsEnabled() bool {
+	return l != nil && l.Limit > 0
+}
+
+// GetWindow returns the duration of the window of the rate limit.
+func (l *ACMERateLimit) GetWindow() time.Duration {
+	if l == nil || l.Window == nil {
+		return 0
+	}
+	return l.Window.Duration
+}
+
+func (l *ACMERateLimit) init(name string, defaultWindow time.Duration) error {
+	switch {
+	case l == nil:
+		return nil
+	case l.Limit < 0:
+		return fmt.Errorf("acme rate limit %q must be greater than or equal to 0", name)
+	case l.Window == nil:
+		l.Window = &Duration{Duration: defaultWindow}
+	case l.Window.Duration <= 0:
+		return fmt.Errorf("acme rate limit %q window must be greater than 0", name)
+	}
+	return nil
+}
+
+// ACMERateLimits contains the rate limits of an ACME provisioner. The
+// counters are stored in the database, so they are shared by all the
+// instances of the CA using it.
+type ACMERateLimits struct {
+	// NewAccountsPerIP limits the number of accounts created from the same
+	// IP address. The window defaults to 3h.
+	NewAccountsPerIP *ACMERateLimit `json:"newAccountsPerIP,omitempty"`
+	// NewOrdersPerAccount limits the number of orders created by an account.
+	// The window defaults to 3h.
+	NewOrdersPerAccount *ACMERateLimit `json:"newOrdersPerAccount,omitempty"`
+	// FailedValidationsPerIdentifier limits the number of failed challenge
+	// validations of an identifier. The window defaults to 1h.
+	FailedValidationsPerIdentifier *ACMERateLimit `json:"failedValidationsPerIdentifier,omitempty"`
+	// CertificatesPerRegisteredDomain limits the number of certificates
+	// issued for the same registered domain, the domain directly below a
+	// public suffix. The window defaults to 168h (one week).
+	CertificatesPerRegisteredDomain *ACMERateLimit `json:"certificatesPerRegisteredDomain,omitempty"`
+	// ChallengeEmailsPerAddress limits the number of email-reply-00
+	// challenge emails sent to the same email address. The window defaults
+	// to 24h.
+	ChallengeEmailsPerAddress *ACMERateLimit `json:"challengeEmailsPerAddress,omitempty"`
+}
+
+func (l *ACMERateLimits) init() error {
+	if l == nil {
+		return nil
+	}
+	if err := l.NewAccountsPerIP.init("newAccountsPerIP", 3*time.Hour); err != nil {
+		return err
+	}
+	if err := l.NewOrdersPerAccount.init("newOrdersPerAccount", 3*time.Hour); err != nil {
+		return err
+	}
+	if err := l.FailedValidationsPerIdentifier.init("failedValidationsPerIdentifier", time.Hour); err != nil {
+		return err
+	}
+	if err := l.CertificatesPerRegisteredDomain.init("certificatesPerRegisteredDomain", 7*24*time.Hour); err != nil {
+		return err
+	}
+	return l.ChallengeEmailsPerAddress.init("challengeEmailsPerAddress", 24*time.Hour)
+}
@@ -0,0 +1,59 @@
+package provisioner
+
+import (
+	"errors"
+	"time"
+)
+
+// ACMEAutoRenewal enables the Short-Term, Automatically Renewed (STAR)
+// certificates defined in RFC 8739. Clients request them adding an
+// auto-renewal object to the new-order request, and the CA reissues the
+// certificates until the end date of the order or until the order is
+// canceled.
+type ACMEAutoRenewal struct {
+	// MinLifetime is the minimum validity of the short-term certificates.
+	// Defaults to 1h.
+	MinLifetime *Duration `json:"minLifetime,omitempty"`
+	// MaxDuration is the maximum time between the start and the end date of
+	// an auto-renewal order. Defaults to 8760h (365 days).
+	MaxDuration *Duration `json:"maxDuration,omitempty"`
+	// AllowCertificateGet allows clients to request the short-term
+	// certificates using unauthenticated GET requests.
+	AllowCertificateGet bool `json:"allowCertificateGet,omitempty"`
+}
+
+// GetMinLifetime returns the minimum validity of the short-term
+// certificates.
+func (a *ACMEAutoRenewal) GetMinLifetime() time.Duration {
+	if a == nil || a.MinLifetime == nil {
+		return 0
+	}
+	return a.MinLifetime.Duration
+}
+
+// GetMaxDuration returns the maximum duration of an auto-renewal order.
+func (a *ACMEAutoRenewal) GetMaxDuration() time.Duration {
+	if a == nil || a.MaxDuration == nil {
+		return 0
+	}
+	return a.MaxDuration.Duration
+}
+
+func (a *ACMEAutoRenewal) init() error {
+	if a == nil {
+		return nil
+	}
+	switch {
+	case a.MinLifetime == nil:
+		a.MinLifetime = &Duration{Duration: time.Hour}
+	case a.MinLifetime.Duration <= 0:
+		return errors.New("autoRenewal.minLifetime must be greater than 0")
+	}
+	switch {
+	case a.MaxDuration == nil:
+		a.MaxDuration = &Duration{Duration: 365 * 24 * time.Hour}
+	case a.MaxDuration.Duration < a.MinLifetime.Duration:
+		return errors.New("autoRenewal.maxDuration must be greater than or equal to autoRenewal.minLifetime")
+	}
+	return nil
+}
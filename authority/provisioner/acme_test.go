@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"context"
 	"crypto/x509"
+	"encoding/pem"
 	"errors"
 	"fmt"
 	"net/http"
@@ -12,9 +13,11 @@ import (
 	"time"
 
 	"github.com/smallstep/certificates/api/render"
+	"github.com/smallstep/certificates/authority/policy"
 	"github.com/smallstep/certificates/authority/provisioner/wire"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"go.step.sm/crypto/pemutil"
 )
 
 func TestACMEChallenge_Validate(t *testing.T) {
@@ -25,10 +28,12 @@ func TestACMEChallenge_Validate(t *testing.T) {
 	}{
 		{"http-01", HTTP_01, false},
 		{"dns-01", DNS_01, false},
+		{"dns-account-01", DNS_ACCOUNT_01, false},
 		{"tls-alpn-01", TLS_ALPN_01, false},
 		{"device-attest-01", DEVICE_ATTEST_01, false},
 		{"wire-oidc-01", DEVICE_ATTEST_01, false},
 		{"wire-dpop-01", DEVICE_ATTEST_01, false},
+		{"email-reply-00", EMAIL_REPLY_00, false},
 		{"uppercase", "HTTP-01", false},
 		{"fail", "http-02", true},
 	}
@@ -54,6 +59,7 @@ func TestACMEAttestationFormat_Validate(t *testing.T) {
 		{"apple", APPLE, false},
 		{"step", STEP, false},
 		{"tpm", TPM, false},
+		{"android-key", ANDROID_KEY, false},
 		{"uppercase", "APPLE", false},
 		{"fail", "FOO", true},
 	}
@@ -124,6 +130,17 @@ MCowBQYDK2VwAyEA5c+4NKZSNQcR1T8qN6SjwgdPZQ0Ge12Ylx/YeGAJ35k=
 				err: errors.New("claims: MinTLSCertDuration must be greater than 0"),
 			}
 		},
+		"fail/check-caa-without-identities": func(t *testing.T) ProvisionerValidateTest {
+			return ProvisionerValidateTest{
+				p:   &ACME{Name: "foo", Type: "ACME", CheckCAA: true},
+				err: errors.New("acme provisioner with checkCAA enabled requires caaIdentities"),
+			}
+		},
+		"ok/check-caa": func(t *testing.T) ProvisionerValidateTest {
+			return ProvisionerValidateTest{
+				p: &ACME{Name: "foo", Type: "ACME", CheckCAA: true, CaaIdentities: []string{"ca.example.org"}},
+			}
+		},
 		"fail/bad-challenge": func(t *testing.T) ProvisionerValidateTest {
 			return ProvisionerValidateTest{
 				p:   &ACME{Name: "foo", Type: "ACME", Challenges: []ACMEChallenge{HTTP_01, "zar"}},
@@ -187,11 +204,37 @@ MCowBQYDK2VwAyEA5c+4NKZSNQcR1T8qN6SjwgdPZQ0Ge12Ylx/YeGAJ35k=
 				err: errors.New("failed initializing Wire options: failed validating Wire options: failed initializing OIDC options: provider not set"),
 			}
 		},
+		"fail/empty-profile-name": func(t *testing.T) ProvisionerValidateTest {
+			return ProvisionerValidateTest{
+				p:   &ACME{Name: "foo", Type: "ACME", Profiles: []*ACMEProfile{{Description: "foo"}}},
+				err: errors.New("acme profile name cannot be empty"),
+			}
+		},
+		"fail/duplicated-profile": func(t *testing.T) ProvisionerValidateTest {
+			return ProvisionerValidateTest{
+				p:   &ACME{Name: "foo", Type: "ACME", Profiles: []*ACMEProfile{{Name: "tls"}, {Name: "tls"}}},
+				err: errors.New("acme profile \"tls\" is duplicated"),
+			}
+		},
+		"fail/bad-profile-claims": func(t *testing.T) ProvisionerValidateTest {
+			return ProvisionerValidateTest{
+				p:   &ACME{Name: "foo", Type: "ACME", Profiles: []*ACMEProfile{{Name: "tls", Claims: &Claims{DefaultTLSDur: &Duration{0}}}}},
+				err: errors.New("failed initializing acme profile \"tls\": claims: MinTLSCertDuration must be greater than 0"),
+			}
+		},
 		"ok": func(t *testing.T) ProvisionerValidateTest {
 			return ProvisionerValidateTest{
 				p: &ACME{Name: "foo", Type: "ACME"},
 			}
 		},
+		"ok/profiles": func(t *testing.T) ProvisionerValidateTest {
+			return ProvisionerValidateTest{
+				p: &ACME{Name: "foo", Type: "ACME", Profiles: []*ACMEProfile{
+					{Name: "tlsserver", Description: "Server certificates"},
+					{Name: "mtls", Claims: &Claims{DefaultTLSDur: &Duration{time.Hour}}, Options: &Options{X509: &X509Options{Template: "{}"}}},
+				}},
+			}
+		},
 		"ok/attestation": func(t *testing.T) ProvisionerValidateTest {
 			return ProvisionerValidateTest{
 				p: &ACME{
@@ -337,10 +380,12 @@ func TestACME_AuthorizeSign(t *testing.T) {
 
 			assert.NoError(t, err)
 			if assert.NotNil(t, opts) {
-				assert.Len(t, opts, 8) // number of SignOptions returned
+				assert.Len(t, opts, 9) // number of SignOptions returned
 				for _, o := range opts {
 					switch v := o.(type) {
 					case *ACME:
+					case CertificateTransparency:
+						assert.False(t, bool(v))
 					case *provisionerExtensionOption:
 						assert.Equal(t, v.Type, TypeACME)
 						assert.Equal(t, v.Name, tc.p.GetName())
@@ -445,3 +490,262 @@ func TestACME_IsAttestationFormatEnabled(t *testing.T) {
 		})
 	}
 }
+
+func TestACME_AuthorizeOrderIdentifier_email(t *testing.T) {
+	p := &ACME{
+		Type: "ACME",
+		Name: "acme",
+		Options: &Options{X509: &X509Options{
+			AllowedNames: &policy.X509NameOptions{EmailAddresses: []string{"@example.com"}},
+		}},
+		Challenges: []ACMEChallenge{EMAIL_REPLY_00},
+	}
+	require.NoError(t, p.Init(Config{Claims: globalProvisionerClaims}))
+
+	ctx := context.Background()
+	assert.NoError(t, p.AuthorizeOrderIdentifier(ctx, ACMEIdentifier{Type: Email, Value: "alice@example.com"}))
+	assert.Error(t, p.AuthorizeOrderIdentifier(ctx, ACMEIdentifier{Type: Email, Value: "alice@example.org"}))
+	assert.True(t, p.IsChallengeEnabled(ctx, EMAIL_REPLY_00))
+}
+
+func TestACME_profiles(t *testing.T) {
+	serverTemplate := `{"subject": {{ toJson .Subject }}, "sans": {{ toJson .SANs }}, "extKeyUsage": ["serverAuth"]}`
+	mtlsTemplate := `{"subject": {{ toJson .Subject }}, "sans": {{ toJson .SANs }}, "extKeyUsage": ["serverAuth", "clientAuth"]}`
+	p := &ACME{
+		Type:    "ACME",
+		Name:    "acme",
+		Options: &Options{X509: &X509Options{Template: serverTemplate}},
+		Profiles: []*ACMEProfile{
+			{Name: "tlsserver", Description: "Server certificates"},
+			{
+				Name:        "mtls",
+				Description: "Client and server certificates",
+				Identifiers: []ACMEIdentifierType{DNS},
+				Policy: &policy.X509PolicyOptions{
+					AllowedNames: &policy.X509NameOptions{DNSDomains: []string{"*.internal"}},
+				},
+				Claims:  &Claims{DefaultTLSDur: &Duration{time.Hour}},
+				Options: &Options{X509: &X509Options{Template: mtlsTemplate}},
+			},
+		},
+	}
+	require.NoError(t, p.Init(Config{Claims: globalProvisionerClaims}))
+
+	profile, ok := p.GetProfile("tlsserver")
+	require.True(t, ok)
+	assert.Equal(t, serverTemplate, profile.GetOptions().GetX509Options().Template)
+	assert.Equal(t, p.DefaultTLSCertDuration(), profile.DefaultTLSCertDuration())
+
+	profile, ok = p.GetProfile("mtls")
+	require.True(t, ok)
+	assert.Equal(t, mtlsTemplate, profile.GetOptions().GetX509Options().Template)
+	assert.Equal(t, time.Hour, profile.DefaultTLSCertDuration())
+
+	_, ok = p.GetProfile("foo")
+	assert.False(t, ok)
+
+	ctx := context.Background()
+	mtlsCtx := NewContextWithACMEProfile(ctx, "mtls")
+	assert.Equal(t, "mtls", ACMEProfileFromContext(mtlsCtx))
+	assert.Equal(t, "", ACMEProfileFromContext(ctx))
+
+	t.Run("AuthorizeOrderIdentifier", func(t *testing.T) {
+		assert.NoError(t, p.AuthorizeOrderIdentifier(ctx, ACMEIdentifier{Type: DNS, Value: "example.com"}))
+		assert.NoError(t, p.AuthorizeOrderIdentifier(ctx, ACMEIdentifier{Type: IP, Value: "127.0.0.1"}))
+		assert.NoError(t, p.AuthorizeOrderIdentifier(mtlsCtx, ACMEIdentifier{Type: DNS, Value: "db.internal"}))
+		assert.Error(t, p.AuthorizeOrderIdentifier(mtlsCtx, ACMEIdentifier{Type: DNS, Value: "example.com"}))
+		assert.Error(t, p.AuthorizeOrderIdentifier(mtlsCtx, ACMEIdentifier{Type: IP, Value: "127.0.0.1"}))
+		assert.Error(t, p.AuthorizeOrderIdentifier(NewContextWithACMEProfile(ctx, "foo"), ACMEIdentifier{Type: DNS, Value: "example.com"}))
+	})
+
+	t.Run("AuthorizeSign", func(t *testing.T) {
+		opts, err := p.AuthorizeSign(mtlsCtx, "")
+		require.NoError(t, err)
+		var found bool
+		for _, o := range opts {
+			switch v := o.(type) {
+			case profileDefaultDuration:
+				assert.Equal(t, time.Hour, time.Duration(v))
+				found = true
+			case *x509NamePolicyValidator:
+				assert.NotNil(t, v.policyEngine)
+			}
+		}
+		assert.True(t, found)
+
+		_, err = p.AuthorizeSign(NewContextWithACMEProfile(ctx, "foo"), "")
+		assert.Error(t, err)
+	})
+}
+
+func TestACME_rateLimits(t *testing.T) {
+	newProv := func(limits *ACMERateLimits) *ACME {
+		return &ACME{Type: "ACME", Name: "acme", RateLimits: limits}
+	}
+
+	p := newProv(&ACMERateLimits{
+		NewAccountsPerIP:                &ACMERateLimit{Limit: 10},
+		NewOrdersPerAccount:             &ACMERateLimit{Limit: 300, Window: &Duration{Duration: time.Hour}},
+		FailedValidationsPerIdentifier:  &ACMERateLimit{Limit: 5},
+		CertificatesPerRegisteredDomain: &ACMERateLimit{Limit: 50},
+		ChallengeEmailsPerAddress:       &ACMERateLimit{Limit: 5},
+	})
+	require.NoError(t, p.Init(Config{Claims: globalProvisionerClaims}))
+	assert.Equal(t, 3*time.Hour, p.RateLimits.NewAccountsPerIP.GetWindow())
+	assert.Equal(t, time.Hour, p.RateLimits.NewOrdersPerAccount.GetWindow())
+	assert.Equal(t, time.Hour, p.RateLimits.FailedValidationsPerIdentifier.GetWindow())
+	assert.Equal(t, 168*time.Hour, p.RateLimits.CertificatesPerRegisteredDomain.GetWindow())
+	assert.Equal(t, 24*time.Hour, p.RateLimits.ChallengeEmailsPerAddress.GetWindow())
+	assert.True(t, p.RateLimits.NewAccountsPerIP.IsEnabled())
+
+	var disabled *ACMERateLimit
+	assert.False(t, disabled.IsEnabled())
+	assert.False(t, (&ACMERateLimit{}).IsEnabled())
+	assert.Zero(t, disabled.GetWindow())
+
+	p = newProv(&ACMERateLimits{NewOrdersPerAccount: &ACMERateLimit{Limit: -1}})
+	assert.EqualError(t, p.Init(Config{Claims: globalProvisionerClaims}), `acme rate limit "newOrdersPerAccount" must be greater than or equal to 0`)
+
+	p = newProv(&ACMERateLimits{NewAccountsPerIP: &ACMERateLimit{Limit: 1, Window: &Duration{}}})
+	assert.EqualError(t, p.Init(Config{Claims: globalProvisionerClaims}), `acme rate limit "newAccountsPerIP" window must be greater than 0`)
+}
+
+func TestACME_validationPerspectives(t *testing.T) {
+	agent := func(name string) *ACMEValidationAgent {
+		return &ACMEValidationAgent{Name: name, URL: "https://" + name + ".example.com/validate", Token: "secret"}
+	}
+	tests := []struct {
+		name         string
+		perspectives *ACMEValidationPerspectives
+		wantErr      string
+	}{
+		{"ok", &ACMEValidationPerspectives{Agents: []*ACMEValidationAgent{agent("a"), agent("b")}, Quorum: 1}, ""},
+		{"ok/defaults", &ACMEValidationPerspectives{Agents: []*ACMEValidationAgent{agent("a")}}, ""},
+		{"fail/no-agents", &ACMEValidationPerspectives{}, "acme validation perspectives require at least one agent"},
+		{"fail/nil-agent", &ACMEValidationPerspectives{Agents: []*ACMEValidationAgent{nil}}, "acme validation agent cannot be empty"},
+		{"fail/name", &ACMEValidationPerspectives{Agents: []*ACMEValidationAgent{agent("")}}, "acme validation agent name cannot be empty"},
+		{"fail/duplicated", &ACMEValidationPerspectives{Agents: []*ACMEValidationAgent{agent("a"), agent("a")}}, `acme validation agent "a" is duplicated`},
+		{"fail/token", &ACMEValidationPerspectives{Agents: []*ACMEValidationAgent{{Name: "a", URL: "https://a.example.com"}}}, `acme validation agent "a" token cannot be empty`},
+		{"fail/url", &ACMEValidationPerspectives{Agents: []*ACMEValidationAgent{{Name: "a", URL: "a.example.com", Token: "secret"}}}, `acme validation agent "a" url "a.example.com" is not valid`},
+		{"fail/http", &ACMEValidationPerspectives{Agents: []*ACMEValidationAgent{{Name: "a", URL: "http://a.example.com", Token: "secret"}}}, `acme validation agent "a" url "http://a.example.com" is not valid`},
+		{"fail/roots", &ACMEValidationPerspectives{Agents: []*ACMEValidationAgent{agent("a")}, Roots: []byte("foo")}, "error parsing acme validation perspectives roots: no certificates found"},
+		{"fail/malformed-roots", &ACMEValidationPerspectives{Agents: []*ACMEValidationAgent{agent("a")}, Roots: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: []byte("foo")})}, "error parsing acme validation perspectives roots: malformed certificate"},
+		{"fail/quorum", &ACMEValidationPerspectives{Agents: []*ACMEValidationAgent{agent("a")}, Quorum: 2}, "acme validation perspectives quorum must be between 1 and 1"},
+		{"fail/timeout", &ACMEValidationPerspectives{Agents: []*ACMEValidationAgent{agent("a")}, Timeout: &Duration{}}, "acme validation perspectives timeout must be greater than 0"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := &ACME{Type: "ACME", Name: "acme", ValidationPerspectives: tt.perspectives}
+			err := p.Init(Config{Claims: globalProvisionerClaims})
+			if tt.wantErr != "" {
+				assert.EqualError(t, err, tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, DefaultACMEValidationTimeout, p.ValidationPerspectives.GetTimeout())
+			assert.Equal(t, DefaultACMEValidationTimeout, p.ValidationPerspectives.GetHTTPClient().Timeout)
+			assert.GreaterOrEqual(t, p.ValidationPerspectives.GetQuorum(), 1)
+		})
+	}
+
+	p := &ACMEValidationPerspectives{Agents: []*ACMEValidationAgent{agent("a"), agent("b")}}
+	assert.Equal(t, 2, p.GetQuorum())
+	p.Quorum = 1
+	assert.Equal(t, 1, p.GetQuorum())
+}
+
+func TestACME_tpmAttestation(t *testing.T) {
+	ekRoots, err := os.ReadFile("testdata/certs/yubico-piv-ca.crt")
+	require.NoError(t, err)
+
+	tests := []struct {
+		name      string
+		tpm       *ACMETPMAttestation
+		wantRoots bool
+		wantErr   string
+	}{
+		{"ok/nil", nil, false, ""},
+		{"ok/empty", &ACMETPMAttestation{}, true, ""},
+		{"ok/roots", &ACMETPMAttestation{EKRoots: ekRoots}, true, ""},
+		{"ok/all", &ACMETPMAttestation{EKRoots: ekRoots, RequireEKCertificate: true, CheckEKRevocation: true, BindPermanentIdentifier: true}, true, ""},
+		{"fail/malformed", &ACMETPMAttestation{EKRoots: []byte("-----BEGIN CERTIFICATE-----\nZm9v\n-----END CERTIFICATE-----")}, false, "error parsing tpmAttestation.ekRoots: malformed certificate"},
+		{"fail/no-certificates", &ACMETPMAttestation{EKRoots: []byte("\n")}, false, "error parsing tpmAttestation.ekRoots: no certificates found"},
+		{"ok/require-default-roots", &ACMETPMAttestation{RequireEKCertificate: true}, true, ""},
+		{"ok/revocation-default-roots", &ACMETPMAttestation{CheckEKRevocation: true}, true, ""},
+		{"fail/bind-without-require", &ACMETPMAttestation{EKRoots: ekRoots, BindPermanentIdentifier: true}, false, "acme tpm attestation with bindPermanentIdentifier requires requireEKCertificate"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := &ACME{Type: "ACME", Name: "acme", TPMAttestation: tt.tpm}
+			err := p.Init(Config{Claims: globalProvisionerClaims})
+			if tt.wantErr != "" {
+				assert.EqualError(t, err, tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			pool, ok := p.TPMAttestation.GetEKRoots()
+			assert.Equal(t, tt.wantRoots, ok)
+			assert.Equal(t, tt.wantRoots, pool != nil)
+		})
+	}
+}
+
+func TestACME_tpmAttestation_defaultRoots(t *testing.T) {
+	intermediate, err := pemutil.ReadCertificate("testdata/certs/google-tpm-ek-intermediate.crt")
+	require.NoError(t, err)
+
+	p := &ACME{Type: "ACME", Name: "acme", TPMAttestation: &ACMETPMAttestation{RequireEKCertificate: true}}
+	require.NoError(t, p.Init(Config{Claims: globalProvisionerClaims}))
+	pool, ok := p.TPMAttestation.GetEKRoots()
+	require.True(t, ok)
+
+	_, err = intermediate.Verify(x509.VerifyOptions{
+		Roots:       pool,
+		CurrentTime: intermediate.NotBefore.Add(time.Hour),
+		KeyUsages:   []x509.ExtKeyUsage{x509.ExtKeyUsageAny},
+	})
+	assert.NoError(t, err)
+
+	// Configured roots replace the default ones.
+	ekRoots, err := os.ReadFile("testdata/certs/yubico-piv-ca.crt")
+	require.NoError(t, err)
+	p.TPMAttestation.EKRoots = ekRoots
+	require.NoError(t, p.Init(Config{Claims: globalProvisionerClaims}))
+	pool, ok = p.TPMAttestation.GetEKRoots()
+	require.True(t, ok)
+	_, err = intermediate.Verify(x509.VerifyOptions{
+		Roots:       pool,
+		CurrentTime: intermediate.NotBefore.Add(time.Hour),
+		KeyUsages:   []x509.ExtKeyUsage{x509.ExtKeyUsageAny},
+	})
+	assert.Error(t, err)
+}
+
+func TestACME_autoRenewal(t *testing.T) {
+	tests := []struct {
+		name            string
+		autoRenewal     *ACMEAutoRenewal
+		wantMinLifetime time.Duration
+		wantMaxDuration time.Duration
+		wantErr         string
+	}{
+		{"ok/nil", nil, 0, 0, ""},
+		{"ok/defaults", &ACMEAutoRenewal{}, time.Hour, 365 * 24 * time.Hour, ""},
+		{"ok/custom", &ACMEAutoRenewal{MinLifetime: &Duration{Duration: 10 * time.Minute}, MaxDuration: &Duration{Duration: 24 * time.Hour}}, 10 * time.Minute, 24 * time.Hour, ""},
+		{"fail/minLifetime", &ACMEAutoRenewal{MinLifetime: &Duration{}}, 0, 0, "autoRenewal.minLifetime must be greater than 0"},
+		{"fail/maxDuration", &ACMEAutoRenewal{MaxDuration: &Duration{Duration: time.Minute}}, 0, 0, "autoRenewal.maxDuration must be greater than or equal to autoRenewal.minLifetime"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := &ACME{Type: "ACME", Name: "acme", AutoRenewal: tt.autoRenewal}
+			err := p.Init(Config{Claims: globalProvisionerClaims})
+			if tt.wantErr != "" {
+				assert.EqualError(t, err, tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.wantMinLifetime, p.AutoRenewal.GetMinLifetime())
+			assert.Equal(t, tt.wantMaxDuration, p.AutoRenewal.GetMaxDuration())
+		})
+	}
+}
@@ -0,0 +1,119 @@
+package provisioner
+
+import (
+	"crypto/x509"
+	_ "embed" // embed default TPM manufacturer roots
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"sync"
+)
+
+// defaultEKRoots is the bundle of TPM manufacturer roots used to validate the
+// EK certificates if no EK roots are configured. It is embedded in the binary
+// at compile time.
+//
+//go:embed tpm_ek_roots.pem
+var defaultEKRoots []byte
+
+// defaultEKRootPool returns the certificate pool with the default EK roots.
+var defaultEKRootPool = sync.OnceValues(func() (*x509.CertPool, error) {
+	return parseEKRoots(defaultEKRoots)
+})
+
+// ACMETPMAttestation configures the validation of the Endorsement Key (EK)
+// certificates in the tpm format of the device-attest-01 challenge. Clients
+// send the EK certificate chain in the "ekx5c" property of the attestation
+// statement.
+type ACMETPMAttestation struct {
+	// RequireEKCertificate requires the attestation statement to contain the
+	// EK certificate chain. If false, the chain is only validated if
+	// present.
+	RequireEKCertificate bool `json:"requireEKCertificate,omitempty"`
+	// EKRoots contains a bundle of TPM manufacturer root certificates in PEM
+	// format used to validate the EK certificates. If empty, the default
+	// bundle shipped with the CA in tpm_ek_roots.pem is used. The default
+	// bundle does not contain the roots of every TPM manufacturer, see the
+	// file for the list of roots included.
+	EKRoots []byte `json:"ekRoots,omitempty"`
+	// CheckEKRevocation enables the revocation check of the EK certificate
+	// chain using the CRLs in the CRL distribution points of the
+	// certificates.
+	CheckEKRevocation bool `json:"checkEKRevocation,omitempty"`
+	// BindPermanentIdentifier requires the permanent identifier in the
+	// challenge to be the URI of the EK key hash, urn:ek:sha256:<base64>, of
+	// the validated EK certificate. The AK certificate must contain the same
+	// URI.
+	BindPermanentIdentifier bool `json:"bindPermanentIdentifier,omitempty"`
+	ekRootPool              *x509.CertPool
+}
+
+// GetEKRoots returns the certificate pool with the configured EK roots, or
+// the default ones if none are configured, and reports if the pool contains
+// at least one certificate.
+func (a *ACMETPMAttestation) GetEKRoots() (*x509.CertPool, bool) {
+	if a == nil {
+		return nil, false
+	}
+	return a.ekRootPool, a.ekRootPool != nil
+}
+
+func (a *ACMETPMAttestation) init() (err error) {
+	if a == nil {
+		return nil
+	}
+
+	if len(a.EKRoots) == 0 {
+		if a.ekRootPool, err = defaultEKRootPool(); err != nil {
+			return fmt.Errorf("error parsing default tpm ek roots: %w", err)
+		}
+	} else if a.ekRootPool, err = parseEKRoots(a.EKRoots); err != nil {
+		return fmt.Errorf("error parsing tpmAttestation.ekRoots: %w", err)
+	}
+
+	if a.BindPermanentIdentifier && !a.RequireEKCertificate {
+		return errors.New("acme tpm attestation with bindPermanentIdentifier requires requireEKCertificate")
+	}
+	return nil
+}
+
+// parseEKRoots parses a bundle of PEM certificates into a certificate pool.
+func parseEKRoots(data []byte) (*x509.CertPool, error) {
+	var (
+		block *pem.Block
+		pool  *x509.CertPool
+	)
+	for rest := data; rest != nil; {
+		block, rest = pem.Decode(rest)
+		if block == nil {
+			break
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, errors.New("malformed certificate")
+		}
+		if pool == nil {
+			pool = x509.NewCertPool()
+		}
+		pool.AddCert(cert)
+	}
+	if pool == nil {
+		return nil, errors.New("no certificates found")
+	}
+	return pool, nil
+}
+
+// TPMEKValidation contains the decisions taken validating the EK certificate
+// chain of a TPM in a device-attest-01 challenge.
+type TPMEKValidation struct {
+	// EKKeyID is the URI of the EK key hash, urn:ek:sha256:<base64>.
+	EKKeyID string `json:"ekKeyID"`
+	// Chain contains the subjects of the verified chain, from the EK
+	// certificate to the manufacturer root.
+	Chain []string `json:"chain"`
+	// RevocationChecked is true if the CRLs of the chain were checked.
+	RevocationChecked bool `json:"revocationChecked"`
+	// PermanentIdentifierBound is true if the permanent identifier was
+	// bound to the EK key hash.
+	PermanentIdentifierBound bool `json:"permanentIdentifierBound"`
+}
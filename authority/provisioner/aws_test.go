@@ -642,11 +642,11 @@ func TestAWS_AuthorizeSign(t *testing.T) {
 		code    int
 		wantErr bool
 	}{
-		{"ok", p1, args{t1, "foo.local"}, 9, http.StatusOK, false},
-		{"ok", p2, args{t2, "instance-id"}, 13, http.StatusOK, false},
-		{"ok", p2, args{t2Hostname, "ip-127-0-0-1.us-west-1.compute.internal"}, 13, http.StatusOK, false},
-		{"ok", p2, args{t2PrivateIP, "127.0.0.1"}, 13, http.StatusOK, false},
-		{"ok", p1, args{t4, "instance-id"}, 9, http.StatusOK, false},
+		{"ok", p1, args{t1, "foo.local"}, 10, http.StatusOK, false},
+		{"ok", p2, args{t2, "instance-id"}, 14, http.StatusOK, false},
+		{"ok", p2, args{t2Hostname, "ip-127-0-0-1.us-west-1.compute.internal"}, 14, http.StatusOK, false},
+		{"ok", p2, args{t2PrivateIP, "127.0.0.1"}, 14, http.StatusOK, false},
+		{"ok", p1, args{t4, "instance-id"}, 10, http.StatusOK, false},
 		{"fail account", p3, args{token: t3}, 0, http.StatusUnauthorized, true},
 		{"fail token", p1, args{token: "token"}, 0, http.StatusUnauthorized, true},
 		{"fail subject", p1, args{token: failSubject}, 0, http.StatusUnauthorized, true},
@@ -678,6 +678,8 @@ func TestAWS_AuthorizeSign(t *testing.T) {
 					switch v := o.(type) {
 					case *AWS:
 					case certificateOptionsFunc:
+					case CertificateTransparency:
+						assert.False(t, bool(v))
 					case *provisionerExtensionOption:
 						assert.Equals(t, v.Type, TypeAWS)
 						assert.Equals(t, v.Name, tt.aws.GetName())
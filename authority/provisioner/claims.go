@@ -28,7 +28,8 @@ type Claims struct {
 	AllowRenewalAfterExpiry *bool `json:"allowRenewalAfterExpiry,omitempty"`
 
 	// Other properties
-	DisableSmallstepExtensions *bool `json:"disableSmallstepExtensions,omitempty"`
+	DisableSmallstepExtensions    *bool `json:"disableSmallstepExtensions,omitempty"`
+	EnableCertificateTransparency *bool `json:"enableCertificateTransparency,omitempty"`
 }
 
 // Claimer is the type that controls claims. It provides an interface around the
@@ -51,21 +52,23 @@ func (c *Claimer) Claims() Claims {
 	allowRenewalAfterExpiry := c.AllowRenewalAfterExpiry()
 	enableSSHCA := c.IsSSHCAEnabled()
 	disableSmallstepExtensions := c.IsDisableSmallstepExtensions()
+	enableCertificateTransparency := c.IsCertificateTransparencyEnabled()
 
 	return Claims{
-		MinTLSDur:                  &Duration{c.MinTLSCertDuration()},
-		MaxTLSDur:                  &Duration{c.MaxTLSCertDuration()},
-		DefaultTLSDur:              &Duration{c.DefaultTLSCertDuration()},
-		MinUserSSHDur:              &Duration{c.MinUserSSHCertDuration()},
-		MaxUserSSHDur:              &Duration{c.MaxUserSSHCertDuration()},
-		DefaultUserSSHDur:          &Duration{c.DefaultUserSSHCertDuration()},
-		MinHostSSHDur:              &Duration{c.MinHostSSHCertDuration()},
-		MaxHostSSHDur:              &Duration{c.MaxHostSSHCertDuration()},
-		DefaultHostSSHDur:          &Duration{c.DefaultHostSSHCertDuration()},
-		EnableSSHCA:                &enableSSHCA,
-		DisableRenewal:             &disableRenewal,
-		AllowRenewalAfterExpiry:    &allowRenewalAfterExpiry,
-		DisableSmallstepExtensions: &disableSmallstepExtensions,
+		MinTLSDur:                     &Duration{c.MinTLSCertDuration()},
+		MaxTLSDur:                     &Duration{c.MaxTLSCertDuration()},
+		DefaultTLSDur:                 &Duration{c.DefaultTLSCertDuration()},
+		MinUserSSHDur:                 &Duration{c.MinUserSSHCertDuration()},
+		MaxUserSSHDur:                 &Duration{c.MaxUserSSHCertDuration()},
+		DefaultUserSSHDur:             &Duration{c.DefaultUserSSHCertDuration()},
+		MinHostSSHDur:                 &Duration{c.MinHostSSHCertDuration()},
+		MaxHostSSHDur:                 &Duration{c.MaxHostSSHCertDuration()},
+		DefaultHostSSHDur:             &Duration{c.DefaultHostSSHCertDuration()},
+		EnableSSHCA:                   &enableSSHCA,
+		DisableRenewal:                &disableRenewal,
+		AllowRenewalAfterExpiry:       &allowRenewalAfterExpiry,
+		DisableSmallstepExtensions:    &disableSmallstepExtensions,
+		EnableCertificateTransparency: &enableCertificateTransparency,
 	}
 }
 
@@ -124,6 +127,15 @@ func (c *Claimer) IsDisableSmallstepExtensions() bool {
 	return *c.claims.DisableSmallstepExtensions
 }
 
+// IsCertificateTransparencyEnabled returns whether X.509 certificates must be
+// submitted to the Certificate Transparency logs configured in the authority.
+func (c *Claimer) IsCertificateTransparencyEnabled() bool {
+	if c.claims == nil || c.claims.EnableCertificateTransparency == nil {
+		return c.global.EnableCertificateTransparency != nil && *c.global.EnableCertificateTransparency
+	}
+	return *c.claims.EnableCertificateTransparency
+}
+
 // AllowRenewalAfterExpiry returns if the renewal flow is authorized if the
 // certificate is expired. If the property is not set within the provisioner
 // then the global value from the authority configuration will be used.
@@ -0,0 +1,250 @@
+package provisioner
+
+import (
+	"context"
+	"crypto"
+	"crypto/x509"
+	"encoding/pem"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/smallstep/linkedca"
+	"go.step.sm/crypto/keyutil"
+	"go.step.sm/crypto/kms"
+	kmsapi "go.step.sm/crypto/kms/apiv1"
+	"go.step.sm/crypto/pemutil"
+)
+
+// CMP is the CMP provisioner type, an entity that can authorize the
+// Lightweight CMP (RFC 9483) enrollment flow.
+//
+// CMP messages are authenticated using a MAC based on a shared secret, or
+// using signatures with certificates issued by the configured roots. Key
+// update and revocation requests are authenticated with signatures using the
+// certificate issued by the CA.
+//
+// Responses to MAC protected requests are protected with the same shared
+// secret. Other responses are signed with the configured signer, or with the
+// intermediate key of the CA if a signer is not configured.
+type CMP struct {
+	*base
+	ID      string `json:"-"`
+	Type    string `json:"type"`
+	Name    string `json:"name"`
+	ForceCN bool   `json:"forceCN,omitempty"`
+
+	// SharedSecret is the secret used to verify and create PBMAC1 protected
+	// messages.
+	SharedSecret string `json:"sharedSecret,omitempty"`
+
+	// Roots is a PEM bundle with the trusted certificates used to verify
+	// signature protected messages.
+	Roots []byte `json:"roots,omitempty"`
+
+	// SignerCertificate is the PEM certificate chain of the key used to sign
+	// responses. The key can be set in PEM format with SignerKeyPEM, or using
+	// a KMS URI with SignerKey.
+	SignerCertificate []byte `json:"signerCertificate,omitempty"`
+	SignerKeyPEM      []byte `json:"signerKeyPEM,omitempty"`
+	SignerKey         string `json:"signerKey,omitempty"`
+	SignerKeyPassword string `json:"signerKeyPassword,omitempty"`
+
+	Options     *Options `json:"options,omitempty"`
+	Claims      *Claims  `json:"claims,omitempty"`
+	ctl         *Controller
+	rootPool    *x509.CertPool
+	signer      crypto.Signer
+	signerChain []*x509.Certificate
+}
+
+// GetID returns the provisioner unique identifier.
+func (s *CMP) GetID() string {
+	if s.ID != "" {
+		return s.ID
+	}
+	return s.GetIDForToken()
+}
+
+// GetIDForToken returns an identifier that will be used to load the provisioner
+// from a token.
+func (s *CMP) GetIDForToken() string {
+	return "cmp/" + s.Name
+}
+
+// GetName returns the name of the provisioner.
+func (s *CMP) GetName() string {
+	return s.Name
+}
+
+// GetType returns the type of provisioner.
+func (s *CMP) GetType() Type {
+	return TypeCMP
+}
+
+// GetEncryptedKey returns the base provisioner encrypted key if it's defined.
+func (s *CMP) GetEncryptedKey() (string, string, bool) {
+	return "", "", false
+}
+
+// GetTokenID returns the identifier of the token.
+func (s *CMP) GetTokenID(string) (string, error) {
+	return "", errors.New("cmp provisioner does not implement GetTokenID")
+}
+
+// GetOptions returns the configured provisioner options.
+func (s *CMP) GetOptions() *Options {
+	return s.Options
+}
+
+// DefaultTLSCertDuration returns the default TLS cert duration enforced by
+// the provisioner.
+func (s *CMP) DefaultTLSCertDuration() time.Duration {
+	return s.ctl.Claimer.DefaultTLSCertDuration()
+}
+
+// Init initializes and validates the fields of a CMP type.
+func (s *CMP) Init(config Config) (err error) {
+	switch {
+	case s.Type == "":
+		return errors.New("provisioner type cannot be empty")
+	case s.Name == "":
+		return errors.New("provisioner name cannot be empty")
+	}
+
+	s.rootPool = nil
+	if len(s.Roots) > 0 {
+		s.rootPool = x509.NewCertPool()
+		var (
+			block *pem.Block
+			rest  = s.Roots
+			count int
+		)
+		for rest != nil {
+			block, rest = pem.Decode(rest)
+			if block == nil {
+				break
+			}
+			cert, err := x509.ParseCertificate(block.Bytes)
+			if err != nil {
+				return errors.Wrap(err, "error parsing x509 certificate from PEM block")
+			}
+			count++
+			s.rootPool.AddCert(cert)
+		}
+		if count == 0 {
+			return errors.Errorf("no x509 certificates found in roots attribute for provisioner '%s'", s.GetName())
+		}
+	}
+
+	if err := s.initSigner(); err != nil {
+		return err
+	}
+
+	s.ctl, err = NewController(s, s.Claims, config, s.Options)
+	return
+}
+
+// initSigner loads the signer used in the responses.
+func (s *CMP) initSigner() (err error) {
+	s.signer, s.signerChain = nil, nil
+	hasKey := len(s.SignerKeyPEM) > 0 || s.SignerKey != ""
+	switch {
+	case len(s.SignerCertificate) == 0 && !hasKey:
+		return nil
+	case len(s.SignerCertificate) == 0:
+		return errors.New("provisioner signer certificate cannot be empty if a signer key is set")
+	case !hasKey:
+		return errors.New("provisioner signer key cannot be empty if a signer certificate is set")
+	case len(s.SignerKeyPEM) > 0 && s.SignerKey != "":
+		return errors.New("provisioner signer key cannot be set both as PEM and as URI")
+	}
+
+	if s.signerChain, err = pemutil.ParseCertificateBundle(s.SignerCertificate); err != nil {
+		return errors.Wrap(err, "error parsing signer certificate")
+	}
+
+	opts := kms.Options{Type: kmsapi.SoftKMS}
+	req := &kmsapi.CreateSignerRequest{
+		SigningKeyPEM:    s.SignerKeyPEM,
+		Password:         []byte(s.SignerKeyPassword),
+		PasswordPrompter: kmsapi.NonInteractivePasswordPrompter,
+	}
+	if s.SignerKey != "" {
+		kmsType, err := kmsapi.TypeOf(s.SignerKey)
+		if err != nil {
+			return errors.Wrap(err, "error parsing signer key")
+		}
+		if kmsType != kmsapi.DefaultKMS {
+			opts.Type = kmsType
+		}
+		opts.URI = s.SignerKey
+		req.SigningKey = s.SignerKey
+	}
+	km, err := kms.New(context.Background(), opts)
+	if err != nil {
+		return errors.Wrap(err, "error initializing kms")
+	}
+	if s.signer, err = km.CreateSigner(req); err != nil {
+		return errors.Wrap(err, "error creating signer")
+	}
+	if !keyutil.Equal(s.signer.Public(), s.signerChain[0].PublicKey) {
+		return errors.New("mismatch between signer certificate and signer public keys")
+	}
+	return nil
+}
+
+// AuthorizeSign does not do any verification, because the authentication is
+// handled by the CMP server. This method returns a list of modifiers /
+// constraints on the resulting certificate.
+func (s *CMP) AuthorizeSign(context.Context, string) ([]SignOption, error) {
+	return []SignOption{
+		s,
+		// modifiers / withOptions
+		newProvisionerExtensionOption(TypeCMP, s.Name, "").WithControllerOptions(s.ctl),
+		newCertificateTransparencyOption(s.ctl),
+		newForceCNOption(s.ForceCN),
+		profileDefaultDuration(s.ctl.Claimer.DefaultTLSCertDuration()),
+		// validators
+		newValidityValidator(s.ctl.Claimer.MinTLSCertDuration(), s.ctl.Claimer.MaxTLSCertDuration()),
+		newX509NamePolicyValidator(s.ctl.getPolicy().getX509()),
+		s.ctl.newWebhookController(nil, linkedca.Webhook_X509),
+	}, nil
+}
+
+// GetSharedSecret returns the secret used in MAC protected messages. It
+// returns nil if MAC protection is not enabled.
+func (s *CMP) GetSharedSecret() []byte {
+	if s.SharedSecret == "" {
+		return nil
+	}
+	return []byte(s.SharedSecret)
+}
+
+// GetSigner returns the signer and the certificate chain used to sign the
+// responses. It returns a nil signer if they are not configured.
+func (s *CMP) GetSigner() (crypto.Signer, []*x509.Certificate) {
+	return s.signer, s.signerChain
+}
+
+// VerifyCertificate verifies that the certificate used to sign a message
+// chains to the configured roots. The intermediates are the extra
+// certificates sent in the message.
+func (s *CMP) VerifyCertificate(cert *x509.Certificate, intermediates []*x509.Certificate) error {
+	if s.rootPool == nil {
+		return errors.New("signature protection is not enabled")
+	}
+
+	pool := x509.NewCertPool()
+	for _, c := range intermediates {
+		pool.AddCert(c)
+	}
+	if _, err := cert.Verify(x509.VerifyOptions{
+		Roots:         s.rootPool,
+		Intermediates: pool,
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageAny},
+	}); err != nil {
+		return errors.Wrap(err, "error verifying certificate")
+	}
+	return nil
+}
@@ -0,0 +1,111 @@
+package provisioner
+
+import (
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.step.sm/crypto/keyutil"
+	"go.step.sm/crypto/minica"
+	"go.step.sm/crypto/pemutil"
+)
+
+func TestCMP_Init(t *testing.T) {
+	ca, err := minica.New()
+	require.NoError(t, err)
+	signer, err := keyutil.GenerateDefaultSigner()
+	require.NoError(t, err)
+	cert, err := ca.Sign(&x509.Certificate{
+		Subject:   pkix.Name{CommonName: "CMP Responder"},
+		PublicKey: signer.Public(),
+	})
+	require.NoError(t, err)
+
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw})
+	rootPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ca.Root.Raw})
+	block, err := pemutil.Serialize(signer)
+	require.NoError(t, err)
+	keyPEM := pem.EncodeToMemory(block)
+	keyPath := filepath.Join(t.TempDir(), "signer.key")
+	require.NoError(t, os.WriteFile(keyPath, keyPEM, 0600))
+	otherKey, err := keyutil.GenerateDefaultSigner()
+	require.NoError(t, err)
+	block, err = pemutil.Serialize(otherKey)
+	require.NoError(t, err)
+	otherKeyPEM := pem.EncodeToMemory(block)
+
+	tests := []struct {
+		name    string
+		s       *CMP
+		wantErr bool
+	}{
+		{"ok", &CMP{Type: "CMP", Name: "cmp"}, false},
+		{"ok shared secret", &CMP{Type: "CMP", Name: "cmp", SharedSecret: "secret"}, false},
+		{"ok roots", &CMP{Type: "CMP", Name: "cmp", Roots: rootPEM}, false},
+		{"ok signer PEM", &CMP{Type: "CMP", Name: "cmp", SignerCertificate: certPEM, SignerKeyPEM: keyPEM}, false},
+		{"ok signer URI", &CMP{Type: "CMP", Name: "cmp", SignerCertificate: certPEM, SignerKey: "softkms:path=" + keyPath}, false},
+		{"fail type", &CMP{Type: "", Name: "cmp"}, true},
+		{"fail name", &CMP{Type: "CMP", Name: ""}, true},
+		{"fail roots", &CMP{Type: "CMP", Name: "cmp", Roots: []byte("not a pem")}, true},
+		{"fail signer key", &CMP{Type: "CMP", Name: "cmp", SignerCertificate: certPEM}, true},
+		{"fail signer certificate", &CMP{Type: "CMP", Name: "cmp", SignerKeyPEM: keyPEM}, true},
+		{"fail signer PEM and URI", &CMP{Type: "CMP", Name: "cmp", SignerCertificate: certPEM, SignerKeyPEM: keyPEM, SignerKey: keyPath}, true},
+		{"fail signer mismatch", &CMP{Type: "CMP", Name: "cmp", SignerCertificate: certPEM, SignerKeyPEM: otherKeyPEM}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.s.Init(Config{Claims: globalProvisionerClaims}); (err != nil) != tt.wantErr {
+				t.Errorf("CMP.Init() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestCMP_UnmarshalJSON(t *testing.T) {
+	var l List
+	require.NoError(t, json.Unmarshal([]byte(`[{
+		"type": "CMP", "name": "cmp", "sharedSecret": "secret", "forceCN": true
+	}]`), &l))
+	require.Len(t, l, 1)
+
+	p, ok := l[0].(*CMP)
+	require.True(t, ok)
+	require.NoError(t, p.Init(Config{Claims: globalProvisionerClaims}))
+	assert.Equal(t, TypeCMP, p.GetType())
+	assert.Equal(t, "cmp/cmp", p.GetID())
+	assert.Equal(t, []byte("secret"), p.GetSharedSecret())
+	assert.True(t, p.ForceCN)
+	signer, chain := p.GetSigner()
+	assert.Nil(t, signer)
+	assert.Nil(t, chain)
+}
+
+func TestCMP_VerifyCertificate(t *testing.T) {
+	ca, err := minica.New()
+	require.NoError(t, err)
+	other, err := minica.New()
+	require.NoError(t, err)
+	signer, err := keyutil.GenerateDefaultSigner()
+	require.NoError(t, err)
+	cert, err := ca.Sign(&x509.Certificate{
+		Subject:   pkix.Name{CommonName: "device"},
+		PublicKey: signer.Public(),
+	})
+	require.NoError(t, err)
+
+	p := &CMP{Type: "CMP", Name: "cmp", Roots: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ca.Root.Raw})}
+	require.NoError(t, p.Init(Config{Claims: globalProvisionerClaims}))
+	assert.NoError(t, p.VerifyCertificate(cert, []*x509.Certificate{ca.Intermediate}))
+	assert.Error(t, p.VerifyCertificate(cert, nil))
+	assert.Error(t, p.VerifyCertificate(other.Intermediate, []*x509.Certificate{ca.Intermediate}))
+
+	p = &CMP{Type: "CMP", Name: "cmp", SharedSecret: "secret"}
+	require.NoError(t, p.Init(Config{Claims: globalProvisionerClaims}))
+	assert.Error(t, p.VerifyCertificate(cert, []*x509.Certificate{ca.Intermediate}))
+}
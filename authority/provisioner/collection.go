@@ -250,6 +250,11 @@ func (c *Collection) Remove(id string) error {
 		c.byKey.Delete(kid)
 	}
 
+	// Stop the background tasks of the provisioner.
+	if closer, ok := prov.(interface{ Close() }); ok {
+		closer.Close()
+	}
+
 	return nil
 }
 
@@ -0,0 +1,107 @@
+package provisioner
+
+import (
+	"context"
+	"crypto/x509"
+	"fmt"
+	"slices"
+
+	"go.step.sm/crypto/x509util"
+)
+
+// CertificateRequestSignOptions returns the sign options used to sign a
+// certificate request with the provisioners that authenticate the request
+// outside the token flow, like the SCEP, EST and CMP provisioners. The
+// template data is created from the certificate request and modified by the
+// given options, and the certificate validators in the options are added to
+// the returned sign options.
+//
+// The context should be created with NewContextWithMethod and the SignMethod.
+func CertificateRequestSignOptions(ctx context.Context, p Interface, o *Options, csr *x509.CertificateRequest, signCSROpts ...SignCSROption) ([]SignOption, error) {
+	// Template data
+	sans := []string{}
+	sans = append(sans, csr.DNSNames...)
+	sans = append(sans, csr.EmailAddresses...)
+	for _, v := range csr.IPAddresses {
+		sans = append(sans, v.String())
+	}
+	for _, v := range csr.URIs {
+		sans = append(sans, v.String())
+	}
+	if len(sans) == 0 {
+		sans = append(sans, csr.Subject.CommonName)
+	}
+	data := x509util.CreateTemplateData(csr.Subject.CommonName, sans)
+	data.SetCertificateRequest(csr)
+	data.SetSubject(x509util.Subject{
+		Country:            csr.Subject.Country,
+		Organization:       csr.Subject.Organization,
+		OrganizationalUnit: csr.Subject.OrganizationalUnit,
+		Locality:           csr.Subject.Locality,
+		Province:           csr.Subject.Province,
+		StreetAddress:      csr.Subject.StreetAddress,
+		PostalCode:         csr.Subject.PostalCode,
+		SerialNumber:       csr.Subject.SerialNumber,
+		CommonName:         csr.Subject.CommonName,
+	})
+
+	// Apply CSR options. Template data modifiers are applied to the template
+	// data, and certificate validators are added to the sign options.
+	var validators []SignOption
+	for _, opt := range signCSROpts {
+		if m, ok := opt.(TemplateDataModifier); ok {
+			m.Modify(data)
+		}
+		if v, ok := opt.(CertificateValidator); ok {
+			validators = append(validators, v)
+		}
+	}
+
+	signOps, err := p.AuthorizeSign(ctx, "")
+	if err != nil {
+		return nil, fmt.Errorf("error retrieving authorization options from %s provisioner: %w", p.GetType(), err)
+	}
+	// These provisioners don't define the templates in AuthorizeSign, and the
+	// template data used in webhooks is not available there.
+	for _, signOp := range signOps {
+		if wc, ok := signOp.(*WebhookController); ok {
+			wc.TemplateData = data
+		}
+	}
+
+	templateOptions, err := TemplateOptions(o, data)
+	if err != nil {
+		return nil, fmt.Errorf("error creating template options from %s provisioner: %w", p.GetType(), err)
+	}
+	signOps = append(signOps, templateOptions)
+	return append(signOps, validators...), nil
+}
+
+// SameCertificateNames returns true if the certificate request has the same
+// subject and subject alternative names as the certificate.
+func SameCertificateNames(csr *x509.CertificateRequest, cert *x509.Certificate) bool {
+	sameStrings := func(a, b []string) bool {
+		a, b = slices.Clone(a), slices.Clone(b)
+		slices.Sort(a)
+		slices.Sort(b)
+		return slices.Equal(a, b)
+	}
+	var csrIPs, certIPs, csrURIs, certURIs []string
+	for _, ip := range csr.IPAddresses {
+		csrIPs = append(csrIPs, ip.String())
+	}
+	for _, ip := range cert.IPAddresses {
+		certIPs = append(certIPs, ip.String())
+	}
+	for _, u := range csr.URIs {
+		csrURIs = append(csrURIs, u.String())
+	}
+	for _, u := range cert.URIs {
+		certURIs = append(certURIs, u.String())
+	}
+	return csr.Subject.String() == cert.Subject.String() &&
+		sameStrings(csr.DNSNames, cert.DNSNames) &&
+		sameStrings(csr.EmailAddresses, cert.EmailAddresses) &&
+		sameStrings(csrIPs, certIPs) &&
+		sameStrings(csrURIs, certURIs)
+}
@@ -0,0 +1,88 @@
+package provisioner
+
+import (
+	"context"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"net"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.step.sm/crypto/keyutil"
+	"go.step.sm/crypto/x509util"
+)
+
+func TestCertificateRequestSignOptions(t *testing.T) {
+	p := &EST{Type: "EST", Name: "est", Options: &Options{
+		X509: &X509Options{Template: `{"subject": {{ toJson .Subject }}, "sans": {{ toJson .SANs }}, "keyUsage": ["digitalSignature"]}`},
+	}}
+	require.NoError(t, p.Init(Config{Claims: globalProvisionerClaims}))
+
+	key, err := keyutil.GenerateDefaultSigner()
+	require.NoError(t, err)
+	csr, err := x509util.CreateCertificateRequest("router1", []string{"router1.example.com"}, key)
+	require.NoError(t, err)
+
+	validator := newValidityValidator(time.Minute, time.Hour)
+	modifier := TemplateDataModifierFunc(func(data x509util.TemplateData) {
+		data.SetSANs([]string{"router1.example.com", "router1.internal"})
+	})
+	ctx := NewContextWithMethod(context.Background(), SignMethod)
+	signOps, err := CertificateRequestSignOptions(ctx, p, p.GetOptions(), csr, validator, modifier)
+	require.NoError(t, err)
+
+	var templateOptions CertificateOptions
+	for _, o := range signOps {
+		if co, ok := o.(CertificateOptions); ok {
+			templateOptions = co
+		}
+	}
+	require.NotNil(t, templateOptions)
+	cert, err := x509util.NewCertificate(csr, templateOptions.Options(SignOptions{})...)
+	require.NoError(t, err)
+	assert.Equal(t, "router1", cert.GetCertificate().Subject.CommonName)
+	assert.Equal(t, []string{"router1.example.com", "router1.internal"}, cert.GetCertificate().DNSNames)
+
+	// Validators are added to the sign options.
+	assert.Equal(t, validator, signOps[len(signOps)-1])
+}
+
+func TestSameCertificateNames(t *testing.T) {
+	csr := &x509.CertificateRequest{
+		Subject:        pkix.Name{CommonName: "router1"},
+		DNSNames:       []string{"router1", "router1.example.com"},
+		EmailAddresses: []string{"router1@example.com"},
+		IPAddresses:    []net.IP{net.ParseIP("10.0.0.1")},
+		URIs:           []*url.URL{{Scheme: "spiffe", Host: "example.com", Path: "/router1"}},
+	}
+	newCert := func(fn func(cert *x509.Certificate)) *x509.Certificate {
+		cert := &x509.Certificate{
+			Subject:        pkix.Name{CommonName: "router1"},
+			DNSNames:       []string{"router1.example.com", "router1"},
+			EmailAddresses: []string{"router1@example.com"},
+			IPAddresses:    []net.IP{net.ParseIP("10.0.0.1").To4()},
+			URIs:           []*url.URL{{Scheme: "spiffe", Host: "example.com", Path: "/router1"}},
+		}
+		if fn != nil {
+			fn(cert)
+		}
+		return cert
+	}
+
+	assert.True(t, SameCertificateNames(csr, newCert(nil)))
+	assert.False(t, SameCertificateNames(csr, newCert(func(cert *x509.Certificate) {
+		cert.Subject.CommonName = "router2"
+	})))
+	assert.False(t, SameCertificateNames(csr, newCert(func(cert *x509.Certificate) {
+		cert.DNSNames = cert.DNSNames[:1]
+	})))
+	assert.False(t, SameCertificateNames(csr, newCert(func(cert *x509.Certificate) {
+		cert.IPAddresses = []net.IP{net.ParseIP("10.0.0.2")}
+	})))
+	assert.False(t, SameCertificateNames(csr, newCert(func(cert *x509.Certificate) {
+		cert.URIs = nil
+	})))
+}
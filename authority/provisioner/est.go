@@ -0,0 +1,301 @@
+package provisioner
+
+import (
+	"context"
+	"crypto"
+	"crypto/subtle"
+	"crypto/x509"
+	"encoding/asn1"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/smallstep/linkedca"
+	"go.step.sm/crypto/keyutil"
+	"go.step.sm/crypto/x509util"
+
+	"github.com/smallstep/certificates/internal/httptransport"
+	"github.com/smallstep/certificates/webhook"
+)
+
+// ESTAuthWebhookKind is the kind of the webhooks used by EST provisioners to
+// validate the HTTP basic credentials sent by the clients.
+const ESTAuthWebhookKind = "ESTAUTH"
+
+// ErrESTUnauthorized is returned when the credentials of an EST request are
+// not valid.
+var ErrESTUnauthorized = errors.New("est credentials are not valid")
+
+// EST is the EST provisioner type, an entity that can authorize the EST
+// (RFC 7030) enrollment flow.
+//
+// EST requests are authenticated using HTTP basic authentication, with the
+// configured username and password or with ESTAUTH webhooks, or using TLS
+// client certificates issued by the CA.
+type EST struct {
+	*base
+	ID      string `json:"-"`
+	Type    string `json:"type"`
+	Name    string `json:"name"`
+	ForceCN bool   `json:"forceCN,omitempty"`
+
+	// Username and Password are the HTTP basic credentials accepted by the
+	// provisioner. If ESTAUTH webhooks are configured, the credentials are
+	// validated by the webhooks instead.
+	Username string `json:"username,omitempty"`
+	Password string `json:"password,omitempty"`
+
+	// TLSClientAuth allows clients with a TLS client certificate issued by
+	// this provisioner to enroll new certificates with the same names.
+	// Re-enrollment requests are always authenticated with the certificate
+	// being renewed, which must also be issued by this provisioner.
+	TLSClientAuth bool `json:"tlsClientAuth,omitempty"`
+
+	// CSRAttributes is the list of object identifiers returned in csrattrs
+	// responses. Clients should include them in their certificate requests.
+	CSRAttributes []x509util.ObjectIdentifier `json:"csrAttributes,omitempty"`
+
+	// ServerKeyGen enables the serverkeygen operation, with the type of the
+	// keys generated by the CA.
+	ServerKeyGen *ESTServerKeyGen `json:"serverKeyGen,omitempty"`
+
+	Options       *Options `json:"options,omitempty"`
+	Claims        *Claims  `json:"claims,omitempty"`
+	ctl           *Controller
+	authenticator *estAuthController
+}
+
+// ESTServerKeyGen is the type of the keys generated in EST serverkeygen
+// requests. It defaults to an EC P-256 key.
+type ESTServerKeyGen struct {
+	KeyType string `json:"kty,omitempty"`
+	Curve   string `json:"crv,omitempty"`
+	Size    int    `json:"size,omitempty"`
+}
+
+func (k *ESTServerKeyGen) keyParams() (kty, crv string, size int) {
+	kty, crv, size = k.KeyType, k.Curve, k.Size
+	switch {
+	case kty == "":
+		return "EC", "P-256", 0
+	case kty == "EC" && crv == "":
+		crv = "P-256"
+	case kty == "OKP" && crv == "":
+		crv = "Ed25519"
+	case kty == "RSA" && size == 0:
+		size = 2048
+	}
+	return
+}
+
+// GetID returns the provisioner unique identifier.
+func (s *EST) GetID() string {
+	if s.ID != "" {
+		return s.ID
+	}
+	return s.GetIDForToken()
+}
+
+// GetIDForToken returns an identifier that will be used to load the provisioner
+// from a token.
+func (s *EST) GetIDForToken() string {
+	return "est/" + s.Name
+}
+
+// GetName returns the name of the provisioner.
+func (s *EST) GetName() string {
+	return s.Name
+}
+
+// GetType returns the type of provisioner.
+func (s *EST) GetType() Type {
+	return TypeEST
+}
+
+// GetEncryptedKey returns the base provisioner encrypted key if it's defined.
+func (s *EST) GetEncryptedKey() (string, string, bool) {
+	return "", "", false
+}
+
+// GetTokenID returns the identifier of the token.
+func (s *EST) GetTokenID(string) (string, error) {
+	return "", errors.New("est provisioner does not implement GetTokenID")
+}
+
+// GetOptions returns the configured provisioner options.
+func (s *EST) GetOptions() *Options {
+	return s.Options
+}
+
+// DefaultTLSCertDuration returns the default TLS cert duration enforced by
+// the provisioner.
+func (s *EST) DefaultTLSCertDuration() time.Duration {
+	return s.ctl.Claimer.DefaultTLSCertDuration()
+}
+
+// Init initializes and validates the fields of an EST type.
+func (s *EST) Init(config Config) (err error) {
+	switch {
+	case s.Type == "":
+		return errors.New("provisioner type cannot be empty")
+	case s.Name == "":
+		return errors.New("provisioner name cannot be empty")
+	case s.Username != "" && s.Password == "":
+		return errors.New("provisioner password cannot be empty if a username is set")
+	}
+
+	if s.ServerKeyGen != nil {
+		switch kty, _, _ := s.ServerKeyGen.keyParams(); kty {
+		case "EC", "RSA", "OKP":
+		default:
+			return errors.Errorf("server key generation key type %q is not supported", kty)
+		}
+	}
+
+	s.authenticator = newESTAuthController(
+		config.WebhookClient,
+		config.WrapTransport,
+		s.GetOptions().GetWebhooks(),
+	)
+
+	s.ctl, err = NewController(s, s.Claims, config, s.Options)
+	return
+}
+
+// AuthorizeSign does not do any verification, because the authentication is
+// handled by the EST server. This method returns a list of modifiers /
+// constraints on the resulting certificate.
+func (s *EST) AuthorizeSign(context.Context, string) ([]SignOption, error) {
+	return []SignOption{
+		s,
+		// modifiers / withOptions
+		newProvisionerExtensionOption(TypeEST, s.Name, "").WithControllerOptions(s.ctl),
+		newCertificateTransparencyOption(s.ctl),
+		newForceCNOption(s.ForceCN),
+		profileDefaultDuration(s.ctl.Claimer.DefaultTLSCertDuration()),
+		// validators
+		newValidityValidator(s.ctl.Claimer.MinTLSCertDuration(), s.ctl.Claimer.MaxTLSCertDuration()),
+		newX509NamePolicyValidator(s.ctl.getPolicy().getX509()),
+		s.ctl.newWebhookController(nil, linkedca.Webhook_X509),
+	}, nil
+}
+
+// IsTLSClientAuthEnabled returns true if TLS client certificates issued by the
+// CA can be used to enroll new certificates.
+func (s *EST) IsTLSClientAuthEnabled() bool {
+	return s.TLSClientAuth
+}
+
+// ValidateClientCertificate returns an error if the TLS client certificate of
+// an EST request was not issued by this provisioner.
+func (s *EST) ValidateClientCertificate(cert *x509.Certificate) error {
+	if ext, ok := GetProvisionerExtension(cert); ok && ext.Type == TypeEST && ext.Name == s.Name {
+		return nil
+	}
+	return ErrESTUnauthorized
+}
+
+// IsBasicAuthEnabled returns true if the provisioner accepts HTTP basic
+// credentials.
+func (s *EST) IsBasicAuthEnabled() bool {
+	return s.Password != "" || len(s.authenticator.webhooks) > 0
+}
+
+// ValidateBasicAuth validates the HTTP basic credentials of an EST request. If
+// ESTAUTH webhooks are configured, the credentials are sent to them, otherwise
+// they are compared with the configured username and password.
+func (s *EST) ValidateBasicAuth(ctx context.Context, csr *x509.CertificateRequest, username, password string) ([]SignCSROption, error) {
+	if s.authenticator == nil {
+		return nil, fmt.Errorf("provisioner %q wasn't initialized", s.Name)
+	}
+
+	if len(s.authenticator.webhooks) > 0 {
+		return s.authenticator.Validate(ctx, csr, s.Name, username, password)
+	}
+
+	if s.Password == "" {
+		return nil, ErrESTUnauthorized
+	}
+	usernameOK := subtle.ConstantTimeCompare([]byte(s.Username), []byte(username))
+	passwordOK := subtle.ConstantTimeCompare([]byte(s.Password), []byte(password))
+	if usernameOK&passwordOK == 0 {
+		return nil, ErrESTUnauthorized
+	}
+	return []SignCSROption{}, nil
+}
+
+// GetCSRAttributes returns the object identifiers returned in csrattrs
+// responses.
+func (s *EST) GetCSRAttributes() []asn1.ObjectIdentifier {
+	oids := make([]asn1.ObjectIdentifier, len(s.CSRAttributes))
+	for i, oid := range s.CSRAttributes {
+		oids[i] = asn1.ObjectIdentifier(oid)
+	}
+	return oids
+}
+
+// GenerateKey generates the key used in a serverkeygen request. It fails if
+// server key generation is not enabled.
+func (s *EST) GenerateKey() (crypto.Signer, error) {
+	if s.ServerKeyGen == nil {
+		return nil, errors.New("server key generation is not enabled")
+	}
+	kty, crv, size := s.ServerKeyGen.keyParams()
+	return keyutil.GenerateSigner(kty, crv, size)
+}
+
+type estAuthController struct {
+	client        *http.Client
+	wrapTransport httptransport.Wrapper
+	webhooks      []*Webhook
+}
+
+// newESTAuthController creates a new estAuthController that validates the
+// credentials of EST requests through webhooks.
+func newESTAuthController(client *http.Client, tw httptransport.Wrapper, webhooks []*Webhook) *estAuthController {
+	estHooks := []*Webhook{}
+	for _, wh := range webhooks {
+		if wh.Kind != ESTAuthWebhookKind {
+			continue
+		}
+		if !isCertTypeOK(wh) {
+			continue
+		}
+		estHooks = append(estHooks, wh)
+	}
+	return &estAuthController{
+		client:        client,
+		wrapTransport: tw,
+		webhooks:      estHooks,
+	}
+}
+
+// Validate executes the configured webhooks to validate the credentials of
+// an EST request. If at least one of them allows the request, validation
+// succeeds and the other webhooks are skipped.
+func (c *estAuthController) Validate(ctx context.Context, csr *x509.CertificateRequest, provisionerName, username, password string) ([]SignCSROption, error) {
+	for _, wh := range c.webhooks {
+		req, err := webhook.NewRequestBody(webhook.WithX509CertificateRequest(csr))
+		if err != nil {
+			return nil, fmt.Errorf("failed creating new webhook request: %w", err)
+		}
+		req.ProvisionerName = provisionerName
+		req.ESTUsername = username
+		req.ESTPassword = password
+		resp, err := wh.DoWithContext(ctx, c.client, c.wrapTransport, req, nil)
+		if err != nil {
+			return nil, fmt.Errorf("failed executing webhook request: %w", err)
+		}
+		if resp.Allow {
+			return []SignCSROption{
+				TemplateDataModifierFunc(func(data x509util.TemplateData) {
+					data.SetWebhook(wh.Name, resp.Data)
+				}),
+			}, nil
+		}
+	}
+
+	return nil, ErrESTUnauthorized
+}
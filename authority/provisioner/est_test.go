@@ -0,0 +1,190 @@
+package provisioner
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/smallstep/linkedca"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.step.sm/crypto/x509util"
+)
+
+func TestEST_Init(t *testing.T) {
+	tests := []struct {
+		name    string
+		s       *EST
+		wantErr bool
+	}{
+		{"ok", &EST{Type: "EST", Name: "est"}, false},
+		{"ok basic auth", &EST{Type: "EST", Name: "est", Username: "device", Password: "password"}, false},
+		{"ok server key generation", &EST{Type: "EST", Name: "est", ServerKeyGen: &ESTServerKeyGen{KeyType: "RSA", Size: 3072}}, false},
+		{"fail type", &EST{Type: "", Name: "est"}, true},
+		{"fail name", &EST{Type: "EST", Name: ""}, true},
+		{"fail password", &EST{Type: "EST", Name: "est", Username: "device"}, true},
+		{"fail server key generation", &EST{Type: "EST", Name: "est", ServerKeyGen: &ESTServerKeyGen{KeyType: "oct"}}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.s.Init(Config{Claims: globalProvisionerClaims}); (err != nil) != tt.wantErr {
+				t.Errorf("EST.Init() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestEST_UnmarshalJSON(t *testing.T) {
+	var l List
+	require.NoError(t, json.Unmarshal([]byte(`[{
+		"type": "EST", "name": "est", "username": "device", "password": "password",
+		"tlsClientAuth": true, "csrAttributes": ["1.2.840.113549.1.9.7", "1.3.6.1.1.1.1.22"],
+		"serverKeyGen": {"kty": "EC", "crv": "P-384"}
+	}]`), &l))
+	require.Len(t, l, 1)
+
+	p, ok := l[0].(*EST)
+	require.True(t, ok)
+	require.NoError(t, p.Init(Config{Claims: globalProvisionerClaims}))
+	assert.Equal(t, TypeEST, p.GetType())
+	assert.Equal(t, "est/est", p.GetID())
+	assert.True(t, p.IsBasicAuthEnabled())
+	assert.True(t, p.IsTLSClientAuthEnabled())
+	assert.Equal(t, []asn1.ObjectIdentifier{
+		{1, 2, 840, 113549, 1, 9, 7},
+		{1, 3, 6, 1, 1, 1, 1, 22},
+	}, p.GetCSRAttributes())
+}
+
+func TestEST_ValidateBasicAuth(t *testing.T) {
+	csr := &x509.CertificateRequest{Raw: []byte{1}}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			ProvisionerName string `json:"provisionerName"`
+			Username        string `json:"estUsername"`
+			Password        string `json:"estPassword"`
+		}
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&req))
+		assert.Equal(t, "est", req.ProvisionerName)
+		allow := req.Username == "device" && req.Password == "webhook-password"
+		require.NoError(t, json.NewEncoder(w).Encode(map[string]any{
+			"allow": allow,
+			"data":  map[string]any{"ID": "device-1"},
+		}))
+	}))
+	t.Cleanup(srv.Close)
+
+	static := &EST{Type: "EST", Name: "est", Username: "device", Password: "password"}
+	require.NoError(t, static.Init(Config{Claims: globalProvisionerClaims}))
+	hooks := &EST{Type: "EST", Name: "est", Options: &Options{
+		Webhooks: []*Webhook{{
+			ID:       "webhook-id",
+			Name:     "devices",
+			URL:      srv.URL,
+			Kind:     ESTAuthWebhookKind,
+			CertType: linkedca.Webhook_X509.String(),
+		}},
+	}}
+	require.NoError(t, hooks.Init(Config{Claims: globalProvisionerClaims, WebhookClient: srv.Client()}))
+	disabled := &EST{Type: "EST", Name: "est", TLSClientAuth: true}
+	require.NoError(t, disabled.Init(Config{Claims: globalProvisionerClaims}))
+	assert.False(t, disabled.IsBasicAuthEnabled())
+
+	ctx := context.Background()
+	tests := []struct {
+		name     string
+		p        *EST
+		username string
+		password string
+		wantData x509util.TemplateData
+		wantErr  bool
+	}{
+		{"ok static", static, "device", "password", x509util.TemplateData{}, false},
+		{"ok webhook", hooks, "device", "webhook-password", x509util.TemplateData{
+			x509util.WebhooksKey: map[string]any{"devices": map[string]any{"ID": "device-1"}},
+		}, false},
+		{"fail static username", static, "other", "password", nil, true},
+		{"fail static password", static, "device", "bad-password", nil, true},
+		{"fail webhook", hooks, "device", "password", nil, true},
+		{"fail disabled", disabled, "", "", nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opts, err := tt.p.ValidateBasicAuth(ctx, csr, tt.username, tt.password)
+			if tt.wantErr {
+				assert.ErrorIs(t, err, ErrESTUnauthorized)
+				return
+			}
+			require.NoError(t, err)
+			data := x509util.TemplateData{}
+			for _, o := range opts {
+				o.(TemplateDataModifier).Modify(data)
+			}
+			assert.Equal(t, tt.wantData, data)
+		})
+	}
+}
+
+func TestEST_ValidateClientCertificate(t *testing.T) {
+	p := &EST{Type: "EST", Name: "est", TLSClientAuth: true}
+	require.NoError(t, p.Init(Config{Claims: globalProvisionerClaims}))
+
+	newCert := func(ext *Extension) *x509.Certificate {
+		cert := &x509.Certificate{}
+		if ext != nil {
+			e, err := ext.ToExtension()
+			require.NoError(t, err)
+			cert.Extensions = append(cert.Extensions, e)
+		}
+		return cert
+	}
+
+	assert.NoError(t, p.ValidateClientCertificate(newCert(&Extension{Type: TypeEST, Name: "est"})))
+	assert.ErrorIs(t, p.ValidateClientCertificate(newCert(&Extension{Type: TypeEST, Name: "other"})), ErrESTUnauthorized)
+	assert.ErrorIs(t, p.ValidateClientCertificate(newCert(&Extension{Type: TypeJWK, Name: "est"})), ErrESTUnauthorized)
+	assert.ErrorIs(t, p.ValidateClientCertificate(newCert(nil)), ErrESTUnauthorized)
+}
+
+func TestEST_GenerateKey(t *testing.T) {
+	tests := []struct {
+		name   string
+		keyGen *ESTServerKeyGen
+		assert func(t *testing.T, key any)
+	}{
+		{"default", &ESTServerKeyGen{}, func(t *testing.T, key any) {
+			k, ok := key.(*ecdsa.PrivateKey)
+			require.True(t, ok)
+			assert.Equal(t, "P-256", k.Curve.Params().Name)
+		}},
+		{"rsa", &ESTServerKeyGen{KeyType: "RSA"}, func(t *testing.T, key any) {
+			k, ok := key.(*rsa.PrivateKey)
+			require.True(t, ok)
+			assert.Equal(t, 2048, k.N.BitLen())
+		}},
+		{"okp", &ESTServerKeyGen{KeyType: "OKP"}, func(t *testing.T, key any) {
+			_, ok := key.(ed25519.PrivateKey)
+			assert.True(t, ok)
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := &EST{Type: "EST", Name: "est", ServerKeyGen: tt.keyGen}
+			require.NoError(t, p.Init(Config{Claims: globalProvisionerClaims}))
+			key, err := p.GenerateKey()
+			require.NoError(t, err)
+			tt.assert(t, key)
+		})
+	}
+
+	p := &EST{Type: "EST", Name: "est"}
+	require.NoError(t, p.Init(Config{Claims: globalProvisionerClaims}))
+	_, err := p.GenerateKey()
+	assert.Error(t, err)
+}
@@ -525,9 +525,9 @@ func TestGCP_AuthorizeSign(t *testing.T) {
 		code    int
 		wantErr bool
 	}{
-		{"ok", p1, args{t1}, 8, http.StatusOK, false},
-		{"ok", p2, args{t2}, 13, http.StatusOK, false},
-		{"ok", p3, args{t3}, 8, http.StatusOK, false},
+		{"ok", p1, args{t1}, 9, http.StatusOK, false},
+		{"ok", p2, args{t2}, 14, http.StatusOK, false},
+		{"ok", p3, args{t3}, 9, http.StatusOK, false},
 		{"fail token", p1, args{"token"}, 0, http.StatusUnauthorized, true},
 		{"fail key", p1, args{failKey}, 0, http.StatusUnauthorized, true},
 		{"fail iss", p1, args{failIss}, 0, http.StatusUnauthorized, true},
@@ -559,6 +559,8 @@ func TestGCP_AuthorizeSign(t *testing.T) {
 					switch v := o.(type) {
 					case *GCP:
 					case certificateOptionsFunc:
+					case CertificateTransparency:
+						assert.False(t, bool(v))
 					case *provisionerExtensionOption:
 						assert.Equals(t, v.Type, TypeGCP)
 						assert.Equals(t, v.Name, tt.gcp.GetName())
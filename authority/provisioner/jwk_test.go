@@ -312,11 +312,13 @@ func TestJWK_AuthorizeSign(t *testing.T) {
 				}
 			} else {
 				if assert.NotNil(t, got) {
-					assert.Equals(t, 11, len(got))
+					assert.Equals(t, 12, len(got))
 					for _, o := range got {
 						switch v := o.(type) {
 						case *JWK:
 						case certificateOptionsFunc:
+						case CertificateTransparency:
+							assert.False(t, bool(v))
 						case *provisionerExtensionOption:
 							assert.Equals(t, v.Type, TypeJWK)
 							assert.Equals(t, v.Name, tt.prov.GetName())
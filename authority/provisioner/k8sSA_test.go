@@ -284,6 +284,8 @@ func TestK8sSA_AuthorizeSign(t *testing.T) {
 							switch v := o.(type) {
 							case *K8sSA:
 							case certificateOptionsFunc:
+							case CertificateTransparency:
+								assert.False(t, bool(v))
 							case *provisionerExtensionOption:
 								assert.Equals(t, v.Type, TypeK8sSA)
 								assert.Equals(t, v.Name, tc.p.GetName())
@@ -303,7 +305,7 @@ func TestK8sSA_AuthorizeSign(t *testing.T) {
 								assert.FatalError(t, fmt.Errorf("unexpected sign option of type %T", v))
 							}
 						}
-						assert.Equals(t, 8, len(opts))
+						assert.Equals(t, 9, len(opts))
 					}
 				}
 			}
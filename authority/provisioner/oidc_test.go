@@ -338,11 +338,13 @@ func TestOIDC_AuthorizeSign(t *testing.T) {
 				assert.Equals(t, sc.StatusCode(), tt.code)
 				assert.Nil(t, got)
 			} else if assert.NotNil(t, got) {
-				assert.Equals(t, 8, len(got))
+				assert.Equals(t, 9, len(got))
 				for _, o := range got {
 					switch v := o.(type) {
 					case *OIDC:
 					case certificateOptionsFunc:
+					case CertificateTransparency:
+						assert.False(t, bool(v))
 					case *provisionerExtensionOption:
 						assert.Equals(t, v.Type, TypeOIDC)
 						assert.Equals(t, v.Name, tt.prov.GetName())
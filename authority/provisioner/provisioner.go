@@ -194,6 +194,12 @@ const (
 	TypeSCEP Type = 10
 	// TypeNebula is used to indicate the Nebula provisioners
 	TypeNebula Type = 11
+	// TypeEST is used to indicate the EST provisioners
+	TypeEST Type = 12
+	// TypeCMP is used to indicate the CMP provisioners
+	TypeCMP Type = 13
+	// TypeSPIFFE is used to indicate the SPIFFE provisioners
+	TypeSPIFFE Type = 14
 )
 
 // String returns the string representation of the type.
@@ -221,6 +227,12 @@ func (t Type) String() string {
 		return "SCEP"
 	case TypeNebula:
 		return "Nebula"
+	case TypeEST:
+		return "EST"
+	case TypeCMP:
+		return "CMP"
+	case TypeSPIFFE:
+		return "SPIFFE"
 	default:
 		return ""
 	}
@@ -314,6 +326,12 @@ func (l *List) UnmarshalJSON(data []byte) error {
 			p = &SCEP{}
 		case "nebula":
 			p = &Nebula{}
+		case "est":
+			p = &EST{}
+		case "cmp":
+			p = &CMP{}
+		case "spiffe":
+			p = &SPIFFE{}
 		default:
 			// Skip unsupported provisioners. A client using this method may be
 			// compiled with a version of smallstep/certificates that does not
@@ -44,12 +44,33 @@ type SCEP struct {
 	// MinimumPublicKeyLength is the minimum length for public keys in CSRs
 	MinimumPublicKeyLength int `json:"minimumPublicKeyLength,omitempty"`
 
+	// RequireApproval puts all the requests with a valid challenge in the
+	// pending queue, the certificates are issued after an administrator
+	// approves them.
+	RequireApproval bool `json:"requireApproval,omitempty"`
+
+	// DynamicChallenges enables the challenges created using the admin API.
+	// They are checked before the static challenge password and the
+	// webhooks, and if they are enabled, an empty challenge is never valid.
+	DynamicChallenges bool `json:"dynamicChallenges,omitempty"`
+
 	// TODO(hs): also support a separate signer configuration?
 	DecrypterCertificate []byte `json:"decrypterCertificate,omitempty"`
 	DecrypterKeyPEM      []byte `json:"decrypterKeyPEM,omitempty"`
 	DecrypterKeyURI      string `json:"decrypterKey,omitempty"`
 	DecrypterKeyPassword string `json:"decrypterKeyPassword,omitempty"`
 
+	// NextCACertificate is the PEM encoded CA certificate that will replace
+	// the current one after a CA rollover. It's returned, with the optional
+	// NextRACertificate, in the GetNextCACert responses, so clients can fetch
+	// the new chain before the switch.
+	NextCACertificate []byte `json:"nextCACertificate,omitempty"`
+
+	// NextRACertificate is the PEM encoded RA certificate, issued by the
+	// NextCACertificate, that will be used to sign and decrypt the SCEP
+	// messages after the CA rollover.
+	NextRACertificate []byte `json:"nextRACertificate,omitempty"`
+
 	// Numerical identifier for the ContentEncryptionAlgorithm as defined in github.com/mozilla-services/pkcs7
 	// at https://github.com/mozilla-services/pkcs7/blob/33d05740a3526e382af6395d3513e73d4e66d1cb/encrypt.go#L63
 	// Defaults to 0, being DES-CBC
@@ -65,6 +86,7 @@ type SCEP struct {
 	decrypterCertificate          *x509.Certificate
 	signer                        crypto.Signer
 	signerCertificate             *x509.Certificate
+	nextCACertificates            []*x509.Certificate
 }
 
 // GetID returns the provisioner unique identifier.
@@ -141,6 +163,9 @@ func newChallengeValidationController(client *http.Client, tw httptransport.Wrap
 var (
 	ErrSCEPChallengeInvalid   = errors.New("webhook server did not allow request")
 	ErrSCEPNotificationFailed = errors.New("scep notification failed")
+	// ErrSCEPRequestPending is returned when the challenge is valid, but the
+	// certificate must not be issued until the request is approved.
+	ErrSCEPRequestPending = errors.New("scep request is pending approval")
 )
 
 // Validate executes zero or more configured webhooks to
@@ -148,9 +173,12 @@ var (
 // the challenge value is accepted, validation succeeds. In
 // that case, the other webhooks will be skipped. If none of
 // the webhooks indicates the value of the challenge was accepted,
-// an error is returned.
+// an error is returned. If an accepting webhook marks the request
+// as pending, the options are returned with ErrSCEPRequestPending, so
+// the webhook data can be used when the request is approved.
 func (c *challengeValidationController) Validate(ctx context.Context, csr *x509.CertificateRequest, provisionerName, challenge, transactionID string) ([]SignCSROption, error) {
 	var opts []SignCSROption
+	var pending bool
 
 	for _, wh := range c.webhooks {
 		req, err := webhook.NewRequestBody(webhook.WithX509CertificateRequest(csr))
@@ -165,6 +193,7 @@ func (c *challengeValidationController) Validate(ctx context.Context, csr *x509.
 			return nil, fmt.Errorf("failed executing webhook request: %w", err)
 		}
 		if resp.Allow {
+			pending = pending || resp.Pending
 			opts = append(opts, TemplateDataModifierFunc(func(data x509util.TemplateData) {
 				data.SetWebhook(wh.Name, resp.Data)
 			}))
@@ -174,6 +203,9 @@ func (c *challengeValidationController) Validate(ctx context.Context, csr *x509.
 	if len(opts) == 0 {
 		return nil, ErrSCEPChallengeInvalid
 	}
+	if pending {
+		return opts, ErrSCEPRequestPending
+	}
 
 	return opts, nil
 }
@@ -370,15 +402,8 @@ func (s *SCEP) Init(config Config) (err error) {
 
 	// parse the decrypter certificate contents if available
 	if len(s.DecrypterCertificate) > 0 {
-		block, rest := pem.Decode(s.DecrypterCertificate)
-		if len(rest) > 0 {
-			return errors.New("failed parsing decrypter certificate: trailing data")
-		}
-		if block == nil {
-			return errors.New("failed parsing decrypter certificate: no PEM block found")
-		}
-		if s.decrypterCertificate, err = x509.ParseCertificate(block.Bytes); err != nil {
-			return fmt.Errorf("failed parsing decrypter certificate: %w", err)
+		if s.decrypterCertificate, err = parseSCEPCertificate(s.DecrypterCertificate, "decrypter certificate"); err != nil {
+			return err
 		}
 		// the decrypter certificate is also the signer certificate
 		s.signerCertificate = s.decrypterCertificate
@@ -401,6 +426,11 @@ func (s *SCEP) Init(config Config) (err error) {
 		}
 	}
 
+	// parse the certificates returned in GetNextCACert responses
+	if err := s.initNextCACertificates(); err != nil {
+		return err
+	}
+
 	// TODO: add other, SCEP specific, options?
 
 	s.ctl, err = NewController(s, s.Claims, config, s.Options)
@@ -415,6 +445,7 @@ func (s *SCEP) AuthorizeSign(context.Context, string) ([]SignOption, error) {
 		s,
 		// modifiers / withOptions
 		newProvisionerExtensionOption(TypeSCEP, s.Name, "").WithControllerOptions(s.ctl),
+		newCertificateTransparencyOption(s.ctl),
 		newForceCNOption(s.ForceCN),
 		profileDefaultDuration(s.ctl.Claimer.DefaultTLSCertDuration()),
 		// validators
@@ -455,20 +486,51 @@ func (s *SCEP) GetContentEncryptionAlgorithm() int {
 
 // ValidateChallenge validates the provided challenge. It starts by
 // selecting the validation method to use, then performs validation
-// according to that method.
+// according to that method. If the challenge is valid, but the request
+// requires approval, the options are returned with ErrSCEPRequestPending.
 func (s *SCEP) ValidateChallenge(ctx context.Context, csr *x509.CertificateRequest, challenge, transactionID string) ([]SignCSROption, error) {
 	if s.challengeValidationController == nil {
 		return nil, fmt.Errorf("provisioner %q wasn't initialized", s.Name)
 	}
+
+	var opts []SignCSROption
 	switch s.selectValidationMethod() {
 	case validationMethodWebhook:
-		return s.challengeValidationController.Validate(ctx, csr, s.Name, challenge, transactionID)
+		var err error
+		if opts, err = s.challengeValidationController.Validate(ctx, csr, s.Name, challenge, transactionID); err != nil {
+			if errors.Is(err, ErrSCEPRequestPending) {
+				return opts, err
+			}
+			return nil, err
+		}
+	case validationMethodNone:
+		if s.DynamicChallenges {
+			return nil, errors.New("invalid challenge password provided")
+		}
+		fallthrough
 	default:
 		if subtle.ConstantTimeCompare([]byte(s.ChallengePassword), []byte(challenge)) == 0 {
 			return nil, errors.New("invalid challenge password provided")
 		}
-		return []SignCSROption{}, nil
+		opts = []SignCSROption{}
 	}
+
+	if s.RequireApproval {
+		return opts, ErrSCEPRequestPending
+	}
+	return opts, nil
+}
+
+// ShouldUseDynamicChallenges indicates if the challenges created using the
+// admin API can be used with the provisioner.
+func (s *SCEP) ShouldUseDynamicChallenges() bool {
+	return s.DynamicChallenges
+}
+
+// ShouldRequireApproval indicates if the certificates must be approved by an
+// administrator before they are issued.
+func (s *SCEP) ShouldRequireApproval() bool {
+	return s.RequireApproval
 }
 
 func (s *SCEP) NotifySuccess(ctx context.Context, csr *x509.CertificateRequest, cert *x509.Certificate, transactionID string) error {
@@ -516,6 +578,13 @@ func (s *SCEP) GetDecrypter() (*x509.Certificate, crypto.Decrypter) {
 	return s.decrypterCertificate, s.decrypter
 }
 
+// GetNextCACertificates returns the certificates that will be used after a CA
+// rollover, the RA certificate, if configured, followed by the CA
+// certificate. It returns nil if a next CA certificate is not configured.
+func (s *SCEP) GetNextCACertificates() []*x509.Certificate {
+	return s.nextCACertificates
+}
+
 // GetSigner returns the provisioner specific signer, used to
 // sign SCEP response messages for the client. The signer consists
 // of a crypto.Signer and a certificate for the public key
@@ -523,3 +592,50 @@ func (s *SCEP) GetDecrypter() (*x509.Certificate, crypto.Decrypter) {
 func (s *SCEP) GetSigner() (*x509.Certificate, crypto.Signer) {
 	return s.signerCertificate, s.signer
 }
+
+func (s *SCEP) initNextCACertificates() error {
+	s.nextCACertificates = nil
+	if len(s.NextCACertificate) == 0 {
+		if len(s.NextRACertificate) > 0 {
+			return errors.New("next RA certificate requires a next CA certificate")
+		}
+		return nil
+	}
+
+	ca, err := parseSCEPCertificate(s.NextCACertificate, "next CA certificate")
+	if err != nil {
+		return err
+	}
+	if !ca.BasicConstraintsValid || !ca.IsCA {
+		return errors.New("next CA certificate is not a CA certificate")
+	}
+	if len(s.NextRACertificate) > 0 {
+		ra, err := parseSCEPCertificate(s.NextRACertificate, "next RA certificate")
+		if err != nil {
+			return err
+		}
+		if err := ra.CheckSignatureFrom(ca); err != nil {
+			return fmt.Errorf("next RA certificate is not issued by the next CA certificate: %w", err)
+		}
+		s.nextCACertificates = append(s.nextCACertificates, ra)
+	}
+	s.nextCACertificates = append(s.nextCACertificates, ca)
+	return nil
+}
+
+// parseSCEPCertificate parses a PEM encoded certificate. The name is used in
+// the errors.
+func parseSCEPCertificate(data []byte, name string) (*x509.Certificate, error) {
+	block, rest := pem.Decode(data)
+	if len(rest) > 0 {
+		return nil, fmt.Errorf("failed parsing %s: trailing data", name)
+	}
+	if block == nil {
+		return nil, fmt.Errorf("failed parsing %s: no PEM block found", name)
+	}
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("failed parsing %s: %w", name, err)
+	}
+	return cert, nil
+}
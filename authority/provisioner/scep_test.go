@@ -300,8 +300,9 @@ func TestSCEP_ValidateChallenge(t *testing.T) {
 		TransactionID   string                          `json:"scepTransactionID"`
 	}
 	type response struct {
-		Allow bool `json:"allow"`
-		Data  any  `json:"data"`
+		Allow   bool `json:"allow"`
+		Data    any  `json:"data"`
+		Pending bool `json:"pending,omitempty"`
 	}
 	okServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		req := &request{}
@@ -320,6 +321,7 @@ func TestSCEP_ValidateChallenge(t *testing.T) {
 				"Email": "admin@example.com",
 			}
 		}
+		resp.Pending = r.Header.Get("X-Smallstep-Webhook-Id") == "webhook-id-3"
 		b, err := json.Marshal(resp)
 		require.NoError(t, err)
 		w.WriteHeader(200)
@@ -424,12 +426,41 @@ func TestSCEP_ValidateChallenge(t *testing.T) {
 			Options:           &Options{},
 			ChallengePassword: "",
 		}, nil, args{"", "static-transaction-1"}, x509util.TemplateData{}, nil},
+		{"fail/webhooks-pending", &SCEP{
+			Name: "SCEP",
+			Type: "SCEP",
+			Options: &Options{
+				Webhooks: []*Webhook{
+					{
+						ID:       "webhook-id-3",
+						Name:     "webhook-name-3",
+						Secret:   "MTIzNAo=",
+						Kind:     linkedca.Webhook_SCEPCHALLENGE.String(),
+						CertType: linkedca.Webhook_X509.String(),
+						URL:      okServer.URL,
+					},
+				},
+			},
+		}, okServer, args{"webhook-challenge", "webhook-transaction-1"}, nil, ErrSCEPRequestPending},
+		{"fail/static-challenge-require-approval", &SCEP{
+			Name:              "SCEP",
+			Type:              "SCEP",
+			Options:           &Options{},
+			ChallengePassword: "secret-static-challenge",
+			RequireApproval:   true,
+		}, nil, args{"secret-static-challenge", "static-transaction-1"}, nil, ErrSCEPRequestPending},
 		{"fail/no-challenge-but-provided", &SCEP{
 			Name:              "SCEP",
 			Type:              "SCEP",
 			Options:           &Options{},
 			ChallengePassword: "",
 		}, nil, args{"a-challenge-value", "static-transaction-1"}, nil, errors.New("invalid challenge password provided")},
+		{"fail/dynamic-challenges-empty-challenge", &SCEP{
+			Name:              "SCEP",
+			Type:              "SCEP",
+			Options:           &Options{},
+			DynamicChallenges: true,
+		}, nil, args{"", "static-transaction-1"}, nil, errors.New("invalid challenge password provided")},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -440,6 +471,10 @@ func TestSCEP_ValidateChallenge(t *testing.T) {
 			got, err := tt.p.ValidateChallenge(ctx, dummyCSR, tt.args.challenge, tt.args.transactionID)
 			if tt.expErr != nil {
 				assert.EqualError(t, err, tt.expErr.Error())
+				if errors.Is(tt.expErr, ErrSCEPRequestPending) && tt.server != nil {
+					// The webhook data is kept for the approval.
+					assert.NotEmpty(t, got)
+				}
 				return
 			}
 			assert.NoError(t, err)
@@ -494,6 +529,20 @@ func TestSCEP_Init(t *testing.T) {
 		Type: "CERTIFICATE", Bytes: ca.Intermediate.Raw,
 	})...)
 
+	nextCA, err := minica.New()
+	require.NoError(t, err)
+	nextRA, err := nextCA.Sign(&x509.Certificate{
+		Subject:   pkix.Name{CommonName: "Next SCEP RA"},
+		PublicKey: key.Public(),
+	})
+	require.NoError(t, err)
+	nextCAPEM := pem.EncodeToMemory(&pem.Block{
+		Type: "CERTIFICATE", Bytes: nextCA.Intermediate.Raw,
+	})
+	nextRAPEM := pem.EncodeToMemory(&pem.Block{
+		Type: "CERTIFICATE", Bytes: nextRA.Raw,
+	})
+
 	keyPEM := serialize(key, "password")
 	keyPEMNoPassword := serialize(key, "")
 	badKeyPEM := serialize(badKey, "password")
@@ -574,6 +623,22 @@ func TestSCEP_Init(t *testing.T) {
 			DecrypterKeyPassword:          "",
 			EncryptionAlgorithmIdentifier: 0,
 		}, args{Config{Claims: globalProvisionerClaims}}, false},
+		{"ok next CA", &SCEP{
+			Type:              "SCEP",
+			Name:              "scep",
+			ChallengePassword: "password123",
+			NextCACertificate: nextCAPEM,
+		}, args{Config{Claims: globalProvisionerClaims}}, false},
+		{"ok next CA and RA", &SCEP{
+			Type:                 "SCEP",
+			Name:                 "scep",
+			ChallengePassword:    "password123",
+			DecrypterCertificate: certPEM,
+			DecrypterKeyPEM:      keyPEM,
+			DecrypterKeyPassword: "password",
+			NextCACertificate:    nextCAPEM,
+			NextRACertificate:    nextRAPEM,
+		}, args{Config{Claims: globalProvisionerClaims}}, false},
 		{"fail type", &SCEP{
 			Type:                          "",
 			Name:                          "scep",
@@ -713,6 +778,31 @@ func TestSCEP_Init(t *testing.T) {
 			DecrypterKeyPassword:          "password",
 			EncryptionAlgorithmIdentifier: 0,
 		}, args{Config{Claims: globalProvisionerClaims}}, true},
+		{"fail next RA without next CA", &SCEP{
+			Type:              "SCEP",
+			Name:              "scep",
+			ChallengePassword: "password123",
+			NextRACertificate: nextRAPEM,
+		}, args{Config{Claims: globalProvisionerClaims}}, true},
+		{"fail next CA decode", &SCEP{
+			Type:              "SCEP",
+			Name:              "scep",
+			ChallengePassword: "password123",
+			NextCACertificate: []byte("not a pem"),
+		}, args{Config{Claims: globalProvisionerClaims}}, true},
+		{"fail next CA is not a CA", &SCEP{
+			Type:              "SCEP",
+			Name:              "scep",
+			ChallengePassword: "password123",
+			NextCACertificate: nextRAPEM,
+		}, args{Config{Claims: globalProvisionerClaims}}, true},
+		{"fail next RA issuer", &SCEP{
+			Type:              "SCEP",
+			Name:              "scep",
+			ChallengePassword: "password123",
+			NextCACertificate: nextCAPEM,
+			NextRACertificate: certPEM,
+		}, args{Config{Claims: globalProvisionerClaims}}, true},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -85,6 +85,19 @@ func (fn CertificateEnforcerFunc) Enforce(cert *x509.Certificate) error {
 // sign methods.
 type AttestationData struct {
 	PermanentIdentifier string
+	TPMEKValidation     *TPMEKValidation
+	AndroidDevice       *AndroidDevice
+}
+
+// AndroidDevice contains the properties of an Android device attested by the
+// hardware in an android-key device-attest-01 challenge.
+type AndroidDevice struct {
+	Brand        string `json:"brand,omitempty"`
+	Manufacturer string `json:"manufacturer,omitempty"`
+	Model        string `json:"model,omitempty"`
+	// DeviceIdentifiers contains the attested serial number, IMEIs and MEID
+	// of the device.
+	DeviceIdentifiers []string `json:"deviceIdentifiers,omitempty"`
 }
 
 // defaultPublicKeyValidator validates the public key of a certificate request.
@@ -507,6 +520,17 @@ func (o *provisionerExtensionOption) WithControllerOptions(c *Controller) *provi
 	return o
 }
 
+// CertificateTransparency is a SignOption that indicates whether the
+// certificate must be submitted to the Certificate Transparency logs
+// configured in the authority before being issued.
+type CertificateTransparency bool
+
+// newCertificateTransparencyOption returns the CertificateTransparency option
+// using the EnableCertificateTransparency provisioner claim.
+func newCertificateTransparencyOption(c *Controller) CertificateTransparency {
+	return CertificateTransparency(c.Claimer.IsCertificateTransparencyEnabled())
+}
+
 func (o *provisionerExtensionOption) Modify(cert *x509.Certificate, _ SignOptions) error {
 	if o.Disabled {
 		return nil
@@ -0,0 +1,350 @@
+package provisioner
+
+import (
+	"context"
+	"crypto/x509"
+	"net/http"
+	"net/url"
+	"path"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/smallstep/linkedca"
+	"go.step.sm/crypto/jose"
+	"go.step.sm/crypto/x509util"
+
+	"github.com/smallstep/certificates/errs"
+	"github.com/smallstep/certificates/webhook"
+)
+
+// defaultX509SVIDTemplate is the template used by default by the SPIFFE
+// provisioner. It creates an X.509-SVID with the SPIFFE ID as the only SAN and
+// the key usages allowed by the X.509-SVID specification.
+const defaultX509SVIDTemplate = `{
+	"sans": {{ toJson .SANs }},
+{{- if typeIs "*rsa.PublicKey" .Insecure.CR.PublicKey }}
+	"keyUsage": ["keyEncipherment", "digitalSignature"],
+{{- else }}
+	"keyUsage": ["digitalSignature"],
+{{- end }}
+	"extKeyUsage": ["serverAuth", "clientAuth"],
+	"basicConstraints": {
+		"isCA": false
+	}
+}`
+
+var (
+	// spiffeTrustDomainRegex matches the characters allowed in a trust domain.
+	spiffeTrustDomainRegex = regexp.MustCompile(`^[a-z0-9._-]+$`)
+	// spiffePathSegmentRegex matches the characters allowed in a path segment.
+	spiffePathSegmentRegex = regexp.MustCompile(`^[a-zA-Z0-9._-]+$`)
+)
+
+// jwtSVIDAlgorithms are the signature algorithms allowed in JWT-SVIDs.
+var jwtSVIDAlgorithms = []string{
+	jose.RS256, jose.RS384, jose.RS512,
+	jose.ES256, jose.ES384, jose.ES512,
+	jose.PS256, jose.PS384, jose.PS512,
+}
+
+// SPIFFETrustDomain is a trust domain allowed in a SPIFFE provisioner, and the
+// source of its SPIFFE bundle, a file or a bundle endpoint.
+type SPIFFETrustDomain struct {
+	Name           string `json:"name"`
+	BundleFile     string `json:"bundleFile,omitempty"`
+	BundleEndpoint string `json:"bundleEndpoint,omitempty"`
+}
+
+// SPIFFE is the provisioner that issues X.509-SVIDs to workloads presenting
+// a JWT-SVID. JWT-SVIDs are validated with the JWT authorities of the SPIFFE
+// bundle of the trust domain in the SPIFFE ID. The JWT-SVID audience must be
+// the sign endpoint of the CA with the fragment "#spiffe/<name>".
+type SPIFFE struct {
+	*base
+	ID           string              `json:"-"`
+	Type         string              `json:"type"`
+	Name         string              `json:"name"`
+	TrustDomains []SPIFFETrustDomain `json:"trustDomains"`
+	Paths        []string            `json:"paths,omitempty"`
+	Claims       *Claims             `json:"claims,omitempty"`
+	Options      *Options            `json:"options,omitempty"`
+	bundles      map[string]*spiffeBundle
+	ctl          *Controller
+}
+
+// GetID returns the provisioner unique identifier.
+func (p *SPIFFE) GetID() string {
+	if p.ID != "" {
+		return p.ID
+	}
+	return p.GetIDForToken()
+}
+
+// GetIDForToken returns an identifier that will be used to load the provisioner
+// from a token.
+func (p *SPIFFE) GetIDForToken() string {
+	return "spiffe/" + p.Name
+}
+
+// GetTokenID returns an empty identifier, so the SHA256 of the token is used to
+// prevent the reuse of a JWT-SVID.
+func (p *SPIFFE) GetTokenID(token string) (string, error) {
+	if _, err := jose.ParseSigned(token); err != nil {
+		return "", errors.Wrap(err, "error parsing token")
+	}
+	return "", nil
+}
+
+// GetName returns the name of the provisioner.
+func (p *SPIFFE) GetName() string {
+	return p.Name
+}
+
+// GetType returns the type of provisioner.
+func (p *SPIFFE) GetType() Type {
+	return TypeSPIFFE
+}
+
+// GetEncryptedKey is not available in a SPIFFE provisioner.
+func (p *SPIFFE) GetEncryptedKey() (kid, key string, ok bool) {
+	return "", "", false
+}
+
+// Init initializes and validates the fields of a SPIFFE type.
+func (p *SPIFFE) Init(config Config) (err error) {
+	switch {
+	case p.Type == "":
+		return errors.New("provisioner type cannot be empty")
+	case p.Name == "":
+		return errors.New("provisioner name cannot be empty")
+	case len(p.TrustDomains) == 0:
+		return errors.New("provisioner trustDomains cannot be empty")
+	}
+
+	names := make(map[string]bool, len(p.TrustDomains))
+	for _, td := range p.TrustDomains {
+		switch {
+		case !spiffeTrustDomainRegex.MatchString(td.Name):
+			return errors.Errorf("provisioner trustDomains: invalid trust domain %q", td.Name)
+		case names[td.Name]:
+			return errors.Errorf("provisioner trustDomains: trust domain %q is duplicated", td.Name)
+		case td.BundleFile == "" && td.BundleEndpoint == "":
+			return errors.Errorf("provisioner trustDomains: bundleFile or bundleEndpoint must be set for %q", td.Name)
+		case td.BundleFile != "" && td.BundleEndpoint != "":
+			return errors.Errorf("provisioner trustDomains: bundleFile and bundleEndpoint cannot be set at the same time for %q", td.Name)
+		}
+		if td.BundleEndpoint != "" {
+			u, err := url.Parse(td.BundleEndpoint)
+			if err != nil {
+				return errors.Wrapf(err, "error parsing %s", td.BundleEndpoint)
+			}
+			if u.Scheme != "https" {
+				return errors.Errorf("provisioner trustDomains: bundleEndpoint %q must use https", td.BundleEndpoint)
+			}
+		}
+		names[td.Name] = true
+	}
+	for _, pattern := range p.Paths {
+		if !strings.HasPrefix(pattern, "/") {
+			return errors.Errorf("provisioner paths: pattern %q must start with /", pattern)
+		}
+		if _, err := path.Match(pattern, ""); err != nil {
+			return errors.Wrapf(err, "provisioner paths: invalid pattern %q", pattern)
+		}
+	}
+
+	config.Audiences = config.Audiences.WithFragment(p.GetIDForToken())
+	if p.ctl, err = NewController(p, p.Claims, config, p.Options); err != nil {
+		return err
+	}
+
+	// Bundles are loaded synchronously and reloaded in the background. A bundle
+	// that cannot be loaded starts without keys, so an unavailable bundle
+	// endpoint only rejects the tokens of its trust domain until it can be
+	// loaded.
+	p.Close()
+	p.bundles = make(map[string]*spiffeBundle, len(p.TrustDomains))
+	for _, td := range p.TrustDomains {
+		p.bundles[td.Name] = newSPIFFEBundle(p.ctl.GetHTTPClient(), td.BundleFile, td.BundleEndpoint)
+	}
+	return nil
+}
+
+// Close stops the background reloads of the SPIFFE bundles. It is called when
+// the provisioner is initialized again or removed.
+func (p *SPIFFE) Close() {
+	for _, b := range p.bundles {
+		b.Close()
+	}
+}
+
+// authorizeToken validates the JWT-SVID and returns its SPIFFE ID.
+func (p *SPIFFE) authorizeToken(token string, audiences []string) (*url.URL, error) {
+	jwt, err := jose.ParseSigned(token)
+	if err != nil {
+		return nil, errs.Wrap(http.StatusUnauthorized, err, "spiffe.authorizeToken; error parsing token")
+	}
+	if len(jwt.Headers) == 0 {
+		return nil, errs.Unauthorized("spiffe.authorizeToken; error parsing token - header is missing")
+	}
+
+	header := jwt.Headers[0]
+	if !slices.Contains(jwtSVIDAlgorithms, header.Algorithm) {
+		return nil, errs.Unauthorized("spiffe.authorizeToken; unsupported token algorithm %q", header.Algorithm)
+	}
+
+	// The token must be signed by an authority in the bundle of the trust
+	// domain of its SPIFFE ID.
+	var unsafeClaims jose.Claims
+	if err := jwt.UnsafeClaimsWithoutVerification(&unsafeClaims); err != nil {
+		return nil, errs.Wrap(http.StatusUnauthorized, err, "spiffe.authorizeToken; error parsing token claims")
+	}
+	id, err := parseSPIFFEID(unsafeClaims.Subject)
+	if err != nil {
+		return nil, errs.Wrap(http.StatusUnauthorized, err, "spiffe.authorizeToken; invalid token subject")
+	}
+	bundle, ok := p.bundles[id.Host]
+	if !ok {
+		return nil, errs.Unauthorized("spiffe.authorizeToken; trust domain %q is not allowed", id.Host)
+	}
+
+	var found bool
+	var claims jose.Claims
+	for _, key := range bundle.Get(header.KeyID) {
+		if err := jwt.Claims(key.Public(), &claims); err == nil {
+			found = true
+			break
+		}
+	}
+	if !found {
+		return nil, errs.Unauthorized("spiffe.authorizeToken; failed to validate token - cannot find key for kid %s", header.KeyID)
+	}
+
+	// According to "rfc7519 JSON Web Token" acceptable skew should be no
+	// more than a few minutes.
+	if claims.Expiry == nil {
+		return nil, errs.Unauthorized("spiffe.authorizeToken; invalid token - exp claim is required")
+	}
+	if err := claims.ValidateWithLeeway(jose.Expected{
+		Time: time.Now().UTC(),
+	}, time.Minute); err != nil {
+		return nil, errs.Wrap(http.StatusUnauthorized, err, "spiffe.authorizeToken; invalid token claims")
+	}
+	if !matchesAudience(claims.Audience, audiences) {
+		return nil, errs.Unauthorized("spiffe.authorizeToken; invalid token - invalid audience claim (aud)")
+	}
+
+	if !p.matchesPath(id.Path) {
+		return nil, errs.Unauthorized("spiffe.authorizeToken; path %q is not allowed", id.Path)
+	}
+
+	return id, nil
+}
+
+// matchesPath returns true if the given path matches any of the path patterns
+// of the provisioner, or if the provisioner does not restrict them.
+func (p *SPIFFE) matchesPath(name string) bool {
+	if len(p.Paths) == 0 {
+		return true
+	}
+	for _, pattern := range p.Paths {
+		if ok, _ := path.Match(pattern, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// AuthorizeSign validates the given JWT-SVID and returns the sign options that
+// will be used on certificate creation.
+func (p *SPIFFE) AuthorizeSign(_ context.Context, token string) ([]SignOption, error) {
+	id, err := p.authorizeToken(token, p.ctl.Audiences.Sign)
+	if err != nil {
+		return nil, errs.Wrap(http.StatusInternalServerError, err, "spiffe.AuthorizeSign")
+	}
+	spiffeID := id.String()
+
+	// Template options
+	data := x509util.NewTemplateData()
+	data.SetSANs([]string{spiffeID})
+	if v, err := unsafeParseSigned(token); err == nil {
+		data.SetToken(v)
+	}
+
+	templateOptions, err := CustomTemplateOptions(p.Options, data, defaultX509SVIDTemplate)
+	if err != nil {
+		return nil, errs.Wrap(http.StatusInternalServerError, err, "spiffe.AuthorizeSign")
+	}
+
+	return []SignOption{
+		p,
+		templateOptions,
+		// modifiers / withOptions
+		newProvisionerExtensionOption(TypeSPIFFE, p.Name, spiffeID).WithControllerOptions(p.ctl),
+		newCertificateTransparencyOption(p.ctl),
+		profileDefaultDuration(p.ctl.Claimer.DefaultTLSCertDuration()),
+		// validators
+		defaultPublicKeyValidator{},
+		newValidityValidator(p.ctl.Claimer.MinTLSCertDuration(), p.ctl.Claimer.MaxTLSCertDuration()),
+		x509SVIDValidator(spiffeID),
+		newX509NamePolicyValidator(p.ctl.getPolicy().getX509()),
+		p.ctl.newWebhookController(
+			data,
+			linkedca.Webhook_X509,
+			webhook.WithAuthorizationPrincipal(spiffeID),
+		),
+	}, nil
+}
+
+// AuthorizeRenew returns an error if the renewal is disabled.
+func (p *SPIFFE) AuthorizeRenew(ctx context.Context, cert *x509.Certificate) error {
+	return p.ctl.AuthorizeRenew(ctx, cert)
+}
+
+// x509SVIDValidator validates that a certificate is an X.509-SVID leaf with
+// the given SPIFFE ID as its only URI SAN.
+type x509SVIDValidator string
+
+// Valid implements CertificateValidator.
+func (v x509SVIDValidator) Valid(cert *x509.Certificate, _ SignOptions) error {
+	switch {
+	case len(cert.URIs) != 1 || cert.URIs[0].String() != string(v):
+		return errs.Forbidden("certificate must contain exactly the URI SAN %s", string(v))
+	case cert.IsCA:
+		return errs.Forbidden("certificate cannot be a CA")
+	case cert.KeyUsage&(x509.KeyUsageCertSign|x509.KeyUsageCRLSign) != 0:
+		return errs.Forbidden("certificate cannot have the keyCertSign or cRLSign key usages")
+	default:
+		return nil
+	}
+}
+
+// parseSPIFFEID parses and validates a SPIFFE ID. SPIFFE IDs used in SVIDs
+// must have a path.
+func parseSPIFFEID(s string) (*url.URL, error) {
+	u, err := url.Parse(s)
+	if err != nil {
+		return nil, errors.Wrapf(err, "error parsing SPIFFE ID %q", s)
+	}
+	switch {
+	case u.Scheme != "spiffe":
+		return nil, errors.Errorf("SPIFFE ID %q must use the spiffe scheme", s)
+	case strings.Contains(s, "%"):
+		return nil, errors.Errorf("SPIFFE ID %q cannot contain percent-encoded characters", s)
+	case u.User != nil || u.Port() != "" || u.RawQuery != "" || u.Fragment != "" || u.Opaque != "":
+		return nil, errors.Errorf("SPIFFE ID %q must only contain a trust domain and a path", s)
+	case !spiffeTrustDomainRegex.MatchString(u.Host):
+		return nil, errors.Errorf("SPIFFE ID %q has an invalid trust domain", s)
+	case u.Path == "" || u.Path == "/":
+		return nil, errors.Errorf("SPIFFE ID %q must have a path", s)
+	}
+	for _, segment := range strings.Split(u.Path[1:], "/") {
+		if segment == "." || segment == ".." || !spiffePathSegmentRegex.MatchString(segment) {
+			return nil, errors.Errorf("SPIFFE ID %q has an invalid path", s)
+		}
+	}
+	return u, nil
+}
@@ -0,0 +1,155 @@
+package provisioner
+
+import (
+	"encoding/json"
+	"log"
+	"math/rand"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"go.step.sm/crypto/jose"
+)
+
+const (
+	// defaultSPIFFERefreshHint is the time a SPIFFE bundle is cached if it does
+	// not define a refresh hint.
+	defaultSPIFFERefreshHint = 5 * time.Minute
+	// spiffeBundleRetry is the time to wait before retrying a failed reload.
+	spiffeBundleRetry = time.Minute
+	// jwtSVIDKeyUse is the use of the keys in a SPIFFE bundle that can be used
+	// to validate JWT-SVIDs.
+	jwtSVIDKeyUse = "jwt-svid"
+)
+
+// spiffeBundleDocument is the JSON representation of a SPIFFE bundle. It is a
+// JWK set with some SPIFFE specific parameters.
+type spiffeBundleDocument struct {
+	Keys        []jose.JSONWebKey `json:"keys"`
+	Sequence    uint64            `json:"spiffe_sequence,omitempty"`
+	RefreshHint int64             `json:"spiffe_refresh_hint,omitempty"`
+}
+
+// spiffeBundle keeps the JWT-SVID authorities of a SPIFFE bundle read from a
+// file or a bundle endpoint. The bundle is reloaded in the background after the
+// refresh hint, and the last good keys are kept if the reload fails or if the
+// new bundle has a lower sequence number.
+type spiffeBundle struct {
+	sync.RWMutex
+	client   *http.Client
+	file     string
+	endpoint string
+	keys     jose.JSONWebKeySet
+	sequence uint64
+	timer    *time.Timer
+	closed   bool
+}
+
+// newSPIFFEBundle loads the bundle and schedules its next reload. If the bundle
+// cannot be loaded, the bundle starts without keys and the load is retried.
+func newSPIFFEBundle(client *http.Client, file, endpoint string) *spiffeBundle {
+	b := &spiffeBundle{
+		client:   client,
+		file:     file,
+		endpoint: endpoint,
+	}
+	next := b.load()
+	b.timer = time.AfterFunc(next, b.reload)
+	return b
+}
+
+// Close stops the background reloads of the bundle.
+func (b *spiffeBundle) Close() {
+	b.Lock()
+	defer b.Unlock()
+	b.closed = true
+	b.timer.Stop()
+}
+
+// Get returns the JWT-SVID authorities with the given key id.
+func (b *spiffeBundle) Get(kid string) []jose.JSONWebKey {
+	b.RLock()
+	defer b.RUnlock()
+	return b.keys.Key(kid)
+}
+
+func (b *spiffeBundle) reload() {
+	next := b.load()
+	b.Lock()
+	if !b.closed {
+		b.timer.Reset(next)
+	}
+	b.Unlock()
+}
+
+// load fetches the bundle and updates the keys, and returns the duration until
+// the next reload.
+func (b *spiffeBundle) load() time.Duration {
+	doc, err := b.fetch()
+	if err != nil {
+		log.Printf("error loading SPIFFE bundle: %v", err)
+		return nextSPIFFEReloadDuration(spiffeBundleRetry)
+	}
+
+	b.RLock()
+	sequence := b.sequence
+	b.RUnlock()
+	if doc.Sequence < sequence {
+		log.Printf("error loading SPIFFE bundle: sequence %d is lower than the current sequence %d", doc.Sequence, sequence)
+		return nextSPIFFEReloadDuration(spiffeBundleRetry)
+	}
+
+	var keys []jose.JSONWebKey
+	for _, k := range doc.Keys {
+		if k.Use == jwtSVIDKeyUse {
+			keys = append(keys, k)
+		}
+	}
+	refreshHint := defaultSPIFFERefreshHint
+	if doc.RefreshHint > 0 {
+		refreshHint = time.Duration(doc.RefreshHint) * time.Second
+	}
+
+	b.Lock()
+	b.keys = jose.JSONWebKeySet{Keys: keys}
+	b.sequence = doc.Sequence
+	b.Unlock()
+	return nextSPIFFEReloadDuration(refreshHint)
+}
+
+// nextSPIFFEReloadDuration returns the given duration minus a random jitter,
+// so the provisioners sharing a bundle endpoint do not reload it at the same
+// time.
+func nextSPIFFEReloadDuration(d time.Duration) time.Duration {
+	n := rand.Int63n(int64(getCacheJitter(d))) //nolint:gosec // not used for cryptographic security
+	return d - time.Duration(n)
+}
+
+func (b *spiffeBundle) fetch() (*spiffeBundleDocument, error) {
+	var doc spiffeBundleDocument
+	if b.file != "" {
+		data, err := os.ReadFile(b.file)
+		if err != nil {
+			return nil, errors.Wrapf(err, "error reading %s", b.file)
+		}
+		if err := json.Unmarshal(data, &doc); err != nil {
+			return nil, errors.Wrapf(err, "error parsing %s", b.file)
+		}
+		return &doc, nil
+	}
+
+	resp, err := b.client.Get(b.endpoint)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to connect to %s", b.endpoint)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, errors.Errorf("error retrieving %s: status code %d", b.endpoint, resp.StatusCode)
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&doc); err != nil {
+		return nil, errors.Wrapf(err, "error reading %s", b.endpoint)
+	}
+	return &doc, nil
+}
@@ -0,0 +1,380 @@
+package provisioner
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.step.sm/crypto/jose"
+	"go.step.sm/crypto/keyutil"
+	"go.step.sm/crypto/x509util"
+)
+
+func spiffeBundleJSON(t *testing.T, refreshHint int64, keys ...*jose.JSONWebKey) []byte {
+	t.Helper()
+	doc := spiffeBundleDocument{Sequence: 1, RefreshHint: refreshHint}
+	for _, k := range keys {
+		pub := k.Public()
+		doc.Keys = append(doc.Keys, pub)
+	}
+	b, err := json.Marshal(doc)
+	require.NoError(t, err)
+	return b
+}
+
+func generateJWTSVIDAuthority(t *testing.T) *jose.JSONWebKey {
+	t.Helper()
+	jwk, err := generateJSONWebKey()
+	require.NoError(t, err)
+	jwk.Use = jwtSVIDKeyUse
+	return jwk
+}
+
+func TestSPIFFE_Init(t *testing.T) {
+	jwk := generateJWTSVIDAuthority(t)
+	bundleFile := filepath.Join(t.TempDir(), "bundle.json")
+	require.NoError(t, os.WriteFile(bundleFile, spiffeBundleJSON(t, 0, jwk), 0600))
+
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/bundle" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write(spiffeBundleJSON(t, 0, jwk))
+	}))
+	t.Cleanup(srv.Close)
+
+	config := Config{Claims: globalProvisionerClaims, HTTPClient: srv.Client()}
+	file := []SPIFFETrustDomain{{Name: "example.org", BundleFile: bundleFile}}
+	endpoint := func(name, uri string) []SPIFFETrustDomain {
+		return []SPIFFETrustDomain{{Name: name, BundleEndpoint: uri}}
+	}
+	tests := []struct {
+		name    string
+		p       *SPIFFE
+		wantErr bool
+	}{
+		{"ok file", &SPIFFE{Type: "SPIFFE", Name: "spiffe", TrustDomains: file}, false},
+		{"ok endpoint", &SPIFFE{Type: "SPIFFE", Name: "spiffe", TrustDomains: endpoint("example.org", srv.URL+"/bundle")}, false},
+		{"ok paths", &SPIFFE{Type: "SPIFFE", Name: "spiffe", TrustDomains: file, Paths: []string{"/ns/*/sa/*"}}, false},
+		{"ok multiple", &SPIFFE{Type: "SPIFFE", Name: "spiffe", TrustDomains: append(endpoint("example.com", srv.URL+"/bundle"), file...)}, false},
+		{"ok missing file", &SPIFFE{Type: "SPIFFE", Name: "spiffe", TrustDomains: []SPIFFETrustDomain{{Name: "example.org", BundleFile: bundleFile + ".missing"}}}, false},
+		{"ok endpoint status", &SPIFFE{Type: "SPIFFE", Name: "spiffe", TrustDomains: endpoint("example.org", srv.URL+"/missing")}, false},
+		{"fail type", &SPIFFE{Type: "", Name: "spiffe", TrustDomains: file}, true},
+		{"fail name", &SPIFFE{Type: "SPIFFE", Name: "", TrustDomains: file}, true},
+		{"fail trust domains", &SPIFFE{Type: "SPIFFE", Name: "spiffe"}, true},
+		{"fail trust domain", &SPIFFE{Type: "SPIFFE", Name: "spiffe", TrustDomains: []SPIFFETrustDomain{{Name: "Example.org", BundleFile: bundleFile}}}, true},
+		{"fail duplicated trust domain", &SPIFFE{Type: "SPIFFE", Name: "spiffe", TrustDomains: append(endpoint("example.org", srv.URL+"/bundle"), file...)}, true},
+		{"fail path", &SPIFFE{Type: "SPIFFE", Name: "spiffe", TrustDomains: file, Paths: []string{"ns/*"}}, true},
+		{"fail path pattern", &SPIFFE{Type: "SPIFFE", Name: "spiffe", TrustDomains: file, Paths: []string{"/ns/["}}, true},
+		{"fail no bundle", &SPIFFE{Type: "SPIFFE", Name: "spiffe", TrustDomains: []SPIFFETrustDomain{{Name: "example.org"}}}, true},
+		{"fail both bundles", &SPIFFE{Type: "SPIFFE", Name: "spiffe", TrustDomains: []SPIFFETrustDomain{{Name: "example.org", BundleFile: bundleFile, BundleEndpoint: srv.URL + "/bundle"}}}, true},
+		{"fail http endpoint", &SPIFFE{Type: "SPIFFE", Name: "spiffe", TrustDomains: endpoint("example.org", "http://example.org/bundle")}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.p.Init(config); (err != nil) != tt.wantErr {
+				t.Errorf("SPIFFE.Init() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestSPIFFE_UnmarshalJSON(t *testing.T) {
+	jwk := generateJWTSVIDAuthority(t)
+	bundleFile := filepath.Join(t.TempDir(), "bundle.json")
+	require.NoError(t, os.WriteFile(bundleFile, spiffeBundleJSON(t, 0, jwk), 0600))
+
+	data, err := json.Marshal([]map[string]any{{
+		"type": "SPIFFE", "name": "spiffe", "trustDomains": []map[string]any{
+			{"name": "example.org", "bundleFile": bundleFile},
+		},
+	}})
+	require.NoError(t, err)
+	var l List
+	require.NoError(t, json.Unmarshal(data, &l))
+	require.Len(t, l, 1)
+
+	p, ok := l[0].(*SPIFFE)
+	require.True(t, ok)
+	require.NoError(t, p.Init(Config{Claims: globalProvisionerClaims}))
+	assert.Equal(t, TypeSPIFFE, p.GetType())
+	assert.Equal(t, "SPIFFE", p.GetType().String())
+	assert.Equal(t, "spiffe/spiffe", p.GetID())
+	assert.Equal(t, "spiffe/spiffe", p.GetIDForToken())
+	assert.Equal(t, []SPIFFETrustDomain{{Name: "example.org", BundleFile: bundleFile}}, p.TrustDomains)
+}
+
+func TestSPIFFE_AuthorizeSign(t *testing.T) {
+	jwk := generateJWTSVIDAuthority(t)
+	other := generateJWTSVIDAuthority(t)
+	x509Authority := generateJWTSVIDAuthority(t)
+	x509Authority.Use = "x509-svid"
+
+	dir := t.TempDir()
+	bundleFile := filepath.Join(dir, "bundle.json")
+	require.NoError(t, os.WriteFile(bundleFile, spiffeBundleJSON(t, 0, jwk, x509Authority), 0600))
+	otherBundleFile := filepath.Join(dir, "other.json")
+	require.NoError(t, os.WriteFile(otherBundleFile, spiffeBundleJSON(t, 0, other), 0600))
+
+	p := &SPIFFE{
+		Type: "SPIFFE",
+		Name: "spiffe",
+		TrustDomains: []SPIFFETrustDomain{
+			{Name: "example.org", BundleFile: bundleFile},
+			{Name: "example.net", BundleFile: otherBundleFile},
+		},
+		Paths: []string{"/ns/*/sa/*"},
+	}
+	require.NoError(t, p.Init(Config{Claims: globalProvisionerClaims, Audiences: testAudiences}))
+
+	aud := testAudiences.Sign[0] + "#spiffe/spiffe"
+	now := time.Now()
+	tokenFor := func(sub, aud string, key *jose.JSONWebKey) string {
+		tok, err := generateToken(sub, "", aud, "", nil, now, key)
+		require.NoError(t, err)
+		return tok
+	}
+
+	t.Run("load by token", func(t *testing.T) {
+		c := NewCollection(testAudiences)
+		require.NoError(t, c.Store(p))
+		tok, claims, err := parseToken(tokenFor("spiffe://example.org/ns/default/sa/web", aud, jwk))
+		require.NoError(t, err)
+		got, ok := c.LoadByToken(tok, claims)
+		require.True(t, ok)
+		assert.Equal(t, p, got)
+	})
+
+	key, err := keyutil.GenerateDefaultSigner()
+	require.NoError(t, err)
+	csr, err := x509util.CreateCertificateRequest("workload", []string{"workload.example.org", "spiffe://example.org/ns/other/sa/other"}, key)
+	require.NoError(t, err)
+
+	t.Run("ok other trust domain", func(t *testing.T) {
+		_, err := p.AuthorizeSign(context.Background(), tokenFor("spiffe://example.net/ns/default/sa/web", aud, other))
+		require.NoError(t, err)
+	})
+
+	t.Run("ok", func(t *testing.T) {
+		opts, err := p.AuthorizeSign(context.Background(), tokenFor("spiffe://example.org/ns/default/sa/web", aud, jwk))
+		require.NoError(t, err)
+
+		var certOptions []x509util.Option
+		var validator x509SVIDValidator
+		for _, o := range opts {
+			switch v := o.(type) {
+			case CertificateOptions:
+				certOptions = append(certOptions, v.Options(SignOptions{})...)
+			case x509SVIDValidator:
+				validator = v
+			}
+		}
+		assert.Equal(t, x509SVIDValidator("spiffe://example.org/ns/default/sa/web"), validator)
+
+		// The default template ignores the names in the request.
+		cert, err := x509util.NewCertificate(csr, certOptions...)
+		require.NoError(t, err)
+		c := cert.GetCertificate()
+		assert.Empty(t, c.Subject.CommonName)
+		assert.Empty(t, c.DNSNames)
+		assert.Equal(t, []*url.URL{{Scheme: "spiffe", Host: "example.org", Path: "/ns/default/sa/web"}}, c.URIs)
+		assert.Equal(t, x509.KeyUsageDigitalSignature, c.KeyUsage)
+		assert.Equal(t, []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth}, c.ExtKeyUsage)
+		assert.True(t, c.BasicConstraintsValid)
+		assert.False(t, c.IsCA)
+		assert.NoError(t, validator.Valid(c, SignOptions{}))
+	})
+
+	failures := []struct {
+		name  string
+		token string
+	}{
+		{"fail token", "foo"},
+		{"fail key", tokenFor("spiffe://example.org/ns/default/sa/web", aud, other)},
+		{"fail x509-svid key", tokenFor("spiffe://example.org/ns/default/sa/web", aud, x509Authority)},
+		{"fail audience", tokenFor("spiffe://example.org/ns/default/sa/web", testAudiences.Sign[0], jwk)},
+		{"fail other provisioner", tokenFor("spiffe://example.org/ns/default/sa/web", testAudiences.Sign[0]+"#spiffe/other", jwk)},
+		{"fail subject", tokenFor("https://example.org/ns/default/sa/web", aud, jwk)},
+		{"fail trust domain", tokenFor("spiffe://example.com/ns/default/sa/web", aud, jwk)},
+		{"fail other trust domain key", tokenFor("spiffe://example.net/ns/default/sa/web", aud, jwk)},
+		{"fail other trust domain subject", tokenFor("spiffe://example.org/ns/default/sa/web", aud, other)},
+		{"fail path", tokenFor("spiffe://example.org/ns/default/web", aud, jwk)},
+		{"fail no path", tokenFor("spiffe://example.org", aud, jwk)},
+	}
+	for _, tt := range failures {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := p.AuthorizeSign(context.Background(), tt.token)
+			assert.Error(t, err)
+		})
+	}
+}
+
+func TestSPIFFE_bundleRefresh(t *testing.T) {
+	jwk := generateJWTSVIDAuthority(t)
+	rotated := generateJWTSVIDAuthority(t)
+
+	var fail atomic.Bool
+	var sequence atomic.Uint64
+	var current atomic.Pointer[jose.JSONWebKey]
+	current.Store(jwk)
+	sequence.Store(1)
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if fail.Load() {
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		b, err := json.Marshal(spiffeBundleDocument{
+			Keys:        []jose.JSONWebKey{current.Load().Public()},
+			Sequence:    sequence.Load(),
+			RefreshHint: 60,
+		})
+		require.NoError(t, err)
+		w.Write(b)
+	}))
+	t.Cleanup(srv.Close)
+
+	// The bundle starts without keys if the endpoint is not available.
+	fail.Store(true)
+	b := newSPIFFEBundle(srv.Client(), "", srv.URL)
+	t.Cleanup(b.Close)
+	assert.Empty(t, b.Get(jwk.KeyID))
+
+	fail.Store(false)
+	b.reload()
+	assert.Len(t, b.Get(jwk.KeyID), 1)
+
+	// Failures keep the last good keys.
+	current.Store(rotated)
+	fail.Store(true)
+	b.reload()
+	assert.Len(t, b.Get(jwk.KeyID), 1)
+	assert.Empty(t, b.Get(rotated.KeyID))
+
+	fail.Store(false)
+	sequence.Store(2)
+	b.reload()
+	assert.Empty(t, b.Get(jwk.KeyID))
+	assert.Len(t, b.Get(rotated.KeyID), 1)
+
+	// Bundles with a lower sequence number are ignored.
+	current.Store(jwk)
+	sequence.Store(1)
+	b.reload()
+	assert.Empty(t, b.Get(jwk.KeyID))
+	assert.Len(t, b.Get(rotated.KeyID), 1)
+
+	sequence.Store(3)
+	b.reload()
+	assert.Len(t, b.Get(jwk.KeyID), 1)
+	assert.Empty(t, b.Get(rotated.KeyID))
+
+	// Closed bundles are not reloaded again.
+	b.Close()
+	b.reload()
+	assert.False(t, b.timer.Stop())
+}
+
+func TestSPIFFE_Close(t *testing.T) {
+	jwk := generateJWTSVIDAuthority(t)
+	bundleFile := filepath.Join(t.TempDir(), "bundle.json")
+	require.NoError(t, os.WriteFile(bundleFile, spiffeBundleJSON(t, 0, jwk), 0600))
+
+	p := &SPIFFE{Type: "SPIFFE", Name: "spiffe", TrustDomains: []SPIFFETrustDomain{{Name: "example.org", BundleFile: bundleFile}}}
+	config := Config{Claims: globalProvisionerClaims}
+	require.NoError(t, p.Init(config))
+	first := p.bundles["example.org"]
+
+	// The bundles are closed when the provisioner is initialized again.
+	require.NoError(t, p.Init(config))
+	second := p.bundles["example.org"]
+	assert.NotSame(t, first, second)
+	assert.True(t, first.closed)
+	assert.False(t, second.closed)
+
+	// And when the provisioner is removed.
+	c := NewCollection(testAudiences)
+	require.NoError(t, c.Store(p))
+	require.NoError(t, c.Remove(p.GetID()))
+	assert.True(t, second.closed)
+	assert.False(t, second.timer.Stop())
+}
+
+func Test_nextSPIFFEReloadDuration(t *testing.T) {
+	for _, d := range []time.Duration{time.Minute, defaultSPIFFERefreshHint, 24 * time.Hour} {
+		for range 100 {
+			next := nextSPIFFEReloadDuration(d)
+			assert.LessOrEqual(t, next, d)
+			assert.Greater(t, next, d/2)
+		}
+	}
+}
+
+func Test_x509SVIDValidator_Valid(t *testing.T) {
+	id := "spiffe://example.org/ns/default/sa/web"
+	u, err := url.Parse(id)
+	require.NoError(t, err)
+	other, err := url.Parse("spiffe://example.org/ns/default/sa/other")
+	require.NoError(t, err)
+
+	tests := []struct {
+		name    string
+		cert    *x509.Certificate
+		wantErr bool
+	}{
+		{"ok", &x509.Certificate{URIs: []*url.URL{u}, KeyUsage: x509.KeyUsageDigitalSignature}, false},
+		{"ok dns", &x509.Certificate{URIs: []*url.URL{u}, DNSNames: []string{"web.example.org"}}, false},
+		{"fail no uris", &x509.Certificate{}, true},
+		{"fail other uri", &x509.Certificate{URIs: []*url.URL{other}}, true},
+		{"fail two uris", &x509.Certificate{URIs: []*url.URL{u, other}}, true},
+		{"fail ca", &x509.Certificate{URIs: []*url.URL{u}, IsCA: true}, true},
+		{"fail key usage", &x509.Certificate{URIs: []*url.URL{u}, KeyUsage: x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := x509SVIDValidator(id).Valid(tt.cert, SignOptions{}); (err != nil) != tt.wantErr {
+				t.Errorf("x509SVIDValidator.Valid() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func Test_parseSPIFFEID(t *testing.T) {
+	tests := []struct {
+		id      string
+		wantErr bool
+	}{
+		{"spiffe://example.org/workload", false},
+		{"spiffe://example.org/ns/default/sa/web-1_a.b", false},
+		{"https://example.org/workload", true},
+		{"spiffe://example.org", true},
+		{"spiffe://example.org/", true},
+		{"spiffe://example.org/workload/", true},
+		{"spiffe://example.org//workload", true},
+		{"spiffe://example.org/../workload", true},
+		{"spiffe://example.org/work%20load", true},
+		{"spiffe://Example.org/workload", true},
+		{"spiffe://example.org:8443/workload", true},
+		{"spiffe://user@example.org/workload", true},
+		{"spiffe://example.org/workload?query", true},
+		{"spiffe://example.org/workload#fragment", true},
+		{"spiffe:example.org/workload", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.id, func(t *testing.T) {
+			if _, err := parseSPIFFEID(tt.id); (err != nil) != tt.wantErr {
+				t.Errorf("parseSPIFFEID() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -504,11 +504,13 @@ func TestX5C_AuthorizeSign(t *testing.T) {
 			} else {
 				if assert.Nil(t, tc.err) {
 					if assert.NotNil(t, opts) {
-						assert.Len(t, opts, 11)
+						assert.Len(t, opts, 12)
 						for _, o := range opts {
 							switch v := o.(type) {
 							case *X5C:
 							case certificateOptionsFunc:
+							case CertificateTransparency:
+								assert.False(t, bool(v))
 							case *provisionerExtensionOption:
 								assert.Equal(t, TypeX5C, v.Type)
 								assert.Equal(t, tc.p.GetName(), v.Name)
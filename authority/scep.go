@@ -0,0 +1,194 @@
+package authority
+
+import (
+	"errors"
+	"time"
+
+	"github.com/smallstep/nosql/database"
+	"go.step.sm/crypto/randutil"
+
+	"github.com/smallstep/certificates/authority/admin"
+	"github.com/smallstep/certificates/db"
+)
+
+func (a *Authority) scepRequestDB() (db.SCEPRequestDB, error) {
+	rdb, ok := a.db.(db.SCEPRequestDB)
+	if !ok {
+		return nil, admin.NewError(admin.ErrorNotImplementedType, "pending SCEP requests are not supported by the database")
+	}
+	return rdb, nil
+}
+
+// GetSCEPRequests returns the SCEP requests in the pending queue of the given
+// provisioner. It requires a database that implements db.SCEPRequestDB.
+func (a *Authority) GetSCEPRequests(provisionerID string) ([]*db.SCEPRequest, error) {
+	rdb, err := a.scepRequestDB()
+	if err != nil {
+		return nil, err
+	}
+
+	reqs, err := rdb.GetSCEPRequests(provisionerID)
+	if err != nil {
+		return nil, admin.WrapErrorISE(err, "error retrieving SCEP requests")
+	}
+	return reqs, nil
+}
+
+// GetSCEPRequest returns the SCEP request with the given provisioner and
+// transaction id.
+func (a *Authority) GetSCEPRequest(provisionerID, transactionID string) (*db.SCEPRequest, error) {
+	rdb, err := a.scepRequestDB()
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := rdb.GetSCEPRequest(provisionerID, transactionID)
+	switch {
+	case database.IsErrNotFound(err):
+		return nil, admin.NewError(admin.ErrorNotFoundType, "SCEP request %s not found", transactionID)
+	case err != nil:
+		return nil, admin.WrapErrorISE(err, "error retrieving SCEP request %s", transactionID)
+	}
+	return req, nil
+}
+
+// UpdateSCEPRequestStatus approves or rejects a pending SCEP request. The
+// certificate of an approved request is issued the next time the client
+// polls for it.
+func (a *Authority) UpdateSCEPRequestStatus(provisionerID, transactionID, status, reason string) (*db.SCEPRequest, error) {
+	switch status {
+	case db.SCEPRequestApproved, db.SCEPRequestRejected:
+	default:
+		return nil, admin.NewError(admin.ErrorBadRequestType, "status '%s' is not valid", status)
+	}
+
+	rdb, err := a.scepRequestDB()
+	if err != nil {
+		return nil, err
+	}
+	req, err := a.GetSCEPRequest(provisionerID, transactionID)
+	if err != nil {
+		return nil, err
+	}
+	if req.Status != db.SCEPRequestPending {
+		return nil, admin.NewError(admin.ErrorBadRequestType, "SCEP request %s is not pending", transactionID)
+	}
+
+	updated := *req
+	updated.Status = status
+	updated.Reason = reason
+	updated.UpdatedAt = time.Now().UTC()
+	switch err := rdb.UpdateSCEPRequest(req, &updated); {
+	case errors.Is(err, db.ErrSCEPRequestChanged):
+		return nil, admin.NewError(admin.ErrorConflictType, "SCEP request %s has been modified concurrently", transactionID)
+	case err != nil:
+		return nil, admin.WrapErrorISE(err, "error updating SCEP request %s", transactionID)
+	}
+	return &updated, nil
+}
+
+// defaultSCEPChallengeDuration is the validity of the dynamic SCEP challenges
+// created without an expiration time.
+const defaultSCEPChallengeDuration = 24 * time.Hour
+
+func (a *Authority) scepChallengeDB() (db.SCEPChallengeDB, error) {
+	cdb, ok := a.db.(db.SCEPChallengeDB)
+	if !ok {
+		return nil, admin.NewError(admin.ErrorNotImplementedType, "dynamic SCEP challenges are not supported by the database")
+	}
+	return cdb, nil
+}
+
+// CreateSCEPChallenge creates a new dynamic SCEP challenge for the given
+// provisioner. The challenge can be used maxUses times before it expires, and
+// if subject or sans are set, the certificates issued using the challenge must
+// have the same common name and subject alternative names. It returns the
+// challenge value, which is not stored in the database, and the stored
+// challenge.
+func (a *Authority) CreateSCEPChallenge(provisionerID, subject string, sans []string, maxUses int, duration time.Duration) (string, *db.SCEPChallenge, error) {
+	switch {
+	case maxUses < 0:
+		return "", nil, admin.NewError(admin.ErrorBadRequestType, "maximum number of uses cannot be negative")
+	case maxUses == 0:
+		maxUses = 1
+	}
+	switch {
+	case duration < 0:
+		return "", nil, admin.NewError(admin.ErrorBadRequestType, "duration cannot be negative")
+	case duration == 0:
+		duration = defaultSCEPChallengeDuration
+	}
+
+	cdb, err := a.scepChallengeDB()
+	if err != nil {
+		return "", nil, err
+	}
+
+	challenge, err := randutil.Alphanumeric(32)
+	if err != nil {
+		return "", nil, admin.WrapErrorISE(err, "error generating SCEP challenge")
+	}
+	now := time.Now().UTC().Truncate(time.Second)
+	ch := &db.SCEPChallenge{
+		ID:            db.SCEPChallengeID(challenge),
+		ProvisionerID: provisionerID,
+		Subject:       subject,
+		SANs:          sans,
+		MaxUses:       maxUses,
+		CreatedAt:     now,
+		ExpiresAt:     now.Add(duration),
+	}
+	if err := cdb.CreateSCEPChallenge(ch); err != nil {
+		return "", nil, admin.WrapErrorISE(err, "error storing SCEP challenge")
+	}
+	return challenge, ch, nil
+}
+
+// GetSCEPChallenges returns the dynamic SCEP challenges of the given
+// provisioner. It requires a database that implements db.SCEPChallengeDB.
+func (a *Authority) GetSCEPChallenges(provisionerID string) ([]*db.SCEPChallenge, error) {
+	cdb, err := a.scepChallengeDB()
+	if err != nil {
+		return nil, err
+	}
+
+	chs, err := cdb.GetSCEPChallenges(provisionerID)
+	if err != nil {
+		return nil, admin.WrapErrorISE(err, "error retrieving SCEP challenges")
+	}
+	return chs, nil
+}
+
+// GetSCEPChallenge returns the dynamic SCEP challenge with the given
+// provisioner and id.
+func (a *Authority) GetSCEPChallenge(provisionerID, id string) (*db.SCEPChallenge, error) {
+	cdb, err := a.scepChallengeDB()
+	if err != nil {
+		return nil, err
+	}
+
+	ch, err := cdb.GetSCEPChallenge(provisionerID, id)
+	switch {
+	case database.IsErrNotFound(err):
+		return nil, admin.NewError(admin.ErrorNotFoundType, "SCEP challenge %s not found", id)
+	case err != nil:
+		return nil, admin.WrapErrorISE(err, "error retrieving SCEP challenge %s", id)
+	}
+	return ch, nil
+}
+
+// DeleteSCEPChallenge deletes the dynamic SCEP challenge with the given
+// provisioner and id.
+func (a *Authority) DeleteSCEPChallenge(provisionerID, id string) error {
+	cdb, err := a.scepChallengeDB()
+	if err != nil {
+		return err
+	}
+	if _, err := a.GetSCEPChallenge(provisionerID, id); err != nil {
+		return err
+	}
+	if err := cdb.DeleteSCEPChallenge(provisionerID, id); err != nil {
+		return admin.WrapErrorISE(err, "error deleting SCEP challenge %s", id)
+	}
+	return nil
+}
@@ -0,0 +1,132 @@
+package authority
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/smallstep/nosql/database"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/smallstep/certificates/authority/admin"
+	"github.com/smallstep/certificates/db"
+)
+
+func TestAuthority_UpdateSCEPRequestStatus(t *testing.T) {
+	var stored *db.SCEPRequest
+	newAuthority := func(status string) *Authority {
+		return testAuthority(t, WithDatabase(&db.MockAuthDB{
+			MGetSCEPRequest: func(provisionerID, transactionID string) (*db.SCEPRequest, error) {
+				if provisionerID != "scep-id" || transactionID != "tx1" {
+					return nil, database.ErrNotFound
+				}
+				return &db.SCEPRequest{ProvisionerID: provisionerID, TransactionID: transactionID, Status: status}, nil
+			},
+			MUpdateSCEPRequest: func(old, req *db.SCEPRequest) error {
+				if old.Status != status {
+					return db.ErrSCEPRequestChanged
+				}
+				stored = req
+				return nil
+			},
+		}))
+	}
+
+	a := newAuthority(db.SCEPRequestPending)
+	req, err := a.UpdateSCEPRequestStatus("scep-id", "tx1", db.SCEPRequestRejected, "unknown device")
+	require.NoError(t, err)
+	assert.Equal(t, db.SCEPRequestRejected, req.Status)
+	assert.Equal(t, "unknown device", req.Reason)
+	assert.False(t, req.UpdatedAt.IsZero())
+	assert.Equal(t, req, stored)
+
+	var ae *admin.Error
+	_, err = a.UpdateSCEPRequestStatus("scep-id", "tx1", db.SCEPRequestIssued, "")
+	require.ErrorAs(t, err, &ae)
+	assert.Equal(t, http.StatusBadRequest, ae.StatusCode())
+
+	_, err = a.UpdateSCEPRequestStatus("scep-id", "tx2", db.SCEPRequestApproved, "")
+	require.ErrorAs(t, err, &ae)
+	assert.Equal(t, http.StatusNotFound, ae.StatusCode())
+
+	// Only pending requests can be approved or rejected.
+	a = newAuthority(db.SCEPRequestIssued)
+	_, err = a.UpdateSCEPRequestStatus("scep-id", "tx1", db.SCEPRequestApproved, "")
+	require.ErrorAs(t, err, &ae)
+	assert.Equal(t, http.StatusBadRequest, ae.StatusCode())
+
+	// Requests modified concurrently are not overwritten.
+	a = testAuthority(t, WithDatabase(&db.MockAuthDB{
+		MGetSCEPRequest: func(provisionerID, transactionID string) (*db.SCEPRequest, error) {
+			return &db.SCEPRequest{ProvisionerID: provisionerID, TransactionID: transactionID, Status: db.SCEPRequestPending}, nil
+		},
+		MUpdateSCEPRequest: func(old, req *db.SCEPRequest) error {
+			return db.ErrSCEPRequestChanged
+		},
+	}))
+	_, err = a.UpdateSCEPRequestStatus("scep-id", "tx1", db.SCEPRequestApproved, "")
+	require.ErrorAs(t, err, &ae)
+	assert.Equal(t, http.StatusConflict, ae.StatusCode())
+
+	// The simple database does not support the pending requests.
+	a = testAuthority(t, WithDatabase(&db.SimpleDB{}))
+	_, err = a.GetSCEPRequests("scep-id")
+	require.ErrorAs(t, err, &ae)
+	assert.Equal(t, http.StatusNotImplemented, ae.StatusCode())
+}
+
+func TestAuthority_SCEPChallenges(t *testing.T) {
+	var stored *db.SCEPChallenge
+	a := testAuthority(t, WithDatabase(&db.MockAuthDB{
+		MCreateSCEPChallenge: func(ch *db.SCEPChallenge) error {
+			stored = ch
+			return nil
+		},
+		MGetSCEPChallenge: func(provisionerID, id string) (*db.SCEPChallenge, error) {
+			if stored == nil || provisionerID != stored.ProvisionerID || id != stored.ID {
+				return nil, database.ErrNotFound
+			}
+			return stored, nil
+		},
+		MDeleteSCEPChallenge: func(provisionerID, id string) error {
+			stored = nil
+			return nil
+		},
+	}))
+
+	challenge, ch, err := a.CreateSCEPChallenge("scep-id", "router1", []string{"router1.example.com"}, 0, 0)
+	require.NoError(t, err)
+	assert.Len(t, challenge, 32)
+	assert.Equal(t, db.SCEPChallengeID(challenge), ch.ID)
+	assert.Equal(t, "scep-id", ch.ProvisionerID)
+	assert.Equal(t, "router1", ch.Subject)
+	assert.Equal(t, []string{"router1.example.com"}, ch.SANs)
+	assert.Equal(t, 1, ch.MaxUses)
+	assert.Equal(t, 24*time.Hour, ch.ExpiresAt.Sub(ch.CreatedAt))
+	assert.Equal(t, ch, stored)
+
+	got, err := a.GetSCEPChallenge("scep-id", ch.ID)
+	require.NoError(t, err)
+	assert.Equal(t, ch, got)
+
+	var ae *admin.Error
+	_, _, err = a.CreateSCEPChallenge("scep-id", "", nil, -1, 0)
+	require.ErrorAs(t, err, &ae)
+	assert.Equal(t, http.StatusBadRequest, ae.StatusCode())
+	_, _, err = a.CreateSCEPChallenge("scep-id", "", nil, 1, -time.Hour)
+	require.ErrorAs(t, err, &ae)
+	assert.Equal(t, http.StatusBadRequest, ae.StatusCode())
+
+	require.NoError(t, a.DeleteSCEPChallenge("scep-id", ch.ID))
+	assert.Nil(t, stored)
+	err = a.DeleteSCEPChallenge("scep-id", ch.ID)
+	require.ErrorAs(t, err, &ae)
+	assert.Equal(t, http.StatusNotFound, ae.StatusCode())
+
+	// The simple database does not support the dynamic challenges.
+	a = testAuthority(t, WithDatabase(&db.SimpleDB{}))
+	_, err = a.GetSCEPChallenges("scep-id")
+	require.ErrorAs(t, err, &ae)
+	assert.Equal(t, http.StatusNotImplemented, ae.StatusCode())
+}
@@ -496,6 +496,9 @@ func (a *Authority) storeSSHCertificate(prov provisioner.Interface, cert *ssh.Ce
 	type sshCertificateStorer interface {
 		StoreSSHCertificate(provisioner.Interface, *ssh.Certificate) error
 	}
+	type sshCertificateWithProvisionerStorer interface {
+		StoreSSHCertificateWithProvisioner(provisioner.Interface, *ssh.Certificate) error
+	}
 
 	// Store certificate in admindb or linkedca
 	switch s := a.adminDB.(type) {
@@ -509,6 +512,8 @@ func (a *Authority) storeSSHCertificate(prov provisioner.Interface, cert *ssh.Ce
 	switch s := a.db.(type) {
 	case sshCertificateStorer:
 		return s.StoreSSHCertificate(prov, cert)
+	case sshCertificateWithProvisionerStorer:
+		return s.StoreSSHCertificateWithProvisioner(prov, cert)
 	case db.CertificateStorer:
 		return s.StoreSSHCertificate(cert)
 	default:
@@ -11,7 +11,6 @@ import (
 	"encoding/json"
 	"encoding/pem"
 	"fmt"
-	"math/big"
 	"net"
 	"net/http"
 	"strings"
@@ -31,7 +30,6 @@ import (
 	"github.com/smallstep/certificates/db"
 	"github.com/smallstep/certificates/errs"
 	"github.com/smallstep/certificates/webhook"
-	"github.com/smallstep/nosql/database"
 )
 
 type tokenKey struct{}
@@ -146,6 +144,7 @@ func (a *Authority) signX509(ctx context.Context, csr *x509.CertificateRequest,
 		pInfo      *casapi.ProvisionerInfo
 		attData    *provisioner.AttestationData
 		webhookCtl webhookController
+		submitCT   bool
 	)
 	for _, op := range extraOpts {
 		switch k := op.(type) {
@@ -190,6 +189,10 @@ func (a *Authority) signX509(ctx context.Context, csr *x509.CertificateRequest,
 		case webhookController:
 			webhookCtl = k
 
+		// Submit the certificate to the Certificate Transparency logs.
+		case provisioner.CertificateTransparency:
+			submitCT = bool(k)
+
 		default:
 			return nil, prov, errs.InternalServer("authority.Sign; invalid extra option type %T", append([]any{k}, opts...)...)
 		}
@@ -275,6 +278,16 @@ func (a *Authority) signX509(ctx context.Context, csr *x509.CertificateRequest,
 		}
 	}
 
+	// Add the revocation information (OCSP and CRL) if configured
+	for _, m := range a.revocationEnforcers() {
+		if err = m.Enforce(leaf); err != nil {
+			return nil, prov, errs.ApplyOptions(
+				errs.ForbiddenErr(err, "error creating certificate"),
+				opts...,
+			)
+		}
+	}
+
 	// Check if authority is allowed to sign the certificate
 	if err = a.isAllowedToSignX509Certificate(leaf); err != nil {
 		var ee *errs.Error
@@ -299,13 +312,21 @@ func (a *Authority) signX509(ctx context.Context, csr *x509.CertificateRequest,
 	// Sign certificate
 	lifetime := leaf.NotAfter.Sub(leaf.NotBefore.Add(signOpts.Backdate))
 
-	resp, err := a.x509CAService.CreateCertificate(&casapi.CreateCertificateRequest{
+	req := &casapi.CreateCertificateRequest{
 		Template:    leaf,
 		CSR:         csr,
 		Lifetime:    lifetime,
 		Backdate:    signOpts.Backdate,
 		Provisioner: pInfo,
-	})
+	}
+
+	var resp *casapi.CreateCertificateResponse
+	if submitCT && a.config.CT.IsEnabled() {
+		// Submit a precertificate to the CT logs and embed the SCTs
+		resp, err = a.createCertificateWithSCTs(ctx, req)
+	} else {
+		resp, err = a.x509CAService.CreateCertificate(req)
+	}
 	if err != nil {
 		return nil, prov, errs.Wrap(http.StatusInternalServerError, err, "authority.Sign; error creating certificate", opts...)
 	}
@@ -334,6 +355,19 @@ func (a *Authority) isAllowedToSignX509Certificate(cert *x509.Certificate) error
 	return a.policyEngine.IsX509CertificateAllowed(cert)
 }
 
+// revocationEnforcers returns the certificate enforcers that add the OCSP
+// responder and the CRL distribution points to the issued certificates.
+func (a *Authority) revocationEnforcers() []provisioner.CertificateEnforcer {
+	var enforcers []provisioner.CertificateEnforcer
+	if o := a.config.OCSP; o.IsEnabled() && o.URL != "" {
+		enforcers = append(enforcers, withOCSPServer(o.URL))
+	}
+	if a.config.CRL.IsPartitioned() || a.config.CRL.IsDeltaEnabled() {
+		enforcers = append(enforcers, a.withCRLDistributionPoints())
+	}
+	return enforcers
+}
+
 // AreSANsAllowed evaluates the provided sans against the
 // authority X.509 policy.
 func (a *Authority) AreSANsAllowed(_ context.Context, sans []string) error {
@@ -433,6 +467,16 @@ func (a *Authority) renewContext(ctx context.Context, oldCert *x509.Certificate,
 		newCert.PublicKey = oldCert.PublicKey
 	}
 
+	// Keep the renewed certificate in the same partitioned CRL, the CRL
+	// distribution point is copied from the old certificate.
+	if a.config.CRL.IsPartitioned() {
+		newCert.SerialNumber, err = newPartitionedSerialNumber(a.config.CRL.Partitions,
+			crlPartitionIndex(oldCert.SerialNumber, a.config.CRL.Partitions))
+		if err != nil {
+			return nil, prov, errs.StatusCodeError(http.StatusInternalServerError, err, opts...)
+		}
+	}
+
 	// Copy all extensions except:
 	//
 	//  1. Authority Key Identifier - This one might be different if we rotate
@@ -442,8 +486,17 @@ func (a *Authority) renewContext(ctx context.Context, oldCert *x509.Certificate,
 	//  2. Subject Key Identifier, if rekey - For rekey, SubjectKeyIdentifier
 	//  extension will be calculated for the new public key by
 	//  x509util.CreateCertificate()
+	//
+	//  3. Certificate Transparency SCT list and poison - The SCTs were signed
+	//  over the precertificate of the old certificate and they are not valid
+	//  in the new one.
+	var submitCT bool
 	for _, ext := range oldCert.Extensions {
-		if ext.Id.Equal(oidAuthorityKeyIdentifier) {
+		if ext.Id.Equal(oidAuthorityKeyIdentifier) || ext.Id.Equal(oidExtensionCTPoison) {
+			continue
+		}
+		if ext.Id.Equal(oidExtensionCTSCTList) {
+			submitCT = true
 			continue
 		}
 		if ext.Id.Equal(oidSubjectKeyIdentifier) && isRekey {
@@ -471,22 +524,36 @@ func (a *Authority) renewContext(ctx context.Context, oldCert *x509.Certificate,
 		}
 	}
 
-	// The token can optionally be in the context. If the CA is running in RA
-	// mode, this can be used to renew a certificate.
-	token, _ := TokenFromContext(ctx)
-
-	resp, err := a.x509CAService.RenewCertificate(&casapi.RenewCertificateRequest{
-		Template: newCert,
-		Lifetime: lifetime,
-		Backdate: backdate,
-		Token:    token,
-	})
-	if err != nil {
-		return nil, prov, errs.StatusCodeError(http.StatusInternalServerError, err, opts...)
+	var chain []*x509.Certificate
+	if submitCT && a.config.CT.IsEnabled() {
+		// The old certificate was submitted to the Certificate Transparency
+		// logs, submit a new precertificate and embed the new SCTs.
+		resp, err := a.createCertificateWithSCTs(ctx, &casapi.CreateCertificateRequest{
+			Template: newCert,
+			Lifetime: lifetime,
+			Backdate: backdate,
+		})
+		if err != nil {
+			return nil, prov, errs.StatusCodeError(http.StatusInternalServerError, err, opts...)
+		}
+		chain = append([]*x509.Certificate{resp.Certificate}, resp.CertificateChain...)
+	} else {
+		// The token can optionally be in the context. If the CA is running in
+		// RA mode, this can be used to renew a certificate.
+		token, _ := TokenFromContext(ctx)
+
+		resp, err := a.x509CAService.RenewCertificate(&casapi.RenewCertificateRequest{
+			Template: newCert,
+			Lifetime: lifetime,
+			Backdate: backdate,
+			Token:    token,
+		})
+		if err != nil {
+			return nil, prov, errs.StatusCodeError(http.StatusInternalServerError, err, opts...)
+		}
+		chain = append([]*x509.Certificate{resp.Certificate}, resp.CertificateChain...)
 	}
 
-	chain := append([]*x509.Certificate{resp.Certificate}, resp.CertificateChain...)
-
 	if err = a.storeRenewedCertificate(oldCert, chain); err != nil && !errors.Is(err, db.ErrNotImplemented) {
 		return nil, prov, errs.StatusCodeError(http.StatusInternalServerError, err, opts...)
 	}
@@ -569,9 +636,8 @@ type RevokeOptions struct {
 // Revoke revokes a certificate.
 //
 // NOTE: Only supports passive revocation - prevent existing certificates from
-// being renewed.
-//
-// TODO: Add OCSP and CRL support.
+// being renewed. Revoked certificates are reported in the CRL and by the OCSP
+// responder if they are enabled.
 func (a *Authority) Revoke(ctx context.Context, revokeOpts *RevokeOptions) error {
 	opts := []interface{}{
 		errs.WithKeyVal("serialNumber", revokeOpts.Serial),
@@ -690,10 +756,18 @@ func (a *Authority) Revoke(ctx context.Context, revokeOpts *RevokeOptions) error
 			return failRevoke(err)
 		}
 
+		// Remove any cached OCSP response for the revoked certificate.
+		a.deleteOCSPResponses(rci.Serial)
+
 		// Generate a new CRL so CRL requesters will always get an up-to-date
-		// CRL whenever they request it.
+		// CRL whenever they request it. If delta CRLs are enabled, only the
+		// delta CRLs are generated.
 		if a.config.CRL.IsEnabled() && a.config.CRL.GenerateOnRevoke {
-			if err := a.GenerateCertificateRevocationList(); err != nil {
+			generate := a.GenerateCertificateRevocationList
+			if a.config.CRL.IsDeltaEnabled() {
+				generate = a.GenerateDeltaCertificateRevocationLists
+			}
+			if err := generate(); err != nil {
 				return errs.Wrap(http.StatusInternalServerError, err, "authority.Revoke", opts...)
 			}
 		}
@@ -754,21 +828,16 @@ func (a *Authority) GetCertificateRevocationList() (*CertificateRevocationListIn
 }
 
 // GenerateCertificateRevocationList generates a DER representation of a signed CRL and stores it in the
-// database. Returns nil if CRL generation has been disabled in the config
+// database. If configured, the partitioned CRLs and the delta CRLs are generated too. Returns nil if CRL
+// generation has been disabled in the config
 func (a *Authority) GenerateCertificateRevocationList() error {
 	if !a.config.CRL.IsEnabled() {
 		return nil
 	}
 
-	crlDB, ok := a.db.(db.CertificateRevocationListDB)
-	if !ok {
-		return errors.Errorf("Database does not support CRL generation")
-	}
-
-	// some CAS may not implement the CRLGenerator interface, so check before we proceed
-	caCRLGenerator, ok := a.x509CAService.(casapi.CertificateAuthorityCRLGenerator)
-	if !ok {
-		return errors.Errorf("CA does not support CRL Generation")
+	g, err := a.newCRLGenerator()
+	if err != nil {
+		return err
 	}
 
 	// use a mutex to ensure only one CRL is generated at a time to avoid
@@ -776,98 +845,21 @@ func (a *Authority) GenerateCertificateRevocationList() error {
 	a.crlMutex.Lock()
 	defer a.crlMutex.Unlock()
 
-	crlInfo, err := crlDB.GetCRL()
-	if err != nil && !database.IsErrNotFound(err) {
-		return errors.Wrap(err, "could not retrieve CRL from database")
-	}
-
-	now := time.Now().Truncate(time.Second).UTC()
-	revokedList, err := crlDB.GetRevokedCertificates()
-	if err != nil {
-		return errors.Wrap(err, "could not retrieve revoked certificates list from database")
-	}
-
-	// Number is a monotonically increasing integer (essentially the CRL version
-	// number) that we need to keep track of and increase every time we generate
-	// a new CRL
-	var bn big.Int
-	if crlInfo != nil {
-		bn.SetInt64(crlInfo.Number + 1)
+	if err := g.load(); err != nil {
+		return err
 	}
 
-	// Convert our database db.RevokedCertificateInfo types into the pkix
-	// representation ready for the CAS to sign it
-	var revokedCertificates []pkix.RevokedCertificate
-	skipExpiredTime := now.Add(-config.DefaultCRLExpiredDuration)
-	for _, revokedCert := range *revokedList {
-		// skip expired certificates
-		if !revokedCert.ExpiresAt.IsZero() && revokedCert.ExpiresAt.Before(skipExpiredTime) {
-			continue
+	for _, scope := range a.crlScopes() {
+		if err := g.generate(scope, false); err != nil {
+			return err
 		}
-
-		var sn big.Int
-		sn.SetString(revokedCert.Serial, 10)
-		revokedCertificates = append(revokedCertificates, pkix.RevokedCertificate{
-			SerialNumber:   &sn,
-			RevocationTime: revokedCert.RevokedAt,
-			Extensions:     nil,
-		})
-	}
-
-	var updateDuration time.Duration
-	if a.config.CRL.CacheDuration != nil {
-		updateDuration = a.config.CRL.CacheDuration.Duration
-	} else if crlInfo != nil {
-		updateDuration = crlInfo.Duration
-	}
-
-	// Create a RevocationList representation ready for the CAS to sign
-	// TODO: allow SignatureAlgorithm to be specified?
-	revocationList := x509.RevocationList{
-		SignatureAlgorithm:  0,
-		RevokedCertificates: revokedCertificates,
-		Number:              &bn,
-		ThisUpdate:          now,
-		NextUpdate:          now.Add(updateDuration),
-	}
-
-	// Set CRL IDP to config item, otherwise, leave as default
-	var fullName string
-	if a.config.CRL.IDPurl != "" {
-		fullName = a.config.CRL.IDPurl
-	} else {
-		fullName = a.config.Audience("/1.0/crl")[0]
-	}
-
-	// Add distribution point.
-	//
-	// Note that this is currently using the port 443 by default.
-	if b, err := marshalDistributionPoint(fullName, false); err == nil {
-		revocationList.ExtraExtensions = []pkix.Extension{
-			{Id: oidExtensionIssuingDistributionPoint, Critical: true, Value: b},
+		if a.config.CRL.IsDeltaEnabled() {
+			if err := g.generate(scope, true); err != nil {
+				return err
+			}
 		}
 	}
 
-	certificateRevocationList, err := caCRLGenerator.CreateCRL(&casapi.CreateCRLRequest{RevocationList: &revocationList})
-	if err != nil {
-		return errors.Wrap(err, "could not create CRL")
-	}
-
-	// Create a new db.CertificateRevocationListInfo, which stores the new Number we just generated, the
-	// expiry time, duration, and the DER-encoded CRL
-	newCRLInfo := db.CertificateRevocationListInfo{
-		Number:    bn.Int64(),
-		ExpiresAt: revocationList.NextUpdate,
-		DER:       certificateRevocationList.CRL,
-		Duration:  updateDuration,
-	}
-
-	// Store the CRL in the database ready for retrieval by api endpoints
-	err = crlDB.StoreCRL(&newCRLInfo)
-	if err != nil {
-		return errors.Wrap(err, "could not store CRL in database")
-	}
-
 	return nil
 }
 
@@ -1019,18 +1011,40 @@ func templatingError(err error) error {
 	return errors.Wrap(cause, "error applying certificate template")
 }
 
+// newWebhookAttestationData returns the attestation data sent to the webhooks.
+func newWebhookAttestationData(attData *provisioner.AttestationData) *webhook.AttestationData {
+	if attData == nil {
+		return nil
+	}
+	attested := &webhook.AttestationData{
+		PermanentIdentifier: attData.PermanentIdentifier,
+	}
+	if v := attData.TPMEKValidation; v != nil {
+		attested.TPMEKValidation = &webhook.TPMEKValidation{
+			EKKeyID:                  v.EKKeyID,
+			Chain:                    v.Chain,
+			RevocationChecked:        v.RevocationChecked,
+			PermanentIdentifierBound: v.PermanentIdentifierBound,
+		}
+	}
+	if d := attData.AndroidDevice; d != nil {
+		attested.AndroidDevice = &webhook.AndroidDevice{
+			Brand:             d.Brand,
+			Manufacturer:      d.Manufacturer,
+			Model:             d.Model,
+			DeviceIdentifiers: d.DeviceIdentifiers,
+		}
+	}
+	return attested
+}
+
 func (a *Authority) callEnrichingWebhooksX509(ctx context.Context, prov provisioner.Interface, webhookCtl webhookController, attData *provisioner.AttestationData, csr *x509.CertificateRequest) (err error) {
 	if webhookCtl == nil {
 		return
 	}
 	defer func() { a.meter.X509WebhookEnriched(prov, err) }()
 
-	var attested *webhook.AttestationData
-	if attData != nil {
-		attested = &webhook.AttestationData{
-			PermanentIdentifier: attData.PermanentIdentifier,
-		}
-	}
+	attested := newWebhookAttestationData(attData)
 
 	var whEnrichReq *webhook.RequestBody
 	if whEnrichReq, err = webhook.NewRequestBody(
@@ -1049,12 +1063,7 @@ func (a *Authority) callAuthorizingWebhooksX509(ctx context.Context, prov provis
 	}
 	defer func() { a.meter.X509WebhookAuthorized(prov, err) }()
 
-	var attested *webhook.AttestationData
-	if attData != nil {
-		attested = &webhook.AttestationData{
-			PermanentIdentifier: attData.PermanentIdentifier,
-		}
-	}
+	attested := newWebhookAttestationData(attData)
 
 	var whAuthBody *webhook.RequestBody
 	if whAuthBody, err = webhook.NewRequestBody(
@@ -34,6 +34,7 @@ import (
 	"github.com/smallstep/certificates/cas/softcas"
 	"github.com/smallstep/certificates/db"
 	"github.com/smallstep/certificates/errs"
+	"github.com/smallstep/certificates/webhook"
 	"github.com/smallstep/nosql/database"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -2066,3 +2067,32 @@ func TestAuthority_GetX509Signer(t *testing.T) {
 		})
 	}
 }
+
+func Test_newWebhookAttestationData(t *testing.T) {
+	assert.Nil(t, newWebhookAttestationData(nil))
+	assert.Equal(t, &webhook.AttestationData{
+		PermanentIdentifier: "serial-number",
+		TPMEKValidation: &webhook.TPMEKValidation{
+			EKKeyID: "urn:ek:sha256:Zm9vYmFy",
+			Chain:   []string{"", "CN=Root"},
+		},
+		AndroidDevice: &webhook.AndroidDevice{
+			Brand:             "google",
+			Manufacturer:      "Google",
+			Model:             "Pixel 8",
+			DeviceIdentifiers: []string{"serial-number"},
+		},
+	}, newWebhookAttestationData(&provisioner.AttestationData{
+		PermanentIdentifier: "serial-number",
+		TPMEKValidation: &provisioner.TPMEKValidation{
+			EKKeyID: "urn:ek:sha256:Zm9vYmFy",
+			Chain:   []string{"", "CN=Root"},
+		},
+		AndroidDevice: &provisioner.AndroidDevice{
+			Brand:             "google",
+			Manufacturer:      "Google",
+			Model:             "Pixel 8",
+			DeviceIdentifiers: []string{"serial-number"},
+		},
+	}))
+}
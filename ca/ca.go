@@ -3,6 +3,7 @@ package ca
 import (
 	"bytes"
 	"context"
+	"crypto"
 	"crypto/tls"
 	"crypto/x509"
 	"fmt"
@@ -21,18 +22,22 @@ import (
 
 	"github.com/smallstep/cli-utils/step"
 	"github.com/smallstep/nosql"
+	"go.step.sm/crypto/pemutil"
 	"go.step.sm/crypto/x509util"
 
 	"github.com/smallstep/certificates/acme"
 	acmeAPI "github.com/smallstep/certificates/acme/api"
 	acmeNoSQL "github.com/smallstep/certificates/acme/db/nosql"
+	"github.com/smallstep/certificates/acme/email"
 	"github.com/smallstep/certificates/api"
 	"github.com/smallstep/certificates/authority"
 	"github.com/smallstep/certificates/authority/admin"
 	adminAPI "github.com/smallstep/certificates/authority/admin/api"
 	"github.com/smallstep/certificates/authority/config"
 	"github.com/smallstep/certificates/cas/apiv1"
+	cmpAPI "github.com/smallstep/certificates/cmp/api"
 	"github.com/smallstep/certificates/db"
+	estAPI "github.com/smallstep/certificates/est/api"
 	"github.com/smallstep/certificates/internal/httptransport"
 	"github.com/smallstep/certificates/internal/metrix"
 	"github.com/smallstep/certificates/logging"
@@ -152,6 +157,12 @@ type CA struct {
 	opts        *options
 	renewer     *TLSRenewer
 	compactStop chan struct{}
+	acmeGCStop  chan struct{}
+	acmeDB      acme.DB
+	validator   *acme.AsyncValidator
+	acmeRenewer *acme.AutoRenewer
+	emailSrv    *email.Server
+	meter       *metrix.Meter
 }
 
 // New creates and initializes the CA with the given configuration and options.
@@ -160,6 +171,7 @@ func New(cfg *config.Config, opts ...Option) (*CA, error) {
 		config:      cfg,
 		opts:        new(options),
 		compactStop: make(chan struct{}),
+		acmeGCStop:  make(chan struct{}),
 	}
 	ca.opts.apply(opts)
 	return ca.Init(cfg)
@@ -196,6 +208,7 @@ func (ca *CA) Init(cfg *config.Config) (*CA, error) {
 		meter = metrix.New()
 		opts = append(opts, authority.WithMeter(meter))
 	}
+	ca.meter = meter
 
 	webhookTransport := httptransport.New()
 	opts = append(opts,
@@ -245,7 +258,15 @@ func (ca *CA) Init(cfg *config.Config) (*CA, error) {
 
 	// Mount the CRL to the insecure mux
 	insecureMux.Get("/crl", api.CRL)
+	insecureMux.Get("/crl/{name}", api.CRL)
 	insecureMux.Get("/1.0/crl", api.CRL)
+	insecureMux.Get("/1.0/crl/{name}", api.CRL)
+
+	// Mount the OCSP responder to the insecure mux
+	insecureMux.Post("/ocsp", api.OCSP)
+	insecureMux.Get("/ocsp/*", api.OCSP)
+	insecureMux.Post("/1.0/ocsp", api.OCSP)
+	insecureMux.Get("/1.0/ocsp/*", api.OCSP)
 
 	// Add ACME api endpoints in /acme and /1.0/acme
 	dns := cfg.DNSNames[0]
@@ -266,6 +287,7 @@ func (ca *CA) Init(cfg *config.Config) (*CA, error) {
 		if err != nil {
 			return nil, errors.Wrap(err, "error configuring ACME DB interface")
 		}
+		ca.acmeDB = acmeDB
 		acmeLinker = acme.NewLinker(dns, "acme")
 		mux.Route("/acme", func(r chi.Router) {
 			acmeAPI.Route(r)
@@ -320,6 +342,21 @@ func (ca *CA) Init(cfg *config.Config) (*CA, error) {
 		})
 	}
 
+	// EST (RFC 7030) requires HTTPS, so the API is only mounted on the secure
+	// mux. The provisioner name is used as the EST label.
+	mux.Route("/.well-known/est", func(r chi.Router) {
+		estAPI.Route(r)
+	})
+
+	// CMP (RFC 9483) messages are protected, so they can be sent using HTTP
+	// or HTTPS. The provisioner name is used as the CMP profile label.
+	insecureMux.Route("/.well-known/cmp/p", func(r chi.Router) {
+		cmpAPI.Route(r)
+	})
+	mux.Route("/.well-known/cmp/p", func(r chi.Router) {
+		cmpAPI.Route(r)
+	})
+
 	// helpful routine for logging all routes
 	//dumpRoutes(mux)
 	//dumpRoutes(insecureMux)
@@ -350,8 +387,63 @@ func (ca *CA) Init(cfg *config.Config) (*CA, error) {
 	handler = requestid.New(legacyTraceHeader).Middleware(handler)
 	insecureHandler = requestid.New(legacyTraceHeader).Middleware(insecureHandler)
 
+	// Asynchronous validation of ACME challenges.
+	if acmeDB != nil && cfg.ACMEValidation.IsEnabled() {
+		vc := cfg.ACMEValidation
+		ca.validator, err = acme.NewAsyncValidator(acme.AsyncValidatorOptions{
+			Workers:     vc.Workers,
+			RetryWindow: vc.RetryWindow.Duration,
+			MinBackoff:  vc.MinBackoff.Duration,
+			MaxBackoff:  vc.MaxBackoff.Duration,
+			OnComplete:  acmeAPI.CountFailedValidation,
+		})
+		if err != nil {
+			return nil, errors.Wrap(err, "error configuring ACME validator")
+		}
+	}
+
 	// Create context with all the necessary values.
 	baseContext := buildContext(auth, scepAuthority, acmeDB, acmeLinker)
+	if ca.validator != nil {
+		baseContext = acme.NewAsyncValidatorContext(baseContext, ca.validator)
+		ca.validator.Start(baseContext)
+	}
+
+	// Reissue the certificates of the ACME auto-renewal orders.
+	if _, ok := acmeDB.(acme.AutoRenewalDB); ok {
+		ca.acmeRenewer = acme.NewAutoRenewer(auth)
+		ca.acmeRenewer.OnError = func(o *acme.Order, err error) {
+			if o != nil {
+				log.Printf("error renewing certificate of ACME order %s: %v", o.ID, err)
+			} else {
+				log.Printf("error renewing ACME certificates: %v", err)
+			}
+		}
+		ca.acmeRenewer.Start(baseContext)
+	}
+
+	// Sending and receiving of the email-reply-00 challenge emails.
+	if acmeDB != nil && cfg.ACMEEmail != nil {
+		sender, err := newACMEEmailSender(cfg.ACMEEmail)
+		if err != nil {
+			return nil, errors.Wrap(err, "error configuring ACME email sender")
+		}
+		baseContext = acme.NewEmailOptionsContext(baseContext, &acme.EmailOptions{
+			From:   cfg.ACMEEmail.From,
+			Sender: sender,
+			OnError: func(_ *acme.Challenge, err error) {
+				log.Printf("error sending ACME challenge email: %v", err)
+			},
+		})
+		emailContext := baseContext
+		ca.emailSrv = &email.Server{
+			Addr:     cfg.ACMEEmail.ListenAddress,
+			Hostname: cfg.ACMEEmail.From[strings.LastIndex(cfg.ACMEEmail.From, "@")+1:],
+			Handler: func(_ string, _ []string, data []byte) error {
+				return acme.HandleEmailReply(emailContext, acmeDB, data)
+			},
+		}
+	}
 
 	ca.srv = server.New(cfg.Address, handler, tlsConfig)
 	ca.srv.BaseContext = func(net.Listener) context.Context {
@@ -384,7 +476,7 @@ func (ca *CA) Init(cfg *config.Config) (*CA, error) {
 // shouldServeInsecureServer returns whether or not the insecure
 // server should also be started. This is (currently) only the case
 // if the insecure address has been configured AND when a SCEP
-// provisioner is configured or when a CRL is configured.
+// provisioner is configured or when a CRL or OCSP responder is configured.
 func (ca *CA) shouldServeInsecureServer() bool {
 	switch {
 	case ca.config.InsecureAddress == "":
@@ -393,6 +485,8 @@ func (ca *CA) shouldServeInsecureServer() bool {
 		return true
 	case ca.config.CRL.IsEnabled():
 		return true
+	case ca.config.OCSP.IsEnabled():
+		return true
 	default:
 		return false
 	}
@@ -456,6 +550,12 @@ func (ca *CA) Run() error {
 		ca.runCompactJob()
 	}()
 
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		ca.runACMEGCJob()
+	}()
+
 	if ca.insecureSrv != nil {
 		wg.Add(1)
 		go func() {
@@ -472,6 +572,16 @@ func (ca *CA) Run() error {
 		}()
 	}
 
+	if ca.emailSrv != nil {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := ca.emailSrv.ListenAndServe(); !errors.Is(err, email.ErrServerClosed) {
+				errs <- err
+			}
+		}()
+	}
+
 	wg.Add(1)
 	go func() {
 		defer wg.Done()
@@ -503,9 +613,19 @@ func (ca *CA) Run() error {
 // Stop stops the CA calling to the server Shutdown method.
 func (ca *CA) Stop() error {
 	close(ca.compactStop)
+	close(ca.acmeGCStop)
 	if ca.renewer != nil {
 		ca.renewer.Stop()
 	}
+	if ca.validator != nil {
+		ca.validator.Stop()
+	}
+	if ca.acmeRenewer != nil {
+		ca.acmeRenewer.Stop()
+	}
+	if ca.emailSrv != nil {
+		ca.emailSrv.Close()
+	}
 
 	if err := ca.auth.Shutdown(); err != nil {
 		log.Printf("error stopping ca.Authority: %+v\n", err)
@@ -577,22 +697,69 @@ func (ca *CA) Reload() error {
 		return errors.Wrap(err, "error reloading server")
 	}
 
-	// 1. Stop previous renewer
+	// 1. Stop previous renewer, ACME validator and ACME renewer
 	// 2. Safely shutdown any internal resources (e.g. key manager)
 	// 3. Replace ca properties
 	// Do not replace ca.srv
 	if ca.renewer != nil {
 		ca.renewer.Stop()
 	}
+	if ca.validator != nil {
+		ca.validator.Stop()
+	}
+	if ca.acmeRenewer != nil {
+		ca.acmeRenewer.Stop()
+	}
+
+	// The SMTP server receiving the email-reply-00 replies cannot be
+	// replaced gracefully, so it's restarted.
+	if ca.emailSrv != nil {
+		ca.emailSrv.Close()
+	}
+	if newCA.emailSrv != nil {
+		go func(srv *email.Server) {
+			if err := srv.ListenAndServe(); !errors.Is(err, email.ErrServerClosed) {
+				log.Printf("error running ACME email server: %v", err)
+			}
+		}(newCA.emailSrv)
+	}
 
 	ca.auth.CloseForReload()
 	ca.auth = newCA.auth
 	ca.config = newCA.config
 	ca.opts = newCA.opts
 	ca.renewer = newCA.renewer
+	ca.validator = newCA.validator
+	ca.acmeRenewer = newCA.acmeRenewer
+	ca.emailSrv = newCA.emailSrv
 	return nil
 }
 
+// newACMEEmailSender creates the sender of the email-reply-00 challenge
+// emails.
+func newACMEEmailSender(cfg *config.ACMEEmailConfig) (*email.SMTPSender, error) {
+	sender, err := email.NewSMTPSender(cfg.Relay, cfg.Username, cfg.Password)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.DKIM != nil {
+		key, err := pemutil.Read(cfg.DKIM.Key)
+		if err != nil {
+			return nil, err
+		}
+		signer, ok := key.(crypto.Signer)
+		if !ok {
+			return nil, errors.Errorf("key %s is not a crypto.Signer", cfg.DKIM.Key)
+		}
+		sender.DKIM = &email.DKIMSigner{
+			Domain:   cfg.DKIM.Domain,
+			Selector: cfg.DKIM.Selector,
+			Signer:   signer,
+		}
+	}
+	return sender, nil
+}
+
 // get TLSConfig returns separate TLSConfigs for server and client with the
 // same self-renewing certificate.
 func (ca *CA) getTLSConfig(auth *authority.Authority) (*tls.Config, *tls.Config, error) {
@@ -734,3 +901,59 @@ func runCompact(c nosql.Compactor) {
 		err = c.Compact(0.7)
 	}
 }
+
+// acmeSweeper is the interface implemented by the ACME databases that support
+// the removal of stale objects.
+type acmeSweeper interface {
+	Sweep(ctx context.Context, opts acmeNoSQL.SweepOptions) (*acmeNoSQL.SweepResult, error)
+}
+
+// runACMEGCJob periodically removes the stale ACME orders, authorizations,
+// challenges and nonces if the ACME garbage collector is enabled.
+func (ca *CA) runACMEGCJob() {
+	cfg := ca.config.ACMEGC
+	if !cfg.IsEnabled() {
+		return
+	}
+	sweeper, ok := ca.acmeDB.(acmeSweeper)
+	if !ok {
+		return
+	}
+
+	opts := acmeNoSQL.SweepOptions{
+		Retention:      cfg.Retention.Duration,
+		NonceRetention: cfg.NonceRetention.Duration,
+	}
+
+	// Sweep database at start.
+	ca.runACMEGC(sweeper, opts)
+
+	ticker := time.NewTicker(cfg.Interval.Duration)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ca.acmeGCStop:
+			return
+		case <-ticker.C:
+			ca.runACMEGC(sweeper, opts)
+		}
+	}
+}
+
+// runACMEGC executes one run of the ACME garbage collector.
+func (ca *CA) runACMEGC(s acmeSweeper, opts acmeNoSQL.SweepOptions) {
+	res, err := s.Sweep(context.Background(), opts)
+	if err != nil {
+		log.Printf("error removing stale ACME objects: %v", err)
+	}
+	if res == nil {
+		res = new(acmeNoSQL.SweepResult)
+	}
+	if n := res.Total(); n > 0 && !ca.opts.quiet {
+		log.Printf("Removed %d stale ACME objects", n)
+	}
+	if ca.meter != nil {
+		ca.meter.ACMESwept(res.Counts(), err)
+	}
+}
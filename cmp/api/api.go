@@ -0,0 +1,694 @@
+// Package api implements a Lightweight CMP (RFC 9483) HTTP server.
+package api
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"slices"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/smallstep/nosql/database"
+
+	"github.com/smallstep/certificates/api"
+	"github.com/smallstep/certificates/api/log"
+	"github.com/smallstep/certificates/authority"
+	"github.com/smallstep/certificates/authority/provisioner"
+	"github.com/smallstep/certificates/cmp"
+	"github.com/smallstep/certificates/db"
+	"github.com/smallstep/certificates/errs"
+)
+
+const (
+	maxPayloadSize = 2 << 20
+
+	// contentType is the media type of the CMP messages, RFC 6712 section 3.4.
+	contentType = "application/pkixcmp"
+
+	// confirmWaitTime is the time the server waits for the confirmation of an
+	// issued certificate.
+	confirmWaitTime = 10 * time.Minute
+)
+
+// Authority is the interface implemented by the CA authority used by the CMP
+// handlers.
+type Authority interface {
+	LoadProvisionerByName(string) (provisioner.Interface, error)
+	SignWithContext(ctx context.Context, cr *x509.CertificateRequest, opts provisioner.SignOptions, signOpts ...provisioner.SignOption) ([]*x509.Certificate, error)
+	Revoke(context.Context, *authority.RevokeOptions) error
+	GetRoots() ([]*x509.Certificate, error)
+	GetIntermediateCertificates() []*x509.Certificate
+	GetX509Signer() (crypto.Signer, error)
+	IsRevoked(sn string) (bool, error)
+}
+
+// mustAuthority will be replaced on unit tests.
+var mustAuthority = func(ctx context.Context) Authority {
+	return authority.MustFromContext(ctx)
+}
+
+// Error is an error with the HTTP status code of the CMP response. It's only
+// used when the request cannot be answered with a CMP error message.
+type Error struct {
+	Status int
+	Err    error
+}
+
+func newError(status int, format string, args ...any) *Error {
+	return &Error{Status: status, Err: fmt.Errorf(format, args...)}
+}
+
+// Error implements the error interface.
+func (e *Error) Error() string {
+	return e.Err.Error()
+}
+
+// Unwrap returns the wrapped error.
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// Route traffic and implement the Router interface. The routes are expected
+// to be mounted on /.well-known/cmp/p, the provisioner name is used as the
+// CMP profile label. The optional operation label is ignored.
+func Route(r api.Router) {
+	r.MethodFunc(http.MethodPost, "/{provisionerName}", lookupProvisioner(Post))
+	r.MethodFunc(http.MethodPost, "/{provisionerName}/{operationLabel}", lookupProvisioner(Post))
+}
+
+// provisionerKey is the key type for storing and searching a CMP
+// provisioner in the context.
+type provisionerKey struct{}
+
+// provisionerFromContext returns the CMP provisioner in the context.
+func provisionerFromContext(ctx context.Context) *provisioner.CMP {
+	p, ok := ctx.Value(provisionerKey{}).(*provisioner.CMP)
+	if !ok {
+		panic("CMP provisioner expected in request context")
+	}
+	return p
+}
+
+// lookupProvisioner loads the provisioner associated with the request.
+// Responds 404 if the provisioner does not exist.
+func lookupProvisioner(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		name := chi.URLParam(r, "provisionerName")
+		provisionerName, err := url.PathUnescape(name)
+		if err != nil {
+			fail(w, r, newError(http.StatusBadRequest, "error url unescaping provisioner name '%s'", name))
+			return
+		}
+
+		ctx := r.Context()
+		p, err := mustAuthority(ctx).LoadProvisionerByName(provisionerName)
+		if err != nil {
+			fail(w, r, &Error{Status: http.StatusNotFound, Err: err})
+			return
+		}
+
+		prov, ok := p.(*provisioner.CMP)
+		if !ok {
+			fail(w, r, newError(http.StatusNotFound, "provisioner must be of type CMP"))
+			return
+		}
+
+		ctx = context.WithValue(ctx, provisionerKey{}, prov)
+		next(w, r.WithContext(ctx))
+	}
+}
+
+// sender is the authenticated sender of a request. The sender is identified by
+// the shared secret, or by the certificate used to sign the request.
+type sender struct {
+	mac  bool
+	cert *x509.Certificate
+}
+
+func (s *sender) equal(other *sender) bool {
+	switch {
+	case s.mac || other.mac:
+		return s.mac == other.mac
+	default:
+		return s.cert != nil && other.cert != nil && s.cert.Equal(other.cert)
+	}
+}
+
+// response is the body of a response message.
+type response struct {
+	typ             cmp.BodyType
+	body            []byte
+	extraCerts      []*x509.Certificate
+	implicitConfirm bool
+}
+
+// Post processes a CMP request, RFC 9483 section 6.1. Errors processing the
+// request are returned to the client in CMP error messages.
+func Post(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	req, err := readMessage(r)
+	if err != nil {
+		fail(w, r, err)
+		return
+	}
+
+	s, err := authenticate(ctx, req)
+	var res *response
+	if err == nil {
+		res, err = process(ctx, w, req, s)
+	}
+	if err != nil {
+		log.Error(w, r, err)
+		if res, err = errorResponse(err); err != nil {
+			fail(w, r, err)
+			return
+		}
+	}
+
+	nonce := make([]byte, 16)
+	if _, err := rand.Read(nonce); err != nil {
+		fail(w, r, fmt.Errorf("error generating nonce: %w", err))
+		return
+	}
+	msg := cmp.NewResponse(req, res.typ, res.body, nonce)
+	if res.implicitConfirm {
+		msg.Header.GeneralInfo = []cmp.InfoTypeAndValue{{InfoType: cmp.OIDImplicitConfirm}}
+	}
+	if err := protect(ctx, req, msg, s, res); err != nil {
+		fail(w, r, err)
+		return
+	}
+
+	data, err := msg.Marshal()
+	if err != nil {
+		fail(w, r, err)
+		return
+	}
+	w.Header().Set("Content-Type", contentType)
+	_, _ = w.Write(data)
+}
+
+// readMessage reads the CMP message in the body of a request.
+func readMessage(r *http.Request) (*cmp.Message, error) {
+	if mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type")); err != nil || mediaType != contentType {
+		return nil, newError(http.StatusUnsupportedMediaType, "content type must be %s", contentType)
+	}
+
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxPayloadSize))
+	if err != nil {
+		return nil, fmt.Errorf("failed reading request body: %w", err)
+	}
+	msg, err := cmp.ParseMessage(body)
+	if err != nil {
+		return nil, &Error{Status: http.StatusBadRequest, Err: err}
+	}
+	return msg, nil
+}
+
+// authenticate validates the header and the protection of a request. MAC
+// protected requests are verified with the shared secret of the provisioner.
+// Signed initialization and certification requests must be signed with a
+// certificate issued by the provisioner roots, key update requests with a
+// certificate issued by this provisioner, and revocation requests with a
+// certificate issued by the CA.
+func authenticate(ctx context.Context, req *cmp.Message) (*sender, error) {
+	p := provisionerFromContext(ctx)
+	switch {
+	case req.Header.PVNO != cmp.Version2000 && req.Header.PVNO != cmp.Version2021:
+		return nil, cmp.NewError(cmp.FailUnsupportedVersion, "unsupported protocol version %d", req.Header.PVNO)
+	case len(req.Header.TransactionID) == 0:
+		return nil, cmp.NewError(cmp.FailBadRequest, "transaction identifier is missing")
+	case len(req.Header.SenderNonce) == 0:
+		return nil, cmp.NewError(cmp.FailBadSenderNonce, "sender nonce is missing")
+	}
+
+	if req.IsMACProtected() {
+		secret := p.GetSharedSecret()
+		if secret == nil {
+			return nil, cmp.NewError(cmp.FailWrongIntegrity, "MAC protection is not enabled")
+		}
+		if err := req.VerifyMAC(secret); err != nil {
+			return nil, err
+		}
+		return &sender{mac: true}, nil
+	}
+
+	cert, err := req.VerifySignature()
+	if err != nil {
+		return nil, err
+	}
+	intermediates := req.ExtraCerts[1:]
+	switch req.Type {
+	case cmp.BodyIR, cmp.BodyCR, cmp.BodyP10CR:
+		err = p.VerifyCertificate(cert, intermediates)
+	case cmp.BodyKUR:
+		if err = verifyCertificate(ctx, cert); err == nil {
+			err = verifyProvisioner(p, cert)
+		}
+	case cmp.BodyRR:
+		err = verifyCertificate(ctx, cert)
+	default:
+		if err = p.VerifyCertificate(cert, intermediates); err != nil {
+			err = verifyCertificate(ctx, cert)
+		}
+	}
+	if err != nil {
+		return nil, cmp.NewError(cmp.FailSignerNotTrusted, "signer certificate is not trusted: %v", err)
+	}
+	return &sender{cert: cert}, nil
+}
+
+// verifyProvisioner verifies that the certificate was issued by the given CMP
+// provisioner.
+func verifyProvisioner(p *provisioner.CMP, cert *x509.Certificate) error {
+	if ext, ok := provisioner.GetProvisionerExtension(cert); ok && ext.Type == provisioner.TypeCMP && ext.Name == p.GetName() {
+		return nil
+	}
+	return errors.New("certificate was not issued by this provisioner")
+}
+
+// verifyCertificate verifies that the certificate was issued by the CA and
+// that it's not revoked.
+func verifyCertificate(ctx context.Context, cert *x509.Certificate) error {
+	auth := mustAuthority(ctx)
+	roots, err := auth.GetRoots()
+	if err != nil {
+		return fmt.Errorf("error getting roots: %w", err)
+	}
+
+	rootPool := x509.NewCertPool()
+	for _, root := range roots {
+		rootPool.AddCert(root)
+	}
+	intermediatePool := x509.NewCertPool()
+	for _, crt := range auth.GetIntermediateCertificates() {
+		intermediatePool.AddCert(crt)
+	}
+	if _, err := cert.Verify(x509.VerifyOptions{
+		Roots:         rootPool,
+		Intermediates: intermediatePool,
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageAny},
+	}); err != nil {
+		return err
+	}
+
+	revoked, err := auth.IsRevoked(cert.SerialNumber.String())
+	switch {
+	case err != nil:
+		return fmt.Errorf("error checking certificate revocation: %w", err)
+	case revoked:
+		return errors.New("certificate has been revoked")
+	}
+	return nil
+}
+
+// process processes an authenticated request and returns the body of the
+// response.
+func process(ctx context.Context, w http.ResponseWriter, req *cmp.Message, s *sender) (*response, error) {
+	switch req.Type {
+	case cmp.BodyIR, cmp.BodyCR, cmp.BodyKUR:
+		msgs, err := cmp.ParseCertReqMessages(req.Body)
+		if err != nil {
+			return nil, err
+		}
+		if len(msgs) > 1 {
+			return nil, cmp.NewError(cmp.FailBadRequest, "only one certificate request is supported")
+		}
+		var old *x509.Certificate
+		if req.Type == cmp.BodyKUR {
+			old = s.cert
+		}
+		csr, err := msgs[0].CertificateRequest(old)
+		if err != nil {
+			return nil, err
+		}
+		if old != nil && !provisioner.SameCertificateNames(csr, old) {
+			return nil, cmp.NewError(cmp.FailBadCertTemplate, "certificate template subject and subject alternative names do not match the certificate to update")
+		}
+		notBefore, notAfter, err := msgs[0].Validity()
+		if err != nil {
+			return nil, err
+		}
+		return certResponse(ctx, w, req, s, msgs[0].CertReq.CertReqID, csr, provisioner.SignOptions{
+			NotBefore: provisioner.NewTimeDuration(notBefore),
+			NotAfter:  provisioner.NewTimeDuration(notAfter),
+		})
+	case cmp.BodyP10CR:
+		csr, err := cmp.ParseP10CertReqContent(req.Body)
+		if err != nil {
+			return nil, err
+		}
+		// RFC 4210 section 5.3.4, the certReqId is -1 for p10cr responses.
+		return certResponse(ctx, w, req, s, -1, csr, provisioner.SignOptions{})
+	case cmp.BodyCertConf:
+		return certConfirm(ctx, req, s)
+	case cmp.BodyRR:
+		return revoke(ctx, req, s)
+	case cmp.BodyGenM:
+		return generalMessage(ctx, req)
+	default:
+		return nil, cmp.NewError(cmp.FailBadRequest, "unsupported message type %s", req.Type)
+	}
+}
+
+// certResponse signs a certificate request and returns the ip, cp or kup
+// response. If the client didn't request implicit confirmation the
+// certificate is stored in the database waiting for a certConf message.
+// Implicit confirmation is required if the database cannot store them.
+func certResponse(ctx context.Context, w http.ResponseWriter, req *cmp.Message, s *sender, certReqID int, csr *x509.CertificateRequest, opts provisioner.SignOptions) (*response, error) {
+	if err := csr.CheckSignature(); err != nil {
+		return nil, cmp.NewError(cmp.FailBadPOP, "invalid proof-of-possession: %v", err)
+	}
+
+	implicitConfirm := req.Header.HasGeneralInfo(cmp.OIDImplicitConfirm)
+	cdb, ok := confirmationDB(ctx)
+	if !implicitConfirm && !ok {
+		return nil, cmp.NewError(cmp.FailBadRequest, "implicit confirmation is required")
+	}
+
+	chain, err := signCSR(ctx, csr, opts)
+	if err != nil {
+		return nil, err
+	}
+	api.LogCertificate(w, chain[0])
+
+	// Send the roots to MAC authenticated clients, they might not have them.
+	var caPubs []*x509.Certificate
+	if s.mac {
+		if caPubs, err = mustAuthority(ctx).GetRoots(); err != nil {
+			return nil, fmt.Errorf("error getting roots: %w", err)
+		}
+	}
+	body, err := cmp.NewCertRepContent(certReqID, chain[0], caPubs)
+	if err != nil {
+		return nil, err
+	}
+
+	typ := cmp.BodyCP
+	switch req.Type {
+	case cmp.BodyIR:
+		typ = cmp.BodyIP
+	case cmp.BodyKUR:
+		typ = cmp.BodyKUP
+	}
+
+	if !implicitConfirm {
+		c := &db.CMPConfirmation{
+			ProvisionerID: provisionerFromContext(ctx).GetID(),
+			TransactionID: hex.EncodeToString(req.Header.TransactionID),
+			CertReqID:     certReqID,
+			Certificate:   chain[0].Raw,
+			SenderMAC:     s.mac,
+			ExpiresAt:     time.Now().Add(confirmWaitTime),
+		}
+		if s.cert != nil {
+			c.SenderCertificate = s.cert.Raw
+		}
+		if err := cdb.CreateCMPConfirmation(c); err != nil {
+			return nil, fmt.Errorf("error storing certificate confirmation: %w", err)
+		}
+	}
+
+	return &response{
+		typ:             typ,
+		body:            body,
+		extraCerts:      chain[1:],
+		implicitConfirm: implicitConfirm,
+	}, nil
+}
+
+// certConfirm processes a certConf message and returns a pkiconf response. If
+// the client rejects the certificate, the certificate is revoked.
+func certConfirm(ctx context.Context, req *cmp.Message, s *sender) (*response, error) {
+	statuses, err := cmp.ParseCertConfirmContent(req.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	pc, err := useConfirmation(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case !pc.sender.equal(s):
+		return nil, cmp.NewError(cmp.FailNotAuthorized, "certificate confirmation sender does not match the request sender")
+	case len(statuses) > 1:
+		return nil, cmp.NewError(cmp.FailBadRequest, "only one certificate status is supported")
+	}
+
+	// An empty list of statuses rejects the certificate.
+	rejected := len(statuses) == 0
+	if !rejected {
+		st := statuses[0]
+		if st.CertReqID != pc.certReqID {
+			return nil, cmp.NewError(cmp.FailBadCertID, "unknown certificate request id %d", st.CertReqID)
+		}
+		sum, err := cmp.CertificateHash(pc.cert, st.HashAlg)
+		if err != nil {
+			return nil, err
+		}
+		if !bytes.Equal(sum, st.CertHash) {
+			return nil, cmp.NewError(cmp.FailBadCertID, "certificate hash does not match the issued certificate")
+		}
+		rejected = st.StatusInfo.Status == cmp.StatusRejection
+	}
+
+	if rejected {
+		ctx = provisioner.NewContextWithMethod(ctx, provisioner.RevokeMethod)
+		if err := mustAuthority(ctx).Revoke(ctx, &authority.RevokeOptions{
+			Serial: pc.cert.SerialNumber.String(),
+			Reason: "certificate rejected by the client",
+			MTLS:   true,
+			Crt:    pc.cert,
+		}); err != nil {
+			return nil, fmt.Errorf("error revoking rejected certificate: %w", err)
+		}
+	}
+
+	return &response{
+		typ:  cmp.BodyPKIConf,
+		body: cmp.PKIConfContent,
+	}, nil
+}
+
+// revoke processes a revocation request, RFC 9483 section 4.2. The request
+// must be signed with the certificate to revoke.
+func revoke(ctx context.Context, req *cmp.Message, s *sender) (*response, error) {
+	details, err := cmp.ParseRevReqContent(req.Body)
+	if err != nil {
+		return nil, err
+	}
+	if s.cert == nil || !details.Matches(s.cert) {
+		return nil, cmp.NewError(cmp.FailNotAuthorized, "revocation requests must be signed with the certificate to revoke")
+	}
+	reasonCode, err := details.ReasonCode()
+	if err != nil {
+		return nil, err
+	}
+
+	ctx = provisioner.NewContextWithMethod(ctx, provisioner.RevokeMethod)
+	if err := mustAuthority(ctx).Revoke(ctx, &authority.RevokeOptions{
+		Serial:     s.cert.SerialNumber.String(),
+		ReasonCode: reasonCode,
+		MTLS:       true,
+		Crt:        s.cert,
+	}); err != nil {
+		return nil, fmt.Errorf("error revoking certificate: %w", err)
+	}
+
+	body, err := cmp.NewRevRepContent(cmp.StatusInfo{Status: cmp.StatusAccepted})
+	if err != nil {
+		return nil, err
+	}
+	return &response{
+		typ:  cmp.BodyRP,
+		body: body,
+	}, nil
+}
+
+// generalMessage processes a general message, only the CA certificates
+// request, RFC 9483 section 4.3.1, is supported. Other information types are
+// not included in the response.
+func generalMessage(ctx context.Context, req *cmp.Message) (*response, error) {
+	infos, err := cmp.ParseGenMsgContent(req.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	var values []cmp.InfoTypeAndValue
+	for _, info := range infos {
+		if !info.InfoType.Equal(cmp.OIDCACerts) {
+			continue
+		}
+		auth := mustAuthority(ctx)
+		roots, err := auth.GetRoots()
+		if err != nil {
+			return nil, fmt.Errorf("error getting roots: %w", err)
+		}
+		value, err := cmp.NewCACertsInfo(append(slices.Clone(auth.GetIntermediateCertificates()), roots...))
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, value)
+	}
+
+	body, err := cmp.NewGenRepContent(values)
+	if err != nil {
+		return nil, err
+	}
+	return &response{
+		typ:  cmp.BodyGenP,
+		body: body,
+	}, nil
+}
+
+// errorResponse returns the error message for the given error. Errors that
+// are not CMP errors are reported as system failures without details.
+func errorResponse(err error) (*response, error) {
+	var e *cmp.Error
+	if !errors.As(err, &e) {
+		e = cmp.NewError(cmp.FailSystemFailure, "internal server error")
+	}
+	body, err := cmp.NewErrorMsgContent(e.StatusInfo())
+	if err != nil {
+		return nil, err
+	}
+	return &response{
+		typ:  cmp.BodyError,
+		body: body,
+	}, nil
+}
+
+// protect protects a response. Responses to MAC protected requests use the
+// shared secret, and other responses are signed with the provisioner signer
+// or the CA intermediate key.
+func protect(ctx context.Context, req, msg *cmp.Message, s *sender, res *response) error {
+	if s != nil && s.mac {
+		msg.ExtraCerts = res.extraCerts
+		return msg.ProtectMAC(provisionerFromContext(ctx).GetSharedSecret(), req.Header.ProtectionAlg)
+	}
+
+	signer, chain := provisionerFromContext(ctx).GetSigner()
+	if signer == nil {
+		auth := mustAuthority(ctx)
+		chain = auth.GetIntermediateCertificates()
+		var err error
+		if signer, err = auth.GetX509Signer(); err != nil {
+			return fmt.Errorf("error getting signer: %w", err)
+		}
+	}
+	if len(chain) == 0 {
+		return errors.New("signer certificate is missing")
+	}
+
+	msg.ExtraCerts = slices.Clone(chain)
+	for _, crt := range res.extraCerts {
+		if !slices.ContainsFunc(msg.ExtraCerts, crt.Equal) {
+			msg.ExtraCerts = append(msg.ExtraCerts, crt)
+		}
+	}
+	return msg.ProtectSignature(signer, chain[0])
+}
+
+// signCSR signs the certificate request using the CMP provisioner in the
+// context.
+func signCSR(ctx context.Context, csr *x509.CertificateRequest, opts provisioner.SignOptions) ([]*x509.Certificate, error) {
+	p := provisionerFromContext(ctx)
+
+	ctx = provisioner.NewContextWithMethod(ctx, provisioner.SignMethod)
+	signOps, err := provisioner.CertificateRequestSignOptions(ctx, p, p.GetOptions(), csr)
+	if err != nil {
+		return nil, err
+	}
+
+	certChain, err := mustAuthority(ctx).SignWithContext(ctx, csr, opts, signOps...)
+	if err != nil {
+		var e *errs.Error
+		if errors.As(err, &e) {
+			switch e.StatusCode() {
+			case http.StatusBadRequest:
+				return nil, cmp.NewError(cmp.FailBadCertTemplate, "error generating certificate: %v", err)
+			case http.StatusUnauthorized, http.StatusForbidden:
+				return nil, cmp.NewError(cmp.FailNotAuthorized, "error generating certificate: %v", err)
+			}
+		}
+		return nil, fmt.Errorf("error generating certificate: %w", err)
+	}
+	return certChain, nil
+}
+
+// pendingCertificate is an issued certificate waiting for the confirmation
+// of the client.
+type pendingCertificate struct {
+	certReqID int
+	cert      *x509.Certificate
+	sender    *sender
+}
+
+// confirmationDB returns the database used to store the certificates waiting
+// for confirmation.
+func confirmationDB(ctx context.Context) (db.CMPConfirmationDB, bool) {
+	authDB, ok := db.FromContext(ctx)
+	if !ok {
+		return nil, false
+	}
+	cdb, ok := authDB.(db.CMPConfirmationDB)
+	return cdb, ok
+}
+
+// useConfirmation removes and returns the certificate waiting for
+// confirmation in the transaction of the request.
+func useConfirmation(ctx context.Context, req *cmp.Message) (*pendingCertificate, error) {
+	cdb, ok := confirmationDB(ctx)
+	if !ok {
+		return nil, cmp.NewError(cmp.FailBadRequest, "there is no certificate waiting for confirmation")
+	}
+
+	c, err := cdb.UseCMPConfirmation(provisionerFromContext(ctx).GetID(), hex.EncodeToString(req.Header.TransactionID))
+	switch {
+	case database.IsErrNotFound(err):
+		return nil, cmp.NewError(cmp.FailBadRequest, "there is no certificate waiting for confirmation")
+	case err != nil:
+		return nil, fmt.Errorf("error retrieving certificate confirmation: %w", err)
+	}
+
+	cert, err := x509.ParseCertificate(c.Certificate)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing certificate waiting for confirmation: %w", err)
+	}
+	pc := &pendingCertificate{
+		certReqID: c.CertReqID,
+		cert:      cert,
+		sender:    &sender{mac: c.SenderMAC},
+	}
+	if len(c.SenderCertificate) > 0 {
+		if pc.sender.cert, err = x509.ParseCertificate(c.SenderCertificate); err != nil {
+			return nil, fmt.Errorf("error parsing certificate confirmation sender: %w", err)
+		}
+	}
+	return pc, nil
+}
+
+// fail logs the error and writes it to the client. It's only used when the
+// request cannot be answered with a CMP message.
+func fail(w http.ResponseWriter, r *http.Request, err error) {
+	log.Error(w, r, err)
+
+	status := http.StatusInternalServerError
+	var e *Error
+	if errors.As(err, &e) {
+		status = e.Status
+	}
+
+	http.Error(w, err.Error(), status)
+}
@@ -0,0 +1,608 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"errors"
+	"io"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/smallstep/nosql/database"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.step.sm/crypto/keyutil"
+	"go.step.sm/crypto/minica"
+	"go.step.sm/crypto/x509util"
+
+	"github.com/smallstep/certificates/authority"
+	"github.com/smallstep/certificates/authority/config"
+	"github.com/smallstep/certificates/authority/provisioner"
+	"github.com/smallstep/certificates/cmp"
+	"github.com/smallstep/certificates/db"
+)
+
+var (
+	oidPBMAC1          = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 5, 14}
+	oidPBKDF2          = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 5, 12}
+	oidHMACWithSHA256  = asn1.ObjectIdentifier{1, 2, 840, 113549, 2, 9}
+	oidECDSAWithSHA256 = asn1.ObjectIdentifier{1, 2, 840, 10045, 4, 3, 2}
+)
+
+type mockAuthority struct {
+	ca           *minica.CA
+	provisioners map[string]provisioner.Interface
+	revoked      map[string]bool
+	revokeErr    error
+}
+
+func (m *mockAuthority) LoadProvisionerByName(name string) (provisioner.Interface, error) {
+	p, ok := m.provisioners[name]
+	if !ok {
+		return nil, errors.New("provisioner not found")
+	}
+	return p, nil
+}
+
+func (m *mockAuthority) SignWithContext(_ context.Context, cr *x509.CertificateRequest, opts provisioner.SignOptions, signOpts ...provisioner.SignOption) ([]*x509.Certificate, error) {
+	var certOptions []x509util.Option
+	for _, so := range signOpts {
+		if co, ok := so.(provisioner.CertificateOptions); ok {
+			certOptions = append(certOptions, co.Options(opts)...)
+		}
+	}
+	c, err := x509util.NewCertificate(cr, certOptions...)
+	if err != nil {
+		return nil, err
+	}
+	crt, err := m.ca.Sign(c.GetCertificate())
+	if err != nil {
+		return nil, err
+	}
+	return []*x509.Certificate{crt, m.ca.Intermediate}, nil
+}
+
+func (m *mockAuthority) Revoke(_ context.Context, opts *authority.RevokeOptions) error {
+	if m.revokeErr != nil {
+		return m.revokeErr
+	}
+	m.revoked[opts.Serial] = true
+	return nil
+}
+
+func (m *mockAuthority) GetRoots() ([]*x509.Certificate, error) {
+	return []*x509.Certificate{m.ca.Root}, nil
+}
+
+func (m *mockAuthority) GetIntermediateCertificates() []*x509.Certificate {
+	return []*x509.Certificate{m.ca.Intermediate}
+}
+
+func (m *mockAuthority) GetX509Signer() (crypto.Signer, error) {
+	return m.ca.Signer, nil
+}
+
+func (m *mockAuthority) IsRevoked(sn string) (bool, error) {
+	return m.revoked[sn], nil
+}
+
+func newTestServer(t *testing.T) (*mockAuthority, http.Handler) {
+	t.Helper()
+	ca, err := minica.New()
+	require.NoError(t, err)
+
+	newProvisioner := func(p *provisioner.CMP) *provisioner.CMP {
+		require.NoError(t, p.Init(provisioner.Config{Claims: config.GlobalProvisionerClaims}))
+		return p
+	}
+	auth := &mockAuthority{
+		ca: ca,
+		provisioners: map[string]provisioner.Interface{
+			"cmp": newProvisioner(&provisioner.CMP{
+				Type:         "CMP",
+				Name:         "cmp",
+				SharedSecret: "secret",
+			}),
+			"jwk": &provisioner.JWK{Type: "JWK", Name: "jwk"},
+		},
+		revoked: map[string]bool{},
+	}
+
+	prev := mustAuthority
+	mustAuthority = func(context.Context) Authority {
+		return auth
+	}
+	t.Cleanup(func() {
+		mustAuthority = prev
+	})
+
+	// Certificates waiting for confirmation are kept in memory.
+	confirmations := make(map[string]*db.CMPConfirmation)
+	authDB := &db.MockAuthDB{
+		MCreateCMPConfirmation: func(c *db.CMPConfirmation) error {
+			confirmations[c.ProvisionerID+"/"+c.TransactionID] = c
+			return nil
+		},
+		MUseCMPConfirmation: func(provisionerID, transactionID string) (*db.CMPConfirmation, error) {
+			c, ok := confirmations[provisionerID+"/"+transactionID]
+			if !ok {
+				return nil, database.ErrNotFound
+			}
+			delete(confirmations, provisionerID+"/"+transactionID)
+			return c, nil
+		},
+	}
+
+	r := chi.NewRouter()
+	r.Use(func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			next.ServeHTTP(w, r.WithContext(db.NewContext(r.Context(), authDB)))
+		})
+	})
+	r.Route("/.well-known/cmp/p", func(r chi.Router) {
+		Route(r)
+	})
+	return auth, r
+}
+
+// newPBMAC1 returns the PBMAC1 algorithm used to protect the requests.
+func newPBMAC1(t *testing.T) pkix.AlgorithmIdentifier {
+	t.Helper()
+	kdf, err := asn1.Marshal(struct {
+		Salt           []byte
+		IterationCount int
+		KeyLength      int
+		PRF            pkix.AlgorithmIdentifier
+	}{
+		Salt:           []byte("0123456789abcdef"),
+		IterationCount: 1000,
+		KeyLength:      32,
+		PRF:            pkix.AlgorithmIdentifier{Algorithm: oidHMACWithSHA256, Parameters: asn1.NullRawValue},
+	})
+	require.NoError(t, err)
+	params, err := asn1.Marshal(struct {
+		KeyDerivationFunc pkix.AlgorithmIdentifier
+		MessageAuthScheme pkix.AlgorithmIdentifier
+	}{
+		KeyDerivationFunc: pkix.AlgorithmIdentifier{Algorithm: oidPBKDF2, Parameters: asn1.RawValue{FullBytes: kdf}},
+		MessageAuthScheme: pkix.AlgorithmIdentifier{Algorithm: oidHMACWithSHA256, Parameters: asn1.NullRawValue},
+	})
+	require.NoError(t, err)
+	return pkix.AlgorithmIdentifier{Algorithm: oidPBMAC1, Parameters: asn1.RawValue{FullBytes: params}}
+}
+
+func newMessage(typ cmp.BodyType, body []byte, transactionID string) *cmp.Message {
+	nullDN := asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 4, IsCompound: true, Bytes: []byte{0x30, 0x00}}
+	return &cmp.Message{
+		Header: cmp.Header{
+			PVNO:          cmp.Version2000,
+			Sender:        nullDN,
+			Recipient:     nullDN,
+			MessageTime:   time.Now().UTC().Truncate(time.Second),
+			TransactionID: []byte(transactionID),
+			SenderNonce:   []byte("sender-nonce-000"),
+		},
+		Type: typ,
+		Body: body,
+	}
+}
+
+func newMACMessage(t *testing.T, typ cmp.BodyType, body []byte, transactionID string) *cmp.Message {
+	t.Helper()
+	msg := newMessage(typ, body, transactionID)
+	require.NoError(t, msg.ProtectMAC([]byte("secret"), newPBMAC1(t)))
+	return msg
+}
+
+func newSignedMessage(t *testing.T, typ cmp.BodyType, body []byte, signer crypto.Signer, chain ...*x509.Certificate) *cmp.Message {
+	t.Helper()
+	msg := newMessage(typ, body, "signed-transaction")
+	msg.ExtraCerts = chain
+	require.NoError(t, msg.ProtectSignature(signer, chain[0]))
+	return msg
+}
+
+// newCertReqMessages returns the body of an ir, cr or kur message with a
+// signature proof-of-possession. The subject is not set if it's empty.
+func newCertReqMessages(t *testing.T, signer crypto.Signer, subject string) []byte {
+	t.Helper()
+	spki, err := x509.MarshalPKIXPublicKey(signer.Public())
+	require.NoError(t, err)
+	var spkiRaw asn1.RawValue
+	_, err = asn1.Unmarshal(spki, &spkiRaw)
+	require.NoError(t, err)
+
+	certReq := cmp.CertRequest{
+		CertTemplate: cmp.CertTemplate{
+			PublicKey: asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 6, IsCompound: true, Bytes: spkiRaw.Bytes},
+		},
+	}
+	if subject != "" {
+		rawSubject, err := asn1.Marshal(pkix.Name{CommonName: subject}.ToRDNSequence())
+		require.NoError(t, err)
+		certReq.CertTemplate.Subject = asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 5, IsCompound: true, Bytes: rawSubject}
+	}
+	certReqDER, err := asn1.Marshal(certReq)
+	require.NoError(t, err)
+
+	sum := sha256.Sum256(certReqDER)
+	sig, err := signer.Sign(rand.Reader, sum[:], crypto.SHA256)
+	require.NoError(t, err)
+	popo, err := asn1.Marshal(struct {
+		Algorithm pkix.AlgorithmIdentifier
+		Signature asn1.BitString
+	}{
+		Algorithm: pkix.AlgorithmIdentifier{Algorithm: oidECDSAWithSHA256},
+		Signature: asn1.BitString{Bytes: sig, BitLength: 8 * len(sig)},
+	})
+	require.NoError(t, err)
+	var popoRaw asn1.RawValue
+	_, err = asn1.Unmarshal(popo, &popoRaw)
+	require.NoError(t, err)
+
+	body, err := asn1.Marshal([]cmp.CertReqMsg{{
+		CertReq: cmp.CertRequest{Raw: certReqDER},
+		POPO:    asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 1, IsCompound: true, Bytes: popoRaw.Bytes},
+	}})
+	require.NoError(t, err)
+	return body
+}
+
+type certRepMessage struct {
+	CAPubs   []asn1.RawValue `asn1:"explicit,optional,tag:1"`
+	Response []struct {
+		CertReqID        int
+		Status           cmp.StatusInfo
+		CertifiedKeyPair struct {
+			CertOrEncCert asn1.RawValue
+		} `asn1:"optional"`
+	}
+}
+
+// parseCertRep returns the certificate and the caPubs in an ip, cp or kup
+// message.
+func parseCertRep(t *testing.T, body []byte) (int, *x509.Certificate, []*x509.Certificate) {
+	t.Helper()
+	var rep certRepMessage
+	_, err := asn1.Unmarshal(body, &rep)
+	require.NoError(t, err)
+	require.Len(t, rep.Response, 1)
+	require.Equal(t, cmp.StatusAccepted, rep.Response[0].Status.Status)
+	cert, err := x509.ParseCertificate(rep.Response[0].CertifiedKeyPair.CertOrEncCert.Bytes)
+	require.NoError(t, err)
+	var caPubs []*x509.Certificate
+	for _, v := range rep.CAPubs {
+		c, err := x509.ParseCertificate(v.FullBytes)
+		require.NoError(t, err)
+		caPubs = append(caPubs, c)
+	}
+	return rep.Response[0].CertReqID, cert, caPubs
+}
+
+// parseError returns the status of an error message.
+func parseError(t *testing.T, res *cmp.Message) cmp.StatusInfo {
+	t.Helper()
+	require.Equal(t, cmp.BodyError, res.Type)
+	var content struct {
+		Status cmp.StatusInfo
+	}
+	_, err := asn1.Unmarshal(res.Body, &content)
+	require.NoError(t, err)
+	return content.Status
+}
+
+func hasFailInfo(si cmp.StatusInfo, f cmp.FailInfo) bool {
+	return si.Status == cmp.StatusRejection && si.FailInfo.At(int(f)) == 1
+}
+
+func do(t *testing.T, h http.Handler, provisionerName string, msg *cmp.Message) *cmp.Message {
+	t.Helper()
+	der, err := msg.Marshal()
+	require.NoError(t, err)
+	r := httptest.NewRequest("POST", "/.well-known/cmp/p/"+provisionerName, bytes.NewReader(der))
+	r.Header.Set("Content-Type", "application/pkixcmp")
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	res := w.Result()
+	body, _ := io.ReadAll(res.Body)
+	res.Body.Close()
+	require.Equal(t, http.StatusOK, res.StatusCode, string(body))
+	assert.Equal(t, "application/pkixcmp", res.Header.Get("Content-Type"))
+
+	resp, err := cmp.ParseMessage(body)
+	require.NoError(t, err)
+	assert.Equal(t, msg.Header.TransactionID, resp.Header.TransactionID)
+	assert.Equal(t, msg.Header.SenderNonce, resp.Header.RecipNonce)
+	return resp
+}
+
+func TestPost_MAC(t *testing.T) {
+	auth, h := newTestServer(t)
+	key, err := keyutil.GenerateDefaultSigner()
+	require.NoError(t, err)
+
+	// Initialization request with explicit confirmation.
+	req := newMACMessage(t, cmp.BodyIR, newCertReqMessages(t, key, "device"), "transaction-ir")
+	res := do(t, h, "cmp", req)
+	require.Equal(t, cmp.BodyIP, res.Type)
+	require.NoError(t, res.VerifyMAC([]byte("secret")))
+	assert.False(t, res.Header.HasGeneralInfo(cmp.OIDImplicitConfirm))
+	certReqID, cert, caPubs := parseCertRep(t, res.Body)
+	assert.Equal(t, 0, certReqID)
+	assert.Equal(t, "device", cert.Subject.CommonName)
+	assert.True(t, keyutil.Equal(key.Public(), cert.PublicKey))
+	assert.Equal(t, []*x509.Certificate{auth.ca.Root}, caPubs)
+	assert.Equal(t, []*x509.Certificate{auth.ca.Intermediate}, res.ExtraCerts)
+
+	// Confirmation with a wrong hash.
+	newCertConf := func(hash []byte) []byte {
+		body, err := asn1.Marshal([]cmp.CertStatus{{CertHash: hash, CertReqID: 0}})
+		require.NoError(t, err)
+		return body
+	}
+	hash, err := cmp.CertificateHash(cert, pkix.AlgorithmIdentifier{})
+	require.NoError(t, err)
+	res = do(t, h, "cmp", newMACMessage(t, cmp.BodyCertConf, newCertConf([]byte("bad hash")), "transaction-ir"))
+	assert.True(t, hasFailInfo(parseError(t, res), cmp.FailBadCertID))
+
+	// The failed confirmation consumed the pending certificate.
+	res = do(t, h, "cmp", newMACMessage(t, cmp.BodyCertConf, newCertConf(hash), "transaction-ir"))
+	assert.True(t, hasFailInfo(parseError(t, res), cmp.FailBadRequest))
+
+	// Certification request with explicit confirmation.
+	res = do(t, h, "cmp", newMACMessage(t, cmp.BodyCR, newCertReqMessages(t, key, "device"), "transaction-cr"))
+	require.Equal(t, cmp.BodyCP, res.Type)
+	_, cert, _ = parseCertRep(t, res.Body)
+	hash, err = cmp.CertificateHash(cert, pkix.AlgorithmIdentifier{})
+	require.NoError(t, err)
+	res = do(t, h, "cmp", newMACMessage(t, cmp.BodyCertConf, newCertConf(hash), "transaction-cr"))
+	require.Equal(t, cmp.BodyPKIConf, res.Type)
+	require.NoError(t, res.VerifyMAC([]byte("secret")))
+	assert.False(t, auth.revoked[cert.SerialNumber.String()])
+
+	// Rejected certificates are revoked.
+	res = do(t, h, "cmp", newMACMessage(t, cmp.BodyCR, newCertReqMessages(t, key, "device"), "transaction-rejected"))
+	require.Equal(t, cmp.BodyCP, res.Type)
+	_, cert, _ = parseCertRep(t, res.Body)
+	res = do(t, h, "cmp", newMACMessage(t, cmp.BodyCertConf, []byte{0x30, 0x00}, "transaction-rejected"))
+	require.Equal(t, cmp.BodyPKIConf, res.Type)
+	assert.True(t, auth.revoked[cert.SerialNumber.String()])
+
+	// PKCS#10 request with implicit confirmation.
+	csr, err := x509util.CreateCertificateRequest("device", []string{"device.example.com"}, key)
+	require.NoError(t, err)
+	req = newMessage(cmp.BodyP10CR, csr.Raw, "transaction-p10cr")
+	req.Header.GeneralInfo = []cmp.InfoTypeAndValue{{InfoType: cmp.OIDImplicitConfirm}}
+	require.NoError(t, req.ProtectMAC([]byte("secret"), newPBMAC1(t)))
+	res = do(t, h, "cmp", req)
+	require.Equal(t, cmp.BodyCP, res.Type)
+	assert.True(t, res.Header.HasGeneralInfo(cmp.OIDImplicitConfirm))
+	certReqID, cert, _ = parseCertRep(t, res.Body)
+	assert.Equal(t, -1, certReqID)
+	assert.Equal(t, []string{"device.example.com"}, cert.DNSNames)
+
+	// Wrong shared secret.
+	req = newMessage(cmp.BodyIR, newCertReqMessages(t, key, "device"), "transaction-bad-mac")
+	require.NoError(t, req.ProtectMAC([]byte("bad secret"), newPBMAC1(t)))
+	res = do(t, h, "cmp", req)
+	assert.True(t, hasFailInfo(parseError(t, res), cmp.FailBadMessageCheck))
+	// Responses to unauthenticated requests are signed.
+	_, err = res.VerifySignature()
+	require.NoError(t, err)
+
+	// Proof-of-possession signed with another key.
+	other, err := keyutil.GenerateDefaultSigner()
+	require.NoError(t, err)
+	msgs, err := cmp.ParseCertReqMessages(newCertReqMessages(t, other, "device"))
+	require.NoError(t, err)
+	body, err := asn1.Marshal([]cmp.CertReqMsg{{
+		CertReq: cmp.CertRequest{Raw: newCertRequest(t, key)},
+		POPO:    msgs[0].POPO,
+	}})
+	require.NoError(t, err)
+	res = do(t, h, "cmp", newMACMessage(t, cmp.BodyIR, body, "transaction-bad-pop"))
+	assert.True(t, hasFailInfo(parseError(t, res), cmp.FailBadPOP))
+
+	// Without a database, certificates cannot wait for confirmation.
+	r := chi.NewRouter()
+	r.Route("/.well-known/cmp/p", func(r chi.Router) {
+		Route(r)
+	})
+	res = do(t, r, "cmp", newMACMessage(t, cmp.BodyIR, newCertReqMessages(t, key, "device"), "transaction-no-db"))
+	assert.True(t, hasFailInfo(parseError(t, res), cmp.FailBadRequest))
+}
+
+// newCertRequest returns the CertRequest of a message for the given key.
+func newCertRequest(t *testing.T, key crypto.Signer) []byte {
+	t.Helper()
+	msgs, err := cmp.ParseCertReqMessages(newCertReqMessages(t, key, "device"))
+	require.NoError(t, err)
+	return msgs[0].CertReq.Raw
+}
+
+// newProvisionerExtension returns the provisioner extension of a certificate
+// issued by the given CMP provisioner.
+func newProvisionerExtension(t *testing.T, name string) pkix.Extension {
+	t.Helper()
+	ext, err := (&provisioner.Extension{Type: provisioner.TypeCMP, Name: name}).ToExtension()
+	require.NoError(t, err)
+	return ext
+}
+
+func TestPost_Signature(t *testing.T) {
+	auth, h := newTestServer(t)
+	key, err := keyutil.GenerateDefaultSigner()
+	require.NoError(t, err)
+	cert, err := auth.ca.Sign(&x509.Certificate{
+		Subject:         pkix.Name{CommonName: "device"},
+		DNSNames:        []string{"device.example.com"},
+		PublicKey:       key.Public(),
+		ExtraExtensions: []pkix.Extension{newProvisionerExtension(t, "cmp")},
+	})
+	require.NoError(t, err)
+
+	// Signed ir without trusted roots in the provisioner.
+	res := do(t, h, "cmp", newSignedMessage(t, cmp.BodyIR, newCertReqMessages(t, key, "device"), key, cert, auth.ca.Intermediate))
+	assert.True(t, hasFailInfo(parseError(t, res), cmp.FailSignerNotTrusted))
+
+	// Key update request, the subject and the SANs of the old certificate are
+	// used.
+	newKey, err := keyutil.GenerateDefaultSigner()
+	require.NoError(t, err)
+	req := newSignedMessage(t, cmp.BodyKUR, newCertReqMessages(t, newKey, ""), key, cert, auth.ca.Intermediate)
+	req.Header.GeneralInfo = []cmp.InfoTypeAndValue{{InfoType: cmp.OIDImplicitConfirm}}
+	require.NoError(t, req.ProtectSignature(key, cert))
+	res = do(t, h, "cmp", req)
+	require.Equal(t, cmp.BodyKUP, res.Type)
+	signer, err := res.VerifySignature()
+	require.NoError(t, err)
+	assert.Equal(t, auth.ca.Intermediate, signer)
+	_, newCert, caPubs := parseCertRep(t, res.Body)
+	assert.Empty(t, caPubs)
+	assert.Equal(t, "device", newCert.Subject.CommonName)
+	assert.Equal(t, []string{"device.example.com"}, newCert.DNSNames)
+	assert.True(t, keyutil.Equal(newKey.Public(), newCert.PublicKey))
+
+	// Key update request with a different subject.
+	res = do(t, h, "cmp", newSignedMessage(t, cmp.BodyKUR, newCertReqMessages(t, newKey, "other"), key, cert, auth.ca.Intermediate))
+	assert.True(t, hasFailInfo(parseError(t, res), cmp.FailBadCertTemplate))
+
+	// Key update request signed with a certificate issued by another
+	// provisioner, or without the provisioner extension.
+	for _, extensions := range [][]pkix.Extension{{newProvisionerExtension(t, "other")}, nil} {
+		otherCert, err := auth.ca.Sign(&x509.Certificate{
+			Subject:         pkix.Name{CommonName: "device"},
+			DNSNames:        []string{"device.example.com"},
+			PublicKey:       key.Public(),
+			ExtraExtensions: extensions,
+		})
+		require.NoError(t, err)
+		res = do(t, h, "cmp", newSignedMessage(t, cmp.BodyKUR, newCertReqMessages(t, newKey, ""), key, otherCert, auth.ca.Intermediate))
+		assert.True(t, hasFailInfo(parseError(t, res), cmp.FailSignerNotTrusted))
+	}
+
+	// Revocation request.
+	newRevReq := func(c *x509.Certificate) []byte {
+		reason, err := asn1.Marshal(asn1.Enumerated(1))
+		require.NoError(t, err)
+		body, err := asn1.Marshal([]cmp.RevDetails{{
+			CertDetails: cmp.CertTemplate{
+				SerialNumber: c.SerialNumber,
+				Issuer:       asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 3, IsCompound: true, Bytes: c.RawIssuer},
+			},
+			CRLEntryDetails: []pkix.Extension{{Id: asn1.ObjectIdentifier{2, 5, 29, 21}, Value: reason}},
+		}})
+		require.NoError(t, err)
+		return body
+	}
+	res = do(t, h, "cmp", newSignedMessage(t, cmp.BodyRR, newRevReq(newCert), key, cert, auth.ca.Intermediate))
+	assert.True(t, hasFailInfo(parseError(t, res), cmp.FailNotAuthorized))
+	assert.False(t, auth.revoked[newCert.SerialNumber.String()])
+
+	res = do(t, h, "cmp", newSignedMessage(t, cmp.BodyRR, newRevReq(cert), key, cert, auth.ca.Intermediate))
+	require.Equal(t, cmp.BodyRP, res.Type)
+	assert.True(t, auth.revoked[cert.SerialNumber.String()])
+
+	// Revoked certificates are not trusted.
+	res = do(t, h, "cmp", newSignedMessage(t, cmp.BodyRR, newRevReq(cert), key, cert, auth.ca.Intermediate))
+	assert.True(t, hasFailInfo(parseError(t, res), cmp.FailSignerNotTrusted))
+
+	// Certificates from another CA are not trusted.
+	other, err := minica.New()
+	require.NoError(t, err)
+	otherCert, err := other.Sign(&x509.Certificate{
+		Subject:      pkix.Name{CommonName: "device"},
+		PublicKey:    key.Public(),
+		SerialNumber: big.NewInt(1),
+	})
+	require.NoError(t, err)
+	res = do(t, h, "cmp", newSignedMessage(t, cmp.BodyRR, newRevReq(otherCert), key, otherCert, other.Intermediate))
+	assert.True(t, hasFailInfo(parseError(t, res), cmp.FailSignerNotTrusted))
+}
+
+func TestPost_GenM(t *testing.T) {
+	auth, h := newTestServer(t)
+
+	body, err := asn1.Marshal([]cmp.InfoTypeAndValue{
+		{InfoType: cmp.OIDCACerts},
+		{InfoType: asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 7, 4, 20}},
+	})
+	require.NoError(t, err)
+	res := do(t, h, "cmp", newMACMessage(t, cmp.BodyGenM, body, "transaction-genm"))
+	require.Equal(t, cmp.BodyGenP, res.Type)
+	require.NoError(t, res.VerifyMAC([]byte("secret")))
+
+	infos, err := cmp.ParseGenMsgContent(res.Body)
+	require.NoError(t, err)
+	require.Len(t, infos, 1)
+	assert.Equal(t, cmp.OIDCACerts, infos[0].InfoType)
+	var values []asn1.RawValue
+	_, err = asn1.Unmarshal(infos[0].InfoValue.FullBytes, &values)
+	require.NoError(t, err)
+	require.Len(t, values, 2)
+	assert.Equal(t, auth.ca.Intermediate.Raw, values[0].FullBytes)
+	assert.Equal(t, auth.ca.Root.Raw, values[1].FullBytes)
+}
+
+func TestPost_errors(t *testing.T) {
+	auth, h := newTestServer(t)
+
+	// Unsupported message type.
+	res := do(t, h, "cmp", newMACMessage(t, cmp.BodyIP, []byte{0x30, 0x00}, "transaction-ip"))
+	assert.True(t, hasFailInfo(parseError(t, res), cmp.FailBadRequest))
+
+	// Missing sender nonce.
+	req := newMessage(cmp.BodyGenM, []byte{0x30, 0x00}, "transaction-nonce")
+	req.Header.SenderNonce = nil
+	require.NoError(t, req.ProtectMAC([]byte("secret"), newPBMAC1(t)))
+	res = do(t, h, "cmp", req)
+	assert.True(t, hasFailInfo(parseError(t, res), cmp.FailBadSenderNonce))
+
+	// Unexpected errors are not sent to the client.
+	key, err := keyutil.GenerateDefaultSigner()
+	require.NoError(t, err)
+	auth.revokeErr = errors.New("database error")
+	res = do(t, h, "cmp", newMACMessage(t, cmp.BodyCR, newCertReqMessages(t, key, "device"), "transaction-revoke-error"))
+	require.Equal(t, cmp.BodyCP, res.Type)
+	res = do(t, h, "cmp", newMACMessage(t, cmp.BodyCertConf, []byte{0x30, 0x00}, "transaction-revoke-error"))
+	si := parseError(t, res)
+	assert.True(t, hasFailInfo(si, cmp.FailSystemFailure))
+	require.Len(t, si.StatusString, 1)
+	assert.NotContains(t, string(si.StatusString[0].Bytes), "database error")
+
+	// HTTP errors.
+	der, err := newMessage(cmp.BodyGenM, []byte{0x30, 0x00}, "transaction").Marshal()
+	require.NoError(t, err)
+	tests := []struct {
+		name        string
+		path        string
+		contentType string
+		body        []byte
+		wantStatus  int
+	}{
+		{"ok operation label", "/.well-known/cmp/p/cmp/label", "application/pkixcmp", []byte("bad message"), http.StatusBadRequest},
+		{"fail content type", "/.well-known/cmp/p/cmp", "application/octet-stream", der, http.StatusUnsupportedMediaType},
+		{"fail message", "/.well-known/cmp/p/cmp", "application/pkixcmp", []byte("bad message"), http.StatusBadRequest},
+		{"fail provisioner", "/.well-known/cmp/p/missing", "application/pkixcmp", der, http.StatusNotFound},
+		{"fail provisioner type", "/.well-known/cmp/p/jwk", "application/pkixcmp", der, http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("POST", tt.path, bytes.NewReader(tt.body))
+			r.Header.Set("Content-Type", tt.contentType)
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, r)
+			assert.Equal(t, tt.wantStatus, w.Result().StatusCode)
+		})
+	}
+}
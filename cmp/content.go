@@ -0,0 +1,109 @@
+package cmp
+
+import (
+	"crypto/x509"
+	"encoding/asn1"
+	"fmt"
+)
+
+type certRepMessage struct {
+	CAPubs   []asn1.RawValue `asn1:"explicit,optional,tag:1"`
+	Response []certResponse
+}
+
+type certResponse struct {
+	CertReqID        int
+	Status           StatusInfo
+	CertifiedKeyPair certifiedKeyPair `asn1:"optional"`
+}
+
+type certifiedKeyPair struct {
+	CertOrEncCert asn1.RawValue
+}
+
+type errorMsgContent struct {
+	Status StatusInfo
+}
+
+type revRepContent struct {
+	Status []StatusInfo
+}
+
+// NewCertRepContent returns the body of an ip, cp or kup message with the
+// issued certificate. The caPubs are optional and are only sent to clients
+// that might not have the roots of the CA.
+func NewCertRepContent(certReqID int, cert *x509.Certificate, caPubs []*x509.Certificate) ([]byte, error) {
+	msg := certRepMessage{
+		Response: []certResponse{{
+			CertReqID: certReqID,
+			Status:    StatusInfo{Status: StatusAccepted},
+			CertifiedKeyPair: certifiedKeyPair{
+				CertOrEncCert: asn1.RawValue{
+					Class:      asn1.ClassContextSpecific,
+					Tag:        0,
+					IsCompound: true,
+					Bytes:      cert.Raw,
+				},
+			},
+		}},
+	}
+	for _, c := range caPubs {
+		msg.CAPubs = append(msg.CAPubs, asn1.RawValue{FullBytes: c.Raw})
+	}
+
+	data, err := asn1.Marshal(msg)
+	if err != nil {
+		return nil, fmt.Errorf("error marshaling certificate response: %w", err)
+	}
+	return data, nil
+}
+
+// NewErrorMsgContent returns the body of an error message.
+func NewErrorMsgContent(status StatusInfo) ([]byte, error) {
+	data, err := asn1.Marshal(errorMsgContent{Status: status})
+	if err != nil {
+		return nil, fmt.Errorf("error marshaling error message: %w", err)
+	}
+	return data, nil
+}
+
+// NewRevRepContent returns the body of an rp message.
+func NewRevRepContent(status StatusInfo) ([]byte, error) {
+	data, err := asn1.Marshal(revRepContent{Status: []StatusInfo{status}})
+	if err != nil {
+		return nil, fmt.Errorf("error marshaling revocation response: %w", err)
+	}
+	return data, nil
+}
+
+// NewGenRepContent returns the body of a genp message.
+func NewGenRepContent(infos []InfoTypeAndValue) ([]byte, error) {
+	if infos == nil {
+		infos = []InfoTypeAndValue{}
+	}
+	data, err := asn1.Marshal(infos)
+	if err != nil {
+		return nil, fmt.Errorf("error marshaling general response: %w", err)
+	}
+	return data, nil
+}
+
+// NewCACertsInfo returns the general response with the given CA
+// certificates, RFC 9483 section 4.3.1.
+func NewCACertsInfo(certs []*x509.Certificate) (InfoTypeAndValue, error) {
+	values := make([]asn1.RawValue, len(certs))
+	for i, c := range certs {
+		values[i] = asn1.RawValue{FullBytes: c.Raw}
+	}
+	data, err := asn1.Marshal(values)
+	if err != nil {
+		return InfoTypeAndValue{}, fmt.Errorf("error marshaling CA certificates: %w", err)
+	}
+	return InfoTypeAndValue{
+		InfoType:  OIDCACerts,
+		InfoValue: asn1.RawValue{FullBytes: data},
+	}, nil
+}
+
+// PKIConfContent is the body of a pkiconf message.
+var PKIConfContent = asn1.NullBytes
@@ -0,0 +1,304 @@
+package cmp
+
+import (
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"math/big"
+	"time"
+)
+
+var (
+	oidExtensionRequest        = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 14}
+	oidExtensionSubjectAltName = asn1.ObjectIdentifier{2, 5, 29, 17}
+	oidExtensionReasonCode     = asn1.ObjectIdentifier{2, 5, 29, 21}
+)
+
+// CertReqMsg is a certificate request message, RFC 4211 section 3.
+type CertReqMsg struct {
+	CertReq CertRequest
+	// POPO is the proof-of-possession, only signatures are supported.
+	POPO    asn1.RawValue `asn1:"optional"`
+	RegInfo asn1.RawValue `asn1:"optional"`
+}
+
+// CertRequest is the certificate request of a CertReqMsg, RFC 4211 section 5.
+type CertRequest struct {
+	Raw          asn1.RawContent
+	CertReqID    int
+	CertTemplate CertTemplate
+	Controls     asn1.RawValue `asn1:"optional"`
+}
+
+// CertTemplate contains the fields of the requested certificate, RFC 4211
+// section 5. It's also used in revocation requests to identify the
+// certificate to revoke. The content of the explicitly tagged raw values,
+// like the subject, is in the Bytes field.
+type CertTemplate struct {
+	Version      int            `asn1:"optional,tag:0"`
+	SerialNumber *big.Int       `asn1:"optional,tag:1"`
+	SigningAlg   asn1.RawValue  `asn1:"optional,tag:2"`
+	Issuer       asn1.RawValue  `asn1:"optional,explicit,tag:3"`
+	Validity     asn1.RawValue  `asn1:"optional,tag:4"`
+	Subject      asn1.RawValue  `asn1:"optional,explicit,tag:5"`
+	PublicKey    asn1.RawValue  `asn1:"optional,tag:6"`
+	IssuerUID    asn1.BitString `asn1:"optional,tag:7"`
+	SubjectUID   asn1.BitString `asn1:"optional,tag:8"`
+	Extensions   asn1.RawValue  `asn1:"optional,tag:9"`
+}
+
+type optionalValidity struct {
+	NotBefore asn1.RawValue `asn1:"optional,explicit,tag:0"`
+	NotAfter  asn1.RawValue `asn1:"optional,explicit,tag:1"`
+}
+
+type popoSigningKey struct {
+	Input     asn1.RawValue `asn1:"optional,tag:0"`
+	Algorithm pkix.AlgorithmIdentifier
+	Signature asn1.BitString
+}
+
+type popoSigningKeyInput struct {
+	AuthInfo  asn1.RawValue
+	PublicKey asn1.RawValue
+}
+
+type certificationRequestInfo struct {
+	Version    int
+	Subject    asn1.RawValue
+	PublicKey  asn1.RawValue
+	Attributes []asn1.RawValue `asn1:"tag:0"`
+}
+
+type certificationRequest struct {
+	Info      asn1.RawValue
+	Algorithm pkix.AlgorithmIdentifier
+	Signature asn1.BitString
+}
+
+type attribute struct {
+	Type   asn1.ObjectIdentifier
+	Values []asn1.RawValue `asn1:"set"`
+}
+
+// ParseCertReqMessages parses the body of an ir, cr or kur message.
+func ParseCertReqMessages(body []byte) ([]CertReqMsg, error) {
+	var msgs []CertReqMsg
+	if rest, err := asn1.Unmarshal(body, &msgs); err != nil || len(rest) > 0 {
+		return nil, NewError(FailBadDataFormat, "invalid certificate request messages")
+	}
+	if len(msgs) == 0 {
+		return nil, NewError(FailBadRequest, "certificate request messages are empty")
+	}
+	return msgs, nil
+}
+
+// CertificateRequest returns the certificate request as an
+// x509.CertificateRequest. The signature of the returned request is the
+// proof-of-possession of the message, so CheckSignature verifies it.
+//
+// In key update requests, old is the certificate being updated, and it's used
+// as the default for the subject and the subject alternative names.
+func (m *CertReqMsg) CertificateRequest(old *x509.Certificate) (*x509.CertificateRequest, error) {
+	if m.POPO.Class != asn1.ClassContextSpecific || m.POPO.Tag != 1 {
+		return nil, NewError(FailBadPOP, "only signature proof-of-possession is supported")
+	}
+	var popo popoSigningKey
+	if rest, err := asn1.Unmarshal(retag(m.POPO, asn1.TagSequence), &popo); err != nil || len(rest) > 0 {
+		return nil, NewError(FailBadPOP, "invalid proof-of-possession")
+	}
+
+	tmpl := &m.CertReq.CertTemplate
+	signed := []byte(m.CertReq.Raw)
+	var spki []byte
+	if len(tmpl.PublicKey.Bytes) > 0 {
+		spki = retag(tmpl.PublicKey, asn1.TagSequence)
+	}
+	if len(popo.Input.Bytes) > 0 {
+		signed = retag(popo.Input, asn1.TagSequence)
+		var input popoSigningKeyInput
+		if rest, err := asn1.Unmarshal(signed, &input); err != nil || len(rest) > 0 {
+			return nil, NewError(FailBadPOP, "invalid proof-of-possession input")
+		}
+		spki = input.PublicKey.FullBytes
+	}
+	if len(spki) == 0 {
+		return nil, NewError(FailBadCertTemplate, "certificate template public key is missing")
+	}
+
+	subject := tmpl.Subject.Bytes
+	if len(subject) == 0 && old != nil {
+		subject = old.RawSubject
+	}
+	if len(subject) == 0 {
+		subject = []byte{0x30, 0x00}
+	}
+
+	var extensions []pkix.Extension
+	if len(tmpl.Extensions.Bytes) > 0 {
+		if rest, err := asn1.Unmarshal(retag(tmpl.Extensions, asn1.TagSequence), &extensions); err != nil || len(rest) > 0 {
+			return nil, NewError(FailBadCertTemplate, "invalid certificate template extensions")
+		}
+	} else if old != nil {
+		for _, ext := range old.Extensions {
+			if ext.Id.Equal(oidExtensionSubjectAltName) {
+				extensions = append(extensions, ext)
+			}
+		}
+	}
+
+	info := certificationRequestInfo{
+		Subject:    asn1.RawValue{FullBytes: subject},
+		PublicKey:  asn1.RawValue{FullBytes: spki},
+		Attributes: []asn1.RawValue{},
+	}
+	if len(extensions) > 0 {
+		value, err := asn1.Marshal(extensions)
+		if err != nil {
+			return nil, err
+		}
+		attr, err := asn1.Marshal(attribute{
+			Type:   oidExtensionRequest,
+			Values: []asn1.RawValue{{FullBytes: value}},
+		})
+		if err != nil {
+			return nil, err
+		}
+		info.Attributes = append(info.Attributes, asn1.RawValue{FullBytes: attr})
+	}
+	infoDER, err := asn1.Marshal(info)
+	if err != nil {
+		return nil, err
+	}
+	der, err := asn1.Marshal(certificationRequest{
+		Info:      asn1.RawValue{FullBytes: infoDER},
+		Algorithm: popo.Algorithm,
+		Signature: popo.Signature,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	csr, err := x509.ParseCertificateRequest(der)
+	if err != nil {
+		return nil, NewError(FailBadCertTemplate, "invalid certificate template: %v", err)
+	}
+	csr.RawTBSCertificateRequest = signed
+	return csr, nil
+}
+
+// Validity returns the validity requested in the certificate template. The
+// returned times are zero if they are not present.
+func (m *CertReqMsg) Validity() (notBefore, notAfter time.Time, err error) {
+	v := m.CertReq.CertTemplate.Validity
+	if len(v.Bytes) == 0 {
+		return
+	}
+
+	var validity optionalValidity
+	if rest, err := asn1.Unmarshal(retag(v, asn1.TagSequence), &validity); err != nil || len(rest) > 0 {
+		return notBefore, notAfter, NewError(FailBadCertTemplate, "invalid certificate template validity")
+	}
+	if len(validity.NotBefore.Bytes) > 0 {
+		if _, err := asn1.Unmarshal(validity.NotBefore.Bytes, &notBefore); err != nil {
+			return notBefore, notAfter, NewError(FailBadCertTemplate, "invalid certificate template validity")
+		}
+	}
+	if len(validity.NotAfter.Bytes) > 0 {
+		if _, err := asn1.Unmarshal(validity.NotAfter.Bytes, &notAfter); err != nil {
+			return notBefore, notAfter, NewError(FailBadCertTemplate, "invalid certificate template validity")
+		}
+	}
+	return
+}
+
+// RevDetails are the details of a revocation request, RFC 4210 section
+// 5.3.9.
+type RevDetails struct {
+	CertDetails     CertTemplate
+	CRLEntryDetails []pkix.Extension `asn1:"optional"`
+}
+
+// ParseRevReqContent parses the body of an rr message. RFC 9483 only allows
+// one certificate per revocation request.
+func ParseRevReqContent(body []byte) (*RevDetails, error) {
+	var details []RevDetails
+	if rest, err := asn1.Unmarshal(body, &details); err != nil || len(rest) > 0 {
+		return nil, NewError(FailBadDataFormat, "invalid revocation request")
+	}
+	if len(details) != 1 {
+		return nil, NewError(FailBadRequest, "revocation request must contain one certificate")
+	}
+	return &details[0], nil
+}
+
+// ReasonCode returns the CRL reason code in the revocation request. It
+// returns 0, unspecified, if it's not present.
+func (d *RevDetails) ReasonCode() (int, error) {
+	for _, ext := range d.CRLEntryDetails {
+		if ext.Id.Equal(oidExtensionReasonCode) {
+			var code asn1.Enumerated
+			if rest, err := asn1.Unmarshal(ext.Value, &code); err != nil || len(rest) > 0 {
+				return 0, NewError(FailBadDataFormat, "invalid revocation reason code")
+			}
+			return int(code), nil
+		}
+	}
+	return 0, nil
+}
+
+// Matches returns true if the issuer and serial number in the revocation
+// request are the ones of the given certificate.
+func (d *RevDetails) Matches(cert *x509.Certificate) bool {
+	t := d.CertDetails
+	return t.SerialNumber != nil && t.SerialNumber.Cmp(cert.SerialNumber) == 0 &&
+		len(t.Issuer.Bytes) > 0 && string(t.Issuer.Bytes) == string(cert.RawIssuer)
+}
+
+// CertStatus is the status of a certificate in a certConf message, RFC 4210
+// section 5.3.18.
+type CertStatus struct {
+	CertHash   []byte
+	CertReqID  int
+	StatusInfo StatusInfo               `asn1:"optional"`
+	HashAlg    pkix.AlgorithmIdentifier `asn1:"explicit,optional,tag:0"`
+}
+
+// ParseCertConfirmContent parses the body of a certConf message.
+func ParseCertConfirmContent(body []byte) ([]CertStatus, error) {
+	var statuses []CertStatus
+	if rest, err := asn1.Unmarshal(body, &statuses); err != nil || len(rest) > 0 {
+		return nil, NewError(FailBadDataFormat, "invalid certificate confirmation")
+	}
+	return statuses, nil
+}
+
+// ParseGenMsgContent parses the body of a genm message.
+func ParseGenMsgContent(body []byte) ([]InfoTypeAndValue, error) {
+	var infos []InfoTypeAndValue
+	if rest, err := asn1.Unmarshal(body, &infos); err != nil || len(rest) > 0 {
+		return nil, NewError(FailBadDataFormat, "invalid general message")
+	}
+	return infos, nil
+}
+
+// ParseP10CertReqContent parses the body of a p10cr message.
+func ParseP10CertReqContent(body []byte) (*x509.CertificateRequest, error) {
+	csr, err := x509.ParseCertificateRequest(body)
+	if err != nil {
+		return nil, NewError(FailBadDataFormat, "invalid certificate request: %v", err)
+	}
+	return csr, nil
+}
+
+// retag returns the DER encoding of an implicitly tagged value using the given
+// universal tag.
+func retag(v asn1.RawValue, tag int) []byte {
+	data, _ := asn1.Marshal(asn1.RawValue{
+		Class:      asn1.ClassUniversal,
+		Tag:        tag,
+		IsCompound: v.IsCompound,
+		Bytes:      v.Bytes,
+	})
+	return data
+}
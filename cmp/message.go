@@ -0,0 +1,289 @@
+// Package cmp implements the messages of the Certificate Management Protocol
+// (RFC 4210) used in the Lightweight CMP profile (RFC 9483).
+package cmp
+
+import (
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// BodyType is the type of the body of a PKIMessage.
+type BodyType int
+
+// Body types, RFC 4210 section 5.1.2.
+const (
+	BodyIR       BodyType = 0
+	BodyIP       BodyType = 1
+	BodyCR       BodyType = 2
+	BodyCP       BodyType = 3
+	BodyP10CR    BodyType = 4
+	BodyKUR      BodyType = 7
+	BodyKUP      BodyType = 8
+	BodyRR       BodyType = 11
+	BodyRP       BodyType = 12
+	BodyPKIConf  BodyType = 19
+	BodyGenM     BodyType = 21
+	BodyGenP     BodyType = 22
+	BodyError    BodyType = 23
+	BodyCertConf BodyType = 24
+)
+
+// String returns the name used in RFC 4210 for the body type.
+func (t BodyType) String() string {
+	switch t {
+	case BodyIR:
+		return "ir"
+	case BodyIP:
+		return "ip"
+	case BodyCR:
+		return "cr"
+	case BodyCP:
+		return "cp"
+	case BodyP10CR:
+		return "p10cr"
+	case BodyKUR:
+		return "kur"
+	case BodyKUP:
+		return "kup"
+	case BodyRR:
+		return "rr"
+	case BodyRP:
+		return "rp"
+	case BodyPKIConf:
+		return "pkiconf"
+	case BodyGenM:
+		return "genm"
+	case BodyGenP:
+		return "genp"
+	case BodyError:
+		return "error"
+	case BodyCertConf:
+		return "certConf"
+	default:
+		return fmt.Sprintf("unknown(%d)", int(t))
+	}
+}
+
+// Protocol versions, RFC 9480 section 2.20.
+const (
+	Version2000 = 2
+	Version2021 = 3
+)
+
+var (
+	// OIDImplicitConfirm is the general info type used to request and grant
+	// the implicit confirmation of issued certificates.
+	OIDImplicitConfirm = asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 7, 4, 13}
+	// OIDCACerts is the general message type used to request the CA
+	// certificates.
+	OIDCACerts = asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 7, 4, 17}
+)
+
+// Header is the PKIHeader of a message, RFC 4210 section 5.1.1.
+type Header struct {
+	PVNO          int
+	Sender        asn1.RawValue
+	Recipient     asn1.RawValue
+	MessageTime   time.Time                `asn1:"generalized,explicit,optional,tag:0"`
+	ProtectionAlg pkix.AlgorithmIdentifier `asn1:"explicit,optional,tag:1"`
+	SenderKID     []byte                   `asn1:"explicit,optional,tag:2"`
+	RecipKID      []byte                   `asn1:"explicit,optional,tag:3"`
+	TransactionID []byte                   `asn1:"explicit,optional,tag:4"`
+	SenderNonce   []byte                   `asn1:"explicit,optional,tag:5"`
+	RecipNonce    []byte                   `asn1:"explicit,optional,tag:6"`
+	FreeText      []asn1.RawValue          `asn1:"explicit,optional,tag:7"`
+	GeneralInfo   []InfoTypeAndValue       `asn1:"explicit,optional,tag:8"`
+}
+
+// HasGeneralInfo returns true if the header contains general information of
+// the given type.
+func (h *Header) HasGeneralInfo(oid asn1.ObjectIdentifier) bool {
+	for _, info := range h.GeneralInfo {
+		if info.InfoType.Equal(oid) {
+			return true
+		}
+	}
+	return false
+}
+
+// InfoTypeAndValue is the type used in general information and in general
+// messages, RFC 4210 section 5.3.19.
+type InfoTypeAndValue struct {
+	InfoType  asn1.ObjectIdentifier
+	InfoValue asn1.RawValue `asn1:"optional"`
+}
+
+// Message is a PKIMessage, RFC 4210 section 5.1.
+type Message struct {
+	Header Header
+	Type   BodyType
+	// Body is the DER encoding of the content of the message body.
+	Body       []byte
+	Protection []byte
+	ExtraCerts []*x509.Certificate
+	// protectedPart is the DER encoding of the header and the body of a parsed
+	// message.
+	protectedPart []byte
+}
+
+type pkiMessage struct {
+	Header     asn1.RawValue
+	Body       asn1.RawValue
+	Protection asn1.BitString  `asn1:"explicit,optional,tag:0"`
+	ExtraCerts []asn1.RawValue `asn1:"explicit,optional,tag:1"`
+}
+
+type protectedPart struct {
+	Header asn1.RawValue
+	Body   asn1.RawValue
+}
+
+// ParseMessage parses a DER encoded PKIMessage.
+func ParseMessage(der []byte) (*Message, error) {
+	var msg pkiMessage
+	if rest, err := asn1.Unmarshal(der, &msg); err != nil {
+		return nil, fmt.Errorf("error parsing message: %w", err)
+	} else if len(rest) > 0 {
+		return nil, errors.New("error parsing message: trailing data")
+	}
+	if msg.Body.Class != asn1.ClassContextSpecific || !msg.Body.IsCompound {
+		return nil, errors.New("error parsing message: invalid body")
+	}
+
+	m := &Message{
+		Type:       BodyType(msg.Body.Tag),
+		Body:       msg.Body.Bytes,
+		Protection: msg.Protection.RightAlign(),
+	}
+	if rest, err := asn1.Unmarshal(msg.Header.FullBytes, &m.Header); err != nil {
+		return nil, fmt.Errorf("error parsing message header: %w", err)
+	} else if len(rest) > 0 {
+		return nil, errors.New("error parsing message header: trailing data")
+	}
+	for _, raw := range msg.ExtraCerts {
+		cert, err := x509.ParseCertificate(raw.FullBytes)
+		if err != nil {
+			return nil, fmt.Errorf("error parsing extra certificate: %w", err)
+		}
+		m.ExtraCerts = append(m.ExtraCerts, cert)
+	}
+
+	var err error
+	if m.protectedPart, err = asn1.Marshal(protectedPart{
+		Header: msg.Header,
+		Body:   msg.Body,
+	}); err != nil {
+		return nil, fmt.Errorf("error marshaling protected part: %w", err)
+	}
+
+	return m, nil
+}
+
+// ProtectedPart returns the DER encoding of the header and the body of the
+// message, the data used to compute the protection.
+func (m *Message) ProtectedPart() ([]byte, error) {
+	if m.protectedPart != nil {
+		return m.protectedPart, nil
+	}
+
+	header, err := asn1.Marshal(m.Header)
+	if err != nil {
+		return nil, fmt.Errorf("error marshaling message header: %w", err)
+	}
+	data, err := asn1.Marshal(protectedPart{
+		Header: asn1.RawValue{FullBytes: header},
+		Body:   m.body(),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error marshaling protected part: %w", err)
+	}
+	return data, nil
+}
+
+// Marshal returns the DER encoding of the message.
+func (m *Message) Marshal() ([]byte, error) {
+	header, err := asn1.Marshal(m.Header)
+	if err != nil {
+		return nil, fmt.Errorf("error marshaling message header: %w", err)
+	}
+
+	msg := pkiMessage{
+		Header: asn1.RawValue{FullBytes: header},
+		Body:   m.body(),
+	}
+	if len(m.Protection) > 0 {
+		msg.Protection = asn1.BitString{
+			Bytes:     m.Protection,
+			BitLength: 8 * len(m.Protection),
+		}
+	}
+	for _, cert := range m.ExtraCerts {
+		msg.ExtraCerts = append(msg.ExtraCerts, asn1.RawValue{FullBytes: cert.Raw})
+	}
+
+	data, err := asn1.Marshal(msg)
+	if err != nil {
+		return nil, fmt.Errorf("error marshaling message: %w", err)
+	}
+	return data, nil
+}
+
+func (m *Message) body() asn1.RawValue {
+	return asn1.RawValue{
+		Class:      asn1.ClassContextSpecific,
+		Tag:        int(m.Type),
+		IsCompound: true,
+		Bytes:      m.Body,
+	}
+}
+
+// NewResponse creates a response to the given request. The transaction
+// identifier and the nonces are set as described in RFC 9483 section 3.1.
+func NewResponse(req *Message, typ BodyType, body []byte, senderNonce []byte) *Message {
+	return &Message{
+		Header: Header{
+			PVNO:          req.Header.PVNO,
+			Sender:        emptyDirectoryName(),
+			Recipient:     req.Header.Sender,
+			MessageTime:   time.Now().UTC().Truncate(time.Second),
+			RecipKID:      req.Header.SenderKID,
+			TransactionID: req.Header.TransactionID,
+			SenderNonce:   senderNonce,
+			RecipNonce:    req.Header.SenderNonce,
+		},
+		Type: typ,
+		Body: body,
+	}
+}
+
+// directoryName returns a GeneralName with the given DER encoded name.
+func directoryName(name []byte) asn1.RawValue {
+	return asn1.RawValue{
+		Class:      asn1.ClassContextSpecific,
+		Tag:        4,
+		IsCompound: true,
+		Bytes:      name,
+	}
+}
+
+// emptyDirectoryName returns a GeneralName with the NULL-DN, used when the
+// sender of a message cannot be identified by a name.
+func emptyDirectoryName() asn1.RawValue {
+	return directoryName([]byte{0x30, 0x00})
+}
+
+// FreeText returns the UTF8 strings used in PKIFreeText fields.
+func FreeText(text ...string) []asn1.RawValue {
+	values := make([]asn1.RawValue, len(text))
+	for i, s := range text {
+		values[i] = asn1.RawValue{
+			Tag:   asn1.TagUTF8String,
+			Bytes: []byte(s),
+		}
+	}
+	return values
+}
@@ -0,0 +1,370 @@
+package cmp
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.step.sm/crypto/keyutil"
+	"go.step.sm/crypto/minica"
+)
+
+func newPBMAC1(t *testing.T, iterations int) pkix.AlgorithmIdentifier {
+	t.Helper()
+	kdf, err := asn1.Marshal(pbkdf2Params{
+		Salt:           []byte("0123456789abcdef"),
+		IterationCount: iterations,
+		KeyLength:      32,
+		PRF:            pkix.AlgorithmIdentifier{Algorithm: oidHMACWithSHA256, Parameters: asn1.NullRawValue},
+	})
+	require.NoError(t, err)
+	params, err := asn1.Marshal(pbmac1Params{
+		KeyDerivationFunc: pkix.AlgorithmIdentifier{Algorithm: oidPBKDF2, Parameters: asn1.RawValue{FullBytes: kdf}},
+		MessageAuthScheme: pkix.AlgorithmIdentifier{Algorithm: oidHMACWithSHA256, Parameters: asn1.NullRawValue},
+	})
+	require.NoError(t, err)
+	return pkix.AlgorithmIdentifier{Algorithm: OIDPBMAC1, Parameters: asn1.RawValue{FullBytes: params}}
+}
+
+func newRequest(typ BodyType, body []byte) *Message {
+	return &Message{
+		Header: Header{
+			PVNO:          Version2000,
+			Sender:        emptyDirectoryName(),
+			Recipient:     emptyDirectoryName(),
+			MessageTime:   time.Now().UTC().Truncate(time.Second),
+			TransactionID: []byte("transaction-id-0"),
+			SenderNonce:   []byte("sender-nonce-000"),
+			FreeText:      FreeText("hello"),
+			GeneralInfo:   []InfoTypeAndValue{{InfoType: OIDImplicitConfirm}},
+		},
+		Type: typ,
+		Body: body,
+	}
+}
+
+// newCertReqMessages returns the body of an ir message with a signature
+// proof-of-possession.
+func newCertReqMessages(t *testing.T, signer crypto.Signer, subject pkix.Name, extensions []pkix.Extension) []byte {
+	t.Helper()
+	spki, err := x509.MarshalPKIXPublicKey(signer.Public())
+	require.NoError(t, err)
+	var spkiRaw asn1.RawValue
+	_, err = asn1.Unmarshal(spki, &spkiRaw)
+	require.NoError(t, err)
+	rawSubject, err := asn1.Marshal(subject.ToRDNSequence())
+	require.NoError(t, err)
+
+	certReq := CertRequest{
+		CertTemplate: CertTemplate{
+			Subject:   asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 5, IsCompound: true, Bytes: rawSubject},
+			PublicKey: asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 6, IsCompound: true, Bytes: spkiRaw.Bytes},
+		},
+	}
+	if len(extensions) > 0 {
+		der, err := asn1.Marshal(extensions)
+		require.NoError(t, err)
+		var raw asn1.RawValue
+		_, err = asn1.Unmarshal(der, &raw)
+		require.NoError(t, err)
+		certReq.CertTemplate.Extensions = asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 9, IsCompound: true, Bytes: raw.Bytes}
+	}
+	certReqDER, err := asn1.Marshal(certReq)
+	require.NoError(t, err)
+
+	oid, h, err := signatureAlgorithm(signer.Public())
+	require.NoError(t, err)
+	digest := certReqDER
+	if h != 0 {
+		hh := h.New()
+		hh.Write(certReqDER)
+		digest = hh.Sum(nil)
+	}
+	sig, err := signer.Sign(rand.Reader, digest, h)
+	require.NoError(t, err)
+	popo, err := asn1.Marshal(popoSigningKey{
+		Algorithm: pkix.AlgorithmIdentifier{Algorithm: oid},
+		Signature: asn1.BitString{Bytes: sig, BitLength: 8 * len(sig)},
+	})
+	require.NoError(t, err)
+	var popoRaw asn1.RawValue
+	_, err = asn1.Unmarshal(popo, &popoRaw)
+	require.NoError(t, err)
+
+	body, err := asn1.Marshal([]CertReqMsg{{
+		CertReq: CertRequest{Raw: certReqDER},
+		POPO:    asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 1, IsCompound: true, Bytes: popoRaw.Bytes},
+	}})
+	require.NoError(t, err)
+	return body
+}
+
+func TestParseMessage(t *testing.T) {
+	ca, err := minica.New()
+	require.NoError(t, err)
+
+	req := newRequest(BodyGenM, []byte{0x30, 0x00})
+	req.ExtraCerts = []*x509.Certificate{ca.Intermediate, ca.Root}
+	req.Protection = []byte("protection")
+	der, err := req.Marshal()
+	require.NoError(t, err)
+
+	m, err := ParseMessage(der)
+	require.NoError(t, err)
+	assert.Equal(t, BodyGenM, m.Type)
+	assert.Equal(t, []byte{0x30, 0x00}, m.Body)
+	assert.Equal(t, []byte("protection"), m.Protection)
+	assert.Equal(t, req.ExtraCerts, m.ExtraCerts)
+	assert.Equal(t, Version2000, m.Header.PVNO)
+	assert.Equal(t, req.Header.TransactionID, m.Header.TransactionID)
+	assert.Equal(t, req.Header.SenderNonce, m.Header.SenderNonce)
+	assert.True(t, req.Header.MessageTime.Equal(m.Header.MessageTime))
+	assert.True(t, m.Header.HasGeneralInfo(OIDImplicitConfirm))
+	assert.False(t, m.Header.HasGeneralInfo(OIDCACerts))
+
+	reqPart, err := req.ProtectedPart()
+	require.NoError(t, err)
+	part, err := m.ProtectedPart()
+	require.NoError(t, err)
+	assert.Equal(t, reqPart, part)
+
+	_, err = ParseMessage(append(der, 0))
+	assert.Error(t, err)
+	_, err = ParseMessage([]byte("not a message"))
+	assert.Error(t, err)
+}
+
+func TestMessage_MAC(t *testing.T) {
+	secret := []byte("shared-secret")
+	alg := newPBMAC1(t, 1000)
+
+	req := newRequest(BodyGenM, []byte{0x30, 0x00})
+	require.NoError(t, req.ProtectMAC(secret, alg))
+	assert.True(t, req.IsMACProtected())
+	der, err := req.Marshal()
+	require.NoError(t, err)
+
+	m, err := ParseMessage(der)
+	require.NoError(t, err)
+	assert.NoError(t, m.VerifyMAC(secret))
+
+	var e *Error
+	err = m.VerifyMAC([]byte("bad-secret"))
+	require.ErrorAs(t, err, &e)
+	assert.Equal(t, FailBadMessageCheck, e.FailInfo)
+
+	// The body is part of the protection.
+	m.Body = []byte{0x05, 0x00}
+	m.protectedPart = nil
+	assert.Error(t, m.VerifyMAC(secret))
+
+	// Too many iterations are rejected.
+	req = newRequest(BodyGenM, []byte{0x30, 0x00})
+	req.Header.ProtectionAlg = newPBMAC1(t, maxIterationCount+1)
+	req.Protection = []byte("protection")
+	err = req.VerifyMAC(secret)
+	require.ErrorAs(t, err, &e)
+	assert.Equal(t, FailBadAlg, e.FailInfo)
+}
+
+func TestMessage_Signature(t *testing.T) {
+	tests := []struct {
+		name string
+		kty  string
+		crv  string
+		size int
+	}{
+		{"rsa", "RSA", "", 2048},
+		{"p256", "EC", "P-256", 0},
+		{"p384", "EC", "P-384", 0},
+		{"ed25519", "OKP", "Ed25519", 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			signer, err := keyutil.GenerateSigner(tt.kty, tt.crv, tt.size)
+			require.NoError(t, err)
+			template := &x509.Certificate{
+				SerialNumber: big.NewInt(1),
+				Subject:      pkix.Name{CommonName: "signer"},
+				NotBefore:    time.Now(),
+				NotAfter:     time.Now().Add(time.Hour),
+				SubjectKeyId: []byte("key-id"),
+			}
+			der, err := x509.CreateCertificate(rand.Reader, template, template, signer.Public(), signer)
+			require.NoError(t, err)
+			cert, err := x509.ParseCertificate(der)
+			require.NoError(t, err)
+
+			req := newRequest(BodyGenM, []byte{0x30, 0x00})
+			require.NoError(t, req.ProtectSignature(signer, cert))
+			req.ExtraCerts = []*x509.Certificate{cert}
+			data, err := req.Marshal()
+			require.NoError(t, err)
+
+			m, err := ParseMessage(data)
+			require.NoError(t, err)
+			assert.False(t, m.IsMACProtected())
+			got, err := m.VerifySignature()
+			require.NoError(t, err)
+			assert.Equal(t, cert, got)
+
+			m.Protection[0] ^= 0xff
+			_, err = m.VerifySignature()
+			assert.Error(t, err)
+		})
+	}
+}
+
+func TestCertReqMsg_CertificateRequest(t *testing.T) {
+	signer, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	san, err := asn1.Marshal([]asn1.RawValue{{Class: asn1.ClassContextSpecific, Tag: 2, Bytes: []byte("device.example.com")}})
+	require.NoError(t, err)
+
+	body := newCertReqMessages(t, signer, pkix.Name{CommonName: "device"}, []pkix.Extension{
+		{Id: oidExtensionSubjectAltName, Value: san},
+	})
+	msgs, err := ParseCertReqMessages(body)
+	require.NoError(t, err)
+	require.Len(t, msgs, 1)
+
+	csr, err := msgs[0].CertificateRequest(nil)
+	require.NoError(t, err)
+	assert.NoError(t, csr.CheckSignature())
+	assert.Equal(t, "device", csr.Subject.CommonName)
+	assert.Equal(t, []string{"device.example.com"}, csr.DNSNames)
+	assert.Equal(t, &signer.PublicKey, csr.PublicKey)
+
+	// The names of the old certificate are used by default.
+	old := &x509.Certificate{
+		RawSubject: []byte{0x30, 0x11, 0x31, 0x0f, 0x30, 0x0d, 0x06, 0x03, 0x55, 0x04, 0x03, 0x0c, 0x06, 'd', 'e', 'v', 'i', 'c', 'e'},
+		Extensions: []pkix.Extension{{Id: oidExtensionSubjectAltName, Value: san}},
+	}
+	body = newCertReqMessages(t, signer, pkix.Name{}, nil)
+	msgs, err = ParseCertReqMessages(body)
+	require.NoError(t, err)
+	msgs[0].CertReq.CertTemplate.Subject = asn1.RawValue{}
+	csr, err = msgs[0].CertificateRequest(old)
+	require.NoError(t, err)
+	assert.Equal(t, "device", csr.Subject.CommonName)
+	assert.Equal(t, []string{"device.example.com"}, csr.DNSNames)
+
+	// Modified requests fail the proof-of-possession.
+	msgs[0].CertReq.Raw[10] ^= 0xff
+	csr, err = msgs[0].CertificateRequest(nil)
+	require.NoError(t, err)
+	assert.Error(t, csr.CheckSignature())
+
+	var e *Error
+	msgs[0].POPO = asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, Bytes: []byte{}}
+	_, err = msgs[0].CertificateRequest(nil)
+	require.ErrorAs(t, err, &e)
+	assert.Equal(t, FailBadPOP, e.FailInfo)
+
+	_, err = ParseCertReqMessages([]byte{0x30, 0x00})
+	assert.Error(t, err)
+}
+
+func TestCertReqMsg_Validity(t *testing.T) {
+	notBefore := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	notAfter := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	nb, err := asn1.MarshalWithParams(notBefore, "generalized")
+	require.NoError(t, err)
+	na, err := asn1.Marshal(notAfter)
+	require.NoError(t, err)
+	validity, err := asn1.Marshal([]asn1.RawValue{
+		{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: nb},
+		{Class: asn1.ClassContextSpecific, Tag: 1, IsCompound: true, Bytes: na},
+	})
+	require.NoError(t, err)
+	var raw asn1.RawValue
+	_, err = asn1.Unmarshal(validity, &raw)
+	require.NoError(t, err)
+
+	m := &CertReqMsg{}
+	gotNotBefore, gotNotAfter, err := m.Validity()
+	require.NoError(t, err)
+	assert.True(t, gotNotBefore.IsZero())
+	assert.True(t, gotNotAfter.IsZero())
+
+	m.CertReq.CertTemplate.Validity = asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 4, IsCompound: true, Bytes: raw.Bytes}
+	gotNotBefore, gotNotAfter, err = m.Validity()
+	require.NoError(t, err)
+	assert.Equal(t, notBefore, gotNotBefore.UTC())
+	assert.Equal(t, notAfter, gotNotAfter.UTC())
+}
+
+func TestRevDetails(t *testing.T) {
+	ca, err := minica.New()
+	require.NoError(t, err)
+	signer, err := keyutil.GenerateDefaultSigner()
+	require.NoError(t, err)
+	cert, err := ca.Sign(&x509.Certificate{
+		Subject:   pkix.Name{CommonName: "device"},
+		PublicKey: signer.Public(),
+	})
+	require.NoError(t, err)
+
+	reason, err := asn1.Marshal(asn1.Enumerated(1))
+	require.NoError(t, err)
+	body, err := asn1.Marshal([]RevDetails{{
+		CertDetails: CertTemplate{
+			SerialNumber: cert.SerialNumber,
+			Issuer:       asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 3, IsCompound: true, Bytes: cert.RawIssuer},
+		},
+		CRLEntryDetails: []pkix.Extension{{Id: oidExtensionReasonCode, Value: reason}},
+	}})
+	require.NoError(t, err)
+
+	details, err := ParseRevReqContent(body)
+	require.NoError(t, err)
+	assert.True(t, details.Matches(cert))
+	assert.False(t, details.Matches(ca.Intermediate))
+	code, err := details.ReasonCode()
+	require.NoError(t, err)
+	assert.Equal(t, 1, code)
+
+	_, err = ParseRevReqContent([]byte{0x30, 0x00})
+	assert.Error(t, err)
+}
+
+func TestCertificateHash(t *testing.T) {
+	cert := &x509.Certificate{Raw: []byte("certificate"), SignatureAlgorithm: x509.ECDSAWithSHA384}
+	sum, err := CertificateHash(cert, pkix.AlgorithmIdentifier{})
+	require.NoError(t, err)
+	assert.Len(t, sum, 48)
+
+	sum, err = CertificateHash(cert, pkix.AlgorithmIdentifier{Algorithm: oidSHA256})
+	require.NoError(t, err)
+	assert.Len(t, sum, 32)
+
+	_, err = CertificateHash(cert, pkix.AlgorithmIdentifier{Algorithm: asn1.ObjectIdentifier{1, 2, 3}})
+	assert.Error(t, err)
+}
+
+func TestError_StatusInfo(t *testing.T) {
+	si := NewError(FailBadPOP, "bad pop").StatusInfo()
+	assert.Equal(t, StatusRejection, si.Status)
+	assert.Equal(t, asn1.BitString{Bytes: []byte{0x00, 0x40}, BitLength: 10}, si.FailInfo)
+
+	body, err := NewErrorMsgContent(si)
+	require.NoError(t, err)
+	var content errorMsgContent
+	_, err = asn1.Unmarshal(body, &content)
+	require.NoError(t, err)
+	assert.Equal(t, 9, content.Status.FailInfo.BitLength-1)
+	assert.Equal(t, 1, content.Status.FailInfo.At(int(FailBadPOP)))
+	var text string
+	_, err = asn1.Unmarshal(content.Status.StatusString[0].FullBytes, &text)
+	require.NoError(t, err)
+	assert.Equal(t, "bad pop", text)
+}
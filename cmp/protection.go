@@ -0,0 +1,329 @@
+package cmp
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"errors"
+	"fmt"
+
+	"golang.org/x/crypto/pbkdf2"
+)
+
+// maxIterationCount is the maximum number of PBKDF2 iterations accepted in a
+// PBMAC1 protected message.
+const maxIterationCount = 100000
+
+var (
+	// OIDPBMAC1 is the algorithm used in messages protected with a MAC based
+	// on a shared secret, RFC 9481 section 6.1.3.
+	OIDPBMAC1 = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 5, 14}
+
+	oidPBKDF2         = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 5, 12}
+	oidHMACWithSHA1   = asn1.ObjectIdentifier{1, 2, 840, 113549, 2, 7}
+	oidHMACWithSHA224 = asn1.ObjectIdentifier{1, 2, 840, 113549, 2, 8}
+	oidHMACWithSHA256 = asn1.ObjectIdentifier{1, 2, 840, 113549, 2, 9}
+	oidHMACWithSHA384 = asn1.ObjectIdentifier{1, 2, 840, 113549, 2, 10}
+	oidHMACWithSHA512 = asn1.ObjectIdentifier{1, 2, 840, 113549, 2, 11}
+
+	oidSHA1   = asn1.ObjectIdentifier{1, 3, 14, 3, 2, 26}
+	oidSHA256 = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 2, 1}
+	oidSHA384 = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 2, 2}
+	oidSHA512 = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 2, 3}
+)
+
+var hmacAlgorithms = []struct {
+	oid  asn1.ObjectIdentifier
+	hash crypto.Hash
+}{
+	{oidHMACWithSHA1, crypto.SHA1},
+	{oidHMACWithSHA224, crypto.SHA224},
+	{oidHMACWithSHA256, crypto.SHA256},
+	{oidHMACWithSHA384, crypto.SHA384},
+	{oidHMACWithSHA512, crypto.SHA512},
+}
+
+var signatureAlgorithms = []struct {
+	oid       asn1.ObjectIdentifier
+	algorithm x509.SignatureAlgorithm
+	hash      crypto.Hash
+}{
+	{asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 11}, x509.SHA256WithRSA, crypto.SHA256},
+	{asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 12}, x509.SHA384WithRSA, crypto.SHA384},
+	{asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 13}, x509.SHA512WithRSA, crypto.SHA512},
+	{asn1.ObjectIdentifier{1, 2, 840, 10045, 4, 3, 2}, x509.ECDSAWithSHA256, crypto.SHA256},
+	{asn1.ObjectIdentifier{1, 2, 840, 10045, 4, 3, 3}, x509.ECDSAWithSHA384, crypto.SHA384},
+	{asn1.ObjectIdentifier{1, 2, 840, 10045, 4, 3, 4}, x509.ECDSAWithSHA512, crypto.SHA512},
+	{asn1.ObjectIdentifier{1, 3, 101, 112}, x509.PureEd25519, crypto.Hash(0)},
+}
+
+// pbmac1Params are the parameters of the PBMAC1 algorithm, RFC 8018
+// appendix A.5.
+type pbmac1Params struct {
+	KeyDerivationFunc pkix.AlgorithmIdentifier
+	MessageAuthScheme pkix.AlgorithmIdentifier
+}
+
+// pbkdf2Params are the parameters of the PBKDF2 key derivation function, RFC
+// 8018 appendix A.2. Only the specified salt is supported.
+type pbkdf2Params struct {
+	Salt           []byte
+	IterationCount int
+	KeyLength      int                      `asn1:"optional"`
+	PRF            pkix.AlgorithmIdentifier `asn1:"optional"`
+}
+
+// IsMACProtected returns true if the message is protected with PBMAC1.
+func (m *Message) IsMACProtected() bool {
+	return m.Header.ProtectionAlg.Algorithm.Equal(OIDPBMAC1)
+}
+
+// VerifyMAC verifies a PBMAC1 protected message using the given shared
+// secret.
+func (m *Message) VerifyMAC(secret []byte) error {
+	if !m.IsMACProtected() {
+		return NewError(FailBadAlg, "message is not protected with PBMAC1")
+	}
+	if len(m.Protection) == 0 {
+		return NewError(FailBadMessageCheck, "message is not protected")
+	}
+
+	mac, err := m.computeMAC(secret, m.Header.ProtectionAlg)
+	if err != nil {
+		return err
+	}
+	if !hmac.Equal(mac, m.Protection) {
+		return NewError(FailBadMessageCheck, "invalid message protection")
+	}
+	return nil
+}
+
+// ProtectMAC protects the message with PBMAC1 using the given shared secret.
+// The parameters of the algorithm are the ones of the request, alg, with a new
+// salt.
+func (m *Message) ProtectMAC(secret []byte, alg pkix.AlgorithmIdentifier) error {
+	var params pbmac1Params
+	if _, err := asn1.Unmarshal(alg.Parameters.FullBytes, &params); err != nil {
+		return fmt.Errorf("error parsing PBMAC1 parameters: %w", err)
+	}
+	var kdf pbkdf2Params
+	if _, err := asn1.Unmarshal(params.KeyDerivationFunc.Parameters.FullBytes, &kdf); err != nil {
+		return fmt.Errorf("error parsing PBKDF2 parameters: %w", err)
+	}
+
+	kdf.Salt = make([]byte, max(len(kdf.Salt), 16))
+	if _, err := rand.Read(kdf.Salt); err != nil {
+		return fmt.Errorf("error generating salt: %w", err)
+	}
+	kdfParams, err := asn1.Marshal(kdf)
+	if err != nil {
+		return fmt.Errorf("error marshaling PBKDF2 parameters: %w", err)
+	}
+	params.KeyDerivationFunc.Parameters = asn1.RawValue{FullBytes: kdfParams}
+	macParams, err := asn1.Marshal(params)
+	if err != nil {
+		return fmt.Errorf("error marshaling PBMAC1 parameters: %w", err)
+	}
+
+	m.protectedPart = nil
+	m.Header.ProtectionAlg = pkix.AlgorithmIdentifier{
+		Algorithm:  OIDPBMAC1,
+		Parameters: asn1.RawValue{FullBytes: macParams},
+	}
+	m.Protection, err = m.computeMAC(secret, m.Header.ProtectionAlg)
+	return err
+}
+
+func (m *Message) computeMAC(secret []byte, alg pkix.AlgorithmIdentifier) ([]byte, error) {
+	var params pbmac1Params
+	if rest, err := asn1.Unmarshal(alg.Parameters.FullBytes, &params); err != nil || len(rest) > 0 {
+		return nil, NewError(FailBadAlg, "invalid PBMAC1 parameters")
+	}
+	if !params.KeyDerivationFunc.Algorithm.Equal(oidPBKDF2) {
+		return nil, NewError(FailBadAlg, "unsupported key derivation function %s", params.KeyDerivationFunc.Algorithm)
+	}
+	var kdf pbkdf2Params
+	if rest, err := asn1.Unmarshal(params.KeyDerivationFunc.Parameters.FullBytes, &kdf); err != nil || len(rest) > 0 {
+		return nil, NewError(FailBadAlg, "invalid PBKDF2 parameters")
+	}
+	if kdf.IterationCount < 1 || kdf.IterationCount > maxIterationCount {
+		return nil, NewError(FailBadAlg, "PBKDF2 iteration count must be between 1 and %d", maxIterationCount)
+	}
+
+	prf := crypto.SHA1
+	if len(kdf.PRF.Algorithm) > 0 {
+		var ok bool
+		if prf, ok = hmacHash(kdf.PRF.Algorithm); !ok {
+			return nil, NewError(FailBadAlg, "unsupported PBKDF2 pseudorandom function %s", kdf.PRF.Algorithm)
+		}
+	}
+	macHash, ok := hmacHash(params.MessageAuthScheme.Algorithm)
+	if !ok {
+		return nil, NewError(FailBadAlg, "unsupported message authentication scheme %s", params.MessageAuthScheme.Algorithm)
+	}
+	keyLength := kdf.KeyLength
+	if keyLength == 0 {
+		keyLength = macHash.Size()
+	}
+	if keyLength < 0 || keyLength > 64 {
+		return nil, NewError(FailBadAlg, "invalid PBKDF2 key length")
+	}
+
+	data, err := m.ProtectedPart()
+	if err != nil {
+		return nil, err
+	}
+	key := pbkdf2.Key(secret, kdf.Salt, kdf.IterationCount, keyLength, prf.New)
+	h := hmac.New(macHash.New, key)
+	h.Write(data)
+	return h.Sum(nil), nil
+}
+
+func hmacHash(oid asn1.ObjectIdentifier) (crypto.Hash, bool) {
+	for _, alg := range hmacAlgorithms {
+		if alg.oid.Equal(oid) {
+			return alg.hash, true
+		}
+	}
+	return 0, false
+}
+
+// VerifySignature verifies a signature protected message. The signer
+// certificate must be the first extra certificate of the message. It returns
+// the signer certificate, but it does not validate its chain.
+func (m *Message) VerifySignature() (*x509.Certificate, error) {
+	if len(m.Protection) == 0 {
+		return nil, NewError(FailBadMessageCheck, "message is not protected")
+	}
+	if len(m.ExtraCerts) == 0 {
+		return nil, NewError(FailBadMessageCheck, "signer certificate is missing")
+	}
+
+	var algorithm x509.SignatureAlgorithm
+	for _, alg := range signatureAlgorithms {
+		if alg.oid.Equal(m.Header.ProtectionAlg.Algorithm) {
+			algorithm = alg.algorithm
+			break
+		}
+	}
+	if algorithm == x509.UnknownSignatureAlgorithm {
+		return nil, NewError(FailBadAlg, "unsupported protection algorithm %s", m.Header.ProtectionAlg.Algorithm)
+	}
+
+	data, err := m.ProtectedPart()
+	if err != nil {
+		return nil, err
+	}
+	cert := m.ExtraCerts[0]
+	if len(m.Header.SenderKID) > 0 && len(cert.SubjectKeyId) > 0 && !bytes.Equal(m.Header.SenderKID, cert.SubjectKeyId) {
+		return nil, NewError(FailBadMessageCheck, "sender key identifier does not match the signer certificate")
+	}
+	if err := cert.CheckSignature(algorithm, data, m.Protection); err != nil {
+		return nil, NewError(FailBadMessageCheck, "invalid message protection: %v", err)
+	}
+	return cert, nil
+}
+
+// ProtectSignature protects the message with a signature using the given
+// signer and certificate. The sender of the message is set to the subject of
+// the certificate.
+func (m *Message) ProtectSignature(signer crypto.Signer, cert *x509.Certificate) error {
+	oid, h, err := signatureAlgorithm(signer.Public())
+	if err != nil {
+		return err
+	}
+
+	m.protectedPart = nil
+	m.Header.Sender = directoryName(cert.RawSubject)
+	m.Header.SenderKID = cert.SubjectKeyId
+	m.Header.ProtectionAlg = pkix.AlgorithmIdentifier{Algorithm: oid}
+	if _, ok := signer.Public().(*rsa.PublicKey); ok {
+		m.Header.ProtectionAlg.Parameters = asn1.NullRawValue
+	}
+
+	data, err := m.ProtectedPart()
+	if err != nil {
+		return err
+	}
+	digest := data
+	if h != 0 {
+		hh := h.New()
+		hh.Write(data)
+		digest = hh.Sum(nil)
+	}
+	if m.Protection, err = signer.Sign(rand.Reader, digest, h); err != nil {
+		return fmt.Errorf("error signing message: %w", err)
+	}
+	return nil
+}
+
+// signatureAlgorithm returns the signature algorithm and hash used to sign
+// messages with the given key.
+func signatureAlgorithm(pub crypto.PublicKey) (asn1.ObjectIdentifier, crypto.Hash, error) {
+	var algorithm x509.SignatureAlgorithm
+	switch k := pub.(type) {
+	case *rsa.PublicKey:
+		algorithm = x509.SHA256WithRSA
+	case *ecdsa.PublicKey:
+		switch k.Curve {
+		case elliptic.P384():
+			algorithm = x509.ECDSAWithSHA384
+		case elliptic.P521():
+			algorithm = x509.ECDSAWithSHA512
+		default:
+			algorithm = x509.ECDSAWithSHA256
+		}
+	case ed25519.PublicKey:
+		algorithm = x509.PureEd25519
+	default:
+		return nil, 0, fmt.Errorf("unsupported public key type %T", pub)
+	}
+	for _, alg := range signatureAlgorithms {
+		if alg.algorithm == algorithm {
+			return alg.oid, alg.hash, nil
+		}
+	}
+	return nil, 0, errors.New("unsupported signature algorithm")
+}
+
+// CertificateHash returns the hash of a certificate used in certConf messages,
+// RFC 9481 section 2. If the hash algorithm is not given, the hash of the
+// certificate signature algorithm is used.
+func CertificateHash(cert *x509.Certificate, alg pkix.AlgorithmIdentifier) ([]byte, error) {
+	var h crypto.Hash
+	switch {
+	case len(alg.Algorithm) == 0:
+		switch cert.SignatureAlgorithm {
+		case x509.SHA1WithRSA, x509.ECDSAWithSHA1:
+			h = crypto.SHA1
+		case x509.SHA384WithRSA, x509.SHA384WithRSAPSS, x509.ECDSAWithSHA384:
+			h = crypto.SHA384
+		case x509.SHA512WithRSA, x509.SHA512WithRSAPSS, x509.ECDSAWithSHA512, x509.PureEd25519:
+			h = crypto.SHA512
+		default:
+			h = crypto.SHA256
+		}
+	case alg.Algorithm.Equal(oidSHA1):
+		h = crypto.SHA1
+	case alg.Algorithm.Equal(oidSHA256):
+		h = crypto.SHA256
+	case alg.Algorithm.Equal(oidSHA384):
+		h = crypto.SHA384
+	case alg.Algorithm.Equal(oidSHA512):
+		h = crypto.SHA512
+	default:
+		return nil, NewError(FailBadAlg, "unsupported hash algorithm %s", alg.Algorithm)
+	}
+
+	hh := h.New()
+	hh.Write(cert.Raw)
+	return hh.Sum(nil), nil
+}
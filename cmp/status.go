@@ -0,0 +1,107 @@
+package cmp
+
+import (
+	"encoding/asn1"
+	"fmt"
+)
+
+// Status is the PKIStatus of a response, RFC 4210 section 5.2.3.
+type Status int
+
+// PKIStatus values.
+const (
+	StatusAccepted               Status = 0
+	StatusGrantedWithMods        Status = 1
+	StatusRejection              Status = 2
+	StatusWaiting                Status = 3
+	StatusRevocationWarning      Status = 4
+	StatusRevocationNotification Status = 5
+	StatusKeyUpdateWarning       Status = 6
+)
+
+// FailInfo is a bit of the PKIFailureInfo of a response, RFC 4210 section
+// 5.2.3.
+type FailInfo int
+
+// PKIFailureInfo values.
+const (
+	FailBadAlg              FailInfo = 0
+	FailBadMessageCheck     FailInfo = 1
+	FailBadRequest          FailInfo = 2
+	FailBadTime             FailInfo = 3
+	FailBadCertID           FailInfo = 4
+	FailBadDataFormat       FailInfo = 5
+	FailWrongAuthority      FailInfo = 6
+	FailIncorrectData       FailInfo = 7
+	FailMissingTimeStamp    FailInfo = 8
+	FailBadPOP              FailInfo = 9
+	FailCertRevoked         FailInfo = 10
+	FailCertConfirmed       FailInfo = 11
+	FailWrongIntegrity      FailInfo = 12
+	FailBadRecipientNonce   FailInfo = 13
+	FailTimeNotAvailable    FailInfo = 14
+	FailUnacceptedPolicy    FailInfo = 15
+	FailUnacceptedExtension FailInfo = 16
+	FailAddInfoNotAvailable FailInfo = 17
+	FailBadSenderNonce      FailInfo = 18
+	FailBadCertTemplate     FailInfo = 19
+	FailSignerNotTrusted    FailInfo = 20
+	FailTransactionIDInUse  FailInfo = 21
+	FailUnsupportedVersion  FailInfo = 22
+	FailNotAuthorized       FailInfo = 23
+	FailSystemUnavail       FailInfo = 24
+	FailSystemFailure       FailInfo = 25
+	FailDuplicateCertReq    FailInfo = 26
+)
+
+// bitString returns the DER encoding of a PKIFailureInfo with only this bit
+// set.
+func (f FailInfo) bitString() asn1.BitString {
+	b := make([]byte, int(f)/8+1)
+	b[int(f)/8] = 0x80 >> (uint(f) % 8)
+	return asn1.BitString{
+		Bytes:     b,
+		BitLength: int(f) + 1,
+	}
+}
+
+// StatusInfo is the PKIStatusInfo of a response, RFC 4210 section 5.2.3.
+type StatusInfo struct {
+	Status       Status
+	StatusString []asn1.RawValue `asn1:"optional"`
+	FailInfo     asn1.BitString  `asn1:"optional"`
+}
+
+// Error is an error returned in CMP error messages. It contains the failure
+// information sent to the client.
+type Error struct {
+	FailInfo FailInfo
+	Err      error
+}
+
+// NewError returns a new Error with the given failure information.
+func NewError(failInfo FailInfo, format string, args ...any) *Error {
+	return &Error{
+		FailInfo: failInfo,
+		Err:      fmt.Errorf(format, args...),
+	}
+}
+
+// Error implements the error interface.
+func (e *Error) Error() string {
+	return e.Err.Error()
+}
+
+// Unwrap returns the wrapped error.
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// StatusInfo returns the PKIStatusInfo used in the error message.
+func (e *Error) StatusInfo() StatusInfo {
+	return StatusInfo{
+		Status:       StatusRejection,
+		StatusString: FreeText(e.Err.Error()),
+		FailInfo:     e.FailInfo.bitString(),
+	}
+}
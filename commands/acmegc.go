@@ -0,0 +1,119 @@
+package commands
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/pkg/errors"
+	"github.com/urfave/cli"
+
+	"github.com/smallstep/cli-utils/command"
+	"github.com/smallstep/cli-utils/errs"
+	"github.com/smallstep/nosql"
+
+	acmeNoSQL "github.com/smallstep/certificates/acme/db/nosql"
+	"github.com/smallstep/certificates/authority/config"
+	"github.com/smallstep/certificates/db"
+)
+
+func init() {
+	command.Register(cli.Command{
+		Name:      "acme-gc",
+		Usage:     "remove stale ACME objects from the database",
+		UsageText: "**step-ca acme-gc** <config> [**--retention**=<duration>] [**--nonce-retention**=<duration>]",
+		Action:    acmeGCAction,
+		Description: `**step-ca acme-gc** removes the expired ACME orders and
+authorizations, their challenges, the unused nonces, and the references to the
+removed orders from the database configured in step-ca. Expired ACME rate limit
+counters are removed too.
+
+The command opens the database directly, so step-ca must be stopped if the
+database does not support concurrent access, like badger. The retention values
+default to the ones in the "acmeGC" property of the configuration.
+
+## POSITIONAL ARGUMENTS
+
+<config>
+:  The ca.json that contains the step-ca configuration.
+
+## EXAMPLES
+
+Remove the stale ACME objects using the default retention:
+'''
+$ step-ca acme-gc $(step path)/config/ca.json
+'''
+
+Remove the orders and authorizations that expired more than a week ago:
+'''
+$ step-ca acme-gc --retention 168h $(step path)/config/ca.json
+'''`,
+		Flags: []cli.Flag{
+			cli.DurationFlag{
+				Name: "retention",
+				Usage: `the <duration> that expired orders and authorizations are kept
+in the database. Defaults to 720h.`,
+			},
+			cli.DurationFlag{
+				Name: "nonce-retention",
+				Usage: `the <duration> that unused nonces are kept in the database.
+Defaults to 24h.`,
+			},
+		},
+	})
+}
+
+func acmeGCAction(ctx *cli.Context) error {
+	if err := errs.NumberOfArguments(ctx, 1); err != nil {
+		return err
+	}
+
+	cfg, err := config.LoadConfiguration(ctx.Args().Get(0))
+	if err != nil {
+		return err
+	}
+	if cfg.DB == nil {
+		return errors.New("the configuration does not have a database")
+	}
+
+	opts := acmeNoSQL.SweepOptions{
+		Retention:      config.DefaultACMEGCRetention.Duration,
+		NonceRetention: config.DefaultACMEGCNonceRetention.Duration,
+	}
+	if c := cfg.ACMEGC; c != nil {
+		if c.Retention != nil {
+			opts.Retention = c.Retention.Duration
+		}
+		if c.NonceRetention != nil {
+			opts.NonceRetention = c.NonceRetention.Duration
+		}
+	}
+	if ctx.IsSet("retention") {
+		opts.Retention = ctx.Duration("retention")
+	}
+	if ctx.IsSet("nonce-retention") {
+		opts.NonceRetention = ctx.Duration("nonce-retention")
+	}
+	if opts.Retention < 0 || opts.NonceRetention < 0 {
+		return errors.New("retention values must be greater than or equal to 0")
+	}
+
+	authDB, err := db.New(cfg.DB)
+	if err != nil {
+		return err
+	}
+	defer authDB.Shutdown()
+
+	nosqlDB, ok := authDB.(nosql.DB)
+	if !ok {
+		return errors.Errorf("database of type %s does not support ACME", cfg.DB.Type)
+	}
+	acmeDB, err := acmeNoSQL.New(nosqlDB)
+	if err != nil {
+		return errors.Wrap(err, "error configuring ACME DB interface")
+	}
+
+	res, err := acmeDB.Sweep(context.Background(), opts)
+	fmt.Printf("Removed %d orders, %d authorizations, %d challenges, %d nonces and %d rate limit counters, and updated %d account order indexes.\n",
+		res.Orders, res.Authorizations, res.Challenges, res.Nonces, res.RateLimits, res.OrderIndexes)
+	return err
+}
@@ -0,0 +1,115 @@
+package commands
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/urfave/cli"
+
+	"github.com/smallstep/cli-utils/command"
+	"github.com/smallstep/cli-utils/errs"
+
+	"github.com/smallstep/certificates/acme/agent"
+)
+
+func init() {
+	command.Register(cli.Command{
+		Name:  "validation-agent",
+		Usage: "run a remote agent for the multi-perspective validation of ACME challenges",
+		UsageText: `**step-ca validation-agent** **--token-file**=<file> **--crt**=<file> **--key**=<file>
+[**--address**=<address>] [**--resolver**=<addr>]`,
+		Action: validationAgentAction,
+		Description: `**step-ca validation-agent** runs an HTTPS server that validates
+ACME challenges from the network perspective of the host running it.
+
+ACME provisioners with "validationPerspectives" send the http-01, dns-01,
+dns-account-01 and tls-alpn-01 challenges validated by the CA to the
+configured agents, and the challenges are only valid if the number of agents
+that validate them reaches the quorum. Agents should run on different networks
+than the CA to detect DNS or BGP hijacks.
+
+The requests are authenticated with the token in the file passed with the
+**--token-file** flag, it must be the same token configured in the provisioner.
+The token is protected using the TLS certificate and key passed with the
+**--crt** and **--key** flags; the CA verifies the certificate using the
+"roots" of the "validationPerspectives", or the system roots.
+
+## EXAMPLES
+
+Run an agent:
+'''
+$ step-ca validation-agent --token-file token.txt \
+  --crt agent.crt --key agent.key --address :9443
+'''`,
+		Flags: []cli.Flag{
+			cli.StringFlag{
+				Name:  "address",
+				Usage: "the <address> the agent listens on.",
+				Value: ":9443",
+			},
+			cli.StringFlag{
+				Name:  "token-file",
+				Usage: "path to the <file> containing the token used to authenticate the requests.",
+			},
+			cli.StringFlag{
+				Name:  "crt",
+				Usage: "path to the <file> with the TLS certificate of the agent.",
+			},
+			cli.StringFlag{
+				Name:  "key",
+				Usage: "path to the <file> with the TLS private key of the agent.",
+			},
+			cli.StringFlag{
+				Name:  "resolver",
+				Usage: "address of a DNS resolver to be used instead of the default.",
+			},
+		},
+	})
+}
+
+func validationAgentAction(ctx *cli.Context) error {
+	if err := errs.NumberOfArguments(ctx, 0); err != nil {
+		return err
+	}
+	if err := errs.RequiredFlag(ctx, "token-file"); err != nil {
+		return err
+	}
+
+	if err := errs.RequiredFlag(ctx, "crt"); err != nil {
+		return err
+	}
+	if err := errs.RequiredFlag(ctx, "key"); err != nil {
+		return err
+	}
+
+	b, err := os.ReadFile(ctx.String("token-file"))
+	if err != nil {
+		return errs.FileError(err, ctx.String("token-file"))
+	}
+	token := string(bytes.TrimSpace(b))
+	if token == "" {
+		return errors.Errorf("file %s is empty", ctx.String("token-file"))
+	}
+
+	// replace resolver if requested
+	if resolver := ctx.String("resolver"); resolver != "" {
+		net.DefaultResolver.PreferGo = true
+		net.DefaultResolver.Dial = func(_ context.Context, network, _ string) (net.Conn, error) {
+			return net.Dial(network, resolver)
+		}
+	}
+
+	srv := &http.Server{
+		Addr:              ctx.String("address"),
+		Handler:           agent.New(token),
+		ReadHeaderTimeout: 15 * time.Second,
+	}
+	fmt.Printf("Validation agent listening at %s\n", srv.Addr)
+	return srv.ListenAndServeTLS(ctx.String("crt"), ctx.String("key"))
+}
@@ -0,0 +1,98 @@
+package db
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/smallstep/nosql/database"
+)
+
+var cmpConfirmationsTable = []byte("cmp_confirmations")
+
+// CMPConfirmationDB is an extension of AuthDB that stores the CMP
+// certificates waiting for the confirmation of the client.
+type CMPConfirmationDB interface {
+	CreateCMPConfirmation(c *CMPConfirmation) error
+	UseCMPConfirmation(provisionerID, transactionID string) (*CMPConfirmation, error)
+}
+
+// CMPConfirmation is a certificate issued by a CMP provisioner that is
+// waiting for a certConf message. Confirmations are identified by the
+// provisioner and the transaction id used by the client, and the sender of the
+// confirmation must be the sender of the request, identified by the shared
+// secret or by the certificate used to sign the request.
+type CMPConfirmation struct {
+	ProvisionerID     string    `json:"provisionerID"`
+	TransactionID     string    `json:"transactionID"`
+	CertReqID         int       `json:"certReqID"`
+	Certificate       []byte    `json:"certificate"`
+	SenderMAC         bool      `json:"senderMAC,omitempty"`
+	SenderCertificate []byte    `json:"senderCertificate,omitempty"`
+	ExpiresAt         time.Time `json:"expiresAt"`
+}
+
+func cmpConfirmationKey(provisionerID, transactionID string) []byte {
+	return []byte(provisionerID + "/" + transactionID)
+}
+
+// CreateCMPConfirmation stores a certificate waiting for confirmation,
+// replacing any previous one in the same transaction. Expired confirmations
+// are removed.
+func (db *DB) CreateCMPConfirmation(c *CMPConfirmation) error {
+	entries, err := db.List(cmpConfirmationsTable)
+	if err != nil {
+		return errors.Wrap(err, "database List error")
+	}
+	now := time.Now()
+	for _, e := range entries {
+		var old CMPConfirmation
+		if err := json.Unmarshal(e.Value, &old); err != nil || now.After(old.ExpiresAt) {
+			if err := db.Del(cmpConfirmationsTable, e.Key); err != nil && !database.IsErrNotFound(err) {
+				return errors.Wrap(err, "database Del error")
+			}
+		}
+	}
+
+	b, err := json.Marshal(c)
+	if err != nil {
+		return errors.Wrap(err, "error marshaling cmp confirmation")
+	}
+	if err := db.Set(cmpConfirmationsTable, cmpConfirmationKey(c.ProvisionerID, c.TransactionID), b); err != nil {
+		return errors.Wrap(err, "database Set error")
+	}
+	return nil
+}
+
+// UseCMPConfirmation atomically removes and returns the certificate waiting
+// for confirmation in the given transaction. It returns a not found error if
+// there is none, or if it has expired.
+func (db *DB) UseCMPConfirmation(provisionerID, transactionID string) (*CMPConfirmation, error) {
+	key := cmpConfirmationKey(provisionerID, transactionID)
+	b, err := db.Get(cmpConfirmationsTable, key)
+	if err != nil {
+		return nil, errors.Wrap(err, "database Get error")
+	}
+
+	// The confirmation is emptied before deleting it, so concurrent messages
+	// cannot use it twice.
+	_, swapped, err := db.CmpAndSwap(cmpConfirmationsTable, key, b, []byte{})
+	switch {
+	case err != nil:
+		return nil, errors.Wrap(err, "error updating cmp confirmation")
+	case !swapped || len(b) == 0:
+		return nil, errors.Wrap(database.ErrNotFound, "cmp confirmation has already been used")
+	}
+	if err := db.Del(cmpConfirmationsTable, key); err != nil {
+		return nil, errors.Wrap(err, "database Del error")
+	}
+
+	var c CMPConfirmation
+	if err := json.Unmarshal(b, &c); err != nil {
+		return nil, errors.Wrap(err, "error unmarshaling cmp confirmation")
+	}
+	if time.Now().After(c.ExpiresAt) {
+		return nil, errors.Wrap(database.ErrNotFound, "cmp confirmation has expired")
+	}
+	return &c, nil
+}
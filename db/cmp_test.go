@@ -0,0 +1,49 @@
+package db
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/smallstep/nosql"
+)
+
+func TestDB_CMPConfirmations(t *testing.T) {
+	adb, err := New(&Config{Type: "badgerv2", DataSource: t.TempDir()})
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = adb.Shutdown() })
+	d, ok := adb.(CMPConfirmationDB)
+	require.True(t, ok)
+
+	now := time.Now().UTC().Truncate(time.Second)
+	c := &CMPConfirmation{
+		ProvisionerID:     "p1-id",
+		TransactionID:     "tx1",
+		CertReqID:         0,
+		Certificate:       []byte("cert"),
+		SenderCertificate: []byte("sender"),
+		ExpiresAt:         now.Add(time.Hour),
+	}
+	require.NoError(t, d.CreateCMPConfirmation(c))
+	require.NoError(t, d.CreateCMPConfirmation(&CMPConfirmation{ProvisionerID: "p1-id", TransactionID: "tx2", ExpiresAt: now.Add(-time.Minute)}))
+
+	// Confirmations can only be used once.
+	got, err := d.UseCMPConfirmation("p1-id", "tx1")
+	require.NoError(t, err)
+	assert.Equal(t, c, got)
+	_, err = d.UseCMPConfirmation("p1-id", "tx1")
+	assert.True(t, nosql.IsErrNotFound(err))
+
+	// Expired confirmations cannot be used, and are removed when new ones are
+	// created.
+	_, err = d.UseCMPConfirmation("p1-id", "tx2")
+	assert.True(t, nosql.IsErrNotFound(err))
+	require.NoError(t, d.CreateCMPConfirmation(&CMPConfirmation{ProvisionerID: "p1-id", TransactionID: "tx3", ExpiresAt: now.Add(-time.Minute)}))
+	require.NoError(t, d.CreateCMPConfirmation(&CMPConfirmation{ProvisionerID: "p2-id", TransactionID: "tx1", ExpiresAt: now.Add(time.Hour)}))
+	_, err = d.UseCMPConfirmation("p1-id", "tx3")
+	assert.True(t, nosql.IsErrNotFound(err))
+	_, err = d.UseCMPConfirmation("p2-id", "tx1")
+	require.NoError(t, err)
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"crypto/x509"
 	"encoding/json"
+	"log"
 	"strconv"
 	"strings"
 	"time"
@@ -102,6 +103,14 @@ type CertificateRevocationListDB interface {
 	StoreCRL(*CertificateRevocationListInfo) error
 }
 
+// NamedCertificateRevocationListDB is an extension of
+// CertificateRevocationListDB that allows to store partitioned and delta CRLs
+// by name.
+type NamedCertificateRevocationListDB interface {
+	GetCRLByName(name string) (*CertificateRevocationListInfo, error)
+	StoreCRLByName(name string, crlInfo *CertificateRevocationListInfo) error
+}
+
 // DB is a wrapper over the nosql.DB interface.
 type DB struct {
 	nosql.DB
@@ -129,6 +138,9 @@ func New(c *Config) (AuthDB, error) {
 		revokedCertsTable, certsTable, usedOTTTable,
 		sshCertsTable, sshHostsTable, sshHostPrincipalsTable, sshUsersTable,
 		revokedSSHCertsTable, certsDataTable, crlTable,
+		sshCertsDataTable, certIndexIDTable, certIndexProvisionerTable,
+		certIndexSANTable, certIndexSubjectTable, certIndexExpiryTable,
+		scepRequestsTable, scepChallengesTable, cmpConfirmationsTable,
 	}
 	for _, b := range tables {
 		if err := db.CreateTable(b); err != nil {
@@ -137,7 +149,17 @@ func New(c *Config) (AuthDB, error) {
 		}
 	}
 
-	return &DB{db, true}, nil
+	authDB := &DB{db, true}
+
+	// Index the certificates stored before the certificate indexes existed
+	// without delaying the start of the CA.
+	go func() {
+		if err := authDB.migrateCertificateIndexes(); err != nil {
+			log.Printf("error indexing certificates: %v", err)
+		}
+	}()
+
+	return authDB, nil
 }
 
 // RevokedCertificateInfo contains information regarding the certificate
@@ -262,6 +284,20 @@ func (db *DB) GetRevokedCertificates() (*[]RevokedCertificateInfo, error) {
 	return &revokedCerts, nil
 }
 
+// GetRevokedCertificate returns the revocation information of the
+// certificate with the given serial number.
+func (db *DB) GetRevokedCertificate(serialNumber string) (*RevokedCertificateInfo, error) {
+	b, err := db.Get(revokedCertsTable, []byte(serialNumber))
+	if err != nil {
+		return nil, errors.Wrap(err, "database Get error")
+	}
+	var data RevokedCertificateInfo
+	if err := json.Unmarshal(b, &data); err != nil {
+		return nil, errors.Wrap(err, "error unmarshaling json")
+	}
+	return &data, nil
+}
+
 // StoreCRL stores a CRL in the DB
 func (db *DB) StoreCRL(crlInfo *CertificateRevocationListInfo) error {
 	crlInfoBytes, err := json.Marshal(crlInfo)
@@ -290,6 +326,41 @@ func (db *DB) GetCRL() (*CertificateRevocationListInfo, error) {
 	return &crlInfo, err
 }
 
+// StoreCRLByName stores a partitioned or delta CRL in the DB with the given
+// name.
+func (db *DB) StoreCRLByName(name string, crlInfo *CertificateRevocationListInfo) error {
+	crlInfoBytes, err := json.Marshal(crlInfo)
+	if err != nil {
+		return errors.Wrap(err, "json Marshal error")
+	}
+
+	if err := db.Set(crlTable, crlNameKey(name), crlInfoBytes); err != nil {
+		return errors.Wrap(err, "database Set error")
+	}
+	return nil
+}
+
+// GetCRLByName gets the existing partitioned or delta CRL with the given name
+// from the database.
+func (db *DB) GetCRLByName(name string) (*CertificateRevocationListInfo, error) {
+	crlInfoBytes, err := db.Get(crlTable, crlNameKey(name))
+	if err != nil {
+		return nil, errors.Wrap(err, "database Get error")
+	}
+
+	var crlInfo CertificateRevocationListInfo
+	if err := json.Unmarshal(crlInfoBytes, &crlInfo); err != nil {
+		return nil, errors.Wrap(err, "json Unmarshal error")
+	}
+	return &crlInfo, nil
+}
+
+// crlNameKey returns the key used to store a named CRL. Named CRLs use a
+// prefix to avoid collisions with the key of the complete CRL.
+func crlNameKey(name string) []byte {
+	return []byte("crl-" + name)
+}
+
 // GetCertificate retrieves a certificate by the serial number.
 func (db *DB) GetCertificate(serialNumber string) (*x509.Certificate, error) {
 	asn1Data, err := db.Get(certsTable, []byte(serialNumber))
@@ -318,7 +389,10 @@ func (db *DB) GetCertificateData(serialNumber string) (*CertificateData, error)
 
 // StoreCertificate stores a certificate PEM.
 func (db *DB) StoreCertificate(crt *x509.Certificate) error {
-	if err := db.Set(certsTable, []byte(crt.SerialNumber.String()), crt.Raw); err != nil {
+	tx := new(database.Tx)
+	tx.Set(certsTable, []byte(crt.SerialNumber.String()), crt.Raw)
+	indexCertificate(tx, newX509CertificateEntry(crt, nil))
+	if err := db.Update(tx); err != nil {
 		return errors.Wrap(err, "database Set error")
 	}
 	return nil
@@ -343,11 +417,9 @@ type raProvisioner interface {
 	RAInfo() *provisioner.RAInfo
 }
 
-// StoreCertificateChain stores the leaf certificate and the provisioner that
-// authorized the certificate.
-func (db *DB) StoreCertificateChain(p provisioner.Interface, chain ...*x509.Certificate) error {
-	leaf := chain[0]
-	serialNumber := []byte(leaf.SerialNumber.String())
+// newCertificateData returns the data stored for a certificate authorized by
+// the given provisioner.
+func newCertificateData(p provisioner.Interface) *CertificateData {
 	data := &CertificateData{}
 	if p != nil {
 		data.Provisioner = &ProvisionerData{
@@ -359,6 +431,15 @@ func (db *DB) StoreCertificateChain(p provisioner.Interface, chain ...*x509.Cert
 			data.RaInfo = rap.RAInfo()
 		}
 	}
+	return data
+}
+
+// StoreCertificateChain stores the leaf certificate and the provisioner that
+// authorized the certificate.
+func (db *DB) StoreCertificateChain(p provisioner.Interface, chain ...*x509.Certificate) error {
+	leaf := chain[0]
+	serialNumber := []byte(leaf.SerialNumber.String())
+	data := newCertificateData(p)
 	b, err := json.Marshal(data)
 	if err != nil {
 		return errors.Wrap(err, "error marshaling json")
@@ -367,6 +448,7 @@ func (db *DB) StoreCertificateChain(p provisioner.Interface, chain ...*x509.Cert
 	tx := new(database.Tx)
 	tx.Set(certsTable, serialNumber, leaf.Raw)
 	tx.Set(certsDataTable, serialNumber, b)
+	indexCertificate(tx, newX509CertificateEntry(leaf, data))
 	if err := db.Update(tx); err != nil {
 		return errors.Wrap(err, "database Update error")
 	}
@@ -377,7 +459,8 @@ func (db *DB) StoreCertificateChain(p provisioner.Interface, chain ...*x509.Cert
 // authorized the old certificate if available.
 func (db *DB) StoreRenewedCertificate(oldCert *x509.Certificate, chain ...*x509.Certificate) error {
 	var certificateData []byte
-	if data, err := db.GetCertificateData(oldCert.SerialNumber.String()); err == nil {
+	data, err := db.GetCertificateData(oldCert.SerialNumber.String())
+	if err == nil {
 		if b, err := json.Marshal(data); err == nil {
 			certificateData = b
 		}
@@ -392,6 +475,7 @@ func (db *DB) StoreRenewedCertificate(oldCert *x509.Certificate, chain ...*x509.
 	if certificateData != nil {
 		tx.Set(certsDataTable, serialNumber, certificateData)
 	}
+	indexCertificate(tx, newX509CertificateEntry(leaf, data))
 	if err := db.Update(tx); err != nil {
 		return errors.Wrap(err, "database Update error")
 	}
@@ -427,9 +511,32 @@ type sshHostPrincipalData struct {
 
 // StoreSSHCertificate stores an SSH certificate.
 func (db *DB) StoreSSHCertificate(crt *ssh.Certificate) error {
+	return db.storeSSHCertificate(nil, crt)
+}
+
+// StoreSSHCertificateWithProvisioner stores an SSH certificate and the
+// provisioner that authorized the certificate.
+func (db *DB) StoreSSHCertificateWithProvisioner(p provisioner.Interface, crt *ssh.Certificate) error {
+	return db.storeSSHCertificate(newCertificateData(p), crt)
+}
+
+// StoreRenewedSSHCertificate stores a renewed or rekeyed SSH certificate and
+// the provisioner that authorized the original certificate.
+func (db *DB) StoreRenewedSSHCertificate(p provisioner.Interface, _, crt *ssh.Certificate) error {
+	return db.storeSSHCertificate(newCertificateData(p), crt)
+}
+
+func (db *DB) storeSSHCertificate(data *CertificateData, crt *ssh.Certificate) error {
 	serial := strconv.FormatUint(crt.Serial, 10)
 	tx := new(database.Tx)
 	tx.Set(sshCertsTable, []byte(serial), crt.Marshal())
+	if data != nil {
+		b, err := json.Marshal(data)
+		if err != nil {
+			return errors.Wrap(err, "error marshaling json")
+		}
+		tx.Set(sshCertsDataTable, []byte(serial), b)
+	}
 	if crt.CertType == ssh.HostCert {
 		for _, p := range crt.ValidPrincipals {
 			hostPrincipalData, err := json.Marshal(sshHostPrincipalData{
@@ -447,6 +554,7 @@ func (db *DB) StoreSSHCertificate(crt *ssh.Certificate) error {
 			tx.Set(sshUsersTable, []byte(strings.ToLower(p)), []byte(serial))
 		}
 	}
+	indexCertificate(tx, newSSHCertificateEntry(crt, data))
 	if err := db.Update(tx); err != nil {
 		return errors.Wrap(err, "database Update error")
 	}
@@ -500,8 +608,137 @@ type MockAuthDB struct {
 	MGetSSHHostPrincipals   func() ([]string, error)
 	MShutdown               func() error
 	MGetRevokedCertificates func() (*[]RevokedCertificateInfo, error)
+	MGetRevokedCertificate  func(serialNumber string) (*RevokedCertificateInfo, error)
 	MGetCRL                 func() (*CertificateRevocationListInfo, error)
 	MStoreCRL               func(*CertificateRevocationListInfo) error
+	MGetCRLByName           func(name string) (*CertificateRevocationListInfo, error)
+	MStoreCRLByName         func(name string, info *CertificateRevocationListInfo) error
+	MSearchCertificates     func(filter *CertificateFilter, cursor string, limit int) ([]*CertificateEntry, string, error)
+	MCreateSCEPRequest      func(req *SCEPRequest) error
+	MGetSCEPRequest         func(provisionerID, transactionID string) (*SCEPRequest, error)
+	MGetSCEPRequests        func(provisionerID string) ([]*SCEPRequest, error)
+	MUpdateSCEPRequest      func(old, req *SCEPRequest) error
+	MCreateSCEPChallenge    func(ch *SCEPChallenge) error
+	MGetSCEPChallenge       func(provisionerID, id string) (*SCEPChallenge, error)
+	MGetSCEPChallenges      func(provisionerID string) ([]*SCEPChallenge, error)
+	MDeleteSCEPChallenge    func(provisionerID, id string) error
+	MUseSCEPChallenge       func(provisionerID, id string) (*SCEPChallenge, error)
+	MCreateCMPConfirmation  func(c *CMPConfirmation) error
+	MUseCMPConfirmation     func(provisionerID, transactionID string) (*CMPConfirmation, error)
+}
+
+// CreateCMPConfirmation mock.
+func (m *MockAuthDB) CreateCMPConfirmation(c *CMPConfirmation) error {
+	if m.MCreateCMPConfirmation != nil {
+		return m.MCreateCMPConfirmation(c)
+	}
+	return m.Err
+}
+
+// UseCMPConfirmation mock.
+func (m *MockAuthDB) UseCMPConfirmation(provisionerID, transactionID string) (*CMPConfirmation, error) {
+	if m.MUseCMPConfirmation != nil {
+		return m.MUseCMPConfirmation(provisionerID, transactionID)
+	}
+	if c, ok := m.Ret1.(*CMPConfirmation); ok {
+		return c, m.Err
+	}
+	return nil, m.Err
+}
+
+// CreateSCEPRequest mock.
+func (m *MockAuthDB) CreateSCEPRequest(req *SCEPRequest) error {
+	if m.MCreateSCEPRequest != nil {
+		return m.MCreateSCEPRequest(req)
+	}
+	return m.Err
+}
+
+// GetSCEPRequest mock.
+func (m *MockAuthDB) GetSCEPRequest(provisionerID, transactionID string) (*SCEPRequest, error) {
+	if m.MGetSCEPRequest != nil {
+		return m.MGetSCEPRequest(provisionerID, transactionID)
+	}
+	if req, ok := m.Ret1.(*SCEPRequest); ok {
+		return req, m.Err
+	}
+	return nil, m.Err
+}
+
+// GetSCEPRequests mock.
+func (m *MockAuthDB) GetSCEPRequests(provisionerID string) ([]*SCEPRequest, error) {
+	if m.MGetSCEPRequests != nil {
+		return m.MGetSCEPRequests(provisionerID)
+	}
+	if reqs, ok := m.Ret1.([]*SCEPRequest); ok {
+		return reqs, m.Err
+	}
+	return nil, m.Err
+}
+
+// UpdateSCEPRequest mock.
+func (m *MockAuthDB) UpdateSCEPRequest(old, req *SCEPRequest) error {
+	if m.MUpdateSCEPRequest != nil {
+		return m.MUpdateSCEPRequest(old, req)
+	}
+	return m.Err
+}
+
+// CreateSCEPChallenge mock.
+func (m *MockAuthDB) CreateSCEPChallenge(ch *SCEPChallenge) error {
+	if m.MCreateSCEPChallenge != nil {
+		return m.MCreateSCEPChallenge(ch)
+	}
+	return m.Err
+}
+
+// GetSCEPChallenge mock.
+func (m *MockAuthDB) GetSCEPChallenge(provisionerID, id string) (*SCEPChallenge, error) {
+	if m.MGetSCEPChallenge != nil {
+		return m.MGetSCEPChallenge(provisionerID, id)
+	}
+	if ch, ok := m.Ret1.(*SCEPChallenge); ok {
+		return ch, m.Err
+	}
+	return nil, m.Err
+}
+
+// GetSCEPChallenges mock.
+func (m *MockAuthDB) GetSCEPChallenges(provisionerID string) ([]*SCEPChallenge, error) {
+	if m.MGetSCEPChallenges != nil {
+		return m.MGetSCEPChallenges(provisionerID)
+	}
+	if chs, ok := m.Ret1.([]*SCEPChallenge); ok {
+		return chs, m.Err
+	}
+	return nil, m.Err
+}
+
+// DeleteSCEPChallenge mock.
+func (m *MockAuthDB) DeleteSCEPChallenge(provisionerID, id string) error {
+	if m.MDeleteSCEPChallenge != nil {
+		return m.MDeleteSCEPChallenge(provisionerID, id)
+	}
+	return m.Err
+}
+
+// UseSCEPChallenge mock.
+func (m *MockAuthDB) UseSCEPChallenge(provisionerID, id string) (*SCEPChallenge, error) {
+	if m.MUseSCEPChallenge != nil {
+		return m.MUseSCEPChallenge(provisionerID, id)
+	}
+	if ch, ok := m.Ret1.(*SCEPChallenge); ok {
+		return ch, m.Err
+	}
+	return nil, m.Err
+}
+
+// SearchCertificates mock.
+func (m *MockAuthDB) SearchCertificates(filter *CertificateFilter, cursor string, limit int) ([]*CertificateEntry, string, error) {
+	if m.MSearchCertificates != nil {
+		return m.MSearchCertificates(filter, cursor, limit)
+	}
+	return m.Ret1.([]*CertificateEntry), "", m.Err
 }
 
 func (m *MockAuthDB) GetRevokedCertificates() (*[]RevokedCertificateInfo, error) {
@@ -511,6 +748,36 @@ func (m *MockAuthDB) GetRevokedCertificates() (*[]RevokedCertificateInfo, error)
 	return m.Ret1.(*[]RevokedCertificateInfo), m.Err
 }
 
+// GetCRLByName mock.
+func (m *MockAuthDB) GetCRLByName(name string) (*CertificateRevocationListInfo, error) {
+	if m.MGetCRLByName != nil {
+		return m.MGetCRLByName(name)
+	}
+	if crl, ok := m.Ret1.(*CertificateRevocationListInfo); ok {
+		return crl, m.Err
+	}
+	return nil, m.Err
+}
+
+// StoreCRLByName mock.
+func (m *MockAuthDB) StoreCRLByName(name string, info *CertificateRevocationListInfo) error {
+	if m.MStoreCRLByName != nil {
+		return m.MStoreCRLByName(name, info)
+	}
+	return m.Err
+}
+
+// GetRevokedCertificate mock.
+func (m *MockAuthDB) GetRevokedCertificate(serialNumber string) (*RevokedCertificateInfo, error) {
+	if m.MGetRevokedCertificate != nil {
+		return m.MGetRevokedCertificate(serialNumber)
+	}
+	if rci, ok := m.Ret1.(*RevokedCertificateInfo); ok {
+		return rci, m.Err
+	}
+	return nil, m.Err
+}
+
 func (m *MockAuthDB) GetCRL() (*CertificateRevocationListInfo, error) {
 	if m.MGetCRL != nil {
 		return m.MGetCRL()
@@ -3,10 +3,12 @@ package db
 import (
 	"bytes"
 	"crypto/x509"
+	"encoding/json"
 	"errors"
 	"math/big"
 	"reflect"
 	"testing"
+	"time"
 
 	"github.com/smallstep/assert"
 	"github.com/smallstep/certificates/authority/provisioner"
@@ -258,7 +260,7 @@ func TestDB_StoreCertificateChain(t *testing.T) {
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			d := &DB{
-				DB:   tt.fields.DB,
+				DB:   certTablesDB{tt.fields.DB},
 				isUp: tt.fields.isUp,
 			}
 			if err := d.StoreCertificateChain(tt.args.p, tt.args.chain...); (err != nil) != tt.wantErr {
@@ -328,6 +330,142 @@ func TestDB_GetCertificateData(t *testing.T) {
 	}
 }
 
+func TestDB_GetRevokedCertificate(t *testing.T) {
+	revokedAt := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	type fields struct {
+		DB   nosql.DB
+		isUp bool
+	}
+	type args struct {
+		serialNumber string
+	}
+	tests := []struct {
+		name    string
+		fields  fields
+		args    args
+		want    *RevokedCertificateInfo
+		wantErr bool
+	}{
+		{"ok", fields{&MockNoSQLDB{
+			MGet: func(bucket, key []byte) ([]byte, error) {
+				assert.Equals(t, bucket, []byte("revoked_x509_certs"))
+				assert.Equals(t, key, []byte("1234"))
+				return []byte(`{"Serial":"1234","ReasonCode":1,"Reason":"key compromise","RevokedAt":"2024-01-02T03:04:05Z"}`), nil
+			},
+		}, true}, args{"1234"}, &RevokedCertificateInfo{
+			Serial:     "1234",
+			ReasonCode: 1,
+			Reason:     "key compromise",
+			RevokedAt:  revokedAt,
+		}, false},
+		{"fail not found", fields{&MockNoSQLDB{
+			MGet: func(bucket, key []byte) ([]byte, error) {
+				return nil, database.ErrNotFound
+			},
+		}, true}, args{"1234"}, nil, true},
+		{"fail unmarshal", fields{&MockNoSQLDB{
+			MGet: func(bucket, key []byte) ([]byte, error) {
+				return []byte(`{"bad-json"}`), nil
+			},
+		}, true}, args{"1234"}, nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := &DB{
+				DB:   tt.fields.DB,
+				isUp: tt.fields.isUp,
+			}
+			got, err := db.GetRevokedCertificate(tt.args.serialNumber)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("DB.GetRevokedCertificate() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("DB.GetRevokedCertificate() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDB_GetCRLByName(t *testing.T) {
+	expiresAt := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	tests := []struct {
+		name    string
+		db      nosql.DB
+		want    *CertificateRevocationListInfo
+		wantErr bool
+	}{
+		{"ok", &MockNoSQLDB{
+			MGet: func(bucket, key []byte) ([]byte, error) {
+				assert.Equals(t, bucket, crlTable)
+				assert.Equals(t, key, []byte("crl-1-delta"))
+				return []byte(`{"Number":2,"ExpiresAt":"2024-01-02T03:04:05Z","Duration":3600000000000,"DER":"AQID"}`), nil
+			},
+		}, &CertificateRevocationListInfo{
+			Number:    2,
+			ExpiresAt: expiresAt,
+			Duration:  time.Hour,
+			DER:       []byte{1, 2, 3},
+		}, false},
+		{"fail not found", &MockNoSQLDB{
+			MGet: func(bucket, key []byte) ([]byte, error) {
+				return nil, database.ErrNotFound
+			},
+		}, nil, true},
+		{"fail unmarshal", &MockNoSQLDB{
+			MGet: func(bucket, key []byte) ([]byte, error) {
+				return []byte(`{"bad-json"}`), nil
+			},
+		}, nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := &DB{DB: tt.db, isUp: true}
+			got, err := db.GetCRLByName("1-delta")
+			if (err != nil) != tt.wantErr {
+				t.Errorf("DB.GetCRLByName() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("DB.GetCRLByName() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDB_StoreCRLByName(t *testing.T) {
+	crlInfo := &CertificateRevocationListInfo{Number: 1, DER: []byte{1, 2, 3}}
+	tests := []struct {
+		name    string
+		db      nosql.DB
+		wantErr bool
+	}{
+		{"ok", &MockNoSQLDB{
+			MSet: func(bucket, key, value []byte) error {
+				assert.Equals(t, bucket, crlTable)
+				assert.Equals(t, key, []byte("crl-0"))
+				var got CertificateRevocationListInfo
+				assert.FatalError(t, json.Unmarshal(value, &got))
+				assert.Equals(t, &got, crlInfo)
+				return nil
+			},
+		}, false},
+		{"fail", &MockNoSQLDB{
+			MSet: func(bucket, key, value []byte) error {
+				return errors.New("force")
+			},
+		}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := &DB{DB: tt.db, isUp: true}
+			if err := db.StoreCRLByName("0", crlInfo); (err != nil) != tt.wantErr {
+				t.Errorf("DB.StoreCRLByName() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
 func TestDB_StoreRenewedCertificate(t *testing.T) {
 	oldCert := &x509.Certificate{SerialNumber: big.NewInt(1)}
 	chain := []*x509.Certificate{
@@ -426,7 +564,7 @@ func TestDB_StoreRenewedCertificate(t *testing.T) {
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			db := &DB{
-				DB:   tt.fields.DB,
+				DB:   certTablesDB{tt.fields.DB},
 				isUp: tt.fields.isUp,
 			}
 			if err := db.StoreRenewedCertificate(tt.args.oldCert, tt.args.chain...); (err != nil) != tt.wantErr {
@@ -435,3 +573,19 @@ func TestDB_StoreRenewedCertificate(t *testing.T) {
 		})
 	}
 }
+
+// certTablesDB removes the certificate index entries from the transactions,
+// so the tests only see the operations on the certificate tables.
+type certTablesDB struct {
+	nosql.DB
+}
+
+func (d certTablesDB) Update(tx *database.Tx) error {
+	ops := make([]*database.TxEntry, 0, len(tx.Operations))
+	for _, op := range tx.Operations {
+		if !bytes.HasPrefix(op.Bucket, []byte("cert_index_")) {
+			ops = append(ops, op)
+		}
+	}
+	return d.DB.Update(&database.Tx{Operations: ops})
+}
@@ -0,0 +1,592 @@
+package db
+
+import (
+	"crypto/x509"
+	"encoding/json"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/smallstep/nosql/database"
+	"golang.org/x/crypto/ssh"
+)
+
+var (
+	sshCertsDataTable = []byte("ssh_certs_data")
+
+	// The certificate index tables store one entry per certificate and indexed
+	// value. The key is the escaped value followed by the certificate
+	// identifier, and the value is empty.
+	certIndexIDTable          = []byte("cert_index_id")
+	certIndexProvisionerTable = []byte("cert_index_provisioner")
+	certIndexSANTable         = []byte("cert_index_san")
+	certIndexSubjectTable     = []byte("cert_index_subject")
+	certIndexExpiryTable      = []byte("cert_index_expiry")
+)
+
+// certIndexVersionKey is the key in the cert_index_id table used to mark that
+// the certificates stored before the indexes existed have been indexed.
+var certIndexVersionKey = []byte("_version")
+
+// certIndexBatchSize is the number of certificates indexed in each
+// transaction by migrateCertificateIndexes.
+const certIndexBatchSize = 100
+
+const (
+	// DefaultCertificatesLimit is the default limit for searching certificates.
+	DefaultCertificatesLimit = 20
+	// DefaultCertificatesMax is the maximum limit for searching certificates.
+	DefaultCertificatesMax = 100
+)
+
+const (
+	// X509CertificateType is the type used for X.509 certificates in the
+	// certificate inventory.
+	X509CertificateType = "x509"
+	// SSHCertificateType is the type used for SSH certificates in the
+	// certificate inventory.
+	SSHCertificateType = "ssh"
+)
+
+// CertificateInventoryDB is an extension of AuthDB that allows to search the
+// X.509 and SSH certificates issued by the authority.
+type CertificateInventoryDB interface {
+	SearchCertificates(filter *CertificateFilter, cursor string, limit int) ([]*CertificateEntry, string, error)
+}
+
+// CertificateFilter contains the conditions a certificate must satisfy to be
+// returned by SearchCertificates. Empty fields are ignored.
+type CertificateFilter struct {
+	// Type is the type of certificate, x509 or ssh.
+	Type string
+	// ProvisionerID is the id of the provisioner that authorized the
+	// certificate.
+	ProvisionerID string
+	// SAN is a DNS name, IP address, email address or URI in an X.509
+	// certificate, or a principal in an SSH certificate. A wildcard name like
+	// *.example.com also matches the names one level below example.com.
+	SAN string
+	// Subject is the common name in an X.509 certificate or the key id in an
+	// SSH certificate.
+	Subject string
+	// ExpiresAfter and ExpiresBefore define the window in which the
+	// certificate expires.
+	ExpiresAfter  time.Time
+	ExpiresBefore time.Time
+	// Revoked filters by the revocation status of the certificate.
+	Revoked *bool
+}
+
+// CertificateEntry is the representation of a certificate in the certificate
+// inventory.
+type CertificateEntry struct {
+	ID           string           `json:"id"`
+	Type         string           `json:"type"`
+	SerialNumber string           `json:"serialNumber"`
+	Subject      string           `json:"subject"`
+	SANs         []string         `json:"sans,omitempty"`
+	NotBefore    time.Time        `json:"notBefore"`
+	NotAfter     time.Time        `json:"notAfter"`
+	Provisioner  *ProvisionerData `json:"provisioner,omitempty"`
+	Revoked      bool             `json:"revoked"`
+	Certificate  []byte           `json:"certificate"`
+}
+
+func newX509CertificateEntry(crt *x509.Certificate, data *CertificateData) *CertificateEntry {
+	sans := make([]string, 0, len(crt.DNSNames)+len(crt.IPAddresses)+len(crt.EmailAddresses)+len(crt.URIs))
+	sans = append(sans, crt.DNSNames...)
+	for _, ip := range crt.IPAddresses {
+		sans = append(sans, ip.String())
+	}
+	sans = append(sans, crt.EmailAddresses...)
+	for _, u := range crt.URIs {
+		sans = append(sans, u.String())
+	}
+	e := &CertificateEntry{
+		ID:           certificateID(X509CertificateType, crt.SerialNumber.String()),
+		Type:         X509CertificateType,
+		SerialNumber: crt.SerialNumber.String(),
+		Subject:      crt.Subject.CommonName,
+		SANs:         sans,
+		NotBefore:    crt.NotBefore.UTC(),
+		NotAfter:     crt.NotAfter.UTC(),
+		Certificate:  crt.Raw,
+	}
+	if data != nil {
+		e.Provisioner = data.Provisioner
+	}
+	return e
+}
+
+func newSSHCertificateEntry(crt *ssh.Certificate, data *CertificateData) *CertificateEntry {
+	serial := strconv.FormatUint(crt.Serial, 10)
+	e := &CertificateEntry{
+		ID:           certificateID(SSHCertificateType, serial),
+		Type:         SSHCertificateType,
+		SerialNumber: serial,
+		Subject:      crt.KeyId,
+		SANs:         crt.ValidPrincipals,
+		NotBefore:    sshTime(crt.ValidAfter),
+		NotAfter:     sshTime(crt.ValidBefore),
+		Certificate:  crt.Marshal(),
+	}
+	if data != nil {
+		e.Provisioner = data.Provisioner
+	}
+	return e
+}
+
+// maxSSHTime is the time used for SSH certificates without expiration, it is
+// the maximum time that can be encoded in JSON.
+var maxSSHTime = time.Date(9999, 12, 31, 23, 59, 59, 0, time.UTC)
+
+// sshTime converts the validity of an SSH certificate to a time.Time.
+func sshTime(t uint64) time.Time {
+	if t >= uint64(maxSSHTime.Unix()) {
+		return maxSSHTime
+	}
+	return time.Unix(int64(t), 0).UTC()
+}
+
+// certificateID returns the identifier of a certificate in the inventory.
+func certificateID(typ, serial string) string {
+	return typ + "/" + serial
+}
+
+// parseCertificateID returns the type and serial number of the given
+// certificate identifier.
+func parseCertificateID(id string) (typ, serial string, ok bool) {
+	typ, serial, ok = strings.Cut(id, "/")
+	if !ok || serial == "" {
+		return "", "", false
+	}
+	switch typ {
+	case X509CertificateType, SSHCertificateType:
+		return typ, serial, true
+	default:
+		return "", "", false
+	}
+}
+
+// certIndexKey is an entry in one of the certificate index tables.
+type certIndexKey struct {
+	table []byte
+	key   []byte
+}
+
+// indexPrefix returns the prefix of the index keys for the given value. The
+// value is escaped, so it cannot contain the separator.
+func indexPrefix(value string) string {
+	return url.PathEscape(value) + "/"
+}
+
+func newCertIndexKey(table []byte, value, id string) certIndexKey {
+	return certIndexKey{table: table, key: []byte(indexPrefix(value) + id)}
+}
+
+func expiryIndexValue(t time.Time) string { return t.UTC().Format(time.DateOnly) }
+
+// wildcardName returns the wildcard name that covers the given DNS name, or
+// an empty string if the name does not have a parent domain.
+func wildcardName(name string) string {
+	if strings.HasPrefix(name, "*.") {
+		return ""
+	}
+	if _, parent, ok := strings.Cut(name, "."); ok && strings.Contains(parent, ".") {
+		return "*." + parent
+	}
+	return ""
+}
+
+// indexKeys returns the index keys of a certificate entry.
+func (e *CertificateEntry) indexKeys() []certIndexKey {
+	keys := []certIndexKey{
+		{table: certIndexIDTable, key: []byte(e.ID)},
+		newCertIndexKey(certIndexExpiryTable, expiryIndexValue(e.NotAfter), e.ID),
+	}
+	if e.Provisioner != nil && e.Provisioner.ID != "" {
+		keys = append(keys, newCertIndexKey(certIndexProvisionerTable, e.Provisioner.ID, e.ID))
+	}
+	if e.Subject != "" {
+		keys = append(keys, newCertIndexKey(certIndexSubjectTable, strings.ToLower(e.Subject), e.ID))
+	}
+	for _, san := range e.SANs {
+		keys = append(keys, newCertIndexKey(certIndexSANTable, strings.ToLower(san), e.ID))
+		if w := wildcardName(san); w != "" {
+			keys = append(keys, newCertIndexKey(certIndexSANTable, strings.ToLower(w), e.ID))
+		}
+	}
+	return keys
+}
+
+// matches returns true if the entry satisfies the filter.
+func (f *CertificateFilter) matches(e *CertificateEntry) bool {
+	if f.Type != "" && f.Type != e.Type {
+		return false
+	}
+	if f.ProvisionerID != "" && (e.Provisioner == nil || e.Provisioner.ID != f.ProvisionerID) {
+		return false
+	}
+	if f.Subject != "" && !strings.EqualFold(f.Subject, e.Subject) {
+		return false
+	}
+	if f.SAN != "" && !matchesSAN(f.SAN, e.SANs) {
+		return false
+	}
+	if !f.ExpiresAfter.IsZero() && e.NotAfter.Before(f.ExpiresAfter) {
+		return false
+	}
+	if !f.ExpiresBefore.IsZero() && !e.NotAfter.Before(f.ExpiresBefore) {
+		return false
+	}
+	if f.Revoked != nil && *f.Revoked != e.Revoked {
+		return false
+	}
+	return true
+}
+
+func matchesSAN(name string, sans []string) bool {
+	for _, san := range sans {
+		if strings.EqualFold(name, san) {
+			return true
+		}
+		if strings.HasPrefix(name, "*.") && strings.EqualFold(name, wildcardName(san)) {
+			return true
+		}
+	}
+	return false
+}
+
+// indexCertificate adds the index entries of the certificate to the given
+// transaction, so they are stored with the certificate.
+func indexCertificate(tx *database.Tx, e *CertificateEntry) {
+	for _, k := range e.indexKeys() {
+		tx.Set(k.table, k.key, []byte{})
+	}
+}
+
+// listIndex returns the certificate identifiers stored in the given index
+// table with the given value. The nosql databases cannot iterate over a key
+// prefix, so the index table is listed, but its entries only contain keys.
+func (db *DB) listIndex(table []byte, value string) ([]string, error) {
+	return db.listIndexFunc(table, func(v string) bool {
+		return v == url.PathEscape(value)
+	})
+}
+
+// listIndexFunc returns the certificate identifiers stored in the given index
+// table with an escaped value that satisfies fn.
+func (db *DB) listIndexFunc(table []byte, fn func(value string) bool) ([]string, error) {
+	entries, err := db.List(table)
+	if err != nil {
+		if database.IsErrNotFound(err) {
+			return nil, nil
+		}
+		return nil, errors.Wrapf(err, "error listing %s", table)
+	}
+	var ids []string
+	for _, e := range entries {
+		value, id, ok := strings.Cut(string(e.Key), "/")
+		if ok && fn(value) {
+			ids = append(ids, id)
+		}
+	}
+	return ids, nil
+}
+
+// migrateCertificateIndexes indexes the certificates stored before the
+// certificate indexes were introduced. It only runs once.
+func (db *DB) migrateCertificateIndexes() error {
+	if _, err := db.Get(certIndexIDTable, certIndexVersionKey); err == nil {
+		return nil
+	} else if !database.IsErrNotFound(err) {
+		return errors.Wrap(err, "error loading certificate index version")
+	}
+
+	var n int
+	tx := new(database.Tx)
+	flush := func(force bool) error {
+		if n == 0 || (!force && n < certIndexBatchSize) {
+			return nil
+		}
+		if err := db.Update(tx); err != nil {
+			return errors.Wrap(err, "error saving certificate indexes")
+		}
+		n, tx = 0, new(database.Tx)
+		return nil
+	}
+
+	entries, err := db.List(certsTable)
+	if err != nil && !database.IsErrNotFound(err) {
+		return errors.Wrap(err, "error listing certificates")
+	}
+	for _, e := range entries {
+		crt, err := x509.ParseCertificate(e.Value)
+		if err != nil {
+			continue
+		}
+		data, _ := db.GetCertificateData(string(e.Key))
+		indexCertificate(tx, newX509CertificateEntry(crt, data))
+		n++
+		if err := flush(false); err != nil {
+			return err
+		}
+	}
+
+	entries, err = db.List(sshCertsTable)
+	if err != nil && !database.IsErrNotFound(err) {
+		return errors.Wrap(err, "error listing ssh certificates")
+	}
+	for _, e := range entries {
+		crt, err := parseSSHCertificate(e.Value)
+		if err != nil {
+			continue
+		}
+		data, _ := db.getSSHCertificateData(string(e.Key))
+		indexCertificate(tx, newSSHCertificateEntry(crt, data))
+		n++
+		if err := flush(false); err != nil {
+			return err
+		}
+	}
+	if err := flush(true); err != nil {
+		return err
+	}
+
+	if err := db.Set(certIndexIDTable, certIndexVersionKey, []byte("1")); err != nil {
+		return errors.Wrap(err, "error saving certificate index version")
+	}
+	return nil
+}
+
+func parseSSHCertificate(b []byte) (*ssh.Certificate, error) {
+	pub, err := ssh.ParsePublicKey(b)
+	if err != nil {
+		return nil, errors.Wrap(err, "error parsing ssh certificate")
+	}
+	crt, ok := pub.(*ssh.Certificate)
+	if !ok {
+		return nil, errors.Errorf("error parsing ssh certificate: unexpected type %T", pub)
+	}
+	return crt, nil
+}
+
+// SearchCertificates returns the X.509 and SSH certificates that satisfy the
+// given filter, sorted by their identifier. The cursor is the identifier of
+// the last certificate in the previous page, and the returned cursor is empty
+// when there are no more pages.
+func (db *DB) SearchCertificates(filter *CertificateFilter, cursor string, limit int) ([]*CertificateEntry, string, error) {
+	if filter == nil {
+		filter = &CertificateFilter{}
+	}
+	switch {
+	case limit <= 0:
+		limit = DefaultCertificatesLimit
+	case limit > DefaultCertificatesMax:
+		limit = DefaultCertificatesMax
+	}
+
+	ids, err := db.searchCandidates(filter)
+	if err != nil {
+		return nil, "", err
+	}
+	sort.Strings(ids)
+
+	i := sort.SearchStrings(ids, cursor)
+	if i < len(ids) && ids[i] == cursor {
+		i++
+	}
+
+	entries := []*CertificateEntry{}
+	for ; i < len(ids) && len(entries) < limit; i++ {
+		e, err := db.getCertificateEntry(ids[i])
+		if err != nil {
+			if database.IsErrNotFound(err) {
+				continue
+			}
+			return nil, "", err
+		}
+		if filter.matches(e) {
+			entries = append(entries, e)
+		}
+	}
+
+	var nextCursor string
+	if i < len(ids) && len(entries) > 0 {
+		nextCursor = entries[len(entries)-1].ID
+	}
+	return entries, nextCursor, nil
+}
+
+// searchCandidates returns the identifiers of the certificates that might
+// satisfy the filter, using the indexes when possible.
+func (db *DB) searchCandidates(filter *CertificateFilter) ([]string, error) {
+	type index struct {
+		table []byte
+		value string
+	}
+	var indexes []index
+	if filter.ProvisionerID != "" {
+		indexes = append(indexes, index{certIndexProvisionerTable, filter.ProvisionerID})
+	}
+	if filter.SAN != "" {
+		indexes = append(indexes, index{certIndexSANTable, strings.ToLower(filter.SAN)})
+	}
+	if filter.Subject != "" {
+		indexes = append(indexes, index{certIndexSubjectTable, strings.ToLower(filter.Subject)})
+	}
+
+	var ids []string
+	switch {
+	case len(indexes) > 0:
+		// Intersect all the indexes
+		for i, idx := range indexes {
+			v, err := db.listIndex(idx.table, idx.value)
+			if err != nil {
+				return nil, err
+			}
+			if i == 0 {
+				ids = v
+			} else {
+				ids = intersect(ids, v)
+			}
+		}
+	case !filter.ExpiresAfter.IsZero() || !filter.ExpiresBefore.IsZero():
+		// Dates in the index are in the YYYY-MM-DD format, so they can be
+		// compared as strings.
+		var after, before string
+		if !filter.ExpiresAfter.IsZero() {
+			after = expiryIndexValue(filter.ExpiresAfter)
+		}
+		if !filter.ExpiresBefore.IsZero() {
+			before = expiryIndexValue(filter.ExpiresBefore)
+		}
+		var err error
+		if ids, err = db.listIndexFunc(certIndexExpiryTable, func(v string) bool {
+			return v >= after && (before == "" || v <= before)
+		}); err != nil {
+			return nil, err
+		}
+	case filter.Revoked != nil && *filter.Revoked:
+		var err error
+		if ids, err = db.listCertificateIDs(revokedCertsTable, X509CertificateType, filter.Type); err != nil {
+			return nil, err
+		}
+		sshIDs, err := db.listCertificateIDs(revokedSSHCertsTable, SSHCertificateType, filter.Type)
+		if err != nil {
+			return nil, err
+		}
+		ids = append(ids, sshIDs...)
+	default:
+		// The cert_index_id table contains only keys, so it is listed instead
+		// of the certificate tables.
+		entries, err := db.List(certIndexIDTable)
+		if err != nil && !database.IsErrNotFound(err) {
+			return nil, errors.Wrapf(err, "error listing %s", certIndexIDTable)
+		}
+		for _, e := range entries {
+			if typ, _, ok := parseCertificateID(string(e.Key)); ok && (filter.Type == "" || filter.Type == typ) {
+				ids = append(ids, string(e.Key))
+			}
+		}
+	}
+
+	return ids, nil
+}
+
+// listCertificateIDs returns the identifiers for all the serial numbers in the
+// given table.
+func (db *DB) listCertificateIDs(table []byte, typ, filterType string) ([]string, error) {
+	if filterType != "" && filterType != typ {
+		return nil, nil
+	}
+	entries, err := db.List(table)
+	if err != nil {
+		if database.IsErrNotFound(err) {
+			return nil, nil
+		}
+		return nil, errors.Wrapf(err, "error listing %s", table)
+	}
+	ids := make([]string, len(entries))
+	for i, e := range entries {
+		ids[i] = certificateID(typ, string(e.Key))
+	}
+	return ids, nil
+}
+
+// getCertificateEntry returns the certificate entry with the given identifier.
+func (db *DB) getCertificateEntry(id string) (*CertificateEntry, error) {
+	typ, serial, ok := parseCertificateID(id)
+	if !ok {
+		return nil, errors.Wrapf(database.ErrNotFound, "invalid certificate id %s", id)
+	}
+
+	var (
+		e       *CertificateEntry
+		revoked bool
+	)
+	switch typ {
+	case X509CertificateType:
+		crt, err := db.GetCertificate(serial)
+		if err != nil {
+			return nil, err
+		}
+		data, err := db.GetCertificateData(serial)
+		if err != nil && !database.IsErrNotFound(err) {
+			return nil, err
+		}
+		e = newX509CertificateEntry(crt, data)
+		if revoked, err = db.IsRevoked(serial); err != nil {
+			return nil, err
+		}
+	default:
+		b, err := db.Get(sshCertsTable, []byte(serial))
+		if err != nil {
+			return nil, errors.Wrap(err, "database Get error")
+		}
+		crt, err := parseSSHCertificate(b)
+		if err != nil {
+			return nil, err
+		}
+		data, err := db.getSSHCertificateData(serial)
+		if err != nil && !database.IsErrNotFound(err) {
+			return nil, err
+		}
+		e = newSSHCertificateEntry(crt, data)
+		if revoked, err = db.IsSSHRevoked(serial); err != nil {
+			return nil, err
+		}
+	}
+
+	e.Revoked = revoked
+	return e, nil
+}
+
+func (db *DB) getSSHCertificateData(serial string) (*CertificateData, error) {
+	b, err := db.Get(sshCertsDataTable, []byte(serial))
+	if err != nil {
+		return nil, errors.Wrap(err, "database Get error")
+	}
+	var data CertificateData
+	if err := json.Unmarshal(b, &data); err != nil {
+		return nil, errors.Wrap(err, "error unmarshaling json")
+	}
+	return &data, nil
+}
+
+func intersect(a, b []string) []string {
+	m := make(map[string]struct{}, len(b))
+	for _, v := range b {
+		m[v] = struct{}{}
+	}
+	var ret []string
+	for _, v := range a {
+		if _, ok := m[v]; ok {
+			ret = append(ret, v)
+		}
+	}
+	return ret
+}
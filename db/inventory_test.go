@@ -0,0 +1,193 @@
+package db
+
+import (
+	"crypto"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"net"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.step.sm/crypto/keyutil"
+	"go.step.sm/crypto/minica"
+	"golang.org/x/crypto/ssh"
+
+	"github.com/smallstep/certificates/authority/provisioner"
+	"github.com/smallstep/nosql"
+)
+
+func TestDB_SearchCertificates(t *testing.T) {
+	ca, err := minica.New()
+	require.NoError(t, err)
+	signer, err := keyutil.GenerateDefaultSigner()
+	require.NoError(t, err)
+	sshSigner, err := ssh.NewSignerFromSigner(signer)
+	require.NoError(t, err)
+
+	now := time.Now()
+	newCert := func(t *testing.T, sn int64, cn string, d time.Duration, ips ...net.IP) *x509.Certificate {
+		t.Helper()
+		crt, err := ca.Sign(&x509.Certificate{
+			SerialNumber: big.NewInt(sn),
+			Subject:      pkix.Name{CommonName: cn},
+			DNSNames:     []string{cn},
+			IPAddresses:  ips,
+			NotBefore:    now,
+			NotAfter:     now.Add(d),
+			PublicKey:    signer.Public(),
+		})
+		require.NoError(t, err)
+		return crt
+	}
+
+	// Certificates stored before the indexes are indexed in the background
+	// when the database is opened.
+	dir := t.TempDir()
+	legacy := newCert(t, 3, "baz.corp.example", 20*24*time.Hour)
+	ndb, err := nosql.New("badgerv2", dir)
+	require.NoError(t, err)
+	require.NoError(t, ndb.CreateTable(certsTable))
+	require.NoError(t, ndb.Set(certsTable, []byte("3"), legacy.Raw))
+	require.NoError(t, ndb.Close())
+
+	adb, err := New(&Config{Type: "badgerv2", DataSource: dir})
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = adb.Shutdown() })
+	d := adb.(*DB)
+	require.Eventually(t, func() bool {
+		_, err := d.Get(certIndexIDTable, certIndexVersionKey)
+		return err == nil
+	}, 5*time.Second, 10*time.Millisecond)
+
+	p1 := &provisioner.JWK{ID: "p1-id", Name: "p1", Type: "JWK"}
+	p2 := &provisioner.JWK{ID: "p2-id", Name: "p2", Type: "JWK"}
+	require.NoError(t, d.StoreCertificateChain(p1, newCert(t, 1, "foo.corp.example", 10*24*time.Hour)))
+	require.NoError(t, d.StoreCertificateChain(p2, newCert(t, 2, "bar.other.example", 100*24*time.Hour, net.IPv4(10, 0, 0, 1))))
+	require.NoError(t, d.Revoke(&RevokedCertificateInfo{Serial: "2"}))
+
+	sshCert, err := ca.SignSSH(&ssh.Certificate{
+		Serial:          4,
+		Key:             sshSigner.PublicKey(),
+		CertType:        ssh.HostCert,
+		KeyId:           "foo.corp.example",
+		ValidPrincipals: []string{"foo.corp.example"},
+	})
+	require.NoError(t, err)
+	require.NoError(t, d.StoreSSHCertificateWithProvisioner(p1, sshCert))
+
+	revoked, notRevoked := true, false
+	tests := []struct {
+		name   string
+		filter *CertificateFilter
+		want   []string
+	}{
+		{"all", nil, []string{"ssh/4", "x509/1", "x509/2", "x509/3"}},
+		{"type", &CertificateFilter{Type: SSHCertificateType}, []string{"ssh/4"}},
+		{"provisioner", &CertificateFilter{ProvisionerID: "p1-id"}, []string{"ssh/4", "x509/1"}},
+		{"provisioner and type", &CertificateFilter{ProvisionerID: "p1-id", Type: X509CertificateType}, []string{"x509/1"}},
+		{"san", &CertificateFilter{SAN: "10.0.0.1"}, []string{"x509/2"}},
+		{"san wildcard", &CertificateFilter{SAN: "*.corp.example"}, []string{"ssh/4", "x509/1", "x509/3"}},
+		{"subject", &CertificateFilter{Subject: "FOO.corp.example"}, []string{"ssh/4", "x509/1"}},
+		{"subject and provisioner", &CertificateFilter{Subject: "bar.other.example", ProvisionerID: "p1-id"}, nil},
+		{"expiry", &CertificateFilter{ExpiresAfter: now.Add(5 * 24 * time.Hour), ExpiresBefore: now.Add(30 * 24 * time.Hour)}, []string{"x509/1", "x509/3"}},
+		{"expiry before", &CertificateFilter{ExpiresBefore: now.Add(15 * 24 * time.Hour)}, []string{"ssh/4", "x509/1"}},
+		{"expiry after", &CertificateFilter{ExpiresAfter: now.Add(30 * 24 * time.Hour)}, []string{"x509/2"}},
+		{"revoked", &CertificateFilter{Revoked: &revoked}, []string{"x509/2"}},
+		{"not revoked", &CertificateFilter{Revoked: &notRevoked, Type: X509CertificateType}, []string{"x509/1", "x509/3"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, next, err := d.SearchCertificates(tt.filter, "", 0)
+			require.NoError(t, err)
+			assert.Empty(t, next)
+			var ids []string
+			for _, e := range got {
+				ids = append(ids, e.ID)
+			}
+			assert.Equal(t, tt.want, ids)
+		})
+	}
+
+	t.Run("entry", func(t *testing.T) {
+		got, _, err := d.SearchCertificates(&CertificateFilter{SAN: "bar.other.example"}, "", 0)
+		require.NoError(t, err)
+		require.Len(t, got, 1)
+		assert.Equal(t, "x509", got[0].Type)
+		assert.Equal(t, "2", got[0].SerialNumber)
+		assert.Equal(t, "bar.other.example", got[0].Subject)
+		assert.Equal(t, []string{"bar.other.example", "10.0.0.1"}, got[0].SANs)
+		assert.Equal(t, &ProvisionerData{ID: "p2-id", Name: "p2", Type: "JWK"}, got[0].Provisioner)
+		assert.True(t, got[0].Revoked)
+	})
+
+	t.Run("pagination", func(t *testing.T) {
+		var ids []string
+		var cursor string
+		for i := 0; i < 3; i++ {
+			got, next, err := d.SearchCertificates(nil, cursor, 3)
+			require.NoError(t, err)
+			for _, e := range got {
+				ids = append(ids, e.ID)
+			}
+			if next == "" {
+				break
+			}
+			cursor = next
+		}
+		assert.Equal(t, []string{"ssh/4", "x509/1", "x509/2", "x509/3"}, ids)
+	})
+}
+
+func TestDB_SearchCertificates_escapedValues(t *testing.T) {
+	ca, err := minica.New()
+	require.NoError(t, err)
+	signer, err := keyutil.GenerateDefaultSigner()
+	require.NoError(t, err)
+
+	adb, err := New(&Config{Type: "badgerv2", DataSource: t.TempDir()})
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = adb.Shutdown() })
+	d := adb.(*DB)
+
+	u, err := url.Parse("spiffe://example.org/ns/default/sa/web")
+	require.NoError(t, err)
+	crt, err := ca.Sign(&x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		URIs:         []*url.URL{u},
+		NotAfter:     time.Now().Add(time.Hour),
+		PublicKey:    signer.Public(),
+	})
+	require.NoError(t, err)
+	require.NoError(t, d.StoreCertificateChain(&provisioner.JWK{ID: "acme/p1", Name: "p1", Type: "JWK"}, crt))
+	require.NoError(t, d.StoreCertificateChain(&provisioner.JWK{ID: "acme", Name: "acme", Type: "JWK"}, newSerialCert(t, ca, signer, 2)))
+
+	for _, filter := range []*CertificateFilter{
+		{ProvisionerID: "acme/p1"},
+		{SAN: "spiffe://example.org/ns/default/sa/web"},
+	} {
+		got, _, err := d.SearchCertificates(filter, "", 0)
+		require.NoError(t, err)
+		require.Len(t, got, 1)
+		assert.Equal(t, "x509/1", got[0].ID)
+	}
+
+	got, _, err := d.SearchCertificates(&CertificateFilter{ProvisionerID: "acme"}, "", 0)
+	require.NoError(t, err)
+	require.Len(t, got, 1)
+	assert.Equal(t, "x509/2", got[0].ID)
+}
+
+func newSerialCert(t *testing.T, ca *minica.CA, signer crypto.Signer, sn int64) *x509.Certificate {
+	t.Helper()
+	crt, err := ca.Sign(&x509.Certificate{
+		SerialNumber: big.NewInt(sn),
+		NotAfter:     time.Now().Add(time.Hour),
+		PublicKey:    signer.Public(),
+	})
+	require.NoError(t, err)
+	return crt
+}
@@ -0,0 +1,285 @@
+package db
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+var scepRequestsTable = []byte("scep_requests")
+
+// SCEP request statuses.
+const (
+	// SCEPRequestPending is the status of a request waiting for a decision.
+	SCEPRequestPending = "pending"
+	// SCEPRequestApproved is the status of a request that has been approved,
+	// the certificate is issued the next time the client polls.
+	SCEPRequestApproved = "approved"
+	// SCEPRequestRejected is the status of a request that has been rejected.
+	SCEPRequestRejected = "rejected"
+	// SCEPRequestIssued is the status of a request whose certificate has been
+	// issued.
+	SCEPRequestIssued = "issued"
+)
+
+// ErrSCEPRequestChanged is returned when a SCEP request cannot be updated
+// because it has been modified concurrently.
+var ErrSCEPRequestChanged = errors.New("scep request has been modified")
+
+// SCEPRequestDB is an extension of AuthDB that stores the SCEP certificate
+// requests that are waiting for approval.
+type SCEPRequestDB interface {
+	CreateSCEPRequest(req *SCEPRequest) error
+	GetSCEPRequest(provisionerID, transactionID string) (*SCEPRequest, error)
+	GetSCEPRequests(provisionerID string) ([]*SCEPRequest, error)
+	UpdateSCEPRequest(old, req *SCEPRequest) error
+}
+
+// SCEPRequest is a SCEP certificate request in the pending queue. Requests
+// are identified by the provisioner and the transaction id used by the
+// client.
+type SCEPRequest struct {
+	ProvisionerID string    `json:"provisionerID"`
+	TransactionID string    `json:"transactionID"`
+	Subject       string    `json:"subject"`
+	CSR           []byte    `json:"csr"`
+	Status        string    `json:"status"`
+	Reason        string    `json:"reason,omitempty"`
+	SerialNumber  string    `json:"serialNumber,omitempty"`
+	CreatedAt     time.Time `json:"createdAt"`
+	UpdatedAt     time.Time `json:"updatedAt"`
+
+	// ChallengeSubject and ChallengeSANs are the names bound to the dynamic
+	// challenge used in the request. They are enforced when the certificate
+	// is issued.
+	ChallengeSubject string   `json:"challengeSubject,omitempty"`
+	ChallengeSANs    []string `json:"challengeSANs,omitempty"`
+
+	// WebhookData is the data returned by the SCEPCHALLENGE webhooks that
+	// accepted the request, by webhook name. It's added to the template data
+	// when the certificate is issued.
+	WebhookData map[string]any `json:"webhookData,omitempty"`
+}
+
+func scepRequestKey(provisionerID, transactionID string) []byte {
+	return []byte(provisionerID + "/" + transactionID)
+}
+
+// CreateSCEPRequest stores a new SCEP request. It returns ErrAlreadyExists if
+// a request with the same provisioner and transaction id already exists.
+func (db *DB) CreateSCEPRequest(req *SCEPRequest) error {
+	b, err := json.Marshal(req)
+	if err != nil {
+		return errors.Wrap(err, "error marshaling scep request")
+	}
+	_, swapped, err := db.CmpAndSwap(scepRequestsTable, scepRequestKey(req.ProvisionerID, req.TransactionID), nil, b)
+	switch {
+	case err != nil:
+		return errors.Wrap(err, "error storing scep request")
+	case !swapped:
+		return ErrAlreadyExists
+	default:
+		return nil
+	}
+}
+
+// GetSCEPRequest returns the SCEP request with the given provisioner and
+// transaction id.
+func (db *DB) GetSCEPRequest(provisionerID, transactionID string) (*SCEPRequest, error) {
+	b, err := db.Get(scepRequestsTable, scepRequestKey(provisionerID, transactionID))
+	if err != nil {
+		return nil, errors.Wrap(err, "database Get error")
+	}
+	var req SCEPRequest
+	if err := json.Unmarshal(b, &req); err != nil {
+		return nil, errors.Wrap(err, "error unmarshaling scep request")
+	}
+	return &req, nil
+}
+
+// GetSCEPRequests returns the SCEP requests of the given provisioner.
+func (db *DB) GetSCEPRequests(provisionerID string) ([]*SCEPRequest, error) {
+	entries, err := db.List(scepRequestsTable)
+	if err != nil {
+		return nil, errors.Wrap(err, "database List error")
+	}
+	var reqs []*SCEPRequest
+	for _, e := range entries {
+		var req SCEPRequest
+		if err := json.Unmarshal(e.Value, &req); err != nil {
+			return nil, errors.Wrap(err, "error unmarshaling scep request")
+		}
+		if req.ProvisionerID == provisionerID {
+			reqs = append(reqs, &req)
+		}
+	}
+	return reqs, nil
+}
+
+// UpdateSCEPRequest atomically replaces the old state of a SCEP request with
+// the new one. It returns ErrSCEPRequestChanged if the stored request is no
+// longer the old one.
+func (db *DB) UpdateSCEPRequest(old, req *SCEPRequest) error {
+	oldb, err := json.Marshal(old)
+	if err != nil {
+		return errors.Wrap(err, "error marshaling scep request")
+	}
+	b, err := json.Marshal(req)
+	if err != nil {
+		return errors.Wrap(err, "error marshaling scep request")
+	}
+	_, swapped, err := db.CmpAndSwap(scepRequestsTable, scepRequestKey(req.ProvisionerID, req.TransactionID), oldb, b)
+	switch {
+	case err != nil:
+		return errors.Wrap(err, "error updating scep request")
+	case !swapped:
+		return ErrSCEPRequestChanged
+	default:
+		return nil
+	}
+}
+
+var scepChallengesTable = []byte("scep_challenges")
+
+// ErrSCEPChallengeExhausted is returned when a SCEP challenge cannot be used
+// because it has expired or because it has no uses left.
+var ErrSCEPChallengeExhausted = errors.New("scep challenge has expired or has no uses left")
+
+// SCEPChallengeDB is an extension of AuthDB that stores the dynamic SCEP
+// challenges created using the admin API.
+type SCEPChallengeDB interface {
+	CreateSCEPChallenge(ch *SCEPChallenge) error
+	GetSCEPChallenge(provisionerID, id string) (*SCEPChallenge, error)
+	GetSCEPChallenges(provisionerID string) ([]*SCEPChallenge, error)
+	DeleteSCEPChallenge(provisionerID, id string) error
+	UseSCEPChallenge(provisionerID, id string) (*SCEPChallenge, error)
+}
+
+// SCEPChallenge is a dynamic SCEP challenge that can be used MaxUses times
+// before it expires. The challenge value is not stored, challenges are
+// identified by the SHA-256 hash of the value, see SCEPChallengeID.
+//
+// If Subject or SANs are set, they must match the common name and the
+// subject alternative names of the certificates issued using the challenge.
+type SCEPChallenge struct {
+	ID            string    `json:"id"`
+	ProvisionerID string    `json:"provisionerID"`
+	Subject       string    `json:"subject,omitempty"`
+	SANs          []string  `json:"sans,omitempty"`
+	MaxUses       int       `json:"maxUses"`
+	Uses          int       `json:"uses"`
+	CreatedAt     time.Time `json:"createdAt"`
+	ExpiresAt     time.Time `json:"expiresAt"`
+}
+
+// SCEPChallengeID returns the identifier of a challenge value.
+func SCEPChallengeID(challenge string) string {
+	sum := sha256.Sum256([]byte(challenge))
+	return hex.EncodeToString(sum[:])
+}
+
+func scepChallengeKey(provisionerID, id string) []byte {
+	return []byte(provisionerID + "/" + id)
+}
+
+// CreateSCEPChallenge stores a new SCEP challenge. It returns
+// ErrAlreadyExists if a challenge with the same provisioner and id already
+// exists.
+func (db *DB) CreateSCEPChallenge(ch *SCEPChallenge) error {
+	b, err := json.Marshal(ch)
+	if err != nil {
+		return errors.Wrap(err, "error marshaling scep challenge")
+	}
+	_, swapped, err := db.CmpAndSwap(scepChallengesTable, scepChallengeKey(ch.ProvisionerID, ch.ID), nil, b)
+	switch {
+	case err != nil:
+		return errors.Wrap(err, "error storing scep challenge")
+	case !swapped:
+		return ErrAlreadyExists
+	default:
+		return nil
+	}
+}
+
+// GetSCEPChallenge returns the SCEP challenge with the given provisioner and
+// id.
+func (db *DB) GetSCEPChallenge(provisionerID, id string) (*SCEPChallenge, error) {
+	b, err := db.Get(scepChallengesTable, scepChallengeKey(provisionerID, id))
+	if err != nil {
+		return nil, errors.Wrap(err, "database Get error")
+	}
+	var ch SCEPChallenge
+	if err := json.Unmarshal(b, &ch); err != nil {
+		return nil, errors.Wrap(err, "error unmarshaling scep challenge")
+	}
+	return &ch, nil
+}
+
+// GetSCEPChallenges returns the SCEP challenges of the given provisioner.
+func (db *DB) GetSCEPChallenges(provisionerID string) ([]*SCEPChallenge, error) {
+	entries, err := db.List(scepChallengesTable)
+	if err != nil {
+		return nil, errors.Wrap(err, "database List error")
+	}
+	var chs []*SCEPChallenge
+	for _, e := range entries {
+		var ch SCEPChallenge
+		if err := json.Unmarshal(e.Value, &ch); err != nil {
+			return nil, errors.Wrap(err, "error unmarshaling scep challenge")
+		}
+		if ch.ProvisionerID == provisionerID {
+			chs = append(chs, &ch)
+		}
+	}
+	return chs, nil
+}
+
+// DeleteSCEPChallenge deletes the SCEP challenge with the given provisioner
+// and id.
+func (db *DB) DeleteSCEPChallenge(provisionerID, id string) error {
+	if err := db.Del(scepChallengesTable, scepChallengeKey(provisionerID, id)); err != nil {
+		return errors.Wrap(err, "database Del error")
+	}
+	return nil
+}
+
+// maxSCEPChallengeRetries is the number of times UseSCEPChallenge retries the
+// update of a challenge modified concurrently.
+const maxSCEPChallengeRetries = 10
+
+// UseSCEPChallenge atomically increments the uses of a SCEP challenge and
+// returns the updated challenge. It returns ErrSCEPChallengeExhausted if the
+// challenge has expired or if it has no uses left.
+func (db *DB) UseSCEPChallenge(provisionerID, id string) (*SCEPChallenge, error) {
+	key := scepChallengeKey(provisionerID, id)
+	for range maxSCEPChallengeRetries {
+		old, err := db.Get(scepChallengesTable, key)
+		if err != nil {
+			return nil, errors.Wrap(err, "database Get error")
+		}
+		var ch SCEPChallenge
+		if err := json.Unmarshal(old, &ch); err != nil {
+			return nil, errors.Wrap(err, "error unmarshaling scep challenge")
+		}
+		if ch.Uses >= ch.MaxUses || !time.Now().Before(ch.ExpiresAt) {
+			return nil, ErrSCEPChallengeExhausted
+		}
+
+		ch.Uses++
+		b, err := json.Marshal(&ch)
+		if err != nil {
+			return nil, errors.Wrap(err, "error marshaling scep challenge")
+		}
+		_, swapped, err := db.CmpAndSwap(scepChallengesTable, key, old, b)
+		switch {
+		case err != nil:
+			return nil, errors.Wrap(err, "error updating scep challenge")
+		case swapped:
+			return &ch, nil
+		}
+	}
+	return nil, errors.New("error updating scep challenge: too many concurrent updates")
+}
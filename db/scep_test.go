@@ -0,0 +1,164 @@
+package db
+
+import (
+	"encoding/json"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/smallstep/nosql"
+)
+
+func TestDB_SCEPRequests(t *testing.T) {
+	adb, err := New(&Config{Type: "badgerv2", DataSource: t.TempDir()})
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = adb.Shutdown() })
+	d, ok := adb.(SCEPRequestDB)
+	require.True(t, ok)
+
+	now := time.Now().UTC().Truncate(time.Second)
+	req := &SCEPRequest{
+		ProvisionerID: "p1-id",
+		TransactionID: "tx1",
+		Subject:       "router1",
+		CSR:           []byte("csr"),
+		Status:        SCEPRequestPending,
+		CreatedAt:     now,
+		UpdatedAt:     now,
+	}
+	require.NoError(t, d.CreateSCEPRequest(req))
+	require.NoError(t, d.CreateSCEPRequest(&SCEPRequest{ProvisionerID: "p2-id", TransactionID: "tx1", Status: SCEPRequestPending}))
+	assert.ErrorIs(t, d.CreateSCEPRequest(req), ErrAlreadyExists)
+
+	got, err := d.GetSCEPRequest("p1-id", "tx1")
+	require.NoError(t, err)
+	assert.Equal(t, req, got)
+
+	_, err = d.GetSCEPRequest("p1-id", "tx2")
+	assert.True(t, nosql.IsErrNotFound(err))
+
+	pending := *req
+	req.Status = SCEPRequestApproved
+	req.UpdatedAt = now.Add(time.Minute)
+	require.NoError(t, d.UpdateSCEPRequest(&pending, req))
+	got, err = d.GetSCEPRequest("p1-id", "tx1")
+	require.NoError(t, err)
+	assert.Equal(t, SCEPRequestApproved, got.Status)
+
+	// Updates of a stale copy fail.
+	rejected := pending
+	rejected.Status = SCEPRequestRejected
+	assert.ErrorIs(t, d.UpdateSCEPRequest(&pending, &rejected), ErrSCEPRequestChanged)
+	got, err = d.GetSCEPRequest("p1-id", "tx1")
+	require.NoError(t, err)
+	assert.Equal(t, req, got)
+
+	reqs, err := d.GetSCEPRequests("p1-id")
+	require.NoError(t, err)
+	assert.Equal(t, []*SCEPRequest{req}, reqs)
+	reqs, err = d.GetSCEPRequests("p3-id")
+	require.NoError(t, err)
+	assert.Empty(t, reqs)
+}
+
+func TestDB_SCEPChallenges(t *testing.T) {
+	adb, err := New(&Config{Type: "badgerv2", DataSource: t.TempDir()})
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = adb.Shutdown() })
+	d, ok := adb.(SCEPChallengeDB)
+	require.True(t, ok)
+
+	now := time.Now().UTC().Truncate(time.Second)
+	ch := &SCEPChallenge{
+		ID:            SCEPChallengeID("challenge1"),
+		ProvisionerID: "p1-id",
+		Subject:       "router1",
+		SANs:          []string{"router1.example.com"},
+		MaxUses:       2,
+		CreatedAt:     now,
+		ExpiresAt:     now.Add(time.Hour),
+	}
+	require.NoError(t, d.CreateSCEPChallenge(ch))
+	require.NoError(t, d.CreateSCEPChallenge(&SCEPChallenge{ID: SCEPChallengeID("challenge2"), ProvisionerID: "p1-id", MaxUses: 1, ExpiresAt: now.Add(-time.Minute)}))
+	require.NoError(t, d.CreateSCEPChallenge(&SCEPChallenge{ID: SCEPChallengeID("challenge1"), ProvisionerID: "p2-id", MaxUses: 1, ExpiresAt: now.Add(time.Hour)}))
+	assert.ErrorIs(t, d.CreateSCEPChallenge(ch), ErrAlreadyExists)
+
+	got, err := d.GetSCEPChallenge("p1-id", SCEPChallengeID("challenge1"))
+	require.NoError(t, err)
+	assert.Equal(t, ch, got)
+	_, err = d.GetSCEPChallenge("p1-id", SCEPChallengeID("challenge3"))
+	assert.True(t, nosql.IsErrNotFound(err))
+
+	chs, err := d.GetSCEPChallenges("p1-id")
+	require.NoError(t, err)
+	assert.Len(t, chs, 2)
+	chs, err = d.GetSCEPChallenges("p3-id")
+	require.NoError(t, err)
+	assert.Empty(t, chs)
+
+	// The challenge can be used twice.
+	got, err = d.UseSCEPChallenge("p1-id", ch.ID)
+	require.NoError(t, err)
+	assert.Equal(t, 1, got.Uses)
+	got, err = d.UseSCEPChallenge("p1-id", ch.ID)
+	require.NoError(t, err)
+	assert.Equal(t, 2, got.Uses)
+	_, err = d.UseSCEPChallenge("p1-id", ch.ID)
+	assert.ErrorIs(t, err, ErrSCEPChallengeExhausted)
+
+	// Expired and missing challenges.
+	_, err = d.UseSCEPChallenge("p1-id", SCEPChallengeID("challenge2"))
+	assert.ErrorIs(t, err, ErrSCEPChallengeExhausted)
+	_, err = d.UseSCEPChallenge("p1-id", SCEPChallengeID("challenge3"))
+	assert.True(t, nosql.IsErrNotFound(err))
+
+	// Concurrent uses never exceed the maximum.
+	id := SCEPChallengeID("challenge4")
+	require.NoError(t, d.CreateSCEPChallenge(&SCEPChallenge{ID: id, ProvisionerID: "p1-id", MaxUses: 5, ExpiresAt: now.Add(time.Hour)}))
+	var (
+		wg   sync.WaitGroup
+		used atomic.Int32
+	)
+	for range 20 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := d.UseSCEPChallenge("p1-id", id); err == nil {
+				used.Add(1)
+			}
+		}()
+	}
+	wg.Wait()
+	assert.Equal(t, int32(5), used.Load())
+
+	require.NoError(t, d.DeleteSCEPChallenge("p1-id", ch.ID))
+	_, err = d.GetSCEPChallenge("p1-id", ch.ID)
+	assert.True(t, nosql.IsErrNotFound(err))
+	got, err = d.GetSCEPChallenge("p2-id", ch.ID)
+	require.NoError(t, err)
+	assert.Equal(t, "p2-id", got.ProvisionerID)
+}
+
+func TestDB_UseSCEPChallenge_retries(t *testing.T) {
+	b, err := json.Marshal(&SCEPChallenge{ID: "id", ProvisionerID: "p1-id", MaxUses: 1, ExpiresAt: time.Now().Add(time.Hour)})
+	require.NoError(t, err)
+	var attempts int
+	d := &DB{&MockNoSQLDB{
+		MGet: func(bucket, key []byte) ([]byte, error) {
+			return b, nil
+		},
+		MCmpAndSwap: func(bucket, key, old, newval []byte) ([]byte, bool, error) {
+			attempts++
+			return old, false, nil
+		},
+	}, true}
+
+	// Updates failing because of concurrent uses are not retried forever.
+	_, err = d.UseSCEPChallenge("p1-id", "id")
+	assert.EqualError(t, err, "error updating scep challenge: too many concurrent updates")
+	assert.Equal(t, maxSCEPChallengeRetries, attempts)
+}
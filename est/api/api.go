@@ -0,0 +1,457 @@
+// Package api implements an EST (RFC 7030) HTTP server.
+package api
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"net/url"
+	"slices"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/smallstep/pkcs7"
+
+	"github.com/smallstep/certificates/api"
+	"github.com/smallstep/certificates/api/log"
+	"github.com/smallstep/certificates/authority"
+	"github.com/smallstep/certificates/authority/provisioner"
+)
+
+const maxPayloadSize = 2 << 20
+
+// Authority is the interface implemented by the CA authority used by the EST
+// handlers.
+type Authority interface {
+	LoadProvisionerByName(string) (provisioner.Interface, error)
+	SignWithContext(ctx context.Context, cr *x509.CertificateRequest, opts provisioner.SignOptions, signOpts ...provisioner.SignOption) ([]*x509.Certificate, error)
+	GetRoots() ([]*x509.Certificate, error)
+	GetIntermediateCertificates() []*x509.Certificate
+	IsRevoked(sn string) (bool, error)
+}
+
+// mustAuthority will be replaced on unit tests.
+var mustAuthority = func(ctx context.Context) Authority {
+	return authority.MustFromContext(ctx)
+}
+
+// Error is an error with the HTTP status code of the EST response.
+type Error struct {
+	Status int
+	Err    error
+}
+
+func newError(status int, format string, args ...any) *Error {
+	return &Error{Status: status, Err: fmt.Errorf(format, args...)}
+}
+
+// Error implements the error interface.
+func (e *Error) Error() string {
+	return e.Err.Error()
+}
+
+// Unwrap returns the wrapped error.
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// Route traffic and implement the Router interface. The routes are expected
+// to be mounted on /.well-known/est, the provisioner name is used as the EST
+// label.
+func Route(r api.Router) {
+	r.MethodFunc(http.MethodGet, "/{provisionerName}/cacerts", lookupProvisioner(CACerts))
+	r.MethodFunc(http.MethodGet, "/{provisionerName}/csrattrs", lookupProvisioner(CSRAttrs))
+	r.MethodFunc(http.MethodPost, "/{provisionerName}/simpleenroll", lookupProvisioner(SimpleEnroll))
+	r.MethodFunc(http.MethodPost, "/{provisionerName}/simplereenroll", lookupProvisioner(SimpleReenroll))
+	r.MethodFunc(http.MethodPost, "/{provisionerName}/serverkeygen", lookupProvisioner(ServerKeyGen))
+}
+
+// provisionerKey is the key type for storing and searching an EST
+// provisioner in the context.
+type provisionerKey struct{}
+
+// provisionerFromContext returns the EST provisioner in the context.
+func provisionerFromContext(ctx context.Context) *provisioner.EST {
+	p, ok := ctx.Value(provisionerKey{}).(*provisioner.EST)
+	if !ok {
+		panic("EST provisioner expected in request context")
+	}
+	return p
+}
+
+// lookupProvisioner loads the provisioner associated with the request.
+// Responds 404 if the provisioner does not exist.
+func lookupProvisioner(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		name := chi.URLParam(r, "provisionerName")
+		provisionerName, err := url.PathUnescape(name)
+		if err != nil {
+			fail(w, r, newError(http.StatusBadRequest, "error url unescaping provisioner name '%s'", name))
+			return
+		}
+
+		ctx := r.Context()
+		p, err := mustAuthority(ctx).LoadProvisionerByName(provisionerName)
+		if err != nil {
+			fail(w, r, &Error{Status: http.StatusNotFound, Err: err})
+			return
+		}
+
+		prov, ok := p.(*provisioner.EST)
+		if !ok {
+			fail(w, r, newError(http.StatusNotFound, "provisioner must be of type EST"))
+			return
+		}
+
+		ctx = context.WithValue(ctx, provisionerKey{}, prov)
+		next(w, r.WithContext(ctx))
+	}
+}
+
+// CACerts returns the CA certificates, RFC 7030 section 4.1.
+func CACerts(w http.ResponseWriter, r *http.Request) {
+	auth := mustAuthority(r.Context())
+	roots, err := auth.GetRoots()
+	if err != nil {
+		fail(w, r, fmt.Errorf("error getting roots: %w", err))
+		return
+	}
+
+	certs := append(slices.Clone(auth.GetIntermediateCertificates()), roots...)
+	data, err := degenerateCertificates(certs)
+	if err != nil {
+		fail(w, r, err)
+		return
+	}
+
+	writeBase64(w, "application/pkcs7-mime", data)
+}
+
+// CSRAttrs returns the attributes clients should include in their
+// certificate requests, RFC 7030 section 4.5.
+func CSRAttrs(w http.ResponseWriter, r *http.Request) {
+	p := provisionerFromContext(r.Context())
+	oids := p.GetCSRAttributes()
+	if len(oids) == 0 {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	data, err := asn1.Marshal(oids)
+	if err != nil {
+		fail(w, r, fmt.Errorf("error marshaling csr attributes: %w", err))
+		return
+	}
+
+	writeBase64(w, "application/csrattrs", data)
+}
+
+// SimpleEnroll signs a certificate request, RFC 7030 section 4.2.1. Requests
+// authenticated with a TLS client certificate must use the subject and the
+// subject alternative names of the certificate.
+func SimpleEnroll(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	csr, err := readCSR(r)
+	if err != nil {
+		fail(w, r, err)
+		return
+	}
+
+	signCSROpts, err := authenticate(ctx, r, csr)
+	if err != nil {
+		fail(w, r, err)
+		return
+	}
+
+	cert, err := signCSR(ctx, csr, signCSROpts)
+	if err != nil {
+		fail(w, r, err)
+		return
+	}
+
+	writeCertificate(w, r, cert)
+}
+
+// SimpleReenroll renews the certificate used in the TLS client
+// authentication, RFC 7030 section 4.2.2. The certificate must have been
+// issued by the provisioner, and the subject and the subject alternative names
+// of the request must match the ones in the certificate.
+func SimpleReenroll(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	csr, err := readCSR(r)
+	if err != nil {
+		fail(w, r, err)
+		return
+	}
+
+	peer, err := clientCertificate(ctx, r)
+	if err != nil {
+		fail(w, r, err)
+		return
+	}
+	if !provisioner.SameCertificateNames(csr, peer) {
+		fail(w, r, newError(http.StatusBadRequest, "certificate request subject and subject alternative names do not match the client certificate"))
+		return
+	}
+
+	cert, err := signCSR(ctx, csr, nil)
+	if err != nil {
+		fail(w, r, err)
+		return
+	}
+
+	writeCertificate(w, r, cert)
+}
+
+// ServerKeyGen generates a new key and signs a certificate for it using the
+// names in the certificate request, RFC 7030 section 4.4. The response
+// contains the private key and the certificate.
+func ServerKeyGen(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	p := provisionerFromContext(ctx)
+	if p.ServerKeyGen == nil {
+		fail(w, r, newError(http.StatusNotImplemented, "server key generation is not enabled"))
+		return
+	}
+
+	csr, err := readCSR(r)
+	if err != nil {
+		fail(w, r, err)
+		return
+	}
+
+	signCSROpts, err := authenticate(ctx, r, csr)
+	if err != nil {
+		fail(w, r, err)
+		return
+	}
+
+	key, err := p.GenerateKey()
+	if err != nil {
+		fail(w, r, fmt.Errorf("error generating key: %w", err))
+		return
+	}
+	der, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{
+		RawSubject:      csr.RawSubject,
+		ExtraExtensions: csr.Extensions,
+	}, key)
+	if err != nil {
+		fail(w, r, fmt.Errorf("error creating certificate request: %w", err))
+		return
+	}
+	if csr, err = x509.ParseCertificateRequest(der); err != nil {
+		fail(w, r, fmt.Errorf("error parsing certificate request: %w", err))
+		return
+	}
+
+	cert, err := signCSR(ctx, csr, signCSROpts)
+	if err != nil {
+		fail(w, r, err)
+		return
+	}
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		fail(w, r, fmt.Errorf("error marshaling private key: %w", err))
+		return
+	}
+	certs, err := degenerateCertificates([]*x509.Certificate{cert})
+	if err != nil {
+		fail(w, r, err)
+		return
+	}
+
+	var buf bytes.Buffer
+	mw := multipart.NewWriter(&buf)
+	for _, part := range []struct {
+		contentType string
+		data        []byte
+	}{
+		{"application/pkcs8", keyDER},
+		{"application/pkcs7-mime; smime-type=certs-only", certs},
+	} {
+		pw, err := mw.CreatePart(textproto.MIMEHeader{
+			"Content-Type":              {part.contentType},
+			"Content-Transfer-Encoding": {"base64"},
+		})
+		if err != nil {
+			fail(w, r, err)
+			return
+		}
+		if _, err := io.WriteString(pw, base64.StdEncoding.EncodeToString(part.data)); err != nil {
+			fail(w, r, err)
+			return
+		}
+	}
+	if err := mw.Close(); err != nil {
+		fail(w, r, err)
+		return
+	}
+
+	api.LogCertificate(w, cert)
+	w.Header().Set("Content-Type", "multipart/mixed; boundary="+mw.Boundary())
+	_, _ = w.Write(buf.Bytes())
+}
+
+// readCSR reads the base64 encoded certificate request in the body of a
+// request.
+func readCSR(r *http.Request) (*x509.CertificateRequest, error) {
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxPayloadSize))
+	if err != nil {
+		return nil, fmt.Errorf("failed reading request body: %w", err)
+	}
+
+	// the body might contain line breaks
+	b64 := strings.Map(func(r rune) rune {
+		switch r {
+		case '\r', '\n', ' ', '\t':
+			return -1
+		}
+		return r
+	}, string(body))
+	der, err := base64.StdEncoding.DecodeString(b64)
+	if err != nil {
+		return nil, newError(http.StatusBadRequest, "failed base64 decoding certificate request: %w", err)
+	}
+	csr, err := x509.ParseCertificateRequest(der)
+	if err != nil {
+		return nil, newError(http.StatusBadRequest, "failed parsing certificate request: %w", err)
+	}
+	if err := csr.CheckSignature(); err != nil {
+		return nil, newError(http.StatusBadRequest, "invalid certificate request signature: %w", err)
+	}
+	return csr, nil
+}
+
+// authenticate authenticates an enrollment request using the HTTP basic
+// credentials or, if enabled in the provisioner, the TLS client certificate.
+// The names in a request authenticated with a client certificate must match
+// the ones in the certificate.
+func authenticate(ctx context.Context, r *http.Request, csr *x509.CertificateRequest) ([]provisioner.SignCSROption, error) {
+	p := provisionerFromContext(ctx)
+	if username, password, ok := r.BasicAuth(); ok && p.IsBasicAuthEnabled() {
+		opts, err := p.ValidateBasicAuth(ctx, csr, username, password)
+		if err != nil {
+			return nil, &Error{Status: http.StatusUnauthorized, Err: err}
+		}
+		return opts, nil
+	}
+
+	if p.IsTLSClientAuthEnabled() {
+		peer, err := clientCertificate(ctx, r)
+		if err != nil {
+			return nil, err
+		}
+		if !provisioner.SameCertificateNames(csr, peer) {
+			return nil, newError(http.StatusForbidden, "certificate request subject and subject alternative names do not match the client certificate")
+		}
+		return []provisioner.SignCSROption{}, nil
+	}
+
+	return nil, newError(http.StatusUnauthorized, "missing credentials")
+}
+
+// clientCertificate returns the TLS client certificate of the request. The
+// certificate must have been verified by the TLS server, must have been issued
+// by the EST provisioner in the context, and must not be revoked.
+func clientCertificate(ctx context.Context, r *http.Request) (*x509.Certificate, error) {
+	if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 || len(r.TLS.VerifiedChains[0]) == 0 {
+		return nil, newError(http.StatusUnauthorized, "missing client certificate")
+	}
+
+	cert := r.TLS.VerifiedChains[0][0]
+	p := provisionerFromContext(ctx)
+	if err := p.ValidateClientCertificate(cert); err != nil {
+		return nil, newError(http.StatusUnauthorized, "client certificate was not issued by provisioner %q", p.GetName())
+	}
+	revoked, err := mustAuthority(ctx).IsRevoked(cert.SerialNumber.String())
+	switch {
+	case err != nil:
+		return nil, fmt.Errorf("error checking certificate revocation: %w", err)
+	case revoked:
+		return nil, newError(http.StatusUnauthorized, "client certificate has been revoked")
+	}
+	return cert, nil
+}
+
+// signCSR signs the certificate request using the EST provisioner in the
+// context.
+func signCSR(ctx context.Context, csr *x509.CertificateRequest, signCSROpts []provisioner.SignCSROption) (*x509.Certificate, error) {
+	p := provisionerFromContext(ctx)
+
+	ctx = provisioner.NewContextWithMethod(ctx, provisioner.SignMethod)
+	signOps, err := provisioner.CertificateRequestSignOptions(ctx, p, p.GetOptions(), csr, signCSROpts...)
+	if err != nil {
+		return nil, err
+	}
+
+	certChain, err := mustAuthority(ctx).SignWithContext(ctx, csr, provisioner.SignOptions{}, signOps...)
+	if err != nil {
+		return nil, fmt.Errorf("error generating certificate: %w", err)
+	}
+	return certChain[0], nil
+}
+
+// degenerateCertificates returns a degenerate certificates-only PKCS#7
+// structure with the given certificates.
+func degenerateCertificates(certs []*x509.Certificate) ([]byte, error) {
+	var buf bytes.Buffer
+	for _, cert := range certs {
+		buf.Write(cert.Raw)
+	}
+	data, err := pkcs7.DegenerateCertificate(buf.Bytes())
+	if err != nil {
+		return nil, fmt.Errorf("error creating degenerate certificates: %w", err)
+	}
+	return data, nil
+}
+
+// writeCertificate writes the certificate in a certs-only response.
+func writeCertificate(w http.ResponseWriter, r *http.Request, cert *x509.Certificate) {
+	data, err := degenerateCertificates([]*x509.Certificate{cert})
+	if err != nil {
+		fail(w, r, err)
+		return
+	}
+
+	api.LogCertificate(w, cert)
+	writeBase64(w, "application/pkcs7-mime; smime-type=certs-only", data)
+}
+
+// writeBase64 writes a base64 encoded response with the given content type.
+func writeBase64(w http.ResponseWriter, contentType string, data []byte) {
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Transfer-Encoding", "base64")
+	_, _ = io.WriteString(w, base64.StdEncoding.EncodeToString(data))
+}
+
+// fail logs the error and writes it to the client. Internal errors are
+// answered with a generic message, and requests without valid credentials
+// with an HTTP basic challenge.
+func fail(w http.ResponseWriter, r *http.Request, err error) {
+	log.Error(w, r, err)
+
+	status := http.StatusInternalServerError
+	var e *Error
+	if errors.As(err, &e) {
+		status = e.Status
+	}
+
+	msg := err.Error()
+	switch status {
+	case http.StatusInternalServerError:
+		msg = http.StatusText(status)
+	case http.StatusUnauthorized:
+		w.Header().Set("WWW-Authenticate", `Basic realm="estrealm"`)
+	}
+
+	http.Error(w, msg, status)
+}
@@ -0,0 +1,379 @@
+package api
+
+import (
+	"context"
+	"crypto"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/base64"
+	"errors"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/smallstep/pkcs7"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.step.sm/crypto/keyutil"
+	"go.step.sm/crypto/minica"
+	"go.step.sm/crypto/x509util"
+
+	"github.com/smallstep/certificates/authority/config"
+	"github.com/smallstep/certificates/authority/provisioner"
+)
+
+type mockAuthority struct {
+	ca           *minica.CA
+	provisioners map[string]provisioner.Interface
+	revoked      map[string]bool
+	revokedErr   error
+}
+
+func (m *mockAuthority) LoadProvisionerByName(name string) (provisioner.Interface, error) {
+	p, ok := m.provisioners[name]
+	if !ok {
+		return nil, errors.New("provisioner not found")
+	}
+	return p, nil
+}
+
+func (m *mockAuthority) SignWithContext(_ context.Context, cr *x509.CertificateRequest, opts provisioner.SignOptions, signOpts ...provisioner.SignOption) ([]*x509.Certificate, error) {
+	var certOptions []x509util.Option
+	for _, so := range signOpts {
+		if co, ok := so.(provisioner.CertificateOptions); ok {
+			certOptions = append(certOptions, co.Options(opts)...)
+		}
+	}
+	c, err := x509util.NewCertificate(cr, certOptions...)
+	if err != nil {
+		return nil, err
+	}
+	tmpl := c.GetCertificate()
+	for _, so := range signOpts {
+		if m, ok := so.(provisioner.CertificateModifier); ok {
+			if err := m.Modify(tmpl, opts); err != nil {
+				return nil, err
+			}
+		}
+	}
+	crt, err := m.ca.Sign(tmpl)
+	if err != nil {
+		return nil, err
+	}
+	return []*x509.Certificate{crt, m.ca.Intermediate}, nil
+}
+
+func (m *mockAuthority) GetRoots() ([]*x509.Certificate, error) {
+	return []*x509.Certificate{m.ca.Root}, nil
+}
+
+func (m *mockAuthority) GetIntermediateCertificates() []*x509.Certificate {
+	return []*x509.Certificate{m.ca.Intermediate}
+}
+
+func (m *mockAuthority) IsRevoked(sn string) (bool, error) {
+	return m.revoked[sn], m.revokedErr
+}
+
+func newTestServer(t *testing.T) (*mockAuthority, http.Handler) {
+	t.Helper()
+	ca, err := minica.New()
+	require.NoError(t, err)
+
+	newProvisioner := func(p *provisioner.EST) *provisioner.EST {
+		require.NoError(t, p.Init(provisioner.Config{Claims: config.GlobalProvisionerClaims}))
+		return p
+	}
+	auth := &mockAuthority{
+		ca: ca,
+		provisioners: map[string]provisioner.Interface{
+			"est": newProvisioner(&provisioner.EST{
+				Type:          "EST",
+				Name:          "est",
+				Username:      "device",
+				Password:      "password",
+				CSRAttributes: []x509util.ObjectIdentifier{{1, 2, 840, 113549, 1, 9, 7}},
+				ServerKeyGen:  &provisioner.ESTServerKeyGen{},
+			}),
+			"est-mtls": newProvisioner(&provisioner.EST{
+				Type:          "EST",
+				Name:          "est-mtls",
+				TLSClientAuth: true,
+			}),
+			"jwk": &provisioner.JWK{Type: "JWK", Name: "jwk"},
+		},
+		revoked: map[string]bool{},
+	}
+
+	prev := mustAuthority
+	mustAuthority = func(context.Context) Authority {
+		return auth
+	}
+	t.Cleanup(func() {
+		mustAuthority = prev
+	})
+
+	r := chi.NewRouter()
+	r.Route("/.well-known/est", func(r chi.Router) {
+		Route(r)
+	})
+	return auth, r
+}
+
+func newCSR(t *testing.T, commonName string, sans ...string) (crypto.Signer, string) {
+	t.Helper()
+	key, err := keyutil.GenerateDefaultSigner()
+	require.NoError(t, err)
+	csr, err := x509util.CreateCertificateRequest(commonName, sans, key)
+	require.NoError(t, err)
+	return key, base64.StdEncoding.EncodeToString(csr.Raw)
+}
+
+// newClientCertificate returns a certificate issued by the given EST
+// provisioner.
+func newClientCertificate(t *testing.T, auth *mockAuthority, provisionerName, commonName string, sans ...string) *x509.Certificate {
+	t.Helper()
+	key, err := keyutil.GenerateDefaultSigner()
+	require.NoError(t, err)
+	ext, err := (&provisioner.Extension{Type: provisioner.TypeEST, Name: provisionerName}).ToExtension()
+	require.NoError(t, err)
+	cert, err := auth.ca.Sign(&x509.Certificate{
+		Subject:         pkix.Name{CommonName: commonName},
+		DNSNames:        sans,
+		PublicKey:       key.Public(),
+		ExtraExtensions: []pkix.Extension{ext},
+	})
+	require.NoError(t, err)
+	return cert
+}
+
+func parseCertsOnly(t *testing.T, body []byte) []*x509.Certificate {
+	t.Helper()
+	der, err := base64.StdEncoding.DecodeString(string(body))
+	require.NoError(t, err)
+	p7, err := pkcs7.Parse(der)
+	require.NoError(t, err)
+	return p7.Certificates
+}
+
+func do(h http.Handler, r *http.Request) (*http.Response, []byte) {
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	res := w.Result()
+	body, _ := io.ReadAll(res.Body)
+	res.Body.Close()
+	return res, body
+}
+
+func TestCACerts(t *testing.T) {
+	auth, h := newTestServer(t)
+
+	res, body := do(h, httptest.NewRequest("GET", "/.well-known/est/est/cacerts", http.NoBody))
+	require.Equal(t, http.StatusOK, res.StatusCode)
+	assert.Equal(t, "application/pkcs7-mime", res.Header.Get("Content-Type"))
+	assert.Equal(t, "base64", res.Header.Get("Content-Transfer-Encoding"))
+	assert.Equal(t, []*x509.Certificate{auth.ca.Intermediate, auth.ca.Root}, parseCertsOnly(t, body))
+
+	res, _ = do(h, httptest.NewRequest("GET", "/.well-known/est/missing/cacerts", http.NoBody))
+	assert.Equal(t, http.StatusNotFound, res.StatusCode)
+
+	res, _ = do(h, httptest.NewRequest("GET", "/.well-known/est/jwk/cacerts", http.NoBody))
+	assert.Equal(t, http.StatusNotFound, res.StatusCode)
+}
+
+func TestCSRAttrs(t *testing.T) {
+	_, h := newTestServer(t)
+
+	res, body := do(h, httptest.NewRequest("GET", "/.well-known/est/est/csrattrs", http.NoBody))
+	require.Equal(t, http.StatusOK, res.StatusCode)
+	assert.Equal(t, "application/csrattrs", res.Header.Get("Content-Type"))
+	der, err := base64.StdEncoding.DecodeString(string(body))
+	require.NoError(t, err)
+	var oids []asn1.ObjectIdentifier
+	_, err = asn1.Unmarshal(der, &oids)
+	require.NoError(t, err)
+	assert.Equal(t, []asn1.ObjectIdentifier{{1, 2, 840, 113549, 1, 9, 7}}, oids)
+
+	res, body = do(h, httptest.NewRequest("GET", "/.well-known/est/est-mtls/csrattrs", http.NoBody))
+	assert.Equal(t, http.StatusNoContent, res.StatusCode)
+	assert.Empty(t, body)
+}
+
+func TestSimpleEnroll(t *testing.T) {
+	auth, h := newTestServer(t)
+	_, csr := newCSR(t, "router1", "router1.example.com")
+
+	newRequest := func(provisionerName, body string) *http.Request {
+		r := httptest.NewRequest("POST", "/.well-known/est/"+provisionerName+"/simpleenroll", strings.NewReader(body))
+		r.Header.Set("Content-Type", "application/pkcs10")
+		return r
+	}
+
+	// Basic authentication.
+	r := newRequest("est", csr)
+	r.SetBasicAuth("device", "password")
+	res, body := do(h, r)
+	require.Equal(t, http.StatusOK, res.StatusCode, string(body))
+	assert.Equal(t, "application/pkcs7-mime; smime-type=certs-only", res.Header.Get("Content-Type"))
+	certs := parseCertsOnly(t, body)
+	require.Len(t, certs, 1)
+	assert.Equal(t, "router1", certs[0].Subject.CommonName)
+	assert.Equal(t, []string{"router1.example.com"}, certs[0].DNSNames)
+
+	// Line breaks in the body are ignored.
+	r = newRequest("est", csr[:64]+"\r\n"+csr[64:])
+	r.SetBasicAuth("device", "password")
+	res, body = do(h, r)
+	assert.Equal(t, http.StatusOK, res.StatusCode, string(body))
+
+	// Missing and invalid credentials.
+	res, _ = do(h, newRequest("est", csr))
+	assert.Equal(t, http.StatusUnauthorized, res.StatusCode)
+	assert.Equal(t, `Basic realm="estrealm"`, res.Header.Get("WWW-Authenticate"))
+	r = newRequest("est", csr)
+	r.SetBasicAuth("device", "bad-password")
+	res, _ = do(h, r)
+	assert.Equal(t, http.StatusUnauthorized, res.StatusCode)
+
+	// Invalid certificate request.
+	r = newRequest("est", "not-base64")
+	r.SetBasicAuth("device", "password")
+	res, _ = do(h, r)
+	assert.Equal(t, http.StatusBadRequest, res.StatusCode)
+
+	// TLS client authentication.
+	peer := newClientCertificate(t, auth, "est-mtls", "router2", "router2.example.com")
+	verifiedChains := [][]*x509.Certificate{{peer, auth.ca.Intermediate, auth.ca.Root}}
+	_, peerCSR := newCSR(t, "router2", "router2.example.com")
+	r = newRequest("est-mtls", peerCSR)
+	r.TLS = &tls.ConnectionState{VerifiedChains: verifiedChains}
+	res, body = do(h, r)
+	require.Equal(t, http.StatusOK, res.StatusCode, string(body))
+	assert.Equal(t, "router2", parseCertsOnly(t, body)[0].Subject.CommonName)
+
+	// The names must match the client certificate.
+	r = newRequest("est-mtls", csr)
+	r.TLS = &tls.ConnectionState{VerifiedChains: verifiedChains}
+	res, _ = do(h, r)
+	assert.Equal(t, http.StatusForbidden, res.StatusCode)
+
+	// The client certificate must be issued by the provisioner.
+	r = newRequest("est-mtls", csr)
+	r.TLS = &tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{certs[0], auth.ca.Intermediate, auth.ca.Root}}}
+	res, _ = do(h, r)
+	assert.Equal(t, http.StatusUnauthorized, res.StatusCode)
+
+	// TLS client authentication is not enabled.
+	r = newRequest("est", peerCSR)
+	r.TLS = &tls.ConnectionState{VerifiedChains: verifiedChains}
+	res, _ = do(h, r)
+	assert.Equal(t, http.StatusUnauthorized, res.StatusCode)
+
+	// Internal errors are not sent to the client.
+	auth.revokedErr = errors.New("database is down")
+	r = newRequest("est-mtls", peerCSR)
+	r.TLS = &tls.ConnectionState{VerifiedChains: verifiedChains}
+	res, body = do(h, r)
+	assert.Equal(t, http.StatusInternalServerError, res.StatusCode)
+	assert.Equal(t, "Internal Server Error\n", string(body))
+	auth.revokedErr = nil
+
+	// Revoked client certificate.
+	auth.revoked[peer.SerialNumber.String()] = true
+	r = newRequest("est-mtls", peerCSR)
+	r.TLS = &tls.ConnectionState{VerifiedChains: verifiedChains}
+	res, _ = do(h, r)
+	assert.Equal(t, http.StatusUnauthorized, res.StatusCode)
+}
+
+func TestSimpleReenroll(t *testing.T) {
+	auth, h := newTestServer(t)
+	peer := newClientCertificate(t, auth, "est", "router1", "router1.example.com", "router1")
+	verifiedChains := [][]*x509.Certificate{{peer, auth.ca.Intermediate, auth.ca.Root}}
+
+	newRequest := func(body string) *http.Request {
+		r := httptest.NewRequest("POST", "/.well-known/est/est/simplereenroll", strings.NewReader(body))
+		r.Header.Set("Content-Type", "application/pkcs10")
+		return r
+	}
+
+	_, csr := newCSR(t, "router1", "router1", "router1.example.com")
+	r := newRequest(csr)
+	r.TLS = &tls.ConnectionState{VerifiedChains: verifiedChains}
+	res, body := do(h, r)
+	require.Equal(t, http.StatusOK, res.StatusCode, string(body))
+	certs := parseCertsOnly(t, body)
+	require.Len(t, certs, 1)
+	assert.Equal(t, "router1", certs[0].Subject.CommonName)
+	assert.NotEqual(t, peer.SerialNumber, certs[0].SerialNumber)
+
+	// The names must match the client certificate.
+	_, otherCSR := newCSR(t, "router1", "router1", "router2.example.com")
+	r = newRequest(otherCSR)
+	r.TLS = &tls.ConnectionState{VerifiedChains: verifiedChains}
+	res, _ = do(h, r)
+	assert.Equal(t, http.StatusBadRequest, res.StatusCode)
+
+	// Certificates issued by other provisioners cannot be renewed.
+	r = newRequest(csr)
+	r.TLS = &tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{
+		newClientCertificate(t, auth, "est-mtls", "router1", "router1.example.com", "router1"), auth.ca.Intermediate, auth.ca.Root,
+	}}}
+	res, _ = do(h, r)
+	assert.Equal(t, http.StatusUnauthorized, res.StatusCode)
+
+	// A client certificate is required, even with basic credentials.
+	r = newRequest(csr)
+	r.SetBasicAuth("device", "password")
+	res, _ = do(h, r)
+	assert.Equal(t, http.StatusUnauthorized, res.StatusCode)
+}
+
+func TestServerKeyGen(t *testing.T) {
+	_, h := newTestServer(t)
+	csrKey, csr := newCSR(t, "router1", "router1.example.com")
+
+	r := httptest.NewRequest("POST", "/.well-known/est/est/serverkeygen", strings.NewReader(csr))
+	r.SetBasicAuth("device", "password")
+	res, body := do(h, r)
+	require.Equal(t, http.StatusOK, res.StatusCode, string(body))
+
+	mediaType, params, err := mime.ParseMediaType(res.Header.Get("Content-Type"))
+	require.NoError(t, err)
+	assert.Equal(t, "multipart/mixed", mediaType)
+	mr := multipart.NewReader(strings.NewReader(string(body)), params["boundary"])
+
+	part, err := mr.NextPart()
+	require.NoError(t, err)
+	assert.Equal(t, "application/pkcs8", part.Header.Get("Content-Type"))
+	b, err := io.ReadAll(part)
+	require.NoError(t, err)
+	der, err := base64.StdEncoding.DecodeString(string(b))
+	require.NoError(t, err)
+	key, err := x509.ParsePKCS8PrivateKey(der)
+	require.NoError(t, err)
+
+	part, err = mr.NextPart()
+	require.NoError(t, err)
+	assert.Equal(t, "application/pkcs7-mime; smime-type=certs-only", part.Header.Get("Content-Type"))
+	b, err = io.ReadAll(part)
+	require.NoError(t, err)
+	certs := parseCertsOnly(t, b)
+	require.Len(t, certs, 1)
+	assert.Equal(t, "router1", certs[0].Subject.CommonName)
+	assert.Equal(t, []string{"router1.example.com"}, certs[0].DNSNames)
+	assert.True(t, key.(crypto.Signer).Public().(interface{ Equal(crypto.PublicKey) bool }).Equal(certs[0].PublicKey))
+	assert.False(t, csrKey.Public().(interface{ Equal(crypto.PublicKey) bool }).Equal(certs[0].PublicKey))
+
+	// Server key generation is not enabled.
+	r = httptest.NewRequest("POST", "/.well-known/est/est-mtls/serverkeygen", strings.NewReader(csr))
+	res, _ = do(h, r)
+	assert.Equal(t, http.StatusNotImplemented, res.StatusCode)
+}
@@ -33,6 +33,10 @@ func New() (m *Meter) {
 			signed: prometheus.NewCounter(prometheus.CounterOpts(opts("kms", "signed", "Number of KMS-backed signatures"))),
 			errors: prometheus.NewCounter(prometheus.CounterOpts(opts("kms", "errors", "Number of KMS-related errors"))),
 		},
+		acmeGC: &acmeGC{
+			runs:    newCounterVec("acme_gc", "runs_total", "Number of ACME garbage collector runs", "success"),
+			deleted: newCounterVec("acme_gc", "deleted_total", "Number of stale ACME objects deleted", "object"),
+		},
 	}
 
 	reg := prometheus.NewRegistry()
@@ -51,6 +55,8 @@ func New() (m *Meter) {
 		m.x509.webhookEnriched,
 		m.kms.signed,
 		m.kms.errors,
+		m.acmeGC.runs,
+		m.acmeGC.deleted,
 	)
 
 	h := promhttp.HandlerFor(reg, promhttp.HandlerOpts{
@@ -74,6 +80,7 @@ type Meter struct {
 	ssh    *provisionerInstruments
 	x509   *provisionerInstruments
 	kms    *kms
+	acmeGC *acmeGC
 }
 
 // SSHRekeyed implements [authority.Meter] for [Meter].
@@ -144,6 +151,15 @@ func (m *Meter) KMSSigned(err error) {
 	}
 }
 
+// ACMESwept is called after each run of the ACME garbage collector with the
+// number of objects deleted by type.
+func (m *Meter) ACMESwept(deleted map[string]int, err error) {
+	for object, n := range deleted {
+		m.acmeGC.deleted.WithLabelValues(object).Add(float64(n))
+	}
+	m.acmeGC.runs.WithLabelValues(strconv.FormatBool(err == nil)).Inc()
+}
+
 // provisionerInstruments wraps the counters exported by provisioners.
 type provisionerInstruments struct {
 	rekeyed *prometheus.CounterVec
@@ -184,6 +200,11 @@ type kms struct {
 	errors prometheus.Counter
 }
 
+type acmeGC struct {
+	runs    *prometheus.CounterVec
+	deleted *prometheus.CounterVec
+}
+
 func newCounterVec(subsystem, name, help string, labels ...string) *prometheus.CounterVec {
 	opts := opts(subsystem, name, help)
 
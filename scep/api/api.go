@@ -24,9 +24,10 @@ import (
 )
 
 const (
-	opnGetCACert    = "GetCACert"
-	opnGetCACaps    = "GetCACaps"
-	opnPKIOperation = "PKIOperation"
+	opnGetCACert     = "GetCACert"
+	opnGetCACaps     = "GetCACaps"
+	opnGetNextCACert = "GetNextCACert"
+	opnPKIOperation  = "PKIOperation"
 
 	// TODO: add other (more optional) operations and handling
 )
@@ -109,6 +110,8 @@ func Get(w http.ResponseWriter, r *http.Request) {
 		res, err = GetCACert(ctx)
 	case opnGetCACaps:
 		res, err = GetCACaps(ctx)
+	case opnGetNextCACert:
+		res, err = GetNextCACert(ctx)
 	case opnPKIOperation:
 		res, err = PKIOperation(ctx, req)
 	default:
@@ -164,7 +167,7 @@ func decodeRequest(r *http.Request) (request, error) {
 	switch method {
 	case http.MethodGet:
 		switch operation {
-		case opnGetCACert, opnGetCACaps:
+		case opnGetCACert, opnGetCACaps, opnGetNextCACert:
 			return request{
 				Operation: operation,
 				Message:   []byte{},
@@ -343,6 +346,21 @@ func GetCACaps(ctx context.Context) (Response, error) {
 	return res, nil
 }
 
+// GetNextCACert returns the CA certificates that will be used after a CA
+// rollover in a SCEP response
+func GetNextCACert(ctx context.Context) (Response, error) {
+	auth := scep.MustFromContext(ctx)
+	data, err := auth.GetNextCACert(ctx)
+	if err != nil {
+		return Response{}, err
+	}
+
+	return Response{
+		Operation: opnGetNextCACert,
+		Data:      data,
+	}, nil
+}
+
 // PKIOperation performs PKI operations and returns a SCEP response
 func PKIOperation(ctx context.Context, req request) (Response, error) {
 	// parse the message using smallscep implementation
@@ -375,6 +393,18 @@ func PKIOperation(ctx context.Context, req request) (Response, error) {
 	}
 
 	// NOTE: at this point we have sufficient information for returning nicely signed CertReps
+	switch msg.MessageType {
+	case smallscep.CertPoll:
+		certRep, err := auth.CertPoll(ctx, msg)
+		return certRepResponse(ctx, msg, certRep, err)
+	case smallscep.GetCert:
+		certRep, err := auth.GetCert(ctx, msg)
+		return certRepResponse(ctx, msg, certRep, err)
+	case smallscep.GetCRL:
+		certRep, err := auth.GetCRL(ctx, msg)
+		return certRepResponse(ctx, msg, certRep, err)
+	}
+
 	csr := msg.CSRReqMessage.CSR
 	transactionID := string(msg.TransactionID)
 	challengePassword := msg.CSRReqMessage.ChallengePassword
@@ -388,9 +418,17 @@ func PKIOperation(ctx context.Context, req request) (Response, error) {
 	if msg.MessageType == smallscep.PKCSReq || msg.MessageType == smallscep.RenewalReq {
 		challengeOptions, err := auth.ValidateChallenge(ctx, csr, challengePassword, transactionID)
 		if err != nil {
+			if errors.Is(err, provisioner.ErrSCEPRequestPending) {
+				certRep, err := auth.CreatePendingRequest(ctx, csr, msg, challengeOptions...)
+				return certRepResponse(ctx, msg, certRep, err)
+			}
 			if errors.Is(err, provisioner.ErrSCEPChallengeInvalid) {
 				return createFailureResponse(ctx, csr, msg, smallscep.BadRequest, err.Error(), err)
 			}
+			var fi *scep.FailInfo
+			if errors.As(err, &fi) {
+				return createFailureResponse(ctx, csr, msg, smallscep.FailInfo(fi.Name), fi.Text, err)
+			}
 			scepErr := errors.New("failed validating challenge password")
 			return createFailureResponse(ctx, csr, msg, smallscep.BadRequest, scepErr.Error(), scepErr)
 		}
@@ -465,6 +503,25 @@ func createFailureResponse(ctx context.Context, csr *x509.CertificateRequest, ms
 	}, nil
 }
 
+// certRepResponse returns the response of the messages answered by the
+// authority with a CertRep message. Errors are returned to the client as a
+// failure response.
+func certRepResponse(ctx context.Context, msg *scep.PKIMessage, certRep *scep.PKIMessage, err error) (Response, error) {
+	if err != nil {
+		var fi *scep.FailInfo
+		if errors.As(err, &fi) {
+			return createFailureResponse(ctx, nil, msg, smallscep.FailInfo(fi.Name), fi.Text, err)
+		}
+		return createFailureResponse(ctx, nil, msg, smallscep.BadRequest, "internal server error; please see the certificate authority logs for more info", err)
+	}
+
+	return Response{
+		Operation:   opnPKIOperation,
+		Data:        certRep.Raw,
+		Certificate: certRep.Certificate,
+	}, nil
+}
+
 func contentHeader(r Response) string {
 	switch r.Operation {
 	default:
@@ -474,6 +531,8 @@ func contentHeader(r Response) string {
 			return "application/x-x509-ca-ra-cert"
 		}
 		return "application/x-x509-ca-cert"
+	case opnGetNextCACert:
+		return "application/x-x509-next-ca-cert"
 	case opnPKIOperation:
 		return "application/x-pki-message"
 	}
@@ -111,6 +111,17 @@ func Test_decodeRequest(t *testing.T) {
 			},
 			wantErr: false,
 		},
+		{
+			name: "ok/get-GetNextCACert",
+			args: args{
+				r: httptest.NewRequest(http.MethodGet, "http://scep:8080/?operation=GetNextCACert", http.NoBody),
+			},
+			want: request{
+				Operation: "GetNextCACert",
+				Message:   []byte{},
+			},
+			wantErr: false,
+		},
 		{
 			name: "ok/get-PKIOperation",
 			args: args{
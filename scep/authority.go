@@ -1,20 +1,24 @@
 package scep
 
 import (
+	"bytes"
 	"context"
 	"crypto"
 	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
 	"errors"
 	"fmt"
+	"slices"
 	"sync"
 
+	"github.com/smallstep/nosql/database"
 	"github.com/smallstep/pkcs7"
 	smallscep "github.com/smallstep/scep"
 	smallscepx509util "github.com/smallstep/scep/x509util"
 
-	"go.step.sm/crypto/x509util"
-
 	"github.com/smallstep/certificates/authority/provisioner"
+	"github.com/smallstep/certificates/db"
 )
 
 // Authority is the layer that handles all SCEP interactions.
@@ -27,6 +31,8 @@ type Authority struct {
 	defaultDecrypter     crypto.Decrypter
 	decrypterCertificate *x509.Certificate
 	scepProvisionerNames []string
+	db                   db.AuthDB
+	getCRL               func() ([]byte, error)
 
 	provisionersMutex        sync.RWMutex
 	encryptionAlgorithmMutex sync.Mutex
@@ -79,6 +85,8 @@ func New(signAuth SignAuthority, opts Options) (*Authority, error) {
 		defaultDecrypter:     opts.Decrypter,
 		decrypterCertificate: opts.SignerCert, // the intermediate signer cert is also the decrypter cert (if RSA)
 		scepProvisionerNames: opts.SCEPProvisionerNames,
+		db:                   opts.DB,
+		getCRL:               opts.GetCRL,
 	}, nil
 }
 
@@ -232,8 +240,20 @@ func (a *Authority) DecryptPKIEnvelope(ctx context.Context, msg *PKIMessage) err
 			ChallengePassword: cp,
 		}
 		return nil
-	case smallscep.GetCRL, smallscep.GetCert, smallscep.CertPoll:
-		return errors.New("not implemented")
+	case smallscep.GetCRL, smallscep.GetCert:
+		var ias IssuerAndSerialNumber
+		if _, err := asn1.Unmarshal(msg.pkiEnvelope, &ias); err != nil {
+			return fmt.Errorf("parse issuer and serial number from pkiEnvelope: %w", err)
+		}
+		msg.IssuerAndSerialNumber = &ias
+		return nil
+	case smallscep.CertPoll:
+		var ias IssuerAndSubject
+		if _, err := asn1.Unmarshal(msg.pkiEnvelope, &ias); err != nil {
+			return fmt.Errorf("parse issuer and subject from pkiEnvelope: %w", err)
+		}
+		msg.IssuerAndSubject = &ias
+		return nil
 	}
 
 	return nil
@@ -242,13 +262,6 @@ func (a *Authority) DecryptPKIEnvelope(ctx context.Context, msg *PKIMessage) err
 // SignCSR creates an x509.Certificate based on a CSR template and Cert Authority credentials
 // returns a new PKIMessage with CertRep data
 func (a *Authority) SignCSR(ctx context.Context, csr *x509.CertificateRequest, msg *PKIMessage, signCSROpts ...provisioner.SignCSROption) (*PKIMessage, error) {
-	// TODO: intermediate storage of the request? In SCEP it's possible to request a csr/certificate
-	// to be signed, which can be performed asynchronously / out-of-band. In that case a client can
-	// poll for the status. It seems to be similar as what can happen in ACME, so might want to model
-	// the implementation after the one in the ACME authority. Requires storage, etc.
-
-	p := provisionerFromContext(ctx)
-
 	// check if CSRReqMessage has already been decrypted
 	if msg.CSRReqMessage.CSR == nil {
 		if err := a.DecryptPKIEnvelope(ctx, msg); err != nil {
@@ -257,108 +270,107 @@ func (a *Authority) SignCSR(ctx context.Context, csr *x509.CertificateRequest, m
 		csr = msg.CSRReqMessage.CSR
 	}
 
-	// Template data
-	sans := []string{}
-	sans = append(sans, csr.DNSNames...)
-	sans = append(sans, csr.EmailAddresses...)
-	for _, v := range csr.IPAddresses {
-		sans = append(sans, v.String())
-	}
-	for _, v := range csr.URIs {
-		sans = append(sans, v.String())
-	}
-	if len(sans) == 0 {
-		sans = append(sans, csr.Subject.CommonName)
-	}
-	data := x509util.CreateTemplateData(csr.Subject.CommonName, sans)
-	data.SetCertificateRequest(csr)
-	data.SetSubject(x509util.Subject{
-		Country:            csr.Subject.Country,
-		Organization:       csr.Subject.Organization,
-		OrganizationalUnit: csr.Subject.OrganizationalUnit,
-		Locality:           csr.Subject.Locality,
-		Province:           csr.Subject.Province,
-		StreetAddress:      csr.Subject.StreetAddress,
-		PostalCode:         csr.Subject.PostalCode,
-		SerialNumber:       csr.Subject.SerialNumber,
-		CommonName:         csr.Subject.CommonName,
-	})
-
-	// Apply CSR options. Currently only one option is defined.
-	for _, o := range signCSROpts {
-		if m, ok := o.(provisioner.TemplateDataModifier); ok {
-			m.Modify(data)
-		}
-	}
-
-	// Get authorizations from the SCEP provisioner.
-	ctx = provisioner.NewContextWithMethod(ctx, provisioner.SignMethod)
-	signOps, err := p.AuthorizeSign(ctx, "")
+	cert, err := a.signCertificate(ctx, csr, signCSROpts...)
 	if err != nil {
-		return nil, fmt.Errorf("error retrieving authorization options from SCEP provisioner: %w", err)
-	}
-	// Unlike most of the provisioners, scep's AuthorizeSign method doesn't
-	// define the templates, and the template data used in WebHooks is not
-	// available.
-	for _, signOp := range signOps {
-		if wc, ok := signOp.(*provisioner.WebhookController); ok {
-			wc.TemplateData = data
-		}
+		return nil, err
 	}
 
-	opts := provisioner.SignOptions{}
-	templateOptions, err := provisioner.TemplateOptions(p.GetOptions(), data)
+	return a.createCertRep(ctx, msg, cert)
+}
+
+// signCertificate signs the CSR using the SCEP provisioner in the context.
+func (a *Authority) signCertificate(ctx context.Context, csr *x509.CertificateRequest, signCSROpts ...provisioner.SignCSROption) (*x509.Certificate, error) {
+	p := provisionerFromContext(ctx)
+
+	ctx = provisioner.NewContextWithMethod(ctx, provisioner.SignMethod)
+	signOps, err := provisioner.CertificateRequestSignOptions(ctx, p, p.GetOptions(), csr, signCSROpts...)
 	if err != nil {
-		return nil, fmt.Errorf("error creating template options from SCEP provisioner: %w", err)
+		return nil, err
 	}
-	signOps = append(signOps, templateOptions)
 
-	certChain, err := a.signAuth.SignWithContext(ctx, csr, opts, signOps...)
+	certChain, err := a.signAuth.SignWithContext(ctx, csr, provisioner.SignOptions{}, signOps...)
 	if err != nil {
 		return nil, fmt.Errorf("error generating certificate: %w", err)
 	}
 
 	// take the issued certificate (only); https://tools.ietf.org/html/rfc8894#section-3.3.2
-	cert := certChain[0]
+	return certChain[0], nil
+}
 
-	// and create a degenerate cert structure
+// createCertRep creates a successful CertRep message with the given
+// certificate.
+func (a *Authority) createCertRep(ctx context.Context, msg *PKIMessage, cert *x509.Certificate) (*PKIMessage, error) {
+	// create a degenerate cert structure
 	deg, err := smallscep.DegenerateCertificates([]*x509.Certificate{cert})
 	if err != nil {
 		return nil, fmt.Errorf("failed generating degenerate certificate: %w", err)
 	}
 
+	return a.createSuccessResponse(ctx, msg, deg, cert)
+}
+
+// createSuccessResponse creates a successful CertRep message with the given
+// degenerate PKCS#7 structure, encrypted for the client. If a certificate is
+// given, it's added to the signed data too.
+func (a *Authority) createSuccessResponse(ctx context.Context, msg *PKIMessage, deg []byte, cert *x509.Certificate) (*PKIMessage, error) {
+	p := provisionerFromContext(ctx)
+
 	e7, err := a.encrypt(deg, msg.P7.Certificates, p.GetContentEncryptionAlgorithm())
 	if err != nil {
 		return nil, fmt.Errorf("failed encrypting degenerate certificate: %w", err)
 	}
 
 	// PKIMessageAttributes to be signed
-	config := pkcs7.SignerInfoConfig{
-		ExtraSignedAttributes: []pkcs7.Attribute{
-			{
-				Type:  oidSCEPtransactionID,
-				Value: msg.TransactionID,
-			},
-			{
-				Type:  oidSCEPpkiStatus,
-				Value: smallscep.SUCCESS,
-			},
-			{
-				Type:  oidSCEPmessageType,
-				Value: smallscep.CertRep,
-			},
-			{
-				Type:  oidSCEPrecipientNonce,
-				Value: msg.SenderNonce,
-			},
-			{
-				Type:  oidSCEPsenderNonce,
-				Value: msg.SenderNonce,
-			},
+	attributes := []pkcs7.Attribute{
+		{
+			Type:  oidSCEPtransactionID,
+			Value: msg.TransactionID,
+		},
+		{
+			Type:  oidSCEPpkiStatus,
+			Value: smallscep.SUCCESS,
+		},
+		{
+			Type:  oidSCEPmessageType,
+			Value: smallscep.CertRep,
+		},
+		{
+			Type:  oidSCEPrecipientNonce,
+			Value: msg.SenderNonce,
+		},
+		{
+			Type:  oidSCEPsenderNonce,
+			Value: msg.SenderNonce,
 		},
 	}
 
-	signedData, err := pkcs7.NewSignedData(e7)
+	certRepBytes, err := a.signCertRep(ctx, e7, cert, attributes)
+	if err != nil {
+		return nil, err
+	}
+
+	cr := &CertRepMessage{
+		PKIStatus:      smallscep.SUCCESS,
+		RecipientNonce: smallscep.RecipientNonce(msg.SenderNonce),
+		Certificate:    cert,
+		degenerate:     deg,
+	}
+
+	// create a CertRep message from the original
+	crepMsg := &PKIMessage{
+		Raw:            certRepBytes,
+		TransactionID:  msg.TransactionID,
+		MessageType:    smallscep.CertRep,
+		CertRepMessage: cr,
+	}
+
+	return crepMsg, nil
+}
+
+// signCertRep creates the signed data of a CertRep message with the given
+// content and signed attributes.
+func (a *Authority) signCertRep(ctx context.Context, content []byte, cert *x509.Certificate, attributes []pkcs7.Attribute) ([]byte, error) {
+	signedData, err := pkcs7.NewSignedData(content)
 	if err != nil {
 		return nil, err
 	}
@@ -366,7 +378,9 @@ func (a *Authority) SignCSR(ctx context.Context, csr *x509.CertificateRequest, m
 	// add the certificate into the signed data type
 	// this cert must be added before the signedData because the recipient will expect it
 	// as the first certificate in the array
-	signedData.AddCertificate(cert)
+	if cert != nil {
+		signedData.AddCertificate(cert)
+	}
 
 	signerCert, signer, err := a.selectSigner(ctx)
 	if err != nil {
@@ -374,31 +388,108 @@ func (a *Authority) SignCSR(ctx context.Context, csr *x509.CertificateRequest, m
 	}
 
 	// sign the attributes
+	config := pkcs7.SignerInfoConfig{
+		ExtraSignedAttributes: attributes,
+	}
 	if err := signedData.AddSigner(signerCert, signer, config); err != nil {
 		return nil, err
 	}
 
-	certRepBytes, err := signedData.Finish()
-	if err != nil {
-		return nil, err
+	return signedData.Finish()
+}
+
+// GetCert returns a CertRep message with the certificate identified by the
+// issuer and serial number of a GetCert message, RFC 8894 section 3.3.4.
+func (a *Authority) GetCert(ctx context.Context, msg *PKIMessage) (*PKIMessage, error) {
+	ias := msg.IssuerAndSerialNumber
+	if ias == nil {
+		return nil, errors.New("GetCert message does not contain an issuer and serial number")
+	}
+	if a.db == nil {
+		return nil, errors.New("GetCert requires a database")
 	}
 
-	cr := &CertRepMessage{
-		PKIStatus:      smallscep.SUCCESS,
-		RecipientNonce: smallscep.RecipientNonce(msg.SenderNonce),
-		Certificate:    cert,
-		degenerate:     deg,
+	serialNumber := ias.SerialNumber.String()
+	cert, err := a.db.GetCertificate(serialNumber)
+	switch {
+	case database.IsErrNotFound(err):
+		return nil, newFailInfo(smallscep.BadCertID, "certificate %s not found", serialNumber)
+	case err != nil:
+		return nil, fmt.Errorf("error retrieving certificate %s: %w", serialNumber, err)
+	case !bytes.Equal(cert.RawIssuer, ias.Issuer.FullBytes):
+		return nil, newFailInfo(smallscep.BadCertID, "certificate %s not found", serialNumber)
 	}
 
-	// create a CertRep message from the original
-	crepMsg := &PKIMessage{
-		Raw:            certRepBytes,
-		TransactionID:  msg.TransactionID,
-		MessageType:    smallscep.CertRep,
-		CertRepMessage: cr,
+	return a.createCertRep(ctx, msg, cert)
+}
+
+// GetCRL returns a CertRep message with the current CRL of the CA that
+// issued the certificate in a GetCRL message, RFC 8894 section 3.3.4.
+func (a *Authority) GetCRL(ctx context.Context, msg *PKIMessage) (*PKIMessage, error) {
+	ias := msg.IssuerAndSerialNumber
+	if ias == nil {
+		return nil, errors.New("GetCRL message does not contain an issuer and serial number")
+	}
+	if !a.isIssuer(ias.Issuer.FullBytes) {
+		return nil, newFailInfo(smallscep.BadCertID, "certificate %s was not issued by this CA", ias.SerialNumber)
+	}
+	if a.getCRL == nil {
+		return nil, newFailInfo(smallscep.BadRequest, "CRL is not available")
 	}
 
-	return crepMsg, nil
+	crl, err := a.getCRL()
+	if err != nil {
+		return nil, fmt.Errorf("error retrieving CRL: %w", err)
+	}
+	deg, err := degenerateCRL(crl)
+	if err != nil {
+		return nil, fmt.Errorf("failed generating degenerate CRL: %w", err)
+	}
+
+	return a.createSuccessResponse(ctx, msg, deg, nil)
+}
+
+// isIssuer returns true if the given name is the subject of one of the
+// intermediates of the CA.
+func (a *Authority) isIssuer(name []byte) bool {
+	for _, crt := range a.intermediates {
+		if bytes.Equal(crt.RawSubject, name) {
+			return true
+		}
+	}
+	return false
+}
+
+type contentInfo struct {
+	ContentType asn1.ObjectIdentifier
+	Content     asn1.RawValue `asn1:"explicit,optional,tag:0"`
+}
+
+type degenerateSignedData struct {
+	Version                    int                        `asn1:"default:1"`
+	DigestAlgorithmIdentifiers []pkix.AlgorithmIdentifier `asn1:"set"`
+	ContentInfo                contentInfo
+	CRLs                       []asn1.RawValue `asn1:"optional,tag:1"`
+	SignerInfos                []asn1.RawValue `asn1:"set"`
+}
+
+// degenerateCRL creates a degenerate PKCS#7 signed data structure containing
+// only the given CRL.
+func degenerateCRL(crl []byte) ([]byte, error) {
+	content, err := asn1.Marshal(degenerateSignedData{
+		Version:                    1,
+		DigestAlgorithmIdentifiers: []pkix.AlgorithmIdentifier{},
+		ContentInfo:                contentInfo{ContentType: pkcs7.OIDData},
+		CRLs:                       []asn1.RawValue{{FullBytes: crl}},
+		SignerInfos:                []asn1.RawValue{},
+	})
+	if err != nil {
+		return nil, err
+	}
+	return asn1.Marshal(contentInfo{
+		ContentType: pkcs7.OIDSignedData,
+		Content:     asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, Bytes: content, IsCompound: true},
+	})
 }
 
 func (a *Authority) encrypt(content []byte, recipients []*x509.Certificate, algorithm int) ([]byte, error) {
@@ -425,55 +516,38 @@ func (a *Authority) encrypt(content []byte, recipients []*x509.Certificate, algo
 
 // CreateFailureResponse creates an appropriately signed reply for PKI operations
 func (a *Authority) CreateFailureResponse(ctx context.Context, _ *x509.CertificateRequest, msg *PKIMessage, info FailInfoName, infoText string) (*PKIMessage, error) {
-	config := pkcs7.SignerInfoConfig{
-		ExtraSignedAttributes: []pkcs7.Attribute{
-			{
-				Type:  oidSCEPtransactionID,
-				Value: msg.TransactionID,
-			},
-			{
-				Type:  oidSCEPpkiStatus,
-				Value: smallscep.FAILURE,
-			},
-			{
-				Type:  oidSCEPfailInfo,
-				Value: info,
-			},
-			{
-				Type:  oidSCEPfailInfoText,
-				Value: infoText,
-			},
-			{
-				Type:  oidSCEPmessageType,
-				Value: smallscep.CertRep,
-			},
-			{
-				Type:  oidSCEPsenderNonce,
-				Value: msg.SenderNonce,
-			},
-			{
-				Type:  oidSCEPrecipientNonce,
-				Value: msg.SenderNonce,
-			},
+	attributes := []pkcs7.Attribute{
+		{
+			Type:  oidSCEPtransactionID,
+			Value: msg.TransactionID,
+		},
+		{
+			Type:  oidSCEPpkiStatus,
+			Value: smallscep.FAILURE,
+		},
+		{
+			Type:  oidSCEPfailInfo,
+			Value: info,
+		},
+		{
+			Type:  oidSCEPfailInfoText,
+			Value: infoText,
+		},
+		{
+			Type:  oidSCEPmessageType,
+			Value: smallscep.CertRep,
+		},
+		{
+			Type:  oidSCEPsenderNonce,
+			Value: msg.SenderNonce,
+		},
+		{
+			Type:  oidSCEPrecipientNonce,
+			Value: msg.SenderNonce,
 		},
 	}
 
-	signedData, err := pkcs7.NewSignedData(nil)
-	if err != nil {
-		return nil, err
-	}
-
-	signerCert, signer, err := a.selectSigner(ctx)
-	if err != nil {
-		return nil, fmt.Errorf("failed selecting signer: %w", err)
-	}
-
-	// sign the attributes
-	if err := signedData.AddSigner(signerCert, signer, config); err != nil {
-		return nil, err
-	}
-
-	certRepBytes, err := signedData.Finish()
+	certRepBytes, err := a.signCertRep(ctx, nil, nil, attributes)
 	if err != nil {
 		return nil, err
 	}
@@ -501,7 +575,13 @@ func (a *Authority) GetCACaps(ctx context.Context) []string {
 
 	caps := p.GetCapabilities()
 	if len(caps) == 0 {
-		return defaultCapabilities
+		caps = defaultCapabilities
+	}
+
+	// advertise the GetNextCACert operation if the provisioner is configured
+	// with the certificates of a CA rollover.
+	if len(p.GetNextCACertificates()) > 0 && !slices.Contains(caps, "GetNextCACert") {
+		caps = append(slices.Clone(caps), "GetNextCACert")
 	}
 
 	// TODO: validate the caps? Ensure they are the right format according to RFC?
@@ -513,8 +593,60 @@ func (a *Authority) GetCACaps(ctx context.Context) []string {
 	return caps
 }
 
+// GetNextCACert returns the certificates that will be used after a CA
+// rollover as a degenerate certificates-only PKCS#7 signed with the current
+// signer, RFC 8894 section 4.7.
+func (a *Authority) GetNextCACert(ctx context.Context) ([]byte, error) {
+	p := provisionerFromContext(ctx)
+	certs := p.GetNextCACertificates()
+	if len(certs) == 0 {
+		return nil, fmt.Errorf("provisioner %q does not have a next CA certificate", p.GetName())
+	}
+
+	deg, err := smallscep.DegenerateCertificates(certs)
+	if err != nil {
+		return nil, fmt.Errorf("failed creating degenerate certificates: %w", err)
+	}
+
+	signedData, err := pkcs7.NewSignedData(deg)
+	if err != nil {
+		return nil, err
+	}
+
+	signerCert, signer, err := a.selectSigner(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed selecting signer: %w", err)
+	}
+	if err := signedData.AddSigner(signerCert, signer, pkcs7.SignerInfoConfig{}); err != nil {
+		return nil, err
+	}
+
+	return signedData.Finish()
+}
+
+// ValidateChallenge validates the challenge of a certificate request. If the
+// provisioner uses dynamic challenges, and the challenge is in the challenge
+// store, the challenge is consumed and the names bound to it are enforced
+// when the certificate is signed. Other challenges are validated by the
+// provisioner.
+//
+// If the request requires approval, the options are returned with
+// provisioner.ErrSCEPRequestPending, so the names bound to the challenge can
+// be stored with the pending request.
 func (a *Authority) ValidateChallenge(ctx context.Context, csr *x509.CertificateRequest, challenge, transactionID string) ([]provisioner.SignCSROption, error) {
 	p := provisionerFromContext(ctx)
+	if challenge != "" && p.ShouldUseDynamicChallenges() {
+		opts, err := a.useDynamicChallenge(ctx, csr, challenge)
+		switch {
+		case err == nil:
+			if p.ShouldRequireApproval() {
+				return opts, provisioner.ErrSCEPRequestPending
+			}
+			return opts, nil
+		case !errors.Is(err, errChallengeNotFound):
+			return nil, err
+		}
+	}
 	return p.ValidateChallenge(ctx, csr, challenge, transactionID)
 }
 
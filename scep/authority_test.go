@@ -100,6 +100,15 @@ func (s *signAuthority) SignWithContext(ctx context.Context, cr *x509.Certificat
 	if err != nil {
 		return nil, err
 	}
+	// Only the names bound to the challenges are validated, the certificate
+	// is not modified by the sign options.
+	for _, so := range signOpts {
+		if v, ok := so.(*boundNamesValidator); ok {
+			if err := v.Valid(c.GetCertificate(), opts); err != nil {
+				return nil, err
+			}
+		}
+	}
 	crt, err := s.ca.Sign(c.GetCertificate())
 	if err != nil {
 		return nil, err
@@ -247,3 +256,45 @@ func TestAuthority_SignCSR(t *testing.T) {
 		})
 	}
 }
+
+func TestAuthority_GetNextCACert(t *testing.T) {
+	a, ctx, ca := newTestAuthority(t, nil, nil)
+
+	nextCA, err := minica.New()
+	require.NoError(t, err)
+	key, err := keyutil.GenerateSigner("RSA", "", 2048)
+	require.NoError(t, err)
+	nextRA, err := nextCA.Sign(&x509.Certificate{
+		Subject:   pkix.Name{CommonName: "Next SCEP RA"},
+		PublicKey: key.Public(),
+	})
+	require.NoError(t, err)
+
+	p := &provisioner.SCEP{
+		Name:              "scep",
+		Type:              "SCEP",
+		ChallengePassword: "password",
+		NextCACertificate: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: nextCA.Intermediate.Raw}),
+		NextRACertificate: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: nextRA.Raw}),
+	}
+	require.NoError(t, p.Init(provisioner.Config{Claims: config.GlobalProvisionerClaims}))
+	nextCtx := NewProvisionerContext(context.Background(), p)
+
+	data, err := a.GetNextCACert(nextCtx)
+	require.NoError(t, err)
+	p7, err := pkcs7.Parse(data)
+	require.NoError(t, err)
+	require.NoError(t, p7.Verify())
+	assert.Equal(t, ca.Intermediate, p7.GetOnlySigner())
+	certs, err := scep.CACerts(p7.Content)
+	require.NoError(t, err)
+	assert.Equal(t, []*x509.Certificate{nextRA, nextCA.Intermediate}, certs)
+
+	assert.Contains(t, a.GetCACaps(nextCtx), "GetNextCACert")
+	assert.NotContains(t, defaultCapabilities, "GetNextCACert")
+
+	// The provisioner does not have a next CA certificate.
+	_, err = a.GetNextCACert(ctx)
+	assert.Error(t, err)
+	assert.NotContains(t, a.GetCACaps(ctx), "GetNextCACert")
+}
@@ -0,0 +1,131 @@
+package scep
+
+import (
+	"context"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"slices"
+
+	"github.com/smallstep/nosql/database"
+	smallscep "github.com/smallstep/scep"
+	"go.step.sm/crypto/x509util"
+
+	"github.com/smallstep/certificates/authority/provisioner"
+	"github.com/smallstep/certificates/db"
+)
+
+// errChallengeNotFound is returned when a challenge is not in the challenge
+// store, so it must be validated by the provisioner.
+var errChallengeNotFound = errors.New("scep challenge not found")
+
+// useDynamicChallenge consumes a challenge in the challenge store. The names
+// bound to the challenge are checked against the certificate request before
+// the challenge is consumed, and the returned options enforce them in the
+// issued certificate.
+func (a *Authority) useDynamicChallenge(ctx context.Context, csr *x509.CertificateRequest, challenge string) ([]provisioner.SignCSROption, error) {
+	cdb, ok := a.db.(db.SCEPChallengeDB)
+	if !ok {
+		return nil, errChallengeNotFound
+	}
+
+	p := provisionerFromContext(ctx)
+	id := db.SCEPChallengeID(challenge)
+	ch, err := cdb.GetSCEPChallenge(p.GetID(), id)
+	switch {
+	case database.IsErrNotFound(err):
+		return nil, errChallengeNotFound
+	case err != nil:
+		return nil, fmt.Errorf("error retrieving challenge: %w", err)
+	}
+
+	v := &boundNamesValidator{
+		commonName: ch.Subject,
+		sans:       ch.SANs,
+	}
+	if err := v.validate(csr.Subject.CommonName, requestSANs(csr)); err != nil {
+		return nil, err
+	}
+	switch _, err := cdb.UseSCEPChallenge(p.GetID(), id); {
+	case errors.Is(err, db.ErrSCEPChallengeExhausted):
+		return nil, newFailInfo(smallscep.BadRequest, "%v", err)
+	case err != nil:
+		return nil, fmt.Errorf("error using challenge: %w", err)
+	}
+
+	return []provisioner.SignCSROption{v}, nil
+}
+
+// challengeSignCSROptions returns the options that enforce the names bound to
+// the dynamic challenge of a pending request, and add the data of the
+// webhooks that validated its challenge to the template data.
+func challengeSignCSROptions(req *db.SCEPRequest) []provisioner.SignCSROption {
+	var opts []provisioner.SignCSROption
+	if req.ChallengeSubject != "" || len(req.ChallengeSANs) > 0 {
+		opts = append(opts, &boundNamesValidator{
+			commonName: req.ChallengeSubject,
+			sans:       req.ChallengeSANs,
+		})
+	}
+	if len(req.WebhookData) > 0 {
+		opts = append(opts, provisioner.TemplateDataModifierFunc(func(data x509util.TemplateData) {
+			for name, v := range req.WebhookData {
+				data.SetWebhook(name, v)
+			}
+		}))
+	}
+	return opts
+}
+
+// boundNamesValidator validates that the common name and the subject
+// alternative names of a certificate are the ones bound to a challenge.
+type boundNamesValidator struct {
+	commonName string
+	sans       []string
+}
+
+// Valid implements provisioner.CertificateValidator.
+func (v *boundNamesValidator) Valid(cert *x509.Certificate, _ provisioner.SignOptions) error {
+	return v.validate(cert.Subject.CommonName, sanStrings(cert.DNSNames, cert.IPAddresses, cert.EmailAddresses, cert.URIs))
+}
+
+func (v *boundNamesValidator) validate(commonName string, sans []string) error {
+	if v.commonName != "" && commonName != v.commonName {
+		return fmt.Errorf("common name %q does not match the challenge subject %q", commonName, v.commonName)
+	}
+	if len(v.sans) > 0 {
+		want := sanStrings(x509util.SplitSANs(v.sans))
+		slices.Sort(want)
+		got := slices.Clone(sans)
+		slices.Sort(got)
+		if !slices.Equal(slices.Compact(got), slices.Compact(want)) {
+			return fmt.Errorf("subject alternative names %v do not match the challenge subject alternative names %v", sans, v.sans)
+		}
+	}
+	return nil
+}
+
+// requestSANs returns the subject alternative names of the certificate
+// issued for the request. As in signCertificate, the common name is used if
+// the request does not have any.
+func requestSANs(csr *x509.CertificateRequest) []string {
+	sans := sanStrings(csr.DNSNames, csr.IPAddresses, csr.EmailAddresses, csr.URIs)
+	if len(sans) == 0 {
+		sans = append(sans, csr.Subject.CommonName)
+	}
+	return sans
+}
+
+func sanStrings(dnsNames []string, ips []net.IP, emails []string, uris []*url.URL) []string {
+	sans := slices.Clone(dnsNames)
+	sans = append(sans, emails...)
+	for _, ip := range ips {
+		sans = append(sans, ip.String())
+	}
+	for _, u := range uris {
+		sans = append(sans, u.String())
+	}
+	return sans
+}
@@ -0,0 +1,140 @@
+package scep
+
+import (
+	"context"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/smallstep/nosql/database"
+	smallscep "github.com/smallstep/scep"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.step.sm/crypto/keyutil"
+	"go.step.sm/crypto/x509util"
+
+	"github.com/smallstep/certificates/authority/config"
+	"github.com/smallstep/certificates/authority/provisioner"
+	"github.com/smallstep/certificates/db"
+)
+
+func TestAuthority_ValidateChallenge_dynamic(t *testing.T) {
+	key, err := keyutil.GenerateDefaultSigner()
+	require.NoError(t, err)
+	csr, err := x509util.CreateCertificateRequest("router1", []string{"router1.example.com"}, key)
+	require.NoError(t, err)
+
+	now := time.Now()
+	challenges := map[string]*db.SCEPChallenge{
+		db.SCEPChallengeID("bound"):     {Subject: "router1", SANs: []string{"router1.example.com"}, MaxUses: 1, ExpiresAt: now.Add(time.Hour)},
+		db.SCEPChallengeID("mismatch"):  {Subject: "router2", MaxUses: 1, ExpiresAt: now.Add(time.Hour)},
+		db.SCEPChallengeID("exhausted"): {MaxUses: 1, Uses: 1, ExpiresAt: now.Add(time.Hour)},
+	}
+	var used []string
+	mockDB := &db.MockAuthDB{
+		MGetSCEPChallenge: func(provisionerID, id string) (*db.SCEPChallenge, error) {
+			assert.Equal(t, "scep/scep", provisionerID)
+			if ch, ok := challenges[id]; ok {
+				return ch, nil
+			}
+			return nil, database.ErrNotFound
+		},
+		MUseSCEPChallenge: func(provisionerID, id string) (*db.SCEPChallenge, error) {
+			ch := challenges[id]
+			if ch.Uses >= ch.MaxUses {
+				return nil, db.ErrSCEPChallengeExhausted
+			}
+			used = append(used, id)
+			ch.Uses++
+			return ch, nil
+		},
+	}
+
+	newContext := func(p *provisioner.SCEP) context.Context {
+		p.Name, p.Type = "scep", "SCEP"
+		require.NoError(t, p.Init(provisioner.Config{Claims: config.GlobalProvisionerClaims}))
+		return NewProvisionerContext(context.Background(), p)
+	}
+
+	a := &Authority{db: mockDB}
+	ctx := newContext(&provisioner.SCEP{ChallengePassword: "static", DynamicChallenges: true})
+
+	opts, err := a.ValidateChallenge(ctx, csr, "bound", "tx1")
+	require.NoError(t, err)
+	require.Len(t, opts, 1)
+	assert.Equal(t, &boundNamesValidator{commonName: "router1", sans: []string{"router1.example.com"}}, opts[0])
+	assert.Equal(t, []string{db.SCEPChallengeID("bound")}, used)
+
+	// Exhausted challenges are answered with a badRequest failure.
+	var fi *FailInfo
+	_, err = a.ValidateChallenge(ctx, csr, "bound", "tx2")
+	require.ErrorAs(t, err, &fi)
+	assert.Equal(t, FailInfoName(smallscep.BadRequest), fi.Name)
+	assert.Equal(t, db.ErrSCEPChallengeExhausted.Error(), fi.Text)
+	_, err = a.ValidateChallenge(ctx, csr, "exhausted", "tx3")
+	require.ErrorAs(t, err, &fi)
+	assert.Equal(t, FailInfoName(smallscep.BadRequest), fi.Name)
+
+	// The names are checked before the challenge is used.
+	_, err = a.ValidateChallenge(ctx, csr, "mismatch", "tx4")
+	assert.Error(t, err)
+	assert.Equal(t, 0, challenges[db.SCEPChallengeID("mismatch")].Uses)
+
+	// Other challenges are validated by the provisioner.
+	opts, err = a.ValidateChallenge(ctx, csr, "static", "tx5")
+	require.NoError(t, err)
+	assert.Empty(t, opts)
+	_, err = a.ValidateChallenge(ctx, csr, "other", "tx6")
+	assert.Error(t, err)
+	_, err = a.ValidateChallenge(newContext(&provisioner.SCEP{DynamicChallenges: true}), csr, "", "tx7")
+	assert.Error(t, err)
+
+	// Requests can still require approval, the bound names are returned to
+	// store them with the pending request.
+	challenges[db.SCEPChallengeID("approval")] = &db.SCEPChallenge{Subject: "router1", MaxUses: 1, ExpiresAt: now.Add(time.Hour)}
+	opts, err = a.ValidateChallenge(newContext(&provisioner.SCEP{DynamicChallenges: true, RequireApproval: true}), csr, "approval", "tx8")
+	assert.ErrorIs(t, err, provisioner.ErrSCEPRequestPending)
+	assert.Equal(t, []provisioner.SignCSROption{&boundNamesValidator{commonName: "router1"}}, opts)
+
+	// The challenge store is only used if dynamic challenges are enabled.
+	challenges[db.SCEPChallengeID("disabled")] = &db.SCEPChallenge{MaxUses: 1, ExpiresAt: now.Add(time.Hour)}
+	_, err = a.ValidateChallenge(newContext(&provisioner.SCEP{ChallengePassword: "static"}), csr, "disabled", "tx9")
+	assert.Error(t, err)
+	assert.Equal(t, 0, challenges[db.SCEPChallengeID("disabled")].Uses)
+
+	// Errors retrieving the challenge are not ignored.
+	a = &Authority{db: &db.MockAuthDB{Err: errors.New("force")}}
+	_, err = a.ValidateChallenge(ctx, csr, "static", "tx10")
+	assert.EqualError(t, err, "error retrieving challenge: force")
+}
+
+func TestBoundNamesValidator_Valid(t *testing.T) {
+	cert := &x509.Certificate{
+		Subject:     pkix.Name{CommonName: "router1"},
+		DNSNames:    []string{"router1.example.com"},
+		IPAddresses: []net.IP{net.ParseIP("10.0.0.1")},
+	}
+	tests := []struct {
+		name    string
+		v       *boundNamesValidator
+		wantErr bool
+	}{
+		{"ok/empty", &boundNamesValidator{}, false},
+		{"ok/subject", &boundNamesValidator{commonName: "router1"}, false},
+		{"ok/sans", &boundNamesValidator{sans: []string{"10.0.0.1", "router1.example.com"}}, false},
+		{"ok/both", &boundNamesValidator{commonName: "router1", sans: []string{"router1.example.com", "10.0.0.1", "router1.example.com"}}, false},
+		{"fail/subject", &boundNamesValidator{commonName: "router2"}, true},
+		{"fail/missing-san", &boundNamesValidator{sans: []string{"router1.example.com"}}, true},
+		{"fail/extra-san", &boundNamesValidator{sans: []string{"router1.example.com", "10.0.0.1", "router1@example.com"}}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.v.Valid(cert, provisioner.SignOptions{}); (err != nil) != tt.wantErr {
+				t.Errorf("boundNamesValidator.Valid() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -5,6 +5,8 @@ import (
 	"crypto/rsa"
 	"crypto/x509"
 	"errors"
+
+	"github.com/smallstep/certificates/db"
 )
 
 type Options struct {
@@ -26,6 +28,13 @@ type Options struct {
 	// are used to be able to load the provisioners when the SCEP authority is being
 	// validated.
 	SCEPProvisionerNames []string
+	// DB is the authority database. It's used to answer GetCert requests and,
+	// if it implements db.SCEPRequestDB, to store the requests pending
+	// approval.
+	DB db.AuthDB `json:"-"`
+	// GetCRL returns the current CRL of the CA in DER format. It's used to
+	// answer GetCRL requests.
+	GetCRL func() ([]byte, error) `json:"-"`
 }
 
 type comparablePublicKey interface {
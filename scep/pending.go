@@ -0,0 +1,213 @@
+package scep
+
+import (
+	"context"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/smallstep/nosql/database"
+	"github.com/smallstep/pkcs7"
+	smallscep "github.com/smallstep/scep"
+	"go.step.sm/crypto/x509util"
+
+	"github.com/smallstep/certificates/authority/provisioner"
+	"github.com/smallstep/certificates/db"
+)
+
+// requestDB returns the database used to store the pending requests.
+func (a *Authority) requestDB() (db.SCEPRequestDB, error) {
+	if rdb, ok := a.db.(db.SCEPRequestDB); ok {
+		return rdb, nil
+	}
+	return nil, errors.New("database does not support pending SCEP requests")
+}
+
+// CreatePendingRequest stores the certificate request of a PKCSReq or
+// RenewalReq message in the pending queue and returns a CertRep message with
+// the PENDING status. The names bound to the dynamic challenge and the
+// webhook data in the options returned by ValidateChallenge are stored with
+// the request, and used when the certificate is issued. If the client resends a request that is already
+// in the queue, it's answered as if it was a CertPoll message.
+func (a *Authority) CreatePendingRequest(ctx context.Context, csr *x509.CertificateRequest, msg *PKIMessage, signCSROpts ...provisioner.SignCSROption) (*PKIMessage, error) {
+	rdb, err := a.requestDB()
+	if err != nil {
+		return nil, err
+	}
+
+	p := provisionerFromContext(ctx)
+	now := time.Now().UTC()
+	req := &db.SCEPRequest{
+		ProvisionerID: p.GetID(),
+		TransactionID: string(msg.TransactionID),
+		Subject:       csr.Subject.CommonName,
+		CSR:           csr.Raw,
+		Status:        db.SCEPRequestPending,
+		CreatedAt:     now,
+		UpdatedAt:     now,
+	}
+	data := x509util.TemplateData{}
+	for _, o := range signCSROpts {
+		if v, ok := o.(*boundNamesValidator); ok {
+			req.ChallengeSubject = v.commonName
+			req.ChallengeSANs = v.sans
+		}
+		if m, ok := o.(provisioner.TemplateDataModifier); ok {
+			m.Modify(data)
+		}
+	}
+	if webhooks, ok := data[x509util.WebhooksKey].(map[string]any); ok {
+		req.WebhookData = webhooks
+	}
+	err = rdb.CreateSCEPRequest(req)
+	switch {
+	case errors.Is(err, db.ErrAlreadyExists):
+		return a.CertPoll(ctx, msg)
+	case err != nil:
+		return nil, fmt.Errorf("error storing pending request: %w", err)
+	}
+
+	return a.createPendingResponse(ctx, msg)
+}
+
+// CertPoll returns a CertRep message with the status of the pending request
+// with the transaction id of the message, RFC 8894 section 3.3.3. Once the
+// request is approved, the certificate is issued and returned in this and the
+// following polls. The message must be signed with the key in the original
+// request.
+func (a *Authority) CertPoll(ctx context.Context, msg *PKIMessage) (*PKIMessage, error) {
+	rdb, err := a.requestDB()
+	if err != nil {
+		return nil, err
+	}
+
+	p := provisionerFromContext(ctx)
+	transactionID := string(msg.TransactionID)
+	req, err := rdb.GetSCEPRequest(p.GetID(), transactionID)
+	switch {
+	case database.IsErrNotFound(err):
+		return nil, newFailInfo(smallscep.BadCertID, "request %s not found", transactionID)
+	case err != nil:
+		return nil, fmt.Errorf("error retrieving pending request %s: %w", transactionID, err)
+	}
+
+	csr, err := x509.ParseCertificateRequest(req.CSR)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing pending request %s: %w", transactionID, err)
+	}
+	signer := msg.P7.GetOnlySigner()
+	pub, ok := csr.PublicKey.(comparablePublicKey)
+	if signer == nil || !ok || !pub.Equal(signer.PublicKey) {
+		return nil, newFailInfo(smallscep.BadMessageCheck, "request %s is not signed with the key in the certificate request", transactionID)
+	}
+
+	switch req.Status {
+	case db.SCEPRequestPending:
+		return a.createPendingResponse(ctx, msg)
+	case db.SCEPRequestRejected:
+		if req.Reason != "" {
+			return nil, newFailInfo(smallscep.BadRequest, "request %s has been rejected: %s", transactionID, req.Reason)
+		}
+		return nil, newFailInfo(smallscep.BadRequest, "request %s has been rejected", transactionID)
+	case db.SCEPRequestApproved:
+		return a.issuePendingRequest(ctx, rdb, msg, req, csr)
+	case db.SCEPRequestIssued:
+		// The certificate is being issued by a concurrent poll.
+		if req.SerialNumber == "" {
+			return a.createPendingResponse(ctx, msg)
+		}
+		if a.db == nil {
+			return nil, errors.New("CertPoll requires a database")
+		}
+		cert, err := a.db.GetCertificate(req.SerialNumber)
+		if err != nil {
+			return nil, fmt.Errorf("error retrieving certificate %s: %w", req.SerialNumber, err)
+		}
+		return a.createCertRep(ctx, msg, cert)
+	default:
+		return nil, fmt.Errorf("pending request %s has an unexpected status %q", transactionID, req.Status)
+	}
+}
+
+// issuePendingRequest signs the certificate of an approved request. The
+// request is moved to the issued status before signing, so concurrent polls
+// cannot issue a second certificate, and it's moved back to the approved
+// status if the certificate cannot be signed.
+func (a *Authority) issuePendingRequest(ctx context.Context, rdb db.SCEPRequestDB, msg *PKIMessage, req *db.SCEPRequest, csr *x509.CertificateRequest) (*PKIMessage, error) {
+	p := provisionerFromContext(ctx)
+	transactionID := req.TransactionID
+
+	issuing := *req
+	issuing.Status = db.SCEPRequestIssued
+	issuing.UpdatedAt = time.Now().UTC()
+	switch err := rdb.UpdateSCEPRequest(req, &issuing); {
+	case errors.Is(err, db.ErrSCEPRequestChanged):
+		// Another poll got the request first, answer with the new status.
+		return a.CertPoll(ctx, msg)
+	case err != nil:
+		return nil, fmt.Errorf("error updating pending request %s: %w", transactionID, err)
+	}
+
+	cert, err := a.signCertificate(ctx, csr, challengeSignCSROptions(req)...)
+	if err != nil {
+		approved := issuing
+		approved.Status = db.SCEPRequestApproved
+		approved.UpdatedAt = time.Now().UTC()
+		if uerr := rdb.UpdateSCEPRequest(&issuing, &approved); uerr != nil {
+			err = errors.Join(err, fmt.Errorf("error updating pending request %s: %w", transactionID, uerr))
+		}
+		_ = p.NotifyFailure(ctx, csr, transactionID, 0, err.Error())
+		return nil, err
+	}
+
+	issued := issuing
+	issued.SerialNumber = cert.SerialNumber.String()
+	issued.UpdatedAt = time.Now().UTC()
+	if err := rdb.UpdateSCEPRequest(&issuing, &issued); err != nil {
+		return nil, fmt.Errorf("error updating pending request %s: %w", transactionID, err)
+	}
+	_ = p.NotifySuccess(ctx, csr, cert, transactionID)
+	return a.createCertRep(ctx, msg, cert)
+}
+
+// createPendingResponse creates a CertRep message with the PENDING status.
+func (a *Authority) createPendingResponse(ctx context.Context, msg *PKIMessage) (*PKIMessage, error) {
+	attributes := []pkcs7.Attribute{
+		{
+			Type:  oidSCEPtransactionID,
+			Value: msg.TransactionID,
+		},
+		{
+			Type:  oidSCEPpkiStatus,
+			Value: smallscep.PENDING,
+		},
+		{
+			Type:  oidSCEPmessageType,
+			Value: smallscep.CertRep,
+		},
+		{
+			Type:  oidSCEPsenderNonce,
+			Value: msg.SenderNonce,
+		},
+		{
+			Type:  oidSCEPrecipientNonce,
+			Value: msg.SenderNonce,
+		},
+	}
+
+	certRepBytes, err := a.signCertRep(ctx, nil, nil, attributes)
+	if err != nil {
+		return nil, err
+	}
+
+	return &PKIMessage{
+		Raw:           certRepBytes,
+		TransactionID: msg.TransactionID,
+		MessageType:   smallscep.CertRep,
+		CertRepMessage: &CertRepMessage{
+			PKIStatus:      smallscep.PENDING,
+			RecipientNonce: smallscep.RecipientNonce(msg.SenderNonce),
+		},
+	}, nil
+}
@@ -0,0 +1,469 @@
+package scep
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"math/big"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/smallstep/nosql/database"
+	"github.com/smallstep/pkcs7"
+	smallscep "github.com/smallstep/scep"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.step.sm/crypto/minica"
+	"go.step.sm/crypto/x509util"
+
+	"github.com/smallstep/certificates/authority/provisioner"
+	"github.com/smallstep/certificates/db"
+)
+
+// newTestAuthority returns a SCEP authority, and a context with its
+// provisioner, that uses the given database.
+func newTestAuthority(t *testing.T, authDB db.AuthDB, getCRL func() ([]byte, error)) (*Authority, context.Context, *minica.CA) {
+	t.Helper()
+	ca, err := minica.New(minica.WithGetSignerFunc(func() (crypto.Signer, error) {
+		return rsa.GenerateKey(rand.Reader, 2048)
+	}))
+	require.NoError(t, err)
+
+	a, err := New(&signAuthority{ca: ca}, Options{
+		Roots:                []*x509.Certificate{ca.Root},
+		Intermediates:        []*x509.Certificate{ca.Intermediate},
+		SignerCert:           ca.Intermediate,
+		Signer:               ca.Signer,
+		Decrypter:            ca.Signer.(*rsa.PrivateKey),
+		DecrypterCert:        ca.Intermediate,
+		SCEPProvisionerNames: []string{"scep"},
+		DB:                   authDB,
+		GetCRL:               getCRL,
+	})
+	require.NoError(t, err)
+
+	p, err := a.LoadProvisionerByName("scep")
+	require.NoError(t, err)
+	return a, NewProvisionerContext(context.Background(), p.(*provisioner.SCEP)), ca
+}
+
+type testClient struct {
+	key  *rsa.PrivateKey
+	cert *x509.Certificate
+	csr  *x509.CertificateRequest
+}
+
+func newTestClient(t *testing.T) *testClient {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	csr, err := x509util.CreateCertificateRequest("router1", []string{"router1.example.com"}, key)
+	require.NoError(t, err)
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "router1"},
+		NotBefore:    time.Now(),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	b, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, key.Public(), key)
+	require.NoError(t, err)
+	cert, err := x509.ParseCertificate(b)
+	require.NoError(t, err)
+	return &testClient{key: key, cert: cert, csr: csr}
+}
+
+// message returns a message signed by the client with the given
+// transaction id and encrypted content. If content is nil, the CSR is used.
+func (c *testClient) message(t *testing.T, msgType smallscep.MessageType, transactionID string, content any, recipient *x509.Certificate) *PKIMessage {
+	t.Helper()
+	b := c.csr.Raw
+	if content != nil {
+		var err error
+		b, err = asn1.Marshal(content)
+		require.NoError(t, err)
+	}
+	e7, err := pkcs7.Encrypt(b, []*x509.Certificate{recipient})
+	require.NoError(t, err)
+	sd, err := pkcs7.NewSignedData(e7)
+	require.NoError(t, err)
+	require.NoError(t, sd.AddSigner(c.cert, c.key, pkcs7.SignerInfoConfig{}))
+	raw, err := sd.Finish()
+	require.NoError(t, err)
+	p7, err := pkcs7.Parse(raw)
+	require.NoError(t, err)
+	return &PKIMessage{
+		TransactionID: smallscep.TransactionID(transactionID),
+		MessageType:   msgType,
+		SenderNonce:   smallscep.SenderNonce("nonce"),
+		Raw:           raw,
+		P7:            p7,
+	}
+}
+
+// decrypt returns the degenerate PKCS#7 structure in a successful CertRep.
+func (c *testClient) decrypt(t *testing.T, certRep *PKIMessage) *pkcs7.PKCS7 {
+	t.Helper()
+	require.Equal(t, smallscep.SUCCESS, certRep.PKIStatus)
+	p7, err := pkcs7.Parse(certRep.Raw)
+	require.NoError(t, err)
+	require.NoError(t, p7.Verify())
+	env, err := pkcs7.Parse(p7.Content)
+	require.NoError(t, err)
+	deg, err := env.Decrypt(c.cert, c.key)
+	require.NoError(t, err)
+	p7, err = pkcs7.Parse(deg)
+	require.NoError(t, err)
+	return p7
+}
+
+func TestAuthority_DecryptPKIEnvelope_queries(t *testing.T) {
+	a, ctx, ca := newTestAuthority(t, nil, nil)
+	client := newTestClient(t)
+
+	ias := IssuerAndSerialNumber{
+		Issuer:       asn1.RawValue{FullBytes: ca.Intermediate.RawSubject},
+		SerialNumber: big.NewInt(1234),
+	}
+	for _, msgType := range []smallscep.MessageType{smallscep.GetCert, smallscep.GetCRL} {
+		msg := client.message(t, msgType, "tx1", ias, ca.Intermediate)
+		require.NoError(t, a.DecryptPKIEnvelope(ctx, msg))
+		require.NotNil(t, msg.IssuerAndSerialNumber)
+		assert.Equal(t, ca.Intermediate.RawSubject, msg.IssuerAndSerialNumber.Issuer.FullBytes)
+		assert.Equal(t, big.NewInt(1234), msg.IssuerAndSerialNumber.SerialNumber)
+	}
+
+	msg := client.message(t, smallscep.CertPoll, "tx1", IssuerAndSubject{
+		Issuer:  asn1.RawValue{FullBytes: ca.Intermediate.RawSubject},
+		Subject: asn1.RawValue{FullBytes: client.csr.RawSubject},
+	}, ca.Intermediate)
+	require.NoError(t, a.DecryptPKIEnvelope(ctx, msg))
+	require.NotNil(t, msg.IssuerAndSubject)
+	assert.Equal(t, client.csr.RawSubject, msg.IssuerAndSubject.Subject.FullBytes)
+
+	// The content of a CertPoll message is not an issuer and serial number.
+	msg = client.message(t, smallscep.GetCert, "tx1", IssuerAndSubject{
+		Issuer:  asn1.RawValue{FullBytes: ca.Intermediate.RawSubject},
+		Subject: asn1.RawValue{FullBytes: client.csr.RawSubject},
+	}, ca.Intermediate)
+	assert.Error(t, a.DecryptPKIEnvelope(ctx, msg))
+}
+
+func TestAuthority_GetCert(t *testing.T) {
+	ca, err := minica.New()
+	require.NoError(t, err)
+	client := newTestClient(t)
+	issued, err := ca.Sign(&x509.Certificate{
+		Subject:   pkix.Name{CommonName: "router1"},
+		PublicKey: client.key.Public(),
+	})
+	require.NoError(t, err)
+
+	authDB := &db.MockAuthDB{
+		MGetCertificate: func(serialNumber string) (*x509.Certificate, error) {
+			if serialNumber != issued.SerialNumber.String() {
+				return nil, database.ErrNotFound
+			}
+			return issued, nil
+		},
+	}
+	a, ctx, _ := newTestAuthority(t, authDB, nil)
+
+	msg := client.message(t, smallscep.GetCert, "tx1", nil, client.cert)
+	msg.IssuerAndSerialNumber = &IssuerAndSerialNumber{
+		Issuer:       asn1.RawValue{FullBytes: issued.RawIssuer},
+		SerialNumber: issued.SerialNumber,
+	}
+	certRep, err := a.GetCert(ctx, msg)
+	require.NoError(t, err)
+	assert.Equal(t, issued, certRep.Certificate)
+	assert.Equal(t, []*x509.Certificate{issued}, client.decrypt(t, certRep).Certificates)
+
+	// Unknown serial number.
+	var fi *FailInfo
+	msg.IssuerAndSerialNumber.SerialNumber = big.NewInt(1)
+	_, err = a.GetCert(ctx, msg)
+	require.ErrorAs(t, err, &fi)
+	assert.Equal(t, FailInfoName(smallscep.BadCertID), fi.Name)
+
+	// Unknown issuer.
+	msg.IssuerAndSerialNumber.SerialNumber = issued.SerialNumber
+	msg.IssuerAndSerialNumber.Issuer = asn1.RawValue{FullBytes: issued.RawSubject}
+	_, err = a.GetCert(ctx, msg)
+	require.ErrorAs(t, err, &fi)
+	assert.Equal(t, FailInfoName(smallscep.BadCertID), fi.Name)
+
+	// Without a database.
+	a, ctx, _ = newTestAuthority(t, nil, nil)
+	_, err = a.GetCert(ctx, msg)
+	assert.Error(t, err)
+}
+
+func TestAuthority_GetCRL(t *testing.T) {
+	client := newTestClient(t)
+	var crl []byte
+	a, ctx, ca := newTestAuthority(t, nil, func() ([]byte, error) {
+		return crl, nil
+	})
+	var err error
+	crl, err = x509.CreateRevocationList(rand.Reader, &x509.RevocationList{
+		Number:     big.NewInt(1),
+		ThisUpdate: time.Now(),
+		NextUpdate: time.Now().Add(time.Hour),
+	}, ca.Intermediate, ca.Signer)
+	require.NoError(t, err)
+
+	msg := client.message(t, smallscep.GetCRL, "tx1", nil, client.cert)
+	msg.IssuerAndSerialNumber = &IssuerAndSerialNumber{
+		Issuer:       asn1.RawValue{FullBytes: ca.Intermediate.RawSubject},
+		SerialNumber: big.NewInt(1234),
+	}
+	certRep, err := a.GetCRL(ctx, msg)
+	require.NoError(t, err)
+	p7 := client.decrypt(t, certRep)
+	require.Len(t, p7.CRLs, 1)
+	assert.Empty(t, p7.Certificates)
+	got, err := x509.ParseRevocationList(crl)
+	require.NoError(t, err)
+	assert.Equal(t, got.Number, big.NewInt(1))
+
+	// The certificate was not issued by the CA.
+	var fi *FailInfo
+	msg.IssuerAndSerialNumber.Issuer = asn1.RawValue{FullBytes: client.cert.RawSubject}
+	_, err = a.GetCRL(ctx, msg)
+	require.ErrorAs(t, err, &fi)
+	assert.Equal(t, FailInfoName(smallscep.BadCertID), fi.Name)
+
+	// The CRL is not available.
+	a, ctx, ca = newTestAuthority(t, nil, nil)
+	msg.IssuerAndSerialNumber.Issuer = asn1.RawValue{FullBytes: ca.Intermediate.RawSubject}
+	_, err = a.GetCRL(ctx, msg)
+	require.ErrorAs(t, err, &fi)
+	assert.Equal(t, FailInfoName(smallscep.BadRequest), fi.Name)
+}
+
+// newRequestDB returns a database that keeps the SCEP requests and the
+// certificates in memory.
+func newRequestDB() *db.MockAuthDB {
+	requests := make(map[string]*db.SCEPRequest)
+	certs := make(map[string]*x509.Certificate)
+	return &db.MockAuthDB{
+		MCreateSCEPRequest: func(req *db.SCEPRequest) error {
+			if _, ok := requests[req.ProvisionerID+"/"+req.TransactionID]; ok {
+				return db.ErrAlreadyExists
+			}
+			requests[req.ProvisionerID+"/"+req.TransactionID] = req
+			return nil
+		},
+		MGetSCEPRequest: func(provisionerID, transactionID string) (*db.SCEPRequest, error) {
+			req, ok := requests[provisionerID+"/"+transactionID]
+			if !ok {
+				return nil, database.ErrNotFound
+			}
+			cp := *req
+			return &cp, nil
+		},
+		MUpdateSCEPRequest: func(old, req *db.SCEPRequest) error {
+			if cur, ok := requests[req.ProvisionerID+"/"+req.TransactionID]; !ok || !reflect.DeepEqual(cur, old) {
+				return db.ErrSCEPRequestChanged
+			}
+			cp := *req
+			requests[req.ProvisionerID+"/"+req.TransactionID] = &cp
+			return nil
+		},
+		MStoreCertificate: func(crt *x509.Certificate) error {
+			certs[crt.SerialNumber.String()] = crt
+			return nil
+		},
+		MGetCertificate: func(serialNumber string) (*x509.Certificate, error) {
+			crt, ok := certs[serialNumber]
+			if !ok {
+				return nil, database.ErrNotFound
+			}
+			return crt, nil
+		},
+	}
+}
+
+func TestAuthority_pendingRequests(t *testing.T) {
+	authDB := newRequestDB()
+	a, ctx, ca := newTestAuthority(t, authDB, nil)
+	client := newTestClient(t)
+	p := provisionerFromContext(ctx)
+
+	// The request is stored in the pending queue.
+	msg := client.message(t, smallscep.PKCSReq, "tx1", nil, client.cert)
+	certRep, err := a.CreatePendingRequest(ctx, client.csr, msg)
+	require.NoError(t, err)
+	assert.Equal(t, smallscep.PENDING, certRep.PKIStatus)
+	assert.Equal(t, smallscep.TransactionID("tx1"), certRep.TransactionID)
+	req, err := authDB.GetSCEPRequest(p.GetID(), "tx1")
+	require.NoError(t, err)
+	assert.Equal(t, db.SCEPRequestPending, req.Status)
+	assert.Equal(t, "router1", req.Subject)
+	assert.Equal(t, client.csr.Raw, req.CSR)
+
+	// Resending the request or polling while pending.
+	poll := client.message(t, smallscep.CertPoll, "tx1", nil, client.cert)
+	certRep, err = a.CreatePendingRequest(ctx, client.csr, msg)
+	require.NoError(t, err)
+	assert.Equal(t, smallscep.PENDING, certRep.PKIStatus)
+	certRep, err = a.CertPoll(ctx, poll)
+	require.NoError(t, err)
+	assert.Equal(t, smallscep.PENDING, certRep.PKIStatus)
+
+	// Polls must be signed with the key in the request.
+	var fi *FailInfo
+	_, err = a.CertPoll(ctx, newTestClient(t).message(t, smallscep.CertPoll, "tx1", nil, client.cert))
+	require.ErrorAs(t, err, &fi)
+	assert.Equal(t, FailInfoName(smallscep.BadMessageCheck), fi.Name)
+
+	// Unknown transaction.
+	_, err = a.CertPoll(ctx, client.message(t, smallscep.CertPoll, "tx2", nil, client.cert))
+	require.ErrorAs(t, err, &fi)
+	assert.Equal(t, FailInfoName(smallscep.BadCertID), fi.Name)
+
+	// Polls wait while another poll issues the certificate.
+	approved := *req
+	approved.Status = db.SCEPRequestApproved
+	require.NoError(t, authDB.UpdateSCEPRequest(req, &approved))
+	issuing := approved
+	issuing.Status = db.SCEPRequestIssued
+	require.NoError(t, authDB.UpdateSCEPRequest(&approved, &issuing))
+	certRep, err = a.CertPoll(ctx, poll)
+	require.NoError(t, err)
+	assert.Equal(t, smallscep.PENDING, certRep.PKIStatus)
+	require.NoError(t, authDB.UpdateSCEPRequest(&issuing, &approved))
+
+	// Once approved, the certificate is issued.
+	certRep, err = a.CertPoll(ctx, poll)
+	require.NoError(t, err)
+	require.NotNil(t, certRep.Certificate)
+	assert.Equal(t, "router1", certRep.Certificate.Subject.CommonName)
+	assert.Equal(t, ca.Intermediate.RawSubject, certRep.Certificate.RawIssuer)
+	assert.Equal(t, []*x509.Certificate{certRep.Certificate}, client.decrypt(t, certRep).Certificates)
+	req, err = authDB.GetSCEPRequest(p.GetID(), "tx1")
+	require.NoError(t, err)
+	assert.Equal(t, db.SCEPRequestIssued, req.Status)
+	assert.Equal(t, certRep.Certificate.SerialNumber.String(), req.SerialNumber)
+
+	// Following polls return the issued certificate.
+	issued := certRep.Certificate
+	require.NoError(t, authDB.StoreCertificate(issued))
+	certRep, err = a.CertPoll(ctx, poll)
+	require.NoError(t, err)
+	assert.Equal(t, issued, certRep.Certificate)
+
+	// Rejected requests fail.
+	msg = client.message(t, smallscep.PKCSReq, "tx3", nil, client.cert)
+	_, err = a.CreatePendingRequest(ctx, client.csr, msg)
+	require.NoError(t, err)
+	req, err = authDB.GetSCEPRequest(p.GetID(), "tx3")
+	require.NoError(t, err)
+	rejected := *req
+	rejected.Status = db.SCEPRequestRejected
+	rejected.Reason = "unknown device"
+	require.NoError(t, authDB.UpdateSCEPRequest(req, &rejected))
+	_, err = a.CertPoll(ctx, client.message(t, smallscep.CertPoll, "tx3", nil, client.cert))
+	require.ErrorAs(t, err, &fi)
+	assert.Equal(t, FailInfoName(smallscep.BadRequest), fi.Name)
+	assert.Equal(t, "request tx3 has been rejected: unknown device", fi.Text)
+
+	// The names bound to the challenge are enforced when the certificate is
+	// issued.
+	approve := func(transactionID string) {
+		t.Helper()
+		req, err := authDB.GetSCEPRequest(p.GetID(), transactionID)
+		require.NoError(t, err)
+		approved := *req
+		approved.Status = db.SCEPRequestApproved
+		require.NoError(t, authDB.UpdateSCEPRequest(req, &approved))
+	}
+	msg = client.message(t, smallscep.PKCSReq, "tx4", nil, client.cert)
+	_, err = a.CreatePendingRequest(ctx, client.csr, msg, &boundNamesValidator{commonName: "router1", sans: []string{"router1.example.com"}})
+	require.NoError(t, err)
+	req, err = authDB.GetSCEPRequest(p.GetID(), "tx4")
+	require.NoError(t, err)
+	assert.Equal(t, "router1", req.ChallengeSubject)
+	assert.Equal(t, []string{"router1.example.com"}, req.ChallengeSANs)
+	approve("tx4")
+	certRep, err = a.CertPoll(ctx, client.message(t, smallscep.CertPoll, "tx4", nil, client.cert))
+	require.NoError(t, err)
+	assert.Equal(t, "router1", certRep.Certificate.Subject.CommonName)
+
+	msg = client.message(t, smallscep.PKCSReq, "tx5", nil, client.cert)
+	_, err = a.CreatePendingRequest(ctx, client.csr, msg, &boundNamesValidator{commonName: "router2"})
+	require.NoError(t, err)
+	approve("tx5")
+	_, err = a.CertPoll(ctx, client.message(t, smallscep.CertPoll, "tx5", nil, client.cert))
+	assert.Error(t, err)
+	// Failed requests can be polled again.
+	req, err = authDB.GetSCEPRequest(p.GetID(), "tx5")
+	require.NoError(t, err)
+	assert.Equal(t, db.SCEPRequestApproved, req.Status)
+
+	// The database must support the pending requests.
+	a, ctx, _ = newTestAuthority(t, &db.SimpleDB{}, nil)
+	_, err = a.CreatePendingRequest(ctx, client.csr, msg)
+	assert.Error(t, err)
+}
+
+func TestAuthority_pendingRequests_webhookData(t *testing.T) {
+	ca, err := minica.New(minica.WithGetSignerFunc(func() (crypto.Signer, error) {
+		return rsa.GenerateKey(rand.Reader, 2048)
+	}))
+	require.NoError(t, err)
+	authDB := newRequestDB()
+	a, err := New(&signAuthority{
+		ca: ca,
+		template: `{
+{{- with .Webhooks.ScepChallenge.CommonName }}
+	"subject": {"commonName" : {{ . | toJson }}},
+{{- else }}
+	"subject": {{ toJson .Subject }},
+{{- end }}
+	"sans": {{ toJson .SANs }}
+}`,
+	}, Options{
+		Roots:                []*x509.Certificate{ca.Root},
+		Intermediates:        []*x509.Certificate{ca.Intermediate},
+		SignerCert:           ca.Intermediate,
+		Signer:               ca.Signer,
+		Decrypter:            ca.Signer.(*rsa.PrivateKey),
+		DecrypterCert:        ca.Intermediate,
+		SCEPProvisionerNames: []string{"scep"},
+		DB:                   authDB,
+	})
+	require.NoError(t, err)
+	p, err := a.LoadProvisionerByName("scep")
+	require.NoError(t, err)
+	ctx := NewProvisionerContext(context.Background(), p.(*provisioner.SCEP))
+	client := newTestClient(t)
+
+	// The data returned by the webhook that left the request pending is
+	// stored with it.
+	msg := client.message(t, smallscep.PKCSReq, "tx1", nil, client.cert)
+	_, err = a.CreatePendingRequest(ctx, client.csr, msg, provisioner.TemplateDataModifierFunc(func(data x509util.TemplateData) {
+		data.SetWebhook("ScepChallenge", map[string]any{"CommonName": "approved-router1"})
+	}))
+	require.NoError(t, err)
+	req, err := authDB.GetSCEPRequest(p.GetID(), "tx1")
+	require.NoError(t, err)
+	assert.Equal(t, map[string]any{
+		"ScepChallenge": map[string]any{"CommonName": "approved-router1"},
+	}, req.WebhookData)
+
+	// And it's available to the template once the request is approved.
+	approved := *req
+	approved.Status = db.SCEPRequestApproved
+	require.NoError(t, authDB.UpdateSCEPRequest(req, &approved))
+	certRep, err := a.CertPoll(ctx, client.message(t, smallscep.CertPoll, "tx1", nil, client.cert))
+	require.NoError(t, err)
+	require.NotNil(t, certRep.Certificate)
+	assert.Equal(t, "approved-router1", certRep.Certificate.Subject.CommonName)
+	assert.Equal(t, []string{"router1.example.com"}, certRep.Certificate.DNSNames)
+}
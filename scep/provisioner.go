@@ -19,7 +19,10 @@ type Provisioner interface {
 	ShouldIncludeIntermediateInChain() bool
 	GetDecrypter() (*x509.Certificate, crypto.Decrypter)
 	GetSigner() (*x509.Certificate, crypto.Signer)
+	GetNextCACertificates() []*x509.Certificate
 	GetContentEncryptionAlgorithm() int
+	ShouldUseDynamicChallenges() bool
+	ShouldRequireApproval() bool
 	ValidateChallenge(ctx context.Context, csr *x509.CertificateRequest, challenge, transactionID string) ([]provisioner.SignCSROption, error)
 	NotifySuccess(ctx context.Context, csr *x509.CertificateRequest, cert *x509.Certificate, transactionID string) error
 	NotifyFailure(ctx context.Context, csr *x509.CertificateRequest, transactionID string, errorCode int, errorDescription string) error
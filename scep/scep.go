@@ -4,6 +4,8 @@ package scep
 import (
 	"crypto/x509"
 	"encoding/asn1"
+	"fmt"
+	"math/big"
 
 	"github.com/smallstep/pkcs7"
 	smallscep "github.com/smallstep/scep"
@@ -30,6 +32,19 @@ type FailInfo struct {
 	Text string
 }
 
+// Error implements the error interface, so a FailInfo can be
+// returned when a request has to be answered with a failure.
+func (f *FailInfo) Error() string {
+	return f.Text
+}
+
+func newFailInfo(name smallscep.FailInfo, format string, args ...any) *FailInfo {
+	return &FailInfo{
+		Name: FailInfoName(name),
+		Text: fmt.Sprintf(format, args...),
+	}
+}
+
 // SCEP OIDs
 var (
 	oidSCEPmessageType    = asn1.ObjectIdentifier{2, 16, 840, 1, 113733, 1, 9, 2}
@@ -51,6 +66,12 @@ type PKIMessage struct {
 
 	*CertRepMessage
 
+	// IssuerAndSubject is the content of a CertPoll message.
+	IssuerAndSubject *IssuerAndSubject
+
+	// IssuerAndSerialNumber is the content of GetCert and GetCRL messages.
+	IssuerAndSerialNumber *IssuerAndSerialNumber
+
 	// DER Encoded PKIMessage
 	Raw []byte
 
@@ -74,3 +95,17 @@ type CertRepMessage struct {
 
 	degenerate []byte
 }
+
+// IssuerAndSubject identifies a pending certificate request in a CertPoll
+// message, RFC 8894 section 3.3.3.
+type IssuerAndSubject struct {
+	Issuer  asn1.RawValue
+	Subject asn1.RawValue
+}
+
+// IssuerAndSerialNumber identifies a certificate in GetCert and GetCRL
+// messages, RFC 8894 section 3.3.4.
+type IssuerAndSerialNumber struct {
+	Issuer       asn1.RawValue
+	SerialNumber *big.Int
+}
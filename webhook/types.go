@@ -13,6 +13,9 @@ type ResponseBody struct {
 	Data  any    `json:"data"`
 	Allow bool   `json:"allow"`
 	Error *Error `json:"error,omitempty"`
+	// Pending is used by SCEPCHALLENGE webhooks to accept a challenge while
+	// keeping the request in the pending queue until it's approved.
+	Pending bool `json:"pending,omitempty"`
 }
 
 // Error provides details explaining why the webhook was not permitted.
@@ -67,6 +70,28 @@ type SSHCertificate struct {
 // AttestationData is data validated by acme device-attest-01 challenge
 type AttestationData struct {
 	PermanentIdentifier string `json:"permanentIdentifier"`
+	// Only set if the EK certificate of a TPM was validated
+	TPMEKValidation *TPMEKValidation `json:"tpmEKValidation,omitempty"`
+	// Only set on the android-key attestation format
+	AndroidDevice *AndroidDevice `json:"androidDevice,omitempty"`
+}
+
+// TPMEKValidation contains the decisions taken validating the EK certificate
+// chain of a TPM in an acme device-attest-01 challenge.
+type TPMEKValidation struct {
+	EKKeyID                  string   `json:"ekKeyID"`
+	Chain                    []string `json:"chain"`
+	RevocationChecked        bool     `json:"revocationChecked"`
+	PermanentIdentifierBound bool     `json:"permanentIdentifierBound"`
+}
+
+// AndroidDevice contains the properties of an Android device attested by the
+// hardware in an acme device-attest-01 challenge.
+type AndroidDevice struct {
+	Brand             string   `json:"brand,omitempty"`
+	Manufacturer      string   `json:"manufacturer,omitempty"`
+	Model             string   `json:"model,omitempty"`
+	DeviceIdentifiers []string `json:"deviceIdentifiers,omitempty"`
 }
 
 // X5CCertificate is the authorization certificate sent to webhook servers for
@@ -98,6 +123,9 @@ type RequestBody struct {
 	SCEPTransactionID    string `json:"scepTransactionID,omitempty"`
 	SCEPErrorCode        int    `json:"scepErrorCode,omitempty"`
 	SCEPErrorDescription string `json:"scepErrorDescription,omitempty"`
+	// Only set for EST webhook requests
+	ESTUsername string `json:"estUsername,omitempty"`
+	ESTPassword string `json:"estPassword,omitempty"`
 	// Only set for X5C provisioners
 	X5CCertificate *X5CCertificate `json:"x5cCertificate,omitempty"`
 	// Set for X5C, AWS, GCP, and Azure provisioners